@@ -180,6 +180,7 @@ func (a *Agent) clientConfig() (*uconf.ClientConfig, error) {
 	}
 	if a.config.DataDir != "" {
 		conf.StateDir = filepath.Join(a.config.DataDir, "agent")
+		conf.AllocDir = filepath.Join(a.config.DataDir, "alloc")
 	}
 	conf.Servers = a.config.Client.Servers
 
@@ -191,6 +192,11 @@ func (a *Agent) clientConfig() (*uconf.ClientConfig, error) {
 	// Set up the HTTP advertise address
 	conf.Node.HTTPAddr = fmt.Sprintf("%s:%d", a.config.BindAddr, a.config.Ports.HTTP) //a.config.AdvertiseAddrs.HTTP
 	conf.Node.NatsAddr = a.config.AdvertiseAddrs.Nats
+	conf.Node.NodeClass = a.config.Client.NodeClass
+	conf.Node.SchedulingEligibility = umodel.NodeSchedulingEligible
+	if a.config.Client.SchedulingIneligible {
+		conf.Node.SchedulingEligibility = umodel.NodeSchedulingIneligible
+	}
 
 	conf.Version = a.config.Version
 
@@ -201,11 +207,54 @@ func (a *Agent) clientConfig() (*uconf.ClientConfig, error) {
 	conf.ConsulConfig = a.config.Consul
 	conf.NatsAddr = a.config.AdvertiseAddrs.Nats
 	conf.MaxPayload = a.config.Network.MaxPayload
+	conf.NatsAuth = &uconf.NatsAuthConfig{
+		Token:    a.config.Network.NatsToken,
+		User:     a.config.Network.NatsUser,
+		Password: a.config.Network.NatsPassword,
+		Nkey:     a.config.Network.NatsNkey,
+	}
 	conf.StatsCollectionInterval = a.config.Metric.collectionInterval
 	conf.PublishNodeMetrics = a.config.Metric.PublishNodeMetrics
 	conf.PublishAllocationMetrics = a.config.Metric.PublishAllocationMetrics
+	conf.AllocationMetricsMetaLabels = a.config.Metric.MetaLabels
+	conf.ApplyLatencyExemplars = a.config.Metric.OpenMetricsExemplars
 
 	conf.NoHostUUID = a.config.Client.NoHostUUID
+	if a.config.Client.AllocUpdatesBufferSize > 0 {
+		conf.AllocUpdatesBufferSize = a.config.Client.AllocUpdatesBufferSize
+	}
+	if a.config.Client.AllocShutdownTimeout > 0 {
+		conf.AllocShutdownTimeout = a.config.Client.AllocShutdownTimeout
+	}
+	if a.config.Client.StateSnapshotInterval > 0 {
+		conf.StateSnapshotInterval = a.config.Client.StateSnapshotInterval
+	}
+	if a.config.Client.StateSnapshotTxDelta > 0 {
+		conf.StateSnapshotTxDelta = a.config.Client.StateSnapshotTxDelta
+	}
+	if a.config.Client.StateSnapshotsRetained > 0 {
+		conf.StateSnapshotsRetained = a.config.Client.StateSnapshotsRetained
+	}
+	conf.AuxDiskBudget = a.config.Client.AuxDiskBudget
+	conf.AuxDiskPolicy = a.config.Client.AuxDiskPolicy
+	conf.DriverSetupParallelism = a.config.Client.DriverSetupParallelism
+	conf.DriverSetupTimeout = a.config.Client.DriverSetupTimeout
+	conf.RefuseUnsupportedAllocs = a.config.Client.RefuseUnsupportedAllocs
+	conf.DisableConsulFallback = a.config.Client.DisableConsulFallback
+	conf.ConsulFallbackAfter = a.config.Client.ConsulFallbackAfter
+	conf.HeartbeatGraceFactor = a.config.Client.HeartbeatGraceFactor
+	conf.ExpectedTasks = a.config.Client.ExpectedTasks
+	conf.OpenFilesPolicy = a.config.Client.OpenFilesPolicy
+	conf.StateDirMode = a.config.Client.StateDirMode
+	conf.AllocDirMode = a.config.Client.AllocDirMode
+	conf.AllocDirOwner = a.config.Client.AllocDirOwner
+	conf.DNSPrefer = a.config.Client.DNSPrefer
+	conf.AllocReconcileThreshold = a.config.Client.AllocReconcileThreshold
+	conf.TaskOutputLogLevel = a.config.Client.TaskOutputLogLevel
+	conf.SelfMonitorWindow = a.config.Client.SelfMonitorWindow
+	conf.SelfMonitorGoroutines = a.config.Client.SelfMonitorGoroutines
+	conf.SelfMonitorHeapMB = a.config.Client.SelfMonitorHeapMB
+	conf.SelfMonitorHeapProfileMB = a.config.Client.SelfMonitorHeapProfileMB
 
 	return conf, nil
 }
@@ -9,9 +9,11 @@ package agent
 import (
 	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/hashicorp/serf/serf"
 
+	"github.com/actiontech/dtle/internal/faults"
 	umodel "github.com/actiontech/dtle/internal/models"
 )
 
@@ -123,6 +125,102 @@ func (s *HTTPServer) AgentForceLeaveRequest(resp http.ResponseWriter, req *http.
 	return nil, err
 }
 
+// AgentEligibilityRequest is used to query or change whether allocations can be
+// placed on the node of the client. The node is registered again with the change.
+func (s *HTTPServer) AgentEligibilityRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	client := s.agent.Client()
+	if client == nil {
+		return nil, CodedError(501, ErrInvalidMethod)
+	}
+
+	switch req.Method {
+	case "PUT", "POST":
+		eligible, err := strconv.ParseBool(req.URL.Query().Get("eligible"))
+		if err != nil {
+			return nil, CodedError(400, "eligible must be true or false")
+		}
+		client.SetSchedulingEligibility(eligible)
+	case "GET":
+	default:
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	return map[string]string{"SchedulingEligibility": client.SchedulingEligibility()}, nil
+}
+
+// AgentAttrDiffsRequest returns the last changes of the attributes of the node.
+func (s *HTTPServer) AgentAttrDiffsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	client := s.agent.Client()
+	if client == nil {
+		return nil, CodedError(501, ErrInvalidMethod)
+	}
+	return client.AttrDiffs(), nil
+}
+
+// AgentReconcileRequest compares the status wanted by the servers for each
+// allocation of the node with the status of the client.
+func (s *HTTPServer) AgentReconcileRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	client := s.agent.Client()
+	if client == nil {
+		return nil, CodedError(501, ErrInvalidMethod)
+	}
+	return client.ReconcileReport(), nil
+}
+
+// AgentHealthRequest reports whether the client heartbeats the servers in time,
+// see Client.HeartbeatHealthy, with status 500 if not.
+func (s *HTTPServer) AgentHealthRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	client := s.agent.Client()
+	if client == nil {
+		return nil, CodedError(501, ErrInvalidMethod)
+	}
+
+	health := agentHealth{Client: &healthResponse{Ok: true, Message: "ok"}}
+	if !client.HeartbeatHealthy() {
+		health.Client = &healthResponse{Message: "no heartbeat to the managers within the heartbeat TTL"}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(500)
+	}
+	return health, nil
+}
+
+// AgentFaultsRequest lists the faults injected into the client, or sets the
+// fault of the point of the query to the one of the body, or clears it.
+func (s *HTTPServer) AgentFaultsRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	injector := faults.Active()
+	if injector == nil {
+		return nil, CodedError(501, ErrInvalidMethod)
+	}
+
+	point := req.URL.Query().Get("point")
+	switch req.Method {
+	case "PUT", "POST":
+		var fault faults.Fault
+		if err := decodeBody(req, &fault); err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+		if err := injector.Set(point, fault); err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+	case "DELETE":
+		if err := injector.Clear(point); err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+	case "GET":
+	default:
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	return injector.List(), nil
+}
+
 // AgentServersRequest is used to query the list of servers used by the Udup
 // Client for RPCs.  This endpoint can also be used to update the list of
 // servers for a given agent.
@@ -175,6 +273,15 @@ type agentSelf struct {
 	Stats  map[string]map[string]string `json:"stats"`
 }
 
+type agentHealth struct {
+	Client *healthResponse `json:"client,omitempty"`
+}
+
+type healthResponse struct {
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
 type joinResult struct {
 	NumJoined int    `json:"num_joined"`
 	Error     string `json:"error"`
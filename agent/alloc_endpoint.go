@@ -9,8 +9,10 @@ package agent
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/actiontech/dtle/internal/client/allocdir"
 	umodel "github.com/actiontech/dtle/internal/models"
 )
 
@@ -94,12 +96,27 @@ func (s *HTTPServer) ClientAllocRequest(resp http.ResponseWriter, req *http.Requ
 	switch tokens[1] {
 	case "stats":
 		return s.allocStats(allocID, resp, req)
+	case "layout":
+		return s.allocLayout(allocID, resp, req)
+	case "files":
+		return s.allocFiles(allocID, resp, req)
+	case "stop":
+		return s.allocStop(allocID, resp, req)
+	case "workers":
+		return s.allocWorkers(allocID, resp, req)
+	case "quiesce":
+		return s.allocQuiesce(allocID, resp, req)
+	case "config":
+		return s.allocConfig(allocID, resp, req)
 	}
 
 	return nil, CodedError(404, resourceNotFoundErr)
 }
 
 func (s *HTTPServer) allocStats(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method == "PUT" || req.Method == "POST" {
+		return s.allocStatsReset(allocID, resp, req)
+	}
 	clientStats := s.agent.client.StatsReporter()
 	aStats, err := clientStats.GetAllocStats(allocID)
 	if err != nil {
@@ -109,3 +126,95 @@ func (s *HTTPServer) allocStats(allocID string, resp http.ResponseWriter, req *h
 	task := req.URL.Query().Get("task")
 	return aStats.LatestAllocStats(task)
 }
+
+// allocStatsReset resets the counters of the tasks of the allocation, and returns them as they
+// were. The reset is recorded with the "by" of the query, or the address of the caller. See
+// Client.ResetAllocStats.
+func (s *HTTPServer) allocStatsReset(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	by := req.URL.Query().Get("by")
+	if by == "" {
+		by = req.RemoteAddr
+	}
+	return s.agent.client.ResetAllocStats(allocID, req.URL.Query().Get("task"), by)
+}
+
+// allocStop stops the allocation gracefully, keeping its state. See Client.StopAlloc.
+func (s *HTTPServer) allocStop(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	if err := s.agent.client.StopAlloc(allocID); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// allocWorkers resizes the pool of workers of the applier to the count in the
+// query. See Client.SetApplierWorkers.
+func (s *HTTPServer) allocWorkers(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	n, err := strconv.Atoi(req.URL.Query().Get("count"))
+	if err != nil {
+		return nil, CodedError(400, fmt.Sprintf("bad count: %v", err))
+	}
+	if err := s.agent.client.SetApplierWorkers(allocID, n); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// allocQuiesce is the cutover of the allocation to the target, setting the source
+// read-only if source_read_only is true in the query. See Client.Quiesce.
+func (s *HTTPServer) allocQuiesce(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	readOnly := false
+	if v := req.URL.Query().Get("source_read_only"); v != "" {
+		var err error
+		if readOnly, err = strconv.ParseBool(v); err != nil {
+			return nil, CodedError(400, fmt.Sprintf("bad source_read_only: %v", err))
+		}
+	}
+	final, err := s.agent.client.Quiesce(allocID, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &umodel.QuiesceResponse{Gtid: final}, nil
+}
+
+// allocConfig returns the configs the tasks of the allocation are running with. See
+// Client.AllocConfig.
+func (s *HTTPServer) allocConfig(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	return s.agent.client.AllocConfig(allocID)
+}
+
+// allocLayout returns the dirs of the tasks of the allocation, relative to its alloc dir.
+func (s *HTTPServer) allocLayout(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	fs, err := s.agent.client.GetAllocFS(allocID)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Layout(), nil
+}
+
+// allocFiles lists the dir at the path, relative to the alloc dir, or returns the file at the path.
+func (s *HTTPServer) allocFiles(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	fs, err := s.agent.client.GetAllocFS(allocID)
+	if err != nil {
+		return nil, err
+	}
+	path := req.URL.Query().Get("path")
+	info, err := fs.Stat(path)
+	if err == allocdir.ErrNotBrowsable {
+		return nil, CodedError(403, err.Error())
+	} else if err != nil {
+		return nil, CodedError(404, err.Error())
+	}
+	if !info.IsDir {
+		return []*allocdir.AllocFileInfo{info}, nil
+	}
+	return fs.List(path)
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 	"net/http"
 
+	"github.com/actiontech/dtle/internal/faults"
 	"github.com/actiontech/dtle/internal/g"
 
 	"github.com/armon/go-metrics"
@@ -297,6 +298,10 @@ func (c *Command) Run(args []string) int {
 
 	// set global value
 	g.DtleSchemaName = config.DtleSchemaName
+	if config.FaultInjection {
+		faults.Enable()
+		c.logger.Warnf("Fault injection is enabled. Do not use it in production")
+	}
 
 	// Initialize the metric
 	if err := c.setupMetric(config); err != nil {
@@ -342,11 +347,10 @@ func (c *Command) Run(args []string) int {
 	padding := 18
 	c.logger.Printf("Dtle server configuration:\n")
 	for _, k := range infoKeys {
-		c.logger.Printf(fmt.Sprintf(
-			" %s%s: %s",
+		c.logger.Printf(" %s%s: %s",
 			strings.Repeat(" ", padding-len(k)),
 			strings.Title(k),
-			info[k]))
+			info[k])
 	}
 	// Output the header that the server has started
 	c.logger.Printf("Dtle server started! Log data will stream in below:\n")
@@ -441,6 +445,12 @@ func (c *Command) handleReload(config *Config) *Config {
 		}
 	}
 
+	if c.httpServer != nil {
+		if err := c.httpServer.SetLimits(newConf.HTTPLimits); err != nil {
+			c.logger.Errorf("http: failed to apply http_limits: %v", err)
+		}
+	}
+
 	return newConf
 }
 
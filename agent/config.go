@@ -83,6 +83,9 @@ type Config struct {
 
 	Network *Network `mapstructure:"network"`
 
+	// HTTPLimits are the rate limits and the access log of the HTTP API.
+	HTTPLimits *HTTPLimits `mapstructure:"http_limits"`
+
 	// Profile is used to select a timing profile for Serf. The supported choices
 	// are "wan", "lan", and "local". The default is "lan"
 	Profile string `mapstructure:"profile"`
@@ -129,6 +132,10 @@ type Config struct {
 	// Do not use special characters (which need to be quoted) in schema name.
 	DtleSchemaName string `mapstructure:"dtle_schema_name"`
 
+	// FaultInjection enables the injection of failures into the client, set at
+	// /v1/agent/faults. For testing only.
+	FaultInjection bool `mapstructure:"fault_injection"`
+
 	// CoverageReportPort is the HTTP port of code coverage report, 0 is disable
 	CoverageReportPort int `mapstructure:"coverage_report_port"`
 
@@ -150,6 +157,105 @@ type ClientConfig struct {
 	// NoHostUUID disables using the host's UUID and will force generation of a
 	// random UUID.
 	NoHostUUID bool `mapstructure:"no_host_uuid"`
+
+	// AllocUpdatesBufferSize is the capacity of the queue of allocation
+	// status updates waiting to be synced to the managers.
+	AllocUpdatesBufferSize int `mapstructure:"alloc_updates_buffer_size"`
+
+	// AllocShutdownTimeout is how long the tasks of an allocation are waited
+	// to stop on destroy, before they are torn down forcibly.
+	AllocShutdownTimeout time.Duration `mapstructure:"alloc_shutdown_timeout"`
+
+	// StateSnapshotInterval is how often the changed allocations are
+	// snapshotted to the state dir.
+	StateSnapshotInterval time.Duration `mapstructure:"state_snapshot_interval"`
+
+	// StateSnapshotTxDelta is how many transactions a task replicates
+	// before its allocation is considered changed. If set, it is also
+	// snapshotted then without waiting for StateSnapshotInterval.
+	StateSnapshotTxDelta int64 `mapstructure:"state_snapshot_tx_delta"`
+
+	// StateSnapshotsRetained is how many snapshots of an allocation are
+	// kept.
+	StateSnapshotsRetained int `mapstructure:"state_snapshots_retained"`
+
+	// AuxDiskBudget is the max bytes of the auxiliary files (spill,
+	// dead-letter and audit files) of all the tasks. 0 is unlimited.
+	AuxDiskBudget int64 `mapstructure:"aux_disk_budget"`
+
+	// AuxDiskPolicy is what to do when AuxDiskBudget is hit.
+	AuxDiskPolicy string `mapstructure:"aux_disk_policy"`
+
+	// NodeClass is the role of the node, sent to the managers at registration.
+	NodeClass string `mapstructure:"node_class"`
+
+	// SchedulingIneligible makes the managers place no allocations on the
+	// node, e.g. for a node only exposing stats and relaying nats messages.
+	SchedulingIneligible bool `mapstructure:"scheduling_ineligible"`
+
+	// DriverSetupParallelism is how many drivers are set up at once at start.
+	DriverSetupParallelism int `mapstructure:"driver_setup_parallelism"`
+
+	// DriverSetupTimeout is how long a driver is waited to be set up at
+	// start, before it is skipped.
+	DriverSetupTimeout time.Duration `mapstructure:"driver_setup_timeout"`
+
+	// RefuseUnsupportedAllocs makes the client fail the allocations whose
+	// task needs a driver or a feature the node lacks.
+	RefuseUnsupportedAllocs bool `mapstructure:"refuse_unsupported_allocs"`
+
+	// DisableConsulFallback disables looking for the managers in Consul,
+	// for the deployments without Consul.
+	DisableConsulFallback bool `mapstructure:"disable_consul_fallback"`
+
+	// ConsulFallbackAfter is how long the managers report no leader before
+	// the managers are looked for in Consul.
+	ConsulFallbackAfter time.Duration `mapstructure:"consul_fallback_after"`
+
+	// HeartbeatGraceFactor stretches the heartbeat TTL within which the last
+	// heartbeat is taken as healthy.
+	HeartbeatGraceFactor float64 `mapstructure:"heartbeat_grace_factor"`
+
+	// ExpectedTasks is how many tasks the node is expected to run at once,
+	// from which the least limit of open files is derived.
+	ExpectedTasks int `mapstructure:"expected_tasks"`
+
+	// OpenFilesPolicy is what to do at start when the limit of open files is
+	// too low for ExpectedTasks.
+	OpenFilesPolicy string `mapstructure:"open_files_policy"`
+
+	// StateDirMode is the octal mode of the state dir.
+	StateDirMode string `mapstructure:"state_dir_mode"`
+
+	// AllocDirMode is the octal mode of the dirs in the alloc dir.
+	AllocDirMode string `mapstructure:"alloc_dir_mode"`
+
+	// AllocDirOwner is the owner of the dirs in the alloc dir, "<uid>:<gid>".
+	AllocDirOwner string `mapstructure:"alloc_dir_owner"`
+
+	// DNSPrefer is the address family tried first: "ipv4" or "ipv6".
+	DNSPrefer string `mapstructure:"dns_prefer"`
+
+	// AllocReconcileThreshold is how long the status of an allocation may
+	// not match that wanted by the managers before it is re-driven.
+	AllocReconcileThreshold time.Duration `mapstructure:"alloc_reconcile_threshold"`
+
+	// TaskOutputLogLevel is the level at which the output of the tasks is
+	// forwarded to the client log. Empty for none.
+	TaskOutputLogLevel string `mapstructure:"task_output_log_level"`
+
+	// SelfMonitorWindow is the window over which the growth of the
+	// goroutines and the heap of the agent is judged.
+	SelfMonitorWindow time.Duration `mapstructure:"self_monitor_window"`
+
+	// SelfMonitorGoroutines and SelfMonitorHeapMB are the goroutines and the
+	// heap in use above which a growth over the window is warned of. 0 for off.
+	SelfMonitorGoroutines int `mapstructure:"self_monitor_goroutines"`
+	SelfMonitorHeapMB     int `mapstructure:"self_monitor_heap_mb"`
+
+	// SelfMonitorHeapProfileMB is the heap in use above which a heap profile
+	// is written, at most once an hour. 0 for off.
+	SelfMonitorHeapProfileMB int `mapstructure:"self_monitor_heap_profile_mb"`
 }
 
 // ServerConfig is configuration specific to the server mode
@@ -199,6 +305,40 @@ type Network struct {
 	// MAX_PAYLOAD is the maximum allowed payload size. Should be using
 	// something different if > 1MB payloads are needed.
 	MaxPayload int `mapstructure:"max_payload"`
+
+	// Authentication of the embedded nats server and the connections to it.
+	// It must be the same on all the agents.
+	NatsToken    string `mapstructure:"nats_token"`
+	NatsUser     string `mapstructure:"nats_user"`
+	NatsPassword string `mapstructure:"nats_password"`
+	NatsNkey     string `mapstructure:"nats_nkey"`
+}
+
+// HTTPLimits are the token bucket rate limits of the HTTP API, per remote IP
+// and per endpoint. A request over a limit is answered 429 with Retry-After.
+// They are applied again on a reload, without dropping the requests in flight.
+type HTTPLimits struct {
+	// PerIP is the rate (requests per second) of a remote IP over all the
+	// endpoints, with bursts of PerIPBurst. 0 for no limit.
+	PerIP      float64 `mapstructure:"per_ip"`
+	PerIPBurst int     `mapstructure:"per_ip_burst"`
+
+	// PerEndpoint is the rate of an endpoint (e.g. /v1/job/) over all the
+	// remote IPs, with bursts of PerEndpointBurst. 0 for no limit.
+	PerEndpoint      float64 `mapstructure:"per_endpoint"`
+	PerEndpointBurst int     `mapstructure:"per_endpoint_burst"`
+
+	// Internal are the addresses (IPs or CIDRs) of the internal callers, e.g.
+	// the managers, which are limited per IP by InternalRate instead, and not
+	// per endpoint. The managers the agent knows are internal. InternalRate 0
+	// exempts them.
+	Internal      []string `mapstructure:"internal"`
+	InternalRate  float64  `mapstructure:"internal_rate"`
+	InternalBurst int      `mapstructure:"internal_burst"`
+
+	// AccessLogLevel is the level of the access log of the requests: DEBUG
+	// (default), INFO, or OFF.
+	AccessLogLevel string `mapstructure:"access_log_level"`
 }
 
 type Metric struct {
@@ -208,6 +348,16 @@ type Metric struct {
 	collectionInterval       time.Duration `mapstructure:"-"`
 	PublishAllocationMetrics bool          `mapstructure:"publish_allocation_metrics"`
 	PublishNodeMetrics       bool          `mapstructure:"publish_node_metrics"`
+
+	// MetaLabels are the keys of the meta of the jobs which label the
+	// allocation metrics. The other keys are not, to bound the cardinality.
+	MetaLabels []string `mapstructure:"meta_labels"`
+
+	// OpenMetricsExemplars keeps the apply latency of the tables of the
+	// tasks, exposed in the OpenMetrics format of /metrics with the GTID of
+	// the slowest transaction of each bucket. Off by default, as it costs
+	// memory by table.
+	OpenMetricsExemplars bool `mapstructure:"openmetrics_exemplars"`
 }
 
 // Ports encapsulates the various ports we bind to for network services. If any
@@ -393,6 +543,14 @@ func (c *Config) Merge(b *Config) *Config {
 		result.Network = result.Network.Merge(b.Network)
 	}
 
+	// Apply the http limits
+	if result.HTTPLimits == nil && b.HTTPLimits != nil {
+		limits := *b.HTTPLimits
+		result.HTTPLimits = &limits
+	} else if b.HTTPLimits != nil {
+		result.HTTPLimits = result.HTTPLimits.Merge(b.HTTPLimits)
+	}
+
 	// Apply the client config
 	if result.Client == nil && b.Client != nil {
 		client := *b.Client
@@ -454,6 +612,9 @@ func (c *Config) Merge(b *Config) *Config {
 	if b.DtleSchemaName != "" {
 		result.DtleSchemaName = b.DtleSchemaName
 	}
+	if b.FaultInjection {
+		result.FaultInjection = true
+	}
 
 	return &result
 }
@@ -602,6 +763,87 @@ func (a *ClientConfig) Merge(b *ClientConfig) *ClientConfig {
 	if b.NoHostUUID {
 		result.NoHostUUID = b.NoHostUUID
 	}
+	if b.AllocUpdatesBufferSize != 0 {
+		result.AllocUpdatesBufferSize = b.AllocUpdatesBufferSize
+	}
+	if b.AllocShutdownTimeout != 0 {
+		result.AllocShutdownTimeout = b.AllocShutdownTimeout
+	}
+	if b.StateSnapshotInterval != 0 {
+		result.StateSnapshotInterval = b.StateSnapshotInterval
+	}
+	if b.StateSnapshotTxDelta != 0 {
+		result.StateSnapshotTxDelta = b.StateSnapshotTxDelta
+	}
+	if b.StateSnapshotsRetained != 0 {
+		result.StateSnapshotsRetained = b.StateSnapshotsRetained
+	}
+	if b.AuxDiskBudget != 0 {
+		result.AuxDiskBudget = b.AuxDiskBudget
+	}
+	if b.AuxDiskPolicy != "" {
+		result.AuxDiskPolicy = b.AuxDiskPolicy
+	}
+	if b.NodeClass != "" {
+		result.NodeClass = b.NodeClass
+	}
+	if b.SchedulingIneligible {
+		result.SchedulingIneligible = true
+	}
+	if b.DriverSetupParallelism != 0 {
+		result.DriverSetupParallelism = b.DriverSetupParallelism
+	}
+	if b.DriverSetupTimeout != 0 {
+		result.DriverSetupTimeout = b.DriverSetupTimeout
+	}
+	if b.RefuseUnsupportedAllocs {
+		result.RefuseUnsupportedAllocs = true
+	}
+	if b.DisableConsulFallback {
+		result.DisableConsulFallback = true
+	}
+	if b.ConsulFallbackAfter != 0 {
+		result.ConsulFallbackAfter = b.ConsulFallbackAfter
+	}
+	if b.HeartbeatGraceFactor != 0 {
+		result.HeartbeatGraceFactor = b.HeartbeatGraceFactor
+	}
+	if b.ExpectedTasks != 0 {
+		result.ExpectedTasks = b.ExpectedTasks
+	}
+	if b.OpenFilesPolicy != "" {
+		result.OpenFilesPolicy = b.OpenFilesPolicy
+	}
+	if b.StateDirMode != "" {
+		result.StateDirMode = b.StateDirMode
+	}
+	if b.AllocDirMode != "" {
+		result.AllocDirMode = b.AllocDirMode
+	}
+	if b.AllocDirOwner != "" {
+		result.AllocDirOwner = b.AllocDirOwner
+	}
+	if b.DNSPrefer != "" {
+		result.DNSPrefer = b.DNSPrefer
+	}
+	if b.AllocReconcileThreshold != 0 {
+		result.AllocReconcileThreshold = b.AllocReconcileThreshold
+	}
+	if b.TaskOutputLogLevel != "" {
+		result.TaskOutputLogLevel = b.TaskOutputLogLevel
+	}
+	if b.SelfMonitorWindow != 0 {
+		result.SelfMonitorWindow = b.SelfMonitorWindow
+	}
+	if b.SelfMonitorGoroutines != 0 {
+		result.SelfMonitorGoroutines = b.SelfMonitorGoroutines
+	}
+	if b.SelfMonitorHeapMB != 0 {
+		result.SelfMonitorHeapMB = b.SelfMonitorHeapMB
+	}
+	if b.SelfMonitorHeapProfileMB != 0 {
+		result.SelfMonitorHeapProfileMB = b.SelfMonitorHeapProfileMB
+	}
 
 	// Add the servers
 	result.Servers = append(result.Servers, b.Servers...)
@@ -609,12 +851,55 @@ func (a *ClientConfig) Merge(b *ClientConfig) *ClientConfig {
 	return &result
 }
 
+// Merge is used to merge two HTTPLimits together.
+func (a *HTTPLimits) Merge(b *HTTPLimits) *HTTPLimits {
+	result := *a
+
+	if b.PerIP != 0 {
+		result.PerIP = b.PerIP
+	}
+	if b.PerIPBurst != 0 {
+		result.PerIPBurst = b.PerIPBurst
+	}
+	if b.PerEndpoint != 0 {
+		result.PerEndpoint = b.PerEndpoint
+	}
+	if b.PerEndpointBurst != 0 {
+		result.PerEndpointBurst = b.PerEndpointBurst
+	}
+	if len(b.Internal) != 0 {
+		result.Internal = b.Internal
+	}
+	if b.InternalRate != 0 {
+		result.InternalRate = b.InternalRate
+	}
+	if b.InternalBurst != 0 {
+		result.InternalBurst = b.InternalBurst
+	}
+	if b.AccessLogLevel != "" {
+		result.AccessLogLevel = b.AccessLogLevel
+	}
+	return &result
+}
+
 func (a *Network) Merge(b *Network) *Network {
 	result := *a
 
 	if b.MaxPayload != 0 {
 		result.MaxPayload = b.MaxPayload
 	}
+	if b.NatsToken != "" {
+		result.NatsToken = b.NatsToken
+	}
+	if b.NatsUser != "" {
+		result.NatsUser = b.NatsUser
+	}
+	if b.NatsPassword != "" {
+		result.NatsPassword = b.NatsPassword
+	}
+	if b.NatsNkey != "" {
+		result.NatsNkey = b.NatsNkey
+	}
 	return &result
 }
 
@@ -637,6 +922,12 @@ func (a *Metric) Merge(b *Metric) *Metric {
 	if b.PublishAllocationMetrics {
 		result.PublishAllocationMetrics = true
 	}
+	if b.MetaLabels != nil {
+		result.MetaLabels = b.MetaLabels
+	}
+	if b.OpenMetricsExemplars {
+		result.OpenMetricsExemplars = true
+	}
 	return &result
 }
 
@@ -20,6 +20,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
 )
 
 // ParseConfigFile parses the given path as a config file.
@@ -103,7 +104,9 @@ func parseConfig(result *Config, list *ast.ObjectList) error {
 		"leave_on_terminate",
 		"consul",
 		"http_api_response_headers",
+		"http_limits",
 		"dtle_schema_name",
+		"fault_injection",
 	}
 	if err := checkHCLKeys(list, valid); err != nil {
 		return multierror.Prefix(err, "config:")
@@ -123,6 +126,7 @@ func parseConfig(result *Config, list *ast.ObjectList) error {
 	delete(m, "network")
 	delete(m, "consul")
 	delete(m, "http_api_response_headers")
+	delete(m, "http_limits")
 
 	// Decode the rest
 	if err := mapstructure.WeakDecode(m, result); err != nil {
@@ -177,6 +181,12 @@ func parseConfig(result *Config, list *ast.ObjectList) error {
 		}
 	}
 
+	if o := list.Filter("http_limits"); len(o.Items) > 0 {
+		if err := parseHTTPLimits(&result.HTTPLimits, o); err != nil {
+			return multierror.Prefix(err, "http_limits ->")
+		}
+	}
+
 	// Parse the consul config
 	if o := list.Filter("consul"); len(o.Items) > 0 {
 		if err := parseConsulConfig(&result.Consul, o); err != nil {
@@ -323,6 +333,33 @@ func parseClient(result **ClientConfig, list *ast.ObjectList) error {
 		"managers",
 		"stats",
 		"no_host_uuid",
+		"alloc_updates_buffer_size",
+		"alloc_shutdown_timeout",
+		"state_snapshot_interval",
+		"state_snapshot_tx_delta",
+		"state_snapshots_retained",
+		"aux_disk_budget",
+		"aux_disk_policy",
+		"node_class",
+		"scheduling_ineligible",
+		"driver_setup_parallelism",
+		"driver_setup_timeout",
+		"refuse_unsupported_allocs",
+		"disable_consul_fallback",
+		"consul_fallback_after",
+		"heartbeat_grace_factor",
+		"expected_tasks",
+		"open_files_policy",
+		"state_dir_mode",
+		"alloc_dir_mode",
+		"alloc_dir_owner",
+		"dns_prefer",
+		"alloc_reconcile_threshold",
+		"task_output_log_level",
+		"self_monitor_window",
+		"self_monitor_goroutines",
+		"self_monitor_heap_mb",
+		"self_monitor_heap_profile_mb",
 	}
 	if err := checkHCLKeys(listVal, valid); err != nil {
 		return err
@@ -418,6 +455,8 @@ func parseMetric(result **Metric, list *ast.ObjectList) error {
 		"collection_interval",
 		"publish_allocation_metrics",
 		"publish_node_metrics",
+		"meta_labels",
+		"openmetrics_exemplars",
 	}
 	if err := checkHCLKeys(listVal, valid); err != nil {
 		return err
@@ -439,6 +478,11 @@ func parseMetric(result **Metric, list *ast.ObjectList) error {
 			metric.collectionInterval = dur
 		}
 	}
+	for _, key := range metric.MetaLabels {
+		if !models.IsJobMetaKey(key) || key == "task_name" || key == "window" {
+			return fmt.Errorf("bad meta_labels %q. Expect a key of the job meta, other than task_name and window", key)
+		}
+	}
 	*result = &metric
 	return nil
 }
@@ -455,6 +499,10 @@ func parseNetwork(result **Network, list *ast.ObjectList) error {
 	// Check for invalid keys
 	valid := []string{
 		"max_payload",
+		"nats_token",
+		"nats_user",
+		"nats_password",
+		"nats_nkey",
 	}
 	if err := checkHCLKeys(listVal, valid); err != nil {
 		return err
@@ -473,6 +521,46 @@ func parseNetwork(result **Network, list *ast.ObjectList) error {
 	return nil
 }
 
+func parseHTTPLimits(result **HTTPLimits, list *ast.ObjectList) error {
+	list = list.Elem()
+	if len(list.Items) > 1 {
+		return fmt.Errorf("only one 'http_limits' block allowed")
+	}
+
+	// Get our http_limits object
+	listVal := list.Items[0].Val
+
+	// Check for invalid keys
+	valid := []string{
+		"per_ip",
+		"per_ip_burst",
+		"per_endpoint",
+		"per_endpoint_burst",
+		"internal",
+		"internal_rate",
+		"internal_burst",
+		"access_log_level",
+	}
+	if err := checkHCLKeys(listVal, valid); err != nil {
+		return err
+	}
+
+	var m map[string]interface{}
+	if err := hcl.DecodeObject(&m, listVal); err != nil {
+		return err
+	}
+
+	var limits HTTPLimits
+	if err := mapstructure.WeakDecode(m, &limits); err != nil {
+		return err
+	}
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	*result = &limits
+	return nil
+}
+
 func parseConsulConfig(result **config.ConsulConfig, list *ast.ObjectList) error {
 	list = list.Elem()
 	if len(list.Items) > 1 {
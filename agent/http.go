@@ -20,7 +20,6 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/ugorji/go/codec"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"strings"
 	log "github.com/actiontech/dtle/internal/logger"
@@ -52,6 +51,8 @@ type HTTPServer struct {
 	logger   *log.Logger
 	uiDir    string
 	addr     string
+	// rate limits and access log of the requests
+	limiter *httpLimiter
 }
 
 // NewHTTPServer starts new HTTP server over the agent
@@ -66,6 +67,12 @@ func NewHTTPServer(agent *Agent, config *Config, logOutput io.Writer) (*HTTPServ
 		return nil, fmt.Errorf("failed to start HTTP listener: %v", err)
 	}
 
+	if config.HTTPLimits != nil {
+		if err := config.HTTPLimits.Validate(); err != nil {
+			return nil, fmt.Errorf("bad http_limits: %v", err)
+		}
+	}
+
 	// Create the mux
 	mux := http.NewServeMux()
 
@@ -77,11 +84,12 @@ func NewHTTPServer(agent *Agent, config *Config, logOutput io.Writer) (*HTTPServ
 		logger:   agent.logger,
 		uiDir:    config.UiDir,
 		addr:     ln.Addr().String(),
+		limiter:  newHTTPLimiter(config.HTTPLimits),
 	}
 	srv.registerHandlers()
 
 	// Start the server
-	go http.Serve(ln, gziphandler.GzipHandler(mux))
+	go http.Serve(ln, gziphandler.GzipHandler(srv.limitHandler(mux)))
 	return srv, nil
 }
 
@@ -168,6 +176,10 @@ func (s *HTTPServer) registerHandlers() {
 	s.mux.HandleFunc("/v1/self", s.wrap(s.AgentSelfRequest))
 	s.mux.HandleFunc("/v1/join", s.wrap(s.AgentJoinRequest))
 	s.mux.HandleFunc("/v1/agent/force-leave", s.wrap(s.AgentForceLeaveRequest))
+	s.mux.HandleFunc("/v1/agent/eligibility", s.wrap(s.AgentEligibilityRequest))
+	s.mux.HandleFunc("/v1/agent/attribute-diffs", s.wrap(s.AgentAttrDiffsRequest))
+	s.mux.HandleFunc("/v1/agent/reconcile", s.wrap(s.AgentReconcileRequest))
+	s.mux.HandleFunc("/v1/agent/health", s.wrap(s.AgentHealthRequest))
 	s.mux.HandleFunc("/v1/members", s.wrap(s.AgentMembersRequest))
 	s.mux.HandleFunc("/v1/managers", s.wrap(s.AgentServersRequest))
 
@@ -178,6 +190,10 @@ func (s *HTTPServer) registerHandlers() {
 
 	s.mux.HandleFunc("/v1/operator/", s.wrap(s.OperatorRequest))
 
+	if s.agent.config.FaultInjection {
+		s.mux.HandleFunc("/v1/agent/faults", s.wrap(s.AgentFaultsRequest))
+	}
+
 	if s.agent.config.LogLevel == "DEBUG" {
 		s.mux.HandleFunc("/debug/pprof/", pprof.Index)
 		s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -192,7 +208,7 @@ func (s *HTTPServer) registerHandlers() {
 		s.mux.Handle("/", http.StripPrefix("/", http.FileServer(assetFS())))
 	}
 
-	s.mux.Handle("/metrics", promhttp.Handler())
+	s.mux.HandleFunc("/metrics", s.MetricsRequest)
 }
 
 // HTTPCodedError is used to provide the HTTP error code
@@ -222,12 +238,8 @@ func (e *codedError) Code() int {
 func (s *HTTPServer) wrap(handler func(resp http.ResponseWriter, req *http.Request) (interface{}, error)) func(resp http.ResponseWriter, req *http.Request) {
 	f := func(resp http.ResponseWriter, req *http.Request) {
 		setHeaders(resp, s.agent.config.HTTPAPIResponseHeaders)
-		// Invoke the handler
+		// Invoke the handler. The requests are logged by limitHandler.
 		reqURL := req.URL.String()
-		start := time.Now()
-		defer func() {
-			s.logger.Debugf("http: Request %v (%v)", reqURL, time.Now().Sub(start))
-		}()
 		obj, err := handler(resp, req)
 
 		// Check for an error
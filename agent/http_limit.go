@@ -0,0 +1,322 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package agent
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// httpBucketPruneIntv is how often the buckets of the remote IPs which
+	// are full again are dropped. A dropped bucket is full when recreated.
+	httpBucketPruneIntv = time.Minute
+
+	// Values of HTTPLimits.AccessLogLevel
+	accessLogDebug = "DEBUG"
+	accessLogInfo  = "INFO"
+	accessLogOff   = "OFF"
+)
+
+// Validate checks the limits.
+func (l *HTTPLimits) Validate() error {
+	if l.PerIP < 0 || l.PerEndpoint < 0 || l.InternalRate < 0 {
+		return fmt.Errorf("negative rate")
+	}
+	if l.PerIPBurst < 0 || l.PerEndpointBurst < 0 || l.InternalBurst < 0 {
+		return fmt.Errorf("negative burst")
+	}
+	if _, err := parseInternalAddrs(l.Internal); err != nil {
+		return err
+	}
+	switch strings.ToUpper(l.AccessLogLevel) {
+	case "", accessLogDebug, accessLogInfo, accessLogOff:
+		return nil
+	default:
+		return fmt.Errorf("bad access_log_level '%v'. Expect %v, %v or %v",
+			l.AccessLogLevel, accessLogDebug, accessLogInfo, accessLogOff)
+	}
+}
+
+// parseInternalAddrs parses the IPs and the CIDRs of HTTPLimits.Internal.
+func parseInternalAddrs(addrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("bad internal address '%v'", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("bad internal address '%v': %v", addr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// burstOf is the burst of a rate, at least a second of it.
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// httpBucket is a token bucket of the requests.
+type httpBucket struct {
+	tokens float64
+	last   time.Time
+	// of the last refill
+	rate, burst float64
+}
+
+// refill adds the tokens of the time since the last refill, up to burst.
+func (b *httpBucket) refill(rate, burst float64, now time.Time) {
+	b.rate, b.burst = rate, burst
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		b.last = now
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+}
+
+// httpLimiter holds the token buckets of the HTTP API.
+type httpLimiter struct {
+	lock     sync.Mutex
+	limits   HTTPLimits
+	internal []*net.IPNet
+	// by remote IP and by endpoint
+	perIP       map[string]*httpBucket
+	perEndpoint map[string]*httpBucket
+	lastPrune   time.Time
+}
+
+func newHTTPLimiter(limits *HTTPLimits) *httpLimiter {
+	l := &httpLimiter{
+		perIP:       make(map[string]*httpBucket),
+		perEndpoint: make(map[string]*httpBucket),
+	}
+	l.setLimits(limits)
+	return l
+}
+
+// setLimits replaces the limits, e.g. on a reload. The buckets are kept, and
+// are capped to the new bursts at their next request. nil for no limits.
+func (l *httpLimiter) setLimits(limits *HTTPLimits) error {
+	var newLimits HTTPLimits
+	if limits != nil {
+		newLimits = *limits
+	}
+	internal, err := parseInternalAddrs(newLimits.Internal)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limits = newLimits
+	l.internal = internal
+	return nil
+}
+
+// isInternal tells whether ip is of HTTPLimits.Internal.
+func (l *httpLimiter) isInternal(ip net.IP) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, ipNet := range l.internal {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *httpLimiter) bucket(buckets map[string]*httpBucket, key string, burst float64, now time.Time) *httpBucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &httpBucket{tokens: burst, last: now}
+		buckets[key] = b
+	}
+	return b
+}
+
+// allow takes a token for a request of ip to endpoint, and returns 0, or how
+// long until the request would be allowed, taking no token.
+func (l *httpLimiter) allow(ip string, internal bool, endpoint string, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.prune(now)
+
+	type limit struct {
+		b           *httpBucket
+		rate, burst float64
+	}
+	var limits []limit
+	if internal {
+		if rate := l.limits.InternalRate; rate > 0 {
+			burst := burstOf(rate, l.limits.InternalBurst)
+			limits = append(limits, limit{l.bucket(l.perIP, ip, burst, now), rate, burst})
+		}
+	} else {
+		if rate := l.limits.PerIP; rate > 0 {
+			burst := burstOf(rate, l.limits.PerIPBurst)
+			limits = append(limits, limit{l.bucket(l.perIP, ip, burst, now), rate, burst})
+		}
+		if rate := l.limits.PerEndpoint; rate > 0 {
+			burst := burstOf(rate, l.limits.PerEndpointBurst)
+			limits = append(limits, limit{l.bucket(l.perEndpoint, endpoint, burst, now), rate, burst})
+		}
+	}
+
+	var wait time.Duration
+	for _, lim := range limits {
+		lim.b.refill(lim.rate, lim.burst, now)
+		if lim.b.tokens < 1 {
+			if w := time.Duration((1 - lim.b.tokens) / lim.rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, lim := range limits {
+		lim.b.tokens--
+	}
+	return 0
+}
+
+// prune drops the buckets of the remote IPs which are full again, as many
+// remote IPs come and go.
+func (l *httpLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < httpBucketPruneIntv {
+		return
+	}
+	l.lastPrune = now
+	for ip, b := range l.perIP {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// accessLogLevel returns HTTPLimits.AccessLogLevel.
+func (l *httpLimiter) accessLogLevel() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limits.AccessLogLevel == "" {
+		return accessLogDebug
+	}
+	return strings.ToUpper(l.limits.AccessLogLevel)
+}
+
+// SetLimits applies the HTTPLimits of a reloaded config. The requests in
+// flight are not affected.
+func (s *HTTPServer) SetLimits(limits *HTTPLimits) error {
+	return s.limiter.setLimits(limits)
+}
+
+// isInternal tells whether a remote IP is of an internal caller: of
+// HTTPLimits.Internal, or a manager the agent knows.
+func (s *HTTPServer) isInternal(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if s.limiter.isInternal(ip) {
+		return true
+	}
+	if client := s.agent.Client(); client != nil {
+		for _, addr := range client.GetServers() {
+			if host, _, err := net.SplitHostPort(addr); err == nil && ip.Equal(net.ParseIP(host)) {
+				return true
+			}
+		}
+	}
+	if server := s.agent.Server(); server != nil {
+		for _, member := range server.Members() {
+			if ip.Equal(member.Addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// statusRecorder records the status of a response, for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// remoteIP returns the IP of the remote address of a request.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// limitHandler rate limits the requests to handler by HTTPLimits, answering
+// 429 with Retry-After over a limit, and logs them in the access log.
+func (s *HTTPServer) limitHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		defer func() {
+			s.logAccess(req, rec.status, time.Since(start))
+		}()
+
+		_, endpoint := s.mux.Handler(req)
+		ip := remoteIP(req)
+		if wait := s.limiter.allow(ip, s.isInternal(net.ParseIP(ip)), endpoint, start); wait > 0 {
+			rec.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			rec.WriteHeader(http.StatusTooManyRequests)
+			rec.Write([]byte("Too many requests"))
+			return
+		}
+		handler.ServeHTTP(rec, req)
+	})
+}
+
+// logAccess logs a request in the access log, at HTTPLimits.AccessLogLevel.
+func (s *HTTPServer) logAccess(req *http.Request, status int, duration time.Duration) {
+	level := s.limiter.accessLogLevel()
+	if level == accessLogOff {
+		return
+	}
+	// The fields of the entries of the logger are only ids.
+	format := "http: access method=%v path=%v status=%v duration=%v remote=%v"
+	args := []interface{}{req.Method, req.URL.Path, status, duration, req.RemoteAddr}
+	if level == accessLogInfo {
+		s.logger.Infof(format, args...)
+	} else {
+		s.logger.Debugf(format, args...)
+	}
+}
@@ -8,8 +8,10 @@ package agent
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	_ "net/http/pprof"
 	"reflect"
 	"testing"
@@ -235,7 +237,7 @@ func TestHTTPServer_wrap(t *testing.T) {
 				addr:     tt.fields.addr,
 			}
 			if got := s.wrap(tt.args.handler); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("HTTPServer.wrap() = %v, want %v", got, tt.want)
+				t.Errorf("HTTPServer.wrap() = %p, want %p", got, tt.want)
 			}
 		})
 	}
@@ -480,3 +482,69 @@ func TestHTTPServer_parse(t *testing.T) {
 		})
 	}
 }
+
+func Test_httpLimiter_allow(t *testing.T) {
+	l := newHTTPLimiter(&HTTPLimits{PerIP: 1, PerIPBurst: 2, PerEndpoint: 10, Internal: []string{"10.0.0.0/8"}})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if wait := l.allow("192.168.0.1", false, "/v1/jobs", now); wait != 0 {
+			t.Fatalf("request %v within the burst waits %v", i, wait)
+		}
+	}
+	if wait := l.allow("192.168.0.1", false, "/v1/jobs", now); wait != time.Second {
+		t.Errorf("allow() over the burst = %v, want 1s", wait)
+	}
+	if wait := l.allow("192.168.0.2", false, "/v1/jobs", now); wait != 0 {
+		t.Errorf("allow() of another IP = %v, want 0", wait)
+	}
+	if wait := l.allow("192.168.0.1", false, "/v1/jobs", now.Add(time.Second)); wait != 0 {
+		t.Errorf("allow() after a second = %v, want 0", wait)
+	}
+	if !l.isInternal(net.ParseIP("10.1.2.3")) || l.isInternal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("isInternal() is wrong for %v", l.internal)
+	}
+	for i := 0; i < 20; i++ {
+		if wait := l.allow("10.1.2.3", true, "/v1/jobs", now); wait != 0 {
+			t.Fatalf("internal request %v waits %v without InternalRate", i, wait)
+		}
+	}
+
+	// per endpoint, over all the IPs
+	for i := 0; i < 10; i++ {
+		l.allow(fmt.Sprintf("172.16.0.%v", i), false, "/v1/nodes", now)
+	}
+	if wait := l.allow("172.16.0.100", false, "/v1/nodes", now); wait == 0 {
+		t.Errorf("allow() over the endpoint limit = 0")
+	}
+
+	// reloaded
+	if err := l.setLimits(nil); err != nil {
+		t.Fatal(err)
+	}
+	if wait := l.allow("192.168.0.1", false, "/v1/nodes", now); wait != 0 {
+		t.Errorf("allow() without limits = %v", wait)
+	}
+}
+
+func TestHTTPServer_limitHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/jobs", func(resp http.ResponseWriter, req *http.Request) {})
+	s := &HTTPServer{
+		agent:   &Agent{},
+		mux:     mux,
+		logger:  log.New(&bytes.Buffer{}, log.DebugLevel),
+		limiter: newHTTPLimiter(&HTTPLimits{PerIP: 0.5}),
+	}
+	handler := s.limitHandler(mux)
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/v1/jobs", nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != want {
+			t.Fatalf("request %v: status %v, want %v", i, resp.Code, want)
+		}
+		if want == http.StatusTooManyRequests && resp.Header().Get("Retry-After") != "2" {
+			t.Errorf("Retry-After = %q, want 2", resp.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -127,6 +127,13 @@ func (s *HTTPServer) jobListRequest(resp http.ResponseWriter, req *http.Request)
 	if s.parse(resp, req, &args.Region, &args.QueryOptions) {
 		return nil, nil
 	}
+	if filter := req.URL.Query().Get("meta"); filter != "" {
+		meta, err := parseMetaFilter(filter)
+		if err != nil {
+			return nil, CodedError(400, err.Error())
+		}
+		args.Meta = meta
+	}
 
 	var out models.JobListResponse
 	if err := s.agent.RPC("Job.List", &args, &out); err != nil {
@@ -140,6 +147,19 @@ func (s *HTTPServer) jobListRequest(resp http.ResponseWriter, req *http.Request)
 	return out.Jobs, nil
 }
 
+// parseMetaFilter parses the meta filter of the job list, "key=value" entries separated by ','.
+func parseMetaFilter(filter string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, entry := range strings.Split(filter, ",") {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("bad meta filter %q. Expect key=value", entry)
+		}
+		meta[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return meta, nil
+}
+
 func (s *HTTPServer) JobSpecificRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	path := strings.TrimPrefix(req.URL.Path, "/v1/job/")
 	switch {
@@ -155,6 +175,9 @@ func (s *HTTPServer) JobSpecificRequest(resp http.ResponseWriter, req *http.Requ
 	case strings.HasSuffix(path, "/evaluations"):
 		jobName := strings.TrimSuffix(path, "/evaluations")
 		return s.jobEvaluations(resp, req, jobName)
+	case strings.HasSuffix(path, "/children"):
+		jobName := strings.TrimSuffix(path, "/children")
+		return s.jobChildren(resp, req, jobName)
 	default:
 		return s.jobCRUD(resp, req, path)
 	}
@@ -217,6 +240,33 @@ func (s *HTTPServer) jobEvaluations(resp http.ResponseWriter, req *http.Request,
 	return out.Evaluations, nil
 }
 
+func (s *HTTPServer) jobChildren(resp http.ResponseWriter, req *http.Request,
+	jobName string) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	args := models.JobSpecificRequest{
+		JobID: jobName,
+	}
+	if args.Region == "" {
+		args.Region = s.agent.config.Region
+	}
+	if s.parse(resp, req, &args.Region, &args.QueryOptions) {
+		return nil, nil
+	}
+
+	var out models.JobChildrenResponse
+	if err := s.agent.RPC("Job.Children", &args, &out); err != nil {
+		return nil, err
+	}
+
+	setMeta(resp, &out.QueryMeta)
+	if out.Children == nil {
+		out.Children = make([]*models.PeriodicChildStub, 0)
+	}
+	return out.Children, nil
+}
+
 func (s *HTTPServer) jobCRUD(resp http.ResponseWriter, req *http.Request,
 	jobName string) (interface{}, error) {
 	switch req.Method {
@@ -443,6 +493,9 @@ func ApiJobToStructJob(job *api.Job, trafficLimit int) *models.Job {
 		Failover:          job.Failover,
 		Type:              *job.Type,
 		Datacenters:       job.Datacenters,
+		Colocate:          job.Colocate,
+		Meta:              job.Meta,
+		Periodic:          ApiPeriodicToStructsPeriodic(job.Periodic),
 		Status:            *job.Status,
 		StatusDescription: *job.StatusDescription,
 		CreateIndex:       *job.CreateIndex,
@@ -477,6 +530,18 @@ func ApiJobToStructJob(job *api.Job, trafficLimit int) *models.Job {
 	return j
 }
 
+func ApiPeriodicToStructsPeriodic(p *api.PeriodicConfig) *models.PeriodicConfig {
+	if p == nil {
+		return nil
+	}
+	return &models.PeriodicConfig{
+		Enabled:         p.Enabled,
+		Spec:            p.Spec,
+		ProhibitOverlap: p.ProhibitOverlap,
+		TimeZone:        p.TimeZone,
+	}
+}
+
 func ApiTaskToStructsTask(apiTask *api.Task, structsTask *models.Task) {
 	structsTask.Type = apiTask.Type
 	structsTask.NodeID = apiTask.NodeID
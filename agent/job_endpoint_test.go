@@ -7,12 +7,13 @@
 package agent
 
 import (
-	"log"
 	"net"
 	"net/http"
 	"reflect"
 	"testing"
+
 	"github.com/actiontech/dtle/api"
+	ulog "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 )
 
@@ -21,7 +22,7 @@ func TestHTTPServer_JobsRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -63,7 +64,7 @@ func TestHTTPServer_jobListRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -105,7 +106,7 @@ func TestHTTPServer_JobSpecificRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -147,7 +148,7 @@ func TestHTTPServer_jobAllocations(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -190,7 +191,7 @@ func TestHTTPServer_jobEvaluations(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -233,7 +234,7 @@ func TestHTTPServer_jobCRUD(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -276,7 +277,7 @@ func TestHTTPServer_jobQuery(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -319,7 +320,7 @@ func TestHTTPServer_jobUpdate(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -362,7 +363,7 @@ func TestHTTPServer_jobDelete(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -405,7 +406,7 @@ func TestHTTPServer_jobResumeRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -448,7 +449,7 @@ func TestHTTPServer_jobPauseRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -488,7 +489,8 @@ func TestHTTPServer_jobPauseRequest(t *testing.T) {
 
 func TestApiJobToStructJob(t *testing.T) {
 	type args struct {
-		job *api.Job
+		job          *api.Job
+		trafficLimit int
 	}
 	tests := []struct {
 		name string
@@ -499,7 +501,7 @@ func TestApiJobToStructJob(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ApiJobToStructJob(tt.args.job); !reflect.DeepEqual(got, tt.want) {
+			if got := ApiJobToStructJob(tt.args.job, tt.args.trafficLimit); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ApiJobToStructJob() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/actiontech/dtle/internal/openmetrics"
+)
+
+const (
+	applyLatencyMetric = "udup_apply_latency_seconds"
+	applyLatencyHelp   = "Time to apply the transactions changing the table on the target."
+)
+
+// MetricsRequest serves the metrics in the Prometheus text format, or in the OpenMetrics text
+// format if the Accept header asks for it or with format=openmetrics in the query. The latter
+// has the apply latency of the tables of the tasks, with the GTID of the slowest transaction of
+// each bucket since the previous scrape as exemplar, with openmetrics_exemplars.
+func (s *HTTPServer) MetricsRequest(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("format") != "openmetrics" && !openmetrics.Accepts(req.Header.Get("Accept")) {
+		promhttp.Handler().ServeHTTP(resp, req)
+		return
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var histograms []*openmetrics.HistogramFamily
+	if s.agent.client != nil {
+		if latency := s.applyLatencyFamily(); len(latency.Metrics) > 0 {
+			histograms = append(histograms, latency)
+		}
+	}
+	resp.Header().Set("Content-Type", openmetrics.ContentType)
+	if err := openmetrics.Write(resp, families, histograms); err != nil {
+		s.logger.Errorf("http: Failed to write the metrics: %v", err)
+	}
+}
+
+// applyLatencyFamily returns the apply latency of the tables of the tasks on the client, taking
+// their exemplars.
+func (s *HTTPServer) applyLatencyFamily() *openmetrics.HistogramFamily {
+	family := &openmetrics.HistogramFamily{Name: applyLatencyMetric, Help: applyLatencyHelp}
+	for _, task := range s.agent.client.ApplyLatency() {
+		for _, h := range task.Histograms {
+			m := &openmetrics.Histogram{
+				Buckets:   h.Buckets,
+				Counts:    h.Counts,
+				Count:     h.Count,
+				Sum:       h.Sum,
+				Exemplars: make([]*openmetrics.Exemplar, len(h.Exemplars)),
+			}
+			for _, l := range task.Labels {
+				m.Labels = append(m.Labels, openmetrics.LabelPair{Name: l.Name, Value: l.Value})
+			}
+			m.Labels = append(m.Labels, openmetrics.LabelPair{Name: "table", Value: h.Table})
+			for i, ex := range h.Exemplars {
+				if ex != nil {
+					m.Exemplars[i] = &openmetrics.Exemplar{
+						Labels:    []openmetrics.LabelPair{{Name: "gtid", Value: ex.Gtid}},
+						Value:     ex.Value,
+						Timestamp: time.Unix(0, ex.Timestamp),
+					}
+				}
+			}
+			family.Metrics = append(family.Metrics, m)
+		}
+	}
+	return family
+}
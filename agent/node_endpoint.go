@@ -48,6 +48,9 @@ func (s *HTTPServer) NodeSpecificRequest(resp http.ResponseWriter, req *http.Req
 	case strings.HasSuffix(path, "/allocations"):
 		nodeName := strings.TrimSuffix(path, "/allocations")
 		return s.nodeAllocations(resp, req, nodeName)
+	case strings.HasSuffix(path, "/events"):
+		nodeName := strings.TrimSuffix(path, "/events")
+		return s.nodeEvents(resp, req, nodeName)
 	default:
 		return s.nodeQuery(resp, req, path)
 	}
@@ -95,6 +98,30 @@ func (s *HTTPServer) nodeAllocations(resp http.ResponseWriter, req *http.Request
 	return out.Allocs, nil
 }
 
+func (s *HTTPServer) nodeEvents(resp http.ResponseWriter, req *http.Request,
+	nodeID string) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	args := models.NodeSpecificRequest{
+		NodeID: nodeID,
+	}
+	if s.parse(resp, req, &args.Region, &args.QueryOptions) {
+		return nil, nil
+	}
+
+	var out models.NodeEventsResponse
+	if err := s.agent.RPC("Node.Events", &args, &out); err != nil {
+		return nil, err
+	}
+
+	setMeta(resp, &out.QueryMeta)
+	if out.Events == nil {
+		out.Events = make([]*models.NodeEvent, 0)
+	}
+	return out.Events, nil
+}
+
 func (s *HTTPServer) nodeQuery(resp http.ResponseWriter, req *http.Request,
 	nodeID string) (interface{}, error) {
 	if req.Method != "GET" {
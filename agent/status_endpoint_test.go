@@ -7,11 +7,12 @@
 package agent
 
 import (
-	"log"
 	"net"
 	"net/http"
 	"reflect"
 	"testing"
+
+	ulog "github.com/actiontech/dtle/internal/logger"
 )
 
 func TestHTTPServer_StatusLeaderRequest(t *testing.T) {
@@ -19,7 +20,7 @@ func TestHTTPServer_StatusLeaderRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
@@ -61,7 +62,7 @@ func TestHTTPServer_StatusPeersRequest(t *testing.T) {
 		agent    *Agent
 		mux      *http.ServeMux
 		listener net.Listener
-		logger   *log.Logger
+		logger   *ulog.Logger
 		addr     string
 	}
 	type args struct {
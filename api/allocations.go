@@ -94,6 +94,61 @@ func (a *Allocations) GC(alloc *Allocation, q *QueryOptions) error {
 	return err
 }
 
+// Layout returns the dirs of the tasks of the allocation, relative to its alloc dir.
+func (a *Allocations) Layout(alloc *Allocation, q *QueryOptions) (map[string]*TaskLayout, error) {
+	client, err := a.nodeClient(alloc, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp map[string]*TaskLayout
+	_, err = client.query("/v1/agent/allocation/"+alloc.ID+"/layout", &resp, nil)
+	return resp, err
+}
+
+// Files lists the dir at the path relative to the alloc dir, or returns the file at the path.
+func (a *Allocations) Files(alloc *Allocation, path string, q *QueryOptions) ([]*AllocFileInfo, error) {
+	client, err := a.nodeClient(alloc, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp []*AllocFileInfo
+	_, err = client.query("/v1/agent/allocation/"+alloc.ID+"/files",
+		&resp, &QueryOptions{Params: map[string]string{"path": path}})
+	return resp, err
+}
+
+// nodeClient returns a client of the agent of the node of the allocation.
+func (a *Allocations) nodeClient(alloc *Allocation, q *QueryOptions) (*Client, error) {
+	node, _, err := a.client.Nodes().Info(alloc.NodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	if node.Status == "down" {
+		return nil, NodeDownErr
+	}
+	if node.HTTPAddr == "" {
+		return nil, fmt.Errorf("http addr of the node where alloc %q is running is not advertised", alloc.ID)
+	}
+	return NewClient(a.client.config.CopyConfig(node.HTTPAddr))
+}
+
+// TaskLayout is the dirs of a task, relative to the alloc dir.
+type TaskLayout struct {
+	Tmp     string
+	Data    string
+	Secrets string
+	Logs    string
+}
+
+// AllocFileInfo describes a file or dir in the alloc dir.
+type AllocFileInfo struct {
+	Name     string
+	IsDir    bool
+	Size     int64
+	FileMode string
+	ModTime  time.Time
+}
+
 // Allocation is used for serialization of allocations.
 type Allocation struct {
 	ID                 string
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/actiontech/dtle/internal"
 	"github.com/actiontech/dtle/internal/models"
@@ -132,6 +133,17 @@ func (j *Jobs) Evaluations(jobID string, q *QueryOptions) ([]*Evaluation, *Query
 	return resp, qm, nil
 }
 
+// Children is used to query the recent children of the given periodic
+// job, oldest first.
+func (j *Jobs) Children(jobID string, q *QueryOptions) ([]*PeriodicChild, *QueryMeta, error) {
+	var resp []*PeriodicChild
+	qm, err := j.client.query("/v1/job/"+jobID+"/children", &resp, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, qm, nil
+}
+
 // Deregister is used to remove an existing job.
 func (j *Jobs) Deregister(jobID string, q *WriteOptions) (string, *WriteMeta, error) {
 	var resp deregisterJobResponse
@@ -188,7 +200,10 @@ type Job struct {
 	Failover          bool
 	Type              *string
 	Datacenters       []string
+	Colocate          string
+	Meta              map[string]string
 	Tasks             []*Task
+	Periodic          *PeriodicConfig
 	Status            *string
 	StatusDescription *string
 	EnforceIndex      bool
@@ -232,12 +247,32 @@ func (j *Job) Canonicalize() {
 
 // JobListStub is used to return a subset of information about
 // jobs during list operations.
+// PeriodicConfig makes a job launch a child job making a one-shot copy at
+// the times of a cron expression.
+type PeriodicConfig struct {
+	Enabled         bool
+	Spec            string
+	ProhibitOverlap bool
+	TimeZone        string
+}
+
+// PeriodicChild is the outcome of a launch of a periodic job.
+type PeriodicChild struct {
+	JobID      string
+	LaunchTime int64
+	Status     string
+	Outcome    string
+	Duration   time.Duration
+}
+
 type JobListStub struct {
 	ID                string
 	Name              string
 	Type              string
 	Status            string
 	StatusDescription string
+	Mode              string // full, copy_only or incremental_only
+	Meta              map[string]string
 	JobSummary        *Job
 	CreateIndex       uint64
 	ModifyIndex       uint64
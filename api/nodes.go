@@ -8,6 +8,7 @@ package api
 
 import (
 	"sort"
+	"time"
 )
 
 // Nodes is used to query node-related API endpoints
@@ -56,6 +57,16 @@ func (n *Nodes) Allocations(nodeID string, q *QueryOptions) ([]*Allocation, *Que
 	return resp, qm, nil
 }
 
+// Events is used to return the events of a node, oldest first.
+func (n *Nodes) Events(nodeID string, q *QueryOptions) ([]*NodeEvent, *QueryMeta, error) {
+	var resp []*NodeEvent
+	qm, err := n.client.query("/v1/node/"+nodeID+"/events", &resp, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, qm, nil
+}
+
 // ForceEvaluate is used to force-evaluate an existing node.
 func (n *Nodes) ForceEvaluate(nodeID string, q *WriteOptions) (string, *WriteMeta, error) {
 	var resp nodeEvalResponse
@@ -81,6 +92,15 @@ type Node struct {
 	ModifyIndex       uint64
 }
 
+// NodeEvent is an event in the timeline of a node, e.g. its registration or a
+// missed heartbeat.
+type NodeEvent struct {
+	Type      string
+	Message   string
+	Timestamp time.Time
+	Details   map[string]string
+}
+
 // NodeListStub is a subset of information returned during
 // node list operations.
 type NodeListStub struct {
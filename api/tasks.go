@@ -34,11 +34,18 @@ func (t *Task) SetConfig(key string, val interface{}) *Task {
 // TaskState tracks the current store of a task and events that caused store
 // transitions.
 type TaskState struct {
-	State      string
-	Failed     bool
-	StartedAt  time.Time
-	FinishedAt time.Time
-	Events     []*TaskEvent
+	State          string
+	Failed         bool
+	StartedAt      time.Time
+	FinishedAt     time.Time
+	Events         []*TaskEvent
+	ResolvedConfig *ResolvedTaskConfig `json:"resolved_config,omitempty"`
+}
+
+// ResolvedTaskConfig is the config a task was last started with, with the secrets redacted.
+type ResolvedTaskConfig struct {
+	Config      map[string]interface{}
+	ModifyIndex uint64
 }
 
 const (
@@ -52,6 +59,7 @@ const (
 	TaskTerminated       = "Terminated"
 	TaskKilling          = "Killing"
 	TaskKilled           = "Killed"
+	TaskStopping         = "Stopping"
 	TaskRestarting       = "Restarting"
 	TaskNotRestarting    = "Not Restarting"
 	TaskSiblingFailed    = "Sibling Task Failed"
@@ -61,9 +69,10 @@ const (
 )
 
 type TableStats struct {
-	InsertCount int64
-	UpdateCount int64
-	DelCount    int64
+	InsertCount   int64
+	UpdateCount   int64
+	DelCount      int64
+	ConflictCount int64
 }
 
 type DelayCount struct {
@@ -71,6 +80,11 @@ type DelayCount struct {
 	Time uint64
 }
 
+type GtidGap struct {
+	Transactions int64
+	Seconds      int64
+}
+
 type ThroughputStat struct {
 	Num  uint64
 	Time uint64
@@ -84,6 +98,7 @@ type Stats struct {
 
 type TaskStatistics struct {
 	Stats     *Stats
+	GtidGap   *GtidGap
 	Timestamp int64
 }
 
@@ -168,6 +168,8 @@ func parseJob(result *api.Job, list *ast.ObjectList) error {
 		return err
 	}
 
+	delete(m, "meta")
+
 	// Set the ID and name to the object key
 	result.ID = internal.StringToPtr(obj.Keys[0].Token.Value().(string))
 	result.Name = internal.StringToPtr(*result.ID)
@@ -189,6 +191,7 @@ func parseJob(result *api.Job, list *ast.ObjectList) error {
 	valid := []string{
 		"region",
 		"datacenters",
+		"meta",
 		"name",
 		"task",
 		"type",
@@ -197,6 +200,19 @@ func parseJob(result *api.Job, list *ast.ObjectList) error {
 		return multierror.Prefix(err, "job:")
 	}
 
+	// Parse the meta
+	if o := listVal.Filter("meta"); len(o.Items) > 0 {
+		for _, o := range o.Elem().Items {
+			var m map[string]interface{}
+			if err := hcl.DecodeObject(&m, o.Val); err != nil {
+				return err
+			}
+			if err := mapstructure.WeakDecode(m, &result.Meta); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Parse the task groups
 	if o := listVal.Filter("task"); len(o.Items) > 0 {
 		if err := parseTasks(result, o); err != nil {
@@ -65,7 +65,7 @@ Node Status Options:
     Display a count of running allocations for each node.
 
   -verbose
-    Display full information.
+    Display full information, with the events of the node.
 
   -json
     Output the node in its JSON format.
@@ -261,6 +261,13 @@ func (c *NodeStatusCommand) formatNode(client *api.Client, node *api.Node) int {
 	if c.verbose {
 		c.formatAttributes(node)
 		c.formatMeta(node)
+
+		events, _, err := client.Nodes().Events(node.ID, nil)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error querying node events: %s", err))
+			return 1
+		}
+		c.formatEvents(events)
 	}
 	return 0
 
@@ -302,6 +309,28 @@ func (c *NodeStatusCommand) formatMeta(node *api.Node) {
 	c.Ui.Output(formatKV(meta))
 }
 
+func (c *NodeStatusCommand) formatEvents(events []*api.NodeEvent) {
+	// Print the events, the most recent first
+	out := make([]string, 1, len(events)+1)
+	out[0] = "Time|Type|Message|Details"
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		keys := make([]string, 0, len(e.Details))
+		for k := range e.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		details := make([]string, 0, len(keys))
+		for _, k := range keys {
+			details = append(details, fmt.Sprintf("%s: %s", k, e.Details[k]))
+		}
+		out = append(out, fmt.Sprintf("%s|%s|%s|%s",
+			formatTime(e.Timestamp), e.Type, e.Message, strings.Join(details, ", ")))
+	}
+	c.Ui.Output(c.Colorize().Color("\n[bold]Events[reset]"))
+	c.Ui.Output(formatList(out))
+}
+
 // getRunningAllocs returns a slice of allocation id's running on the node
 func getRunningAllocs(client *api.Client, nodeID string) ([]*api.Allocation, error) {
 	var allocs []*api.Allocation
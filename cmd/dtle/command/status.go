@@ -50,16 +50,37 @@ Status Options:
 
   -verbose
     Display full information.
+
+  -meta key=value
+    List only the jobs with this entry in their meta. May be repeated, for the
+    jobs with all the entries.
 `
 	return strings.TrimSpace(helpText)
 }
 
+// metaFlag is the -meta entries of the job list, key=value.
+type metaFlag []string
+
+func (f *metaFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *metaFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || strings.Contains(value, ",") {
+		return fmt.Errorf("bad meta %q. Expect key=value", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
 func (c *StatusCommand) Synopsis() string {
 	return "Display status information about jobs"
 }
 
 func (c *StatusCommand) Run(args []string) int {
 	var short bool
+	var meta metaFlag
 
 	flags := c.Meta.FlagSet("status", FlagSetClient)
 	flags.Usage = func() { c.Ui.Output(c.Help()) }
@@ -67,6 +88,7 @@ func (c *StatusCommand) Run(args []string) int {
 	flags.BoolVar(&c.evals, "evals", false, "")
 	flags.BoolVar(&c.allAllocs, "all-allocs", false, "")
 	flags.BoolVar(&c.verbose, "verbose", false, "")
+	flags.Var(&meta, "meta", "")
 
 	if err := flags.Parse(args); err != nil {
 		return 1
@@ -91,7 +113,11 @@ func (c *StatusCommand) Run(args []string) int {
 
 	// Invoke list mode if no job ID.
 	if len(args) == 0 {
-		jobs, _, err := client.Jobs().List(nil)
+		var q *api.QueryOptions
+		if len(meta) > 0 {
+			q = &api.QueryOptions{Params: map[string]string{"meta": meta.String()}}
+		}
+		jobs, _, err := client.Jobs().List(q)
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error querying jobs: %s", err))
 			return 1
@@ -134,8 +160,21 @@ func (c *StatusCommand) Run(args []string) int {
 		fmt.Sprintf("Name|%s", *job.Name),
 		fmt.Sprintf("Type|%s", *job.Type),
 		fmt.Sprintf("Datacenters|%s", strings.Join(job.Datacenters, ",")),
+		fmt.Sprintf("Mode|%s", jobs[0].Mode),
 		fmt.Sprintf("Status|%s", *job.Status),
 	}
+	if len(job.Meta) > 0 {
+		keys := make([]string, 0, len(job.Meta))
+		for key := range job.Meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		entries := make([]string, len(keys))
+		for i, key := range keys {
+			entries[i] = fmt.Sprintf("%s=%s", key, job.Meta[key])
+		}
+		basic = append(basic, fmt.Sprintf("Meta|%s", strings.Join(entries, ",")))
+	}
 
 	c.Ui.Output(formatKV(basic))
 
@@ -234,9 +273,43 @@ func (c *StatusCommand) outputJobInfo(client *api.Client, job *api.Job) error {
 	} else {
 		c.Ui.Output("No allocations placed")
 	}
+
+	if c.verbose {
+		c.outputGtidGap(client, jobAllocs)
+	}
 	return nil
 }
 
+// outputGtidGap displays how far the running apply tasks are behind what they
+// have received.
+func (c *StatusCommand) outputGtidGap(client *api.Client, jobAllocs []*api.AllocationListStub) {
+	gaps := []string{"ID|Task|Behind (transactions)|Behind (seconds)"}
+	for _, alloc := range jobAllocs {
+		if alloc.ClientStatus != "running" {
+			continue
+		}
+		stats, err := client.Allocations().Stats(&api.Allocation{ID: alloc.ID, NodeID: alloc.NodeID}, nil)
+		if err != nil {
+			c.Ui.Warn(fmt.Sprintf("Error querying stats of allocation %s: %s", limit(alloc.ID, c.length), err))
+			continue
+		}
+		for task, taskStats := range stats.Tasks {
+			if taskStats.GtidGap == nil {
+				continue
+			}
+			gaps = append(gaps, fmt.Sprintf("%s|%s|%d|%d",
+				limit(alloc.ID, c.length),
+				task,
+				taskStats.GtidGap.Transactions,
+				taskStats.GtidGap.Seconds))
+		}
+	}
+	if len(gaps) > 1 {
+		c.Ui.Output(c.Colorize().Color("\n[bold]Replication Gap[reset]"))
+		c.Ui.Output(formatList(gaps))
+	}
+}
+
 // outputJobSummary displays the given jobs summary and children job summary
 // where appropriate
 func (c *StatusCommand) outputJobSummary(client *api.Client, job *api.Job) error {
@@ -257,7 +330,7 @@ func (c *StatusCommand) outputJobSummary(client *api.Client, job *api.Job) error
 
 	for idx, task := range summary.Tasks {
 		summaries[idx+1] = fmt.Sprintf("%s|%s",
-			task, task.Status,
+			task.Type, task.Status,
 		)
 	}
 	c.Ui.Output(formatList(summaries))
@@ -304,11 +377,12 @@ func sortedTaskFromMetrics(groups map[string]*api.AllocationMetric) []string {
 // list general information about a list of jobs
 func createStatusListOutput(jobs []*api.JobListStub) string {
 	out := make([]string, len(jobs)+1)
-	out[0] = "ID|Type|Status"
+	out[0] = "ID|Type|Mode|Status"
 	for i, job := range jobs {
-		out[i+1] = fmt.Sprintf("%s|%s|%s",
+		out[i+1] = fmt.Sprintf("%s|%s|%s|%s",
 			job.ID,
 			job.Type,
+			job.Mode,
 			job.Status)
 	}
 	return formatList(out)
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package atrest encrypts the data files the tasks write with EncryptDataAtRest (LOAD DATA
+// files and FileSink files), with a data key of the allocation.
+//
+// The key is generated by the client in the alloc dir, and shredded when the allocation is
+// destroyed, which leaves the files written with it unreadable. Each record is sealed with
+// AES-256-GCM as nonce || ciphertext || tag, so it is authenticated on reading.
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// KeySize is the size of a data key, for AES-256.
+const KeySize = 32
+
+var ErrShortRecord = errors.New("the encrypted record is shorter than its nonce and tag")
+
+// Cipher seals and opens the records of the files of a task, and counts them for the stats.
+// It is safe for concurrent use.
+type Cipher struct {
+	aead cipher.AEAD
+
+	bytesEncrypted int64
+	bytesDecrypted int64
+	encryptNanos   int64
+	decryptNanos   int64
+}
+
+// NewCipher returns a Cipher with the data key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the data key has %v bytes, not %v", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Overhead is the bytes a sealed record has more than its plaintext.
+func (c *Cipher) Overhead() int {
+	return c.aead.NonceSize() + c.aead.Overhead()
+}
+
+// Seal encrypts plain with a random nonce.
+func (c *Cipher) Seal(plain []byte) ([]byte, error) {
+	start := time.Now()
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, nil)
+	atomic.AddInt64(&c.bytesEncrypted, int64(len(plain)))
+	atomic.AddInt64(&c.encryptNanos, int64(time.Since(start)))
+	return sealed, nil
+}
+
+// Open decrypts a record sealed by Seal, and fails if it was altered.
+func (c *Cipher) Open(sealed []byte) ([]byte, error) {
+	start := time.Now()
+	if len(sealed) < c.Overhead() {
+		return nil, ErrShortRecord
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.bytesDecrypted, int64(len(plain)))
+	atomic.AddInt64(&c.decryptNanos, int64(time.Since(start)))
+	return plain, nil
+}
+
+// Stat returns the bytes sealed and opened, of the plaintexts, and the time spent on them.
+func (c *Cipher) Stat() *models.EncryptionStat {
+	return &models.EncryptionStat{
+		BytesEncrypted: atomic.LoadInt64(&c.bytesEncrypted),
+		BytesDecrypted: atomic.LoadInt64(&c.bytesDecrypted),
+		EncryptTime:    atomic.LoadInt64(&c.encryptNanos) / int64(time.Millisecond),
+		DecryptTime:    atomic.LoadInt64(&c.decryptNanos) / int64(time.Millisecond),
+	}
+}
+
+// LoadOrCreateKey reads the data key at path, or generates it if there is none. The file and
+// its dir are only accessible to the agent.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("the data key %v has %v bytes, not %v", path, len(key), KeySize)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, StoreKey(path, key)
+}
+
+// StoreKey writes the data key at path, e.g. again after it was removed. It fails if a key is
+// already at path.
+func StoreKey(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// DestroyKey overwrites the data key at path with zeros before removing it, so that the key is
+// not left in the blocks of the file. It is best effort on the file systems and disks which do
+// not write in place, e.g. copy-on-write ones and SSDs. A missing key is not an error.
+func DestroyKey(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		if _, err = f.WriteAt(make([]byte, info.Size()), 0); err == nil {
+			err = f.Sync()
+		}
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to overwrite the data key %v: %v", path, err)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package atrest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	if _, err := NewCipher([]byte("short")); err == nil {
+		t.Errorf("NewCipher() of a short key succeeded")
+	}
+	c, err := NewCipher(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := []byte("3e11fa47-71ca-11e1-9e33-c80aa9429562\t1\n")
+	sealed, err := c.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(plain)+c.Overhead() || bytes.Contains(sealed, plain) {
+		t.Errorf("Seal() = %q, want %v bytes encrypted", sealed, len(plain)+c.Overhead())
+	}
+	if again, _ := c.Seal(plain); bytes.Equal(again, sealed) {
+		t.Errorf("Seal() twice gives the same record")
+	}
+	if opened, err := c.Open(sealed); err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("Open() = %q, %v, want %q", opened, err, plain)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Open(sealed); err == nil {
+		t.Errorf("Open() of an altered record succeeded")
+	}
+	if _, err := c.Open(sealed[:3]); err != ErrShortRecord {
+		t.Errorf("Open() of a short record: err = %v, want %v", err, ErrShortRecord)
+	}
+	if stat := c.Stat(); stat.BytesEncrypted != 2*int64(len(plain)) || stat.BytesDecrypted != int64(len(plain)) {
+		t.Errorf("Stat() = %+v", stat)
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-atrest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets", "data.key")
+
+	key, err := LoadOrCreateKey(path)
+	if err != nil || len(key) != KeySize {
+		t.Fatalf("LoadOrCreateKey() = %v, %v, want a new key", key, err)
+	}
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("key dir: %v, %v, want mode 0700", info, err)
+	}
+	if loaded, err := LoadOrCreateKey(path); err != nil || !bytes.Equal(loaded, key) {
+		t.Errorf("LoadOrCreateKey() = %v, %v, want the existing key", loaded, err)
+	}
+
+	if err := DestroyKey(path); err != nil {
+		t.Fatalf("DestroyKey() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("key after DestroyKey(): err = %v, want removed", err)
+	}
+	if err := DestroyKey(path); err != nil {
+		t.Errorf("DestroyKey() of a missing key: err = %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package auxdisk accounts the disk used by the auxiliary files of the tasks (spill,
+// dead-letter, audit and LOAD DATA files) against a node-wide budget.
+//
+// A writer calls Reserve before writing to a file, and Release after removing it.
+package auxdisk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type Kind string
+
+const (
+	KindSpill      Kind = "spill"
+	KindDeadLetter Kind = "dead_letter"
+	KindAudit      Kind = "audit"
+	KindLoadData   Kind = "load_data"
+)
+
+// Policy is what to do when a write would exceed the budget.
+type Policy string
+
+const (
+	// PolicyPause blocks the writer, thus pausing its task, until enough space is released.
+	PolicyPause Policy = "pause"
+	// PolicyDropDeadLetters removes the oldest dead-letter files to make space.
+	PolicyDropDeadLetters Policy = "drop_dead_letters"
+	// PolicyStopAudit refuses audit writes from then on, so that the other files can be written.
+	PolicyStopAudit Policy = "stop_audit"
+)
+
+var (
+	ErrBudgetExceeded = errors.New("aux disk budget exceeded")
+	ErrAuditStopped   = errors.New("audit stopped since the aux disk budget was hit")
+	ErrBudgetClosed   = errors.New("aux disk budget closed")
+)
+
+type auxFile struct {
+	kind Kind
+	size int64
+}
+
+// Budget is shared by all the tasks on a node. A nil or zero-limit Budget accounts the
+// usage without limiting it.
+type Budget struct {
+	limit  int64
+	policy Policy
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	used  int64
+	files map[string]*auxFile
+	// dead-letter files, the oldest first
+	deadLetters  []string
+	auditStopped bool
+	closed       bool
+}
+
+// NewBudget returns a budget of limit bytes. 0 is unlimited.
+func NewBudget(limit int64, policy Policy) (*Budget, error) {
+	if policy == "" {
+		policy = PolicyPause
+	}
+	switch policy {
+	case PolicyPause, PolicyDropDeadLetters, PolicyStopAudit:
+	default:
+		return nil, fmt.Errorf("unknown aux disk policy %q. expect %v, %v or %v",
+			policy, PolicyPause, PolicyDropDeadLetters, PolicyStopAudit)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("bad aux disk budget %v", limit)
+	}
+	b := &Budget{
+		limit:  limit,
+		policy: policy,
+		files:  make(map[string]*auxFile),
+	}
+	b.cond = sync.NewCond(&b.lock)
+	return b, nil
+}
+
+// Reserve accounts n more bytes to be written to the file at path.
+// If it would exceed the budget, the policy applies, and an error is returned if the
+// write should not be done.
+func (b *Budget) Reserve(kind Kind, path string, n int64) error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if kind == KindAudit && b.auditStopped {
+		return ErrAuditStopped
+	}
+	if b.limit > 0 && n > b.limit {
+		// Never fits.
+		return ErrBudgetExceeded
+	}
+	for b.limit > 0 && b.used+n > b.limit {
+		if b.closed {
+			return ErrBudgetClosed
+		}
+		switch b.policy {
+		case PolicyPause:
+			b.cond.Wait()
+			continue
+		case PolicyDropDeadLetters:
+			if b.dropOldestDeadLetter(path) {
+				continue
+			}
+		case PolicyStopAudit:
+			b.auditStopped = true
+			if kind == KindAudit {
+				return ErrAuditStopped
+			}
+		}
+		return ErrBudgetExceeded
+	}
+
+	f, ok := b.files[path]
+	if !ok {
+		f = &auxFile{kind: kind}
+		b.files[path] = f
+		if kind == KindDeadLetter {
+			b.deadLetters = append(b.deadLetters, path)
+		}
+	}
+	f.size += n
+	b.used += n
+	return nil
+}
+
+// dropOldestDeadLetter removes the oldest dead-letter file other than keep.
+// It returns false if there is none to remove.
+func (b *Budget) dropOldestDeadLetter(keep string) bool {
+	for i := 0; i < len(b.deadLetters); i++ {
+		path := b.deadLetters[i]
+		if path == keep {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			// Still on disk. Try the next one.
+			continue
+		}
+		b.deadLetters = append(b.deadLetters[:i], b.deadLetters[i+1:]...)
+		b.used -= b.files[path].size
+		delete(b.files, path)
+		return true
+	}
+	return false
+}
+
+// Release is called after the file at path is removed.
+func (b *Budget) Release(path string) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	f, ok := b.files[path]
+	if !ok {
+		return
+	}
+	b.used -= f.size
+	delete(b.files, path)
+	if f.kind == KindDeadLetter {
+		for i := range b.deadLetters {
+			if b.deadLetters[i] == path {
+				b.deadLetters = append(b.deadLetters[:i], b.deadLetters[i+1:]...)
+				break
+			}
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// Used returns the bytes accounted.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.used
+}
+
+// Close wakes up the paused writers with ErrBudgetClosed.
+func (b *Budget) Close() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	b.closed = true
+	b.lock.Unlock()
+	b.cond.Broadcast()
+}
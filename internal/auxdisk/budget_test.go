@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package auxdisk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBudget_Reserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-auxdisk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dl1, dl2 := filepath.Join(dir, "dl1"), filepath.Join(dir, "dl2")
+	for _, path := range []string{dl1, dl2} {
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		// reservations before the checked one
+		prepare func(b *Budget)
+		kind    Kind
+		n       int64
+		wantErr error
+		check   func(t *testing.T, b *Budget)
+	}{
+		{"fits", PolicyPause, nil, KindSpill, 5, nil, nil},
+		{"never-fits", PolicyPause, nil, KindSpill, 11, ErrBudgetExceeded, nil},
+		{"drop-dead-letters", PolicyDropDeadLetters, func(b *Budget) {
+			b.Reserve(KindDeadLetter, dl1, 6)
+			b.Reserve(KindDeadLetter, dl2, 2)
+		}, KindSpill, 5, nil, func(t *testing.T, b *Budget) {
+			if _, err := os.Stat(dl1); !os.IsNotExist(err) {
+				t.Errorf("the oldest dead-letter file is not removed: %v", err)
+			}
+			if _, err := os.Stat(dl2); err != nil {
+				t.Errorf("a dead-letter file is removed more than needed: %v", err)
+			}
+		}},
+		{"stop-audit", PolicyStopAudit, func(b *Budget) {
+			b.Reserve(KindSpill, "spill", 8)
+		}, KindAudit, 5, ErrAuditStopped, func(t *testing.T, b *Budget) {
+			if err := b.Reserve(KindAudit, "audit", 1); err != ErrAuditStopped {
+				t.Errorf("Reserve() of audit after stopped error = %v, want %v", err, ErrAuditStopped)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewBudget(10, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(b)
+			}
+			if err := b.Reserve(tt.kind, "checked", tt.n); err != tt.wantErr {
+				t.Errorf("Reserve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if b.Used() > 10 {
+				t.Errorf("Used() = %v, beyond the budget", b.Used())
+			}
+			if tt.check != nil {
+				tt.check(t, b)
+			}
+		})
+	}
+}
+
+func TestBudget_pause(t *testing.T) {
+	b, err := NewBudget(10, PolicyPause)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reserve(KindSpill, "spill1", 8)
+
+	done := make(chan error)
+	go func() {
+		done <- b.Reserve(KindSpill, "spill2", 5)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Reserve() beyond the budget returned %v, want it paused", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	b.Release("spill1")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Reserve() after Release() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Reserve() is not resumed by Release()")
+	}
+	if b.Used() != 5 {
+		t.Errorf("Used() = %v, want 5", b.Used())
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// AllocStateError is the error of an allocation whose state cannot be recovered, e.g. after its
+// dirs were removed by hand with its data key. The allocation is failed, and the others are not
+// affected.
+type AllocStateError struct {
+	AllocID string
+	Err     error
+}
+
+func (e *AllocStateError) Error() string {
+	return fmt.Sprintf("unrecoverable state of alloc %v: %v", e.AllocID, e.Err)
+}
+
+// repairDirs recreates the state dir and the alloc dir of the allocation which were removed while
+// it runs. It returns an *AllocStateError if they cannot be recovered. The dirs of a destroyed
+// allocation are not recreated.
+func (r *Allocator) repairDirs() error {
+	r.destroyLock.Lock()
+	destroyed := r.destroy
+	r.destroyLock.Unlock()
+	if destroyed {
+		return nil
+	}
+
+	id := r.Alloc().ID
+	stateDir := filepath.Dir(r.stateFilePath())
+	if _, err := os.Stat(stateDir); os.IsNotExist(err) {
+		r.logger.Warnf("agent: State dir %v of alloc %v was removed. Recreating it", stateDir, id)
+		if err := os.MkdirAll(stateDir, 0700); err != nil {
+			return &AllocStateError{AllocID: id, Err: fmt.Errorf("failed to recreate state dir: %v", err)}
+		}
+	}
+	if r.allocDir == nil {
+		return nil
+	}
+	repaired, err := r.allocDir.Repair(r.encryptsData())
+	if len(repaired) > 0 {
+		r.logger.Warnf("agent: Recreated the removed dirs of alloc %v: %v", id, repaired)
+	}
+	if err != nil {
+		return &AllocStateError{AllocID: id, Err: err}
+	}
+	return nil
+}
+
+// encryptsData tells whether the task of the allocation encrypts its data files, with
+// EncryptDataAtRest.
+func (r *Allocator) encryptsData() bool {
+	alloc := r.Alloc()
+	if alloc.Job == nil {
+		return false
+	}
+	t := alloc.Job.LookupTask(alloc.Task)
+	if t == nil {
+		return false
+	}
+	t.ConfigLock.RLock()
+	defer t.ConfigLock.RUnlock()
+	switch v := t.Config["EncryptDataAtRest"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true" || v == "1"
+	}
+	return false
+}
+
+// failState fails the allocation with the *AllocStateError err: its tasks are killed, and it is
+// not saved after it.
+func (r *Allocator) failState(err error) {
+	r.allocLock.Lock()
+	first := r.stateErr == nil
+	if first {
+		r.stateErr = err
+	}
+	r.allocLock.Unlock()
+	if !first {
+		return
+	}
+
+	r.logger.Errorf("agent: %v. Failing the allocation", err)
+	for _, tr := range r.getWorkers() {
+		tr.Kill("agent", err.Error(), true)
+	}
+	r.setStatus(models.AllocClientStatusFailed, err.Error())
+}
+
+// stateError returns the *AllocStateError failing the allocation, or nil.
+func (r *Allocator) stateError() error {
+	r.allocLock.Lock()
+	defer r.allocLock.Unlock()
+	return r.stateErr
+}
+
+// sweepAllocDirs reconciles the dirs of the allocations with their state on the start of the
+// client: the removed dirs are recreated, and the allocations which cannot be recovered are
+// failed before their tasks are started.
+func (c *Client) sweepAllocDirs() {
+	for _, ar := range c.getAllocRunners() {
+		if ar.stateError() != nil {
+			continue
+		}
+		if err := ar.repairDirs(); err != nil {
+			ar.failState(err)
+		}
+	}
+}
@@ -7,6 +7,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 
+	"github.com/actiontech/dtle/internal/client/allocdir"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
@@ -57,6 +59,20 @@ type Allocator struct {
 
 	// serialize saveAllocatorState calls
 	persistLock sync.Mutex
+
+	// dirty marks the allocation changed since the last snapshot.
+	// savedTxCounts is the number of transactions replicated by each task at the last snapshot.
+	dirty         bool
+	savedTxCounts map[string]int64
+	snapshotLock  sync.Mutex
+	// requests an immediate snapshot, on task state transitions
+	snapshotCh chan struct{}
+
+	// nil without ClientConfig.AllocDir
+	allocDir *allocdir.AllocDir
+
+	// the *AllocStateError failing the allocation, which is not saved after it. guarded by allocLock
+	stateErr error
 }
 
 // allocatorState is used to snapshot the store of the alloc runner
@@ -83,6 +99,13 @@ func NewAllocator(logger *log.Logger, config *config.ClientConfig, updater Alloc
 		workUpdates: workUpdates,
 		destroyCh:   make(chan struct{}),
 		waitCh:      make(chan struct{}),
+
+		dirty:         true,
+		savedTxCounts: make(map[string]int64),
+		snapshotCh:    make(chan struct{}, 1),
+	}
+	if config != nil && config.AllocDir != "" && alloc != nil {
+		ar.allocDir = allocdir.NewAllocDir(config.AllocDir, alloc.ID, config.AllocDirPerms)
 	}
 	return ar
 }
@@ -99,19 +122,29 @@ func (r *Allocator) stateFilePath() string {
 // if the fullSync is marked as false only the store of the Alloc Runner
 // is snapshotted. If fullSync is marked as true, we snapshot
 // all the Task Runners associated with the Alloc
+//
+// The dirs of the allocation removed while it runs are recreated first. An allocation whose
+// state cannot be recovered is failed with an *AllocStateError, and is not saved after it.
 func (r *Allocator) SaveState() error {
-	/*if err := r.saveAllocatorState(); err != nil {
+	if r.stateError() != nil {
+		return nil
+	}
+	if err := r.repairDirs(); err != nil {
+		r.failState(err)
 		return err
-	}*/
+	}
 
-	// Save store for each task
-	runners := r.getWorkers()
+	// Save store for each task, then the allocation with the task configs they update. A
+	// failure of one does not keep the others from being saved.
 	var mErr multierror.Error
-	for _, tr := range runners {
+	for _, tr := range r.getWorkers() {
 		if err := r.saveWorkerState(tr); err != nil {
 			mErr.Errors = append(mErr.Errors, err)
 		}
 	}
+	if err := r.saveAllocatorState(); err != nil {
+		mErr.Errors = append(mErr.Errors, err)
+	}
 	return mErr.ErrorOrNil()
 }
 
@@ -119,9 +152,46 @@ func (r *Allocator) saveAllocatorState() error {
 	r.persistLock.Lock()
 	defer r.persistLock.Unlock()
 
+	// Do not recreate the state removed by DestroyState.
+	r.destroyLock.Lock()
+	destroyed := r.destroy
+	r.destroyLock.Unlock()
+	if destroyed {
+		return nil
+	}
+
 	// Create the snapshot.
 	alloc := r.Alloc()
 
+	// The task config is updated by the workers, e.g. with the replicated Gtid.
+	// Snapshot it, so that the task can be restored without the servers.
+	configs := make(map[string]map[string]interface{})
+	for _, tr := range r.getWorkers() {
+		tr.task.ConfigLock.RLock()
+		config := make(map[string]interface{}, len(tr.task.Config))
+		for k, v := range tr.task.Config {
+			config[k] = v
+		}
+		tr.task.ConfigLock.RUnlock()
+		configs[tr.task.Type] = config
+	}
+	if alloc.Job != nil {
+		for _, t := range alloc.Job.Tasks {
+			// t is a copy. Its Config is shared with the original.
+			t.ConfigLock.RLock()
+			config, ok := configs[t.Type]
+			if !ok {
+				config = make(map[string]interface{}, len(t.Config))
+				for k, v := range t.Config {
+					config[k] = v
+				}
+			}
+			t.ConfigLock.RUnlock()
+			t.Config = config
+			t.ConfigLock = &sync.RWMutex{}
+		}
+	}
+
 	r.allocLock.Lock()
 	allocClientStatus := r.allocClientStatus
 	allocClientDescription := r.allocClientDescription
@@ -133,7 +203,125 @@ func (r *Allocator) saveAllocatorState() error {
 		AllocClientStatus:      allocClientStatus,
 		AllocClientDescription: allocClientDescription,
 	}
-	return persistState(r.stateFilePath(), &snap)
+	keep := r.config.StateSnapshotsRetained
+	if keep <= 0 {
+		keep = defaultStateSnapshotsRetained
+	}
+	return persistStateRotated(r.stateFilePath(), &snap, keep)
+}
+
+// RestoreState is used to restore the allocation from the state saved by
+// SaveState. The tasks are started again by Run, with the saved task config.
+// A snapshot which fails to be read falls back to the previous one.
+func (r *Allocator) RestoreState() error {
+	var snap allocatorState
+	var err error
+	paths := statePaths(r.stateFilePath())
+	for i, path := range paths {
+		if i > 0 {
+			r.logger.Warnf("agent: Failed to restore alloc %v from %v: %v. Falling back to the previous snapshot %v",
+				r.alloc.ID, paths[i-1], err, path)
+		}
+		snap = allocatorState{}
+		if err = restoreState(path, &snap); err == nil && snap.Alloc == nil {
+			err = fmt.Errorf("no allocation in state file %v", path)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	r.allocLock.Lock()
+	r.alloc = snap.Alloc
+	r.allocClientStatus = snap.AllocClientStatus
+	r.allocClientDescription = snap.AllocClientDescription
+	r.allocLock.Unlock()
+
+	r.taskStatusLock.Lock()
+	r.taskStates = copyTaskStates(snap.Alloc.TaskStates)
+	r.taskStatusLock.Unlock()
+	return nil
+}
+
+// markDirty marks the allocation to be saved by the next snapshotIfDirty.
+func (r *Allocator) markDirty() {
+	r.snapshotLock.Lock()
+	r.dirty = true
+	r.snapshotLock.Unlock()
+}
+
+// snapshotIfDirty saves the state if the allocation is changed since the last snapshot.
+// A task which has replicated StateSnapshotTxDelta transactions since then changes it as well.
+func (r *Allocator) snapshotIfDirty() (saved bool, err error) {
+	txDelta := r.config.StateSnapshotTxDelta
+	if txDelta <= 0 {
+		txDelta = defaultStateSnapshotTxDelta
+	}
+
+	txCounts := make(map[string]int64)
+	for _, tr := range r.getWorkers() {
+		gtid, ok := tr.currentGtid()
+		if !ok || gtid == "" {
+			continue
+		}
+		count, err := gtidTxCount(gtid)
+		if err != nil {
+			r.logger.Warnf("agent: Failed to parse Gtid of alloc %s task '%s': %v", r.alloc.ID, tr.task.Type, err)
+			r.markDirty()
+			continue
+		}
+		txCounts[tr.task.Type] = count
+
+		r.snapshotLock.Lock()
+		if count-r.savedTxCounts[tr.task.Type] >= txDelta {
+			r.dirty = true
+		}
+		r.snapshotLock.Unlock()
+	}
+
+	r.snapshotLock.Lock()
+	dirty := r.dirty
+	r.dirty = false
+	r.snapshotLock.Unlock()
+	if !dirty {
+		return false, nil
+	}
+
+	if err := r.SaveState(); err != nil {
+		r.markDirty()
+		return false, err
+	}
+	r.snapshotLock.Lock()
+	for task, count := range txCounts {
+		r.savedTxCounts[task] = count
+	}
+	r.snapshotLock.Unlock()
+	return true, nil
+}
+
+// txSinceSnapshot returns the most transactions replicated by a task of the allocation since
+// the last snapshot.
+func (r *Allocator) txSinceSnapshot() int64 {
+	var most int64
+	for _, tr := range r.getWorkers() {
+		gtid, ok := tr.currentGtid()
+		if !ok || gtid == "" {
+			continue
+		}
+		count, err := gtidTxCount(gtid)
+		if err != nil {
+			continue
+		}
+		r.snapshotLock.Lock()
+		if delta := count - r.savedTxCounts[tr.task.Type]; delta > most {
+			most = delta
+		}
+		r.snapshotLock.Unlock()
+	}
+	return most
 }
 
 func (r *Allocator) saveWorkerState(tr *Worker) error {
@@ -146,6 +334,11 @@ func (r *Allocator) saveWorkerState(tr *Worker) error {
 
 // DestroyState is used to cleanup after ourselves
 func (r *Allocator) DestroyState() error {
+	if r.allocDir != nil {
+		if err := r.allocDir.Destroy(); err != nil {
+			return err
+		}
+	}
 	return os.RemoveAll(filepath.Dir(r.stateFilePath()))
 }
 
@@ -188,7 +381,7 @@ func (r *Allocator) Alloc() *models.Allocation {
 			running = true
 		case models.TaskStatePending:
 			pending = true
-		case models.TaskStateDead:
+		case models.TaskStateDead, models.TaskStateComplete:
 			if state.Failed {
 				failed = true
 			} else {
@@ -218,6 +411,10 @@ func (r *Allocator) dirtySyncState() {
 		select {
 		case <-r.dirtyCh:
 			r.syncStatus()
+		case <-r.snapshotCh:
+			if _, err := r.snapshotIfDirty(); err != nil {
+				r.logger.Errorf("agent: Failed to save state for alloc %s: %v", r.alloc.ID, err)
+			}
 		case <-r.destroyCh:
 			return
 		}
@@ -241,6 +438,7 @@ func (r *Allocator) setStatus(status, desc string) {
 	r.allocClientStatus = status
 	r.allocClientDescription = desc
 	r.allocLock.Unlock()
+	r.markDirty()
 	select {
 	case r.dirtyCh <- struct{}{}:
 		r.logger.Debugf("setStatus")
@@ -272,6 +470,13 @@ func (r *Allocator) setTaskState(taskName, state string, event *models.TaskEvent
 	}
 
 	if state == "" {
+		if event != nil && event.Type == models.TaskStartPositionSaved {
+			r.markDirty()
+			select {
+			case r.snapshotCh <- struct{}{}:
+			default:
+			}
+		}
 		return
 	}
 
@@ -281,7 +486,15 @@ func (r *Allocator) setTaskState(taskName, state string, event *models.TaskEvent
 		if taskState.State != models.TaskStateRunning {
 			taskState.StartedAt = time.Now()
 		}
-	case models.TaskStateDead:
+		r.taskLock.RLock()
+		tr, ok := r.tasks[taskName]
+		r.taskLock.RUnlock()
+		if ok {
+			if resolved := tr.resolvedTaskConfig(); resolved != nil {
+				taskState.ResolvedConfig = resolved
+			}
+		}
+	case models.TaskStateDead, models.TaskStateComplete:
 		// Capture the finished time. If it has never started there is no finish
 		// time
 		if !taskState.StartedAt.IsZero() {
@@ -360,6 +573,7 @@ func (r *Allocator) setTaskState(taskName, state string, event *models.TaskEvent
 	}
 
 	// Store the new store
+	transition := taskState.State != state
 	taskState.State = state
 
 	select {
@@ -367,6 +581,73 @@ func (r *Allocator) setTaskState(taskName, state string, event *models.TaskEvent
 		r.logger.Debugf("setTaskState: dirtyCh<-")
 	default:
 	}
+
+	// Snapshot a transition at once. A crash should not lose e.g. the failure of the task.
+	r.markDirty()
+	if transition {
+		select {
+		case r.snapshotCh <- struct{}{}:
+		default:
+		}
+	}
+
+	if transition && taskState.Successful() {
+		go func(state *models.TaskState) {
+			if err := r.saveReport(taskName, state); err != nil {
+				r.logger.Errorf("agent: Failed to save report for alloc %s: %v", r.alloc.ID, err)
+			}
+		}(taskState.Copy())
+	}
+}
+
+// saveReport saves the summary of the completed task to the alloc dir. It is kept after
+// the allocation is destroyed.
+func (r *Allocator) saveReport(taskName string, state *models.TaskState) error {
+	if r.config.AllocDir == "" {
+		return nil
+	}
+	alloc := r.Alloc()
+	report := &models.AllocReport{
+		AllocID:    alloc.ID,
+		JobID:      alloc.JobID,
+		Task:       taskName,
+		StartedAt:  state.StartedAt,
+		FinishedAt: state.FinishedAt,
+		Events:     state.Events,
+	}
+	if alloc.Job != nil {
+		report.JobName = alloc.Job.Name
+	}
+	if !state.StartedAt.IsZero() && !state.FinishedAt.IsZero() {
+		report.Duration = state.FinishedAt.Sub(state.StartedAt).String()
+	}
+
+	r.taskLock.RLock()
+	tr, ok := r.tasks[taskName]
+	r.taskLock.RUnlock()
+	if ok {
+		if gtid, ok := tr.currentGtid(); ok {
+			report.Gtid = gtid
+		}
+		if stats := tr.finalTaskStats(); stats != nil {
+			report.Tables = stats.TableCopyStats
+			report.TableStats = stats.TableStats
+			if stats.SinceTaskStart != nil && stats.SinceTaskStart.TableStats != nil {
+				report.TableStats = stats.SinceTaskStart.TableStats
+			}
+			report.RowCounts = stats.RowCounts
+			if report.Gtid == "" && stats.CurrentCoordinates != nil {
+				report.Gtid = stats.CurrentCoordinates.GtidSet
+			}
+		}
+	}
+	for _, table := range report.Tables {
+		report.TotalRowsCopied += table.RowsCopied
+	}
+
+	r.logger.Printf("agent: Task %q of alloc %q completed. %d rows copied in %v",
+		taskName, alloc.ID, report.TotalRowsCopied, report.Duration)
+	return persistState(allocdir.ReportPath(r.config.AllocDir, alloc.ID), report)
 }
 
 // appendTaskEvent updates the task status by appending the new event.
@@ -391,6 +672,14 @@ func (r *Allocator) Run() {
 	defer close(r.waitCh)
 	go r.dirtySyncState()
 
+	// Failed on restore, without a job if its state could not be read. See Client.restoreState
+	// and Client.sweepAllocDirs.
+	if err := r.stateError(); err != nil {
+		r.logger.Errorf("agent: Alloc %q not started: %v", r.alloc.ID, err)
+		r.handleDestroy()
+		return
+	}
+
 	// Find the task to run in the allocation
 	alloc := r.alloc
 	t := alloc.Job.LookupTask(alloc.Task)
@@ -418,6 +707,15 @@ func (r *Allocator) Run() {
 	}
 
 	tr := NewWorker(r.logger, r.config, r.setTaskState, r.Alloc(), t.Copy(), r.workUpdates)
+	if r.allocDir != nil {
+		if err := r.allocDir.Build(); err != nil {
+			r.taskLock.Unlock()
+			r.logger.Errorf("agent: Failed to build alloc dir for alloc '%s': %v", alloc.ID, err)
+			r.setStatus(models.AllocClientStatusFailed, err.Error())
+			return
+		}
+		tr.taskDir = r.allocDir.NewTaskDir(t.Type)
+	}
 	r.tasks[t.Type] = tr
 	tr.MarkReceived()
 
@@ -437,6 +735,12 @@ OUTER:
 			r.allocLock.Lock()
 			r.alloc = update
 			r.allocLock.Unlock()
+			r.markDirty()
+			if update.Job != nil {
+				for _, tr := range r.getWorkers() {
+					tr.setJobMeta(update.Job.Meta)
+				}
+			}
 
 			// Check if we're in a terminal status
 			if update.ClientTerminalStatus() {
@@ -466,9 +770,25 @@ func (r *Allocator) destroyWorkers(destroyEvent *models.TaskEvent) {
 		tr.Destroy(destroyEvent)
 	}
 
-	// Wait for termination of the task runners
+	// Wait for termination of the task runners. A task hanging in teardown
+	// must not block the allocation forever.
+	timeout := r.config.AllocShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultAllocShutdownTimeout
+	}
+	// The deadline is shared by the runners. Each one gets its own timer, which fires at once for
+	// those still running once the deadline has passed.
+	deadline := time.Now().Add(timeout)
 	for _, tr := range runners {
-		<-tr.WaitCh()
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-tr.WaitCh():
+		case <-timer.C:
+			r.logger.Errorf("agent: Task %q for alloc %q did not stop in %v. Forcing teardown",
+				tr.task.Type, r.alloc.ID, timeout)
+			tr.ForceKill()
+		}
+		timer.Stop()
 	}
 }
 
@@ -563,6 +883,80 @@ func (r *Allocator) shouldUpdate(serverIndex uint64) bool {
 	return r.alloc.AllocModifyIndex < serverIndex
 }
 
+// Stop stops the tasks of the allocation gracefully, see Worker.Stop, and saves the
+// allocation. Unlike Destroy, which is for the forced removal, the state is kept, so
+// the tasks resume from the checkpoint when the allocation is added again.
+func (r *Allocator) Stop(ctx context.Context) error {
+	var mErr multierror.Error
+	for _, tr := range r.getWorkers() {
+		if err := tr.Stop(ctx); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("task %v: %v", tr.task.Type, err))
+		}
+	}
+	if err := r.SaveState(); err != nil {
+		mErr.Errors = append(mErr.Errors, err)
+	}
+	return mErr.ErrorOrNil()
+}
+
+// SetApplierWorkers resizes the pool of workers of the Dest task. See Worker.SetWorkers.
+func (r *Allocator) SetApplierWorkers(ctx context.Context, n int) error {
+	for _, tr := range r.getWorkers() {
+		if tr.task.Type == models.TaskTypeDest {
+			return tr.SetWorkers(ctx, n)
+		}
+	}
+	return fmt.Errorf("allocation %q has no %v task", r.alloc.ID, models.TaskTypeDest)
+}
+
+// ResetStats resets the counters of the tasks of the allocation, or of the task taskFilter if
+// set, and returns them as they were, by task. Without taskFilter, the tasks whose stats cannot
+// be reset are left out. See Worker.ResetStats.
+func (r *Allocator) ResetStats(taskFilter, by string) (map[string]*models.TaskCounters, error) {
+	var runners []*Worker
+	if taskFilter != "" {
+		r.taskLock.RLock()
+		tr, ok := r.tasks[taskFilter]
+		r.taskLock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("allocation %q has no task %q", r.alloc.ID, taskFilter)
+		}
+		runners = []*Worker{tr}
+	} else {
+		runners = r.getWorkers()
+	}
+
+	counters := make(map[string]*models.TaskCounters)
+	var mErr multierror.Error
+	for _, tr := range runners {
+		c, err := tr.ResetStats(by)
+		if err == errStatsNotResettable && taskFilter == "" {
+			continue
+		} else if err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("task %v: %v", tr.task.Type, err))
+			continue
+		}
+		counters[tr.task.Type] = c
+	}
+	return counters, mErr.ErrorOrNil()
+}
+
+// EffectiveConfig returns the configs the tasks of the allocation are running with, by task.
+// See Worker.EffectiveConfig.
+func (r *Allocator) EffectiveConfig() (map[string]*models.ResolvedTaskConfig, error) {
+	configs := make(map[string]*models.ResolvedTaskConfig)
+	var mErr multierror.Error
+	for _, tr := range r.getWorkers() {
+		c, err := tr.EffectiveConfig()
+		if err != nil {
+			mErr.Errors = append(mErr.Errors, err)
+			continue
+		}
+		configs[tr.task.Type] = c
+	}
+	return configs, mErr.ErrorOrNil()
+}
+
 // Destroy is used to indicate that the allocation context should be destroyed
 func (r *Allocator) Destroy() {
 	r.destroyLock.Lock()
@@ -7,9 +7,13 @@
 package client
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
@@ -1354,3 +1358,305 @@ func TestAllocator_WaitCh(t *testing.T) {
 		})
 	}
 }
+
+func TestAllocator_destroyWorkersTimeout(t *testing.T) {
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	alloc := &models.Allocation{ID: "alloc1"}
+	// never exit
+	hung := func(taskType string) *Worker {
+		return &Worker{
+			logger:      logger,
+			alloc:       alloc,
+			task:        &models.Task{Type: taskType},
+			destroyCh:   make(chan struct{}),
+			waitCh:      make(chan struct{}),
+			forceKillCh: make(chan struct{}),
+		}
+	}
+	src, dest := hung(models.TaskTypeSrc), hung(models.TaskTypeDest)
+	r := &Allocator{
+		config: &config.ClientConfig{AllocShutdownTimeout: 10 * time.Millisecond},
+		logger: logger,
+		alloc:  alloc,
+		tasks:  map[string]*Worker{src.task.Type: src, dest.task.Type: dest},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.destroyWorkers(models.NewTaskEvent(models.TaskKilled))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("destroyWorkers() did not return after AllocShutdownTimeout")
+	}
+	for _, tr := range []*Worker{src, dest} {
+		select {
+		case <-tr.forceKillCh:
+		default:
+			t.Errorf("destroyWorkers() did not force-kill the hanging worker %v", tr.task.Type)
+		}
+	}
+}
+
+func TestAllocator_RestoreState(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-alloc-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	cfg := &config.ClientConfig{StateDir: stateDir}
+	task := models.NewTask()
+	task.Type = models.TaskTypeDest
+	task.Config = map[string]interface{}{"Gtid": ""}
+	alloc := &models.Allocation{
+		ID:   "alloc1",
+		Task: models.TaskTypeDest,
+		Job:  &models.Job{ID: "job1", Tasks: []*models.Task{task}},
+	}
+	r := NewAllocator(logger, cfg, func(*models.Allocation) {}, alloc, make(chan *models.TaskUpdate, 1))
+	// The worker has replicated some transactions.
+	workerTask := task.Copy()
+	workerTask.Config = map[string]interface{}{"Gtid": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"}
+	r.tasks[workerTask.Type] = &Worker{task: workerTask, logger: logger, alloc: alloc}
+	if err := r.SaveState(); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	restored := NewAllocator(logger, cfg, func(*models.Allocation) {}, &models.Allocation{ID: "alloc1"},
+		make(chan *models.TaskUpdate, 1))
+	if err := restored.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() error = %v", err)
+	}
+	restoredTask := restored.Alloc().Job.LookupTask(models.TaskTypeDest)
+	if restoredTask == nil {
+		t.Fatalf("RestoreState() lost the task")
+	}
+	if got := restoredTask.Config["Gtid"]; got != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5" {
+		t.Errorf("RestoreState() Gtid = %v, want the one of the worker", got)
+	}
+}
+
+func TestAllocator_snapshotIfDirty(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-alloc-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	cfg := &config.ClientConfig{StateDir: stateDir}
+	alloc := &models.Allocation{
+		ID:   "alloc1",
+		Task: models.TaskTypeDest,
+		Job:  &models.Job{ID: "job1"},
+	}
+	r := NewAllocator(logger, cfg, func(*models.Allocation) {}, alloc, make(chan *models.TaskUpdate, 1))
+
+	// A new allocation is saved, and then skipped until it's changed.
+	for i, want := range []bool{true, false} {
+		saved, err := r.snapshotIfDirty()
+		if err != nil || saved != want {
+			t.Errorf("snapshotIfDirty() #%d = %v, %v, want %v", i, saved, err, want)
+		}
+	}
+	r.setTaskState(models.TaskTypeDest, models.TaskStateRunning, nil)
+	if saved, err := r.snapshotIfDirty(); err != nil || !saved {
+		t.Errorf("snapshotIfDirty() after a task state change = %v, %v, want true", saved, err)
+	}
+	select {
+	case <-r.snapshotCh:
+	default:
+		t.Errorf("setTaskState() did not request a snapshot on the transition")
+	}
+}
+
+func TestAllocator_saveReport(t *testing.T) {
+	allocDir, err := ioutil.TempDir("", "dtle-alloc-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(allocDir)
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	cfg := &config.ClientConfig{AllocDir: allocDir}
+	alloc := &models.Allocation{
+		ID:    "alloc1",
+		JobID: "job1",
+		Task:  models.TaskTypeDest,
+		Job:   &models.Job{ID: "job1", Name: "copy"},
+	}
+	r := NewAllocator(logger, cfg, func(*models.Allocation) {}, alloc, make(chan *models.TaskUpdate, 1))
+	r.tasks[models.TaskTypeDest] = &Worker{logger: logger, alloc: alloc, taskStats: &models.TaskStatistics{
+		CurrentCoordinates: &models.CurrentCoordinates{GtidSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"},
+		TableCopyStats: []*models.TableCopyStat{
+			{TableSchema: "db1", TableName: "t1", RowsCopied: 10},
+			{TableSchema: "db1", TableName: "t2", RowsCopied: 5},
+		},
+	}}
+	started := time.Now().Add(-time.Minute)
+	state := &models.TaskState{
+		State:      models.TaskStateDead,
+		StartedAt:  started,
+		FinishedAt: started.Add(time.Minute),
+		Events:     []*models.TaskEvent{models.NewTaskEvent(models.TaskTerminated)},
+	}
+	if err := r.saveReport(models.TaskTypeDest, state); err != nil {
+		t.Fatalf("saveReport() error = %v", err)
+	}
+
+	c := &Client{config: cfg}
+	report, err := c.AllocReport("alloc1")
+	if err != nil {
+		t.Fatalf("AllocReport() error = %v", err)
+	}
+	if report.JobName != "copy" || report.Task != models.TaskTypeDest || report.Duration != "1m0s" {
+		t.Errorf("AllocReport() = %+v, want the job, task and duration", report)
+	}
+	if report.Gtid != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5" || report.TotalRowsCopied != 15 || len(report.Tables) != 2 {
+		t.Errorf("AllocReport() = %+v, want the Gtid and copied rows of the task", report)
+	}
+	if _, err := c.AllocReport("alloc2"); err == nil {
+		t.Errorf("AllocReport() of an allocation without report succeeded, want an error")
+	}
+}
+
+func TestAllocator_RestoreState_fallback(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-alloc-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	cfg := &config.ClientConfig{StateDir: stateDir, StateSnapshotsRetained: 2}
+	r := NewAllocator(logger, cfg, func(*models.Allocation) {}, &models.Allocation{ID: "alloc1"},
+		make(chan *models.TaskUpdate, 1))
+	for _, status := range []string{models.AllocClientStatusPending, models.AllocClientStatusRunning,
+		models.AllocClientStatusFailed} {
+		r.allocClientStatus = status
+		if err := r.SaveState(); err != nil {
+			t.Fatalf("SaveState() error = %v", err)
+		}
+	}
+	path := r.stateFilePath()
+	if got := statePaths(path); !reflect.DeepEqual(got, []string{path, path + ".1"}) {
+		t.Fatalf("snapshots = %v, want the last 2", got)
+	}
+
+	restore := func() (string, error) {
+		restored := NewAllocator(logger, cfg, func(*models.Allocation) {}, &models.Allocation{ID: "alloc1"},
+			make(chan *models.TaskUpdate, 1))
+		err := restored.RestoreState()
+		return restored.allocClientStatus, err
+	}
+	if status, err := restore(); err != nil || status != models.AllocClientStatusFailed {
+		t.Errorf("RestoreState() = %v, %v, want the latest", status, err)
+	}
+
+	// a corrupt latest snapshot falls back to the previous one
+	if err := ioutil.WriteFile(path, []byte(`{"Alloc":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := restore(); err != nil || status != models.AllocClientStatusRunning {
+		t.Errorf("RestoreState() = %v, %v, want the previous", status, err)
+	}
+
+	if err := ioutil.WriteFile(path+".1", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restore(); err == nil {
+		t.Errorf("RestoreState() of corrupt snapshots succeeded")
+	}
+}
+
+func TestAllocator_SaveState_repair(t *testing.T) {
+	root, err := ioutil.TempDir("", "dtle-alloc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	cfg := &config.ClientConfig{StateDir: filepath.Join(root, "state"), AllocDir: filepath.Join(root, "alloc")}
+	newAllocator := func(id string, encrypted bool) *Allocator {
+		task := models.NewTask()
+		task.Type = models.TaskTypeDest
+		task.Config = map[string]interface{}{"EncryptDataAtRest": encrypted}
+		alloc := &models.Allocation{
+			ID:   id,
+			Task: models.TaskTypeDest,
+			Job:  &models.Job{ID: "job-" + id, Tasks: []*models.Task{task}},
+		}
+		r := NewAllocator(logger, cfg, func(*models.Allocation) {}, alloc, make(chan *models.TaskUpdate, 1))
+		if err := r.allocDir.Build(); err != nil {
+			t.Fatal(err)
+		}
+		td := r.allocDir.NewTaskDir(models.TaskTypeDest)
+		if err := td.Build(); err != nil {
+			t.Fatal(err)
+		}
+		if encrypted {
+			if _, err := td.DataKey(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(td.DataDir, "f"), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SaveState(); err != nil {
+			t.Fatalf("SaveState() of %v error = %v", id, err)
+		}
+		return r
+	}
+	kept := newAllocator("alloc1", false)
+	lost := newAllocator("alloc2", true)
+	// alloc2 is restored after a restart of the client, and has not loaded its key yet
+	lost = NewAllocator(logger, cfg, func(*models.Allocation) {}, lost.Alloc(), make(chan *models.TaskUpdate, 1))
+
+	// the dirs are removed while they run, with the key of alloc2
+	for _, r := range []*Allocator{kept, lost} {
+		if err := os.RemoveAll(filepath.Dir(r.stateFilePath())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.RemoveAll(kept.allocDir.AllocDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(lost.allocDir.AllocDir, "secrets")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kept.SaveState(); err != nil {
+		t.Errorf("SaveState() of alloc1 error = %v, want the dirs recreated", err)
+	}
+	for _, path := range []string{kept.stateFilePath(), filepath.Join(kept.allocDir.AllocDir, models.TaskTypeDest, "data")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%v after SaveState(): err = %v, want recreated", path, err)
+		}
+	}
+
+	c := &Client{logger: logger, allocs: map[string]*Allocator{"alloc1": kept, "alloc2": lost}}
+	c.sweepAllocDirs()
+	if _, ok := lost.stateError().(*AllocStateError); !ok || lost.allocClientStatus != models.AllocClientStatusFailed {
+		t.Errorf("alloc2 after the sweep: %v, %v, want failed with an *AllocStateError",
+			lost.allocClientStatus, lost.stateError())
+	}
+	if kept.stateError() != nil || kept.allocClientStatus == models.AllocClientStatusFailed {
+		t.Errorf("alloc1 after the sweep: %v, %v, want not failed", kept.allocClientStatus, kept.stateError())
+	}
+
+	// a failed allocation is not saved again, and does not keep the others from being saved
+	if err := lost.SaveState(); err != nil {
+		t.Errorf("SaveState() of the failed alloc2 error = %v, want skipped", err)
+	}
+	if _, err := os.Stat(lost.stateFilePath()); !os.IsNotExist(err) {
+		t.Errorf("state of the failed alloc2: err = %v, want not saved", err)
+	}
+	if err := kept.SaveState(); err != nil {
+		t.Errorf("SaveState() of alloc1 after alloc2 failed: error = %v", err)
+	}
+}
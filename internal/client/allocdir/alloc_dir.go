@@ -0,0 +1,336 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package allocdir defines the layout of the files of the allocations on a client:
+//
+//	<alloc_dir>/<alloc id>/<task>/tmp      scratch files, emptied at each start of the task
+//	<alloc_dir>/<alloc id>/<task>/data     files surviving a restart of the task
+//	<alloc_dir>/<alloc id>/<task>/secrets  files readable only by the agent, not browsable
+//	<alloc_dir>/<alloc id>/<task>/logs     log files of the task, e.g. <task>.stdout and <task>.stderr
+//	<alloc_dir>/<alloc id>/secrets/data.key the data key of EncryptDataAtRest, shredded on destroy
+//	<alloc_dir>/reports/<alloc id>.json    the report of a completed task, kept after destroy
+//
+// Everything under <alloc_dir>/<alloc id> is removed when the allocation is destroyed. The
+// dirs are created with the mode and the owner of the Perms of the client, but the secrets
+// dirs, which are always 0700.
+package allocdir
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/atrest"
+)
+
+const (
+	// TaskTmp is the scratch dir of a task, e.g. for spill queues and dump files.
+	TaskTmp = "tmp"
+	// TaskData is the dir of the files a task resumes from after a restart.
+	TaskData = "data"
+	// TaskSecrets is the dir of the credentials of a task.
+	TaskSecrets = "secrets"
+	// TaskLogs is the dir of the log files of a task.
+	TaskLogs = "logs"
+
+	reportsDir = "reports"
+	dataKey    = "data.key"
+)
+
+var ErrNotBrowsable = errors.New("the secrets dirs are not browsable")
+
+// ErrDataKeyLost is returned by Repair for an allocation whose data key was removed while its
+// data files, encrypted with the key, remain. They cannot be read again.
+var ErrDataKeyLost = errors.New("the data key was removed, and the data files encrypted with it cannot be read")
+
+// AllocDirFS exposes the files of an allocation, e.g. for the files API.
+// Paths are relative to the alloc dir. The secrets dirs are not browsable.
+type AllocDirFS interface {
+	// Layout returns the dirs of each task, relative to the alloc dir.
+	Layout() map[string]*TaskLayout
+	List(path string) ([]*AllocFileInfo, error)
+	Stat(path string) (*AllocFileInfo, error)
+}
+
+// AllocFileInfo describes a file or dir in the alloc dir.
+type AllocFileInfo struct {
+	Name     string
+	IsDir    bool
+	Size     int64
+	FileMode string
+	ModTime  time.Time
+}
+
+// TaskLayout is the dirs of a task, relative to the alloc dir.
+type TaskLayout struct {
+	Tmp     string
+	Data    string
+	Secrets string
+	Logs    string
+}
+
+// AllocDir is the dir of an allocation.
+type AllocDir struct {
+	// AllocDir is the absolute path of the dir of the allocation.
+	AllocDir string
+
+	perms    Perms
+	taskDirs map[string]*TaskDir
+	// the data key, once loaded, to write it again if it is removed
+	key  []byte
+	lock sync.Mutex
+}
+
+// TaskDir is the dir of a task of an allocation. The paths are absolute.
+type TaskDir struct {
+	Dir        string
+	TmpDir     string
+	DataDir    string
+	SecretsDir string
+	LogsDir    string
+
+	alloc *AllocDir
+	// built is set by Build. guarded by the lock of alloc
+	built bool
+}
+
+// NewAllocDir returns the dir of the allocation under the alloc dir of the client, whose dirs
+// are created with perms. Nothing is created until Build.
+func NewAllocDir(root, allocID string, perms Perms) *AllocDir {
+	return &AllocDir{
+		AllocDir: filepath.Join(root, allocID),
+		perms:    perms,
+		taskDirs: make(map[string]*TaskDir),
+	}
+}
+
+// ReportPath returns the path of the report of the allocation.
+func ReportPath(root, allocID string) string {
+	return filepath.Join(root, reportsDir, allocID+".json")
+}
+
+// NewTaskDir adds the dir of a task. Nothing is created until TaskDir.Build.
+func (d *AllocDir) NewTaskDir(name string) *TaskDir {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if td, ok := d.taskDirs[name]; ok {
+		return td
+	}
+	dir := filepath.Join(d.AllocDir, name)
+	td := &TaskDir{
+		Dir:        dir,
+		TmpDir:     filepath.Join(dir, TaskTmp),
+		DataDir:    filepath.Join(dir, TaskData),
+		SecretsDir: filepath.Join(dir, TaskSecrets),
+		LogsDir:    filepath.Join(dir, TaskLogs),
+		alloc:      d,
+	}
+	d.taskDirs[name] = td
+	return td
+}
+
+// Build creates the dir of the allocation.
+func (d *AllocDir) Build() error {
+	if err := d.perms.MkdirAll(d.AllocDir, DefaultDirMode); err != nil {
+		return fmt.Errorf("failed to create alloc dir %v: %v", d.AllocDir, err)
+	}
+	return nil
+}
+
+// DataKey returns the data key of the allocation, generating it on the first call. The tasks
+// encrypt their data files with it, see atrest.
+func (d *AllocDir) DataKey() ([]byte, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.perms.mkdirSecrets(filepath.Dir(d.dataKeyPath())); err != nil {
+		return nil, err
+	}
+	key, err := atrest.LoadOrCreateKey(d.dataKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	d.key = key
+	return key, nil
+}
+
+func (d *AllocDir) dataKeyPath() string {
+	return filepath.Join(d.AllocDir, TaskSecrets, dataKey)
+}
+
+// Destroy removes the dir of the allocation with the dirs of all its tasks. The data key is
+// shredded first.
+func (d *AllocDir) Destroy() error {
+	if err := atrest.DestroyKey(d.dataKeyPath()); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(d.AllocDir); err != nil {
+		return fmt.Errorf("failed to remove alloc dir %v: %v", d.AllocDir, err)
+	}
+	return nil
+}
+
+// Build creates the dirs of the task. It is called at each start of the task: tmp is
+// emptied, and the others are kept.
+func (t *TaskDir) Build() error {
+	if err := os.RemoveAll(t.TmpDir); err != nil {
+		return fmt.Errorf("failed to empty task tmp dir %v: %v", t.TmpDir, err)
+	}
+	var perms Perms
+	if t.alloc != nil {
+		perms = t.alloc.perms
+	}
+	for _, dir := range []string{t.Dir, t.TmpDir, t.DataDir, t.LogsDir} {
+		if err := perms.MkdirAll(dir, DefaultDirMode); err != nil {
+			return fmt.Errorf("failed to create task dir %v: %v", dir, err)
+		}
+	}
+	if err := perms.mkdirSecrets(t.SecretsDir); err != nil {
+		return fmt.Errorf("failed to create task dir %v: %v", t.SecretsDir, err)
+	}
+	if t.alloc != nil {
+		t.alloc.lock.Lock()
+		t.built = true
+		t.alloc.lock.Unlock()
+	}
+	return nil
+}
+
+// Repair recreates the dirs of the allocation and of its built tasks which were removed while it
+// runs, e.g. by hand, and returns them. The data key is written again if it was loaded. Else,
+// with encrypted, the allocation encrypts its data files: ErrDataKeyLost if data files remain.
+func (d *AllocDir) Repair(encrypted bool) (repaired []string, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	mkdir := func(dir string, secrets bool) error {
+		if _, err := os.Stat(dir); err == nil || !os.IsNotExist(err) {
+			return err
+		}
+		repaired = append(repaired, dir)
+		if secrets {
+			return d.perms.mkdirSecrets(dir)
+		}
+		return d.perms.MkdirAll(dir, DefaultDirMode)
+	}
+	if err := mkdir(d.AllocDir, false); err != nil {
+		return repaired, fmt.Errorf("failed to recreate alloc dir %v: %v", d.AllocDir, err)
+	}
+	for _, t := range d.taskDirs {
+		if !t.built {
+			continue
+		}
+		for _, dir := range []string{t.Dir, t.TmpDir, t.DataDir, t.LogsDir, t.SecretsDir} {
+			if err := mkdir(dir, dir == t.SecretsDir); err != nil {
+				return repaired, fmt.Errorf("failed to recreate task dir %v: %v", dir, err)
+			}
+		}
+	}
+
+	path := d.dataKeyPath()
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return repaired, err
+	}
+	if d.key != nil {
+		if err := mkdir(filepath.Dir(path), true); err != nil {
+			return repaired, fmt.Errorf("failed to recreate the dir of the data key: %v", err)
+		}
+		if err := atrest.StoreKey(path, d.key); err != nil {
+			return repaired, fmt.Errorf("failed to write the data key again: %v", err)
+		}
+		return append(repaired, path), nil
+	}
+	if encrypted && d.hasDataFiles() {
+		return repaired, ErrDataKeyLost
+	}
+	return repaired, nil
+}
+
+// hasDataFiles tells whether the data dir of any task of the allocation has files, built or not,
+// e.g. of an allocation restored after a restart of the client.
+func (d *AllocDir) hasDataFiles() bool {
+	matches, _ := filepath.Glob(filepath.Join(d.AllocDir, "*", TaskData, "*"))
+	return len(matches) > 0
+}
+
+// DataKey returns the data key of the allocation of the task, see AllocDir.DataKey.
+func (t *TaskDir) DataKey() ([]byte, error) {
+	if t.alloc == nil {
+		return nil, fmt.Errorf("task dir %v has no allocation", t.Dir)
+	}
+	return t.alloc.DataKey()
+}
+
+// Layout implements AllocDirFS.
+func (d *AllocDir) Layout() map[string]*TaskLayout {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	layout := make(map[string]*TaskLayout, len(d.taskDirs))
+	for name := range d.taskDirs {
+		layout[name] = &TaskLayout{
+			Tmp:     filepath.Join(name, TaskTmp),
+			Data:    filepath.Join(name, TaskData),
+			Secrets: filepath.Join(name, TaskSecrets),
+			Logs:    filepath.Join(name, TaskLogs),
+		}
+	}
+	return layout
+}
+
+// List implements AllocDirFS.
+func (d *AllocDir) List(path string) ([]*AllocFileInfo, error) {
+	p, err := d.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*AllocFileInfo, 0, len(infos))
+	for _, info := range infos {
+		files = append(files, fileInfo(info))
+	}
+	return files, nil
+}
+
+// Stat implements AllocDirFS.
+func (d *AllocDir) Stat(path string) (*AllocFileInfo, error) {
+	p, err := d.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(info), nil
+}
+
+// resolve returns the absolute path of a path relative to the alloc dir. It must not
+// be out of the alloc dir, or in a secrets dir.
+func (d *AllocDir) resolve(path string) (string, error) {
+	rel := filepath.Clean(string(filepath.Separator) + path)[1:]
+	parts := strings.Split(rel, string(filepath.Separator))
+	if parts[0] == TaskSecrets || (len(parts) >= 2 && parts[1] == TaskSecrets) {
+		return "", ErrNotBrowsable
+	}
+	return filepath.Join(d.AllocDir, rel), nil
+}
+
+func fileInfo(info os.FileInfo) *AllocFileInfo {
+	return &AllocFileInfo{
+		Name:     info.Name(),
+		IsDir:    info.IsDir(),
+		Size:     info.Size(),
+		FileMode: info.Mode().String(),
+		ModTime:  info.ModTime(),
+	}
+}
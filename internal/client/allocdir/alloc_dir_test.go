@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package allocdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "allocdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	d := NewAllocDir(root, "alloc1", Perms{})
+	if err := d.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	td := d.NewTaskDir("Dest")
+	if err := td.Build(); err != nil {
+		t.Fatalf("TaskDir.Build() error = %v", err)
+	}
+	for _, dir := range []string{td.TmpDir, td.DataDir, td.LogsDir} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a restart of the task
+	if err := td.Build(); err != nil {
+		t.Fatalf("TaskDir.Build() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(td.TmpDir, "f")); !os.IsNotExist(err) {
+		t.Errorf("tmp file after restart: err = %v, want removed", err)
+	}
+	for _, dir := range []string{td.DataDir, td.LogsDir} {
+		if _, err := os.Stat(filepath.Join(dir, "f")); err != nil {
+			t.Errorf("%v file after restart: err = %v, want kept", dir, err)
+		}
+	}
+	if info, err := os.Stat(td.SecretsDir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("secrets dir: %v, %v, want mode 0700", info, err)
+	}
+
+	layout := d.Layout()
+	if l := layout["Dest"]; l == nil || l.Data != filepath.Join("Dest", TaskData) {
+		t.Errorf("Layout() = %v, want the dirs of task Dest", layout)
+	}
+	files, err := d.List(layout["Dest"].Data)
+	if err != nil || len(files) != 1 || files[0].Name != "f" || files[0].Size != 1 {
+		t.Errorf("List() = %v, %v, want file f", files, err)
+	}
+	if _, err := d.List(layout["Dest"].Secrets); err != ErrNotBrowsable {
+		t.Errorf("List() of secrets: err = %v, want %v", err, ErrNotBrowsable)
+	}
+	if info, err := d.Stat("../../" + filepath.Base(root)); err == nil {
+		t.Errorf("Stat() out of the alloc dir = %v, want an error", info)
+	}
+
+	key, err := td.DataKey()
+	if err != nil || len(key) != 32 {
+		t.Fatalf("DataKey() = %v, %v, want a key", key, err)
+	}
+	if again, err := d.DataKey(); err != nil || string(again) != string(key) {
+		t.Errorf("DataKey() again = %v, %v, want the same key", again, err)
+	}
+	if info, err := os.Stat(d.dataKeyPath()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("data key: %v, %v, want mode 0600", info, err)
+	}
+	if _, err := d.Stat(filepath.Join(TaskSecrets, dataKey)); err != ErrNotBrowsable {
+		t.Errorf("Stat() of the data key: err = %v, want %v", err, ErrNotBrowsable)
+	}
+
+	if err := d.Destroy(); err != nil {
+		t.Fatalf("Destroy() error = %v", err)
+	}
+	if _, err := os.Stat(d.AllocDir); !os.IsNotExist(err) {
+		t.Errorf("alloc dir after Destroy(): err = %v, want removed", err)
+	}
+}
+
+func TestAllocDir_Repair(t *testing.T) {
+	root, err := ioutil.TempDir("", "allocdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	d := NewAllocDir(root, "alloc1", Perms{})
+	if err := d.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	td := d.NewTaskDir("Dest")
+	if err := td.Build(); err != nil {
+		t.Fatalf("TaskDir.Build() error = %v", err)
+	}
+	if repaired, err := d.Repair(true); err != nil || len(repaired) != 0 {
+		t.Errorf("Repair() of intact dirs = %v, %v, want nothing", repaired, err)
+	}
+	key, err := d.DataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dirs and the key are removed while the allocation runs
+	if err := os.RemoveAll(d.AllocDir); err != nil {
+		t.Fatal(err)
+	}
+	repaired, err := d.Repair(true)
+	if err != nil || len(repaired) != 8 || repaired[7] != d.dataKeyPath() {
+		t.Errorf("Repair() = %v, %v, want the alloc dir, the 5 dirs of the task, the secrets dir and the key",
+			repaired, err)
+	}
+	for _, dir := range []string{td.TmpDir, td.DataDir, td.LogsDir} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%v after Repair(): err = %v, want recreated", dir, err)
+		}
+	}
+	if info, err := os.Stat(td.SecretsDir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("secrets dir after Repair(): %v, %v, want mode 0700", info, err)
+	}
+	if again, err := NewAllocDir(root, "alloc1", Perms{}).DataKey(); err != nil || string(again) != string(key) {
+		t.Errorf("DataKey() after Repair() = %v, %v, want the same key", again, err)
+	}
+
+	// a restored allocation never loaded its key: the encrypted data files cannot be read
+	restored := NewAllocDir(root, "alloc1", Perms{})
+	if err := ioutil.WriteFile(filepath.Join(td.DataDir, "f"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(d.dataKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Repair(false); err != nil {
+		t.Errorf("Repair() without encryption: err = %v, want nil", err)
+	}
+	if _, err := restored.Repair(true); err != ErrDataKeyLost {
+		t.Errorf("Repair() of the lost key: err = %v, want %v", err, ErrDataKeyLost)
+	}
+}
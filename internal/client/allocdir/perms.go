@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package allocdir
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultDirMode is the mode of the dirs of the allocations and the tasks without a Mode.
+	DefaultDirMode os.FileMode = 0755
+	// secretsDirMode is the mode of the secrets dirs, whatever the Mode.
+	secretsDirMode os.FileMode = 0700
+)
+
+// Perms are the mode and the owner of the dirs created in the alloc dir, from the
+// alloc_dir_mode and alloc_dir_owner of the client.
+type Perms struct {
+	// Mode is set with chmod, so that the umask does not narrow it. 0 for the default
+	// mode of each dir, narrowed by the umask.
+	Mode os.FileMode
+	// the owner of the dirs if Chown, -1 to keep the user or the group of the agent
+	Chown    bool
+	Uid, Gid int
+}
+
+// ValidateDirMode checks mode is a mode the agent can use for its dirs without exposing them:
+// permission bits only, the owner having all of them, and not writable by others.
+func ValidateDirMode(mode os.FileMode) error {
+	if mode&^os.ModePerm != 0 {
+		return fmt.Errorf("dir mode %04o has bits other than the permissions", uint32(mode))
+	}
+	if mode&0700 != 0700 {
+		return fmt.Errorf("dir mode %04o lacks permissions of the owner. expect 07xx", uint32(mode))
+	}
+	if mode&0002 != 0 {
+		return fmt.Errorf("dir mode %04o is world-writable", uint32(mode))
+	}
+	return nil
+}
+
+// ParseDirMode parses an octal mode, e.g. "0750", and validates it. "" is 0.
+func ParseDirMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad dir mode %q. expect an octal mode, e.g. 0750", s)
+	}
+	return os.FileMode(mode), ValidateDirMode(os.FileMode(mode))
+}
+
+// ParsePerms returns the Perms of mode (see ParseDirMode) and owner, "<uid>:<gid>" with numeric
+// ids, either of which may be empty to be kept, e.g. ":1001". An empty owner is not chowned.
+func ParsePerms(mode, owner string) (Perms, error) {
+	var p Perms
+	var err error
+	if p.Mode, err = ParseDirMode(mode); err != nil {
+		return p, err
+	}
+	if owner == "" {
+		return p, nil
+	}
+	parts := strings.Split(owner, ":")
+	if len(parts) != 2 || (parts[0] == "" && parts[1] == "") {
+		return p, fmt.Errorf("bad dir owner %q. expect <uid>:<gid>", owner)
+	}
+	ids := []*int{&p.Uid, &p.Gid}
+	for i, part := range parts {
+		*ids[i] = -1
+		if part == "" {
+			continue
+		}
+		if *ids[i], err = strconv.Atoi(part); err != nil || *ids[i] < 0 {
+			return p, fmt.Errorf("bad dir owner %q. expect numeric ids", owner)
+		}
+	}
+	p.Chown = true
+	return p, nil
+}
+
+// MkdirAll creates dir, with Mode or else defaultMode, and sets its Mode and its owner.
+// Both are set on an existing dir too.
+func (p Perms) MkdirAll(dir string, defaultMode os.FileMode) error {
+	mode := p.Mode
+	if mode == 0 {
+		mode = defaultMode
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if p.Mode != 0 {
+		// MkdirAll does not change an existing dir, and its mode is narrowed by the umask
+		if err := os.Chmod(dir, p.Mode); err != nil {
+			return err
+		}
+	}
+	return p.chown(dir)
+}
+
+// mkdirSecrets creates a secrets dir, always 0700, owned as the other dirs.
+func (p Perms) mkdirSecrets(dir string) error {
+	if err := os.MkdirAll(dir, secretsDirMode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, secretsDirMode); err != nil {
+		return err
+	}
+	return p.chown(dir)
+}
+
+func (p Perms) chown(dir string) error {
+	if !p.Chown {
+		return nil
+	}
+	return os.Chown(dir, p.Uid, p.Gid)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package allocdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePerms(t *testing.T) {
+	tests := []struct {
+		mode, owner string
+		want        Perms
+		wantErr     bool
+	}{
+		{"", "", Perms{}, false},
+		{"0750", "", Perms{Mode: 0750}, false},
+		{"700", "1000:1001", Perms{Mode: 0700, Chown: true, Uid: 1000, Gid: 1001}, false},
+		{"", ":1001", Perms{Chown: true, Uid: -1, Gid: 1001}, false},
+		{"0777", "", Perms{}, true}, // world-writable
+		{"0650", "", Perms{}, true}, // the owner can not list it
+		{"04750", "", Perms{}, true},
+		{"rwx", "", Perms{}, true},
+		{"", "1000", Perms{}, true},
+		{"", "admin:admin", Perms{}, true},
+		{"", ":", Perms{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePerms(tt.mode, tt.owner)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePerms(%q, %q) error = %v, wantErr %v", tt.mode, tt.owner, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePerms(%q, %q) = %+v, want %+v", tt.mode, tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestAllocDir_perms(t *testing.T) {
+	root, err := ioutil.TempDir("", "allocdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// the owner is the agent's, which it can always chown to
+	perms := Perms{Mode: 0750, Chown: true, Uid: os.Getuid(), Gid: os.Getgid()}
+	d := NewAllocDir(root, "alloc1", perms)
+	if err := d.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	td := d.NewTaskDir("Src")
+	// an existing dir is changed too
+	if err := os.MkdirAll(td.DataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := td.Build(); err != nil {
+		t.Fatalf("TaskDir.Build() error = %v", err)
+	}
+	for _, dir := range []string{d.AllocDir, td.Dir, td.TmpDir, td.DataDir, td.LogsDir} {
+		if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0750 {
+			t.Errorf("%v: %v, %v, want mode 0750", dir, info, err)
+		}
+	}
+	if _, err := td.DataKey(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{td.SecretsDir, filepath.Join(d.AllocDir, TaskSecrets)} {
+		if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+			t.Errorf("%v: %v, %v, want mode 0700", dir, info, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// maxAttrDiffs is how many diffs of the node attributes are kept.
+	maxAttrDiffs = 20
+
+	// attrNodeClass and attrSchedulingEligibility are the keys of the node
+	// fields compared along with the attributes.
+	attrNodeClass             = "node.class"
+	attrSchedulingEligibility = "node.scheduling_eligibility"
+)
+
+// AttrChange is the old and new values of a changed node attribute.
+type AttrChange struct {
+	Old string
+	New string
+}
+
+// AttrDiff is a change of the node attributes, found by watchNodeUpdates.
+type AttrDiff struct {
+	Time    time.Time
+	Added   map[string]string
+	Removed map[string]string
+	Changed map[string]AttrChange
+}
+
+// String lists the changed keys, sorted, for the log.
+func (d *AttrDiff) String() string {
+	var parts []string
+	for k, v := range d.Added {
+		parts = append(parts, fmt.Sprintf("+%v=%q", k, v))
+	}
+	for k, v := range d.Removed {
+		parts = append(parts, fmt.Sprintf("-%v=%q", k, v))
+	}
+	for k, c := range d.Changed {
+		parts = append(parts, fmt.Sprintf("%v: %q -> %q", k, c.Old, c.New))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// modules counts the changed keys by module, the part of the key before the
+// first ".", e.g. "driver" for "driver.MySQL".
+func (d *AttrDiff) modules() map[string]int {
+	counts := make(map[string]int)
+	count := func(key string) {
+		counts[strings.SplitN(key, ".", 2)[0]]++
+	}
+	for k := range d.Added {
+		count(k)
+	}
+	for k := range d.Removed {
+		count(k)
+	}
+	for k := range d.Changed {
+		count(k)
+	}
+	return counts
+}
+
+// nodeAttrs returns the attributes of node, with the node fields which are
+// also watched for changes. It must be called with configLock held.
+func nodeAttrs(node *models.Node) map[string]string {
+	attrs := make(map[string]string, len(node.Attributes)+2)
+	for k, v := range node.Attributes {
+		attrs[k] = v
+	}
+	attrs[attrNodeClass] = node.NodeClass
+	attrs[attrSchedulingEligibility] = node.SchedulingEligibility
+	return attrs
+}
+
+// diffAttrs returns the changes from old to new, or nil if there is none.
+func diffAttrs(old, new map[string]string, now time.Time) *AttrDiff {
+	d := &AttrDiff{
+		Time:    now,
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string]AttrChange),
+	}
+	for k, v := range new {
+		if o, ok := old[k]; !ok {
+			d.Added[k] = v
+		} else if o != v {
+			d.Changed[k] = AttrChange{Old: o, New: v}
+		}
+	}
+	for k, v := range old {
+		if _, ok := new[k]; !ok {
+			d.Removed[k] = v
+		}
+	}
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return nil
+	}
+	return d
+}
+
+// recordAttrDiff logs a change of the node attributes, keeps it for AttrDiffs
+// and counts the changed keys per module in the client.attribute_changes metric.
+func (c *Client) recordAttrDiff(d *AttrDiff) {
+	c.logger.Printf("agent: Node attributes changed: %v", d)
+	for module, n := range d.modules() {
+		metrics.IncrCounterWithLabels([]string{"client", "attribute_changes"}, float32(n),
+			[]metrics.Label{{Name: "module", Value: module}})
+	}
+
+	c.attrDiffsLock.Lock()
+	defer c.attrDiffsLock.Unlock()
+	c.attrDiffs = append(c.attrDiffs, d)
+	if len(c.attrDiffs) > maxAttrDiffs {
+		c.attrDiffs = c.attrDiffs[len(c.attrDiffs)-maxAttrDiffs:]
+	}
+}
+
+// AttrDiffs returns the last changes of the node attributes, oldest first.
+func (c *Client) AttrDiffs() []*AttrDiff {
+	c.attrDiffsLock.Lock()
+	defer c.attrDiffsLock.Unlock()
+	return append([]*AttrDiff(nil), c.attrDiffs...)
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// maxRetryBackoff caps the exponential backoff of the retry loops.
+	maxRetryBackoff = 5 * time.Minute
+
+	// busyBackoffSteps is how many more doublings of the backoff a failure
+	// of the servers being busy, or without a leader, counts for.
+	busyBackoffSteps = 2
+
+	// The names of the retry loops in Client.Stats.
+	backoffRegister         = "register"
+	backoffHeartbeat        = "heartbeat"
+	backoffAllocSync        = "alloc_sync"
+	backoffWatchAllocations = "watch_allocations"
+)
+
+// isServerBusy returns whether err tells the client to try later: the server
+// is busy or the servers have no leader. The errors are compared by message as
+// they are strings once returned over RPC.
+func isServerBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, models.ErrServerBusy.Error()) ||
+		strings.Contains(msg, models.ErrNoLeader.Error())
+}
+
+// backoff is the exponential backoff with full jitter of a retry loop. The
+// n-th consecutive failure waits a random duration up to base*2^n, capped
+// at max. It is safe for concurrent use so it can be read by Client.Stats.
+type backoff struct {
+	base, max time.Duration
+
+	l        sync.Mutex
+	attempt  uint
+	busy     bool
+	last     time.Duration
+	lastFail time.Time
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+// Next records a failure with err and returns how long to wait before the
+// retry. A busy server doubles the backoff busyBackoffSteps more times and
+// the wait is at least half of it.
+func (b *backoff) Next(err error) time.Duration {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	b.busy = isServerBusy(err)
+	ceil := b.ceil()
+	var wait time.Duration
+	if b.busy {
+		wait = ceil/2 + time.Duration(rand.Int63n(int64(ceil/2)+1))
+		b.attempt += busyBackoffSteps
+	} else {
+		wait = time.Duration(rand.Int63n(int64(ceil) + 1))
+	}
+	b.attempt++
+	if wait < time.Millisecond {
+		// e.g. for a ticker
+		wait = time.Millisecond
+	}
+	b.last = wait
+	b.lastFail = time.Now()
+	return wait
+}
+
+// ceil returns the upper bound of the next wait. It must be called with l held.
+func (b *backoff) ceil() time.Duration {
+	ceil := b.base
+	for i := uint(0); i < b.attempt && ceil < b.max; i++ {
+		ceil *= 2
+	}
+	if ceil > b.max {
+		ceil = b.max
+	}
+	return ceil
+}
+
+// Reset records a success.
+func (b *backoff) Reset() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.attempt = 0
+	b.busy = false
+	b.last = 0
+}
+
+// String describes the state of the backoff for Client.Stats.
+func (b *backoff) String() string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.attempt == 0 {
+		return "idle"
+	}
+	return fmt.Sprintf("attempt=%d busy=%v last_wait=%v last_failure=%v",
+		b.attempt, b.busy, b.last, b.lastFail.Format(time.RFC3339))
+}
+
+// rpcClassRate is the token bucket rate limit of the RPCs of a method class.
+type rpcClassRate struct {
+	perSecond float64
+	burst     float64
+}
+
+// rpcClassRates caps the RPC rate of the client against the servers per class
+// of methods. The methods of no class are of the "default" one.
+var rpcClassRates = map[string]rpcClassRate{
+	"heartbeat":    {perSecond: 1, burst: 5},
+	"alloc_update": {perSecond: 10, burst: 20},
+	"alloc_query":  {perSecond: 10, burst: 20},
+	"default":      {perSecond: 20, burst: 40},
+}
+
+// rpcMethodClasses maps the RPC methods to their class in rpcClassRates.
+var rpcMethodClasses = map[string]string{
+	"Node.Register":        "heartbeat",
+	"Node.UpdateStatus":    "heartbeat",
+	"Node.UpdateAlloc":     "alloc_update",
+	"Node.UpdateJob":       "alloc_update",
+	"Node.GetClientAllocs": "alloc_query",
+	"Node.GetNode":         "alloc_query",
+	"Alloc.GetAlloc":       "alloc_query",
+	"Alloc.GetAllocs":      "alloc_query",
+}
+
+// rpcLimiter holds a token bucket per method class.
+type rpcLimiter struct {
+	l       sync.Mutex
+	buckets map[string]*tokenBucket
+	// throttled is how many RPCs had to wait for a token
+	throttled int64
+}
+
+func newRPCLimiter(rates map[string]rpcClassRate) *rpcLimiter {
+	r := &rpcLimiter{buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	for class, rate := range rates {
+		r.buckets[class] = &tokenBucket{rate: rate, tokens: rate.burst, last: now}
+	}
+	return r
+}
+
+// reserve takes a token for method and returns how long to wait before
+// calling it.
+func (r *rpcLimiter) reserve(method string, now time.Time) time.Duration {
+	class, ok := rpcMethodClasses[method]
+	if !ok {
+		class = "default"
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	b, ok := r.buckets[class]
+	if !ok {
+		return 0
+	}
+	wait := b.reserve(now)
+	if wait > 0 {
+		r.throttled++
+	}
+	return wait
+}
+
+// Throttled returns how many RPCs had to wait. A nil limiter throttles none.
+func (r *rpcLimiter) Throttled() int64 {
+	if r == nil {
+		return 0
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.throttled
+}
+
+type tokenBucket struct {
+	rate   rpcClassRate
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, possibly in debt, and returns how long until it is
+// available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate.perSecond
+		if b.tokens > b.rate.burst {
+			b.tokens = b.rate.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate.perSecond * float64(time.Second))
+}
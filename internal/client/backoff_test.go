@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestBackoff_Next(t *testing.T) {
+	b := newBackoff(time.Second, 10*time.Second)
+	failure := fmt.Errorf("connection refused")
+	for i, ceil := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		if wait := b.Next(failure); wait > ceil*time.Second {
+			t.Errorf("failure %v: wait = %v, want at most %vs", i, wait, ceil)
+		}
+	}
+	if got := b.String(); got == "idle" {
+		t.Errorf("String() = idle after failures")
+	}
+
+	b.Reset()
+	if got := b.String(); got != "idle" {
+		t.Errorf("String() = %v after Reset, want idle", got)
+	}
+
+	// A busy server is backed off harder: at least half of the ceiling,
+	// and the ceiling grows faster.
+	busy := fmt.Errorf("failed to update status: %v", models.ErrServerBusy)
+	if wait := b.Next(busy); wait < 500*time.Millisecond || wait > time.Second {
+		t.Errorf("busy wait = %v, want between 0.5s and 1s", wait)
+	}
+	if wait := b.Next(busy); wait < 4*time.Second || wait > 8*time.Second {
+		t.Errorf("second busy wait = %v, want between 4s and 8s", wait)
+	}
+}
+
+func TestIsServerBusy(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{fmt.Errorf("EOF"), false},
+		{models.ErrServerBusy, true},
+		{fmt.Errorf("RPC failed to server 127.0.0.1:8191: %v", models.ErrServerBusy.Error()), true},
+		{fmt.Errorf("rpc error: %v", models.ErrNoLeader), true},
+	}
+	for _, tt := range tests {
+		if got := isServerBusy(tt.err); got != tt.want {
+			t.Errorf("isServerBusy(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRPCLimiter_reserve(t *testing.T) {
+	r := newRPCLimiter(map[string]rpcClassRate{
+		"heartbeat": {perSecond: 1, burst: 2},
+		"default":   {perSecond: 10, burst: 1},
+	})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if wait := r.reserve("Node.UpdateStatus", now); wait != 0 {
+			t.Fatalf("heartbeat %v: wait = %v within the burst", i, wait)
+		}
+	}
+	if wait := r.reserve("Node.Register", now); wait != time.Second {
+		t.Errorf("wait = %v beyond the burst, want 1s", wait)
+	}
+	// Other classes are not affected.
+	if wait := r.reserve("Job.List", now); wait != 0 {
+		t.Errorf("default class wait = %v, want 0", wait)
+	}
+	if wait := r.reserve("Job.List", now); wait != 100*time.Millisecond {
+		t.Errorf("default class wait = %v beyond the burst, want 100ms", wait)
+	}
+	// The tokens are refilled with time. The heartbeat class is one in debt.
+	if wait := r.reserve("Node.UpdateStatus", now.Add(3*time.Second)); wait != 0 {
+		t.Errorf("wait = %v after a refill, want 0", wait)
+	}
+	if got := r.Throttled(); got != 2 {
+		t.Errorf("Throttled() = %v, want 2", got)
+	}
+}
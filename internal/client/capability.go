@@ -0,0 +1,49 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// CapabilityMissingError is returned for an allocation needing a driver or a
+// feature the node does not advertise.
+type CapabilityMissingError struct {
+	// Capability is the node attr, e.g. "driver.MySQL" or "feature.load_data".
+	Capability string
+}
+
+func (e *CapabilityMissingError) Error() string {
+	return fmt.Sprintf("capability missing: %v", e.Capability)
+}
+
+// checkCapabilities checks the driver and the constraints of the allocation's
+// task against the capabilities of the node.
+func (c *Client) checkCapabilities(alloc *models.Allocation) error {
+	if alloc.Job == nil {
+		return nil
+	}
+	task := alloc.Job.LookupTask(alloc.Task)
+	if task == nil {
+		return nil
+	}
+	constraints := task.Constraints
+	if task.Driver != "" {
+		constraints = append([]*models.Constraint{
+			models.CapabilityConstraint(models.NodeAttrDriverPrefix + task.Driver)}, constraints...)
+	}
+
+	c.configLock.RLock()
+	missing := c.config.Node.MissingCapability(constraints)
+	c.configLock.RUnlock()
+	if missing != "" {
+		return &CapabilityMissingError{Capability: missing}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -18,6 +19,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -25,14 +27,20 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/hashstructure"
 	gnatsd "github.com/nats-io/gnatsd/server"
+	gonats "github.com/nats-io/go-nats"
 	stand "github.com/nats-io/nats-streaming-server/server"
 	"github.com/shirou/gopsutil/host"
 
 	"github.com/actiontech/dtle/internal"
+	"github.com/actiontech/dtle/internal/auxdisk"
+	"github.com/actiontech/dtle/internal/client/allocdir"
 	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/faults"
 	ulog "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/resolver"
 	"github.com/actiontech/dtle/internal/server"
 )
 
@@ -55,9 +63,22 @@ const (
 
 	getJobRetryIntv = 5 * time.Second
 
-	// stateSnapshotIntv is how often the client snapshots state
+	// stateSnapshotIntv is how often the client snapshots state, if not
+	// configured.
 	stateSnapshotIntv = 60 * time.Second
 
+	// stateSnapshotCheckIntv is how often the transactions replicated since
+	// the last snapshot are checked against StateSnapshotTxDelta, if set.
+	stateSnapshotCheckIntv = time.Second
+
+	// defaultStateSnapshotTxDelta is how many transactions a task replicates
+	// before its allocation is snapshotted, if not configured.
+	defaultStateSnapshotTxDelta = 1
+
+	// defaultStateSnapshotsRetained is how many snapshots of an allocation
+	// are kept, if not configured.
+	defaultStateSnapshotsRetained = 2
+
 	// initialHeartbeatStagger is used to stagger the interval between
 	// starting and the intial heartbeat. After the intial heartbeat,
 	// we switch to using the TTL specified by the servers.
@@ -74,6 +95,38 @@ const (
 	// allocSyncRetryIntv is the interval on which we retry updating
 	// the status of the allocation
 	allocSyncRetryIntv = 5 * time.Second
+
+	// defaultAllocUpdatesBufferSize is the capacity of allocUpdates if not
+	// configured.
+	defaultAllocUpdatesBufferSize = 64
+
+	// maxPendingAllocUpdates bounds the allocation updates buffered while
+	// the servers are unreachable.
+	maxPendingAllocUpdates = 1024
+
+	// pendingAllocUpdatesFile is where the buffered allocation updates are
+	// persisted, relative to the state dir.
+	pendingAllocUpdatesFile = "client/pending_alloc_updates.json"
+
+	// setApplierWorkersTimeout is how long SetApplierWorkers waits for the
+	// transactions in progress to be committed before the resize.
+	setApplierWorkersTimeout = time.Minute
+
+	// quiesceTimeout is how long Quiesce waits for the target to apply the
+	// final position of the source.
+	quiesceTimeout = 5 * time.Minute
+
+	// defaultAllocShutdownTimeout is how long the tasks of an allocation are
+	// waited to stop on destroy, if not configured.
+	defaultAllocShutdownTimeout = 30 * time.Second
+
+	// defaultHeartbeatGraceFactor is ClientConfig.HeartbeatGraceFactor if
+	// unset.
+	defaultHeartbeatGraceFactor = 1.5
+
+	// natsReadyTimeout is how long the client waits for the embedded nats
+	// server to accept connections, before any task is started.
+	natsReadyTimeout = 10 * time.Second
 )
 
 // ClientStatsReporter exposes all the APIs related to resource usage of a Udup
@@ -107,6 +160,12 @@ type Client struct {
 	heartbeatTTL  time.Duration
 	heartbeatLock sync.Mutex
 
+	// noLeaderSince is when the servers started to report no leader, zero
+	// while they report one. consulFallbackActive is whether the servers are
+	// looked for in Consul since. Both are guarded by heartbeatLock.
+	noLeaderSince        time.Time
+	consulFallbackActive bool
+
 	// triggerDiscoveryCh triggers Consul discovery; see triggerDiscovery
 	triggerDiscoveryCh chan struct{}
 
@@ -132,6 +191,53 @@ type Client struct {
 
 	workUpdates chan *models.TaskUpdate
 
+	// degraded is 1 while none of the servers is reachable
+	degraded int32
+
+	// the last changes of the node attributes, oldest first
+	attrDiffs     []*AttrDiff
+	attrDiffsLock sync.Mutex
+
+	// the allocations last pulled from the servers and the start of the
+	// mismatches of their status, by ID, and the reconciliation actions
+	// taken, by action. See reconcileAllocs.
+	pulledAllocs     map[string]*models.Allocation
+	allocMismatches  map[string]time.Time
+	reconcileActions map[string]int64
+	reconcileLock    sync.Mutex
+
+	// rpcLimiter caps the rate of the RPCs to the servers
+	rpcLimiter *rpcLimiter
+
+	// backoffs of the long lived retry loops, by name
+	backoffs map[string]*backoff
+
+	// allocations saved and skipped by the last periodic snapshot
+	snapshotSaved   int64
+	snapshotSkipped int64
+
+	// clock of the long lived loops, the real one if nil
+	clock clock
+	// heartbeatNowCh triggers a heartbeat out of schedule
+	heartbeatNowCh chan struct{}
+	// jumps of the wall clock detected by watchClock
+	clockJumpsForward  int64
+	clockJumpsBackward int64
+
+	// open files of the client and their limit, by watchOpenFiles
+	openFiles    int64
+	maxOpenFiles uint64
+
+	// the runtime of the client, sampled by watchRuntime
+	selfMonitor selfMonitor
+
+	// the last events of the node, oldest first
+	nodeEvents     []*models.NodeEvent
+	nodeEventsLock sync.Mutex
+	// the events not yet sent to the servers, on the next registration or
+	// heartbeat. Guarded by nodeEventsLock.
+	pendingNodeEvents []*models.NodeEvent
+
 	stand *stand.StanServer
 
 	shutdown     bool
@@ -176,6 +282,11 @@ var (
 
 // NewClient is used to create a new client from the given configuration
 func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
+	allocUpdatesBufferSize := cfg.AllocUpdatesBufferSize
+	if allocUpdatesBufferSize <= 0 {
+		allocUpdatesBufferSize = defaultAllocUpdatesBufferSize
+	}
+
 	// Create the client
 	c := &Client{
 		config:              cfg,
@@ -184,13 +295,38 @@ func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
 		logger:              logger,
 		allocs:              make(map[string]*Allocator),
 		blockedAllocations:  make(map[string]*models.Allocation),
-		allocUpdates:        make(chan *models.Allocation, 64),
+		allocUpdates:        make(chan *models.Allocation, allocUpdatesBufferSize),
 		workUpdates:         make(chan *models.TaskUpdate, 64),
 		shutdownCh:          make(chan struct{}),
 		migratingAllocs:     make(map[string]*migrateAllocCtrl),
 		servers:             newServerList(),
 		triggerDiscoveryCh:  make(chan struct{}),
 		serversDiscoveredCh: make(chan struct{}),
+		rpcLimiter:          newRPCLimiter(rpcClassRates),
+		clock:               realClock{},
+		heartbeatNowCh:      make(chan struct{}, 1),
+		backoffs: map[string]*backoff{
+			backoffRegister:         newBackoff(registerRetryIntv, maxRetryBackoff),
+			backoffHeartbeat:        newBackoff(registerRetryIntv, maxRetryBackoff),
+			backoffAllocSync:        newBackoff(allocSyncRetryIntv, maxRetryBackoff),
+			backoffWatchAllocations: newBackoff(getAllocRetryIntv, maxRetryBackoff),
+		},
+	}
+
+	auxDisk, err := auxdisk.NewBudget(cfg.AuxDiskBudget, auxdisk.Policy(cfg.AuxDiskPolicy))
+	if err != nil {
+		return nil, err
+	}
+	cfg.AuxDisk = auxDisk
+
+	// The servers and the MySQL endpoints are resolved again on the reconnections
+	if err := resolver.Default.Configure(cfg.DNSPrefer, logger); err != nil {
+		return nil, fmt.Errorf("bad dns_prefer: %v", err)
+	}
+	umconf.UseResolver(resolver.Default)
+
+	if _, _, err := parseTaskOutputLevel(cfg.TaskOutputLogLevel); err != nil {
+		return nil, fmt.Errorf("bad task_output_log_level: %v", err)
 	}
 
 	// Initialize the client
@@ -202,13 +338,16 @@ func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
 	if err := c.setupNode(); err != nil {
 		return nil, fmt.Errorf("node setup failed: %v", err)
 	}
+	if err := c.checkOpenFilesLimit(c.fingerprintOpenFiles()); err != nil {
+		return nil, err
+	}
 
 	if err := c.setupNatsServer(); err != nil {
 		return nil, fmt.Errorf("nats server setup failed: %v", err)
 	}
 
 	// Scan for drivers
-	if err := c.setupDrivers(); err != nil {
+	if err := c.setupDrivers(driver.BuiltinDrivers); err != nil {
 		return nil, fmt.Errorf("driver setup failed: %v", err)
 	}
 
@@ -218,6 +357,11 @@ func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
 	c.configCopy = c.config.Copy()
 	c.configLock.Unlock()
 
+	// Restore the state
+	if err := c.restoreState(); err != nil {
+		return nil, fmt.Errorf("failed to restore state: %v", err)
+	}
+
 	// Set the preconfigured list of static servers
 	c.configLock.RLock()
 	if len(c.configCopy.Servers) > 0 {
@@ -227,15 +371,32 @@ func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
 	}
 	c.configLock.RUnlock()
 
+	// Look for the servers in Consul when triggered.
+	if c.consulFallbackEnabled() {
+		go c.consulDiscovery()
+	}
+
 	// Register and then start heartbeating to the servers.
 	go c.registerAndHeartbeat()
 
+	// Heartbeat at once after the host wakes up.
+	go c.watchClock()
+
+	// Warn before the open files run out.
+	go c.watchOpenFiles()
+
+	// Warn of the goroutines and the heap growing, e.g. on a leak.
+	go c.watchRuntime()
+
 	// Begin periodic snapshotting of state.
 	go c.periodicSnapshot()
 
 	// Begin syncing allocations to the server
 	go c.allocSync()
 
+	// Re-drive the allocations not matching the status wanted by the servers.
+	go c.reconcileAllocs()
+
 	// Start the client!
 	go c.run()
 
@@ -246,9 +407,19 @@ func NewClient(cfg *config.ClientConfig, logger *ulog.Logger) (*Client, error) {
 // init is used to initialize the client and perform any setup
 // needed before we begin starting its various components.
 func (c *Client) init() error {
+	stateDirMode, err := allocdir.ParseDirMode(c.config.StateDirMode)
+	if err != nil {
+		return fmt.Errorf("bad state_dir_mode: %v", err)
+	}
+	perms, err := allocdir.ParsePerms(c.config.AllocDirMode, c.config.AllocDirOwner)
+	if err != nil {
+		return fmt.Errorf("bad alloc_dir_mode or alloc_dir_owner: %v", err)
+	}
+	c.config.AllocDirPerms = perms
+
 	// Ensure the state dir exists if we have one
 	if c.config.StateDir != "" {
-		if err := os.MkdirAll(c.config.StateDir, 0700); err != nil {
+		if err := (allocdir.Perms{Mode: stateDirMode}).MkdirAll(c.config.StateDir, 0700); err != nil {
 			return fmt.Errorf("failed creating state dir: %s", err)
 		}
 
@@ -263,11 +434,22 @@ func (c *Client) init() error {
 		if err != nil {
 			return fmt.Errorf("failed to find temporary directory for the StateDir: %v", err)
 		}
+		if err := (allocdir.Perms{Mode: stateDirMode}).MkdirAll(p, 0700); err != nil {
+			return fmt.Errorf("failed setting up temporary directory for the StateDir: %v", err)
+		}
 
 		c.config.StateDir = p
 	}
 	c.logger.Printf("agent: Using state directory %v", c.config.StateDir)
 
+	if c.config.AllocDir == "" {
+		c.config.AllocDir = filepath.Join(c.config.StateDir, "allocdir")
+	}
+	if err := perms.MkdirAll(c.config.AllocDir, 0700); err != nil {
+		return fmt.Errorf("failed creating alloc dir: %s", err)
+	}
+	c.logger.Printf("agent: Using alloc directory %v", c.config.AllocDir)
+
 	return nil
 }
 
@@ -303,12 +485,17 @@ func (c *Client) Shutdown() error {
 	c.stand.Shutdown()
 	c.shutdown = true
 	close(c.shutdownCh)
+	c.config.AuxDisk.Close()
 	c.connPool.Shutdown()
 	return c.saveState()
 }
 
 // RPC is used to forward an RPC call to a server server, or fail if no servers.
 func (c *Client) RPC(method string, args interface{}, reply interface{}) error {
+	if err := faults.Inject(faults.RPCBeforeSend); err != nil {
+		return err
+	}
+
 	// Invoke the RPCHandler if it exists
 	if c.config.RPCHandler != nil {
 		return c.config.RPCHandler.RPC(method, args, reply)
@@ -316,26 +503,80 @@ func (c *Client) RPC(method string, args interface{}, reply interface{}) error {
 
 	servers := c.servers.all()
 	if len(servers) == 0 {
+		c.setDegraded(true)
 		return noServersErr
 	}
 
+	if c.rpcLimiter != nil {
+		if wait := c.rpcLimiter.reserve(method, time.Now()); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-c.shutdownCh:
+				return fmt.Errorf("aborting %v because client is shutting down", method)
+			}
+		}
+	}
+
 	var mErr multierror.Error
 	for _, s := range servers {
 		// Make the RPC request
-		if err := c.connPool.RPC(c.Region(), s.addr, method, args, reply); err != nil {
-			errmsg := fmt.Errorf("RPC failed to server %s: %v", s.addr, err)
+		addr := s.resolve()
+		if err := c.connPool.RPC(c.Region(), addr, method, args, reply); err != nil {
+			errmsg := fmt.Errorf("RPC failed to server %s: %v", addr, err)
 			mErr.Errors = append(mErr.Errors, errmsg)
 			c.logger.Debugf("agent: %v", errmsg)
 			c.servers.failed(s)
 			continue
 		}
 		c.servers.good(s)
+		c.setDegraded(false)
 		return nil
 	}
 
+	c.setDegraded(true)
 	return mErr.ErrorOrNil()
 }
 
+// setDegraded records whether any server is reachable. While degraded, the
+// allocations keep running with the state persisted on the client.
+func (c *Client) setDegraded(degraded bool) {
+	if degraded {
+		if atomic.CompareAndSwapInt32(&c.degraded, 0, 1) {
+			c.logger.Warnf("agent: No server is reachable. Running in degraded mode")
+		}
+	} else if atomic.CompareAndSwapInt32(&c.degraded, 1, 0) {
+		c.logger.Printf("agent: Servers are reachable again. Leaving degraded mode")
+	}
+}
+
+// Degraded returns whether none of the servers is reachable.
+func (c *Client) Degraded() bool {
+	return atomic.LoadInt32(&c.degraded) == 1
+}
+
+// HeartbeatHealthy returns whether the last heartbeat is within the TTL of the
+// node stretched by HeartbeatGraceFactor. Past it, the servers are about to
+// take the node as down, or already have. It is false before the first
+// heartbeat.
+func (c *Client) HeartbeatHealthy() bool {
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+	return c.heartbeatHealthy(time.Now())
+}
+
+// heartbeatHealthy is HeartbeatHealthy at now. It must be called with
+// heartbeatLock held.
+func (c *Client) heartbeatHealthy(now time.Time) bool {
+	if c.lastHeartbeat.IsZero() {
+		return false
+	}
+	factor := c.config.HeartbeatGraceFactor
+	if factor <= 0 {
+		factor = defaultHeartbeatGraceFactor
+	}
+	return now.Sub(c.lastHeartbeat) < time.Duration(float64(c.heartbeatTTL)*factor)
+}
+
 // Stats is used to return statistics for debugging and insight
 // for various sub-systems
 func (c *Client) Stats() map[string]map[string]string {
@@ -343,6 +584,11 @@ func (c *Client) Stats() map[string]map[string]string {
 	numAllocs := len(c.allocs)
 	c.allocLock.RUnlock()
 
+	c.configLock.RLock()
+	nodeClass := c.config.Node.NodeClass
+	eligibility := c.config.Node.SchedulingEligibility
+	c.configLock.RUnlock()
+
 	c.heartbeatLock.Lock()
 	defer c.heartbeatLock.Unlock()
 	stats := map[string]map[string]string{
@@ -352,8 +598,39 @@ func (c *Client) Stats() map[string]map[string]string {
 			"num_allocations": strconv.Itoa(numAllocs),
 			"last_heartbeat":  fmt.Sprintf("%v", time.Since(c.lastHeartbeat)),
 			"heartbeat_ttl":   fmt.Sprintf("%v", c.heartbeatTTL),
+			"degraded":        strconv.FormatBool(c.Degraded()),
+			"rpc_throttled":   strconv.FormatInt(c.rpcLimiter.Throttled(), 10),
+
+			"heartbeat_healthy": strconv.FormatBool(c.heartbeatHealthy(time.Now())),
+
+			"consul_fallback_enabled": strconv.FormatBool(c.consulFallbackEnabled()),
+			"consul_fallback_active":  strconv.FormatBool(c.consulFallbackActive),
+
+			"alloc_updates_backlog": strconv.Itoa(len(c.allocUpdates)),
+			"alloc_updates_buffer":  strconv.Itoa(cap(c.allocUpdates)),
+
+			"snapshot_saved":   strconv.FormatInt(atomic.LoadInt64(&c.snapshotSaved), 10),
+			"snapshot_skipped": strconv.FormatInt(atomic.LoadInt64(&c.snapshotSkipped), 10),
+
+			"clock_jumps_forward":  strconv.FormatInt(atomic.LoadInt64(&c.clockJumpsForward), 10),
+			"clock_jumps_backward": strconv.FormatInt(atomic.LoadInt64(&c.clockJumpsBackward), 10),
+
+			"reconcile_actions": strconv.FormatInt(c.reconcileActionsTotal(), 10),
+
+			"aux_disk_bytes": strconv.FormatInt(c.config.AuxDisk.Used(), 10),
+
+			"open_files":     strconv.FormatInt(atomic.LoadInt64(&c.openFiles), 10),
+			"max_open_files": strconv.FormatUint(atomic.LoadUint64(&c.maxOpenFiles), 10),
+
+			"node_class":             nodeClass,
+			"scheduling_eligibility": eligibility,
 		},
-		"runtime": internal.RuntimeStats(),
+		"runtime":      internal.RuntimeStats(),
+		"self_monitor": c.selfMonitorStats(),
+		"rpc_backoff":  make(map[string]string, len(c.backoffs)),
+	}
+	for name, b := range c.backoffs {
+		stats["rpc_backoff"][name] = b.String()
 	}
 	return stats
 }
@@ -391,6 +668,146 @@ func (c *Client) GetClientAlloc(allocID string) (*models.Allocation, error) {
 	return alloc, nil
 }
 
+// GetAllocFS returns the files of a running allocation.
+func (c *Client) GetAllocFS(allocID string) (allocdir.AllocDirFS, error) {
+	c.allocLock.RLock()
+	defer c.allocLock.RUnlock()
+	ar, ok := c.allocs[allocID]
+	if !ok {
+		return nil, fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+	if ar.allocDir == nil {
+		return nil, fmt.Errorf("allocation %q has no alloc dir", allocID)
+	}
+	return ar.allocDir, nil
+}
+
+// StopAlloc stops the allocation gracefully, within AllocShutdownTimeout, with a
+// clean checkpoint of its tasks. See Allocator.Stop.
+func (c *Client) StopAlloc(allocID string) error {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+
+	timeout := c.config.AllocShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultAllocShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c.logger.Printf("agent: Stopping alloc %q", allocID)
+	return ar.Stop(ctx)
+}
+
+// SetApplierWorkers resizes the pool of the workers applying the binlog in the Dest
+// task of the allocation to n, without a restart, until the task restarts. The
+// ParallelWorkers of the job is unchanged.
+func (c *Client) SetApplierWorkers(allocID string, n int) error {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), setApplierWorkersTimeout)
+	defer cancel()
+	c.logger.Printf("agent: Setting the applier workers of alloc %q to %v", allocID, n)
+	return ar.SetApplierWorkers(ctx, n)
+}
+
+// ResetAllocStats resets the counters of the tasks of the allocation, or of the task if set,
+// and returns them as they were. See Allocator.ResetStats.
+func (c *Client) ResetAllocStats(allocID, task, by string) (map[string]*models.TaskCounters, error) {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+	return ar.ResetStats(task, by)
+}
+
+// Quiesce is the cutover of the Dest task of the allocation to the target: with
+// sourceReadOnly, it sets the source read-only, then waits for the task to apply the
+// current position of the source, and saves the checkpoint. It returns the GTID set of
+// the source applied, for the operator to point the application at the target. The
+// source is made writable again on error. See Allocator.Quiesce.
+func (c *Client) Quiesce(allocID string, sourceReadOnly bool) (string, error) {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiesceTimeout)
+	defer cancel()
+	c.logger.Printf("agent: Quiescing alloc %q, source read-only: %v", allocID, sourceReadOnly)
+	return ar.Quiesce(ctx, sourceReadOnly)
+}
+
+// AllocConfig returns the configs the tasks of the allocation are running with, by task: with
+// the defaults and the secret references resolved, the secrets redacted, and the values resolved
+// by the running tasks, e.g. the tables matched by the filters of the job.
+func (c *Client) AllocConfig(allocID string) (map[string]*models.ResolvedTaskConfig, error) {
+	c.allocLock.RLock()
+	ar, ok := c.allocs[allocID]
+	c.allocLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown allocation ID %q", allocID)
+	}
+	return ar.EffectiveConfig()
+}
+
+// TaskLatency is the apply latency of the tables of a task, with the labels of the metrics of
+// the task.
+type TaskLatency struct {
+	Labels     []metrics.Label
+	Histograms []*models.LatencyHistogram
+}
+
+// ApplyLatency returns the apply latency of the tables of the running tasks, kept with
+// ApplyLatencyExemplars, and takes their exemplars.
+func (c *Client) ApplyLatency() []*TaskLatency {
+	var latencies []*TaskLatency
+	for _, ar := range c.getAllocRunners() {
+		for _, tr := range ar.getWorkers() {
+			if histograms := tr.applyLatency(); len(histograms) > 0 {
+				latencies = append(latencies, &TaskLatency{Labels: tr.metricLabels(), Histograms: histograms})
+			}
+		}
+	}
+	return latencies
+}
+
+// AllocReport returns the report saved when the task of the allocation completed. It is
+// kept after the allocation is destroyed.
+func (c *Client) AllocReport(allocID string) (*models.AllocReport, error) {
+	path := allocdir.ReportPath(c.config.AllocDir, allocID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// saved in the dir of the allocation by an older version
+		legacy := filepath.Join(c.config.AllocDir, allocID, "report.json")
+		if _, err := os.Stat(legacy); err == nil {
+			path = legacy
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no report of allocation %q. Its task has not completed", allocID)
+		}
+		return nil, err
+	}
+	report := &models.AllocReport{}
+	if err := restoreState(path, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
 // GetServers returns the list of server servers this client is aware of.
 func (c *Client) GetServers() []string {
 	endpoints := c.servers.all()
@@ -444,6 +861,48 @@ func (c *Client) saveState() error {
 	return mErr.ErrorOrNil()
 }
 
+// restoreState is used to restore the allocations from the state dir, so that
+// they keep running after a restart of the client, even if the servers are
+// unreachable. Terminal allocations are cleaned up. An allocation whose state
+// cannot be read is failed, and does not keep the others from being restored.
+func (c *Client) restoreState() error {
+	list, err := ioutil.ReadDir(filepath.Join(c.config.StateDir, "alloc"))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list alloc state: %v", err)
+	}
+
+	for _, entry := range list {
+		id := entry.Name()
+		alloc := &models.Allocation{ID: id}
+		c.configLock.RLock()
+		ar := NewAllocator(c.logger, c.configCopy, c.updateAllocStatus, alloc, c.workUpdates)
+		c.configLock.RUnlock()
+		if err := ar.RestoreState(); err != nil {
+			// Kept failed, so that its status is reported. Its state is left for inspection.
+			ar.failState(&AllocStateError{AllocID: id, Err: fmt.Errorf("failed to restore state: %v", err)})
+		} else if ar.Alloc().TerminalStatus() {
+			if err := ar.DestroyState(); err != nil {
+				c.logger.Warnf("agent: Failed to destroy state for terminal alloc %s: %v", id, err)
+			}
+			continue
+		} else {
+			c.logger.Printf("agent: Restored alloc %s", id)
+		}
+		c.allocLock.Lock()
+		c.allocs[id] = ar
+		c.allocLock.Unlock()
+	}
+
+	// Reconcile the dirs of the restored allocations before running them
+	c.sweepAllocDirs()
+	for _, ar := range c.getAllocRunners() {
+		go ar.Run()
+	}
+	return nil
+}
+
 // getAllocRunners returns a snapshot of the current set of alloc runners.
 func (c *Client) getAllocRunners() map[string]*Allocator {
 	c.allocLock.RLock()
@@ -507,6 +966,9 @@ func (c *Client) setupNode() error {
 	if node.Attributes == nil {
 		node.Attributes = make(map[string]string)
 	}
+	for _, feature := range models.SupportedFeatures() {
+		node.Attributes[models.NodeAttrFeaturePrefix+feature] = "1"
+	}
 	if node.Datacenter == "" {
 		node.Datacenter = "dc1"
 	}
@@ -516,6 +978,9 @@ func (c *Client) setupNode() error {
 	if node.Name == "" {
 		node.Name = node.ID
 	}
+	if node.SchedulingEligibility == "" {
+		node.SchedulingEligibility = models.NodeSchedulingEligible
+	}
 	node.Status = models.NodeStatusInit
 	return nil
 }
@@ -525,6 +990,9 @@ func (c *Client) setupNatsServer() error {
 	if err != nil {
 		return fmt.Errorf("Failed to parse Nats address %q: %v", c.config.NatsAddr, err)
 	}
+	if err := c.config.NatsAuth.Validate(); err != nil {
+		return err
+	}
 	nOpts := gnatsd.Options{
 		Host:       natsAddr.IP.String(),
 		Port:       natsAddr.Port,
@@ -534,6 +1002,16 @@ func (c *Client) setupNatsServer() error {
 		Trace:   true,
 		Debug:   true,
 	}
+	c.config.NatsAuth.ApplyServerOptions(&nOpts)
+
+	// The nats server only logs a failure to listen, then times out waiting to be ready.
+	// Check the address first for a clear error.
+	l, err := net.ListenTCP("tcp", natsAddr)
+	if err != nil {
+		return fmt.Errorf("nats address %v is not available: %v", c.config.NatsAddr, err)
+	}
+	l.Close()
+
 	c.logger.Debugf("agent: Starting nats streaming server [%v]", natsAddr)
 	sOpts := stand.GetDefaultOptions()
 	sOpts.ID = config.DefaultClusterID
@@ -546,24 +1024,111 @@ func (c *Client) setupNatsServer() error {
 		return err
 	}
 	c.stand = s
+
+	// The allocations are restored after this returns. Their tasks connect with the
+	// same settings, so they must succeed on the first attempt.
+	if err := waitNatsReady(c.config.NatsAuth, natsLocalAddr(natsAddr), natsReadyTimeout); err != nil {
+		s.Shutdown()
+		return err
+	}
 	return nil
 }
 
-// setupDrivers is used to find the available drivers
-func (c *Client) setupDrivers() error {
-	var avail []string
-	driverCtx := driver.NewDriverContext("", "", c.config, c.config.Node, c.logger)
-	for name := range driver.BuiltinDrivers {
-		_, err := driver.NewDriver(name, driverCtx)
-		if err != nil {
-			return err
+// waitNatsReady connects to the nats server until it succeeds or the timeout.
+// An error other than failing to dial (e.g. of authentication) is returned at once.
+func waitNatsReady(auth *config.NatsAuthConfig, addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		nc, err := auth.Connect(fmt.Sprintf("nats://%s", addr))
+		if err == nil {
+			nc.Close()
+			return nil
+		}
+		if err != gonats.ErrNoServers {
+			return fmt.Errorf("nats server and client auth settings mismatch: %v", err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("nats server at %v is not ready after %v: %v", addr, timeout, err)
 		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// natsLocalAddr returns the address to connect to the nats server listening on addr.
+func natsLocalAddr(addr *net.TCPAddr) string {
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return fmt.Sprintf("127.0.0.1:%d", addr.Port)
+	}
+	return addr.String()
+}
+
+// setupDrivers is used to find the available drivers among the factories. Up to
+// DriverSetupParallelism drivers are set up at once. A driver not set up within
+// DriverSetupTimeout is skipped, so the startup is not delayed by a slow one.
+func (c *Client) setupDrivers(factories map[string]driver.Factory) error {
+	driverCtx := driver.NewDriverContext("", "", c.config, c.config.Node, c.logger, nil)
+	parallelism := c.config.DriverSetupParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	timeout := c.config.DriverSetupTimeout
+
+	type setupResult struct {
+		name    string
+		skipped bool
+	}
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make(chan setupResult, len(names))
+	sem := make(chan struct{}, parallelism)
+	for _, name := range names {
+		go func(name string, factory driver.Factory) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			done := make(chan struct{})
+			go func() {
+				factory(driverCtx)
+				close(done)
+			}()
+			var timeoutCh <-chan time.Time
+			if timeout > 0 {
+				timer := time.NewTimer(timeout)
+				defer timer.Stop()
+				timeoutCh = timer.C
+			}
+			select {
+			case <-done:
+				results <- setupResult{name: name}
+			case <-timeoutCh:
+				results <- setupResult{name: name, skipped: true}
+			}
+		}(name, factories[name])
+	}
 
-		avail = append(avail, name)
-		c.config.Node.Attributes[fmt.Sprintf("driver.%s", name)] = "1"
+	var avail, skipped []string
+	for range names {
+		result := <-results
+		switch {
+		case result.skipped:
+			skipped = append(skipped, result.name)
+		default:
+			avail = append(avail, result.name)
+			c.configLock.Lock()
+			c.config.Node.Attributes[models.NodeAttrDriverPrefix+result.name] = "1"
+			c.configLock.Unlock()
+		}
 	}
+	sort.Strings(avail)
 
 	c.logger.Debugf("agent: Available drivers %v", avail)
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		c.logger.Warnf("agent: Skipped drivers not set up within %v: %v", timeout, skipped)
+	}
 
 	return nil
 }
@@ -582,16 +1147,23 @@ func (c *Client) registerAndHeartbeat() {
 	// Start watching changes for node changes
 	go c.watchNodeUpdates()
 
+	c.heartbeatLoop()
+}
+
+// heartbeatLoop heartbeats the registered node until the client shuts down.
+func (c *Client) heartbeatLoop() {
+	clk := c.clk()
 	// Setup the heartbeat timer, for the initial registration
 	// we want to do this quickly. We want to do it extra quickly
 	// in development mode.
 	var heartbeat <-chan time.Time
-	heartbeat = time.After(lib.RandomStagger(initialHeartbeatStagger))
+	heartbeat = clk.After(lib.RandomStagger(initialHeartbeatStagger))
 
 	for {
 		select {
 		case <-c.serversDiscoveredCh:
 		case <-heartbeat:
+		case <-c.heartbeatNowCh:
 		case <-c.shutdownCh:
 			return
 		}
@@ -603,36 +1175,81 @@ func (c *Client) registerAndHeartbeat() {
 				// Re-register the node
 				c.logger.Printf("agent: Re-registering node")
 				c.retryRegisterNode()
-				heartbeat = time.After(lib.RandomStagger(initialHeartbeatStagger))
+				heartbeat = clk.After(lib.RandomStagger(initialHeartbeatStagger))
 			} else {
-				intv := c.retryIntv(registerRetryIntv)
+				intv := c.backoffs[backoffHeartbeat].Next(err)
 				c.logger.Errorf("agent: Heartbeating failed. Retrying in %v: %v", intv, err)
-				heartbeat = time.After(intv)
+				heartbeat = clk.After(intv)
 
 				// if heartbeating fails, trigger Consul discovery
 				c.triggerDiscovery()
 			}
 		} else {
+			c.backoffs[backoffHeartbeat].Reset()
 			c.heartbeatLock.Lock()
-			heartbeat = time.After(c.heartbeatTTL)
+			heartbeat = clk.After(c.heartbeatTTL)
 			c.heartbeatLock.Unlock()
 		}
 	}
 }
 
 // periodicSnapshot is a long lived goroutine used to periodically snapshot the
-// state of the client
+// state of the client. An allocation is snapshotted after StateSnapshotInterval
+// since its last snapshot, or with StateSnapshotTxDelta set, after as many
+// transactions of a task, whichever comes first.
 func (c *Client) periodicSnapshot() {
+	interval := c.config.StateSnapshotInterval
+	if interval <= 0 {
+		interval = stateSnapshotIntv
+	}
+	txDelta := c.config.StateSnapshotTxDelta
+	tick := interval
+	if txDelta > 0 && stateSnapshotCheckIntv < interval {
+		tick = stateSnapshotCheckIntv
+	}
+	// the last snapshot of each allocation by this loop
+	lastSnapshot := make(map[string]time.Time)
+
 	// Create a snapshot timer
-	snapshot := time.After(stateSnapshotIntv)
+	clk := c.clk()
+	snapshot := clk.After(tick)
 
 	for {
 		select {
 		case <-snapshot:
-			snapshot = time.After(stateSnapshotIntv)
-			if err := c.saveState(); err != nil {
-				c.logger.Errorf("agent: Failed to save state: %v", err)
+			now := clk.Now()
+			// Only the changed allocations are saved.
+			var saved, skipped int64
+			allocs := c.getAllocRunners()
+			for id, ar := range allocs {
+				if last, ok := lastSnapshot[id]; ok && now.Sub(last) < interval {
+					// Not due by time. Saved early after StateSnapshotTxDelta
+					// transactions, which also restarts the interval, so that
+					// the two do not save it back to back.
+					if txDelta <= 0 || ar.txSinceSnapshot() < txDelta {
+						skipped++
+						continue
+					}
+				}
+				lastSnapshot[id] = now
+				ok, err := ar.snapshotIfDirty()
+				if err != nil {
+					c.logger.Errorf("agent: Failed to save state for alloc %s: %v", id, err)
+				}
+				if ok {
+					saved++
+				} else {
+					skipped++
+				}
+			}
+			for id := range lastSnapshot {
+				if _, ok := allocs[id]; !ok {
+					delete(lastSnapshot, id)
+				}
 			}
+			atomic.StoreInt64(&c.snapshotSaved, saved)
+			atomic.StoreInt64(&c.snapshotSkipped, skipped)
+			snapshot = clk.After(tick)
 
 		case <-c.shutdownCh:
 			return
@@ -670,11 +1287,37 @@ func (c *Client) hasNodeChanged(oldAttrHash uint64, oldMetaHash uint64) (bool, u
 	if err != nil {
 		c.logger.Debugf("agent: Unable to calculate node attributes hash: %v", err)
 	}
+	newMetaHash, err := hashstructure.Hash([]string{c.config.Node.NodeClass, c.config.Node.SchedulingEligibility}, nil)
+	if err != nil {
+		c.logger.Debugf("agent: Unable to calculate node meta hash: %v", err)
+	}
 
-	if newAttrHash != oldAttrHash {
-		return true, newAttrHash, 0
+	if newAttrHash != oldAttrHash || newMetaHash != oldMetaHash {
+		return true, newAttrHash, newMetaHash
 	}
-	return false, oldAttrHash, 0
+	return false, oldAttrHash, oldMetaHash
+}
+
+// SetSchedulingEligibility changes whether allocations can be placed on the node.
+// The node is registered again with the change by watchNodeUpdates.
+func (c *Client) SetSchedulingEligibility(eligible bool) {
+	eligibility := models.NodeSchedulingEligible
+	if !eligible {
+		eligibility = models.NodeSchedulingIneligible
+	}
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	if c.config.Node.SchedulingEligibility != eligibility {
+		c.logger.Printf("agent: Scheduling eligibility of the node changed to %v", eligibility)
+		c.config.Node.SchedulingEligibility = eligibility
+	}
+}
+
+// SchedulingEligibility returns whether allocations can be placed on the node.
+func (c *Client) SchedulingEligibility() string {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+	return c.config.Node.SchedulingEligibility
 }
 
 // retryRegisterNode is used to register the node or update the registration and
@@ -684,6 +1327,7 @@ func (c *Client) retryRegisterNode() {
 		err := c.registerNode()
 		if err == nil {
 			// Registered!
+			c.backoffs[backoffRegister].Reset()
 			return
 		}
 
@@ -695,7 +1339,7 @@ func (c *Client) retryRegisterNode() {
 		}
 		select {
 		case <-c.serversDiscoveredCh:
-		case <-time.After(c.retryIntv(registerRetryIntv)):
+		case <-c.clk().After(c.backoffs[backoffRegister].Next(err)):
 		case <-c.shutdownCh:
 			return
 		}
@@ -707,10 +1351,12 @@ func (c *Client) registerNode() error {
 	node := c.Node()
 	req := models.NodeRegisterRequest{
 		Node:         node,
+		Events:       c.takeNodeEvents(),
 		WriteRequest: models.WriteRequest{Region: c.Region()},
 	}
 	var resp models.NodeUpdateResponse
 	if err := c.RPC("Node.Register", &req, &resp); err != nil {
+		c.requeueNodeEvents(req.Events)
 		return err
 	}
 
@@ -740,10 +1386,12 @@ func (c *Client) updateNodeStatus() error {
 	req := models.NodeUpdateStatusRequest{
 		NodeID:       node.ID,
 		Status:       models.NodeStatusReady,
+		Events:       c.takeNodeEvents(),
 		WriteRequest: models.WriteRequest{Region: c.Region()},
 	}
 	var resp models.NodeUpdateResponse
 	if err := c.RPC("Node.UpdateStatus", &req, &resp); err != nil {
+		c.requeueNodeEvents(req.Events)
 		c.triggerDiscovery()
 		return fmt.Errorf("failed to update status: %v", err)
 	}
@@ -778,14 +1426,12 @@ func (c *Client) updateNodeStatus() error {
 	}
 	c.servers.set(servers)
 
-	// Begin polling Consul if there is no Udup leader.  We could be
-	// heartbeating to a Udup server that is in the minority of a
+	// Begin polling Consul if there is no Udup leader for a while. We could
+	// be heartbeating to a Udup server that is in the minority of a
 	// partition of the Udup server quorum, but this Udup Agent still
 	// has connectivity to the existing majority of Udup Servers, but
 	// only if it queries Consul.
-	if resp.LeaderRPCAddr == "" {
-		c.triggerDiscovery()
-	}
+	c.observeLeader(resp.LeaderRPCAddr, time.Now())
 
 	return nil
 }
@@ -823,31 +1469,57 @@ func (c *Client) updateAllocStatus(alloc *models.Allocation) {
 // allocSync is a long lived function that batches allocation updates to the
 // server.
 func (c *Client) allocSync() {
+	clk := c.clk()
 	staggered := false
-	syncTicker := time.NewTicker(allocSyncIntv)
+	syncIntv := allocSyncIntv
+	syncTick := clk.After(syncIntv)
 	aUpdates := make(map[string]*models.Allocation)
+	// alloc IDs of aUpdates, in the order they are received
+	var aOrder []string
+	addUpdate := func(alloc *models.Allocation) {
+		if _, ok := aUpdates[alloc.ID]; !ok {
+			aOrder = append(aOrder, alloc.ID)
+		}
+		aUpdates[alloc.ID] = alloc
+		if len(aOrder) > maxPendingAllocUpdates {
+			c.logger.Warnf("agent: Too many pending allocation updates. Dropping the update of alloc %v", aOrder[0])
+			delete(aUpdates, aOrder[0])
+			aOrder = aOrder[1:]
+		}
+	}
+	// Updates buffered before a restart during a server outage.
+	pending, err := c.loadPendingAllocUpdates()
+	if err != nil {
+		c.logger.Warnf("agent: Failed to load pending allocation updates: %v", err)
+	}
+	for _, alloc := range pending {
+		addUpdate(alloc)
+	}
+
 	jUpdates := make(map[string]*models.TaskUpdate)
 	for {
 		select {
 		case <-c.shutdownCh:
-			syncTicker.Stop()
 			return
 		case alloc := <-c.allocUpdates:
 			// Batch the allocation updates until the timer triggers.
 			c.logger.Debugf("Client.allocSync: <-allocUpdates")
-			aUpdates[alloc.ID] = alloc
+			addUpdate(alloc)
 
 		case update := <-c.workUpdates:
 			jUpdates[update.JobID] = update
 
-		case <-syncTicker.C:
+		case <-syncTick:
+			// syncErr is the last failure of the updates of this tick
+			var syncErr error
+			synced := false
 			// Fast path if there are no updates
 			if len(aUpdates) != 0 {
 				c.logger.Debugf("Client.allocSync: len(aUpdates) != 0")
 
 				sync := make([]*models.Allocation, 0, len(aUpdates))
-				for _, alloc := range aUpdates {
-					sync = append(sync, alloc)
+				for _, id := range aOrder {
+					sync = append(sync, aUpdates[id])
 				}
 
 				// Send to server.
@@ -859,16 +1531,20 @@ func (c *Client) allocSync() {
 				var resp models.GenericResponse
 				if err := c.RPC("Node.UpdateAlloc", &args, &resp); err != nil {
 					c.logger.Errorf("agent: Failed to update allocations: %v", err)
-					syncTicker.Stop()
-					syncTicker = time.NewTicker(c.retryIntv(allocSyncRetryIntv))
-					staggered = true
+					if c.Degraded() {
+						// Keep them across a restart of the client.
+						if err := c.savePendingAllocUpdates(sync); err != nil {
+							c.logger.Errorf("agent: Failed to save pending allocation updates: %v", err)
+						}
+					}
+					syncErr = err
 				} else {
 					aUpdates = make(map[string]*models.Allocation)
-					if staggered {
-						syncTicker.Stop()
-						syncTicker = time.NewTicker(allocSyncIntv)
-						staggered = false
+					aOrder = nil
+					if err := c.savePendingAllocUpdates(nil); err != nil {
+						c.logger.Errorf("agent: Failed to clear pending allocation updates: %v", err)
 					}
+					synced = true
 				}
 			}
 			if len(jUpdates) != 0 {
@@ -886,22 +1562,47 @@ func (c *Client) allocSync() {
 				var resp models.GenericResponse
 				if err := c.RPC("Node.UpdateJob", &args, &resp); err != nil {
 					c.logger.Errorf("agent: Failed to update allocations: %v", err)
-					syncTicker.Stop()
-					syncTicker = time.NewTicker(c.retryIntv(allocSyncRetryIntv))
-					staggered = true
+					syncErr = err
 				} else {
 					jUpdates = make(map[string]*models.TaskUpdate)
-					if staggered {
-						syncTicker.Stop()
-						syncTicker = time.NewTicker(allocSyncIntv)
-						staggered = false
-					}
+					synced = true
 				}
 			}
+
+			if syncErr != nil {
+				syncIntv = c.backoffs[backoffAllocSync].Next(syncErr)
+				staggered = true
+			} else if synced && staggered {
+				c.backoffs[backoffAllocSync].Reset()
+				syncIntv = allocSyncIntv
+				staggered = false
+			}
+			syncTick = clk.After(syncIntv)
 		}
 	}
 }
 
+// savePendingAllocUpdates persists the allocation updates not synced to the
+// servers. An empty list removes the file.
+func (c *Client) savePendingAllocUpdates(allocs []*models.Allocation) error {
+	path := filepath.Join(c.config.StateDir, pendingAllocUpdatesFile)
+	if len(allocs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return persistState(path, allocs)
+}
+
+// loadPendingAllocUpdates reads the allocation updates saved by
+// savePendingAllocUpdates, in the order they were received.
+func (c *Client) loadPendingAllocUpdates() ([]*models.Allocation, error) {
+	var allocs []*models.Allocation
+	err := restoreState(filepath.Join(c.config.StateDir, pendingAllocUpdatesFile), &allocs)
+	return allocs, err
+}
+
 type jobUpdates struct {
 	pulled map[string]string
 }
@@ -963,11 +1664,11 @@ func (c *Client) watchAllocations(updates chan *allocUpdates, jUpdates chan *job
 			if err != noServersErr {
 				c.logger.Errorf("agent: Failed to query for node allocations: %v", err)
 			}
-			retry := c.retryIntv(getAllocRetryIntv)
+			retry := c.backoffs[backoffWatchAllocations].Next(err)
 			select {
 			case <-c.serversDiscoveredCh:
 				continue
-			case <-time.After(retry):
+			case <-c.clk().After(retry):
 				continue
 			case <-c.shutdownCh:
 				return
@@ -1017,11 +1718,11 @@ func (c *Client) watchAllocations(updates chan *allocUpdates, jUpdates chan *job
 			allocsResp = models.AllocsGetResponse{}
 			if err := c.RPC("Alloc.GetAllocs", &allocsReq, &allocsResp); err != nil {
 				c.logger.Errorf("agent: Failed to query updated allocations: %v", err)
-				retry := c.retryIntv(getAllocRetryIntv)
+				retry := c.backoffs[backoffWatchAllocations].Next(err)
 				select {
 				case <-c.serversDiscoveredCh:
 					continue
-				case <-time.After(retry):
+				case <-c.clk().After(retry):
 					continue
 				case <-c.shutdownCh:
 					return
@@ -1058,6 +1759,7 @@ func (c *Client) watchAllocations(updates chan *allocUpdates, jUpdates chan *job
 			}
 		}
 
+		c.backoffs[backoffWatchAllocations].Reset()
 		c.logger.Debugf("agent: Updated allocations at index %d (total %d) (pulled %d) (filtered %d)",
 			resp.Index, len(resp.Allocs), len(allocsResp.Allocs), len(filtered))
 
@@ -1086,20 +1788,28 @@ func (c *Client) watchNodeUpdates() {
 
 	// Initialize the hashes
 	_, attrHash, metaHash := c.hasNodeChanged(0, 0)
+	c.configLock.RLock()
+	attrs := nodeAttrs(c.config.Node)
+	c.configLock.RUnlock()
 	var changed bool
 	for {
 		select {
-		case <-time.After(c.retryIntv(nodeUpdateRetryIntv)):
+		case <-c.clk().After(c.retryIntv(nodeUpdateRetryIntv)):
 			changed, attrHash, metaHash = c.hasNodeChanged(attrHash, metaHash)
 			if changed {
-				c.logger.Debugf("agent: State changed, updating node.")
-
 				// Update the config copy.
 				c.configLock.Lock()
 				node := c.config.Node.Copy()
 				c.configCopy.Node = node
+				newAttrs := nodeAttrs(node)
 				c.configLock.Unlock()
 
+				if diff := diffAttrs(attrs, newAttrs, time.Now()); diff != nil {
+					c.recordAttrDiff(diff)
+				}
+				attrs = newAttrs
+				c.logger.Debugf("agent: State changed, updating node.")
+
 				c.retryRegisterNode()
 			}
 		case <-c.shutdownCh:
@@ -1120,6 +1830,7 @@ func (c *Client) runAllocs(update *allocUpdates) {
 
 	// Diff the existing and updated allocations
 	diff := diffAllocs(exist, update)
+	c.recordPulledAllocs(update)
 	c.logger.Debugf("agent: Diff %#v", diff)
 
 	// Remove the old allocations
@@ -1296,14 +2007,15 @@ func (c *Client) waitForAllocTerminal(allocID string, stopCh *migrateAllocCtrl)
 		},
 	}
 
+	backoff := newBackoff(getAllocRetryIntv, maxRetryBackoff)
 	for {
 		resp := models.SingleAllocResponse{}
 		err := c.RPC("Alloc.GetAlloc", &req, &resp)
 		if err != nil {
 			c.logger.Errorf("agent: Failed to query allocation %q: %v", allocID, err)
-			retry := c.retryIntv(getAllocRetryIntv)
+			retry := backoff.Next(err)
 			select {
-			case <-time.After(retry):
+			case <-c.clk().After(retry):
 				continue
 			case <-stopCh.ch:
 				return nil, fmt.Errorf("giving up waiting on alloc %v since migration is not needed", allocID)
@@ -1337,13 +2049,14 @@ func (c *Client) getNode(nodeID string) (*models.Node, error) {
 	}
 
 	resp := models.SingleNodeResponse{}
+	backoff := newBackoff(getAllocRetryIntv, maxRetryBackoff)
 	for {
 		err := c.RPC("Node.GetNode", &req, &resp)
 		if err != nil {
 			c.logger.Errorf("agent: Failed to query node info %q: %v", nodeID, err)
-			retry := c.retryIntv(getAllocRetryIntv)
+			retry := backoff.Next(err)
 			select {
-			case <-time.After(retry):
+			case <-c.clk().After(retry):
 				continue
 			case <-c.shutdownCh:
 				return nil, fmt.Errorf("aborting because client is shutting down")
@@ -1419,6 +2132,17 @@ func (c *Client) addAlloc(alloc *models.Allocation) error {
 	}
 	c.allocLock.Unlock()
 
+	if c.config.RefuseUnsupportedAllocs {
+		if err := c.checkCapabilities(alloc); err != nil {
+			c.logger.Errorf("agent: Refusing allocation %q: %v", alloc.ID, err)
+			failed := alloc.Copy()
+			failed.ClientStatus = models.AllocClientStatusFailed
+			failed.ClientDescription = err.Error()
+			c.updateAllocStatus(failed)
+			return err
+		}
+	}
+
 	c.allocLock.Lock()
 	defer c.allocLock.Unlock()
 
@@ -1432,6 +2156,46 @@ func (c *Client) addAlloc(alloc *models.Allocation) error {
 	return nil
 }
 
+// EnsureAllocResult is what EnsureAlloc did with an allocation.
+type EnsureAllocResult string
+
+const (
+	EnsureAllocCreated EnsureAllocResult = "created"
+	EnsureAllocUpdated EnsureAllocResult = "updated"
+	EnsureAllocSkipped EnsureAllocResult = "skipped"
+)
+
+// EnsureAlloc asserts that the allocation should be running as given, for
+// the controllers reconciling the client from outside. It adds the allocation
+// if absent and updates it if its AllocModifyIndex is newer, as for the
+// allocations pulled from the servers; otherwise it does nothing.
+func (c *Client) EnsureAlloc(alloc *models.Allocation) (EnsureAllocResult, error) {
+	var exist []*models.Allocation
+	c.allocLock.RLock()
+	if ar, ok := c.allocs[alloc.ID]; ok {
+		exist = append(exist, ar.Alloc())
+	}
+	c.allocLock.RUnlock()
+
+	diff := diffAllocs(exist, &allocUpdates{
+		pulled: map[string]*models.Allocation{alloc.ID: alloc},
+	})
+	switch {
+	case len(diff.added) > 0:
+		if err := c.addAlloc(alloc); err != nil {
+			return "", err
+		}
+		return EnsureAllocCreated, nil
+	case len(diff.updated) > 0:
+		if err := c.updateAlloc(diff.updated[0].exist, alloc); err != nil {
+			return "", err
+		}
+		return EnsureAllocUpdated, nil
+	default:
+		return EnsureAllocSkipped, nil
+	}
+}
+
 // triggerDiscovery causes a Consul discovery to begin (if one hasn't alread)
 func (c *Client) triggerDiscovery() {
 	select {
@@ -1472,6 +2236,10 @@ func (c *Client) emitClientMetrics() {
 	metrics.SetGauge([]string{"client", "allocations", "pending", nodeID}, float32(pending))
 	metrics.SetGauge([]string{"client", "allocations", "running", nodeID}, float32(running))
 	metrics.SetGauge([]string{"client", "allocations", "terminal", nodeID}, float32(terminal))
+
+	metrics.SetGauge([]string{"client", "alloc_updates_backlog", nodeID}, float32(len(c.allocUpdates)))
+	metrics.SetGauge([]string{"client", "aux_disk_bytes", nodeID}, float32(c.config.AuxDisk.Used()))
+	metrics.SetGauge([]string{"client", "open_files", nodeID}, float32(atomic.LoadInt64(&c.openFiles)))
 }
 
 // allAllocs returns all the allocations managed by the client
@@ -1508,7 +2276,7 @@ func resolveServer(s string) (net.Addr, error) {
 			return nil, err
 		}
 	}
-	return net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+	return resolver.Default.ResolveTCPAddr(net.JoinHostPort(host, port))
 }
 
 // serverlist is a prioritized randomized list of server servers. Users should
@@ -1600,12 +2368,23 @@ func (e endpoints) String() string {
 
 type endpoint struct {
 	name string
+	// the address of name when the endpoint was set
 	addr net.Addr
 
 	// 0 being the highest priority
 	priority int
 }
 
+// resolve returns the current address of the endpoint, resolving its name again, e.g. after a
+// failover of the server by a DNS change. It is addr if the name fails to be resolved.
+func (e *endpoint) resolve() net.Addr {
+	addr, err := resolveServer(e.name)
+	if err != nil {
+		return e.addr
+	}
+	return addr
+}
+
 // equal returns true if the name and addr match between two endpoints.
 // Priority is ignored because the same endpoint may be added by discovery and
 // heartbeating with different priorities.
@@ -7,14 +7,26 @@
 package client
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/faults"
 	ulog "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/resolver"
 	"github.com/actiontech/dtle/internal/server"
 
 	stand "github.com/nats-io/nats-streaming-server/server"
@@ -1433,7 +1445,7 @@ func TestClient_setupDrivers(t *testing.T) {
 				shutdownCh:          tt.fields.shutdownCh,
 				shutdownLock:        tt.fields.shutdownLock,
 			}
-			if err := c.setupDrivers(); (err != nil) != tt.wantErr {
+			if err := c.setupDrivers(driver.BuiltinDrivers); (err != nil) != tt.wantErr {
 				t.Errorf("Client.setupDrivers() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -3314,3 +3326,876 @@ func Test_endpoint_equal(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_savePendingAllocUpdates(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-client-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	c := &Client{config: &config.ClientConfig{StateDir: stateDir}}
+
+	allocs := []*models.Allocation{{ID: "alloc2"}, {ID: "alloc1"}}
+	if err := c.savePendingAllocUpdates(allocs); err != nil {
+		t.Fatalf("savePendingAllocUpdates() error = %v", err)
+	}
+	got, err := c.loadPendingAllocUpdates()
+	if err != nil {
+		t.Fatalf("loadPendingAllocUpdates() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != "alloc2" || got[1].ID != "alloc1" {
+		t.Errorf("loadPendingAllocUpdates() = %v, want allocs in the saved order", got)
+	}
+
+	if err := c.savePendingAllocUpdates(nil); err != nil {
+		t.Fatalf("savePendingAllocUpdates(nil) error = %v", err)
+	}
+	got, err = c.loadPendingAllocUpdates()
+	if err != nil || len(got) != 0 {
+		t.Errorf("loadPendingAllocUpdates() = %v, %v after clearing, want none", got, err)
+	}
+}
+
+func TestClient_setupDrivers_timeout(t *testing.T) {
+	// the hung driver is released and has returned before the test does
+	var running sync.WaitGroup
+	defer running.Wait()
+	release := make(chan struct{})
+	defer close(release)
+	slow := func(d time.Duration) driver.Factory {
+		running.Add(1)
+		return func(*driver.DriverContext) driver.Driver {
+			defer running.Done()
+			select {
+			case <-time.After(d):
+			case <-release:
+			}
+			return nil
+		}
+	}
+	factories := map[string]driver.Factory{
+		"slow1": slow(200 * time.Millisecond),
+		"slow2": slow(200 * time.Millisecond),
+		"hung":  slow(time.Hour),
+	}
+
+	c := &Client{
+		config: &config.ClientConfig{
+			Node:                   &models.Node{Attributes: make(map[string]string)},
+			DriverSetupParallelism: 3,
+			DriverSetupTimeout:     time.Second,
+		},
+		logger: ulog.New(os.Stderr, ulog.ErrorLevel),
+	}
+	start := time.Now()
+	if err := c.setupDrivers(factories); err != nil {
+		t.Fatalf("setupDrivers() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("setupDrivers() took %v, want about the timeout", elapsed)
+	}
+	want := map[string]string{"driver.slow1": "1", "driver.slow2": "1"}
+	if !reflect.DeepEqual(c.config.Node.Attributes, want) {
+		t.Errorf("node attributes = %v, want %v", c.config.Node.Attributes, want)
+	}
+}
+
+func TestClient_addAlloc_capabilityMissing(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    *models.Task
+		missing string
+	}{
+		{
+			name:    "unknown driver",
+			task:    &models.Task{Type: models.TaskTypeSrc, Driver: "Oracle"},
+			missing: "driver.Oracle",
+		},
+		{
+			name: "unknown feature",
+			task: &models.Task{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL,
+				Constraints: []*models.Constraint{models.CapabilityConstraint("feature.foo")}},
+			missing: "feature.foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				config: &config.ClientConfig{
+					Node:                    &models.Node{ID: "node1", Attributes: map[string]string{"driver.MySQL": "1"}},
+					RefuseUnsupportedAllocs: true,
+				},
+				logger:       ulog.New(os.Stderr, ulog.ErrorLevel),
+				allocs:       make(map[string]*Allocator),
+				allocUpdates: make(chan *models.Allocation, 1),
+				shutdownCh:   make(chan struct{}),
+			}
+			alloc := &models.Allocation{
+				ID:   "alloc1",
+				Task: tt.task.Type,
+				Job:  &models.Job{ID: "job1", Tasks: []*models.Task{tt.task}},
+			}
+			err := c.addAlloc(alloc)
+			cmErr, ok := err.(*CapabilityMissingError)
+			if !ok || cmErr.Capability != tt.missing {
+				t.Fatalf("addAlloc() error = %v, want capability missing: %v", err, tt.missing)
+			}
+			if _, ok := c.allocs[alloc.ID]; ok {
+				t.Errorf("the allocation is added")
+			}
+			update := <-c.allocUpdates
+			if update.ClientStatus != models.AllocClientStatusFailed ||
+				update.ClientDescription != "capability missing: "+tt.missing {
+				t.Errorf("alloc update = %v %q, want failed", update.ClientStatus, update.ClientDescription)
+			}
+		})
+	}
+}
+
+func TestClient_consulFallback(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/catalog/service/server" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[{"Address":"127.0.0.2","ServiceAddress":"","ServicePort":8191},
+			{"Address":"127.0.0.3","ServiceAddress":"127.0.0.4","ServicePort":8191}]`)
+	}))
+	defer consul.Close()
+
+	newClient := func(disable bool) *Client {
+		consulConfig := config.DefaultConsulConfig()
+		consulConfig.Addr = consul.Listener.Addr().String()
+		cfg := &config.ClientConfig{
+			Node:                  &models.Node{},
+			ConsulConfig:          consulConfig,
+			DisableConsulFallback: disable,
+			ConsulFallbackAfter:   time.Minute,
+		}
+		return &Client{
+			config:              cfg,
+			configCopy:          cfg,
+			logger:              ulog.New(os.Stderr, ulog.ErrorLevel),
+			servers:             newServerList(),
+			triggerDiscoveryCh:  make(chan struct{}, 1),
+			serversDiscoveredCh: make(chan struct{}, 1),
+			shutdownCh:          make(chan struct{}),
+		}
+	}
+	triggered := func(c *Client) bool {
+		select {
+		case <-c.triggerDiscoveryCh:
+			return true
+		default:
+			return false
+		}
+	}
+
+	c := newClient(false)
+	now := time.Now()
+	c.observeLeader("", now)
+	if triggered(c) || c.consulFallbackActive {
+		t.Fatalf("discovery triggered without a leader for less than ConsulFallbackAfter")
+	}
+	c.observeLeader("", now.Add(time.Minute))
+	if !triggered(c) || !c.consulFallbackActive {
+		t.Fatalf("discovery not triggered without a leader for ConsulFallbackAfter")
+	}
+	if got := c.Stats()["client"]["consul_fallback_active"]; got != "true" {
+		t.Errorf("consul_fallback_active = %v, want true", got)
+	}
+
+	if err := c.consulDiscoveryImpl(); err != nil {
+		t.Fatalf("consulDiscoveryImpl() error = %v", err)
+	}
+	var names []string
+	for _, e := range c.servers.all() {
+		names = append(names, e.name)
+	}
+	sort.Strings(names)
+	if want := []string{"127.0.0.2:8191", "127.0.0.4:8191"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("servers = %v, want %v", names, want)
+	}
+	select {
+	case <-c.serversDiscoveredCh:
+	default:
+		t.Errorf("serversDiscoveredCh not ticked")
+	}
+
+	c.observeLeader("127.0.0.2:8191", now.Add(2*time.Minute))
+	if c.consulFallbackActive || !c.noLeaderSince.IsZero() {
+		t.Errorf("Consul fallback still active with a leader")
+	}
+
+	c = newClient(true)
+	c.observeLeader("", now)
+	c.observeLeader("", now.Add(time.Hour))
+	if triggered(c) || c.consulFallbackActive {
+		t.Errorf("discovery triggered with DisableConsulFallback")
+	}
+	if got := c.Stats()["client"]["consul_fallback_enabled"]; got != "false" {
+		t.Errorf("consul_fallback_enabled = %v, want false", got)
+	}
+}
+
+func TestClient_EnsureAlloc(t *testing.T) {
+	logger := ulog.New(os.Stderr, ulog.ErrorLevel)
+	running := &models.Allocation{ID: "alloc1", AllocModifyIndex: 10, DesiredStatus: models.AllocDesiredStatusRun}
+	ar := &Allocator{alloc: running, logger: logger, updateCh: make(chan *models.Allocation, 1)}
+	c := &Client{
+		config: &config.ClientConfig{},
+		logger: logger,
+		allocs: map[string]*Allocator{running.ID: ar},
+	}
+
+	tests := []struct {
+		name  string
+		alloc *models.Allocation
+		want  EnsureAllocResult
+	}{
+		{"identical", running.Copy(), EnsureAllocSkipped},
+		{"changed", &models.Allocation{ID: "alloc1", AllocModifyIndex: 11, DesiredStatus: models.AllocDesiredStatusRun}, EnsureAllocUpdated},
+		{"absent but paused", &models.Allocation{ID: "alloc2", DesiredStatus: models.AllocDesiredStatusPause}, EnsureAllocSkipped},
+	}
+	for _, tt := range tests {
+		got, err := c.EnsureAlloc(tt.alloc)
+		if err != nil || got != tt.want {
+			t.Fatalf("%v: EnsureAlloc() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if update := <-ar.updateCh; update.AllocModifyIndex != 11 {
+		t.Errorf("alloc update = %v, want the changed alloc", update.AllocModifyIndex)
+	}
+	if _, ok := c.allocs["alloc2"]; ok {
+		t.Errorf("the paused allocation is added")
+	}
+}
+
+func Test_diffAttrs(t *testing.T) {
+	now := time.Now()
+	old := nodeAttrs(&models.Node{
+		Attributes:            map[string]string{"driver.MySQL": "1", "driver.Kafka": "1", "feature.foo": "1"},
+		SchedulingEligibility: models.NodeSchedulingEligible,
+	})
+	new := nodeAttrs(&models.Node{
+		Attributes:            map[string]string{"driver.MySQL": "1", "feature.foo": "2", "feature.bar": "1"},
+		SchedulingEligibility: models.NodeSchedulingIneligible,
+	})
+	if d := diffAttrs(old, old, now); d != nil {
+		t.Errorf("diffAttrs() of the same attributes = %v, want nil", d)
+	}
+
+	d := diffAttrs(old, new, now)
+	want := &AttrDiff{
+		Time:    now,
+		Added:   map[string]string{"feature.bar": "1"},
+		Removed: map[string]string{"driver.Kafka": "1"},
+		Changed: map[string]AttrChange{
+			"feature.foo":             {Old: "1", New: "2"},
+			attrSchedulingEligibility: {Old: models.NodeSchedulingEligible, New: models.NodeSchedulingIneligible},
+		},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("diffAttrs() = %#v, want %#v", d, want)
+	}
+	if got := d.modules(); !reflect.DeepEqual(got, map[string]int{"driver": 1, "feature": 2, "node": 1}) {
+		t.Errorf("modules() = %v", got)
+	}
+
+	c := &Client{logger: ulog.New(os.Stderr, ulog.ErrorLevel)}
+	for i := 0; i < maxAttrDiffs+2; i++ {
+		c.recordAttrDiff(&AttrDiff{Time: now.Add(time.Duration(i))})
+	}
+	diffs := c.AttrDiffs()
+	if len(diffs) != maxAttrDiffs || !diffs[0].Time.Equal(now.Add(2)) {
+		t.Errorf("AttrDiffs() has %v diffs from %v, want the last %v", len(diffs), diffs[0].Time, maxAttrDiffs)
+	}
+}
+
+// jobUpdateRecorder is an RPCHandler keeping the Gtids of the job updates.
+type jobUpdateRecorder struct {
+	l     sync.Mutex
+	gtids []string
+}
+
+func (r *jobUpdateRecorder) RPC(method string, args interface{}, reply interface{}) error {
+	if method != "Node.UpdateJob" {
+		return nil
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	for _, u := range args.(*models.JobUpdateRequest).JobUpdates {
+		r.gtids = append(r.gtids, u.Gtid)
+	}
+	return nil
+}
+
+func (r *jobUpdateRecorder) last() string {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if len(r.gtids) == 0 {
+		return ""
+	}
+	return r.gtids[len(r.gtids)-1]
+}
+
+func TestClient_allocSync_faults(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-client-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	injector := faults.Enable()
+	defer faults.Disable()
+	if err := injector.Set(faults.RPCBeforeSend, faults.Fault{DropEvery: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &jobUpdateRecorder{}
+	c := &Client{
+		config:       &config.ClientConfig{StateDir: stateDir, RPCHandler: recorder},
+		logger:       ulog.New(os.Stderr, ulog.ErrorLevel),
+		allocUpdates: make(chan *models.Allocation, 1),
+		workUpdates:  make(chan *models.TaskUpdate, 1),
+		shutdownCh:   make(chan struct{}),
+		backoffs: map[string]*backoff{
+			backoffAllocSync: newBackoff(10*time.Millisecond, 50*time.Millisecond),
+		},
+	}
+	go c.allocSync()
+	defer close(c.shutdownCh)
+
+	// Every other update is dropped on the way to the servers, and retried.
+	sid := "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59"
+	for gno := 1; gno <= 5; gno++ {
+		c.workUpdates <- &models.TaskUpdate{JobID: "job1", Gtid: fmt.Sprintf("%v:1-%v", sid, gno)}
+		time.Sleep(allocSyncIntv)
+	}
+	want := fmt.Sprintf("%v:1-5", sid)
+	deadline := time.Now().Add(5 * time.Second)
+	for recorder.last() != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := recorder.last(); got != want {
+		t.Fatalf("last Gtid on the servers = %q, want %q", got, want)
+	}
+	if got := injector.List()[faults.RPCBeforeSend].Injected; got == 0 {
+		t.Errorf("no RPC was dropped")
+	}
+}
+
+// fakeServers is an RPCHandler standing for the servers in the tests of the
+// long lived loops. The RPCs are recorded and answered by the handler of their
+// method, if any.
+type fakeServers struct {
+	l        sync.Mutex
+	handlers map[string]func(args, reply interface{}) error
+	calls    map[string]int
+}
+
+func newFakeServers() *fakeServers {
+	return &fakeServers{
+		handlers: make(map[string]func(args, reply interface{}) error),
+		calls:    make(map[string]int),
+	}
+}
+
+func (s *fakeServers) handle(method string, h func(args, reply interface{}) error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.handlers[method] = h
+}
+
+func (s *fakeServers) RPC(method string, args interface{}, reply interface{}) error {
+	s.l.Lock()
+	s.calls[method]++
+	h := s.handlers[method]
+	s.l.Unlock()
+	if h == nil {
+		return nil
+	}
+	return h(args, reply)
+}
+
+// waitCalls waits for method to be called n times in all.
+func (s *fakeServers) waitCalls(t *testing.T, method string, n int) {
+	waitFor(t, func() bool {
+		s.l.Lock()
+		defer s.l.Unlock()
+		return s.calls[method] >= n
+	})
+	s.l.Lock()
+	defer s.l.Unlock()
+	if got := s.calls[method]; got != n {
+		t.Fatalf("%v called %v times, want %v", method, got, n)
+	}
+}
+
+// newLoopTestClient returns a client for the tests of the long lived loops,
+// with servers and clock faked.
+func newLoopTestClient(t *testing.T, servers *fakeServers, clk *fakeClock) *Client {
+	stateDir, err := ioutil.TempDir("", "dtle-client-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ClientConfig{
+		StateDir:   stateDir,
+		Region:     "global",
+		RPCHandler: servers,
+		Node:       &models.Node{ID: "node1", Datacenter: "dc1"},
+	}
+	return &Client{
+		config:         cfg,
+		configCopy:     cfg,
+		logger:         ulog.New(os.Stderr, ulog.ErrorLevel),
+		clock:          clk,
+		servers:        newServerList(),
+		allocs:         make(map[string]*Allocator),
+		allocUpdates:   make(chan *models.Allocation, 8),
+		workUpdates:    make(chan *models.TaskUpdate, 8),
+		heartbeatNowCh: make(chan struct{}, 1),
+		shutdownCh:     make(chan struct{}),
+		backoffs: map[string]*backoff{
+			backoffRegister:         newBackoff(registerRetryIntv, maxRetryBackoff),
+			backoffHeartbeat:        newBackoff(registerRetryIntv, maxRetryBackoff),
+			backoffAllocSync:        newBackoff(allocSyncRetryIntv, maxRetryBackoff),
+			backoffWatchAllocations: newBackoff(getAllocRetryIntv, maxRetryBackoff),
+		},
+	}
+}
+
+func stopLoopTestClient(c *Client) {
+	close(c.shutdownCh)
+	os.RemoveAll(c.config.StateDir)
+}
+
+func TestClient_heartbeatLoop(t *testing.T) {
+	fc := newFakeClock()
+	servers := newFakeServers()
+	var (
+		l        sync.Mutex
+		ttl      = time.Minute
+		notFound bool
+	)
+	servers.handle("Node.UpdateStatus", func(args, reply interface{}) error {
+		l.Lock()
+		defer l.Unlock()
+		if notFound {
+			notFound = false
+			return fmt.Errorf("node not found")
+		}
+		resp := reply.(*models.NodeUpdateResponse)
+		resp.HeartbeatTTL = ttl
+		resp.Servers = []*models.NodeServerInfo{{RPCAdvertiseAddr: "127.0.0.1:8191", Datacenter: "dc1"}}
+		return nil
+	})
+	c := newLoopTestClient(t, servers, fc)
+	go c.heartbeatLoop()
+	defer stopLoopTestClient(c)
+
+	fc.waitTimers(t, 1)
+	fc.Advance(initialHeartbeatStagger)
+	servers.waitCalls(t, "Node.UpdateStatus", 1)
+
+	// The next heartbeat is after the TTL of the last response.
+	l.Lock()
+	ttl = 3 * time.Minute
+	l.Unlock()
+	fc.waitTimers(t, 1)
+	fc.Advance(time.Minute)
+	servers.waitCalls(t, "Node.UpdateStatus", 2)
+	fc.waitTimers(t, 1)
+	fc.Advance(2 * time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	servers.waitCalls(t, "Node.UpdateStatus", 2)
+	fc.Advance(time.Minute)
+	servers.waitCalls(t, "Node.UpdateStatus", 3)
+
+	// The servers lost the node: register it again, and heartbeat soon.
+	l.Lock()
+	notFound = true
+	l.Unlock()
+	fc.waitTimers(t, 1)
+	fc.Advance(3 * time.Minute)
+	servers.waitCalls(t, "Node.Register", 1)
+	fc.waitTimers(t, 1)
+	fc.Advance(initialHeartbeatStagger)
+	servers.waitCalls(t, "Node.UpdateStatus", 5)
+}
+
+func TestClient_allocSync_batching(t *testing.T) {
+	fc := newFakeClock()
+	servers := newFakeServers()
+	var (
+		l       sync.Mutex
+		fail    bool
+		batches [][]string
+	)
+	servers.handle("Node.UpdateAlloc", func(args, reply interface{}) error {
+		l.Lock()
+		defer l.Unlock()
+		if fail {
+			return fmt.Errorf("connection refused")
+		}
+		var batch []string
+		for _, alloc := range args.(*models.AllocUpdateRequest).Alloc {
+			batch = append(batch, alloc.ID+"/"+alloc.ClientStatus)
+		}
+		batches = append(batches, batch)
+		return nil
+	})
+	lastBatch := func() []string {
+		l.Lock()
+		defer l.Unlock()
+		if len(batches) == 0 {
+			return nil
+		}
+		return batches[len(batches)-1]
+	}
+	c := newLoopTestClient(t, servers, fc)
+	go c.allocSync()
+	defer stopLoopTestClient(c)
+	pendingFile := filepath.Join(c.config.StateDir, pendingAllocUpdatesFile)
+
+	// The updates of a period are sent in one batch, the last of each
+	// allocation in the order the allocations were first updated.
+	fc.waitTimers(t, 1)
+	c.allocUpdates <- &models.Allocation{ID: "a2", ClientStatus: models.AllocClientStatusPending}
+	c.allocUpdates <- &models.Allocation{ID: "a1", ClientStatus: models.AllocClientStatusRunning}
+	c.allocUpdates <- &models.Allocation{ID: "a2", ClientStatus: models.AllocClientStatusRunning}
+	waitFor(t, func() bool { return len(c.allocUpdates) == 0 })
+	fc.Advance(allocSyncIntv)
+	servers.waitCalls(t, "Node.UpdateAlloc", 1)
+	want := []string{"a2/" + models.AllocClientStatusRunning, "a1/" + models.AllocClientStatusRunning}
+	if got := lastBatch(); !reflect.DeepEqual(got, want) {
+		t.Errorf("batch = %v, want %v", got, want)
+	}
+
+	// A failure staggers the sync by the backoff, and keeps the updates
+	// on disk while the servers are unreachable.
+	l.Lock()
+	fail = true
+	l.Unlock()
+	c.setDegraded(true)
+	fc.waitTimers(t, 1)
+	c.allocUpdates <- &models.Allocation{ID: "a3", ClientStatus: models.AllocClientStatusFailed}
+	waitFor(t, func() bool { return len(c.allocUpdates) == 0 })
+	fc.Advance(allocSyncIntv)
+	servers.waitCalls(t, "Node.UpdateAlloc", 2)
+	fc.waitTimers(t, 1)
+	if _, err := os.Stat(pendingFile); err != nil {
+		t.Errorf("pending updates not saved: %v", err)
+	}
+	if got := c.backoffs[backoffAllocSync].String(); got == "idle" {
+		t.Errorf("alloc_sync backoff is idle after a failure")
+	}
+
+	l.Lock()
+	fail = false
+	l.Unlock()
+	c.setDegraded(false)
+	fc.Advance(allocSyncRetryIntv)
+	servers.waitCalls(t, "Node.UpdateAlloc", 3)
+	fc.waitTimers(t, 1)
+	if got := lastBatch(); !reflect.DeepEqual(got, []string{"a3/" + models.AllocClientStatusFailed}) {
+		t.Errorf("retried batch = %v", got)
+	}
+	if _, err := os.Stat(pendingFile); !os.IsNotExist(err) {
+		t.Errorf("pending updates not removed after the sync: %v", err)
+	}
+	if got := c.backoffs[backoffAllocSync].String(); got != "idle" {
+		t.Errorf("alloc_sync backoff = %v after a success, want idle", got)
+	}
+
+	// Back to the batching period.
+	c.allocUpdates <- &models.Allocation{ID: "a4", ClientStatus: models.AllocClientStatusRunning}
+	waitFor(t, func() bool { return len(c.allocUpdates) == 0 })
+	fc.Advance(allocSyncIntv)
+	servers.waitCalls(t, "Node.UpdateAlloc", 4)
+}
+
+func TestClient_watchAllocations_filter(t *testing.T) {
+	fc := newFakeClock()
+	servers := newFakeServers()
+	queries := make(chan uint64, 4)
+	release := make(chan struct{})
+	defer close(release)
+	var failed bool
+	servers.handle("Node.GetClientAllocs", func(args, reply interface{}) error {
+		queries <- args.(*models.NodeSpecificRequest).MinQueryIndex
+		if !failed {
+			failed = true
+			return fmt.Errorf("connection refused")
+		}
+		if args.(*models.NodeSpecificRequest).MinQueryIndex > 0 {
+			// the blocking query of the next change
+			<-release
+			return fmt.Errorf("shutting down")
+		}
+		resp := reply.(*models.NodeClientAllocsResponse)
+		resp.Allocs = map[string]uint64{"a1": 5, "a2": 9, "a3": 3}
+		resp.Index = 10
+		return nil
+	})
+	var pullReq models.AllocsGetRequest
+	servers.handle("Alloc.GetAllocs", func(args, reply interface{}) error {
+		pullReq = *args.(*models.AllocsGetRequest)
+		resp := reply.(*models.AllocsGetResponse)
+		for _, id := range pullReq.AllocIDs {
+			resp.Allocs = append(resp.Allocs, &models.Allocation{ID: id})
+		}
+		return nil
+	})
+	c := newLoopTestClient(t, servers, fc)
+	// a1 is up to date, a2 is changed on the servers and a3 is new.
+	c.allocs["a1"] = &Allocator{alloc: &models.Allocation{ID: "a1", AllocModifyIndex: 5}}
+	c.allocs["a2"] = &Allocator{alloc: &models.Allocation{ID: "a2", AllocModifyIndex: 7}}
+	updates := make(chan *allocUpdates, 1)
+	go c.watchAllocations(updates, nil)
+	defer stopLoopTestClient(c)
+
+	// The failed query is retried after the backoff.
+	<-queries
+	fc.waitTimers(t, 1)
+	fc.Advance(getAllocRetryIntv)
+	if index := <-queries; index != 0 {
+		t.Errorf("MinQueryIndex = %v, want 0", index)
+	}
+
+	update := <-updates
+	sort.Strings(pullReq.AllocIDs)
+	if !reflect.DeepEqual(pullReq.AllocIDs, []string{"a2", "a3"}) || pullReq.MinQueryIndex != 8 {
+		t.Errorf("pulled %v from index %v, want [a2 a3] from 8", pullReq.AllocIDs, pullReq.MinQueryIndex)
+	}
+	if _, ok := update.filtered["a1"]; !ok || len(update.filtered) != 1 {
+		t.Errorf("filtered = %v, want a1", update.filtered)
+	}
+	if len(update.pulled) != 2 || update.pulled["a2"] == nil || update.pulled["a3"] == nil {
+		t.Errorf("pulled = %v, want a2 and a3", update.pulled)
+	}
+	if index := <-queries; index != 10 {
+		t.Errorf("next MinQueryIndex = %v, want 10", index)
+	}
+}
+
+func TestClient_periodicSnapshot_errors(t *testing.T) {
+	fc := newFakeClock()
+	c := newLoopTestClient(t, newFakeServers(), fc)
+	c.config.StateSnapshotInterval = time.Minute
+	defer stopLoopTestClient(c)
+	ar := &Allocator{
+		config: c.config,
+		logger: c.logger,
+		alloc:  &models.Allocation{ID: "a1"},
+		dirty:  true,
+	}
+	c.allocs["a1"] = ar
+	// The state dir of the allocations cannot be made.
+	blocker := filepath.Join(c.config.StateDir, "alloc")
+	if err := ioutil.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	go c.periodicSnapshot()
+
+	snapshot := func() (saved, skipped int64) {
+		fc.waitTimers(t, 1)
+		fc.Advance(time.Minute)
+		fc.waitTimers(t, 1)
+		return atomic.LoadInt64(&c.snapshotSaved), atomic.LoadInt64(&c.snapshotSkipped)
+	}
+
+	if saved, skipped := snapshot(); saved != 0 || skipped != 1 {
+		t.Errorf("failed snapshot: saved %v, skipped %v, want 0, 1", saved, skipped)
+	}
+	// The allocation is saved by the next snapshot once the dir can be made.
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if saved, skipped := snapshot(); saved != 1 || skipped != 0 {
+		t.Errorf("retried snapshot: saved %v, skipped %v, want 1, 0", saved, skipped)
+	}
+	if _, err := os.Stat(ar.stateFilePath()); err != nil {
+		t.Errorf("state not saved: %v", err)
+	}
+	if saved, skipped := snapshot(); saved != 0 || skipped != 1 {
+		t.Errorf("clean snapshot: saved %v, skipped %v, want 0, 1", saved, skipped)
+	}
+}
+
+func TestClient_periodicSnapshot_txDelta(t *testing.T) {
+	fc := newFakeClock()
+	c := newLoopTestClient(t, newFakeServers(), fc)
+	c.config.StateSnapshotInterval = time.Minute
+	c.config.StateSnapshotTxDelta = 100
+	defer stopLoopTestClient(c)
+	alloc := &models.Allocation{ID: "a1", Job: &models.Job{ID: "job1"}}
+	ar := NewAllocator(c.logger, c.config, func(*models.Allocation) {}, alloc, make(chan *models.TaskUpdate, 8))
+	handle := &stoppingHandle{gtid: "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-10"}
+	ar.tasks[models.TaskTypeSrc] = &Worker{
+		task:   &models.Task{Type: models.TaskTypeSrc, Config: map[string]interface{}{}, ConfigLock: &sync.RWMutex{}},
+		logger: c.logger,
+		alloc:  alloc,
+		handle: handle,
+	}
+	c.allocs["a1"] = ar
+	go c.periodicSnapshot()
+
+	// savedTx checks a tick, and returns the transactions of the last snapshot.
+	savedTx := func() int64 {
+		fc.waitTimers(t, 1)
+		fc.Advance(stateSnapshotCheckIntv)
+		fc.waitTimers(t, 1)
+		ar.snapshotLock.Lock()
+		defer ar.snapshotLock.Unlock()
+		return ar.savedTxCounts[models.TaskTypeSrc]
+	}
+	if got := savedTx(); got != 10 {
+		t.Fatalf("first snapshot of %v transactions, want 10", got)
+	}
+	handle.gtid = "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-50"
+	if got := savedTx(); got != 10 {
+		t.Errorf("snapshot of %v transactions before StateSnapshotTxDelta", got)
+	}
+	// The allocation not due is counted as skipped.
+	if saved, skipped := atomic.LoadInt64(&c.snapshotSaved), atomic.LoadInt64(&c.snapshotSkipped); saved != 0 || skipped != 1 {
+		t.Errorf("snapshot not due: saved %v, skipped %v, want 0, 1", saved, skipped)
+	}
+	handle.gtid = "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-120"
+	if got := savedTx(); got != 120 {
+		t.Errorf("snapshot of %v transactions after StateSnapshotTxDelta, want 120", got)
+	}
+	// The interval restarts at the snapshot by StateSnapshotTxDelta.
+	handle.gtid = "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-130"
+	ar.markDirty()
+	for i := 1; i < 60; i++ {
+		if got := savedTx(); got != 120 {
+			t.Fatalf("snapshot of %v transactions %vs after the last one", got, i)
+		}
+	}
+	if got := savedTx(); got != 130 {
+		t.Errorf("snapshot of %v transactions after StateSnapshotInterval, want 130", got)
+	}
+}
+
+func TestClient_heartbeatHealthy(t *testing.T) {
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		factor float64
+		since  time.Duration
+		want   bool
+	}{
+		{"in the TTL", 0, 50 * time.Second, true},
+		{"in the default grace", 0, 80 * time.Second, true},
+		{"past the default grace", 0, 90 * time.Second, false},
+		{"past the TTL", 1, 70 * time.Second, false},
+		{"in the grace", 3, 170 * time.Second, true},
+	}
+	for _, tt := range tests {
+		c := &Client{
+			config:        &config.ClientConfig{HeartbeatGraceFactor: tt.factor},
+			lastHeartbeat: now.Add(-tt.since),
+			heartbeatTTL:  time.Minute,
+		}
+		if got := c.heartbeatHealthy(now); got != tt.want {
+			t.Errorf("%v: heartbeatHealthy() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Not before the first heartbeat.
+	c := &Client{config: &config.ClientConfig{}}
+	if c.HeartbeatHealthy() {
+		t.Errorf("HeartbeatHealthy() = true before the first heartbeat")
+	}
+}
+
+func TestEndpoint_resolve(t *testing.T) {
+	var lock sync.Mutex
+	ip := "10.0.0.1"
+	defer func(r *resolver.Resolver) { resolver.Default = r }(resolver.Default)
+	resolver.Default = resolver.New(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if host != "manager.example.com" {
+			return nil, fmt.Errorf("no such host %v", host)
+		}
+		return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+	}, 0)
+
+	addr, err := resolveServer("manager.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &endpoint{name: "manager.example.com", addr: addr}
+	if got := e.resolve().String(); got != "10.0.0.1:8191" {
+		t.Errorf("resolve() = %v, want 10.0.0.1:8191", got)
+	}
+
+	// the manager fails over by a DNS change
+	lock.Lock()
+	ip = "10.0.0.2"
+	lock.Unlock()
+	if got := e.resolve().String(); got != "10.0.0.2:8191" {
+		t.Errorf("resolve() after the DNS change = %v, want 10.0.0.2:8191", got)
+	}
+	e = &endpoint{name: "gone.example.com", addr: addr}
+	if got := e.resolve(); got != addr {
+		t.Errorf("resolve() of an unresolvable name = %v, want %v", got, addr)
+	}
+}
+
+func TestClient_restoreState_badAlloc(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-client-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	logger := ulog.New(os.Stderr, ulog.ErrorLevel)
+	cfg := &config.ClientConfig{StateDir: stateDir, Node: &models.Node{ID: "node1"}}
+
+	// alloc1 has no task to run, so that it is not started
+	good := NewAllocator(logger, cfg, func(*models.Allocation) {},
+		&models.Allocation{ID: "alloc1", Task: models.TaskTypeDest, Job: &models.Job{ID: "job1"}},
+		make(chan *models.TaskUpdate, 1))
+	if err := good.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	badPath := filepath.Join(stateDir, "alloc", "alloc2", "state.json")
+	if err := os.MkdirAll(filepath.Dir(badPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(badPath, []byte("{corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{
+		config:       cfg,
+		configCopy:   cfg,
+		logger:       logger,
+		allocs:       make(map[string]*Allocator),
+		allocUpdates: make(chan *models.Allocation, 8),
+		shutdownCh:   make(chan struct{}),
+		workUpdates:  make(chan *models.TaskUpdate, 1),
+	}
+	if err := c.restoreState(); err != nil {
+		t.Fatalf("restoreState() error = %v, want the client started", err)
+	}
+	runners := c.getAllocRunners()
+	defer func() {
+		for _, ar := range runners {
+			ar.Destroy()
+			<-ar.WaitCh()
+		}
+	}()
+	if ar, ok := runners["alloc1"]; !ok || ar.stateError() != nil {
+		t.Errorf("alloc1 not restored, or failed")
+	}
+	bad, ok := runners["alloc2"]
+	if !ok {
+		t.Fatalf("alloc2 not kept")
+	}
+	if _, ok := bad.stateError().(*AllocStateError); !ok || bad.Alloc().ClientStatus != models.AllocClientStatusFailed {
+		t.Errorf("alloc2: %v, %v, want failed with an *AllocStateError", bad.Alloc().ClientStatus, bad.stateError())
+	}
+}
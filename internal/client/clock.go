@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// clockCheckIntv is how often the wall clock is compared to the
+	// monotonic one.
+	clockCheckIntv = 5 * time.Second
+
+	// clockJumpThreshold is the least difference between the two clocks
+	// over a check taken for a jump of the wall clock. NTP slews the clock
+	// far slower than this.
+	clockJumpThreshold = 10 * time.Second
+)
+
+// clock is the time source of the long lived loops of the client, faked by
+// the tests.
+type clock interface {
+	// Now returns the wall clock time.
+	Now() time.Time
+	// Elapsed returns the time elapsed since an arbitrary origin on the
+	// monotonic clock, which the wall clock being set does not move.
+	Elapsed() time.Duration
+	// After waits for d on the monotonic clock.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClockOrigin carries the monotonic reading the real clock is measured
+// from.
+var realClockOrigin = time.Now()
+
+// realClock is the clock of the system. The timers of the runtime run on the
+// monotonic clock, so the loops waiting on them are not disturbed by the wall
+// clock being set. They stand still while the host is suspended though.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Elapsed() time.Duration                 { return time.Since(realClockOrigin) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clk returns the clock of the loops of the client.
+func (c *Client) clk() clock {
+	if c.clock == nil {
+		return realClock{}
+	}
+	return c.clock
+}
+
+// clockJump returns how much further the wall clock went than the monotonic
+// one between two readings: positive if it jumped forward, negative if back.
+func clockJump(lastWall, wall time.Time, lastElapsed, elapsed time.Duration) time.Duration {
+	// Round(0) strips the monotonic readings so that Sub compares the wall
+	// clock readings.
+	return wall.Round(0).Sub(lastWall.Round(0)) - (elapsed - lastElapsed)
+}
+
+// watchClock is a long lived goroutine detecting the jumps of the wall clock.
+// A forward jump is either the clock being set or the host having been
+// suspended, during which the monotonic clock and so the heartbeat timer stood
+// still. The TTL of the node may have expired on the servers meanwhile, so the
+// client heartbeats at once.
+func (c *Client) watchClock() {
+	clk := c.clk()
+	lastWall, lastElapsed := clk.Now(), clk.Elapsed()
+	for {
+		select {
+		case <-clk.After(clockCheckIntv):
+		case <-c.shutdownCh:
+			return
+		}
+
+		wall, elapsed := clk.Now(), clk.Elapsed()
+		jump := clockJump(lastWall, wall, lastElapsed, elapsed)
+		lastWall, lastElapsed = wall, elapsed
+		switch {
+		case jump >= clockJumpThreshold:
+			atomic.AddInt64(&c.clockJumpsForward, 1)
+			c.logger.Warnf("agent: The wall clock jumped forward by %v. Heartbeating now", jump)
+			c.emitNodeEvent(models.NodeEventClockJump, fmt.Sprintf("The wall clock jumped forward by %v", jump),
+				map[string]string{"jump": jump.String()})
+			select {
+			case c.heartbeatNowCh <- struct{}{}:
+			default:
+				// a heartbeat is already due
+			}
+		case jump <= -clockJumpThreshold:
+			atomic.AddInt64(&c.clockJumpsBackward, 1)
+			c.logger.Warnf("agent: The wall clock jumped backward by %v", -jump)
+			c.emitNodeEvent(models.NodeEventClockJump, fmt.Sprintf("The wall clock jumped backward by %v", -jump),
+				map[string]string{"jump": jump.String()})
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ulog "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// fakeClock is a clock moved by the tests. Advance moves both the wall and
+// the monotonic clocks and fires the timers, Step moves the wall clock only.
+type fakeClock struct {
+	l       sync.Mutex
+	wall    time.Time
+	elapsed time.Duration
+	timers  []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Duration
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{wall: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.wall
+}
+
+func (f *fakeClock) Elapsed() time.Duration {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.elapsed
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.l.Lock()
+	defer f.l.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.wall
+		return ch
+	}
+	f.timers = append(f.timers, fakeTimer{at: f.elapsed + d, ch: ch})
+	return ch
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.wall = f.wall.Add(d)
+	f.elapsed += d
+	pending := f.timers[:0]
+	for _, t := range f.timers {
+		if t.at <= f.elapsed {
+			t.ch <- f.wall
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	f.timers = pending
+}
+
+func (f *fakeClock) Step(d time.Duration) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.wall = f.wall.Add(d)
+}
+
+// waitTimers waits for n timers to be pending, i.e. for the loops to wait.
+func (f *fakeClock) waitTimers(t *testing.T, n int) {
+	waitFor(t, func() bool {
+		f.l.Lock()
+		defer f.l.Unlock()
+		return len(f.timers) >= n
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_clockJump(t *testing.T) {
+	wall := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		wallDelta  time.Duration
+		monoDelta  time.Duration
+		wantJumped time.Duration
+	}{
+		{"steady", 5 * time.Second, 5 * time.Second, 0},
+		{"set forward", time.Hour + 5*time.Second, 5 * time.Second, time.Hour},
+		{"set backward", -time.Minute + 5*time.Second, 5 * time.Second, -time.Minute},
+		{"suspended", time.Hour, 0, time.Hour},
+	}
+	for _, tt := range tests {
+		got := clockJump(wall, wall.Add(tt.wallDelta), time.Minute, time.Minute+tt.monoDelta)
+		if got != tt.wantJumped {
+			t.Errorf("%v: clockJump() = %v, want %v", tt.name, got, tt.wantJumped)
+		}
+	}
+
+	// The monotonic readings of time.Now are ignored.
+	now := time.Now()
+	if got := clockJump(now, now.Add(time.Hour), 0, 0); got != time.Hour {
+		t.Errorf("clockJump() = %v, want 1h", got)
+	}
+}
+
+func TestClient_watchClock(t *testing.T) {
+	fc := newFakeClock()
+	c := &Client{
+		logger:         ulog.New(os.Stderr, ulog.ErrorLevel),
+		clock:          fc,
+		heartbeatNowCh: make(chan struct{}, 1),
+		shutdownCh:     make(chan struct{}),
+	}
+	go c.watchClock()
+	defer close(c.shutdownCh)
+
+	check := func(step time.Duration) {
+		fc.waitTimers(t, 1)
+		fc.Step(step)
+		fc.Advance(clockCheckIntv)
+		fc.waitTimers(t, 1)
+	}
+	heartbeatDue := func() bool {
+		select {
+		case <-c.heartbeatNowCh:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// NTP slewing is no jump.
+	check(time.Second)
+	if forward, backward := atomic.LoadInt64(&c.clockJumpsForward), atomic.LoadInt64(&c.clockJumpsBackward); forward != 0 || backward != 0 {
+		t.Errorf("jumps = %v forward, %v backward, want none", forward, backward)
+	}
+
+	check(time.Hour)
+	if got := atomic.LoadInt64(&c.clockJumpsForward); got != 1 {
+		t.Errorf("forward jumps = %v, want 1", got)
+	}
+	if !heartbeatDue() {
+		t.Errorf("no heartbeat after a forward jump")
+	}
+
+	check(-time.Hour)
+	if got := atomic.LoadInt64(&c.clockJumpsBackward); got != 1 {
+		t.Errorf("backward jumps = %v, want 1", got)
+	}
+	if heartbeatDue() {
+		t.Errorf("heartbeat after a backward jump")
+	}
+}
+
+func TestClient_heartbeatLoop_clockJump(t *testing.T) {
+	fc := newFakeClock()
+	servers := newFakeServers()
+	servers.handle("Node.UpdateStatus", func(args, reply interface{}) error {
+		reply.(*models.NodeUpdateResponse).HeartbeatTTL = time.Minute
+		return nil
+	})
+	c := newLoopTestClient(t, servers, fc)
+	go c.heartbeatLoop()
+	go c.watchClock()
+	defer stopLoopTestClient(c)
+
+	// The initial heartbeat, then every TTL by the monotonic clock.
+	fc.waitTimers(t, 2)
+	fc.Advance(initialHeartbeatStagger)
+	servers.waitCalls(t, "Node.UpdateStatus", 1)
+	fc.waitTimers(t, 2)
+	fc.Advance(time.Minute)
+	servers.waitCalls(t, "Node.UpdateStatus", 2)
+	fc.waitTimers(t, 2)
+
+	// Setting the wall clock back does not delay the heartbeat.
+	fc.Step(-time.Hour)
+	fc.Advance(time.Minute)
+	servers.waitCalls(t, "Node.UpdateStatus", 3)
+	fc.waitTimers(t, 2)
+
+	// The host wakes up from an hour of suspension: heartbeat at the next
+	// clock check, long before the TTL.
+	fc.Step(time.Hour)
+	fc.Advance(clockCheckIntv)
+	servers.waitCalls(t, "Node.UpdateStatus", 4)
+	if got := atomic.LoadInt64(&c.clockJumpsForward); got != 1 {
+		t.Errorf("forward jumps = %v, want 1", got)
+	}
+}
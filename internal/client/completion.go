@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// completionWindow is the window of the rates of models.CompletionEstimate.
+	completionWindow = time.Minute
+
+	etaUnknown = "N/A"
+)
+
+// completionSample is the progress of a task at a time: the rows copied and the lag.
+type completionSample struct {
+	at   time.Time
+	rows int64
+	// -1 without a lag
+	lag int64
+}
+
+// completionRing keeps samples of the progress of a task, taken on the stats collection, to
+// estimate when its phases complete.
+type completionRing struct {
+	lock    sync.Mutex
+	samples []completionSample // oldest first
+}
+
+// observe samples the progress of ru at now, and sets its Completion.
+func (r *completionRing) observe(ru *models.TaskStatistics, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cur := completionSample{at: now, rows: ru.ExecMasterRowCount, lag: -1}
+	if ru.DelayCount != nil {
+		cur.lag = int64(ru.DelayCount.Time)
+	}
+	if n := len(r.samples); n > 0 {
+		last := r.samples[n-1]
+		if cur.rows < last.rows || now.Before(last.at) {
+			// the task was restarted, with its counters
+			r.samples = nil
+		}
+	}
+	if n := len(r.samples); n == 0 || now.Sub(r.samples[n-1].at) >= throughputSampleIntv {
+		r.samples = append(r.samples, cur)
+	}
+	// keep one sample at or before the start of the window
+	drop := 0
+	for drop+1 < len(r.samples) && !r.samples[drop+1].at.After(now.Add(-completionWindow)) {
+		drop++
+	}
+	r.samples = r.samples[drop:]
+
+	estimate := &models.CompletionEstimate{}
+	base := r.samples[0]
+	secs := now.Sub(base.at).Seconds()
+	if total := ru.ReadMasterRowCount; total > 0 {
+		estimate.DumpETA = etaUnknown
+		if cur.rows >= total {
+			estimate.DumpETA = "0s"
+		} else if secs > 0 && cur.rows > base.rows {
+			rate := float64(cur.rows-base.rows) / secs
+			estimate.DumpETA, estimate.DumpEndAt = etaOf(float64(total-cur.rows)/rate, now)
+		}
+	}
+	if cur.lag >= 0 {
+		estimate.CatchUpETA = etaUnknown
+		switch {
+		case cur.lag == 0:
+			estimate.CatchUpState = models.CatchUpCaughtUp
+			estimate.CatchUpETA = "0s"
+		case secs <= 0 || base.lag < 0:
+			// no rate yet
+		case cur.lag < base.lag:
+			estimate.CatchUpState = models.CatchUpConverging
+			rate := float64(base.lag-cur.lag) / secs
+			estimate.CatchUpETA, estimate.CatchUpEndAt = etaOf(float64(cur.lag)/rate, now)
+		case cur.lag > base.lag:
+			estimate.CatchUpState = models.CatchUpDiverging
+		default:
+			estimate.CatchUpState = models.CatchUpSteady
+		}
+	}
+	ru.Completion = estimate
+}
+
+// etaOf returns the ETA of seconds left, and the end in unix seconds.
+func etaOf(seconds float64, now time.Time) (string, int64) {
+	if seconds >= float64(math.MaxInt64/int64(time.Second)) {
+		return etaUnknown, 0
+	}
+	left := time.Duration(seconds * float64(time.Second)).Round(time.Second)
+	return left.String(), now.Add(left).Unix()
+}
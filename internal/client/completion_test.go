@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestCompletionRing_observe(t *testing.T) {
+	var r completionRing
+	start := time.Unix(1525910400, 0)
+	observe := func(at time.Duration, rows, total int64, lag int64) *models.CompletionEstimate {
+		ru := &models.TaskStatistics{ExecMasterRowCount: rows, ReadMasterRowCount: total}
+		if lag >= 0 {
+			ru.DelayCount = &models.DelayCount{Time: uint64(lag)}
+		}
+		r.observe(ru, start.Add(at))
+		return ru.Completion
+	}
+
+	// the full copy of 10000 rows at 10 rows/sec
+	if c := observe(0, 0, 10000, -1); c.DumpETA != etaUnknown || c.CatchUpETA != "" {
+		t.Errorf("first estimate = %+v, want no ETA", c)
+	}
+	var c *models.CompletionEstimate
+	for s := 1; s <= 120; s++ {
+		c = observe(time.Duration(s)*time.Second, int64(10*s), 10000, -1)
+	}
+	if c.DumpETA != "14m40s" || c.DumpEndAt != start.Add(2*time.Minute+880*time.Second).Unix() {
+		t.Errorf("dump estimate = %+v, want 880s left", c)
+	}
+	if c = observe(121*time.Second, 10000, 10000, -1); c.DumpETA != "0s" {
+		t.Errorf("DumpETA when copied = %v, want 0s", c.DumpETA)
+	}
+
+	// the catch-up, after a restart
+	r = completionRing{}
+	observe(0, 0, 0, 100)
+	if c = observe(10*time.Second, 0, 0, 90); c.CatchUpState != models.CatchUpConverging || c.CatchUpETA != "1m30s" ||
+		c.DumpETA != "" {
+		t.Errorf("converging estimate = %+v, want 1m30s", c)
+	}
+	if c = observe(20*time.Second, 0, 0, 120); c.CatchUpState != models.CatchUpDiverging ||
+		c.CatchUpETA != etaUnknown || c.CatchUpEndAt != 0 {
+		t.Errorf("estimate of an increasing lag = %+v, want diverging", c)
+	}
+	if c = observe(30*time.Second, 0, 0, 100); c.CatchUpState != models.CatchUpSteady {
+		t.Errorf("estimate of a steady lag = %+v, want steady", c)
+	}
+	if c = observe(40*time.Second, 0, 0, 0); c.CatchUpState != models.CatchUpCaughtUp || c.CatchUpETA != "0s" {
+		t.Errorf("estimate without a lag = %+v, want caught up", c)
+	}
+}
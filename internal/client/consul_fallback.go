@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// defaultConsulFallbackAfter is how long the servers report no leader
+	// before they are looked for in Consul, if not configured.
+	defaultConsulFallbackAfter = 30 * time.Second
+)
+
+// consulFallbackEnabled returns whether the servers are looked for in Consul.
+func (c *Client) consulFallbackEnabled() bool {
+	if c.config.DisableConsulFallback || c.config.ConsulConfig == nil {
+		return false
+	}
+	autoJoin := c.config.ConsulConfig.ClientAutoJoin
+	return autoJoin == nil || *autoJoin
+}
+
+func (c *Client) consulFallbackAfter() time.Duration {
+	if c.config.ConsulFallbackAfter > 0 {
+		return c.config.ConsulFallbackAfter
+	}
+	return defaultConsulFallbackAfter
+}
+
+// observeLeader records whether the servers know a leader, and triggers a
+// discovery once they have reported none for consulFallbackAfter. It must be
+// called with heartbeatLock held.
+func (c *Client) observeLeader(leaderRPCAddr string, now time.Time) {
+	if leaderRPCAddr != "" {
+		if c.consulFallbackActive {
+			c.logger.Printf("agent: Servers report leader %v, Consul fallback inactive", leaderRPCAddr)
+		}
+		c.noLeaderSince = time.Time{}
+		c.consulFallbackActive = false
+		return
+	}
+	if c.noLeaderSince.IsZero() {
+		c.noLeaderSince = now
+	}
+	if now.Sub(c.noLeaderSince) < c.consulFallbackAfter() || !c.consulFallbackEnabled() {
+		return
+	}
+	if !c.consulFallbackActive {
+		c.logger.Warnf("agent: Servers have reported no leader since %v, looking for servers in Consul",
+			c.noLeaderSince)
+		c.consulFallbackActive = true
+	}
+	c.triggerDiscovery()
+}
+
+// consulDiscovery is a long lived goroutine looking for the servers in Consul
+// each time a discovery is triggered.
+func (c *Client) consulDiscovery() {
+	for {
+		select {
+		case <-c.triggerDiscoveryCh:
+			if err := c.consulDiscoveryImpl(); err != nil {
+				c.logger.Errorf("agent: Consul server discovery failed: %v", err)
+			}
+		case <-c.shutdownCh:
+			return
+		}
+	}
+}
+
+// consulDiscoveryImpl replaces the known servers with the instances of the
+// server service registered in the local Consul agent's datacenter.
+func (c *Client) consulDiscoveryImpl() error {
+	c.configLock.RLock()
+	consulConfig := c.configCopy.ConsulConfig.Copy()
+	c.configLock.RUnlock()
+
+	apiConfig, err := consulConfig.ApiConfig()
+	if err != nil {
+		return err
+	}
+	consul, err := consulapi.NewClient(apiConfig)
+	if err != nil {
+		return err
+	}
+	services, _, err := consul.Catalog().Service(consulConfig.ServerServiceName, "",
+		&consulapi.QueryOptions{AllowStale: true})
+	if err != nil {
+		return fmt.Errorf("query service %q: %v", consulConfig.ServerServiceName, err)
+	}
+
+	servers := make(endpoints, 0, len(services))
+	for _, s := range services {
+		host := s.ServiceAddress
+		if host == "" {
+			host = s.Address
+		}
+		name := net.JoinHostPort(host, strconv.Itoa(s.ServicePort))
+		addr, err := resolveServer(name)
+		if err != nil {
+			c.logger.Debugf("agent: Ignoring server %s from Consul due to resolution error: %v", name, err)
+			continue
+		}
+		servers = append(servers, &endpoint{name: name, addr: addr})
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("no server of service %q found", consulConfig.ServerServiceName)
+	}
+
+	c.servers.set(servers)
+	c.logger.Printf("agent: Discovered servers in Consul: %v", servers)
+
+	// Wake up the loops waiting for servers
+	select {
+	case c.serversDiscoveredCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -7,9 +7,20 @@
 package driver
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/actiontech/dtle/internal/auxdisk"
+	"github.com/actiontech/dtle/internal/client/allocdir"
+	"github.com/actiontech/dtle/internal/client/driver/filesink"
+	"github.com/actiontech/dtle/internal/client/driver/kafka3"
 	uconf "github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
@@ -21,6 +32,8 @@ var (
 	BuiltinDrivers = map[string]Factory{
 		models.TaskDriverMySQL: NewMySQLDriver,
 		models.TaskDriverKafka: NewKafkaDriver,
+
+		models.TaskDriverFileSink: NewFileSinkDriver,
 		//"models.TaskDriverOracle:     NewOracleDriver,
 	}
 
@@ -43,9 +56,150 @@ func NewDriver(name string, ctx *DriverContext) (Driver, error) {
 	return f, nil
 }
 
+// ValidateTaskConfig checks the parts of the task config which do not need the databases,
+// e.g. when a job is registered.
+func ValidateTaskConfig(task *models.Task) error {
+	switch task.Driver {
+	case models.TaskDriverMySQL:
+		var driverConfig uconf.MySQLDriverConfig
+		if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateCreateTable(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateSourceHosts(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateAdaptiveGroup(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateReadBatch(0); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateCompression(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateCodec(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateStartPosition(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateCharsetErrorPolicy(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateZeroDatePolicy(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateIgnoreErrorCodes(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateSocks5Proxy(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateConflictPolicy(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateXaPolicy(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateExecTimeout(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateUnsignedPolicy(); err != nil {
+			return err
+		}
+		if _, _, err := driverConfig.ValidateHeartbeatTable(); err != nil {
+			return err
+		}
+		if err := driverConfig.ValidateInitSQL(); err != nil {
+			return err
+		}
+		if task.Type == models.TaskTypeSrc {
+			if err := driverConfig.ValidateMode(); err != nil {
+				return err
+			}
+			if err := driverConfig.ValidateConcurrentIncrementalCopy(); err != nil {
+				return err
+			}
+			if err := driverConfig.ValidateReplicaServerId(); err != nil {
+				return err
+			}
+			if err := driverConfig.ValidateDumpMaxMBPerSec(); err != nil {
+				return err
+			}
+		}
+		if task.Type == models.TaskTypeExport {
+			if err := driverConfig.ValidateExport(); err != nil {
+				return err
+			}
+		}
+		var schemas []string
+		for _, db := range driverConfig.ReplicateDoDb {
+			if db.TableSchema != "" {
+				schemas = append(schemas, db.TableSchema)
+			}
+		}
+		if _, err := driverConfig.ValidateTargetSchema(schemas); err != nil {
+			return err
+		}
+		return uconf.ValidateEventFilters(driverConfig.EventFilters)
+	default:
+		return nil
+	}
+}
+
+// ResolvedTaskConfig returns the config of the task as the driver runs it: with the defaults
+// and the secret references resolved, and the secrets redacted.
+func ResolvedTaskConfig(task *models.Task) (map[string]interface{}, error) {
+	var resolved interface{}
+	switch task.Driver {
+	case models.TaskDriverMySQL:
+		var driverConfig uconf.MySQLDriverConfig
+		if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+			return nil, err
+		}
+		if driverConfig.ConnectionConfig != nil {
+			if err := driverConfig.ConnectionConfig.ResolveSecrets(); err != nil {
+				return nil, err
+			}
+		}
+		resolved = driverConfig.SetDefault()
+	case models.TaskDriverKafka:
+		var driverConfig kafka3.KafkaConfig
+		if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+			return nil, err
+		}
+		resolved = &driverConfig
+	case models.TaskDriverFileSink:
+		var driverConfig filesink.FileSinkConfig
+		if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+			return nil, err
+		}
+		resolved = &driverConfig
+	default:
+		return nil, fmt.Errorf("unknown driver '%s'", task.Driver)
+	}
+
+	bs, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(bs, &result); err != nil {
+		return nil, err
+	}
+	uconf.RedactTaskConfig(result)
+	return result, nil
+}
+
 // Factory is used to instantiate a new Driver
 type Factory func(*DriverContext) Driver
 
+// LogEventFn is a callback which allows Drivers to emit task events.
+type LogEventFn func(message string, args ...interface{})
+
 // Driver is used for execution of tasks. This allows Udup
 // to support many pluggable implementations of task drivers.
 type Driver interface {
@@ -65,6 +219,8 @@ type DriverContext struct {
 	config   *uconf.ClientConfig
 	logger   *log.Logger
 	node     *models.Node
+
+	emitEvent LogEventFn
 }
 
 // NewEmptyDriverContext returns a DriverContext with all fields set to their
@@ -78,13 +234,14 @@ func NewEmptyDriverContext() *DriverContext {
 // private to the driver. If we want to change this later we can gorename all of
 // the fields in DriverContext.
 func NewDriverContext(taskName, allocID string, config *uconf.ClientConfig, node *models.Node,
-	logger *log.Logger) *DriverContext {
+	logger *log.Logger, eventEmitter LogEventFn) *DriverContext {
 	return &DriverContext{
-		taskName: taskName,
-		allocID:  allocID,
-		config:   config,
-		node:     node,
-		logger:   logger,
+		taskName:  taskName,
+		allocID:   allocID,
+		config:    config,
+		node:      node,
+		logger:    logger,
+		emitEvent: eventEmitter,
 	}
 }
 
@@ -104,10 +261,69 @@ type DriverHandle interface {
 	Stats() (*models.TaskStatistics, error)
 }
 
+// GracefulStopper is implemented by the handles which can stop gracefully:
+// Stop finishes the work in progress, within ctx, so the handle ID is a clean
+// checkpoint to resume from. The task exits successfully on the Shutdown which
+// follows.
+type GracefulStopper interface {
+	Stop(ctx context.Context) error
+}
+
+// WorkerResizer is implemented by the handles whose pool of workers can be
+// resized while running. SetWorkers returns once n workers are running.
+type WorkerResizer interface {
+	SetWorkers(ctx context.Context, n int) error
+}
+
+// StatsResetter is implemented by the handles whose counters can be reset, see
+// models.TaskCounters. ResetStats returns the counters before the reset.
+type StatsResetter interface {
+	ResetStats() (*models.TaskCounters, error)
+}
+
+// StartupReporter is implemented by the handles which report their startup.
+// StartupPhase returns the initialization phase the handle is in, and whether
+// it has started. A handle which has not started within StartDeadline (0 to
+// disable) fails with models.StartupTimeoutError.
+type StartupReporter interface {
+	StartupPhase() (phase string, started bool)
+	StartDeadline() time.Duration
+}
+
+// ConfigResolver is implemented by the handles which resolve a part of their
+// config while running, e.g. the tables matched by the filters of the job.
+// ResolvedConfig returns those values by name, to be merged into the config of
+// the task. The values not resolved yet are left out.
+type ConfigResolver interface {
+	ResolvedConfig() map[string]interface{}
+}
+
+// LatencyReporter is implemented by the handles which keep the apply latency
+// of the tables. ApplyLatency returns it, nil if not kept, and takes the
+// exemplars: each is returned once.
+type LatencyReporter interface {
+	ApplyLatency() []*models.LatencyHistogram
+}
+
 type ExecContext struct {
 	Subject    string
 	Tp         string
 	MaxPayload int
+	NatsAuth   *uconf.NatsAuthConfig
+	AllocID    string
+	// the node-wide budget of the auxiliary files of the tasks
+	AuxDisk *auxdisk.Budget
+	// the dirs of the task in the alloc dir. nil without an alloc dir
+	TaskDir *allocdir.TaskDir
+	// persists at once the start position captured by the task. might be nil
+	SaveStartPosition func(gtid string)
+	// keeps the apply latency with exemplars, see uconf.ClientConfig.ApplyLatencyExemplars
+	ApplyLatencyExemplars bool
+	// the stdout and stderr of the task, e.g. for the output of the tools it runs. Written
+	// to the logs dir of the task, and forwarded to the client log with task_output_log_level.
+	// Never nil
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 // NewExecContext is used to create a new execution context
@@ -116,5 +332,7 @@ func NewExecContext(subject, tp string, mp int) *ExecContext {
 		Subject:    subject,
 		Tp:         tp,
 		MaxPayload: mp,
+		Stdout:     ioutil.Discard,
+		Stderr:     ioutil.Discard,
 	}
 }
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package driver
+
+import (
+	"os"
+	"testing"
+
+	uconf "github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestResolvedTaskConfig(t *testing.T) {
+	os.Setenv("DTLE_TEST_MYSQL_USER", "repl")
+	defer os.Unsetenv("DTLE_TEST_MYSQL_USER")
+
+	task := &models.Task{
+		Type:   models.TaskTypeSrc,
+		Driver: models.TaskDriverMySQL,
+		Config: map[string]interface{}{
+			"ConnectionConfig": map[string]interface{}{
+				"Host":     "127.0.0.1",
+				"Port":     3306,
+				"User":     "env://DTLE_TEST_MYSQL_USER",
+				"Password": "secret",
+			},
+		},
+	}
+	resolved, err := ResolvedTaskConfig(task)
+	if err != nil {
+		t.Fatalf("ResolvedTaskConfig() error = %v", err)
+	}
+
+	connCfg, ok := resolved["ConnectionConfig"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("ResolvedTaskConfig() ConnectionConfig = %v", resolved["ConnectionConfig"])
+	}
+	if connCfg["User"] != "repl" {
+		t.Errorf("User = %v, want the resolved reference", connCfg["User"])
+	}
+	if connCfg["Password"] != uconf.MaskedPassword {
+		t.Errorf("Password = %v, want it redacted", connCfg["Password"])
+	}
+	if connCfg["Charset"] != "utf8mb4" {
+		t.Errorf("Charset = %v, want the default", connCfg["Charset"])
+	}
+	if resolved["NoPkTablePolicy"] == "" {
+		t.Errorf("NoPkTablePolicy is empty, want the default")
+	}
+	if task.Config["ConnectionConfig"].(map[string]interface{})["Password"] != "secret" {
+		t.Errorf("the task config is modified")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/actiontech/dtle/internal/client/driver/filesink"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+type FileSinkDriver struct {
+	DriverContext
+}
+
+func (fd *FileSinkDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle, error) {
+	var driverConfig filesink.FileSinkConfig
+	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+		return nil, err
+	}
+	driverConfig.NatsAuth = ctx.NatsAuth
+	if driverConfig.Dir == "" {
+		if ctx.TaskDir == nil {
+			return nil, fmt.Errorf("FileSink needs a Dir without an alloc dir")
+		}
+		driverConfig.Dir = ctx.TaskDir.DataDir
+	}
+	if driverConfig.EncryptDataAtRest {
+		if ctx.TaskDir == nil {
+			return nil, fmt.Errorf("EncryptDataAtRest needs an alloc dir, for the data key")
+		}
+		key, err := ctx.TaskDir.DataKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the data key: %v", err)
+		}
+		driverConfig.DataKey = key
+	}
+
+	switch task.Type {
+	case models.TaskTypeSrc:
+		return nil, fmt.Errorf("FileSink can only be used on 'Dest'")
+	case models.TaskTypeDest:
+		runner := filesink.NewFileSinkRunner(ctx.Subject, &driverConfig, fd.logger)
+		go runner.Run()
+		return runner, nil
+	default:
+		return nil, fmt.Errorf("unknown processor type : %+v", task.Type)
+	}
+}
+
+func (fd *FileSinkDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	reply := &models.TaskValidateResponse{}
+
+	return reply, nil
+}
+
+func NewFileSinkDriver(ctx *DriverContext) Driver {
+	return &FileSinkDriver{DriverContext: *ctx}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package filesink
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/atrest"
+	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/gtid"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	TaskStateComplete int = iota
+	TaskStateRestart
+	TaskStateDead
+)
+
+// DefaultFileSizeMB is FileSinkConfig.FileSizeMB if unset.
+const DefaultFileSizeMB = 256
+
+// FileSinkConfig is the config of a Dest task with the FileSink driver.
+type FileSinkConfig struct {
+	NatsAddr string
+	// the transactions written, to resume from
+	Gtid string
+	// the dir of the files. the data dir of the task if empty
+	Dir string
+	// the size (in MB) past which the next file is started
+	FileSizeMB int64
+	// EncryptDataAtRest encrypts the files with the data key of the allocation (AES-GCM). They
+	// are unreadable once the allocation is destroyed. The files in Dir must all be written with
+	// it, or all without.
+	EncryptDataAtRest bool
+
+	NatsAuth *config.NatsAuthConfig `json:"-"` // set by the client
+	DataKey  []byte                 `json:"-"` // set by the client with EncryptDataAtRest
+}
+
+// FileSinkRunner writes the binlog the Src task reads, as the messages received from it, to
+// rotating files instead of applying it. The transactions are indexed by GTID, see Locate.
+// A transaction is written at least once: after a restart, those written since the last
+// saved Gtid are written again.
+type FileSinkRunner struct {
+	logger   *log.Entry
+	subject  string
+	natsConn *gonats.Conn
+	waitCh   chan *models.WaitResult
+
+	shutdown   bool
+	shutdownCh chan struct{}
+
+	cfg    *FileSinkConfig
+	writer *Writer
+	cipher *atrest.Cipher // with EncryptDataAtRest
+
+	// the transactions written, with those of cfg.Gtid
+	writtenLock sync.Mutex
+	written     gtid.Set
+}
+
+func NewFileSinkRunner(subject string, cfg *FileSinkConfig, logger *log.Logger) *FileSinkRunner {
+	entry := log.NewEntry(logger).WithFields(log.Fields{
+		"job": subject,
+	})
+	return &FileSinkRunner{
+		subject:    subject,
+		cfg:        cfg,
+		logger:     entry,
+		waitCh:     make(chan *models.WaitResult, 1),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+func (r *FileSinkRunner) ID() string {
+	id := config.DriverCtx{
+		DriverConfig: &config.MySQLDriverConfig{
+			Gtid:     r.writtenGtid(),
+			NatsAddr: r.cfg.NatsAddr,
+		},
+	}
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		r.logger.Errorf("filesink: Failed to marshal ID to JSON: %s", err)
+	}
+	return string(data)
+}
+
+// writtenGtid returns the GTID set of the transactions written.
+func (r *FileSinkRunner) writtenGtid() string {
+	r.writtenLock.Lock()
+	defer r.writtenLock.Unlock()
+	if r.written == nil {
+		return r.cfg.Gtid
+	}
+	return r.written.String()
+}
+
+func (r *FileSinkRunner) WaitCh() chan *models.WaitResult {
+	return r.waitCh
+}
+
+func (r *FileSinkRunner) Shutdown() error {
+	if r.shutdown {
+		return nil
+	}
+	if r.natsConn != nil {
+		r.natsConn.Close()
+	}
+	if r.writer != nil {
+		r.writer.Close()
+	}
+	r.shutdown = true
+	close(r.shutdownCh)
+
+	r.logger.Printf("filesink: Shutting down")
+	return nil
+}
+
+func (r *FileSinkRunner) Stats() (*models.TaskStatistics, error) {
+	taskResUsage := &models.TaskStatistics{
+		CurrentCoordinates: &models.CurrentCoordinates{
+			ExecutedGtidSet: r.writtenGtid(),
+		},
+	}
+	if r.writer != nil {
+		taskResUsage.FileSink = r.writer.Stat()
+	}
+	if r.cipher != nil {
+		taskResUsage.Encryption = r.cipher.Stat()
+	}
+	return taskResUsage, nil
+}
+
+func (r *FileSinkRunner) Run() {
+	var err error
+	r.writtenLock.Lock()
+	r.written, err = gtid.Parse(r.cfg.Gtid)
+	r.writtenLock.Unlock()
+	if err != nil {
+		r.onError(TaskStateDead, fmt.Errorf("bad Gtid %v: %v", r.cfg.Gtid, err))
+		return
+	}
+
+	fileSizeMB := r.cfg.FileSizeMB
+	if fileSizeMB <= 0 {
+		fileSizeMB = DefaultFileSizeMB
+	}
+	if r.cfg.EncryptDataAtRest {
+		if r.cipher, err = atrest.NewCipher(r.cfg.DataKey); err != nil {
+			r.onError(TaskStateDead, fmt.Errorf("failed to set up EncryptDataAtRest: %v", err))
+			return
+		}
+	}
+	if r.writer, err = OpenWriter(r.cfg.Dir, fileSizeMB*1024*1024, r.cipher); err != nil {
+		r.onError(TaskStateDead, fmt.Errorf("failed to open the files in %v: %v", r.cfg.Dir, err))
+		return
+	}
+	r.logger.Printf("filesink: Writing the binlog to %v from gtid %v", r.cfg.Dir, r.cfg.Gtid)
+
+	natsAddr := fmt.Sprintf("nats://%s", r.cfg.NatsAddr)
+	if r.natsConn, err = r.cfg.NatsAuth.Connect(natsAddr); err != nil {
+		r.logger.Errorf("filesink: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
+		r.onError(TaskStateDead, err)
+		return
+	}
+
+	if err := r.initiateStreaming(); err != nil {
+		r.onError(TaskStateDead, err)
+		return
+	}
+}
+
+func (r *FileSinkRunner) initiateStreaming() error {
+	_, err := r.natsConn.Subscribe(fmt.Sprintf("%s_full", r.subject), func(m *gonats.Msg) {
+		r.onError(TaskStateDead, fmt.Errorf("the Src task is copying the full data, which FileSink does not write. "+
+			"start it from a Gtid or with StartPosition"))
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.natsConn.Subscribe(fmt.Sprintf("%s_incr", r.subject), func(m *gonats.Msg) {
+		r.onError(TaskStateDead, fmt.Errorf("FileSink needs ApproveHeterogeneous on the Src task"))
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", r.subject), r.handleIncr)
+	return err
+}
+
+// handleIncr writes a message of transactions, unless they are all written already, and acks it.
+func (r *FileSinkRunner) handleIncr(m *gonats.Msg) {
+	var binlogEntries binlog.BinlogEntries
+	if err := mysqlDriver.Decode(m.Data, &binlogEntries); err != nil {
+		r.onError(TaskStateDead, err)
+		return
+	}
+
+	var gtids []string
+	r.writtenLock.Lock()
+	for _, entry := range binlogEntries.Entries {
+		if !r.written.ContainsGtid(entry.Coordinates.SID, entry.Coordinates.GNO) {
+			gtids = append(gtids, entry.Coordinates.GetGtidForThisTx())
+		}
+	}
+	r.writtenLock.Unlock()
+
+	if len(gtids) > 0 {
+		if err := r.writer.Write(m.Data, gtids); err != nil {
+			r.onError(TaskStateDead, fmt.Errorf("failed to write the binlog: %v", err))
+			return
+		}
+		r.writtenLock.Lock()
+		for _, entry := range binlogEntries.Entries {
+			r.written.AddGtid(entry.Coordinates.SID, entry.Coordinates.GNO)
+		}
+		r.writtenLock.Unlock()
+	}
+
+	if err := r.natsConn.Publish(m.Reply, nil); err != nil {
+		r.onError(TaskStateDead, err)
+	}
+	r.logger.Debugf("filesink: ack-recv. nEntries: %v, written: %v", len(binlogEntries.Entries), len(gtids))
+}
+
+func (r *FileSinkRunner) onError(state int, err error) {
+	if r.shutdown {
+		return
+	}
+	switch state {
+	case TaskStateComplete:
+		r.logger.Printf("filesink: Done")
+	case TaskStateRestart:
+		if r.natsConn != nil {
+			if err := r.natsConn.Publish(fmt.Sprintf("%s_restart", r.subject), []byte(r.writtenGtid())); err != nil {
+				r.logger.Errorf("filesink: Trigger restart: %v", err)
+			}
+		}
+	default:
+		if r.natsConn != nil {
+			if err := r.natsConn.Publish(fmt.Sprintf("%s_error", r.subject), []byte(r.writtenGtid())); err != nil {
+				r.logger.Errorf("filesink: Trigger shutdown: %v", err)
+			}
+		}
+	}
+
+	r.waitCh <- models.NewWaitResult(state, err)
+	r.Shutdown()
+}
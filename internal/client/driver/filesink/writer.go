@@ -0,0 +1,301 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package filesink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/actiontech/dtle/internal/atrest"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// filePrefix is the prefix of the files of the messages, followed by their sequence
+	// number, e.g. binlog.000001.
+	filePrefix = "binlog."
+	// IndexFile is the file of the index of the transactions in the files, one line
+	// "<gtid> <file> <offset>" per transaction.
+	IndexFile = "binlog.index"
+	// recordHeaderSize is the big endian length before each message in a file.
+	recordHeaderSize = 4
+	// EncryptedMarker is in a dir whose files are written with EncryptDataAtRest. Each message
+	// is then sealed, see atrest.Cipher, and the length before it is of the sealed one.
+	EncryptedMarker = "encrypted"
+)
+
+// Position is where a message is in the files.
+type Position struct {
+	File   string // the name of the file, in the dir
+	Offset int64
+}
+
+// Writer appends the messages of the extractor, as received, to rotating files in a dir, and
+// indexes the transactions in them.
+type Writer struct {
+	dir      string
+	fileSize int64
+	cipher   *atrest.Cipher // nil without EncryptDataAtRest
+
+	lock         sync.Mutex
+	file         *os.File
+	seq          int
+	offset       int64
+	index        *os.File
+	bytesWritten int64
+	files        int
+}
+
+// OpenWriter opens the files in dir for appending, starting a file once the last one is past
+// fileSize bytes. A message partially written to the last file, e.g. by a crash, is truncated.
+// The messages are encrypted with cipher if not nil, which the files in dir must already be.
+func OpenWriter(dir string, fileSize int64, cipher *atrest.Cipher) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	names, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEncrypted(dir, len(names) > 0, cipher != nil); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, fileSize: fileSize, cipher: cipher, files: len(names)}
+	if w.index, err = os.OpenFile(filepath.Join(dir, IndexFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return w, w.rotate()
+	}
+
+	last := names[len(names)-1]
+	if _, err := fmt.Sscanf(strings.TrimPrefix(last, filePrefix), "%d", &w.seq); err != nil {
+		return nil, fmt.Errorf("bad file name %v: %v", last, err)
+	}
+	if w.file, err = os.OpenFile(filepath.Join(dir, last), os.O_RDWR, 0644); err != nil {
+		return nil, err
+	}
+	if w.offset, err = validLength(w.file); err != nil {
+		return nil, err
+	}
+	if err := w.file.Truncate(w.offset); err != nil {
+		return nil, err
+	}
+	if _, err := w.file.Seek(w.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// listFiles returns the names of the files of the messages in dir, in order.
+func listFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), filePrefix) && info.Name() != IndexFile {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkEncrypted checks that the existing files in dir are encrypted if encrypt, and marks dir
+// as encrypted before its first file.
+func checkEncrypted(dir string, existing, encrypt bool) error {
+	marker := filepath.Join(dir, EncryptedMarker)
+	_, err := os.Stat(marker)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	encrypted := err == nil
+	switch {
+	case existing && encrypted && !encrypt:
+		return fmt.Errorf("the files in %v are encrypted. set EncryptDataAtRest, or use another Dir", dir)
+	case existing && !encrypted && encrypt:
+		return fmt.Errorf("the files in %v are not encrypted. unset EncryptDataAtRest, or use another Dir", dir)
+	case !existing && encrypt && !encrypted:
+		return ioutil.WriteFile(marker, nil, 0644)
+	case !existing && !encrypt && encrypted:
+		return os.Remove(marker)
+	}
+	return nil
+}
+
+// validLength returns the length of the complete messages at the start of f.
+func validLength(f *os.File) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		if skipped, err := r.Discard(int(n)); int64(skipped) < n || err != nil {
+			return offset, nil
+		}
+		offset += recordHeaderSize + n
+	}
+}
+
+// rotate starts the next file.
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+	w.seq++
+	f, err := os.OpenFile(filepath.Join(w.dir, fmt.Sprintf("%v%06d", filePrefix, w.seq)),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.offset = 0
+	w.files++
+	return nil
+}
+
+// Write appends msg, which has the transactions gtids, and indexes them. Both are synced to
+// the disk on return.
+func (w *Writer) Write(msg []byte, gtids []string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.offset > 0 && w.offset+recordHeaderSize+int64(len(msg)) > w.fileSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if w.cipher != nil {
+		sealed, err := w.cipher.Seal(msg)
+		if err != nil {
+			return err
+		}
+		msg = sealed
+	}
+	record := make([]byte, recordHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(record, uint32(len(msg)))
+	copy(record[recordHeaderSize:], msg)
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	var lines strings.Builder
+	for _, gtid := range gtids {
+		fmt.Fprintf(&lines, "%v %v %v\n", gtid, filepath.Base(w.file.Name()), w.offset)
+	}
+	if _, err := w.index.WriteString(lines.String()); err != nil {
+		return err
+	}
+	if err := w.index.Sync(); err != nil {
+		return err
+	}
+	w.offset += int64(len(record))
+	w.bytesWritten += int64(len(record))
+	return nil
+}
+
+// Close closes the current file and the index.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file != nil {
+		w.file.Close()
+	}
+	return w.index.Close()
+}
+
+// Stat returns the current file and the bytes written since the writer was opened.
+func (w *Writer) Stat() *models.FileSinkStat {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	stat := &models.FileSinkStat{
+		Dir:          w.dir,
+		BytesWritten: w.bytesWritten,
+		Files:        w.files,
+	}
+	if w.file != nil {
+		stat.CurrentFile = filepath.Base(w.file.Name())
+		stat.CurrentOffset = w.offset
+	}
+	return stat
+}
+
+// Locate returns the position of the message with the transaction gtid (e.g.
+// "3e11fa47-71ca-11e1-9e33-c80aa9429562:23") in the files in dir, from the index. A replay of
+// the files from a transaction starts there.
+func Locate(dir, gtid string) (*Position, error) {
+	f, err := os.Open(filepath.Join(dir, IndexFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || !strings.EqualFold(fields[0], gtid) {
+			continue
+		}
+		pos := &Position{File: fields[1]}
+		if _, err := fmt.Sscanf(fields[2], "%d", &pos.Offset); err != nil {
+			return nil, fmt.Errorf("bad index line %q: %v", scanner.Text(), err)
+		}
+		return pos, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("transaction %v is not in the index of %v", gtid, dir)
+}
+
+// ReadMessage returns the message at pos in the files in dir, and the position of the next one.
+// The messages are those of the extractor, see mysql.Decode. cipher is that of the Writer.
+func ReadMessage(dir string, pos *Position, cipher *atrest.Cipher) (msg []byte, next *Position, err error) {
+	f, err := os.Open(filepath.Join(dir, pos.File))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(pos.Offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, nil, err
+	}
+	msg = make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := io.ReadFull(f, msg); err != nil {
+		return nil, nil, err
+	}
+	next = &Position{File: pos.File, Offset: pos.Offset + recordHeaderSize + int64(len(msg))}
+	if cipher != nil {
+		if msg, err = cipher.Open(msg); err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt the message at %v:%v: %v", pos.File, pos.Offset, err)
+		}
+	}
+	return msg, next, nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package filesink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/actiontech/dtle/internal/atrest"
+	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sid := uuid.FromStringOrNil("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+	message := func(gnos ...int64) (msg []byte, gtids []string) {
+		entries := binlog.BinlogEntries{}
+		for _, gno := range gnos {
+			entry := &binlog.BinlogEntry{Coordinates: base.BinlogCoordinateTx{SID: sid, GNO: gno}}
+			entries.Entries = append(entries.Entries, entry)
+			gtids = append(gtids, entry.Coordinates.GetGtidForThisTx())
+		}
+		msg, err := mysqlDriver.Encode(&entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg, gtids
+	}
+
+	// a file holds two of the messages
+	msg, _ := message(1, 2)
+	fileSize := int64(2 * (recordHeaderSize + len(msg)))
+	w, err := OpenWriter(dir, fileSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for gno := int64(1); gno < 6; gno += 2 {
+		if err := w.Write(message(gno, gno+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stat := w.Stat()
+	if stat.CurrentFile != "binlog.000002" || stat.Files != 2 || stat.BytesWritten != 3*int64(recordHeaderSize+len(msg)) {
+		t.Errorf("Stat() = %+v", stat)
+	}
+	w.Close()
+
+	// a message partially written by a crash is truncated on the next open
+	f, err := os.OpenFile(filepath.Join(dir, "binlog.000002"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 'x'})
+	f.Close()
+	if w, err = OpenWriter(dir, fileSize, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(message(7)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	pos, err := Locate(dir, sid.String()+":4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Position{File: "binlog.000001", Offset: int64(recordHeaderSize + len(msg))}); !reflect.DeepEqual(pos, want) {
+		t.Errorf("Locate() = %+v, want %+v", pos, want)
+	}
+
+	// the messages are read back in order from the position, then from the next file
+	var gnos []int64
+	read := func(pos *Position) *Position {
+		msg, next, err := ReadMessage(dir, pos, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries binlog.BinlogEntries
+		if err := mysqlDriver.Decode(msg, &entries); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries.Entries {
+			gnos = append(gnos, entry.Coordinates.GNO)
+		}
+		return next
+	}
+	if next := read(pos); next.Offset != fileSize {
+		t.Errorf("next offset = %v, want the end of the file %v", next.Offset, fileSize)
+	}
+	read(read(&Position{File: "binlog.000002"}))
+	if !reflect.DeepEqual(gnos, []int64{3, 4, 5, 6, 7}) {
+		t.Errorf("gnos read = %v", gnos)
+	}
+
+	if _, err := Locate(dir, sid.String()+":8"); err == nil {
+		t.Errorf("Locate() of a transaction not written succeeded")
+	}
+}
+
+func TestWriter_encrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cipher, err := atrest.NewCipher(make([]byte, atrest.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := OpenWriter(dir, 1024, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("a message of the extractor")
+	if err := w.Write(msg, []string{"3e11fa47-71ca-11e1-9e33-c80aa9429562:1"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	data, err := ioutil.ReadFile(filepath.Join(dir, "binlog.000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != recordHeaderSize+len(msg)+cipher.Overhead() || strings.Contains(string(data), string(msg)) {
+		t.Errorf("the file has %q, want the message encrypted", data)
+	}
+
+	read, _, err := ReadMessage(dir, &Position{File: "binlog.000001"}, cipher)
+	if err != nil || string(read) != string(msg) {
+		t.Errorf("ReadMessage() = %q, %v, want %q", read, err, msg)
+	}
+	if stat := cipher.Stat(); stat.BytesEncrypted != int64(len(msg)) || stat.BytesDecrypted != int64(len(msg)) {
+		t.Errorf("Stat() = %+v", stat)
+	}
+
+	// the files are encrypted, or not, all together
+	if _, err := OpenWriter(dir, 1024, nil); err == nil {
+		t.Errorf("OpenWriter() without encryption of encrypted files succeeded")
+	}
+}
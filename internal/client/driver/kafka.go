@@ -22,6 +22,7 @@ func (kd *KafkaDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle,
 	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
 		return nil, err
 	}
+	driverConfig.NatsAuth = ctx.NatsAuth
 
 	switch task.Type {
 	case models.TaskTypeSrc:
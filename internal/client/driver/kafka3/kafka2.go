@@ -19,6 +19,8 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+
+	"github.com/actiontech/dtle/internal/config"
 )
 
 type SchemaType string
@@ -53,6 +55,8 @@ type KafkaConfig struct {
 	Converter string
 	NatsAddr  string
 	Gtid      string // TODO remove?
+
+	NatsAuth *config.NatsAuthConfig `json:"-"` // set by the client
 }
 
 type KafkaManager struct {
@@ -8,7 +8,6 @@ package kafka3
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -16,7 +15,6 @@ import (
 	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
 	"github.com/actiontech/dtle/internal/config/mysql"
 
-	"github.com/golang/snappy"
 	gonats "github.com/nats-io/go-nats"
 	"github.com/satori/go.uuid"
 
@@ -112,7 +110,7 @@ func (kr *KafkaRunner) Stats() (*models.TaskStatistics, error) {
 }
 func (kr *KafkaRunner) initNatSubClient() (err error) {
 	natsAddr := fmt.Sprintf("nats://%s", kr.kafkaConfig.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	sc, err := kr.kafkaConfig.NatsAuth.Connect(natsAddr)
 	if err != nil {
 		kr.logger.Errorf("kafka: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
 		return err
@@ -234,14 +232,9 @@ func (kr *KafkaRunner) initiateStreaming() error {
 	return nil
 }
 
-// TODO move to one place
+// Decode decodes a message of the mysql extractor.
 func Decode(data []byte, vPtr interface{}) (err error) {
-	msg, err := snappy.Decode(nil, data)
-	if err != nil {
-		return err
-	}
-
-	return gob.NewDecoder(bytes.NewBuffer(msg)).Decode(vPtr)
+	return mysqlDriver.Decode(data, vPtr)
 }
 
 func (kr *KafkaRunner) onError(state int, err error) {
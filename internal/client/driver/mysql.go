@@ -7,7 +7,10 @@
 package driver
 
 import (
+	gosql "database/sql"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
@@ -16,6 +19,7 @@ import (
 	ubase "github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	"github.com/actiontech/dtle/internal/models"
 
 	"github.com/actiontech/dtle/internal/g"
@@ -37,8 +41,17 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
 		return reply, err
 	}
+	if err := config.ValidateEventFilters(driverConfig.EventFilters); err != nil {
+		return reply, err
+	}
+	// Validation runs on the server, which must not see the secrets.
+	if umconf.IsSecretRef(driverConfig.ConnectionConfig.User) || umconf.IsSecretRef(driverConfig.ConnectionConfig.Password) {
+		reply.Connection.Success = false
+		reply.Connection.Error = "not validated: the credentials are resolved on the client at task start"
+		return reply, nil
+	}
 	uri := driverConfig.ConnectionConfig.GetDBUri()
-	db, err := usql.CreateDB(uri)
+	db, err := usql.CreateDB(uri, driverConfig.InitSQL...)
 	if err != nil {
 		return reply, err
 	}
@@ -107,6 +120,19 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 		} else {
 			reply.ServerID.Success = true
 		}
+		if reply.ServerID.ReplicaServerId = driverConfig.ServerIdRetry; reply.ServerID.ReplicaServerId == 0 {
+			reply.ServerID.ReplicaServerId = driverConfig.ReplicaServerId
+		}
+		if err := driverConfig.ValidateReplicaServerId(); err != nil {
+			reply.ServerID.Success = false
+			reply.ServerID.Error = err.Error()
+		} else if id := reply.ServerID.ReplicaServerId; id != 0 {
+			// not checked if the replicas cannot be listed, e.g. without REPLICATION SLAVE
+			if ids, err := replicaServerIds(db); err == nil && ids[id] {
+				reply.ServerID.Success = false
+				reply.ServerID.Error = fmt.Sprintf("ReplicaServerId %v is used by another replica of the source", id)
+			}
+		}
 
 		query = `select @@global.log_bin, @@global.binlog_format`
 		var hasBinaryLogs bool
@@ -123,6 +149,11 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 		} else {
 			reply.Binlog.Success = true
 		}
+		// not before MySQL 8.0.20
+		query = `select @@global.binlog_transaction_compression`
+		if err := db.QueryRow(query).Scan(&reply.Binlog.TransactionCompression); err != nil {
+			reply.Binlog.TransactionCompression = false
+		}
 
 		query = `show grants for current_user()`
 		foundAll := false
@@ -171,6 +202,51 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 			reply.Privileges.Success = false
 			reply.Privileges.Error = fmt.Sprintf("User has insufficient privileges for extractor. Needed: SUPER|REPLICATION CLIENT, REPLICATION SLAVE and ALL on *.*")
 		}
+
+		reply.NoPkTables.Policy = driverConfig.SetDefault().NoPkTablePolicy
+		query = `select t.table_schema as tb_schema, t.table_name as tb_name from information_schema.tables t
+			left join information_schema.table_constraints c on c.table_schema = t.table_schema
+				and c.table_name = t.table_name and c.constraint_type = 'PRIMARY KEY'
+			where t.table_type = 'BASE TABLE' and c.constraint_name is null
+				and t.table_schema not in ('mysql', 'information_schema', 'performance_schema', 'sys')`
+		err = usql.QueryRowsMap(db, query, func(rowMap usql.RowMap) error {
+			schema, table := rowMap.GetString("tb_schema"), rowMap.GetString("tb_name")
+			if isReplicatedTable(&driverConfig, schema, table) {
+				reply.NoPkTables.Tables = append(reply.NoPkTables.Tables, fmt.Sprintf("%s.%s", schema, table))
+			}
+			return nil
+		})
+		if err != nil {
+			reply.NoPkTables.Success = false
+			reply.NoPkTables.Error = err.Error()
+		} else if len(reply.NoPkTables.Tables) > 0 && reply.NoPkTables.Policy == config.NoPkTablePolicyReject {
+			reply.NoPkTables.Success = false
+			reply.NoPkTables.Error = fmt.Sprintf("Tables without primary key are rejected by NoPkTablePolicy %v: %v",
+				reply.NoPkTables.Policy, strings.Join(reply.NoPkTables.Tables, ", "))
+		} else {
+			reply.NoPkTables.Success = true
+		}
+
+		reply.ZeroDates.Policy = driverConfig.SetDefault().ZeroDatePolicy
+		query = `select table_schema as tb_schema, table_name as tb_name, column_name as col_name,
+				column_default as col_default
+			from information_schema.columns
+			where data_type in ('date', 'datetime', 'timestamp') and column_default is not null
+				and table_schema not in ('mysql', 'information_schema', 'performance_schema', 'sys')`
+		err = usql.QueryRowsMap(db, query, func(rowMap usql.RowMap) error {
+			schema, table := rowMap.GetString("tb_schema"), rowMap.GetString("tb_name")
+			if mysql.IsZeroOrInvalidDate(rowMap.GetString("col_default")) && isReplicatedTable(&driverConfig, schema, table) {
+				reply.ZeroDates.Columns = append(reply.ZeroDates.Columns,
+					fmt.Sprintf("%s.%s.%s", schema, table, rowMap.GetString("col_name")))
+			}
+			return nil
+		})
+		if err != nil {
+			reply.ZeroDates.Success = false
+			reply.ZeroDates.Error = err.Error()
+		} else {
+			reply.ZeroDates.Success = true
+		}
 	} else {
 		query := `show grants for current_user()`
 		foundAll := false
@@ -212,6 +288,28 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 			reply.Privileges.Success = false
 			reply.Privileges.Error = fmt.Sprintf("user has insufficient privileges for applier. Needed: SUPER|ALL on *.*")
 		}
+		if reply.Privileges.Success && !driverConfig.SkipCreateDbTable {
+			missing, err := mysql.MissingCreateTablePrivileges(db, &driverConfig)
+			if err != nil {
+				reply.Privileges.Success = false
+				reply.Privileges.Error = err.Error()
+			} else if len(missing) > 0 {
+				reply.Privileges.Success = false
+				reply.Privileges.Error = fmt.Sprintf("user has insufficient privileges to create the tables on the target"+
+					" (set SkipCreateDbTable to skip). Missing: %v", strings.Join(missing, ", "))
+			}
+		}
+
+		reply.ZeroDates.Policy = driverConfig.SetDefault().ZeroDatePolicy
+		var sqlMode string
+		if err := db.QueryRow(`select @@session.sql_mode`).Scan(&sqlMode); err != nil {
+			reply.ZeroDates.Success = false
+			reply.ZeroDates.Error = err.Error()
+		} else {
+			reply.ZeroDates.Success = true
+			reply.ZeroDates.StrictTarget = mysql.RejectsZeroDates(sqlMode) &&
+				reply.ZeroDates.Policy == config.ZeroDatePolicyFail
+		}
 	}
 	if task.Config["ExpandSyntaxSupport"] == true {
 		if _, err := db.Query("use mysql"); err != nil {
@@ -222,18 +320,124 @@ func (m *MySQLDriver) Validate(task *models.Task) (*models.TaskValidateResponse,
 	return reply, nil
 }
 
+// isReplicatedTable tells if the table is selected by ReplicateDoDb and not by ReplicateIgnoreDb.
+// replicaServerIds returns the server_ids of the replicas connected to the source, by
+// SHOW SLAVE HOSTS.
+func replicaServerIds(db *gosql.DB) (map[uint32]bool, error) {
+	rows, err := db.Query("SHOW SLAVE HOSTS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[uint32]bool)
+	for rows.Next() {
+		values := make([]gosql.RawBytes, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		// Server_id is the first column
+		id, err := strconv.ParseUint(string(values[0]), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids[uint32(id)] = true
+	}
+	return ids, rows.Err()
+}
+
+func isReplicatedTable(driverConfig *config.MySQLDriverConfig, schema, table string) bool {
+	matchTable := func(doDb *config.DataSource) bool {
+		if doDb.TableSchema != "" {
+			if doDb.TableSchema != schema {
+				return false
+			}
+		} else if doDb.TableSchemaRegex != "" {
+			if matched, err := regexp.MatchString(doDb.TableSchemaRegex, schema); err != nil || !matched {
+				return false
+			}
+		} else {
+			return false
+		}
+		if len(doDb.Tables) == 0 {
+			return true
+		}
+		for _, tb := range doDb.Tables {
+			if tb.TableName != "" {
+				if tb.TableName == table {
+					return true
+				}
+			} else if tb.TableRegex != "" {
+				if matched, err := regexp.MatchString(tb.TableRegex, table); err == nil && matched {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, ignoreDb := range driverConfig.ReplicateIgnoreDb {
+		if matchTable(ignoreDb) {
+			return false
+		}
+	}
+	if len(driverConfig.ReplicateDoDb) == 0 {
+		return true
+	}
+	for _, doDb := range driverConfig.ReplicateDoDb {
+		if matchTable(doDb) {
+			return true
+		}
+	}
+	return false
+}
 func (m *MySQLDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle, error) {
 	var driverConfig config.MySQLDriverConfig
 	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
 		return nil, err
 	}
+	driverConfig.NatsAuth = ctx.NatsAuth
+	driverConfig.AllocID = ctx.AllocID
+	if m.node != nil {
+		driverConfig.NodeId = m.node.ID
+		driverConfig.CodecV2Supported = m.node.Attributes[models.NodeAttrFeaturePrefix+models.FeatureCodecV2] == "1"
+	}
+	if ctx.TaskDir != nil {
+		driverConfig.DataDir = ctx.TaskDir.DataDir
+		driverConfig.TmpDir = ctx.TaskDir.TmpDir
+	}
+	driverConfig.AuxDisk = ctx.AuxDisk
+	driverConfig.SaveStartPosition = ctx.SaveStartPosition
+	driverConfig.ApplyLatencyExemplars = ctx.ApplyLatencyExemplars
+	if driverConfig.EncryptDataAtRest {
+		if ctx.TaskDir == nil {
+			return nil, fmt.Errorf("EncryptDataAtRest needs an alloc dir, for the data key")
+		}
+		key, err := ctx.TaskDir.DataKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the data key: %v", err)
+		}
+		driverConfig.DataKey = key
+	}
+	if driverConfig.ConnectionConfig != nil {
+		if err := driverConfig.ConnectionConfig.ResolveSecrets(); err != nil {
+			return nil, err
+		}
+	}
 
 	switch task.Type {
 	case models.TaskTypeSrc:
 		{
 			m.logger.Debugf("NewExtractor ReplicateDoDb: %v", driverConfig.ReplicateDoDb)
 			// Create the extractor
-			e, err := mysql.NewExtractor(ctx.Subject, ctx.Tp, ctx.MaxPayload, &driverConfig, m.logger)
+			e, err := mysql.NewExtractor(ctx.Subject, ctx.Tp, ctx.MaxPayload, &driverConfig, m.logger, m.emitEvent)
 			if err != nil {
 				return nil, err
 			}
@@ -243,13 +447,23 @@ func (m *MySQLDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle,
 	case models.TaskTypeDest:
 		{
 			m.logger.Debugf("NewApplier ReplicateDoDb: %v", driverConfig.ReplicateDoDb)
-			a, err := mysql.NewApplier(ctx.Subject, ctx.Tp, &driverConfig, m.logger)
+			a, err := mysql.NewApplier(ctx.Subject, ctx.Tp, &driverConfig, m.logger, m.emitEvent)
 			if err != nil {
 				return nil, err
 			}
 			go a.Run()
 			return a, nil
 		}
+	case models.TaskTypeExport:
+		{
+			m.logger.Debugf("NewExporter ReplicateDoDb: %v", driverConfig.ReplicateDoDb)
+			x, err := mysql.NewExporter(ctx.Subject, &driverConfig, m.logger, m.emitEvent)
+			if err != nil {
+				return nil, err
+			}
+			go x.Run()
+			return x, nil
+		}
 	default:
 		{
 			return nil, fmt.Errorf("unknown processor type : %+v", task.Type)
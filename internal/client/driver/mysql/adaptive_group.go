@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// number of the last group size changes kept for TaskStatistics
+	groupSizeHistoryLen = 20
+)
+
+// groupSizer decides how many transactions the applier commits together, with AdaptiveGroup.
+// The size grows by one after a full group applied within the target latency, and is halved
+// after a slower group, an error or a deadlock (AIMD).
+type groupSizer struct {
+	lock          sync.Mutex
+	min           int
+	max           int
+	targetLatency time.Duration
+	size          int
+	// the last changes of size, oldest first
+	history []*models.GroupSizeChange
+}
+
+func newGroupSizer(min, max int, targetLatency time.Duration) *groupSizer {
+	return &groupSizer{
+		min:           min,
+		max:           max,
+		targetLatency: targetLatency,
+		size:          min,
+	}
+}
+
+func (g *groupSizer) Size() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.size
+}
+
+// observe adjusts the size after a group of n transactions was applied in latency.
+func (g *groupSizer) observe(n int, latency time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if latency > g.targetLatency {
+		g.resize(g.size/2, fmt.Sprintf("group of %v applied in %v, over the target %v", n, latency, g.targetLatency))
+	} else if n >= g.size {
+		g.resize(g.size+1, fmt.Sprintf("group of %v applied in %v", n, latency))
+	}
+}
+
+// shrink halves the size, e.g. after an error.
+func (g *groupSizer) shrink(reason string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.resize(g.size/2, reason)
+}
+
+// resize must be called with lock held.
+func (g *groupSizer) resize(size int, reason string) {
+	if size < g.min {
+		size = g.min
+	}
+	if size > g.max {
+		size = g.max
+	}
+	if size == g.size {
+		return
+	}
+	g.history = append(g.history, &models.GroupSizeChange{
+		From:      g.size,
+		To:        size,
+		Reason:    reason,
+		Timestamp: time.Now().UnixNano(),
+	})
+	if len(g.history) > groupSizeHistoryLen {
+		g.history = g.history[len(g.history)-groupSizeHistoryLen:]
+	}
+	g.size = size
+}
+
+// stat returns the size and a copy of the history.
+func (g *groupSizer) stat() (int, []*models.GroupSizeChange) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.size, append([]*models.GroupSizeChange(nil), g.history...)
+}
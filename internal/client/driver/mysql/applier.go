@@ -19,13 +19,13 @@ import (
 
 	//"encoding/base64"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/snappy"
 	gonats "github.com/nats-io/go-nats"
 	gomysql "github.com/siddontang/go-mysql/mysql"
 
@@ -34,11 +34,14 @@ import (
 	"encoding/hex"
 	"os"
 
+	"github.com/actiontech/dtle/internal/atrest"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/faults"
+	"github.com/actiontech/dtle/internal/gtid"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 	"github.com/actiontech/dtle/utils"
@@ -74,21 +77,41 @@ func (q *Int64PriQueue) Pop() interface{} {
 }
 
 type applierTableItem struct {
-	columns  *umconf.ColumnList
-	psInsert []*gosql.Stmt
-	psDelete []*gosql.Stmt
-	psUpdate []*gosql.Stmt
+	// the cached structure the columns and charsets are of
+	meta    *tableMeta
+	columns *umconf.ColumnList
+	// target charsets of the string columns, by name
+	charsets map[string]string
+	// kinds of the DATE, DATETIME and TIMESTAMP columns, by name
+	temporals map[string]string
+	psInsert  []*gosql.Stmt
+	psDelete  []*gosql.Stmt
+	psUpdate  []*gosql.Stmt
+	// for non-FULL row images. The columns vary with events. query -> stmt
+	psImage []map[string]*gosql.Stmt
 }
 
 func newApplierTableItem(parallelWorkers int) *applierTableItem {
+	psImage := make([]map[string]*gosql.Stmt, parallelWorkers)
+	for i := range psImage {
+		psImage[i] = make(map[string]*gosql.Stmt)
+	}
 	return &applierTableItem{
 		columns:  nil,
 		psInsert: make([]*gosql.Stmt, parallelWorkers),
 		psDelete: make([]*gosql.Stmt, parallelWorkers),
 		psUpdate: make([]*gosql.Stmt, parallelWorkers),
+		psImage:  psImage,
 	}
 }
 func (ait *applierTableItem) Reset() {
+	ait.closeStmts()
+	ait.meta = nil
+	ait.columns = nil
+}
+
+// closeStmts closes the prepared statements but keeps the columns.
+func (ait *applierTableItem) closeStmts() {
 	// TODO handle err of `.Close()`?
 	closeStmts := func(stmts []*gosql.Stmt) {
 		for i := range stmts {
@@ -101,8 +124,12 @@ func (ait *applierTableItem) Reset() {
 	closeStmts(ait.psInsert)
 	closeStmts(ait.psDelete)
 	closeStmts(ait.psUpdate)
-
-	ait.columns = nil
+	for i := range ait.psImage {
+		for query, stmt := range ait.psImage[i] {
+			stmt.Close()
+			delete(ait.psImage[i], query)
+		}
+	}
 }
 
 type mapSchemaTableItems map[string](map[string](*applierTableItem))
@@ -132,6 +159,23 @@ func NewMtsManager(shutdownCh chan struct{}) *MtsManager {
 	}
 }
 
+// WaitForSequence blocks until all tx with seqNum <= seq have been executed.
+// Return false for abortion.
+func (mm *MtsManager) WaitForSequence(seq int64) bool {
+	for {
+		if atomic.LoadInt64(&mm.lastCommitted) >= seq {
+			return true
+		}
+
+		select {
+		case <-mm.updated:
+			// continue
+		case <-mm.shutdownCh:
+			return false
+		}
+	}
+}
+
 //  This function must be called sequentially.
 func (mm *MtsManager) WaitForAllCommitted() bool {
 	for {
@@ -209,23 +253,30 @@ type Applier struct {
 	subjectUUID        uuid.UUID
 	tp                 string
 	mysqlContext       *config.MySQLDriverConfig
-	dbs                []*sql.Conn
+	dbs                []*sql.Conn // by worker index. see workersLock
 	db                 *gosql.DB
 	gtidExecuted       base.GtidSet
 	currentCoordinates *models.CurrentCoordinates
 	tableItems         mapSchemaTableItems
+	// structure of the target tables
+	metaCache *tableMetaCache
 
 	rowCopyComplete     chan bool
 	rowCopyCompleteFlag int64
 	// copyRowsQueue should not be buffered; if buffered some non-damaging but
 	//  excessive work happens at the end of the iteration as new copy-jobs arrive befroe realizing the copy is complete
 	copyRowsQueue           chan *DumpEntry
+	dumpResumed             bool // the full copy is resumed from mysqlContext.DumpProgress
 	applyDataEntryQueue     chan *binlog.BinlogEntry
 	applyBinlogTxQueue      chan *binlog.BinlogTx
 	applyBinlogGroupTxQueue chan []*binlog.BinlogTx
 	// only TX can be executed should be put into this chan
 	applyBinlogMtsTxQueue chan *binlog.BinlogEntry
 	lastAppliedBinlogTx   *binlog.BinlogTx
+	// *tableHandoff of ConcurrentIncrementalCopy, during the full copy. handoffGtid is the snapshot
+	// of the full copy, once it is complete
+	handoff     atomic.Value
+	handoffGtid string
 
 	natsConn *gonats.Conn
 	waitCh   chan *models.WaitResult
@@ -235,15 +286,133 @@ type Applier struct {
 	shutdownCh   chan struct{}
 	shutdownLock sync.Mutex
 
+	// stopCh is closed by Stop for the replay to commit the txs in progress and exit,
+	// closing replayDone. replaying is 1 once the replay is started.
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	replayDone chan struct{}
+	replaying  int32
+	// the applier is stopped by Stop. guarded by shutdownLock
+	stopped bool
+
 	mtsManager     *MtsManager
 	printTps       bool
 	txLastNSeconds uint32
 	nDumpEntry     int64
 
+	// the MTS workers, by index, and the resize requests of SetWorkers. dbs and
+	// writeSet are changed by resizeWorkers with workersLock held, which the
+	// goroutines other than the replay and the workers hold to read them.
+	workers       []*applierWorker
+	workersCh     chan *workersRequest
+	workersLock   sync.RWMutex
+	activeWorkers int32
+	// the workers to restart on the next transaction, while the replay has parked the
+	// applier idle with no workers and no connections to the target. see park
+	parkedWorkers int
+	parked        int32
+	idleParks     int64
+
 	stubFullApplyDelay bool
+	// binlog_row_image of the last applied rows event
+	rowImage atomic.Value
+
+	// "schema.table" -> index of DependencyGroups
+	dependencyGroupIndex map[string]int
+	// index of DependencyGroups -> seqNum of the last enqueued tx touching the group
+	dependencyGroupLastSeq map[int]int64
+	// conflicting txs by the written keys, with ParallelWorkers > 1. nil otherwise.
+	writeSet *writeSetTracker
+	// the size of the groups of txs committed together, with AdaptiveGroup. nil otherwise.
+	groupSizer *groupSizer
+	// serializes the txs retried after a deadlock among the workers. see applyGated
+	tableGate         *tableGate
+	workerDeadlocks   int64
+	externalDeadlocks int64
+	deadlockRetries   int64
+	// "schema.table" -> DML types not to be applied, from DmlFilter
+	dmlFilterIndex map[string]map[binlog.EventDML]bool
+
+	// applied rows events, for TaskStatistics.TableStats
+	insertCount int64
+	updateCount int64
+	deleteCount int64
+	// rows of the full copy conflicting with the target, resolved by ConflictPolicy
+	conflictCount int64
+
+	// emits a task event. might be nil.
+	eventEmitter func(message string, args ...interface{})
+
+	// serializes waitForWritableTarget. failoverGen is increased on each reconnection to the target.
+	failoverLock   sync.Mutex
+	failoverGen    int64
+	readOnlyPauses int64
+	failovers      int64
+
+	// kills the statements running for longer than MaxExecTime. nil without
+	execWatchdog *execWatchdog
+	execTimeouts int64
+
+	// string. the codec of the last transactions received
+	msgCodec atomic.Value
+
+	// UseLoadData. loadDataDisabled is set when the target disallows it. accessed atomically
+	loadDataDisabled int32
+	loadDataRows     int64
+	loadDataNanos    int64
+	// encrypts the LOAD DATA files with EncryptDataAtRest
+	dataCipher *atrest.Cipher
+
+	// the result of the last row count check
+	rowCounts     []*models.TableRowCount
+	rowCountsLock sync.Mutex
+
+	// the zero dates converted for ZeroDatePolicy, by "schema.table"
+	zeroDates     map[string]int64
+	zeroDatesLock sync.Mutex
+	// the errors ignored for IgnoreErrorCodes, by code
+	ignoredErrors     map[string]int64
+	ignoredErrorsLock sync.Mutex
+	// protects mysqlContext.DumpProgress, set while copying and read by ID() and Stats()
+	dumpProgressLock sync.Mutex
+
+	transport *transportCounter
+	copyStat  *copyStat
+	txCounter *txCounter
+	// the apply latency of the tables, with ApplyLatencyExemplars. nil if disabled
+	latencyTracker *latencyTracker
+
+	// the counters at the last stats reset. see ResetStats
+	statsBaseline statsBaseline
+
+	// the counters last reported by the extractor. Protected by peerTransportLock.
+	peerTransportLock     sync.Mutex
+	peerTransport         []*models.SubjectStat
+	transportIdleReported bool
+	// the lag of the replica the extractor reads with ReadFromReplica, as last reported by it.
+	// accessed atomically
+	sourceReplicaLag int64
+
+	// for TaskStatistics.GtidGap. Protected by gtidGapLock.
+	gtidGapLock    sync.Mutex
+	gtidReceived   gtid.Set
+	gtidApplied    gtid.Set
+	lastReceivedTs uint32
+	lastAppliedTs  uint32
+	// the GTID set the incremental apply started from. see executedGtid
+	gtidBase gtid.Set
+	// the source binlog position of the last applied transaction, for HeartbeatTable
+	lastAppliedFile string
+	lastAppliedPos  int64
+
+	// the initialization phase, for StartDeadline
+	startup startupTracker
+	// writes HeartbeatTable. nil if not set
+	heartbeatTable *heartbeatTableWriter
 }
 
-func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.Logger) (*Applier, error) {
+func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.Logger,
+	eventEmitter func(message string, args ...interface{})) (*Applier, error) {
 	cfg = cfg.SetDefault()
 	entry := log.NewEntry(logger).WithFields(log.Fields{
 		"job": subject,
@@ -264,21 +433,150 @@ func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.L
 		tableItems:              make(mapSchemaTableItems),
 		rowCopyComplete:         make(chan bool, 1),
 		copyRowsQueue:           make(chan *DumpEntry, 24),
+		dumpResumed:             cfg.DumpProgress != nil,
 		applyDataEntryQueue:     make(chan *binlog.BinlogEntry, cfg.ReplChanBufferSize*2),
 		applyBinlogMtsTxQueue:   make(chan *binlog.BinlogEntry, cfg.ReplChanBufferSize*2),
 		applyBinlogTxQueue:      make(chan *binlog.BinlogTx, cfg.ReplChanBufferSize*2),
 		applyBinlogGroupTxQueue: make(chan []*binlog.BinlogTx, cfg.ReplChanBufferSize*2),
 		waitCh:                  make(chan *models.WaitResult, 1),
 		shutdownCh:              make(chan struct{}),
+		stopCh:                  make(chan struct{}),
+		replayDone:              make(chan struct{}),
+		workersCh:               make(chan *workersRequest),
 		printTps:                os.Getenv(g.ENV_PRINT_TPS) != "",
 		stubFullApplyDelay:      os.Getenv(g.ENV_FULL_APPLY_DELAY) != "",
+		dependencyGroupLastSeq:  make(map[int]int64),
+		eventEmitter:            eventEmitter,
+		gtidReceived:            make(gtid.Set),
+		gtidApplied:             make(gtid.Set),
+		transport:               newTransportCounter(),
+		copyStat:                newCopyStat(),
+		txCounter:               newTxCounter(time.Duration(cfg.ErrorRateWindow)*time.Second, time.Now()),
+	}
+	var metaTTL time.Duration
+	if config.EventFiltersMayDropDDL(cfg.EventFilters) {
+		// the DDLs dropped do not invalidate the structures
+		metaTTL = time.Duration(cfg.MetadataCacheTTL) * time.Second
+	}
+	a.metaCache, err = newTableMetaCache(cfg.MetadataCacheSize, metaTTL)
+	if err != nil {
+		return nil, err
+	}
+	a.dependencyGroupIndex, err = newDependencyGroupIndex(cfg.DependencyGroups)
+	if err != nil {
+		return nil, err
+	}
+	a.dmlFilterIndex, err = newDmlFilterIndex(cfg.ReplicateDoDb)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.EncryptDataAtRest {
+		if a.dataCipher, err = atrest.NewCipher(cfg.DataKey); err != nil {
+			return nil, fmt.Errorf("failed to set up EncryptDataAtRest: %v", err)
+		}
+	}
+	for table, filter := range a.dmlFilterIndex {
+		if filter[binlog.DeleteDML] && !filter[binlog.InsertDML] {
+			a.logger.Warnf("mysql.applier: DELETE is filtered on %v. Rows deleted on the source are kept on the target,"+
+				" and will be replaced by rows inserted later with the same key", table)
+			a.emitEvent("DELETE is filtered on %v. Rows deleted on the source are kept on the target,"+
+				" and will be replaced by rows inserted later with the same key", table)
+		}
+	}
+	a.warnRiskyIgnoreErrorCodes()
+	if cfg.ParallelWorkers > 1 {
+		a.writeSet = newWriteSetTracker(cfg.WriteSetStrict)
 	}
+	if cfg.ApplyLatencyExemplars {
+		a.latencyTracker = newLatencyTracker()
+	}
+	if cfg.MaxExecTime > 0 {
+		a.execWatchdog = newExecWatchdog(time.Duration(cfg.MaxExecTime) * time.Second)
+	}
+	if cfg.AdaptiveGroup {
+		if cfg.WriteSetStrict {
+			a.logger.Warnf("mysql.applier: AdaptiveGroup is disabled with WriteSetStrict. applying transactions one by one")
+		} else {
+			a.groupSizer = newGroupSizer(cfg.AdaptiveGroupMinSize, cfg.AdaptiveGroupMaxSize,
+				time.Duration(cfg.AdaptiveGroupTargetLatency)*time.Millisecond)
+		}
+	}
+	a.tableGate = newTableGate()
 	a.mtsManager = NewMtsManager(a.shutdownCh)
 	go a.mtsManager.LcUpdater()
 	return a, nil
 }
 
-func (a *Applier) MtsWorker(workerIndex int) {
+func newDependencyGroupIndex(groups [][]string) (map[string]int, error) {
+	index := make(map[string]int)
+	for i, group := range groups {
+		for _, table := range group {
+			if strings.Count(table, ".") != 1 {
+				return nil, fmt.Errorf("bad table in DependencyGroups: '%v'. Expect 'schema.table'", table)
+			}
+			if j, ok := index[table]; ok && j != i {
+				return nil, fmt.Errorf("table %v is in more than one DependencyGroups", table)
+			}
+			index[table] = i
+		}
+	}
+	return index, nil
+}
+
+func newDmlFilterIndex(dataSources []*config.DataSource) (map[string]map[binlog.EventDML]bool, error) {
+	index := make(map[string]map[binlog.EventDML]bool)
+	for _, db := range dataSources {
+		for _, tb := range db.Tables {
+			if len(tb.DmlFilter) == 0 {
+				continue
+			}
+			filter := make(map[binlog.EventDML]bool)
+			for _, dml := range tb.DmlFilter {
+				switch strings.ToLower(dml) {
+				case "insert":
+					filter[binlog.InsertDML] = true
+				case "update":
+					filter[binlog.UpdateDML] = true
+				case "delete":
+					filter[binlog.DeleteDML] = true
+				default:
+					return nil, fmt.Errorf("bad DmlFilter of table %v.%v: '%v'. Expect insert, update or delete",
+						db.TableSchema, tb.TableName, dml)
+				}
+			}
+			index[fmt.Sprintf("%v.%v", db.TableSchema, tb.TableName)] = filter
+		}
+	}
+	return index, nil
+}
+
+// isDmlFiltered tells whether the rows event is not to be applied, regarding DmlFilter.
+func (a *Applier) isDmlFiltered(event *binlog.DataEvent) bool {
+	if len(a.dmlFilterIndex) == 0 {
+		return false
+	}
+	return a.dmlFilterIndex[fmt.Sprintf("%v.%v", event.DatabaseName, event.TableName)][event.DML]
+}
+
+// dependencyGroupsOf returns indexes of DependencyGroups touched by the tx.
+func (a *Applier) dependencyGroupsOf(binlogEntry *binlog.BinlogEntry) (groups []int) {
+	if len(a.dependencyGroupIndex) == 0 {
+		return nil
+	}
+	seen := make(map[int]bool)
+	for i := range binlogEntry.Events {
+		event := &binlogEntry.Events[i]
+		if group, ok := a.dependencyGroupIndex[fmt.Sprintf("%v.%v", event.DatabaseName, event.TableName)]; ok && !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (a *Applier) MtsWorker(workerIndex int, w *applierWorker) {
+	defer close(w.done)
+	conn := a.dbs[workerIndex]
 	keepLoop := true
 
 	for keepLoop {
@@ -287,8 +585,13 @@ func (a *Applier) MtsWorker(workerIndex int) {
 		case tx := <-a.applyBinlogMtsTxQueue:
 			a.logger.Debugf("mysql.applier: a binlogEntry MTS dequeue, worker: %v. GNO: %v",
 				workerIndex, tx.Coordinates.GNO)
-			if err := a.ApplyBinlogEvent(workerIndex, tx); err != nil {
-				a.onError(TaskStateDead, err) // TODO coordinate with other goroutine
+			group, rest := a.dequeueGroup(tx)
+			err := a.ApplyBinlogEvent(workerIndex, group...)
+			if err == nil && rest != nil {
+				err = a.ApplyBinlogEvent(workerIndex, rest)
+			}
+			if err != nil {
+				a.onError(a.applyErrorState(err), err) // TODO coordinate with other goroutine
 				keepLoop = false
 			} else {
 				// do nothing
@@ -297,8 +600,11 @@ func (a *Applier) MtsWorker(workerIndex int) {
 				workerIndex, tx.Coordinates.GNO)
 		case <-a.shutdownCh:
 			keepLoop = false
+		case <-w.stopCh:
+			// retired by resizeWorkers
+			keepLoop = false
 		case <-timer.C:
-			err := a.dbs[workerIndex].Db.PingContext(context.Background())
+			err := conn.Db.PingContext(context.Background())
 			if err != nil {
 				a.logger.Errorf("mysql.applier. bad connection for mts worker. workerIndex: %v, err: %v",
 					workerIndex, err)
@@ -308,6 +614,41 @@ func (a *Applier) MtsWorker(workerIndex int) {
 	}
 }
 
+// dequeueGroup returns tx, and with AdaptiveGroup, the txs following it which are already
+// in the queue, up to the group size. Those txs do not depend on any tx not yet applied:
+// they would not have been enqueued otherwise.
+// A tx with a NotDML event (e.g. a DDL) implicitly commits the txs before it, so it is never
+// grouped: the group stops before it, and it is returned as rest, to be applied after the group.
+func (a *Applier) dequeueGroup(tx *binlog.BinlogEntry) (group []*binlog.BinlogEntry, rest *binlog.BinlogEntry) {
+	group = []*binlog.BinlogEntry{tx}
+	if a.groupSizer == nil || hasNotDML(tx) {
+		return group, nil
+	}
+	size := a.groupSizer.Size()
+	for len(group) < size {
+		select {
+		case next := <-a.applyBinlogMtsTxQueue:
+			if hasNotDML(next) {
+				return group, next
+			}
+			group = append(group, next)
+		default:
+			return group, nil
+		}
+	}
+	return group, nil
+}
+
+// hasNotDML tells whether the tx has a NotDML event.
+func hasNotDML(binlogEntry *binlog.BinlogEntry) bool {
+	for i := range binlogEntry.Events {
+		if binlogEntry.Events[i].DML == binlog.NotDML {
+			return true
+		}
+	}
+	return false
+}
+
 // Run executes the complete apply logic.
 func (a *Applier) Run() {
 	if a.printTps {
@@ -322,24 +663,38 @@ func (a *Applier) Run() {
 
 	a.logger.Printf("mysql.applier: Apply binlog events to %s.%d", a.mysqlContext.ConnectionConfig.Host, a.mysqlContext.ConnectionConfig.Port)
 	a.mysqlContext.StartTime = time.Now()
+	a.startup.enter(startupPhaseTargetConnection)
 	if err := a.initDBConnections(); err != nil {
 		a.onError(TaskStateDead, err)
 		return
 	}
+	if err := a.initHeartbeatTable(); err != nil {
+		a.onError(TaskStateDead, err)
+		return
+	}
+	a.startup.enter(startupPhaseNatsConnection)
 	if err := a.initNatSubClient(); err != nil {
 		a.onError(TaskStateDead, err)
 		return
 	}
+	go a.periodicStatsPublish()
 
+	// before the replay, which resizes the pool
+	for i := 0; i < a.mysqlContext.ParallelWorkers; i++ {
+		a.startWorker(i)
+	}
+	atomic.StoreInt32(&a.activeWorkers, int32(a.mysqlContext.ParallelWorkers))
+
+	a.startup.enter(startupPhaseNatsSubscription)
 	if err := a.initiateStreaming(); err != nil {
 		a.onError(TaskStateDead, err)
 		return
 	}
+	a.startup.markStarted()
 
-	for i := 0; i < a.mysqlContext.ParallelWorkers; i++ {
-		go a.MtsWorker(i)
+	if a.heartbeatTable != nil {
+		go a.heartbeatTable.run()
 	}
-
 	go a.executeWriteFuncs()
 }
 
@@ -409,8 +764,15 @@ func (a *Applier) executeWriteFuncs() {
 				case copyRows := <-a.copyRowsQueue:
 					if nil != copyRows {
 						//time.Sleep(20 * time.Second) // #348 stub
-						if err := a.ApplyEventQueries(a.db, copyRows); err != nil {
+						if copyRows.HandoffTable != "" {
+							a.handoffTableCopied(copyRows.HandoffTable)
+						} else if err := a.ApplyEventQueries(a.db, copyRows); err != nil {
 							a.onError(TaskStateDead, err)
+						} else if copyRows.Progress != nil {
+							// saved with the Gtid by the client, to resume the copy after a restart
+							a.dumpProgressLock.Lock()
+							a.mysqlContext.DumpProgress = copyRows.Progress
+							a.dumpProgressLock.Unlock()
 						}
 					}
 					if atomic.LoadInt64(&a.nDumpEntry) < 0 {
@@ -436,7 +798,15 @@ func (a *Applier) executeWriteFuncs() {
 			if atomic.LoadInt64(&a.rowCopyCompleteFlag) == 1 && a.mysqlContext.TotalRowsCopied == a.mysqlContext.TotalRowsReplay {
 				a.rowCopyComplete <- true
 				a.logger.Printf("mysql.applier: Rows copy complete.number of rows:%d", a.mysqlContext.TotalRowsReplay)
-				a.mysqlContext.Gtid = a.currentCoordinates.RetrievedGtidSet
+				if a.handoffGtid != "" {
+					// the transactions applied during the copy are skipped by gtid_executed on a restart
+					a.mysqlContext.Gtid = a.handoffGtid
+				} else {
+					a.mysqlContext.Gtid = a.currentCoordinates.RetrievedGtidSet
+				}
+				a.dumpProgressLock.Lock()
+				a.mysqlContext.DumpProgress = nil
+				a.dumpProgressLock.Unlock()
 				break
 			}
 			if a.shutdown {
@@ -444,7 +814,13 @@ func (a *Applier) executeWriteFuncs() {
 			}
 			time.Sleep(time.Second)
 		}
+		if a.mysqlContext.SkipIncrementalCopy && !a.shutdown {
+			a.logger.Printf("mysql.applier: SkipIncrementalCopy")
+			a.onError(TaskStateComplete, nil)
+			return
+		}
 	}
+	a.setGtidBase(a.mysqlContext.Gtid)
 
 	var dbApplier *sql.Conn
 
@@ -482,7 +858,7 @@ func (a *Applier) executeWriteFuncs() {
 
 func (a *Applier) initNatSubClient() (err error) {
 	natsAddr := fmt.Sprintf("nats://%s", a.mysqlContext.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	sc, err := a.mysqlContext.NatsAuth.Connect(natsAddr)
 	if err != nil {
 		a.logger.Errorf("mysql.applier: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
 		return err
@@ -492,9 +868,9 @@ func (a *Applier) initNatSubClient() (err error) {
 	return nil
 }
 
-// Decode
+// Decode decodes a message made by Encode or EncodeWith.
 func Decode(data []byte, vPtr interface{}) (err error) {
-	msg, err := snappy.Decode(nil, data)
+	msg, err := decompress(data)
 	if err != nil {
 		return err
 	}
@@ -503,7 +879,6 @@ func Decode(data []byte, vPtr interface{}) (err error) {
 }
 
 func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) error {
-	var err error
 	for i := range binlogEntry.Events {
 		dmlEvent := &binlogEntry.Events[i]
 		switch dmlEvent.DML {
@@ -511,14 +886,34 @@ func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) er
 			// do nothing
 		default:
 			tableItem := a.getTableItem(dmlEvent.DatabaseName, dmlEvent.TableName)
-			if tableItem.columns == nil {
+			meta, err := a.metaCache.get(a.db, dmlEvent.DatabaseName, dmlEvent.TableName)
+			if err != nil {
+				a.logger.Errorf("mysql.applier. get table structure error. err: %v", err)
+				return err
+			}
+			if tableItem.meta != nil && tableItem.meta != meta && tableItem.meta.sameStructure(meta) {
+				// loaded again after an eviction or an expiry, unchanged: the statements stay valid
+				tableItem.meta = meta
+			}
+			if tableItem.meta != meta {
+				// first touch, or the structure was changed without a DDL in the binlog
 				a.logger.Debugf("mysql.applier: get tableColumns %v.%v", dmlEvent.DatabaseName, dmlEvent.TableName)
-				tableItem.columns, err = base.GetTableColumns(a.db, dmlEvent.DatabaseName, dmlEvent.TableName)
-				if err != nil {
-					a.logger.Errorf("mysql.applier. GetTableColumns error. err: %v", err)
-					return err
+				if tableItem.meta != nil {
+					// the workers may be executing the statements for the earlier transactions
+					if !a.mtsManager.WaitForAllCommitted() {
+						return fmt.Errorf("the applier is shutting down")
+					}
+					tableItem.closeStmts()
 				}
+				tableItem.meta = meta
 				// Review: column types is not applied or used. Only
+				tableItem.columns = meta.columns
+				tableItem.charsets = meta.charsets
+				tableItem.temporals = meta.temporals
+				if !hasPkColumn(tableItem.columns) {
+					a.logger.Warnf("mysql.applier: table %v.%v has no primary key. Rows will be matched by all columns",
+						dmlEvent.DatabaseName, dmlEvent.TableName)
+				}
 			} else {
 				a.logger.Debugf("mysql.applier: reuse tableColumns %v.%v", dmlEvent.DatabaseName, dmlEvent.TableName)
 			}
@@ -528,6 +923,21 @@ func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) er
 	return nil
 }
 
+// stripSurrogateKeyColumn removes the column added by NoPkTablePolicy "surrogate_key",
+// which does not exist on the source.
+func stripSurrogateKeyColumn(columns *umconf.ColumnList) *umconf.ColumnList {
+	if columns.GetColumn(g.SurrogateKeyColumn) == nil {
+		return columns
+	}
+	var stripped []umconf.Column
+	for _, column := range columns.ColumnList() {
+		if column.Name != g.SurrogateKeyColumn {
+			stripped = append(stripped, column)
+		}
+	}
+	return umconf.NewColumnList(stripped)
+}
+
 func (a *Applier) cleanGtidExecuted(sid uuid.UUID, intervalStr string) error {
 	a.logger.Debugf("mysql.applier. incr. cleanup before WaitForExecution")
 	if !a.mtsManager.WaitForAllCommitted() {
@@ -566,15 +976,25 @@ func (a *Applier) cleanGtidExecuted(sid uuid.UUID, intervalStr string) error {
 }
 
 func (a *Applier) heterogeneousReplay() {
+	defer close(a.replayDone)
 	var err error
 	stopSomeLoop := false
 	prevDDL := false
+	lastActive := time.Now()
 	for !stopSomeLoop {
 		select {
 		case binlogEntry := <-a.applyDataEntryQueue:
 			if nil == binlogEntry {
 				continue
 			}
+			lastActive = time.Now()
+			if a.parkedWorkers > 0 {
+				// the entry is held until the connections are reopened
+				if err := a.unpark(); err != nil {
+					a.onError(TaskStateDead, err)
+					return
+				}
+			}
 
 			a.logger.Debugf("mysql.applier: a binlogEntry. remaining: %v. gno: %v, lc: %v, seq: %v",
 				len(a.applyDataEntryQueue), binlogEntry.Coordinates.GNO,
@@ -582,6 +1002,7 @@ func (a *Applier) heterogeneousReplay() {
 
 			if binlogEntry.Coordinates.OSID == a.mysqlContext.MySQLServerUuid {
 				a.logger.Debugf("mysql.applier: skipping a dtle tx. osid: %v", binlogEntry.Coordinates.OSID)
+				a.markGtidApplied(&binlogEntry.Coordinates)
 				continue
 			}
 
@@ -605,6 +1026,7 @@ func (a *Applier) heterogeneousReplay() {
 			if base.IntervalSlicesContainOne(gtidSetItem.Intervals, binlogEntry.Coordinates.GNO) {
 				// entry executed
 				a.logger.Debugf("mysql.applier: skip an executed tx: %v:%v", txSid, binlogEntry.Coordinates.GNO)
+				a.markGtidApplied(&binlogEntry.Coordinates)
 				continue
 			}
 			// endregion
@@ -635,6 +1057,9 @@ func (a *Applier) heterogeneousReplay() {
 			newInterval := append(gtidSetItem.Intervals, thisInterval).Normalize()
 			// TODO this is assigned before real execution
 			gtidSetItem.Intervals = newInterval
+			if binlogEntry.XaPrepared != nil {
+				a.addXaPreparedExecuted(binlogEntry.XaPrepared)
+			}
 
 			if binlogEntry.Coordinates.SeqenceNumber == 0 {
 				// MySQL 5.6: non mts
@@ -644,7 +1069,7 @@ func (a *Applier) heterogeneousReplay() {
 					return
 				}
 				if err := a.ApplyBinlogEvent(0, binlogEntry); err != nil {
-					a.onError(TaskStateDead, err)
+					a.onError(a.applyErrorState(err), err)
 					return
 				}
 			} else {
@@ -655,6 +1080,10 @@ func (a *Applier) heterogeneousReplay() {
 					}
 					a.mtsManager.lastCommitted = 0
 					a.mtsManager.lastEnqueue = 0
+					a.dependencyGroupLastSeq = make(map[int]int64)
+					if a.writeSet != nil {
+						a.writeSet.reset()
+					}
 					if len(a.mtsManager.m) != 0 {
 						a.logger.Warnf("DTLE_BUG: len(a.mtsManager.m) should be 0")
 					}
@@ -678,6 +1107,13 @@ func (a *Applier) heterogeneousReplay() {
 					return false
 				}()
 
+				// The commit part of an XA transaction has no rows to depend on its prepare part by.
+				if binlogEntry.XaOp == binlog.XaOpCommit || binlogEntry.XaOp == binlog.XaOpRollback {
+					if !a.mtsManager.WaitForAllCommitted() {
+						return // shutdown
+					}
+				}
+
 				// DDL must be executed separatedly
 				if hasDDL || prevDDL {
 					a.logger.Debugf("mysql.applier: gno: %v MTS found DDL(%v,%v). WaitForAllCommitted",
@@ -693,24 +1129,72 @@ func (a *Applier) heterogeneousReplay() {
 					prevDDL = false
 				}
 
-				if !a.mtsManager.WaitForExecution(binlogEntry) {
-					return // shutdown
-				}
-
-				a.logger.Debugf("mysql.applier: a binlogEntry MTS enqueue. gno: %v", binlogEntry.Coordinates.GNO)
+				// before WaitForExecution, which enqueues the entry: a changed table waits for the
+				// earlier transactions to commit before closing its statements
 				err = a.setTableItemForBinlogEntry(binlogEntry)
 				if err != nil {
 					a.onError(TaskStateDead, err)
 					return
 				}
+
+				if !a.mtsManager.WaitForExecution(binlogEntry) {
+					return // shutdown
+				}
+
+				dependencyGroups := a.dependencyGroupsOf(binlogEntry)
+				for _, group := range dependencyGroups {
+					if lastSeq, ok := a.dependencyGroupLastSeq[group]; ok {
+						a.logger.Debugf("mysql.applier: gno: %v waits for dependency group %v seq %v",
+							binlogEntry.Coordinates.GNO, group, lastSeq)
+						if !a.mtsManager.WaitForSequence(lastSeq) {
+							return // shutdown
+						}
+					}
+				}
+				for _, group := range dependencyGroups {
+					a.dependencyGroupLastSeq[group] = binlogEntry.Coordinates.SeqenceNumber
+				}
+
+				if a.writeSet != nil {
+					buckets := a.writeSet.bucketsOf(binlogEntry)
+					if seq := a.writeSet.dependsOn(buckets); seq > atomic.LoadInt64(&a.mtsManager.lastCommitted) {
+						a.logger.Debugf("mysql.applier: gno: %v waits for conflicting seq %v",
+							binlogEntry.Coordinates.GNO, seq)
+						atomic.AddInt64(&a.writeSet.waits, 1)
+						if !a.mtsManager.WaitForSequence(seq) {
+							return // shutdown
+						}
+					}
+					a.writeSet.add(buckets, binlogEntry.Coordinates.SeqenceNumber)
+				}
+
+				a.logger.Debugf("mysql.applier: a binlogEntry MTS enqueue. gno: %v", binlogEntry.Coordinates.GNO)
 				a.applyBinlogMtsTxQueue <- binlogEntry
 			}
-			if !a.shutdown {
+			if !a.shutdown && a.currentHandoff() == nil {
 				// TODO what is this used for?
+				// Not during the full copy of ConcurrentIncrementalCopy, which would be skipped on a restart.
 				a.mysqlContext.Gtid = fmt.Sprintf("%s:1-%d", txSid, binlogEntry.Coordinates.GNO)
 			}
+		case req := <-a.workersCh:
+			// between transactions. The workers are idle once those in progress are committed.
+			if !a.mtsManager.WaitForAllCommitted() {
+				req.done <- fmt.Errorf("the applier is shutting down")
+				return
+			}
+			req.done <- a.resizeWorkers(req.n)
 		case <-time.After(10 * time.Second):
 			a.logger.Debugf("mysql.applier: no binlogEntry for 10s")
+			if a.shouldPark(lastActive, time.Now()) {
+				if !a.mtsManager.WaitForAllCommitted() {
+					return // shutdown
+				}
+				a.park(time.Since(lastActive))
+			}
+		case <-a.stopCh:
+			a.logger.Printf("mysql.applier: Stopping replay. Waiting for the txs in progress")
+			a.mtsManager.WaitForAllCommitted()
+			stopSomeLoop = true
 		case <-a.shutdownCh:
 			stopSomeLoop = true
 		}
@@ -769,13 +1253,21 @@ func (a *Applier) initiateStreaming() error {
 	if a.mysqlContext.Gtid == "" {
 		a.mysqlContext.MarkRowCopyStartTime()
 		a.logger.Debugf("mysql.applier: nats subscribe")
-		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_full", a.subject), func(m *gonats.Msg) {
+		_, err := a.subscribe(fmt.Sprintf("%s_full", a.subject), func(m *gonats.Msg) {
 			a.logger.Debugf("mysql.applier: full. recv a msg. copyRowsQueue: %v", len(a.copyRowsQueue))
 
 			dumpData := &DumpEntry{}
 			if err := Decode(m.Data, dumpData); err != nil {
 				a.onError(TaskStateDead, err)
 			}
+			if dumpData.HandoffStart {
+				// before the binlog is streamed
+				a.startHandoff(dumpData.HandoffTables)
+				if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+					a.onError(TaskStateDead, err)
+				}
+				return
+			}
 
 			timer := time.NewTimer(DefaultConnectWait / 2)
 			atomic.AddInt64(&a.nDumpEntry, 1) // this must be increased before enqueuing
@@ -800,12 +1292,15 @@ func (a *Applier) initiateStreaming() error {
 			return err
 		}*/
 
-		_, err = a.natsConn.Subscribe(fmt.Sprintf("%s_full_complete", a.subject), func(m *gonats.Msg) {
+		_, err = a.subscribe(fmt.Sprintf("%s_full_complete", a.subject), func(m *gonats.Msg) {
 			dumpData := &dumpStatResult{}
 			if err := Decode(m.Data, dumpData); err != nil {
 				a.onError(TaskStateDead, err)
 			}
-			a.currentCoordinates.RetrievedGtidSet = dumpData.Gtid
+			handoff := a.currentHandoff()
+			if handoff == nil {
+				a.currentCoordinates.RetrievedGtidSet = dumpData.Gtid
+			}
 			a.mysqlContext.Stage = models.StageSlaveWaitingForWorkersToProcessQueue
 
 			for atomic.LoadInt64(&a.nDumpEntry) != 0 {
@@ -816,11 +1311,19 @@ func (a *Applier) initiateStreaming() error {
 				}
 			}
 
+			if handoff != nil {
+				a.handoffGtid = dumpData.Gtid
+				a.finishHandoff(handoff)
+			}
+
 			a.logger.Debugf("mysql.applier. ack full_complete")
 			if err := a.natsConn.Publish(m.Reply, nil); err != nil {
 				a.onError(TaskStateDead, err)
 			}
 			atomic.AddInt64(&a.mysqlContext.TotalRowsCopied, dumpData.TotalCount)
+			if dumpData.SkipIncrementalCopy {
+				a.mysqlContext.SkipIncrementalCopy = true
+			}
 			atomic.StoreInt64(&a.rowCopyCompleteFlag, 1)
 		})
 		if err != nil {
@@ -829,31 +1332,44 @@ func (a *Applier) initiateStreaming() error {
 	}
 
 	if a.mysqlContext.ApproveHeterogeneous {
-		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", a.subject), func(m *gonats.Msg) {
+		_, err := a.subscribe(fmt.Sprintf("%s_incr_hete", a.subject), func(m *gonats.Msg) {
 			var binlogEntries binlog.BinlogEntries
-			if err := Decode(m.Data, &binlogEntries); err != nil {
+			codec, err := DecodeEntries(m.Data, &binlogEntries)
+			if err != nil {
 				a.onError(TaskStateDead, err)
 			}
+			a.msgCodec.Store(codec)
 
 			nEntries := len(binlogEntries.Entries)
 
 			handled := false
 			for i := 0; !handled && (i < DefaultConnectWaitSecond/2); i++ {
 				vacancy := cap(a.applyDataEntryQueue) - len(a.applyDataEntryQueue)
+				handoff := a.currentHandoff()
 				a.logger.Debugf("applier. incr. nEntries: %v, vacancy: %v", nEntries, vacancy)
 				if vacancy < nEntries {
 					a.logger.Debugf("applier. incr. wait 1s for applyDataEntryQueue")
 					time.Sleep(1 * time.Second) // It will wait an second at the end, but seems no hurt.
+				} else if handoff != nil && handoff.full() {
+					a.logger.Debugf("applier. incr. wait 1s for the transactions held during the full copy")
+					time.Sleep(1 * time.Second)
 				} else {
 					a.logger.Debugf("applier. incr. applyDataEntryQueue enqueue")
 					for _, binlogEntry := range binlogEntries.Entries {
-						a.applyDataEntryQueue <- binlogEntry
+						a.markGtidReceived(&binlogEntry.Coordinates)
+						if handoff == nil {
+							a.applyDataEntryQueue <- binlogEntry
+						}
 						a.currentCoordinates.RetrievedGtidSet = binlogEntry.Coordinates.GetGtidForThisTx()
 						atomic.AddInt64(&a.mysqlContext.DeltaEstimate, 1)
 					}
+					if handoff != nil {
+						// held until their tables are copied
+						handoff.admit(binlogEntries.Entries)
+					}
 					a.mysqlContext.Stage = models.StageWaitingForMasterToSendEvent
 
-					if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+					if err := a.natsConn.Publish(m.Reply, a.txAck()); err != nil {
 						a.onError(TaskStateDead, err)
 					}
 					a.logger.Debugf("applier. incr. ack-recv. nEntries: %v", nEntries)
@@ -871,9 +1387,10 @@ func (a *Applier) initiateStreaming() error {
 			return err
 		}
 
+		atomic.StoreInt32(&a.replaying, 1)
 		go a.heterogeneousReplay()
 	} else {
-		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_incr", a.subject), func(m *gonats.Msg) {
+		_, err := a.subscribe(fmt.Sprintf("%s_incr", a.subject), func(m *gonats.Msg) {
 			var binlogTx []*binlog.BinlogTx
 			if err := Decode(m.Data, &binlogTx); err != nil {
 				a.onError(TaskStateDead, err)
@@ -894,12 +1411,36 @@ func (a *Applier) initiateStreaming() error {
 		go a.homogeneousReplay()
 	}
 
+	_, err := a.subscribe(fmt.Sprintf("%s_row_count", a.subject), a.handleRowCountCheck)
+	if err != nil {
+		return err
+	}
+	_, err = a.natsConn.Subscribe(fmt.Sprintf("%s_transport", a.subject), a.handleTransportReport)
+	if err != nil {
+		return err
+	}
+	_, err = a.natsConn.Subscribe(fmt.Sprintf("%s_replica_lag", a.subject), a.handleReplicaLagReport)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (a *Applier) initDBConnections() (err error) {
+	if err := a.mysqlContext.ValidateInitSQL(); err != nil {
+		return err
+	}
+	if len(a.mysqlContext.InitSQL) > 0 {
+		a.logger.Printf("mysql.applier: InitSQL on the connections to the target: %v",
+			strings.Join(a.mysqlContext.InitSQL, "; "))
+	}
+	if a.mysqlContext.ZeroDatePolicy == config.ZeroDatePolicyPreserve {
+		a.logger.Printf("mysql.applier: ZeroDatePolicy %v. the zero dates are allowed on the connections to the target",
+			a.mysqlContext.ZeroDatePolicy)
+	}
 	applierUri := a.mysqlContext.ConnectionConfig.GetDBUri()
-	if a.db, err = sql.CreateDB(applierUri); err != nil {
+	if a.db, err = sql.CreateDB(applierUri, a.targetInitSQL()...); err != nil {
 		return err
 	}
 	a.db.SetMaxOpenConns(10 + a.mysqlContext.ParallelWorkers)
@@ -930,21 +1471,8 @@ func (a *Applier) initDBConnections() (err error) {
 		}
 		a.logger.Debugf("mysql.applier. after createTableGtidExecutedV2")
 
-		for i := range a.dbs {
-			a.dbs[i].PsDeleteExecutedGtid, err = a.dbs[i].Db.PrepareContext(context.Background(), fmt.Sprintf("delete from %v.%v where job_uuid = unhex('%s') and source_uuid = ?",
-				g.DtleSchemaName, g.GtidExecutedTableV3, hex.EncodeToString(a.subjectUUID.Bytes())))
-			if err != nil {
-				return err
-			}
-			a.dbs[i].PsInsertExecutedGtid, err = a.dbs[i].Db.PrepareContext(context.Background(), fmt.Sprintf("replace into %v.%v "+
-				"(job_uuid,source_uuid,interval_gtid) "+
-				"values (unhex('%s'), ?, ?)",
-				g.DtleSchemaName, g.GtidExecutedTableV3,
-				hex.EncodeToString(a.subjectUUID.Bytes())))
-			if err != nil {
-				return err
-			}
-
+		if err := a.prepareGtidExecutedStmts(a.dbs); err != nil {
+			return err
 		}
 		a.logger.Debugf("mysql.applier. after prepare stmt for gtid_executed table")
 	}
@@ -958,6 +1486,25 @@ func (a *Applier) initDBConnections() (err error) {
 	return nil
 }
 
+func (a *Applier) prepareGtidExecutedStmts(dbs []*sql.Conn) (err error) {
+	for i := range dbs {
+		dbs[i].PsDeleteExecutedGtid, err = dbs[i].Db.PrepareContext(context.Background(), fmt.Sprintf("delete from %v.%v where job_uuid = unhex('%s') and source_uuid = ?",
+			g.DtleSchemaName, g.GtidExecutedTableV3, hex.EncodeToString(a.subjectUUID.Bytes())))
+		if err != nil {
+			return err
+		}
+		dbs[i].PsInsertExecutedGtid, err = dbs[i].Db.PrepareContext(context.Background(), fmt.Sprintf("replace into %v.%v "+
+			"(job_uuid,source_uuid,interval_gtid) "+
+			"values (unhex('%s'), ?, ?)",
+			g.DtleSchemaName, g.GtidExecutedTableV3,
+			hex.EncodeToString(a.subjectUUID.Bytes())))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (a *Applier) validateServerUUID() error {
 	query := `SELECT @@SERVER_UUID`
 	if err := a.db.QueryRow(query).Scan(&a.mysqlContext.MySQLServerUuid); err != nil {
@@ -987,6 +1534,19 @@ func (a *Applier) validateGrants() error {
 		a.logger.Debugf("mysql.applier: skipping priv check")
 		return nil
 	}
+	if a.mysqlContext.StrictPrivilegeCheck {
+		grants, err := showGrants(a.db)
+		if err != nil {
+			return err
+		}
+		gs := parseGrants(grants)
+		a.mysqlContext.HasSuperPrivilege = gs.has(privilegeRequirement{Privilege: "SUPER"})
+		if missing := gs.missingPrivileges(targetPrivileges(a.mysqlContext, a.dmlFilterIndex)); len(missing) > 0 {
+			return missingPrivilegesError("applier", missing)
+		}
+		a.logger.Printf("mysql.applier: User has the privileges on the replicated tables")
+		return nil
+	}
 	query := `show grants for current_user()`
 	foundAll := false
 	foundSuper := false
@@ -1144,7 +1704,7 @@ func (a *Applier) getTableItem(schema string, table string) *applierTableItem {
 
 	tableItem, ok := schemaItem[table]
 	if !ok {
-		tableItem = newApplierTableItem(a.mysqlContext.ParallelWorkers)
+		tableItem = newApplierTableItem(len(a.dbs))
 		schemaItem[table] = tableItem
 	}
 
@@ -1170,14 +1730,65 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (
 		return stmts[workerIdx], err
 	}
 
+	whereColumns := tableColumns
+	newColumns := tableColumns
+	isFullImage := dmlEvent.RowImage == "" || dmlEvent.RowImage == binlog.RowImageFull
+	if !isFullImage {
+		a.rowImage.Store(dmlEvent.RowImage)
+		whereColumns = imageColumns(tableColumns, dmlEvent.WhereColumnBitmap)
+		newColumns = imageColumns(tableColumns, dmlEvent.NewColumnBitmap)
+		if dmlEvent.DML != binlog.InsertDML && dmlEvent.RowImage == binlog.RowImageMinimal && !hasPkColumn(whereColumns) {
+			return nil, nil, -1, fmt.Errorf("table %v.%v has no primary key, which is required with binlog_row_image=MINIMAL",
+				dmlEvent.DatabaseName, dmlEvent.TableName)
+		}
+	} else {
+		a.rowImage.Store(binlog.RowImageFull)
+	}
+	if len(tableItem.charsets) > 0 {
+		for _, values := range []*umconf.ColumnValues{dmlEvent.WhereColumnValues, dmlEvent.NewColumnValues} {
+			if values == nil {
+				continue
+			}
+			err := transcodeValues(a.mysqlContext.CharsetErrorPolicy, tableItem.charsets, tableColumns.Names(), values.GetAbstractValues())
+			if err != nil {
+				return nil, nil, -1, fmt.Errorf("table %v.%v: %v", dmlEvent.DatabaseName, dmlEvent.TableName, err)
+			}
+		}
+	}
+	if len(tableItem.temporals) > 0 && a.convertsZeroDates() {
+		var rows [][]*interface{}
+		for _, values := range []*umconf.ColumnValues{dmlEvent.WhereColumnValues, dmlEvent.NewColumnValues} {
+			if values != nil {
+				rows = append(rows, values.GetAbstractValues())
+			}
+		}
+		a.convertRowZeroDates(dmlEvent.DatabaseName, dmlEvent.TableName, tableItem.temporals, tableColumns.Names(), rows...)
+	}
+	doPrepare := func(stmts []*gosql.Stmt, query string) (*gosql.Stmt, error) {
+		if isFullImage {
+			return doPrepareIfNil(stmts, query)
+		}
+		if stmt, ok := tableItem.psImage[workerIdx][query]; ok {
+			return stmt, nil
+		}
+		a.logger.Debugf("mysql.applier buildDMLEventQuery prepare query for row image %v: %v", dmlEvent.RowImage, query)
+		stmt, err := a.dbs[workerIdx].Db.PrepareContext(context.Background(), query)
+		if err != nil {
+			a.logger.Errorf("mysql.applier buildDMLEventQuery prepare query %v err %v", query, err)
+			return nil, err
+		}
+		tableItem.psImage[workerIdx][query] = stmt
+		return stmt, nil
+	}
+
 	switch dmlEvent.DML {
 	case binlog.DeleteDML:
 		{
-			query, uniqueKeyArgs, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, dmlEvent.WhereColumnValues.GetAbstractValues())
+			query, uniqueKeyArgs, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, whereColumns, dmlEvent.WhereColumnValues.GetAbstractValues())
 			if err != nil {
 				return nil, nil, -1, err
 			}
-			stmt, err := doPrepareIfNil(tableItem.psDelete, query)
+			stmt, err := doPrepare(tableItem.psDelete, query)
 			if err != nil {
 				return nil, nil, -1, err
 			}
@@ -1186,11 +1797,11 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (
 	case binlog.InsertDML:
 		{
 			// TODO no need to generate query string every time
-			query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues())
+			query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, newColumns, newColumns, dmlEvent.NewColumnValues.GetAbstractValues())
 			if err != nil {
 				return nil, nil, -1, err
 			}
-			stmt, err := doPrepareIfNil(tableItem.psInsert, query)
+			stmt, err := doPrepare(tableItem.psInsert, query)
 			if err != nil {
 				return nil, nil, -1, err
 			}
@@ -1198,14 +1809,14 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (
 		}
 	case binlog.UpdateDML:
 		{
-			query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
+			query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, newColumns, newColumns, whereColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
 			if err != nil {
 				return nil, nil, -1, err
 			}
 			args = append(args, sharedArgs...)
 			args = append(args, uniqueKeyArgs...)
 
-			stmt, err := doPrepareIfNil(tableItem.psUpdate, query)
+			stmt, err := doPrepare(tableItem.psUpdate, query)
 			if err != nil {
 				return nil, nil, -1, err
 			}
@@ -1216,122 +1827,308 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (
 	return nil, args, 0, fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)
 }
 
+// imageColumns returns the columns present in a row image. A nil bitmap means all columns.
+func imageColumns(tableColumns *umconf.ColumnList, bitmap []byte) *umconf.ColumnList {
+	if bitmap == nil {
+		return tableColumns
+	}
+	columns := []umconf.Column{}
+	for i, column := range tableColumns.ColumnList() {
+		if binlog.ColumnPresent(bitmap, i) {
+			columns = append(columns, column)
+		}
+	}
+	return umconf.NewColumnList(columns)
+}
+
+func hasPkColumn(columns *umconf.ColumnList) bool {
+	for _, column := range columns.ColumnList() {
+		if column.IsPk() {
+			return true
+		}
+	}
+	return false
+}
+
+func isCreateTable(query string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "CREATE TABLE")
+}
+
+var autoIncrementOptionRegex = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// normalizeCreateTable removes the parts of SHOW CREATE TABLE which differ between
+// tables of the same definition.
+func normalizeCreateTable(statement string) string {
+	statement = autoIncrementOptionRegex.ReplaceAllString(statement, "")
+	return strings.Join(strings.Fields(statement), " ")
+}
+
+// createTable executes the CREATE TABLE of a schema entry, if the table does not exist on the target.
+// An existing table is kept, so the full copy can be re-run. A difference of its definition
+// is reported as a task event. created tells whether the table is created.
+func (a *Applier) createTable(tx *gosql.Tx, entry *DumpEntry, query string) (created bool, err error) {
+	name := fmt.Sprintf("%v.%v", sql.EscapeName(entry.CreateTableSchema), sql.EscapeName(entry.CreateTableName))
+	var dummy, existing string
+	err = tx.QueryRow(fmt.Sprintf("show create table %v", name)).Scan(&dummy, &existing)
+	switch {
+	case err == nil:
+		if normalizeCreateTable(existing) == normalizeCreateTable(query) {
+			a.logger.Printf("mysql.applier: table %v exists with the same definition", name)
+		} else {
+			a.logger.Warnf("mysql.applier: table %v exists with another definition. kept. existing: %v, source: %v",
+				name, existing, query)
+			a.emitEvent("Table %v exists on the target with a definition different from the source. The existing table is kept", name)
+		}
+		return false, nil
+	case sql.IsNoSuchTableError(err):
+	default:
+		a.emitEvent("Failed to create table %v on the target: %v", name, err)
+		return false, err
+	}
+
+	a.logger.Debugf("mysql.applier: Exec [%s]", utils.StrLim(query, 256))
+	if _, err := tx.Exec(query); err != nil {
+		a.emitEvent("Failed to create table %v on the target: %v", name, err)
+		return false, err
+	}
+	a.emitEvent("Created table %v on the target", name)
+	return true, nil
+}
+
 // ApplyEventQueries applies multiple DML queries onto the dest table
-func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntry *binlog.BinlogEntry) error {
+// ApplyBinlogEvent applies transactions, in one target transaction. If the target is read-only, it waits
+// for a writable target (see waitForWritableTarget) and retries the transactions.
+// With AdaptiveGroup, a group of transactions failing with a deadlock is retried one by one.
+// Transactions failing with a deadlock among the workers are retried alone on their tables (see tableGate).
+func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntries ...*binlog.BinlogEntry) error {
+	if groups := splitXaEntries(binlogEntries); len(groups) > 1 {
+		for _, group := range groups {
+			if err := a.ApplyBinlogEvent(workerIdx, group...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tables := tablesOf(binlogEntries)
+	deadlocks := 0
+	for {
+		gen := atomic.LoadInt64(&a.failoverGen)
+		start := time.Now()
+		others, err := a.applyGated(workerIdx, binlogEntries, tables, deadlocks > 0)
+		if a.txCounter != nil {
+			a.txCounter.add(len(binlogEntries), err, time.Now())
+		}
+		if err == nil {
+			a.observeApplyLatency(binlogEntries, tables, time.Since(start))
+		}
+		amongWorkers := false
+		if err != nil && sql.IsDeadlockError(err) {
+			amongWorkers = a.countDeadlock(workerIdx, others, err)
+		}
+		if a.groupSizer != nil {
+			if err == nil {
+				a.groupSizer.observe(len(binlogEntries), time.Since(start))
+			} else if sql.IsDeadlockError(err) && len(binlogEntries) > 1 {
+				a.groupSizer.shrink(fmt.Sprintf("deadlock on a group of %v", len(binlogEntries)))
+				a.logger.Warnf("mysql.applier: deadlock on a group of %v transactions. retrying them one by one. err: %v",
+					len(binlogEntries), err)
+				for _, binlogEntry := range binlogEntries {
+					if err := a.ApplyBinlogEvent(workerIdx, binlogEntry); err != nil {
+						return err
+					}
+				}
+				return nil
+			} else {
+				a.groupSizer.shrink(fmt.Sprintf("error on a group of %v: %v", len(binlogEntries), err))
+			}
+		}
+		if amongWorkers && deadlocks < maxDeadlockRetries {
+			deadlocks++
+			atomic.AddInt64(&a.deadlockRetries, 1)
+			first := binlogEntries[0]
+			a.logger.Warnf("mysql.applier: deadlock among the workers. retrying gtid %s:%d alone on its tables (%v). err: %v",
+				first.Coordinates.GetSid(), first.Coordinates.GNO, deadlocks, err)
+			continue
+		}
+		if err == nil || !sql.IsReadOnlyError(err) || a.mysqlContext.FailoverTimeout < 0 {
+			return err
+		}
+		first := binlogEntries[0]
+		a.logger.Warnf("mysql.applier: target is read-only. gtid: %s:%d. err: %v",
+			first.Coordinates.GetSid(), first.Coordinates.GNO, err)
+		if err := a.waitForWritableTarget(gen, err); err != nil {
+			return err
+		}
+		a.logger.Infof("mysql.applier: retry gtid: %s:%d", first.Coordinates.GetSid(), first.Coordinates.GNO)
+	}
+}
+
+func (a *Applier) applyBinlogEvent(workerIdx int, binlogEntries []*binlog.BinlogEntry) (err error) {
+	if binlogEntries[0].XaOp != "" {
+		return a.applyXaEntry(workerIdx, binlogEntries[0])
+	}
 	dbApplier := a.dbs[workerIdx]
 
 	var totalDelta int64
-	var err error
-
-	txSid := binlogEntry.Coordinates.GetSid()
+	var nInsert, nUpdate, nDelete int64
 
 	dbApplier.DbMutex.Lock()
+	if err := a.prepareExecTimeout(dbApplier); err != nil {
+		dbApplier.DbMutex.Unlock()
+		return err
+	}
 	tx, err := dbApplier.Db.BeginTx(context.Background(), &gosql.TxOptions{})
 	if err != nil {
+		dbApplier.DbMutex.Unlock()
 		return err
 	}
 	defer func() {
-		if err := tx.Commit(); err != nil {
-			a.onError(TaskStateDead, err)
-		} else {
-			a.mtsManager.Executed(binlogEntry)
+		if err == nil {
+			err = faults.Inject(faults.ApplierCommit)
+		}
+		if err != nil {
+			// Rollback so that the transaction could be retried.
+			if errRollback := tx.Rollback(); errRollback != nil {
+				a.logger.Warnf("mysql.applier: rollback error: %v", errRollback)
+			}
+		} else if err = tx.Commit(); err == nil {
+			for _, binlogEntry := range binlogEntries {
+				a.markGtidApplied(&binlogEntry.Coordinates)
+				if binlogEntry.XaPrepared != nil {
+					a.markGtidApplied(binlogEntry.XaPrepared)
+				}
+				a.mtsManager.Executed(binlogEntry)
+			}
+			atomic.AddInt64(&a.insertCount, nInsert)
+			atomic.AddInt64(&a.updateCount, nUpdate)
+			atomic.AddInt64(&a.deleteCount, nDelete)
 		}
 		if a.printTps {
-			atomic.AddUint32(&a.txLastNSeconds, 1)
+			atomic.AddUint32(&a.txLastNSeconds, uint32(len(binlogEntries)))
 		}
 
 		dbApplier.DbMutex.Unlock()
 	}()
 
-	for i, event := range binlogEntry.Events {
-		a.logger.Debugf("mysql.applier: ApplyBinlogEvent. gno: %v, event: %v",
-			binlogEntry.Coordinates.GNO, i)
-		switch event.DML {
-		case binlog.NotDML:
-			var err error
-			a.logger.Debugf("mysql.applier: ApplyBinlogEvent: not dml: %v", event.Query)
-
-			if event.CurrentSchema != "" {
-				// TODO escape schema name?
-				query := fmt.Sprintf("USE %s", event.CurrentSchema)
-				a.logger.Debugf("mysql.applier: query: %v", query)
-				_, err = tx.Exec(query)
+	for _, binlogEntry := range binlogEntries {
+		txSid := binlogEntry.Coordinates.GetSid()
+		for i, event := range binlogEntry.Events {
+			a.logger.Debugf("mysql.applier: ApplyBinlogEvent. gno: %v, event: %v",
+				binlogEntry.Coordinates.GNO, i)
+			switch event.DML {
+			case binlog.NotDML:
+				var err error
+				a.logger.Debugf("mysql.applier: ApplyBinlogEvent: not dml: %v", event.Query)
+
+				if event.CurrentSchema != "" {
+					// TODO escape schema name?
+					query := fmt.Sprintf("USE %s", event.CurrentSchema)
+					a.logger.Debugf("mysql.applier: query: %v", query)
+					_, err = tx.Exec(query)
+					if err != nil {
+						if !sql.IgnoreError(err) {
+							a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
+							return err
+						} else {
+							a.logger.Warnf("mysql.applier: Ignore error: %v", err)
+						}
+					}
+				}
+
+				schema := event.DatabaseName
+				if schema == "" {
+					schema = event.CurrentSchema
+				}
+				if event.TableName != "" {
+					a.logger.Debugf("mysql.applier: reset tableItem %v.%v", schema, event.TableName)
+					a.getTableItem(schema, event.TableName).Reset()
+					a.metaCache.invalidate(schema, event.TableName)
+				} else { // TableName == ""
+					if event.DatabaseName != "" {
+						if schemaItem, ok := a.tableItems[event.DatabaseName]; ok {
+							for tableName, v := range schemaItem {
+								a.logger.Debugf("mysql.applier: reset tableItem %v.%v", event.DatabaseName, tableName)
+								v.Reset()
+							}
+						}
+						delete(a.tableItems, event.DatabaseName)
+						a.metaCache.invalidate(event.DatabaseName, "")
+					}
+				}
+
+				_, err = a.execTimed(dbApplier, schema, event.TableName, len(binlogEntry.Events), func() (gosql.Result, error) {
+					return tx.Exec(event.Query)
+				})
 				if err != nil {
-					if !sql.IgnoreError(err) {
+					if !sql.IgnoreError(err) && !a.ignoresError(err, fmt.Sprintf("gtid %s:%d", txSid, binlogEntry.Coordinates.GNO)) {
 						a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
 						return err
 					} else {
 						a.logger.Warnf("mysql.applier: Ignore error: %v", err)
 					}
 				}
-			}
-
-			if event.TableName != "" {
-				var schema string
-				if event.DatabaseName != "" {
-					schema = event.DatabaseName
-				} else {
-					schema = event.CurrentSchema
+				a.logger.Debugf("mysql.applier: Exec [%s]", event.Query)
+			default:
+				a.logger.Debugf("mysql.applier: ApplyBinlogEvent: a dml event")
+				if a.isDmlFiltered(&event) {
+					// The gtid is still recorded below.
+					a.logger.Debugf("mysql.applier: skip %v on %v.%v by DmlFilter", event.DML, event.DatabaseName, event.TableName)
+					continue
 				}
-				a.logger.Debugf("mysql.applier: reset tableItem %v.%v", schema, event.TableName)
-				a.getTableItem(schema, event.TableName).Reset()
-			} else { // TableName == ""
-				if event.DatabaseName != "" {
-					if schemaItem, ok := a.tableItems[event.DatabaseName]; ok {
-						for tableName, v := range schemaItem {
-							a.logger.Debugf("mysql.applier: reset tableItem %v.%v", event.DatabaseName, tableName)
-							v.Reset()
-						}
-					}
-					delete(a.tableItems, event.DatabaseName)
+				stmt, args, rowDelta, err := a.buildDMLEventQuery(event, workerIdx)
+				if err != nil {
+					a.logger.Errorf("mysql.applier: Build dml query error: %v", err)
+					return err
 				}
-			}
 
-			_, err = tx.Exec(event.Query)
-			if err != nil {
-				if !sql.IgnoreError(err) {
-					a.logger.Errorf("mysql.applier: Exec sql error: %v", err)
+				a.logger.Debugf("ApplyBinlogEvent. args: %v", args)
+
+				var r gosql.Result
+				r, err = a.execTimed(dbApplier, event.DatabaseName, event.TableName, len(binlogEntry.Events), func() (gosql.Result, error) {
+					return stmt.Exec(args...)
+				})
+				if err != nil {
+					if a.ignoresError(err, fmt.Sprintf("gtid %s:%d %v %v.%v", txSid, binlogEntry.Coordinates.GNO,
+						event.DML, event.DatabaseName, event.TableName)) {
+						continue
+					}
+					a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v", txSid, binlogEntry.Coordinates.GNO, err)
 					return err
+				}
+				nr, err := r.RowsAffected()
+				if err != nil {
+					a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected_err %v schema", binlogEntry.Coordinates.GNO, i, err)
 				} else {
-					a.logger.Warnf("mysql.applier: Ignore error: %v", err)
+					a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected %v", binlogEntry.Coordinates.GNO, i, nr)
+				}
+				totalDelta += rowDelta
+				switch event.DML {
+				case binlog.InsertDML:
+					nInsert++
+				case binlog.UpdateDML:
+					nUpdate++
+				case binlog.DeleteDML:
+					nDelete++
 				}
 			}
-			a.logger.Debugf("mysql.applier: Exec [%s]", event.Query)
-		default:
-			a.logger.Debugf("mysql.applier: ApplyBinlogEvent: a dml event")
-			stmt, args, rowDelta, err := a.buildDMLEventQuery(event, workerIdx)
-			if err != nil {
-				a.logger.Errorf("mysql.applier: Build dml query error: %v", err)
-				return err
-			}
-
-			a.logger.Debugf("ApplyBinlogEvent. args: %v", args)
+		}
 
-			var r gosql.Result
-			r, err = stmt.Exec(args...)
+		a.logger.Debugf("ApplyBinlogEvent. insert gno: %v", binlogEntry.Coordinates.GNO)
+		_, err = dbApplier.PsInsertExecutedGtid.Exec(binlogEntry.Coordinates.SID.Bytes(), binlogEntry.Coordinates.GNO)
+		if err != nil {
+			return err
+		}
+		if binlogEntry.XaPrepared != nil {
+			_, err = dbApplier.PsInsertExecutedGtid.Exec(binlogEntry.XaPrepared.SID.Bytes(), binlogEntry.XaPrepared.GNO)
 			if err != nil {
-				a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v", txSid, binlogEntry.Coordinates.GNO, err)
 				return err
 			}
-			nr, err := r.RowsAffected()
-			if err != nil {
-				a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected_err %v schema", binlogEntry.Coordinates.GNO, i, err)
-			} else {
-				a.logger.Debugf("ApplyBinlogEvent executed gno %v event %v rows_affected %v", binlogEntry.Coordinates.GNO, i, nr)
-			}
-			totalDelta += rowDelta
 		}
 	}
 
-	a.logger.Debugf("ApplyBinlogEvent. insert gno: %v", binlogEntry.Coordinates.GNO)
-	_, err = dbApplier.PsInsertExecutedGtid.Exec(binlogEntry.Coordinates.SID.Bytes(), binlogEntry.Coordinates.GNO)
-	if err != nil {
-		return err
-	}
-
 	// no error
 	a.mysqlContext.Stage = models.StageWaitingForGtidToBeCommitted
-	atomic.AddInt64(&a.mysqlContext.TotalDeltaCopied, 1)
+	atomic.AddInt64(&a.mysqlContext.TotalDeltaCopied, int64(len(binlogEntries)))
 	return nil
 }
 
@@ -1344,7 +2141,6 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 
 	queries := []string{}
 	queries = append(queries, entry.SystemVariablesStatement, entry.SqlMode, entry.DbSQL)
-	queries = append(queries, entry.TbSQL...)
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -1354,6 +2150,9 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 			a.onError(TaskStateDead, err)
 		}
 		atomic.AddInt64(&a.mysqlContext.TotalRowsReplay, entry.RowsCount)
+		if entry.TableName != "" {
+			a.copyStat.add(entry.TableSchema, entry.TableName, entry.RowsCount)
+		}
 	}()
 	sessionQuery := `SET @@session.foreign_key_checks = 0`
 	if _, err := tx.Exec(sessionQuery); err != nil {
@@ -1364,6 +2163,9 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		_, err := tx.Exec(query)
 		if err != nil {
 			if !sql.IgnoreError(err) {
+				if a.ignoresError(err, fmt.Sprintf("the full copy of %v.%v", entry.TableSchema, entry.TableName)) {
+					return nil
+				}
 				a.logger.Errorf("mysql.applier: Exec [%s] error: %v", utils.StrLim(query, 10), err)
 				return err
 			}
@@ -1383,17 +2185,80 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 			return err
 		}
 	}
+	for _, query := range entry.TbSQL {
+		if entry.CreateTableName != "" && isCreateTable(query) {
+			created, err := a.createTable(tx, entry, query)
+			if err != nil {
+				return err
+			}
+			if !created {
+				// the statements after CREATE TABLE (e.g. adding a surrogate key) are for a new table
+				break
+			}
+			continue
+		}
+		if err := execQuery(query); err != nil {
+			return err
+		}
+	}
+
+	if err := a.transcodeDumpEntry(entry); err != nil {
+		return err
+	}
+	if err := a.convertDumpEntryZeroDates(entry); err != nil {
+		return err
+	}
+
+	if a.mysqlContext.UseLoadData && len(entry.ValuesX) > 0 {
+		loaded, err := a.loadRows(tx, entry)
+		if loaded {
+			return nil
+		}
+		if err != nil {
+			a.logger.Warnf("mysql.applier: LOAD DATA of a chunk of %v.%v error. inserting the rows: %v",
+				entry.TableSchema, entry.TableName, err)
+		}
+	}
+
+	var columnNames []string
+	if entry.Table != nil && entry.Table.OriginalTableColumns != nil {
+		// Explicit column list. The target might have more columns, e.g. a surrogate key.
+		for _, name := range entry.Table.OriginalTableColumns.Names() {
+			columnNames = append(columnNames, sql.EscapeName(name))
+		}
+	}
+	policy := a.mysqlContext.ConflictPolicy
+	head, tail := dumpInsertStatement(policy, entry.TableSchema, entry.TableName, columnNames)
+	execRows := func(query string, n int64) error {
+		a.logger.Debugf("mysql.applier: Exec [%s]", utils.StrLim(query, 256))
+		r, err := tx.Exec(query)
+		if err != nil {
+			if !sql.IgnoreError(err) || (policy == config.ConflictPolicyFail && sql.IsDupEntryError(err)) {
+				a.logger.Errorf("mysql.applier: Exec [%s] error: %v", utils.StrLim(query, 10), err)
+				return err
+			}
+			a.logger.Warnf("mysql.applier: Ignore error: %v", err)
+			return nil
+		}
+		if affected, err := r.RowsAffected(); err == nil {
+			atomic.AddInt64(&a.conflictCount, conflictsOf(policy, n, affected))
+		}
+		return nil
+	}
 
 	var buf bytes.Buffer
 	BufSizeLimit := 1 * 1024 * 1024 // 1MB. TODO parameterize it
 	BufSizeLimitDelta := 1024
 	buf.Grow(BufSizeLimit + BufSizeLimitDelta)
+	var nRows int64
 	for i, _ := range entry.ValuesX {
 		if buf.Len() == 0 {
-			buf.WriteString(fmt.Sprintf(`replace into %s.%s values (`, entry.TableSchema, entry.TableName))
+			buf.WriteString(head)
+			buf.WriteByte('(')
 		} else {
 			buf.WriteString(",(")
 		}
+		nRows++
 
 		firstCol := true
 		for j := range entry.ValuesX[i] {
@@ -1418,8 +2283,10 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		// last rows or sql too large
 
 		if needInsert {
-			err := execQuery(buf.String())
+			buf.WriteString(tail)
+			err := execRows(buf.String(), nRows)
 			buf.Reset()
+			nRows = 0
 			if err != nil {
 				return err
 			}
@@ -1470,6 +2337,9 @@ func (a *Applier) Stats() (*models.TaskStatistics, error) {
 		}
 	}
 
+	a.dumpProgressLock.Lock()
+	dumpProgress := a.mysqlContext.DumpProgress
+	a.dumpProgressLock.Unlock()
 	taskResUsage := models.TaskStatistics{
 		ExecMasterRowCount: totalRowsReplay,
 		ExecMasterTxCount:  totalDeltaCopied,
@@ -1480,25 +2350,147 @@ func (a *Applier) Stats() (*models.TaskStatistics, error) {
 		Backlog:            backlog,
 		Stage:              a.mysqlContext.Stage,
 		CurrentCoordinates: a.currentCoordinates,
+		RowImage:           a.mysqlContext.BinlogRowImage,
+		TableCopyStats:     a.copyStat.stats(),
+		DumpProgress:       dumpProgress,
+		DumpResumed:        a.dumpResumed,
+		MetadataCache:      a.metaCache.stat(),
+		ServerUuid:         a.mysqlContext.MySQLServerUuid,
+		ReadOnlyPauses:     atomic.LoadInt64(&a.readOnlyPauses),
+		Failovers:          atomic.LoadInt64(&a.failovers),
+		ExecTimeouts:       atomic.LoadInt64(&a.execTimeouts),
+		Deadlocks:          a.deadlockStat(),
+		Status:             models.TaskStatusActive,
+		IdleParks:          atomic.LoadInt64(&a.idleParks),
+		ThroughputStat: &models.ThroughputStat{
+			Num:  uint64(atomic.LoadInt64(&a.loadDataRows)),
+			Time: uint64(time.Duration(atomic.LoadInt64(&a.loadDataNanos)) / time.Millisecond),
+		},
 		BufferStat: models.BufferStat{
 			ApplierTxQueueSize:      len(a.applyBinlogTxQueue),
 			ApplierGroupTxQueueSize: len(a.applyBinlogGroupTxQueue),
+			ApplierWorkers:          int(atomic.LoadInt32(&a.activeWorkers)),
 		},
 		Timestamp: time.Now().UTC().UnixNano(),
 	}
+	a.workersLock.RLock()
+	writeSet := a.writeSet
+	a.workersLock.RUnlock()
+	if writeSet != nil {
+		inFlight := writeSet.inFlight(atomic.LoadInt64(&a.mtsManager.lastCommitted))
+		taskResUsage.BufferStat.WriteSetBuckets = writeSetBuckets
+		taskResUsage.BufferStat.WriteSetInFlight = inFlight
+		taskResUsage.BufferStat.WriteSetFalsePositiveRate = float64(inFlight) / writeSetBuckets
+		taskResUsage.BufferStat.WriteSetWaits = atomic.LoadInt64(&writeSet.waits)
+	}
+	if atomic.LoadInt32(&a.parked) == 1 {
+		taskResUsage.Status = models.TaskStatusIdle
+	}
+	if a.dataCipher != nil {
+		taskResUsage.Encryption = a.dataCipher.Stat()
+	}
+	if executed := a.executedGtid(); executed != "" {
+		coordinates := *a.currentCoordinates
+		coordinates.ExecutedGtidSet = executed
+		taskResUsage.CurrentCoordinates = &coordinates
+	}
+	total, sinceReset, resetAt := a.statsBaseline.snapshot(a.counters)
+	taskResUsage.TableStats = sinceReset.TableStats
+	taskResUsage.SinceTaskStart = total
+	taskResUsage.StatsResetAt = resetAt
+	if a.txCounter != nil {
+		taskResUsage.TxStat = a.txCounter.stat(time.Now())
+		taskResUsage.TxStat.Succeeded = sinceReset.TxSucceeded
+		taskResUsage.TxStat.Failed = sinceReset.TxFailed
+	}
+	if a.groupSizer != nil {
+		taskResUsage.BufferStat.ApplierGroupSize, taskResUsage.BufferStat.ApplierGroupSizeChanges = a.groupSizer.stat()
+	}
+	if rowImage, ok := a.rowImage.Load().(string); ok {
+		taskResUsage.RowImage = rowImage
+	}
+	taskResUsage.GtidGap = a.gtidGap()
+	a.rowCountsLock.Lock()
+	taskResUsage.RowCounts = a.rowCounts
+	a.rowCountsLock.Unlock()
+	taskResUsage.ZeroDateConversions = a.zeroDateConversions()
+	taskResUsage.IgnoredErrors = a.ignoredErrorCounts()
+	if handoff := a.currentHandoff(); handoff != nil {
+		taskResUsage.ConcurrentCopy = handoff.stat()
+	}
+	taskResUsage.Transport = &models.TransportStat{
+		Subjects: a.transport.stats(),
+	}
+	a.peerTransportLock.Lock()
+	taskResUsage.Transport.PeerSubjects = a.peerTransport
+	a.peerTransportLock.Unlock()
+	taskResUsage.DelayCount = &models.DelayCount{
+		Num:  uint64(taskResUsage.GtidGap.Transactions),
+		Time: uint64(taskResUsage.GtidGap.Seconds),
+	}
+	if lag := atomic.LoadInt64(&a.sourceReplicaLag); lag > 0 {
+		// end to end, from the primary
+		taskResUsage.DelayCount.Time += uint64(lag)
+	}
 	if a.natsConn != nil {
 		taskResUsage.MsgStat = a.natsConn.Statistics
 	}
+	if codec, ok := a.msgCodec.Load().(string); ok {
+		taskResUsage.MsgCodec = codec
+	}
+	taskResUsage.Throughput = &models.TaskThroughput{
+		Events: totalRowsReplay + totalDeltaCopied,
+		Bytes:  int64(taskResUsage.MsgStat.InBytes),
+	}
 
 	return &taskResUsage, nil
 }
 
+func (a *Applier) markGtidReceived(coordinates *base.BinlogCoordinateTx) {
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	a.gtidReceived.AddGtid(coordinates.SID, coordinates.GNO)
+	if coordinates.Timestamp > a.lastReceivedTs {
+		a.lastReceivedTs = coordinates.Timestamp
+	}
+}
+
+func (a *Applier) markGtidApplied(coordinates *base.BinlogCoordinateTx) {
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	a.gtidApplied.AddGtid(coordinates.SID, coordinates.GNO)
+	if coordinates.Timestamp > a.lastAppliedTs {
+		a.lastAppliedTs = coordinates.Timestamp
+	}
+	if coordinates.LogFile != "" {
+		a.lastAppliedFile, a.lastAppliedPos = coordinates.LogFile, coordinates.LogPos
+	}
+}
+
+// gtidGap computes the received-but-not-applied transactions by subtracting the GTID sets.
+// Both sets are kept normalized, so it is linear in the number of intervals.
+func (a *Applier) gtidGap() *models.GtidGap {
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	gap := &models.GtidGap{
+		Transactions: a.gtidReceived.Subtract(a.gtidApplied).Count(),
+	}
+	if gap.Transactions > 0 && a.lastReceivedTs > a.lastAppliedTs {
+		gap.Seconds = int64(a.lastReceivedTs - a.lastAppliedTs)
+	}
+	return gap
+}
+
 func (a *Applier) ID() string {
+	a.dumpProgressLock.Lock()
+	dumpProgress := a.mysqlContext.DumpProgress
+	a.dumpProgressLock.Unlock()
 	id := config.DriverCtx{
 		DriverConfig: &config.MySQLDriverConfig{
 			ReplicateDoDb:     a.mysqlContext.ReplicateDoDb,
 			ReplicateIgnoreDb: a.mysqlContext.ReplicateIgnoreDb,
 			Gtid:              a.mysqlContext.Gtid,
+			DumpProgress:      dumpProgress,
 			NatsAddr:          a.mysqlContext.NatsAddr,
 			ParallelWorkers:   a.mysqlContext.ParallelWorkers,
 			ConnectionConfig:  a.mysqlContext.ConnectionConfig,
@@ -1537,6 +2529,27 @@ func (a *Applier) onError(state int, err error) {
 	a.Shutdown()
 }
 
+// Stop stops the replay gracefully: it takes no more transactions, and the ones in progress are
+// committed, so the Gtid of ID is a clean checkpoint. It returns the error of ctx if they are not
+// committed in time. During the full copy, the checkpoint is the DumpProgress of the copied chunks.
+func (a *Applier) Stop(ctx context.Context) error {
+	a.shutdownLock.Lock()
+	a.stopped = true
+	a.shutdownLock.Unlock()
+	a.stopOnce.Do(func() {
+		close(a.stopCh)
+	})
+	if atomic.LoadInt32(&a.replaying) == 0 {
+		return nil
+	}
+	select {
+	case <-a.replayDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (a *Applier) WaitCh() chan *models.WaitResult {
 	return a.waitCh
 }
@@ -1554,10 +2567,22 @@ func (a *Applier) Shutdown() error {
 
 	a.shutdown = true
 	close(a.shutdownCh)
+	if a.execWatchdog != nil {
+		a.execWatchdog.stop()
+	}
+	if a.stopped {
+		// the end of a graceful stop
+		select {
+		case a.waitCh <- models.NewWaitResult(TaskStateComplete, nil):
+		default:
+		}
+	}
 
 	if err := sql.CloseDB(a.db); err != nil {
 		return err
 	}
+	a.workersLock.RLock()
+	defer a.workersLock.RUnlock()
 	if err := sql.CloseConns(a.dbs...); err != nil {
 		return err
 	}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// unparkTimeout bounds the reopening of the connections to the target by unpark. The task
+	// fails past it, and resumes from the applied transactions after a restart.
+	unparkTimeout = 30 * time.Second
+	// interval between the attempts to reopen the connections
+	unparkRetryInterval = time.Second
+	// idle connections kept by the pool when the applier is active, as by database/sql
+	defaultMaxIdleConns = 2
+)
+
+// shouldPark returns whether the replay parks the applier at now, having taken no
+// transaction since lastActive. The full copy, if any, must be complete: it writes
+// through the pool.
+func (a *Applier) shouldPark(lastActive, now time.Time) bool {
+	if a.mysqlContext.DisableIdleParking || a.parkedWorkers > 0 || len(a.workers) == 0 {
+		return false
+	}
+	if a.mysqlContext.Gtid == "" {
+		return false
+	}
+	if len(a.applyDataEntryQueue) > 0 || len(a.applyBinlogMtsTxQueue) > 0 {
+		return false
+	}
+	return now.Sub(lastActive) >= time.Duration(a.mysqlContext.ParkIdleAfter)*time.Second
+}
+
+// park stops the workers and closes their connections to the target, and the idle ones
+// of the pool. It is called by the replay with the transactions in progress committed.
+// The nats subscriptions are kept: the next transaction waits in the queue, and is
+// taken by the replay, which unparks the applier before applying it.
+func (a *Applier) park(idle time.Duration) {
+	n := len(a.workers)
+	// stopping workers does not fail
+	a.resizePool(context.Background(), 0)
+	a.db.SetMaxIdleConns(0)
+	a.parkedWorkers = n
+	atomic.StoreInt32(&a.parked, 1)
+	atomic.AddInt64(&a.idleParks, 1)
+
+	a.logger.Printf("mysql.applier: Idle for %v. Closed the connections to the target", idle.Round(time.Second))
+	a.emitEvent("Idle for %v. Closed the connections to the target", idle.Round(time.Second))
+}
+
+// unpark reopens the connections closed by park, and restarts the workers. It retries for
+// unparkTimeout at most.
+func (a *Applier) unpark() error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), unparkTimeout)
+	defer cancel()
+
+	a.db.SetMaxIdleConns(defaultMaxIdleConns)
+	for {
+		err := a.resizePool(ctx, a.parkedWorkers)
+		if err == nil {
+			break
+		}
+		a.logger.Warnf("mysql.applier: Failed to reopen the connections to the target: %v", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to reopen the connections to the target in %v: %v", unparkTimeout, err)
+		case <-a.shutdownCh:
+			return fmt.Errorf("shutdown while reopening the connections to the target")
+		case <-time.After(unparkRetryInterval):
+		}
+	}
+	a.parkedWorkers = 0
+	atomic.StoreInt32(&a.parked, 0)
+
+	a.logger.Printf("mysql.applier: Active. Reopened the connections to the target in %v", time.Since(start))
+	a.emitEvent("Active. Reopened the connections to the target in %v", time.Since(start))
+	return nil
+}
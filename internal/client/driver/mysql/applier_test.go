@@ -7,17 +7,29 @@
 package mysql
 
 import (
+	"context"
 	gosql "database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"github.com/actiontech/dtle/internal/atrest"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/g"
+	"github.com/actiontech/dtle/internal/gtid"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 
 	gonats "github.com/nats-io/go-nats"
+	uuid "github.com/satori/go.uuid"
 )
 
 func TestNewApplier(t *testing.T) {
@@ -36,7 +48,7 @@ func TestNewApplier(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewApplier(tt.args.subject, tt.args.tp, tt.args.cfg, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
+			if got, _ := NewApplier(tt.args.subject, tt.args.tp, tt.args.cfg, tt.args.logger, nil); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewApplier() = %v, want %v", got, tt.want)
 			}
 		})
@@ -57,28 +69,6 @@ func TestApplier_Run(t *testing.T) {
 	}
 }
 
-func TestApplier_onApplyTxStruct(t *testing.T) {
-	type args struct {
-		dbApplier *sql.DB
-		binlogTx  *binlog.BinlogTx
-	}
-	tests := []struct {
-		name    string
-		a       *Applier
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.a.onApplyTxStruct(tt.args.dbApplier, tt.args.binlogTx); (err != nil) != tt.wantErr {
-				t.Errorf("Applier.onApplyTxStruct() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
 func TestApplier_executeWriteFuncs(t *testing.T) {
 	tests := []struct {
 		name string
@@ -167,16 +157,16 @@ func TestApplier_initDBConnections(t *testing.T) {
 
 func TestApplier_validateServerUUID(t *testing.T) {
 	tests := []struct {
-		name string
-		a    *Applier
-		want string
+		name    string
+		a       *Applier
+		wantErr bool
 	}{
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.a.validateServerUUID(); got != tt.want {
-				t.Errorf("Applier.validateServerUUID() = %v, want %v", got, tt.want)
+			if err := tt.a.validateServerUUID(); (err != nil) != tt.wantErr {
+				t.Errorf("Applier.validateServerUUID() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -222,13 +212,14 @@ func TestApplier_validateAndReadTimeZone(t *testing.T) {
 
 func TestApplier_buildDMLEventQuery(t *testing.T) {
 	type args struct {
-		dmlEvent binlog.DataEvent
+		dmlEvent  binlog.DataEvent
+		workerIdx int
 	}
 	tests := []struct {
 		name          string
 		a             *Applier
 		args          args
-		wantQuery     string
+		wantQuery     *gosql.Stmt
 		wantArgs      []interface{}
 		wantRowsDelta int64
 		wantErr       bool
@@ -237,7 +228,7 @@ func TestApplier_buildDMLEventQuery(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotQuery, gotArgs, gotRowsDelta, err := tt.a.buildDMLEventQuery(tt.args.dmlEvent)
+			gotQuery, gotArgs, gotRowsDelta, err := tt.a.buildDMLEventQuery(tt.args.dmlEvent, tt.args.workerIdx)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Applier.buildDMLEventQuery() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -257,8 +248,8 @@ func TestApplier_buildDMLEventQuery(t *testing.T) {
 
 func TestApplier_ApplyBinlogEvent(t *testing.T) {
 	type args struct {
-		db     *gosql.DB
-		events [](binlog.DataEvent)
+		workerIdx   int
+		binlogEntry *binlog.BinlogEntry
 	}
 	tests := []struct {
 		name    string
@@ -270,7 +261,7 @@ func TestApplier_ApplyBinlogEvent(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.a.ApplyBinlogEvent(tt.args.db, tt.args.events); (err != nil) != tt.wantErr {
+			if err := tt.a.ApplyBinlogEvent(tt.args.workerIdx, tt.args.binlogEntry); (err != nil) != tt.wantErr {
 				t.Errorf("Applier.ApplyBinlogEvent() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -341,7 +332,8 @@ func TestApplier_ID(t *testing.T) {
 
 func TestApplier_onError(t *testing.T) {
 	type args struct {
-		err error
+		state int
+		err   error
 	}
 	tests := []struct {
 		name string
@@ -352,7 +344,7 @@ func TestApplier_onError(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.a.onError(tt.args.err)
+			tt.a.onError(tt.args.state, tt.args.err)
 		})
 	}
 }
@@ -391,16 +383,14 @@ func TestApplier_Shutdown(t *testing.T) {
 	}
 }
 
-func TestApplier_onApplyTxStructWithSetGtid(t *testing.T) {
+func TestApplier_validateGrants(t *testing.T) {
 	type fields struct {
 		logger                  *log.Entry
 		subject                 string
 		tp                      string
 		mysqlContext            *config.MySQLDriverConfig
-		dbs                     []*sql.DB
-		singletonDB             *gosql.DB
-		totalRowCount           int
-		applyRowCount           int
+		dbs                     []*sql.Conn
+		db                      *gosql.DB
 		rowCopyComplete         chan bool
 		rowCopyCompleteFlag     int64
 		copyRowsQueue           chan *DumpEntry
@@ -415,14 +405,9 @@ func TestApplier_onApplyTxStructWithSetGtid(t *testing.T) {
 		shutdownCh              chan struct{}
 		shutdownLock            sync.Mutex
 	}
-	type args struct {
-		dbApplier *sql.DB
-		binlogTx  *binlog.BinlogTx
-	}
 	tests := []struct {
 		name    string
 		fields  fields
-		args    args
 		wantErr bool
 	}{
 		// TODO: Add test cases.
@@ -435,9 +420,7 @@ func TestApplier_onApplyTxStructWithSetGtid(t *testing.T) {
 				tp:                      tt.fields.tp,
 				mysqlContext:            tt.fields.mysqlContext,
 				dbs:                     tt.fields.dbs,
-				singletonDB:             tt.fields.singletonDB,
-				totalRowCount:           tt.fields.totalRowCount,
-				applyRowCount:           tt.fields.applyRowCount,
+				db:                      tt.fields.db,
 				rowCopyComplete:         tt.fields.rowCopyComplete,
 				rowCopyCompleteFlag:     tt.fields.rowCopyCompleteFlag,
 				copyRowsQueue:           tt.fields.copyRowsQueue,
@@ -452,108 +435,1084 @@ func TestApplier_onApplyTxStructWithSetGtid(t *testing.T) {
 				shutdownCh:              tt.fields.shutdownCh,
 				shutdownLock:            tt.fields.shutdownLock,
 			}
-			if err := a.onApplyTxStructWithSetGtid(tt.args.dbApplier, tt.args.binlogTx); (err != nil) != tt.wantErr {
-				t.Errorf("Applier.onApplyTxStructWithSetGtid() error = %v, wantErr %v", err, tt.wantErr)
+			if err := a.validateGrants(); (err != nil) != tt.wantErr {
+				t.Errorf("Applier.validateGrants() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestApplier_validateGrants(t *testing.T) {
-	type fields struct {
-		logger                  *log.Entry
-		subject                 string
-		tp                      string
-		mysqlContext            *config.MySQLDriverConfig
-		dbs                     []*sql.DB
-		singletonDB             *gosql.DB
-		totalRowCount           int
-		applyRowCount           int
-		rowCopyComplete         chan bool
-		rowCopyCompleteFlag     int64
-		copyRowsQueue           chan *DumpEntry
-		applyDataEntryQueue     chan *binlog.BinlogEntry
-		applyBinlogTxQueue      chan *binlog.BinlogTx
-		applyBinlogGroupTxQueue chan []*binlog.BinlogTx
-		lastAppliedBinlogTx     *binlog.BinlogTx
-		natsConn                *gonats.Conn
-		waitCh                  chan *models.WaitResult
-		wg                      sync.WaitGroup
-		shutdown                bool
-		shutdownCh              chan struct{}
-		shutdownLock            sync.Mutex
+func TestApplier_onApplyTxStructWithSuper(t *testing.T) {
+	type args struct {
+		dbApplier *sql.Conn
+		binlogTx  *binlog.BinlogTx
 	}
 	tests := []struct {
 		name    string
-		fields  fields
+		a       *Applier
+		args    args
 		wantErr bool
 	}{
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := &Applier{
-				logger:                  tt.fields.logger,
-				subject:                 tt.fields.subject,
-				tp:                      tt.fields.tp,
-				mysqlContext:            tt.fields.mysqlContext,
-				dbs:                     tt.fields.dbs,
-				singletonDB:             tt.fields.singletonDB,
-				totalRowCount:           tt.fields.totalRowCount,
-				applyRowCount:           tt.fields.applyRowCount,
-				rowCopyComplete:         tt.fields.rowCopyComplete,
-				rowCopyCompleteFlag:     tt.fields.rowCopyCompleteFlag,
-				copyRowsQueue:           tt.fields.copyRowsQueue,
-				applyDataEntryQueue:     tt.fields.applyDataEntryQueue,
-				applyBinlogTxQueue:      tt.fields.applyBinlogTxQueue,
-				applyBinlogGroupTxQueue: tt.fields.applyBinlogGroupTxQueue,
-				lastAppliedBinlogTx:     tt.fields.lastAppliedBinlogTx,
-				natsConn:                tt.fields.natsConn,
-				waitCh:                  tt.fields.waitCh,
-				wg:                      tt.fields.wg,
-				shutdown:                tt.fields.shutdown,
-				shutdownCh:              tt.fields.shutdownCh,
-				shutdownLock:            tt.fields.shutdownLock,
-			}
-			if err := a.validateGrants(); (err != nil) != tt.wantErr {
-				t.Errorf("Applier.validateGrants() error = %v, wantErr %v", err, tt.wantErr)
+			if err := tt.a.onApplyTxStructWithSuper(tt.args.dbApplier, tt.args.binlogTx); (err != nil) != tt.wantErr {
+				t.Errorf("Applier.onApplyTxStructWithSuper() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestApplier_onApplyTxStructWithSuper(t *testing.T) {
-	type args struct {
-		dbApplier *sql.DB
-		binlogTx  *binlog.BinlogTx
-	}
+func Test_newDependencyGroupIndex(t *testing.T) {
 	tests := []struct {
 		name    string
-		a       *Applier
-		args    args
+		groups  [][]string
+		want    map[string]int
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"empty", nil, map[string]int{}, false},
+		{"two-groups", [][]string{{"db1.parent", "db1.child"}, {"db2.a", "db2.b"}},
+			map[string]int{"db1.parent": 0, "db1.child": 0, "db2.a": 1, "db2.b": 1}, false},
+		{"no-schema", [][]string{{"parent", "db1.child"}}, nil, true},
+		{"in-two-groups", [][]string{{"db1.parent", "db1.child"}, {"db1.child", "db1.other"}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.a.onApplyTxStructWithSuper(tt.args.dbApplier, tt.args.binlogTx); (err != nil) != tt.wantErr {
-				t.Errorf("Applier.onApplyTxStructWithSuper() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := newDependencyGroupIndex(tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newDependencyGroupIndex() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDependencyGroupIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newDmlFilterIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		dataSources []*config.DataSource
+		want        map[string]map[binlog.EventDML]bool
+		wantErr     bool
+	}{
+		{"empty", nil, map[string]map[binlog.EventDML]bool{}, false},
+		{"insert-only", []*config.DataSource{{TableSchema: "db1", Tables: []*config.Table{
+			{TableName: "tb1", DmlFilter: []string{"update", "DELETE"}},
+			{TableName: "tb2"},
+		}}}, map[string]map[binlog.EventDML]bool{
+			"db1.tb1": {binlog.UpdateDML: true, binlog.DeleteDML: true},
+		}, false},
+		{"bad-type", []*config.DataSource{{TableSchema: "db1", Tables: []*config.Table{
+			{TableName: "tb1", DmlFilter: []string{"replace"}},
+		}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newDmlFilterIndex(tt.dataSources)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newDmlFilterIndex() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDmlFilterIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compareRowCount(t *testing.T) {
+	tests := []struct {
+		name         string
+		entry        *rowCountEntry
+		targetCount  int64
+		tolerance    int64
+		wantDelta    int64
+		wantDiverged bool
+	}{
+		{"equal", &rowCountEntry{SourceCount: 10}, 10, 0, 0, false},
+		{"within-tolerance", &rowCountEntry{SourceCount: 10}, 8, 2, -2, false},
+		{"beyond-tolerance", &rowCountEntry{SourceCount: 10}, 13, 2, 3, true},
+		{"skipped", &rowCountEntry{Skipped: true}, 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareRowCount(tt.entry, tt.targetCount, tt.tolerance)
+			if got.Delta != tt.wantDelta || got.Diverged != tt.wantDiverged || got.Skipped != tt.entry.Skipped {
+				t.Errorf("compareRowCount() = %+v, want Delta %v, Diverged %v", got, tt.wantDelta, tt.wantDiverged)
 			}
 		})
 	}
 }
 
-func TestApplier_Test008ProvidesFlowControlToThrottleOverSending(t *testing.T) {
+func Test_peerPublishing(t *testing.T) {
 	tests := []struct {
 		name string
-		a    *Applier
+		prev []*models.SubjectStat
+		cur  []*models.SubjectStat
+		want []string
 	}{
-		// TODO: Add test cases.
+		{"first-report", nil, []*models.SubjectStat{
+			{Subject: "job_full", PublishMsgs: 0},
+			{Subject: "job_incr_hete", PublishMsgs: 3},
+		}, []string{"job_incr_hete"}},
+		{"no-new-messages", []*models.SubjectStat{
+			{Subject: "job_incr_hete", PublishMsgs: 3},
+		}, []*models.SubjectStat{
+			{Subject: "job_incr_hete", PublishMsgs: 3},
+		}, nil},
+		{"new-messages", []*models.SubjectStat{
+			{Subject: "job_full", PublishMsgs: 5},
+			{Subject: "job_incr_hete", PublishMsgs: 3},
+		}, []*models.SubjectStat{
+			{Subject: "job_full", PublishMsgs: 5},
+			{Subject: "job_incr_hete", PublishMsgs: 4},
+		}, []string{"job_incr_hete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := peerPublishing(tt.prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("peerPublishing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_grantSet_missingPrivileges(t *testing.T) {
+	grants := []string{
+		"GRANT REPLICATION SLAVE, REPLICATION CLIENT ON *.* TO 'dtle'@'%'",
+		"GRANT SELECT, INSERT, UPDATE, DELETE, CREATE ON `dtle`.* TO 'dtle'@'%'",
+		"GRANT ALL PRIVILEGES ON `db1`.* TO 'dtle'@'%'",
+		"GRANT SELECT, INSERT, UPDATE (c1) ON `db2`.`tb1` TO 'dtle'@'%'",
+	}
+	tests := []struct {
+		name     string
+		required []privilegeRequirement
+		want     []string
+	}{
+		{"global", []privilegeRequirement{
+			{Privilege: "REPLICATION SLAVE"},
+			{Privilege: "REPLICATION CLIENT"},
+		}, nil},
+		{"schema-all", []privilegeRequirement{
+			{Privilege: "DELETE", Schema: "db1", Table: "tb1"},
+			{Privilege: "CREATE", Schema: "db1"},
+		}, nil},
+		{"table", []privilegeRequirement{
+			{Privilege: "INSERT", Schema: "db2", Table: "tb1"},
+			{Privilege: "UPDATE", Schema: "db2", Table: "tb1"},
+			{Privilege: "DELETE", Schema: "db2", Table: "tb1"},
+			{Privilege: "DELETE", Schema: "db2", Table: "tb1"},
+			{Privilege: "SELECT", Schema: "db2", Table: "tb2"},
+		}, []string{"UPDATE ON `db2`.`tb1`", "DELETE ON `db2`.`tb1`", "SELECT ON `db2`.`tb2`"}},
+	}
+	gs := parseGrants(grants)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gs.missingPrivileges(tt.required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingPrivileges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_targetPrivileges(t *testing.T) {
+	cfg := &config.MySQLDriverConfig{
+		SkipCreateDbTable: true,
+		ReplicateDoDb: []*config.DataSource{{TableSchema: "db1", TableSchemaRename: "db2", Tables: []*config.Table{
+			{TableName: "tb1", TableRename: "tb2", DmlFilter: []string{"delete"}},
+		}}},
+	}
+	dmlFilterIndex, err := newDmlFilterIndex(cfg.ReplicateDoDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := parseGrants([]string{
+		fmt.Sprintf("GRANT ALL PRIVILEGES ON `%v`.* TO 'dtle'@'%%'", g.DtleSchemaName),
+		"GRANT SELECT ON `db2`.`tb2` TO 'dtle'@'%'",
+	})
+	got := gs.missingPrivileges(targetPrivileges(cfg, dmlFilterIndex))
+	want := []string{"INSERT ON `db2`.`tb2`", "UPDATE ON `db2`.`tb2`"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("missingPrivileges(targetPrivileges()) = %v, want %v", got, want)
+	}
+}
+
+func Test_writeSetTracker(t *testing.T) {
+	pkColumns := umconf.NewColumnList([]umconf.Column{{Name: "id", Key: "PRI"}, {Name: "val"}})
+	ukColumns := umconf.NewColumnList([]umconf.Column{{Name: "id", Key: "PRI"}, {Name: "code", Key: "UNI"}})
+	noPkColumns := umconf.NewColumnList([]umconf.Column{{Name: "val"}})
+	row := func(values ...interface{}) *umconf.ColumnValues {
+		return umconf.ToColumnValues(values)
+	}
+	tx := func(table string, columns *umconf.ColumnList, dml binlog.EventDML, where, new *umconf.ColumnValues) *binlog.BinlogEntry {
+		return &binlog.BinlogEntry{Events: []binlog.DataEvent{{
+			DatabaseName: "db1", TableName: table, DML: dml,
+			WhereColumnValues: where, NewColumnValues: new,
+			TableItem: &applierTableItem{columns: columns},
+		}}}
+	}
+	tests := []struct {
+		name     string
+		strict   bool
+		tx1      *binlog.BinlogEntry
+		tx2      *binlog.BinlogEntry
+		conflict bool
+	}{
+		{"same-pk", false, tx("t1", pkColumns, binlog.InsertDML, nil, row(1, "a")),
+			tx("t1", pkColumns, binlog.UpdateDML, row(1, "a"), row(1, "b")), true},
+		{"other-pk", false, tx("t1", pkColumns, binlog.InsertDML, nil, row(1, "a")),
+			tx("t1", pkColumns, binlog.InsertDML, nil, row(2, "a")), false},
+		{"other-table", false, tx("t1", pkColumns, binlog.InsertDML, nil, row(1, "a")),
+			tx("t2", pkColumns, binlog.InsertDML, nil, row(1, "a")), false},
+		{"pk-changed", false, tx("t1", pkColumns, binlog.UpdateDML, row(1, "a"), row(2, "a")),
+			tx("t1", pkColumns, binlog.DeleteDML, row(2, "a"), nil), true},
+		{"no-pk", false, tx("t3", noPkColumns, binlog.InsertDML, nil, row("a")),
+			tx("t3", noPkColumns, binlog.InsertDML, nil, row("b")), true},
+		{"unique-key", false, tx("t4", ukColumns, binlog.InsertDML, nil, row(1, "x")),
+			tx("t4", ukColumns, binlog.InsertDML, nil, row(2, "y")), false},
+		{"unique-key-strict", true, tx("t4", ukColumns, binlog.InsertDML, nil, row(1, "x")),
+			tx("t4", ukColumns, binlog.InsertDML, nil, row(2, "y")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWriteSetTracker(tt.strict)
+			w.add(w.bucketsOf(tt.tx1), 1)
+			if got := w.dependsOn(w.bucketsOf(tt.tx2)) == 1; got != tt.conflict {
+				t.Errorf("conflict = %v, want %v", got, tt.conflict)
+			}
+			if got := w.inFlight(0); got == 0 {
+				t.Errorf("inFlight(0) = 0, want the buckets of tx1")
+			}
+			if got := w.inFlight(1); got != 0 {
+				t.Errorf("inFlight(1) = %v, want 0 after tx1 is applied", got)
+			}
+		})
+	}
+}
+
+func Test_normalizeCreateTable(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{"same", "CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB",
+			"CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB", true},
+		{"auto-increment", "CREATE TABLE `t1` (\n  `id` int(11) NOT NULL AUTO_INCREMENT\n) ENGINE=InnoDB AUTO_INCREMENT=12 DEFAULT CHARSET=utf8",
+			"CREATE TABLE `t1` (\n  `id` int(11) NOT NULL AUTO_INCREMENT\n) ENGINE=InnoDB DEFAULT CHARSET=utf8", true},
+		{"column", "CREATE TABLE `t1` (\n  `id` int(11) NOT NULL\n) ENGINE=InnoDB",
+			"CREATE TABLE `t1` (\n  `id` bigint(20) NOT NULL\n) ENGINE=InnoDB", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.a.Test008ProvidesFlowControlToThrottleOverSending()
+			if got := normalizeCreateTable(tt.a) == normalizeCreateTable(tt.b); got != tt.want {
+				t.Errorf("normalizeCreateTable() equal = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
+
+func Test_encodeLoadData(t *testing.T) {
+	value := func(v interface{}) *interface{} { return &v }
+	columns := []umconf.Column{{Name: "id"}, {Name: "s"}, {Name: "b", Type: umconf.BlobColumnType}}
+	rows := [][]*interface{}{
+		{value([]byte("1")), value([]byte("a\tb\nc\\d")), value([]byte{0, 0xff})},
+		{value([]byte("2")), value(nil), value(nil)},
+	}
+	got, err := encodeLoadData(columns, rows)
+	if err != nil {
+		t.Fatalf("encodeLoadData() error = %v", err)
+	}
+	want := "1\ta\\tb\\nc\\\\d\t00ff\n2\t\\N\t\\N\n"
+	if string(got) != want {
+		t.Errorf("encodeLoadData() = %q, want %q", got, want)
+	}
+
+	query := loadDataStatement("/tmp/f", "db", "t", "utf8mb4", config.ConflictPolicyReplace, columns)
+	wantQuery := "LOAD DATA LOCAL INFILE '/tmp/f' REPLACE INTO TABLE `db`.`t` CHARACTER SET utf8mb4 " +
+		"(`id`,`s`,@dtle_col2) SET `b` = UNHEX(@dtle_col2)"
+	if query != wantQuery {
+		t.Errorf("loadDataStatement() = %v, want %v", query, wantQuery)
+	}
+}
+
+func Test_groupSizer(t *testing.T) {
+	g := newGroupSizer(1, 4, 100*time.Millisecond)
+	g.observe(1, 10*time.Millisecond)
+	g.observe(2, 10*time.Millisecond)
+	g.observe(2, 10*time.Millisecond) // not a full group
+	if got := g.Size(); got != 3 {
+		t.Fatalf("Size() = %v, want 3 after fast full groups", got)
+	}
+	g.observe(3, 10*time.Millisecond)
+	g.observe(4, 10*time.Millisecond)
+	if got := g.Size(); got != 4 {
+		t.Fatalf("Size() = %v, want the max 4", got)
+	}
+	g.observe(4, 200*time.Millisecond)
+	if got := g.Size(); got != 2 {
+		t.Fatalf("Size() = %v, want 2 after a slow group", got)
+	}
+	g.shrink("deadlock")
+	g.shrink("deadlock")
+	size, history := g.stat()
+	if size != 1 {
+		t.Errorf("size = %v, want the min 1", size)
+	}
+	if len(history) != 5 || history[4].From != 2 || history[4].To != 1 || history[4].Reason != "deadlock" {
+		t.Errorf("history = %v, want 5 changes ending with the deadlock", history)
+	}
+}
+
+func TestApplier_dequeueGroup(t *testing.T) {
+	entry := func(gno int64, dml binlog.EventDML) *binlog.BinlogEntry {
+		e := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{GNO: gno})
+		e.Events = append(e.Events, binlog.NewDataEvent("db1", "t", dml, 1))
+		return e
+	}
+	gnos := func(entries []*binlog.BinlogEntry) (r []int64) {
+		for _, e := range entries {
+			r = append(r, e.Coordinates.GNO)
+		}
+		return r
+	}
+	a := &Applier{
+		applyBinlogMtsTxQueue: make(chan *binlog.BinlogEntry, 8),
+		groupSizer:            newGroupSizer(4, 4, time.Second),
+	}
+
+	// The group stops before the DDL, which is applied after it on its own.
+	a.applyBinlogMtsTxQueue <- entry(2, binlog.InsertDML)
+	a.applyBinlogMtsTxQueue <- entry(3, binlog.NotDML)
+	a.applyBinlogMtsTxQueue <- entry(4, binlog.InsertDML)
+	group, rest := a.dequeueGroup(entry(1, binlog.InsertDML))
+	if got := gnos(group); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("group = %v, want [1 2]", got)
+	}
+	if rest == nil || rest.Coordinates.GNO != 3 {
+		t.Errorf("rest = %v, want the DDL 3", rest)
+	}
+	group, rest = a.dequeueGroup(<-a.applyBinlogMtsTxQueue)
+	if got := gnos(group); !reflect.DeepEqual(got, []int64{4}) || rest != nil {
+		t.Errorf("group = %v, rest = %v, want [4] alone", got, rest)
+	}
+
+	// A DDL is not grouped with the txs after it either.
+	a.applyBinlogMtsTxQueue <- entry(6, binlog.InsertDML)
+	group, rest = a.dequeueGroup(entry(5, binlog.NotDML))
+	if got := gnos(group); !reflect.DeepEqual(got, []int64{5}) || rest != nil {
+		t.Errorf("group = %v, rest = %v, want the DDL 5 alone", got, rest)
+	}
+	if len(a.applyBinlogMtsTxQueue) != 1 {
+		t.Errorf("%v txs left in the queue, want 1", len(a.applyBinlogMtsTxQueue))
+	}
+}
+
+func Test_transcodeValues(t *testing.T) {
+	newRow := func(values ...interface{}) []*interface{} {
+		row := make([]*interface{}, len(values))
+		for i := range values {
+			row[i] = &values[i]
+		}
+		return row
+	}
+	charsets := map[string]string{"name": "latin1", "note": "utf8"}
+	names := []string{"id", "name", "note"}
+
+	row := newRow(int64(1), "café", []byte("ok 😀"))
+	if err := transcodeValues(config.CharsetErrorPolicyFail, charsets, names, row); err == nil {
+		t.Errorf("transcodeValues(fail) succeeded, want an error for the emoji in note")
+	}
+
+	row = newRow(int64(1), "café 中", []byte("ok 😀"))
+	if err := transcodeValues(config.CharsetErrorPolicyReplace, charsets, names, row); err != nil {
+		t.Fatalf("transcodeValues(replace) error = %v", err)
+	}
+	if got := *row[0]; got != int64(1) {
+		t.Errorf("id = %v, want it untouched", got)
+	}
+	if got := *row[1]; got != "café ?" {
+		t.Errorf("name = %#v, want the replaced string", got)
+	}
+	if got, ok := (*row[2]).([]byte); !ok || string(got) != "ok ?" {
+		t.Errorf("note = %#v, want the replaced []byte", *row[2])
+	}
+}
+
+func Test_tableMetaCache(t *testing.T) {
+	c, err := newTableMetaCache(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := map[string]int{}
+	c.load = func(db sql.QueryAble, schema, table string) (*tableMeta, error) {
+		loaded[schema+"."+table]++
+		return &tableMeta{columns: umconf.NewColumnList([]umconf.Column{{Name: "id"}})}, nil
+	}
+	get := func(schema, table string) *tableMeta {
+		meta, err := c.get(nil, schema, table)
+		if err != nil {
+			t.Fatalf("get(%v.%v) error = %v", schema, table, err)
+		}
+		return meta
+	}
+
+	a1 := get("a", "t1")
+	if get("a", "t1") != a1 {
+		t.Errorf("get(a.t1) reloaded a cached table")
+	}
+	get("a", "t2")
+	get("b", "t1") // evicts a.t1, the least recently used
+	get("a", "t1")
+	if loaded["a.t1"] != 2 {
+		t.Errorf("a.t1 loaded %v times, want 2 after an eviction", loaded["a.t1"])
+	}
+
+	// a DDL on the schema
+	c.invalidate("a", "")
+	get("b", "t1")
+	get("a", "t1")
+	if loaded["a.t1"] != 3 || loaded["b.t1"] != 1 {
+		t.Errorf("loaded = %v, want a.t1 reloaded after the invalidation only", loaded)
+	}
+
+	stat := c.stat()
+	if stat.Size != 2 || stat.Hits != 2 || stat.Misses != 5 || stat.Evictions != 2 {
+		t.Errorf("stat = %+v, want size 2, 2 hits, 5 misses, 2 evictions by the size bound", stat)
+	}
+
+	// with a TTL
+	c, _ = newTableMetaCache(2, time.Millisecond)
+	c.load = func(db sql.QueryAble, schema, table string) (*tableMeta, error) {
+		return &tableMeta{}, nil
+	}
+	m1 := get("a", "t1")
+	time.Sleep(2 * time.Millisecond)
+	if get("a", "t1") == m1 {
+		t.Errorf("get(a.t1) returned an expired structure")
+	}
+	if stat := c.stat(); stat.Evictions != 0 {
+		t.Errorf("stat = %+v, want no evictions for an expiry", stat)
+	}
+}
+
+func TestApplier_setTableItemForBinlogEntry_reload(t *testing.T) {
+	a := &Applier{
+		logger:     log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		dbs:        make([]*sql.Conn, 1),
+		tableItems: make(mapSchemaTableItems),
+		mtsManager: NewMtsManager(make(chan struct{})),
+	}
+	a.metaCache, _ = newTableMetaCache(1, 0)
+	columns := []umconf.Column{{Name: "id", Key: "PRI"}, {Name: "v"}}
+	a.metaCache.load = func(db sql.QueryAble, schema, table string) (*tableMeta, error) {
+		return &tableMeta{columns: umconf.NewColumnList(columns)}, nil
+	}
+	entry := &binlog.BinlogEntry{Events: []binlog.DataEvent{{DatabaseName: "db1", TableName: "t1", DML: binlog.InsertDML}}}
+	if err := a.setTableItemForBinlogEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	item := a.getTableItem("db1", "t1")
+	stmt := &gosql.Stmt{}
+	item.psInsert[0] = stmt
+
+	// loaded again after an eviction, unchanged: a worker may still be executing the statement
+	a.metaCache.invalidate("db1", "t1")
+	if err := a.setTableItemForBinlogEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if item.psInsert[0] != stmt {
+		t.Errorf("the statement of an unchanged table is closed")
+	}
+
+	// changed without a DDL in the binlog: the columns are replaced
+	item.psInsert[0] = nil
+	columns = columns[:1]
+	a.metaCache.invalidate("db1", "t1")
+	if err := a.setTableItemForBinlogEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if item.columns.Len() != 1 {
+		t.Errorf("columns = %v, want the changed structure", item.columns.Names())
+	}
+}
+
+func Test_dumpInsertStatement(t *testing.T) {
+	columns := []string{"`id`", "`v`"}
+	tests := []struct {
+		policy  string
+		columns []string
+		want    string
+	}{
+		{config.ConflictPolicyFail, columns, "insert into db.t (`id`,`v`) values (1,'a'),(2,'b')"},
+		{config.ConflictPolicyIgnore, columns, "insert ignore into db.t (`id`,`v`) values (1,'a'),(2,'b')"},
+		{config.ConflictPolicyReplace, columns, "replace into db.t (`id`,`v`) values (1,'a'),(2,'b')"},
+		{config.ConflictPolicyUpsert, columns,
+			"insert into db.t (`id`,`v`) values (1,'a'),(2,'b') on duplicate key update `id`=values(`id`),`v`=values(`v`)"},
+		{config.ConflictPolicyUpsert, nil, "replace into db.t values (1,'a'),(2,'b')"},
+		{config.ConflictPolicyIgnore, nil, "insert ignore into db.t values (1,'a'),(2,'b')"},
+	}
+	for _, tt := range tests {
+		head, tail := dumpInsertStatement(tt.policy, "db", "t", tt.columns)
+		if got := head + "(1,'a'),(2,'b')" + tail; got != tt.want {
+			t.Errorf("dumpInsertStatement(%v, %v) = %v, want %v", tt.policy, tt.columns, got, tt.want)
+		}
+	}
+
+	query := loadDataStatement("/tmp/f", "db", "t", "utf8mb4", config.ConflictPolicyIgnore,
+		[]umconf.Column{{Name: "id"}})
+	if want := "LOAD DATA LOCAL INFILE '/tmp/f' IGNORE INTO TABLE `db`.`t` CHARACTER SET utf8mb4 (`id`)"; query != want {
+		t.Errorf("loadDataStatement() = %v, want %v", query, want)
+	}
+}
+
+func Test_conflictsOf(t *testing.T) {
+	tests := []struct {
+		policy      string
+		n, affected int64
+		want        int64
+	}{
+		{config.ConflictPolicyFail, 10, 10, 0},
+		{config.ConflictPolicyIgnore, 10, 7, 3},
+		{config.ConflictPolicyReplace, 10, 13, 3},
+		// a row conflicting on 2 unique keys deletes 2 rows
+		{config.ConflictPolicyReplace, 1, 3, 2},
+		{config.ConflictPolicyUpsert, 10, 14, 4},
+		// 2 rows updated to their current values are not counted
+		{config.ConflictPolicyUpsert, 10, 8, 0},
+	}
+	for _, tt := range tests {
+		if got := conflictsOf(tt.policy, tt.n, tt.affected); got != tt.want {
+			t.Errorf("conflictsOf(%v, %v, %v) = %v, want %v", tt.policy, tt.n, tt.affected, got, tt.want)
+		}
+	}
+}
+
+func Test_applierTableItem_resize(t *testing.T) {
+	item := newApplierTableItem(2)
+	item.psImage[1]["k"] = nil
+
+	item.resize(4)
+	if len(item.psInsert) != 4 || len(item.psDelete) != 4 || len(item.psUpdate) != 4 || len(item.psImage) != 4 {
+		t.Fatalf("resize(4) = %v, %v, %v, %v statements", len(item.psInsert), len(item.psDelete), len(item.psUpdate), len(item.psImage))
+	}
+	if _, ok := item.psImage[1]["k"]; !ok {
+		t.Errorf("resize(4) dropped the statements of a kept worker")
+	}
+	for i := 2; i < 4; i++ {
+		if item.psImage[i] == nil || len(item.psImage[i]) != 0 {
+			t.Errorf("psImage[%v] = %v, want an empty map", i, item.psImage[i])
+		}
+	}
+
+	item.resize(1)
+	if len(item.psInsert) != 1 || len(item.psImage) != 1 {
+		t.Fatalf("resize(1) = %v, %v statements", len(item.psInsert), len(item.psImage))
+	}
+	// growing again does not bring back the statements of the retired workers
+	item.resize(2)
+	if len(item.psImage[1]) != 0 {
+		t.Errorf("psImage[1] = %v, want an empty map", item.psImage[1])
+	}
+}
+
+func TestApplier_SetWorkers(t *testing.T) {
+	a := &Applier{
+		mysqlContext: &config.MySQLDriverConfig{ApproveHeterogeneous: false},
+		workersCh:    make(chan *workersRequest),
+		replayDone:   make(chan struct{}),
+	}
+	for _, n := range []int{0, -1, maxApplierWorkers + 1} {
+		if err := a.SetWorkers(context.Background(), n); err == nil {
+			t.Errorf("SetWorkers(%v) = nil, want an error", n)
+		}
+	}
+	if err := a.SetWorkers(context.Background(), 2); err == nil {
+		t.Errorf("SetWorkers() without ApproveHeterogeneous = nil, want an error")
+	}
+
+	a.mysqlContext.ApproveHeterogeneous = true
+	if err := a.SetWorkers(context.Background(), 2); err == nil {
+		t.Errorf("SetWorkers() before the replay = nil, want an error")
+	}
+
+	// the replay takes the request
+	a.replaying = 1
+	go func() {
+		req := <-a.workersCh
+		req.done <- fmt.Errorf("resized to %v", req.n)
+	}()
+	if err := a.SetWorkers(context.Background(), 3); err == nil || err.Error() != "resized to 3" {
+		t.Errorf("SetWorkers() = %v, want the error of the replay", err)
+	}
+
+	// the replay is over
+	close(a.replayDone)
+	if err := a.SetWorkers(context.Background(), 3); err == nil {
+		t.Errorf("SetWorkers() after the replay = nil, want an error")
+	}
+}
+
+func Test_splitXaEntries(t *testing.T) {
+	entry := func(gno int64, xaOp string) *binlog.BinlogEntry {
+		e := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{GNO: gno})
+		e.XaOp = xaOp
+		return e
+	}
+	gnos := func(groups [][]*binlog.BinlogEntry) (result [][]int64) {
+		for _, group := range groups {
+			var g []int64
+			for _, e := range group {
+				g = append(g, e.Coordinates.GNO)
+			}
+			result = append(result, g)
+		}
+		return result
+	}
+
+	entries := []*binlog.BinlogEntry{entry(1, ""), entry(2, ""), entry(3, binlog.XaOpPrepare), entry(4, ""),
+		entry(5, binlog.XaOpCommit), entry(6, binlog.XaOpRollback)}
+	want := [][]int64{{1, 2}, {3}, {4}, {5}, {6}}
+	if got := gnos(splitXaEntries(entries)); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitXaEntries() = %v, want %v", got, want)
+	}
+	want = [][]int64{{1, 2}}
+	if got := gnos(splitXaEntries(entries[:2])); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitXaEntries() = %v, want %v", got, want)
+	}
+}
+
+func TestApplier_shouldPark(t *testing.T) {
+	a := &Applier{
+		mysqlContext:          &config.MySQLDriverConfig{Gtid: "uuid:1-10", ParkIdleAfter: 600},
+		workers:               []*applierWorker{{}},
+		applyDataEntryQueue:   make(chan *binlog.BinlogEntry, 1),
+		applyBinlogMtsTxQueue: make(chan *binlog.BinlogEntry, 1),
+	}
+	now := time.Now()
+	if a.shouldPark(now.Add(-599*time.Second), now) {
+		t.Errorf("shouldPark() before ParkIdleAfter = true")
+	}
+	if !a.shouldPark(now.Add(-600*time.Second), now) {
+		t.Errorf("shouldPark() after ParkIdleAfter = false")
+	}
+
+	lastActive := now.Add(-time.Hour)
+	a.applyDataEntryQueue <- &binlog.BinlogEntry{}
+	if a.shouldPark(lastActive, now) {
+		t.Errorf("shouldPark() with a queued entry = true")
+	}
+	<-a.applyDataEntryQueue
+
+	a.mysqlContext.Gtid = ""
+	if a.shouldPark(lastActive, now) {
+		t.Errorf("shouldPark() during the full copy = true")
+	}
+	a.mysqlContext.Gtid = "uuid:1-10"
+
+	a.mysqlContext.DisableIdleParking = true
+	if a.shouldPark(lastActive, now) {
+		t.Errorf("shouldPark() with DisableIdleParking = true")
+	}
+	a.mysqlContext.DisableIdleParking = false
+
+	a.parkedWorkers, a.workers = 1, nil
+	if a.shouldPark(lastActive, now) {
+		t.Errorf("shouldPark() when parked = true")
+	}
+}
+
+func TestApplier_resizeWorkers_parked(t *testing.T) {
+	a := &Applier{
+		logger:        log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		mysqlContext:  &config.MySQLDriverConfig{},
+		parkedWorkers: 2,
+	}
+	// recorded for unpark, with no connection opened
+	if err := a.resizeWorkers(4); err != nil {
+		t.Fatalf("resizeWorkers() = %v", err)
+	}
+	if a.parkedWorkers != 4 || len(a.workers) != 0 || a.activeWorkers != 4 {
+		t.Errorf("parkedWorkers %v, workers %v, activeWorkers %v. want 4, 0, 4",
+			a.parkedWorkers, len(a.workers), a.activeWorkers)
+	}
+}
+
+func Test_txCounter(t *testing.T) {
+	start := time.Unix(1546300800, 0)
+	c := newTxCounter(time.Minute, start)
+	if stat := c.stat(start); stat.ErrorRatePct != 0 || stat.Succeeded != 0 {
+		t.Errorf("stat() of no transactions = %+v", stat)
+	}
+
+	c.add(3, nil, start.Add(time.Second))
+	c.add(1, fmt.Errorf("deadlock"), start.Add(2*time.Second))
+	stat := c.stat(start.Add(3 * time.Second))
+	want := &models.TxStat{Succeeded: 3, Failed: 1, ErrorRatePct: 25,
+		WindowSucceeded: 3, WindowFailed: 1, WindowStart: start.Unix()}
+	if !reflect.DeepEqual(stat, want) {
+		t.Errorf("stat() = %+v, want %+v", stat, want)
+	}
+
+	// the totals are kept across the windows
+	next := start.Add(time.Minute)
+	c.add(1, nil, next)
+	stat = c.stat(next.Add(time.Second))
+	want = &models.TxStat{Succeeded: 4, Failed: 1, ErrorRatePct: 0,
+		WindowSucceeded: 1, WindowFailed: 0, WindowStart: next.Unix()}
+	if !reflect.DeepEqual(stat, want) {
+		t.Errorf("stat() in the next window = %+v, want %+v", stat, want)
+	}
+}
+
+func TestApplier_ResetStats(t *testing.T) {
+	now := time.Now()
+	a := &Applier{txCounter: newTxCounter(time.Minute, now)}
+	a.insertCount, a.deleteCount = 5, 1
+	a.txCounter.add(3, nil, now)
+	a.txCounter.add(1, fmt.Errorf("deadlock"), now)
+
+	prev, err := a.ResetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &models.TaskCounters{TableStats: &models.TableStats{InsertCount: 5, DelCount: 1}, TxSucceeded: 3, TxFailed: 1}
+	if !reflect.DeepEqual(prev, want) {
+		t.Errorf("ResetStats() = %+v, want %+v", prev, want)
+	}
+
+	a.insertCount += 2
+	total, sinceReset, resetAt := a.statsBaseline.snapshot(a.counters)
+	want = &models.TaskCounters{TableStats: &models.TableStats{InsertCount: 2}}
+	if !reflect.DeepEqual(sinceReset, want) || resetAt == 0 {
+		t.Errorf("since the reset = %+v at %v, want %+v", sinceReset, resetAt, want)
+	}
+	if total.TableStats.InsertCount != 7 || total.TxSucceeded != 3 {
+		t.Errorf("since the task start = %+v", total)
+	}
+
+	// Nothing is lost or counted twice by the resets concurrent with the counting, and no
+	// counter since a reset is negative.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				atomic.AddInt64(&a.insertCount, 1)
+			}
+		}
+	}()
+	inserts := int64(0)
+	for i := 0; i < 1000; i++ {
+		if i%10 == 0 {
+			prev, _ := a.ResetStats()
+			inserts += prev.TableStats.InsertCount
+		}
+		if _, sinceReset, _ := a.statsBaseline.snapshot(a.counters); sinceReset.TableStats.InsertCount < 0 {
+			t.Fatalf("inserts since the reset = %v", sinceReset.TableStats.InsertCount)
+		}
+	}
+	close(done)
+	wg.Wait()
+	total, sinceReset, _ = a.statsBaseline.snapshot(a.counters)
+	if got := 5 + inserts + sinceReset.TableStats.InsertCount; got != total.TableStats.InsertCount {
+		t.Errorf("inserts over the resets = %v, want %v", got, total.TableStats.InsertCount)
+	}
+}
+
+func TestApplier_executedGtid(t *testing.T) {
+	a := &Applier{gtidApplied: make(gtid.Set)}
+	sid, other := "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59", "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	applied := func(sid string, gno int64) {
+		a.markGtidApplied(&base.BinlogCoordinateTx{SID: uuid.FromStringOrNil(sid), GNO: gno})
+	}
+	applied(sid, 11)
+	if got := a.executedGtid(); got != "" {
+		t.Errorf("executedGtid() before the incremental apply = %q", got)
+	}
+
+	a.setGtidBase(sid + ":1-10," + other + ":1-3")
+	applied(sid, 12)
+	applied(other, 5)
+	if got, want := a.executedGtid(), sid+":1-12,\n"+other+":1-3:5"; got != want {
+		t.Errorf("executedGtid() = %v, want %v", got, want)
+	}
+}
+
+func TestApplier_heartbeatPosition(t *testing.T) {
+	a := &Applier{gtidReceived: make(gtid.Set), gtidApplied: make(gtid.Set)}
+	now := time.Unix(1525910400, 0)
+	sid := uuid.FromStringOrNil("3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59")
+	if ts, file, _ := a.heartbeatPosition(now); !ts.Equal(now) || file != "" {
+		t.Errorf("heartbeatPosition() before any transaction = %v, %q", ts, file)
+	}
+
+	for gno := int64(1); gno <= 3; gno++ {
+		a.markGtidReceived(&base.BinlogCoordinateTx{SID: sid, GNO: gno, Timestamp: uint32(now.Unix()) - 60 + uint32(gno)})
+	}
+	a.markGtidApplied(&base.BinlogCoordinateTx{SID: sid, GNO: 1, Timestamp: uint32(now.Unix()) - 59,
+		LogFile: "mysql-bin.000003", LogPos: 1200})
+	// behind: the time of the last applied transaction on the source
+	ts, file, pos := a.heartbeatPosition(now)
+	if !ts.Equal(now.Add(-59*time.Second)) || file != "mysql-bin.000003" || pos != 1200 {
+		t.Errorf("heartbeatPosition() behind = %v, %v, %v", ts, file, pos)
+	}
+
+	// caught up: now
+	for gno := int64(2); gno <= 3; gno++ {
+		a.markGtidApplied(&base.BinlogCoordinateTx{SID: sid, GNO: gno, Timestamp: uint32(now.Unix()) - 60 + uint32(gno),
+			LogFile: "mysql-bin.000003", LogPos: 1200 + gno*100})
+	}
+	if ts, _, pos := a.heartbeatPosition(now); !ts.Equal(now) || pos != 1500 {
+		t.Errorf("heartbeatPosition() caught up = %v, %v", ts, pos)
+	}
+}
+
+func TestApplier_openLoadDataFile(t *testing.T) {
+	cipher, err := atrest.NewCipher(make([]byte, atrest.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Applier{dataCipher: cipher}
+	f, err := ioutil.TempFile("", "load_data_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := []byte("1\tabc\n2\t\\N\n")
+	sealed, err := cipher.Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(sealed)
+	f.Close()
+
+	if read, err := ioutil.ReadAll(a.openLoadDataFile(f.Name())); err != nil || string(read) != string(data) {
+		t.Errorf("openLoadDataFile() = %q, %v, want %q", read, err, data)
+	}
+	sealed[0] ^= 1
+	if err := ioutil.WriteFile(f.Name(), sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(a.openLoadDataFile(f.Name())); err == nil {
+		t.Errorf("openLoadDataFile() of an altered file succeeded")
+	}
+}
+
+func TestExecWatchdog(t *testing.T) {
+	w := newExecWatchdog(20 * time.Millisecond)
+	killed := make(chan int64, 1)
+	kill := func(ctx context.Context, connId int64) error {
+		killed <- connId
+		return nil
+	}
+	onError := func(err error) { t.Errorf("kill error: %v", err) }
+
+	fast := w.watch(7, kill, onError)
+	if w.done(fast) {
+		t.Errorf("a statement done in time is killed")
+	}
+	slow := w.watch(8, kill, onError)
+	select {
+	case id := <-killed:
+		if id != 8 {
+			t.Errorf("killed connection %v, want 8", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("a statement over the timeout is not killed")
+	}
+	if !w.done(slow) {
+		t.Errorf("done() of a killed statement = false")
+	}
+
+	pending := w.watch(9, kill, onError)
+	w.stop()
+	time.Sleep(50 * time.Millisecond)
+	if len(killed) != 0 || w.done(pending) {
+		t.Errorf("a statement is killed after stop")
+	}
+}
+
+func TestApplier_applyErrorState(t *testing.T) {
+	timeoutErr := &ExecTimeoutError{Schema: "db1", Table: "tb1", Rows: 3, Duration: time.Minute, Err: fmt.Errorf("query execution was interrupted")}
+	tests := []struct {
+		policy string
+		err    error
+		want   int
+	}{
+		{config.ExecTimeoutPolicyRetry, timeoutErr, TaskStateRestart},
+		{config.ExecTimeoutPolicyFail, timeoutErr, TaskStateDead},
+		{config.ExecTimeoutPolicyRetry, fmt.Errorf("duplicate entry"), TaskStateDead},
+	}
+	for _, tt := range tests {
+		a := &Applier{mysqlContext: &config.MySQLDriverConfig{ExecTimeoutPolicy: tt.policy}}
+		if got := a.applyErrorState(tt.err); got != tt.want {
+			t.Errorf("applyErrorState(%v) with %v = %v, want %v", tt.err, tt.policy, got, tt.want)
+		}
+	}
+}
+
+func Test_parseDeadlockThreads(t *testing.T) {
+	status := `
+------------------------
+LATEST DETECTED DEADLOCK
+------------------------
+2019-03-01 10:00:00 0x7f
+*** (1) TRANSACTION:
+TRANSACTION 1841, ACTIVE 0 sec inserting
+MySQL thread id 12, OS thread handle 1401, query id 300 localhost dtle update
+*** (2) TRANSACTION:
+TRANSACTION 1842, ACTIVE 0 sec inserting
+MySQL thread id 14, OS thread handle 1402, query id 301 localhost dtle update
+*** WE ROLL BACK TRANSACTION (2)
+------------
+TRANSACTIONS
+------------
+---TRANSACTION 1843, ACTIVE 3 sec
+MySQL thread id 20, OS thread handle 1403, query id 302 localhost app
+`
+	if got := parseDeadlockThreads(status); !reflect.DeepEqual(got, []int64{12, 14}) {
+		t.Errorf("parseDeadlockThreads() = %v, want [12 14]", got)
+	}
+	if got := parseDeadlockThreads("no deadlock"); got != nil {
+		t.Errorf("parseDeadlockThreads() = %v, want none", got)
+	}
+}
+
+func TestTableGate(t *testing.T) {
+	g := newTableGate()
+	g.enter([]string{"db.t1"})
+	g.enter([]string{"db.t2"})
+
+	// the retried tx waits for the tx in progress on its table
+	aloneIn := make(chan struct{})
+	go func() {
+		g.enterAlone([]string{"db.t1"})
+		close(aloneIn)
+	}()
+	select {
+	case <-aloneIn:
+		t.Fatalf("enterAlone() did not wait for the tx on its table")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if others := g.leave([]string{"db.t1"}, false); others != 1 {
+		t.Errorf("leave() = %v other txs, want 1", others)
+	}
+	<-aloneIn
+
+	// a new tx on the table waits for the retried tx, and others do not
+	entered := make(chan struct{})
+	go func() {
+		g.enter([]string{"db.t1", "db.t3"})
+		close(entered)
+	}()
+	g.enter([]string{"db.t3"})
+	select {
+	case <-entered:
+		t.Fatalf("enter() did not wait for the retried tx")
+	case <-time.After(50 * time.Millisecond):
+	}
+	g.leave([]string{"db.t1"}, true)
+	<-entered
+}
+
+func TestIsZeroOrInvalidDate(t *testing.T) {
+	for s, want := range map[string]bool{
+		"0000-00-00":                 true,
+		"0000-00-00 00:00:00":        true,
+		"0000-00-00 00:00:00.000000": true,
+		"2023-00-10":                 true,
+		"2023-05-00 10:00:00":        true,
+		"2023-02-30":                 true,
+		"2023-02-29 12:00:00":        true,
+		"2024-02-29 12:00:00":        false,
+		"2023-04-31":                 true,
+		"2023-13-01":                 true,
+		"0000-01-01":                 false,
+		"2023-02-28 23:59:59":        false,
+		"1970-01-01 00:00:01":        false,
+		"CURRENT_TIMESTAMP":          false,
+		"":                           false,
+	} {
+		if got := IsZeroOrInvalidDate(s); got != want {
+			t.Errorf("IsZeroOrInvalidDate(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func Test_convertZeroDates(t *testing.T) {
+	newRow := func(values ...interface{}) []*interface{} {
+		row := make([]*interface{}, len(values))
+		for i := range values {
+			row[i] = &values[i]
+		}
+		return row
+	}
+	temporals := temporalColumns(umconf.NewColumnList([]umconf.Column{
+		{Name: "id", ColumnType: "int(11)"},
+		{Name: "d", ColumnType: "date"},
+		{Name: "dt", ColumnType: "datetime(3)"},
+		{Name: "ts", ColumnType: "TIMESTAMP"},
+		{Name: "note", ColumnType: "varchar(10)"},
+	}))
+	if want := map[string]string{"d": "date", "dt": "datetime", "ts": "timestamp"}; !reflect.DeepEqual(temporals, want) {
+		t.Fatalf("temporalColumns() = %v, want %v", temporals, want)
+	}
+	names := []string{"id", "d", "dt", "ts", "note"}
+
+	row := newRow(int64(1), "0000-00-00", []byte("0000-00-00 00:00:00.000"), "0000-00-00 00:00:00", "0000-00-00")
+	if n := convertZeroDates(config.ZeroDatePolicyNull, temporals, names, row); n != 3 {
+		t.Errorf("convertZeroDates(null) = %v, want 3", n)
+	}
+	for i, want := range []interface{}{int64(1), nil, nil, nil, "0000-00-00"} {
+		if !reflect.DeepEqual(*row[i], want) {
+			t.Errorf("null: %v = %#v, want %#v", names[i], *row[i], want)
+		}
+	}
+
+	row = newRow(int64(1), "2023-02-30", "2023-02-30 10:00:00", []byte("2023-04-31 10:00:00"), nil)
+	if n := convertZeroDates(config.ZeroDatePolicyEpoch, temporals, names, row); n != 3 {
+		t.Errorf("convertZeroDates(epoch) = %v, want 3", n)
+	}
+	for i, want := range []interface{}{int64(1), "1970-01-01", "1970-01-01 00:00:00", "1970-01-02 00:00:00", nil} {
+		if !reflect.DeepEqual(*row[i], want) {
+			t.Errorf("epoch: %v = %#v, want %#v", names[i], *row[i], want)
+		}
+	}
+
+	row = newRow(int64(1), "2023-02-28", "2023-02-28 10:00:00", "2023-02-28 10:00:00", nil)
+	if n := convertZeroDates(config.ZeroDatePolicyEpoch, temporals, names, row); n != 0 || *row[1] != "2023-02-28" {
+		t.Errorf("convertZeroDates() of valid dates = %v, %v", n, *row[1])
+	}
+
+	a := &Applier{mysqlContext: &config.MySQLDriverConfig{ZeroDatePolicy: config.ZeroDatePolicyNull}}
+	a.convertRowZeroDates("db1", "t1", temporals, names,
+		newRow(int64(1), "0000-00-00", nil, nil, nil), newRow(int64(1), "2023-02-30", nil, nil, nil))
+	if got := a.zeroDateConversions(); !reflect.DeepEqual(got, map[string]int64{"db1.t1": 2}) {
+		t.Errorf("zeroDateConversions() = %v", got)
+	}
+}
+
+func TestRejectsZeroDates(t *testing.T) {
+	for mode, want := range map[string]bool{
+		"ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO": true,
+		"STRICT_ALL_TABLES,NO_ZERO_DATE":          true,
+		"STRICT_TRANS_TABLES":                     false,
+		"NO_ZERO_IN_DATE,NO_ZERO_DATE":            false,
+		"STRICT_TRANS_TABLES,ALLOW_INVALID_DATES": false,
+		"": false,
+	} {
+		if got := RejectsZeroDates(mode); got != want {
+			t.Errorf("RejectsZeroDates(%q) = %v, want %v", mode, got, want)
+		}
+	}
+}
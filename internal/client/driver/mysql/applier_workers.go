@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+)
+
+// maxApplierWorkers bounds SetWorkers. Each worker holds a connection to the target.
+const maxApplierWorkers = 64
+
+// applierWorker is an MTS worker. It exits when stopCh is closed, and closes done.
+type applierWorker struct {
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+type workersRequest struct {
+	n    int
+	done chan error
+}
+
+// startWorker starts the worker of index i, whose connection is a.dbs[i].
+func (a *Applier) startWorker(i int) {
+	w := &applierWorker{
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	a.workers = append(a.workers, w)
+	go a.MtsWorker(i, w)
+}
+
+// SetWorkers resizes the pool of the workers applying the binlog to n, in 1 to
+// maxApplierWorkers, until the task restarts. The replay resizes it between two
+// transactions, once those in progress are committed: the new workers take the
+// transactions from then on and the retired ones have none in hand, so none is
+// lost and the order of the dependent ones is kept. If ctx is done first, the
+// pool may still be resized later.
+func (a *Applier) SetWorkers(ctx context.Context, n int) error {
+	if n < 1 || n > maxApplierWorkers {
+		return fmt.Errorf("bad number of workers %v: expect 1 to %v", n, maxApplierWorkers)
+	}
+	if !a.mysqlContext.ApproveHeterogeneous {
+		return fmt.Errorf("the workers can be resized with ApproveHeterogeneous only")
+	}
+	if atomic.LoadInt32(&a.replaying) == 0 {
+		return fmt.Errorf("the applier is not replaying the binlog yet")
+	}
+
+	req := &workersRequest{n: n, done: make(chan error, 1)}
+	select {
+	case a.workersCh <- req:
+	case <-a.replayDone:
+		return fmt.Errorf("the applier has stopped replaying the binlog")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resizeWorkers resizes the pool to n workers. It is called by the replay with the
+// workers idle.
+func (a *Applier) resizeWorkers(n int) error {
+	old := len(a.workers)
+	if a.parkedWorkers > 0 {
+		old = a.parkedWorkers
+	}
+	if n == old {
+		return nil
+	}
+
+	if a.parkedWorkers > 0 {
+		// the pool is resized when the applier is unparked
+		a.parkedWorkers = n
+	} else if err := a.resizePool(context.Background(), n); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&a.activeWorkers, int32(n))
+	a.logger.Printf("mysql.applier: Resized the workers from %v to %v", old, n)
+	a.emitEvent("Resized the workers from %v to %v", old, n)
+	return nil
+}
+
+// resizePool starts or stops workers, opening or closing their connections, for n
+// workers. If it fails, the pool is left as it was.
+func (a *Applier) resizePool(ctx context.Context, n int) error {
+	old := len(a.workers)
+	if n > old {
+		conns, err := sql.CreateConnsContext(ctx, a.db, n-old)
+		if err != nil {
+			return err
+		}
+		if err := a.prepareGtidExecutedStmts(conns); err != nil {
+			sql.CloseConns(conns...)
+			return err
+		}
+		a.db.SetMaxOpenConns(10 + n)
+		a.workersLock.Lock()
+		a.dbs = append(a.dbs, conns...)
+		if a.writeSet == nil && n > 1 {
+			a.writeSet = newWriteSetTracker(a.mysqlContext.WriteSetStrict)
+		}
+		a.workersLock.Unlock()
+		a.resizeTableItems(n)
+		for i := old; i < n; i++ {
+			a.startWorker(i)
+		}
+	} else {
+		for _, w := range a.workers[n:] {
+			close(w.stopCh)
+		}
+		for _, w := range a.workers[n:] {
+			<-w.done
+		}
+		a.workers = a.workers[:n]
+		a.resizeTableItems(n)
+		a.workersLock.Lock()
+		retired := a.dbs[n:]
+		a.dbs = a.dbs[:n]
+		a.workersLock.Unlock()
+		if err := sql.CloseConns(retired...); err != nil {
+			a.logger.Warnf("mysql.applier: Failed to close the connections of the retired workers: %v", err)
+		}
+		a.db.SetMaxOpenConns(10 + n)
+	}
+	return nil
+}
+
+func (a *Applier) resizeTableItems(n int) {
+	for _, schemaItem := range a.tableItems {
+		for _, tableItem := range schemaItem {
+			tableItem.resize(n)
+		}
+	}
+}
+
+// resize keeps the prepared statements of n workers, closing those of the retired ones.
+func (ait *applierTableItem) resize(n int) {
+	resizeStmts := func(stmts []*gosql.Stmt) []*gosql.Stmt {
+		for i := n; i < len(stmts); i++ {
+			if stmts[i] != nil {
+				stmts[i].Close()
+			}
+		}
+		if n <= len(stmts) {
+			return stmts[:n]
+		}
+		return append(stmts, make([]*gosql.Stmt, n-len(stmts))...)
+	}
+	ait.psInsert = resizeStmts(ait.psInsert)
+	ait.psDelete = resizeStmts(ait.psDelete)
+	ait.psUpdate = resizeStmts(ait.psUpdate)
+
+	for i := n; i < len(ait.psImage); i++ {
+		for _, stmt := range ait.psImage[i] {
+			if stmt != nil {
+				stmt.Close()
+			}
+		}
+	}
+	if n <= len(ait.psImage) {
+		ait.psImage = ait.psImage[:n]
+		return
+	}
+	for len(ait.psImage) < n {
+		ait.psImage = append(ait.psImage, make(map[string]*gosql.Stmt))
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// applyLatencyBuckets are the upper bounds (in seconds) of the buckets of the apply latency.
+var applyLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyHistogram is the apply latency of a table. counts and exemplars are by bucket, then +Inf.
+type latencyHistogram struct {
+	counts    []uint64
+	count     uint64
+	sum       float64
+	exemplars []*models.LatencyExemplar
+}
+
+// latencyTracker keeps the apply latency of the tables, for ApplyLatencyExemplars. Each bucket
+// keeps the slowest transaction observed in it until the next report, so the memory is bounded by
+// the tables, not by the transactions.
+type latencyTracker struct {
+	lock   sync.Mutex
+	tables map[string]*latencyHistogram
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{tables: make(map[string]*latencyHistogram)}
+}
+
+// observe records that the transaction gtid changing the tables ("schema.table") was applied in d.
+func (t *latencyTracker) observe(tables []string, d time.Duration, gtid string, now time.Time) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(applyLatencyBuckets, seconds)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, table := range tables {
+		h, ok := t.tables[table]
+		if !ok {
+			h = &latencyHistogram{
+				counts:    make([]uint64, len(applyLatencyBuckets)+1),
+				exemplars: make([]*models.LatencyExemplar, len(applyLatencyBuckets)+1),
+			}
+			t.tables[table] = h
+		}
+		h.counts[i]++
+		h.count++
+		h.sum += seconds
+		if ex := h.exemplars[i]; ex == nil || seconds > ex.Value {
+			h.exemplars[i] = &models.LatencyExemplar{Gtid: gtid, Value: seconds, Timestamp: now.UnixNano()}
+		}
+	}
+}
+
+// report returns the histograms of the tables, sorted by table, and takes their exemplars: each
+// is reported once.
+func (t *latencyTracker) report() []*models.LatencyHistogram {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	histograms := make([]*models.LatencyHistogram, 0, len(t.tables))
+	for table, h := range t.tables {
+		counts := make([]uint64, len(h.counts))
+		var cumulative uint64
+		for i, n := range h.counts {
+			cumulative += n
+			counts[i] = cumulative
+		}
+		histograms = append(histograms, &models.LatencyHistogram{
+			Table:     table,
+			Buckets:   applyLatencyBuckets,
+			Counts:    counts,
+			Count:     h.count,
+			Sum:       h.sum,
+			Exemplars: h.exemplars,
+		})
+		h.exemplars = make([]*models.LatencyExemplar, len(h.exemplars))
+	}
+	sort.Slice(histograms, func(i, j int) bool {
+		return histograms[i].Table < histograms[j].Table
+	})
+	return histograms
+}
+
+// observeApplyLatency records the latency of the transactions applied together in a target
+// transaction, as the last of them, with ApplyLatencyExemplars.
+func (a *Applier) observeApplyLatency(binlogEntries []*binlog.BinlogEntry, tables []string, d time.Duration) {
+	if a.latencyTracker == nil || len(binlogEntries) == 0 || len(tables) == 0 {
+		return
+	}
+	last := binlogEntries[len(binlogEntries)-1]
+	a.latencyTracker.observe(tables, d, last.Coordinates.GetGtidForThisTx(), time.Now())
+}
+
+// ApplyLatency returns the apply latency of the tables, with ApplyLatencyExemplars. See
+// driver.LatencyReporter.
+func (a *Applier) ApplyLatency() []*models.LatencyHistogram {
+	if a.latencyTracker == nil {
+		return nil
+	}
+	return a.latencyTracker.report()
+}
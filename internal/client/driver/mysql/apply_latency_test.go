@@ -0,0 +1,53 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_latencyTracker(t *testing.T) {
+	tr := newLatencyTracker()
+	now := time.Now()
+	tr.observe([]string{"db1.t1", "db1.t2"}, 3*time.Millisecond, "uuid:1", now)
+	tr.observe([]string{"db1.t1"}, 4*time.Millisecond, "uuid:2", now)
+	tr.observe([]string{"db1.t1"}, 2*time.Millisecond, "uuid:3", now)
+	tr.observe([]string{"db1.t1"}, 20*time.Second, "uuid:4", now)
+
+	report := tr.report()
+	if len(report) != 2 || report[0].Table != "db1.t1" || report[1].Table != "db1.t2" {
+		t.Fatalf("report() = %v, want db1.t1 and db1.t2", report)
+	}
+	h := report[0]
+	// 3ms and 4ms and 2ms in the bucket of 0.005, 20s in +Inf
+	inf := len(applyLatencyBuckets)
+	if h.Count != 4 || h.Counts[0] != 0 || h.Counts[1] != 3 || h.Counts[inf-1] != 3 || h.Counts[inf] != 4 {
+		t.Errorf("counts %v of %v, want 3 within 0.005, 4 in all", h.Counts, h.Count)
+	}
+	if ex := h.Exemplars[1]; ex == nil || ex.Gtid != "uuid:2" || ex.Value != 0.004 {
+		t.Errorf("exemplar of 0.005 = %+v, want the slowest, uuid:2", ex)
+	}
+	if ex := h.Exemplars[inf]; ex == nil || ex.Gtid != "uuid:4" {
+		t.Errorf("exemplar of +Inf = %+v, want uuid:4", ex)
+	}
+	if h.Exemplars[0] != nil {
+		t.Errorf("exemplar of an empty bucket = %+v", h.Exemplars[0])
+	}
+
+	// the exemplars are reported once, the counts are kept
+	tr.observe([]string{"db1.t1"}, time.Millisecond, "uuid:5", now)
+	h = tr.report()[0]
+	for i, ex := range h.Exemplars {
+		if ex != nil && (i != 0 || ex.Gtid != "uuid:5") {
+			t.Errorf("exemplar %v = %+v after a report, want uuid:5 of 0.001 only", i, ex)
+		}
+	}
+	if h.Count != 5 {
+		t.Errorf("count %v after a report, want 5", h.Count)
+	}
+}
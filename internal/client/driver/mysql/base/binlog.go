@@ -34,6 +34,8 @@ type BinlogCoordinateTx struct {
 	GNO           int64
 	LastCommitted int64
 	SeqenceNumber int64
+	// Timestamp of the GTID event on the source, in seconds
+	Timestamp uint32
 }
 
 // Do not call this frequently. Cache your result.
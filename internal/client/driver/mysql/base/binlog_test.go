@@ -49,40 +49,11 @@ func TestBinlogCoordinatesAsKey(t *testing.T) {
 	test.S(t).ExpectEquals(len(m), 3)
 }
 
-func TestBinlogCoordinates_DisplayString(t *testing.T) {
-	type fields struct {
-		LogFile string
-		LogPos  int64
-		GtidSet string
-		Type    BinlogType
-	}
-	tests := []struct {
-		name   string
-		fields fields
-		want   string
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &BinlogCoordinateTx{
-				LogFile: tt.fields.LogFile,
-				LogPos:  tt.fields.LogPos,
-				GtidSet: tt.fields.GtidSet,
-			}
-			if got := b.DisplayString(); got != tt.want {
-				t.Errorf("BinlogCoordinates.DisplayString() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestBinlogCoordinates_String(t *testing.T) {
 	type fields struct {
 		LogFile string
 		LogPos  int64
 		GtidSet string
-		Type    BinlogType
 	}
 	tests := []struct {
 		name   string
@@ -93,13 +64,13 @@ func TestBinlogCoordinates_String(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			b := BinlogCoordinateTx{
+			b := BinlogCoordinatesX{
 				LogFile: tt.fields.LogFile,
 				LogPos:  tt.fields.LogPos,
 				GtidSet: tt.fields.GtidSet,
 			}
 			if got := b.String(); got != tt.want {
-				t.Errorf("BinlogCoordinates.String() = %v, want %v", got, tt.want)
+				t.Errorf("BinlogCoordinatesX.String() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -109,8 +80,6 @@ func TestBinlogCoordinates_Equals(t *testing.T) {
 	type fields struct {
 		LogFile string
 		LogPos  int64
-		GtidSet string
-		Type    BinlogType
 	}
 	type args struct {
 		other *BinlogCoordinateTx
@@ -128,7 +97,6 @@ func TestBinlogCoordinates_Equals(t *testing.T) {
 			b := &BinlogCoordinateTx{
 				LogFile: tt.fields.LogFile,
 				LogPos:  tt.fields.LogPos,
-				GtidSet: tt.fields.GtidSet,
 			}
 			if got := b.Equals(tt.args.other); got != tt.want {
 				t.Errorf("BinlogCoordinates.Equals() = %v, want %v", got, tt.want)
@@ -142,7 +110,6 @@ func TestBinlogCoordinates_IsEmpty(t *testing.T) {
 		LogFile string
 		LogPos  int64
 		GtidSet string
-		Type    BinlogType
 	}
 	tests := []struct {
 		name   string
@@ -153,13 +120,13 @@ func TestBinlogCoordinates_IsEmpty(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			b := &BinlogCoordinateTx{
+			b := &BinlogCoordinatesX{
 				LogFile: tt.fields.LogFile,
 				LogPos:  tt.fields.LogPos,
 				GtidSet: tt.fields.GtidSet,
 			}
 			if got := b.IsEmpty(); got != tt.want {
-				t.Errorf("BinlogCoordinates.IsEmpty() = %v, want %v", got, tt.want)
+				t.Errorf("BinlogCoordinatesX.IsEmpty() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -169,8 +136,6 @@ func TestBinlogCoordinates_SmallerThan(t *testing.T) {
 	type fields struct {
 		LogFile string
 		LogPos  int64
-		GtidSet string
-		Type    BinlogType
 	}
 	type args struct {
 		other *BinlogCoordinateTx
@@ -188,7 +153,6 @@ func TestBinlogCoordinates_SmallerThan(t *testing.T) {
 			b := &BinlogCoordinateTx{
 				LogFile: tt.fields.LogFile,
 				LogPos:  tt.fields.LogPos,
-				GtidSet: tt.fields.GtidSet,
 			}
 			if got := b.SmallerThan(tt.args.other); got != tt.want {
 				t.Errorf("BinlogCoordinates.SmallerThan() = %v, want %v", got, tt.want)
@@ -201,8 +165,6 @@ func TestBinlogCoordinates_SmallerThanOrEquals(t *testing.T) {
 	type fields struct {
 		LogFile string
 		LogPos  int64
-		GtidSet string
-		Type    BinlogType
 	}
 	type args struct {
 		other *BinlogCoordinateTx
@@ -220,7 +182,6 @@ func TestBinlogCoordinates_SmallerThanOrEquals(t *testing.T) {
 			b := &BinlogCoordinateTx{
 				LogFile: tt.fields.LogFile,
 				LogPos:  tt.fields.LogPos,
-				GtidSet: tt.fields.GtidSet,
 			}
 			if got := b.SmallerThanOrEquals(tt.args.other); got != tt.want {
 				t.Errorf("BinlogCoordinates.SmallerThanOrEquals() = %v, want %v", got, tt.want)
@@ -27,6 +27,7 @@ import (
 
 	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/gtid"
 )
 
 var (
@@ -84,6 +85,106 @@ func ParseBinlogCoordinatesFromRows(rows *sql.Rows) (selfBinlogCoordinates *Binl
 	return selfBinlogCoordinates, err
 }
 
+// ReplicationChannelStatus is the replica status of a replication channel (multi-source replication).
+type ReplicationChannelStatus struct {
+	Channel            string
+	MasterUUID         string
+	RelayMasterLogFile string
+	ExecMasterLogPos   int64
+	RetrievedGtidSet   string
+	ExecutedGtidSet    string
+}
+
+// GetReplicationChannelStatus reads `show slave status` of the channel.
+// It returns an error if the channel does not exist.
+func GetReplicationChannelStatus(db usql.QueryAble, channel string) (status *ReplicationChannelStatus, err error) {
+	if strings.ContainsAny(channel, `'\`) {
+		return nil, fmt.Errorf("bad replication channel name: %v", channel)
+	}
+	query := fmt.Sprintf(`show slave status for channel '%s'`, channel)
+	err = usql.QueryRowsMap(db, query, func(m usql.RowMap) error {
+		status = &ReplicationChannelStatus{
+			Channel:            channel,
+			MasterUUID:         m.GetString("Master_UUID"),
+			RelayMasterLogFile: m.GetString("Relay_Master_Log_File"),
+			ExecMasterLogPos:   m.GetInt64("Exec_Master_Log_Pos"),
+			RetrievedGtidSet:   m.GetString("Retrieved_Gtid_Set"),
+			ExecutedGtidSet:    m.GetString("Executed_Gtid_Set"),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("replication channel '%v' does not exist on source", channel)
+	}
+	return status, nil
+}
+
+// GetReplicaLag reads Seconds_Behind_Master of `show slave status`, the max of all channels.
+// It is 0 if the server is not a replica, and -1 if the replication of any channel is stopped.
+func GetReplicaLag(db usql.QueryAble) (lag int64, err error) {
+	err = usql.QueryRowsMap(db, `show slave status`, func(m usql.RowMap) error {
+		seconds := m.GetNullInt64("Seconds_Behind_Master")
+		if !seconds.Valid {
+			lag = -1
+		} else if lag >= 0 && seconds.Int64 > lag {
+			lag = seconds.Int64
+		}
+		return nil
+	})
+	return lag, err
+}
+
+// ReplicaStatus is the replica status of a server, of its first replication channel but for Lag.
+type ReplicaStatus struct {
+	// Seconds_Behind_Master, the max of all channels. -1 if the replication of any channel is stopped
+	Lag                int64
+	MasterLogFile      string
+	ReadMasterLogPos   int64
+	RelayMasterLogFile string
+	ExecMasterLogPos   int64
+}
+
+// GetReplicaStatus reads `show slave status`. It returns nil if the server is not a replica.
+func GetReplicaStatus(db usql.QueryAble) (status *ReplicaStatus, err error) {
+	err = usql.QueryRowsMap(db, `show slave status`, func(m usql.RowMap) error {
+		if status == nil {
+			status = &ReplicaStatus{
+				MasterLogFile:      m.GetString("Master_Log_File"),
+				ReadMasterLogPos:   m.GetInt64("Read_Master_Log_Pos"),
+				RelayMasterLogFile: m.GetString("Relay_Master_Log_File"),
+				ExecMasterLogPos:   m.GetInt64("Exec_Master_Log_Pos"),
+			}
+		}
+		seconds := m.GetNullInt64("Seconds_Behind_Master")
+		if !seconds.Valid {
+			status.Lag = -1
+		} else if status.Lag >= 0 && seconds.Int64 > status.Lag {
+			status.Lag = seconds.Int64
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Sids returns the server uuids of the transactions received from the channel.
+func (s *ReplicationChannelStatus) Sids() (map[string]bool, error) {
+	gtidSet, err := gtid.Parse(s.RetrievedGtidSet)
+	if err != nil {
+		return nil, err
+	}
+	sids := make(map[string]bool)
+	for sid := range gtidSet {
+		sids[sid.String()] = true
+	}
+	if s.MasterUUID != "" {
+		sids[strings.ToLower(s.MasterUUID)] = true
+	}
+	return sids, nil
+}
+
 // GetTableColumns reads column list from given table
 func GetTableColumns(db usql.QueryAble, databaseName, tableName string) (*umconf.ColumnList, error) {
 	query := fmt.Sprintf(`
@@ -115,6 +216,29 @@ func GetTableColumns(db usql.QueryAble, databaseName, tableName string) (*umconf
 	return umconf.NewColumnList(columns), nil
 }
 
+// GetColumnCharsets reads the charsets of the string columns of a table, by column name.
+func GetColumnCharsets(db usql.QueryAble, databaseName, tableName string) (map[string]string, error) {
+	query := `
+		select
+				column_name as name, character_set_name as charset
+			from
+				information_schema.columns
+			where
+				table_schema=?
+				and table_name=?
+				and character_set_name is not null
+		`
+	charsets := make(map[string]string)
+	err := usql.QueryRowsMap(db, query, func(m usql.RowMap) error {
+		charsets[m.GetString("name")] = m.GetString("charset")
+		return nil
+	}, databaseName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return charsets, nil
+}
+
 func ShowCreateTable(db *gosql.DB, databaseName, tableName string, dropTableIfExists bool, addUse bool) (statement []string, err error) {
 	var dummy, createTableStatement string
 	query := fmt.Sprintf(`show create table %s.%s`, usql.EscapeName(databaseName), usql.EscapeName(tableName))
@@ -400,60 +524,27 @@ func ApplyColumnTypes(db usql.QueryAble, databaseName, tableName string, columns
 	return err
 }
 
+// GtidSetDiff returns the transactions of set1 before set2, which has a single GNO for each
+// server uuid, as GtidStart.
 func GtidSetDiff(set1 string, set2 string) (string, error) {
-	gStartHelper, err := gomysql.ParseMysqlGTIDSet(set2)
+	start, err := gtid.Parse(set2)
 	if err != nil {
 		return "", err
 	}
-
-	gStart, ok := gStartHelper.(*gomysql.MysqlGTIDSet)
-	if !ok {
-		return "", fmt.Errorf("internal error: cannot cast MysqlGTIDSet")
-	}
-
-	gExecutedHelper, err := gomysql.ParseMysqlGTIDSet(set1)
+	executed, err := gtid.Parse(set1)
 	if err != nil {
 		return "", err
 	}
 
-	gExecuted, ok := gExecutedHelper.(*gomysql.MysqlGTIDSet)
-	if !ok {
-		return "", fmt.Errorf("internal error: cannot cast MysqlGTIDSet")
-	}
-
-	for sid, startSet := range gStart.Sets {
-		// one for each UUID
-		if startSet.Intervals.Len() != 1 {
-			return "", fmt.Errorf("bad format for GtidStart")
-		}
+	after := make(gtid.Set)
+	for sid, intervals := range start {
 		// only start
-		if startSet.Intervals[0].Start+1 != startSet.Intervals[0].Stop {
+		if len(intervals) != 1 || intervals[0].Start+1 != intervals[0].Stop {
 			return "", fmt.Errorf("bad format for GtidStart")
 		}
-
-		startPoint := startSet.Intervals[0].Start
-		execSets, ok := gExecuted.Sets[sid]
-		if !ok {
-			// do nothing
-		} else {
-			newIntervals := gomysql.IntervalSlice{}
-			for i, _ := range execSets.Intervals {
-				if execSets.Intervals[i].Start >= startPoint {
-					continue
-				} else if execSets.Intervals[i].Stop >= startPoint {
-					newIntervals = append(newIntervals, gomysql.Interval{
-						Start: execSets.Intervals[i].Start,
-						Stop:  startPoint,
-					})
-				} else {
-					newIntervals = append(newIntervals, execSets.Intervals[i])
-				}
-			}
-			execSets.Intervals = newIntervals
-		}
+		after[sid] = []gtid.Interval{{Start: intervals[0].Start, Stop: gtid.MaxGNO + 1}}
 	}
-
-	return gExecuted.String(), nil
+	return executed.Subtract(after).String(), nil
 }
 
 func GetTableColumnsSqle(sqleContext *sqle.Context, schema string, table string) (*umconf.ColumnList, error) {
@@ -471,6 +562,14 @@ func GetTableColumnsSqle(sqleContext *sqle.Context, schema string, table string)
 
 	pks, _ := sqle.GetPrimaryKey(cStmt)
 
+	// the charset of the string columns without one
+	tableCharset := ""
+	for _, opt := range cStmt.Options {
+		if opt.Tp == ast.TableOptionCharset {
+			tableCharset = opt.StrValue
+		}
+	}
+
 	for _, col := range cStmt.Cols {
 		newColumn := umconf.Column{
 			Name:     col.Name.String(),
@@ -486,6 +585,17 @@ func GetTableColumnsSqle(sqleContext *sqle.Context, schema string, table string)
 
 		newColumn.ColumnType = col.Tp.String()
 
+		switch col.Tp.Tp {
+		case parsermysql.TypeString, parsermysql.TypeVarchar, parsermysql.TypeVarString,
+			parsermysql.TypeTinyBlob, parsermysql.TypeBlob, parsermysql.TypeMediumBlob, parsermysql.TypeLongBlob,
+			parsermysql.TypeEnum, parsermysql.TypeSet:
+			// blobs are of charset binary
+			newColumn.Charset = col.Tp.Charset
+			if newColumn.Charset == "" {
+				newColumn.Charset = tableCharset
+			}
+		}
+
 		switch col.Tp.Tp {
 		case parsermysql.TypeDecimal, parsermysql.TypeNewDecimal:
 			newColumn.Type = umconf.DecimalColumnType
@@ -592,8 +702,6 @@ func GetTableColumnsSqle(sqleContext *sqle.Context, schema string, table string)
 		}
 	}
 
-	r := umconf.NewColumnList(columns)
-	//r.SetCharset() // TODO
-	return r, nil
+	return umconf.NewColumnList(columns), nil
 }
 
@@ -11,11 +11,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
-	umconf "github.com/actiontech/dtle/internal/config/mysql"
-
-	"fmt"
-	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	test "github.com/outbrain/golib/tests"
 	gomysql "github.com/siddontang/go-mysql/mysql"
 )
@@ -56,10 +53,6 @@ func TestGetSelfBinlogCoordinates(t *testing.T) {
 	type args struct {
 		db *gosql.DB
 	}
-	db, err := sql.CreateDB(fmt.Sprintf("root:rootroot@tcp(192.168.99.100:13307)/?timeout=5s&tls=false&autocommit=true&charset=utf8mb4,utf8,latin1&multiStatements=true"))
-	if err != nil {
-		return
-	}
 	tests := []struct {
 		name                      string
 		args                      args
@@ -67,7 +60,6 @@ func TestGetSelfBinlogCoordinates(t *testing.T) {
 		wantErr                   bool
 	}{
 		// TODO: Add test cases.
-		{"T1", args{db}, nil, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -146,23 +138,24 @@ func TestShowCreateTable(t *testing.T) {
 		databaseName      string
 		tableName         string
 		dropTableIfExists bool
+		addUse            bool
 	}
 	tests := []struct {
 		name                     string
 		args                     args
-		wantCreateTableStatement string
+		wantCreateTableStatement []string
 		wantErr                  bool
 	}{
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotCreateTableStatement, err := ShowCreateTable(tt.args.db, tt.args.databaseName, tt.args.tableName, tt.args.dropTableIfExists)
+			gotCreateTableStatement, err := ShowCreateTable(tt.args.db, tt.args.databaseName, tt.args.tableName, tt.args.dropTableIfExists, tt.args.addUse)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ShowCreateTable() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if gotCreateTableStatement != tt.wantCreateTableStatement {
+			if !reflect.DeepEqual(gotCreateTableStatement, tt.wantCreateTableStatement) {
 				t.Errorf("ShowCreateTable() = %v, want %v", gotCreateTableStatement, tt.wantCreateTableStatement)
 			}
 		})
@@ -179,8 +172,7 @@ func Test_parseInterval(t *testing.T) {
 		wantI   gomysql.Interval
 		wantErr bool
 	}{
-		// TODO: Add test cases.
-		{"t1", args{"36671-36677"}, gomysql.Interval{}, false},
+		{"t1", args{"36671-36677"}, gomysql.Interval{Start: 36671, Stop: 36678}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -253,3 +245,64 @@ func Test_stringInterval(t *testing.T) {
 		})
 	}
 }
+
+func TestReplicationChannelStatus_Sids(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  *ReplicationChannelStatus
+		want    map[string]bool
+		wantErr bool
+	}{
+		{"master-uuid-only", &ReplicationChannelStatus{MasterUUID: "3E11FA47-71CA-11E1-9E33-C80AA9429562"},
+			map[string]bool{"3e11fa47-71ca-11e1-9e33-c80aa9429562": true}, false},
+		{"retrieved", &ReplicationChannelStatus{
+			MasterUUID:       "3e11fa47-71ca-11e1-9e33-c80aa9429562",
+			RetrievedGtidSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n8ab0a6a2-71ca-11e1-9e33-c80aa9429562:1-3",
+		}, map[string]bool{
+			"3e11fa47-71ca-11e1-9e33-c80aa9429562": true,
+			"8ab0a6a2-71ca-11e1-9e33-c80aa9429562": true,
+		}, false},
+		{"bad-gtid-set", &ReplicationChannelStatus{RetrievedGtidSet: "abc"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.status.Sids()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReplicationChannelStatus.Sids() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReplicationChannelStatus.Sids() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGtidSetDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		executed string
+		start    string
+		want     string
+		wantErr  bool
+	}{
+		{"before start", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:1-100:200:300-400,8888aa16-c8e6-11e7-9ff0-e19f7778f563:1-1000",
+			"113fa2ce-c8e6-11e7-b894-67ad30e6f107:330",
+			"113fa2ce-c8e6-11e7-b894-67ad30e6f107:1-100:200:300-329,\n8888aa16-c8e6-11e7-9ff0-e19f7778f563:1-1000", false},
+		{"nothing before", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:5-10", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:3", "", false},
+		{"not executed", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:5-10", "8888aa16-c8e6-11e7-9ff0-e19f7778f563:3",
+			"113fa2ce-c8e6-11e7-b894-67ad30e6f107:5-10", false},
+		{"start interval", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:5-10", "113fa2ce-c8e6-11e7-b894-67ad30e6f107:3-4", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GtidSetDiff(tt.executed, tt.start)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GtidSetDiff() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GtidSetDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
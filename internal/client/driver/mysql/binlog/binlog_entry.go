@@ -23,6 +23,14 @@ type BinlogEntry struct {
 
 	Events       []DataEvent
 	OriginalSize int // size of binlog entry
+
+	// Xid and XaOp are set for the parts of an XA transaction applied as XA on the target:
+	// XaOpPrepare, XaOpCommit or XaOpRollback. See xa.go.
+	Xid  string
+	XaOp string
+	// XaPrepared is the coordinates of the prepare part of the XA transaction committed or
+	// rolled back by the entry. Its GTID is applied with the entry.
+	XaPrepared *base.BinlogCoordinateTx
 }
 
 // NewBinlogEntry creates an empty, ready to go BinlogEntry object
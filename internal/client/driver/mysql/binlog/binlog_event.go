@@ -9,6 +9,7 @@ package binlog
 import (
 	"fmt"
 
+	gomysql "github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
 
 	"github.com/actiontech/dtle/internal/config"
@@ -25,6 +26,58 @@ const (
 	DeleteDML          = "Delete"
 )
 
+const (
+	RowImageFull    = "FULL"
+	RowImageMinimal = "MINIMAL"
+	RowImageNoBlob  = "NOBLOB"
+)
+
+func isBlobType(tp byte) bool {
+	switch tp {
+	case gomysql.MYSQL_TYPE_TINY_BLOB, gomysql.MYSQL_TYPE_MEDIUM_BLOB, gomysql.MYSQL_TYPE_LONG_BLOB,
+		gomysql.MYSQL_TYPE_BLOB, gomysql.MYSQL_TYPE_JSON, gomysql.MYSQL_TYPE_GEOMETRY:
+		return true
+	default:
+		return false
+	}
+}
+
+// DetectRowImage tells the binlog_row_image of a rows event from its included-columns bitmaps.
+// A column absent from a bitmap is decoded as nil, just like a NULL value, so the bitmaps
+// are the only way to tell them apart.
+func DetectRowImage(rowsEvent *replication.RowsEvent) string {
+	result := RowImageFull
+	check := func(bitmap []byte) {
+		for i := 0; i < int(rowsEvent.ColumnCount); i++ {
+			if ColumnPresent(bitmap, i) {
+				continue
+			}
+			if rowsEvent.Table != nil && i < len(rowsEvent.Table.ColumnType) && isBlobType(rowsEvent.Table.ColumnType[i]) {
+				if result == RowImageFull {
+					result = RowImageNoBlob
+				}
+			} else {
+				result = RowImageMinimal
+			}
+		}
+	}
+	check(rowsEvent.ColumnBitmap1)
+	check(rowsEvent.ColumnBitmap2)
+	return result
+}
+
+// ColumnPresent reports whether the i-th column is included in the bitmap.
+// A nil bitmap means a full image.
+func ColumnPresent(bitmap []byte, i int) bool {
+	if bitmap == nil {
+		return true
+	}
+	if i>>3 >= len(bitmap) {
+		return false
+	}
+	return bitmap[i>>3]&(1<<(uint(i)&7)) > 0
+}
+
 func ToEventDML(eventType replication.EventType) EventDML {
 	switch eventType {
 	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
@@ -87,6 +140,11 @@ type DataEvent struct {
 	Table             *config.Table // TODO tmp solution
 	LogPos            int64         // for kafka. The pos of WRITE_ROW_EVENT
 	TableItem         interface{}
+	// RowImage is detected from the column bitmaps of the rows event.
+	// The bitmaps are only kept for a non-FULL image.
+	RowImage          string
+	WhereColumnBitmap []byte
+	NewColumnBitmap   []byte
 }
 
 func NewDataEvent(databaseName, tableName string, dml EventDML, columnCount int) DataEvent {
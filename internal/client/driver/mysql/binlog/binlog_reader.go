@@ -16,9 +16,9 @@ import (
 	//"encoding/hex"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	//"os"
 
@@ -35,15 +35,21 @@ import (
 	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
-	"github.com/actiontech/dtle/internal/client/driver/mysql/util"
 	"github.com/actiontech/dtle/internal/config"
 	"github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/faults"
+	"github.com/actiontech/dtle/internal/gtid"
 
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/socks5"
 	"github.com/actiontech/dtle/utils"
 )
 
+// binlogMissedHeartbeats is how many heartbeats the binlog connection may miss before it is
+// reconnected.
+const binlogMissedHeartbeats = 2
+
 // BinlogReader is a general interface whose implementations can choose their methods of reading
 // a binary log file and parsing it into binlog entries
 type BinlogReader struct {
@@ -74,9 +80,50 @@ type BinlogReader struct {
 	shutdownCh   chan struct{}
 	shutdownLock sync.Mutex
 
-	sqlFilter *SqlFilter
+	sqlFilter   *SqlFilter
+	eventFilter *eventFilter
+	// server_ids of SkipServerIds
+	skipServerIds map[uint32]bool
+	// the ROWS_QUERY event of the current transaction, for EventFilters
+	currentRowsQuery string
+	// the prepare parts of the XA transactions not committed yet, by xid. See xa.go
+	xaPrepared map[string]*BinlogEntry
 
 	context *sqle.Context
+
+	// binlog_row_image detected from the last rows event
+	rowImage     string
+	rowImageLock sync.Mutex
+
+	// OnFakeRotate is called on each fake rotate event, which the source sends when the streamer
+	// connects or reconnects, and when it opens the next binlog file, with the server_id of the
+	// source. An error stops the streaming.
+	OnFakeRotate func(serverId uint32) error
+	// unix seconds of the last heartbeat, and of the last event or heartbeat, received.
+	// accessed atomically
+	lastHeartbeatAt int64
+	lastEventAt     int64
+
+	// the GTID set the streamer resumes from on a reconnect: the one it started from, and the
+	// transactions read since. nil if it is not parsed
+	readGtidSet gtid.Set
+
+	// forwards the binlog connection through the SOCKS5 proxy, if any
+	socks5Forwarder *socks5.Forwarder
+
+	// the column types of the last TABLE_MAP accepted for each table, by "schema.table". See
+	// revalidateTableMeta
+	tableLayouts map[string]string
+	// fetches the columns of a table from the source: fetchTableColumns, set with the connection
+	// to the source in NewMySQLReader. nil without a connection, e.g. in tests
+	loadTableColumns func(schemaName, tableName string) (*mysql.ColumnList, error)
+
+	// parses the events of the TRANSACTION_PAYLOAD_EVENTs. See payload.go
+	payloadParser *replication.BinlogParser
+	// the TRANSACTION_PAYLOAD_EVENTs read, and their sizes. accessed atomically
+	compressedPayloads int64
+	compressedBytes    int64
+	uncompressedBytes  int64
 }
 
 type SqlFilter struct {
@@ -137,11 +184,17 @@ func parseSqlFilter(strs []string) (*SqlFilter, error) {
 	return s, nil
 }
 
-func NewMySQLReader(cfg *config.MySQLDriverConfig, logger *log.Entry, replicateDoDb []*config.DataSource, sqleContext *sqle.Context) (binlogReader *BinlogReader, err error) {
+// NewMySQLReader creates a reader of the binlog of the source, as a replica with serverId.
+func NewMySQLReader(cfg *config.MySQLDriverConfig, logger *log.Entry, replicateDoDb []*config.DataSource, sqleContext *sqle.Context,
+	serverId uint32) (binlogReader *BinlogReader, err error) {
 	sqlFilter, err := parseSqlFilter(cfg.SqlFilter)
 	if err != nil {
 		return nil, err
 	}
+	eventFilter, err := newEventFilter(cfg.EventFilters)
+	if err != nil {
+		return nil, err
+	}
 
 	binlogReader = &BinlogReader{
 		logger:                  logger,
@@ -153,8 +206,13 @@ func NewMySQLReader(cfg *config.MySQLDriverConfig, logger *log.Entry, replicateD
 		shutdownCh:              make(chan struct{}),
 		tables:                  make(map[string](map[string]*config.TableContext)),
 		sqlFilter:               sqlFilter,
+		eventFilter:             eventFilter,
+		skipServerIds:           make(map[uint32]bool),
 		context:                 sqleContext,
 	}
+	for _, serverId := range cfg.SkipServerIds {
+		binlogReader.skipServerIds[serverId] = true
+	}
 
 	for _, db := range replicateDoDb {
 		tableMap := binlogReader.getDbTableMap(db.TableSchema)
@@ -166,40 +224,48 @@ func NewMySQLReader(cfg *config.MySQLDriverConfig, logger *log.Entry, replicateD
 	}
 
 	uri := cfg.ConnectionConfig.GetDBUri()
-	if binlogReader.db, err = sql.CreateDB(uri); err != nil {
+	if binlogReader.db, err = sql.CreateDB(uri, cfg.InitSQL...); err != nil {
 		return nil, err
 	}
+	binlogReader.loadTableColumns = binlogReader.fetchTableColumns
 
-	id, err := util.NewIdWorker(2, 3, util.SnsEpoch)
-	if err != nil {
-		return nil, err
-	}
-	sid, err := id.NextId()
-	if err != nil {
-		return nil, err
-	}
-	bid := []byte(strconv.FormatUint(uint64(sid), 10))
-	serverId, err := strconv.ParseUint(string(bid), 10, 32)
-	if err != nil {
-		return nil, err
-	}
-	logger.Debug("job.start: debug server id is :", serverId)
+	logger.Debugf("mysql.reader: server_id of the replica: %v", serverId)
 	// support regex
 	binlogReader.genRegexMap()
 
+	host, port := cfg.ConnectionConfig.Host, cfg.ConnectionConfig.Port
+	if cfg.ConnectionConfig.Socks5Proxy != "" {
+		// The syncer cannot be given a dialer. It connects to a local forwarder instead,
+		// which dials through the proxy for each connection, including the reconnections.
+		d, err := socks5.Parse(cfg.ConnectionConfig.Socks5Proxy)
+		if err != nil {
+			return nil, err
+		}
+		binlogReader.socks5Forwarder, err = socks5.Forward(d, cfg.ConnectionConfig.Addr())
+		if err != nil {
+			return nil, err
+		}
+		host, port = binlogReader.socks5Forwarder.Addr()
+		logger.Infof("mysql.reader: streaming binlog through SOCKS5 proxy %v", d.Addr)
+	}
+
 	binlogSyncerConfig := replication.BinlogSyncerConfig{
-		ServerID:       uint32(serverId),
+		ServerID:       serverId,
 		Flavor:         "mysql",
-		Host:           cfg.ConnectionConfig.Host,
-		Port:           uint16(cfg.ConnectionConfig.Port),
+		Host:           host,
+		Port:           uint16(port),
 		User:           cfg.ConnectionConfig.User,
 		Password:       cfg.ConnectionConfig.Password,
 		RawModeEnabled: false,
 		UseDecimal:     true,
 
 		MaxReconnectAttempts: 3,
-		HeartbeatPeriod:      3 * time.Second,
-		ReadTimeout:          6 * time.Second,
+	}
+	if cfg.BinlogHeartbeatPeriod > 0 {
+		// A connection silently dropped, e.g. by a firewall on a long idle source, misses the
+		// heartbeats. The read times out, and the syncer reconnects.
+		binlogSyncerConfig.HeartbeatPeriod = time.Duration(cfg.BinlogHeartbeatPeriod) * time.Second
+		binlogSyncerConfig.ReadTimeout = binlogMissedHeartbeats * binlogSyncerConfig.HeartbeatPeriod
 	}
 	binlogReader.binlogSyncer = replication.NewBinlogSyncer(binlogSyncerConfig)
 	binlogReader.mysqlContext.Stage = models.StageRegisteringSlaveOnMaster
@@ -249,6 +315,9 @@ func (b *BinlogReader) ConnectBinlogStreamer(coordinates base.BinlogCoordinatesX
 	if err != nil {
 		b.logger.Errorf("mysql.reader: err: %v", err)
 	}
+	if readGtidSet, err := gtid.Parse(coordinates.GtidSet); err == nil {
+		b.readGtidSet = readGtidSet
+	}
 	b.binlogStreamer, err = b.binlogSyncer.StartSyncGTID(gtidSet)
 	if err != nil {
 		b.logger.Debugf("mysql.reader: err at StartSyncGTID: %v", err)
@@ -291,6 +360,17 @@ func ToColumnValuesV2(abstractValues []interface{}, table *config.TableContext)
 					abstractValues[i] = uint64(v)
 				}
 			}
+			if i < len(columns) && columns[i].Charset != "" {
+				// Strings are sent in UTF-8. The applier checks them against the target charsets.
+				switch v := abstractValues[i].(type) {
+				case string:
+					abstractValues[i], _ = mysql.DecodeString(v, columns[i].Charset)
+				case []byte:
+					if decoded, err := mysql.DecodeString(string(v), columns[i].Charset); err == nil && decoded != string(v) {
+						abstractValues[i] = decoded
+					}
+				}
+			}
 		}
 		result.AbstractValues[i] = &abstractValues[i]
 		result.ValuesPointers[i] = result.AbstractValues[i]
@@ -299,6 +379,27 @@ func ToColumnValuesV2(abstractValues []interface{}, table *config.TableContext)
 	return result
 }
 
+// checkSignedness returns an error if the rows of rowsEvent have an integer value of a column
+// missing from the metadata of table, which ToColumnValuesV2 cannot convert for an UNSIGNED
+// column, unless policy is UnsignedPolicySigned. Without table (replicating all), the values
+// are sent signed and the applier converts them with the target columns.
+func checkSignedness(rowsEvent *replication.RowsEvent, table *config.TableContext, policy string) error {
+	if table == nil || policy == config.UnsignedPolicySigned {
+		return nil
+	}
+	columns := table.Table.OriginalTableColumns.Columns
+	for i := len(columns); i < len(rowsEvent.Table.ColumnType); i++ {
+		switch rowsEvent.Table.ColumnType[i] {
+		case gomysql.MYSQL_TYPE_TINY, gomysql.MYSQL_TYPE_SHORT, gomysql.MYSQL_TYPE_INT24,
+			gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_LONGLONG:
+			return fmt.Errorf("unknown signedness of the integer column %v of %v.%v, which has %v columns in the metadata. "+
+				"set UnsignedPolicy to %v to send its values signed",
+				i, string(rowsEvent.Table.Schema), string(rowsEvent.Table.Table), len(columns), config.UnsignedPolicySigned)
+		}
+	}
+	return nil
+}
+
 // If isDDL, a sql correspond to a table item, aka len(tables) == len(sqls).
 type parseDDLResult struct {
 	isDDL  bool
@@ -331,7 +432,15 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 		b.currentCoordinates.GNO = evt.GNO
 		b.currentCoordinates.LastCommitted = evt.LastCommitted
 		b.currentCoordinates.SeqenceNumber = evt.SequenceNumber
+		b.currentCoordinates.Timestamp = ev.Header.Timestamp
 		b.currentBinlogEntry = NewBinlogEntryAt(b.currentCoordinates)
+		if b.readGtidSet != nil {
+			b.readGtidSet.AddGtid(u, evt.GNO)
+		}
+		b.currentRowsQuery = ""
+	case replication.ROWS_QUERY_EVENT:
+		// precedes the rows events of a statement with binlog_rows_query_log_events=ON
+		b.currentRowsQuery = string(ev.Event.(*replication.RowsQueryEvent).Query)
 	case replication.QUERY_EVENT:
 		evt := ev.Event.(*replication.QueryEvent)
 		query := string(evt.Query)
@@ -343,6 +452,11 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 
 		b.logger.Debugf("mysql.reader: query event: schema: %s, query: %s", evt.Schema, query)
 
+		if stmt, xid, ok := parseXaQuery(query); ok {
+			b.handleXaQuery(stmt, xid, entriesChannel)
+			return nil
+		}
+
 		if strings.ToUpper(query) == "BEGIN" {
 			b.currentBinlogEntry.hasBeginQuery = true
 		} else {
@@ -373,8 +487,8 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 
 				if !ddlInfo.isDDL {
 					event := NewQueryEvent(
-						currentSchema,
-						query,
+						b.mysqlContext.TargetSchema(currentSchema),
+						b.renameSchemas(query),
 						NotDML,
 					)
 					b.currentBinlogEntry.Events = append(b.currentBinlogEntry.Events, event)
@@ -405,13 +519,12 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 						b.logger.Debugf("mysql.reader: Skip QueryEvent currentSchema: %s, sql: %s, realSchema: %v, tableName: %v", currentSchema, sql, realSchema, tableName)
 						return nil
 					}
+					dropped := b.eventFilter.drop(config.EventFilterOpDDL, realSchema, tableName, sql)
 
 					var table *config.Table
-					var schema *config.DataSource
 					for i := range b.mysqlContext.ReplicateDoDb {
 						// TODO escape name before comparing?
 						if b.mysqlContext.ReplicateDoDb[i].TableSchema == realSchema {
-							schema = b.mysqlContext.ReplicateDoDb[i]
 							for j := range b.mysqlContext.ReplicateDoDb[i].Tables {
 								if b.mysqlContext.ReplicateDoDb[i].Tables[j].TableName == tableName {
 									table = b.mysqlContext.ReplicateDoDb[i].Tables[j]
@@ -488,13 +601,13 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 							}
 						}
 					}
-					if schema != nil && schema.TableSchemaRename != "" {
-						ddlInfo.tables[i].Schema = schema.TableSchemaRename
-						b.logger.Debugf("mysql.reader. ddl schema mapping :from  %s to %s", realSchema, schema.TableSchemaRename)
-						//sql = strings.Replace(sql, realSchema, schema.TableSchemaRename, 1)
-						sql = loadMapping(sql, realSchema, schema.TableSchemaRename, "schemaRename", " ")
-						currentSchema = schema.TableSchemaRename
+					if targetSchema := b.mysqlContext.TargetSchema(realSchema); targetSchema != realSchema {
+						ddlInfo.tables[i].Schema = targetSchema
+						b.logger.Debugf("mysql.reader. ddl schema mapping :from  %s to %s", realSchema, targetSchema)
+						currentSchema = targetSchema
 					}
+					// all the schemas of the statement, e.g. of CREATE TABLE ... LIKE or REFERENCES
+					sql = b.renameSchemas(sql)
 
 					if table != nil && table.TableRename != "" {
 						ddlInfo.tables[i].Table = table.TableRename
@@ -503,7 +616,7 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 						b.logger.Debugf("mysql.reader. ddl table mapping  :from %s to %s", tableName, table.TableRename)
 					}
 
-					if skipEvent {
+					if skipEvent || dropped {
 						b.logger.Debugf("mysql.reader. skipped a ddl event. query: %v", query)
 					} else {
 						event := NewQueryEventAffectTable(
@@ -522,6 +635,8 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 	case replication.XID_EVENT:
 		entriesChannel <- b.currentBinlogEntry
 		b.LastAppliedRowsEventHint = b.currentCoordinates
+	case xaPrepareLogEvent:
+		return b.handleXaPrepare(ev, entriesChannel)
 	default:
 		if rowsEvent, ok := ev.Event.(*replication.RowsEvent); ok {
 			dml := ToEventDML(ev.Header.EventType)
@@ -534,6 +649,12 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 			schemaName := string(rowsEvent.Table.Schema)
 			tableName := string(rowsEvent.Table.Table)
 
+			if b.skipServerIds[ev.Header.ServerID] {
+				b.logger.Debugf("mysql.reader. skipped a dml event by SkipServerIds. server_id: %v, table: %v.%v",
+					ev.Header.ServerID, schemaName, tableName)
+				return nil
+			}
+
 			if b.sqlFilter.NoDML ||
 				(b.sqlFilter.NoDMLDelete && dml == DeleteDML) ||
 				(b.sqlFilter.NoDMLInsert && dml == InsertDML) ||
@@ -542,6 +663,10 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 				b.logger.Debugf("mysql.reader. skipped_a_dml_event. type: %v, table: %v.%v", dml, schemaName, tableName)
 				return nil
 			}
+			if b.eventFilter.drop(strings.ToLower(string(dml)), schemaName, tableName, b.currentRowsQuery) {
+				b.logger.Debugf("mysql.reader. dropped a dml event by EventFilters. type: %v, table: %v.%v", dml, schemaName, tableName)
+				return nil
+			}
 
 			if dml == NotDML {
 				return fmt.Errorf("Unknown DML type: %s", ev.Header.EventType.String())
@@ -553,12 +678,24 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 				int(rowsEvent.ColumnCount),
 			)
 			dmlEvent.LogPos = int64(ev.Header.LogPos - ev.Header.EventSize)
+			dmlEvent.RowImage = DetectRowImage(rowsEvent)
+			if dmlEvent.RowImage != RowImageFull {
+				dmlEvent.WhereColumnBitmap, dmlEvent.NewColumnBitmap = b.rowImageBitmaps(rowsEvent, dml)
+			}
+			b.setRowImage(dmlEvent.RowImage)
 
+			if err := b.revalidateTableMeta(rowsEvent.Table, table); err != nil {
+				return err
+			}
 			if table != nil && !table.DefChangedSent {
 				dmlEvent.Table = table.Table
 				table.DefChangedSent = true
 			}
 
+			if err := checkSignedness(rowsEvent, table, b.mysqlContext.UnsignedPolicy); err != nil {
+				return err
+			}
+
 			/*originalTableColumns, _, err := b.InspectTableColumnsAndUniqueKeys(string(rowsEvent.Table.Schema), string(rowsEvent.Table.Table))
 			if err != nil {
 				return err
@@ -628,18 +765,12 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 					dmlEvent.TableName = table.Table.TableRename
 					b.logger.Debugf("mysql.reader. dml  table mapping : from %s to %s", dmlEvent.TableName, table.Table.TableRename)
 				}
-				for _, schema := range b.mysqlContext.ReplicateDoDb {
-					if schema.TableSchema != schemaName {
-						continue
-					}
-					if schema.TableSchemaRename == "" {
-						continue
-					}
+				if targetSchema := b.mysqlContext.TargetSchema(schemaName); targetSchema != schemaName {
 					if dmlEvent.Table != nil {
-						dmlEvent.Table.TableSchemaRename = schema.TableSchemaRename
+						dmlEvent.Table.TableSchemaRename = targetSchema
 					}
-					b.logger.Debugf("mysql.reader. dml  schema mapping: from  %s to %s", dmlEvent.DatabaseName, schema.TableSchemaRename)
-					dmlEvent.DatabaseName = schema.TableSchemaRename
+					b.logger.Debugf("mysql.reader. dml  schema mapping: from  %s to %s", dmlEvent.DatabaseName, targetSchema)
+					dmlEvent.DatabaseName = targetSchema
 				}
 				if whereTrue {
 					// The channel will do the throttling. Whoever is reding from the channel
@@ -657,6 +788,47 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 	return nil
 }
 
+// rowImageBitmaps maps the bitmaps of a rows event onto the before (WHERE) and after (SET) images.
+func (b *BinlogReader) rowImageBitmaps(rowsEvent *replication.RowsEvent, dml EventDML) (whereBitmap []byte, newBitmap []byte) {
+	copyBitmap := func(bitmap []byte) []byte {
+		// bitmaps of a RowsEvent reference the raw event data
+		result := make([]byte, len(bitmap))
+		copy(result, bitmap)
+		return result
+	}
+	switch dml {
+	case InsertDML:
+		return nil, copyBitmap(rowsEvent.ColumnBitmap1)
+	case UpdateDML:
+		return copyBitmap(rowsEvent.ColumnBitmap1), copyBitmap(rowsEvent.ColumnBitmap2)
+	case DeleteDML:
+		return copyBitmap(rowsEvent.ColumnBitmap1), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (b *BinlogReader) setRowImage(rowImage string) {
+	b.rowImageLock.Lock()
+	if b.rowImage != rowImage {
+		b.logger.Infof("mysql.reader: detected binlog row image: %v", rowImage)
+	}
+	b.rowImage = rowImage
+	b.rowImageLock.Unlock()
+}
+
+// EventFilterStats returns the number of events matched by each rule of EventFilters.
+func (b *BinlogReader) EventFilterStats() []*models.EventFilterStat {
+	return b.eventFilter.stats()
+}
+
+// GetRowImage returns the binlog_row_image detected from the last rows event, or "" if none.
+func (b *BinlogReader) GetRowImage() string {
+	b.rowImageLock.Lock()
+	defer b.rowImageLock.Unlock()
+	return b.rowImage
+}
+
 func loadMapping(sql, beforeName, afterName, mappingType, currentSchema string) string {
 	sqlType := strings.Split(sql, " ")[1]
 	newSql := ""
@@ -692,6 +864,11 @@ func loadMapping(sql, beforeName, afterName, mappingType, currentSchema string)
 	return sql
 }
 
+// renameSchemas rewrites the schemas of a DDL to their names on the target.
+func (b *BinlogReader) renameSchemas(query string) string {
+	return sql.RenameSchemas(query, b.mysqlContext.TargetSchema)
+}
+
 // StreamEvents
 func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) error {
 	for {
@@ -700,11 +877,16 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 			break
 		}
 
+		if err := faults.Inject(faults.ExtractorReadEvent); err != nil {
+			b.logger.Errorf("mysql.reader error GetEvent. err: %v", err)
+			return err
+		}
 		ev, err := b.binlogStreamer.GetEvent(context.Background())
 		if err != nil {
 			b.logger.Errorf("mysql.reader error GetEvent. err: %v", err)
 			return err
 		}
+		b.received(ev)
 		if ev.Header.EventType == replication.HEARTBEAT_EVENT {
 			continue
 		}
@@ -716,7 +898,16 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 			b.currentCoordinates.LogPos = int64(ev.Header.LogPos)
 		}()
 
+		if ev.Header.EventType == replication.FORMAT_DESCRIPTION_EVENT {
+			if err := b.resetPayloadParser(ev); err != nil {
+				return err
+			}
+		}
+
 		if ev.Header.EventType == replication.ROTATE_EVENT {
+			if err := b.handleFakeRotate(ev); err != nil {
+				return err
+			}
 			if rotateEvent, ok := ev.Event.(*replication.RotateEvent); ok {
 				func() {
 					b.currentCoordinatesMutex.Lock()
@@ -728,6 +919,10 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 			} else {
 				b.logger.Warnf("mysql.reader: fake rotate_event.")
 			}
+		} else if ev.Header.EventType == transactionPayloadEvent {
+			if err := b.handleTransactionPayload(ev, entriesChannel); err != nil {
+				return err
+			}
 		} else {
 			if err := b.handleEvent(ev, entriesChannel); err != nil {
 				return err
@@ -738,6 +933,44 @@ func (b *BinlogReader) DataStreamEvents(entriesChannel chan<- *BinlogEntry) erro
 	return nil
 }
 
+// received records the time an event is received.
+func (b *BinlogReader) received(ev *replication.BinlogEvent) {
+	now := time.Now().Unix()
+	atomic.StoreInt64(&b.lastEventAt, now)
+	if ev.Header.EventType == replication.HEARTBEAT_EVENT {
+		atomic.StoreInt64(&b.lastHeartbeatAt, now)
+	}
+}
+
+// HeartbeatStat returns when the binlog connection last received a heartbeat and an event.
+func (b *BinlogReader) HeartbeatStat() *models.BinlogHeartbeatStat {
+	stat := &models.BinlogHeartbeatStat{
+		LastHeartbeat: atomic.LoadInt64(&b.lastHeartbeatAt),
+		LastEvent:     atomic.LoadInt64(&b.lastEventAt),
+	}
+	if b.mysqlContext.BinlogHeartbeatPeriod > 0 {
+		stat.Period = int64(b.mysqlContext.BinlogHeartbeatPeriod)
+	}
+	return stat
+}
+
+// handleFakeRotate calls OnFakeRotate if the rotate event is a fake one, which has no timestamp.
+func (b *BinlogReader) handleFakeRotate(ev *replication.BinlogEvent) error {
+	if ev.Header.Timestamp != 0 || b.OnFakeRotate == nil {
+		return nil
+	}
+	return b.OnFakeRotate(ev.Header.ServerID)
+}
+
+// ReadGtidSet returns the GTID set the streamer resumes from on a reconnect, or "" if it is
+// unknown. It is called by OnFakeRotate.
+func (b *BinlogReader) ReadGtidSet() string {
+	if b.readGtidSet == nil {
+		return ""
+	}
+	return b.readGtidSet.String()
+}
+
 func (b *BinlogReader) BinlogStreamEvents(txChannel chan<- *BinlogTx) error {
 	for {
 		// Check for shutdown
@@ -749,6 +982,7 @@ func (b *BinlogReader) BinlogStreamEvents(txChannel chan<- *BinlogTx) error {
 		if err != nil {
 			return err
 		}
+		b.received(ev)
 
 		/*switch ev.Header.EventType {
 		case replication.TABLE_MAP_EVENT:
@@ -805,6 +1039,9 @@ func (b *BinlogReader) BinlogStreamEvents(txChannel chan<- *BinlogTx) error {
 			b.currentCoordinates.LogPos = int64(ev.Header.LogPos)
 		}()
 		if ev.Header.EventType == replication.ROTATE_EVENT {
+			if err := b.handleFakeRotate(ev); err != nil {
+				return err
+			}
 			if rotateEvent, ok := ev.Event.(*replication.RotateEvent); ok {
 				func() {
 					b.currentCoordinatesMutex.Lock()
@@ -940,7 +1177,7 @@ func (b *BinlogReader) handleBinlogRowsEvent(ev *replication.BinlogEvent, txChan
 	case replication.TABLE_MAP_EVENT:
 		evt := ev.Event.(*replication.TableMapEvent)
 
-		if b.skipEvent(string(evt.Schema), string(evt.Table)) {
+		if b.skipEvent(string(evt.Schema), string(evt.Table)) || b.skipServerIds[ev.Header.ServerID] {
 			//b.logger.Debugf("mysql.reader: skip TableMapEvent at schema: %s,table: %s", fmt.Sprintf("%s", evt.Schema), fmt.Sprintf("%s", evt.Table))
 			return nil
 		}
@@ -955,7 +1192,7 @@ func (b *BinlogReader) handleBinlogRowsEvent(ev *replication.BinlogEvent, txChan
 
 	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
 		evt := ev.Event.(*replication.RowsEvent)
-		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) {
+		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) || b.skipServerIds[ev.Header.ServerID] {
 			//b.logger.Debugf("mysql.reader: skip RowsEvent at schema: %s,table: %s", fmt.Sprintf("%s", evt.Table.Schema), fmt.Sprintf("%s", evt.Table.Table))
 			return nil
 		}
@@ -971,7 +1208,7 @@ func (b *BinlogReader) handleBinlogRowsEvent(ev *replication.BinlogEvent, txChan
 
 	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
 		evt := ev.Event.(*replication.RowsEvent)
-		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) {
+		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) || b.skipServerIds[ev.Header.ServerID] {
 			//b.logger.Debugf("mysql.reader: skip RowsEvent at schema: %s,table: %s", fmt.Sprintf("%s", evt.Table.Schema), fmt.Sprintf("%s", evt.Table.Table))
 			return nil
 		}
@@ -987,7 +1224,7 @@ func (b *BinlogReader) handleBinlogRowsEvent(ev *replication.BinlogEvent, txChan
 
 	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
 		evt := ev.Event.(*replication.RowsEvent)
-		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) {
+		if b.skipEvent(string(evt.Table.Schema), string(evt.Table.Table)) || b.skipServerIds[ev.Header.ServerID] {
 			//b.logger.Debugf("mysql.reader: skip RowsEvent at schema: %s,table: %s", fmt.Sprintf("%s", evt.Table.Schema), fmt.Sprintf("%s", evt.Table.Table))
 			return nil
 		}
@@ -1453,6 +1690,9 @@ func (b *BinlogReader) Close() error {
 	// here. A new go-mysql version closes the binlog syncer connection independently.
 	// I will go against the sacred rules of comments and just leave this here.
 	// This is the year 2017. Let's see what year these comments get deleted.
+	if b.socks5Forwarder != nil {
+		b.socks5Forwarder.Close()
+	}
 	return nil
 }
 func (b *BinlogReader) updateTableMeta(table *config.Table, realSchema string, tableName string) error {
@@ -1475,10 +1715,11 @@ func (b *BinlogReader) updateTableMeta(table *config.Table, realSchema string, t
 		table.Where = "true"
 	}
 	table.OriginalTableColumns = columns
+	b.forgetTableLayout(realSchema, tableName)
 	tableMap := b.getDbTableMap(realSchema)
 	err = b.addTableToTableMap(tableMap, table)
 	if err != nil {
-		b.logger.Error("failed to make table context: %v", err)
+		b.logger.Errorf("failed to make table context: %v", err)
 		return err
 	}
 
@@ -9,15 +9,24 @@ package binlog
 import (
 	"bytes"
 	gosql "database/sql"
+	"encoding/binary"
+	"fmt"
+	"os"
 	"reflect"
 	"regexp"
 	"sync"
 	"testing"
+
 	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
 	"github.com/actiontech/dtle/internal/config"
 	"github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/faults"
+	"github.com/actiontech/dtle/internal/gtid"
 	log "github.com/actiontech/dtle/internal/logger"
 
+	"github.com/klauspost/compress/zstd"
+	uuid "github.com/satori/go.uuid"
 	gomysql "github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
 )
@@ -56,8 +65,11 @@ func TestParseMysqlGTIDSet(t *testing.T) {
 
 func TestNewMySQLReader(t *testing.T) {
 	type args struct {
-		cfg    *config.MySQLDriverConfig
-		logger *log.Entry
+		cfg           *config.MySQLDriverConfig
+		logger        *log.Entry
+		replicateDoDb []*config.DataSource
+		sqleContext   *sqle.Context
+		serverId      uint32
 	}
 	tests := []struct {
 		name             string
@@ -69,7 +81,7 @@ func TestNewMySQLReader(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotBinlogReader, err := NewMySQLReader(tt.args.cfg, tt.args.logger)
+			gotBinlogReader, err := NewMySQLReader(tt.args.cfg, tt.args.logger, tt.args.replicateDoDb, tt.args.sqleContext, tt.args.serverId)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewMySQLReader() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -91,7 +103,7 @@ func TestBinlogReader_ConnectBinlogStreamer(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -106,7 +118,7 @@ func TestBinlogReader_ConnectBinlogStreamer(t *testing.T) {
 		shutdownLock             sync.Mutex
 	}
 	type args struct {
-		coordinates base.BinlogCoordinateTx
+		coordinates base.BinlogCoordinatesX
 	}
 	tests := []struct {
 		name    string
@@ -127,7 +139,7 @@ func TestBinlogReader_ConnectBinlogStreamer(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -158,7 +170,7 @@ func TestBinlogReader_GetCurrentBinlogCoordinates(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -190,7 +202,7 @@ func TestBinlogReader_GetCurrentBinlogCoordinates(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -221,7 +233,7 @@ func TestBinlogReader_handleRowsEvent(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -258,7 +270,7 @@ func TestBinlogReader_handleRowsEvent(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -289,7 +301,7 @@ func TestBinlogReader_DataStreamEvents(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -325,7 +337,7 @@ func TestBinlogReader_DataStreamEvents(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -356,7 +368,7 @@ func TestBinlogReader_BinlogStreamEvents(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -392,7 +404,7 @@ func TestBinlogReader_BinlogStreamEvents(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -423,7 +435,7 @@ func TestBinlogReader_handleBinlogRowsEvent(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -460,7 +472,7 @@ func TestBinlogReader_handleBinlogRowsEvent(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -491,7 +503,7 @@ func TestBinlogReader_appendQuery(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -526,7 +538,7 @@ func TestBinlogReader_appendQuery(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -575,7 +587,7 @@ func TestBinlogReader_clearB64Sql(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -606,7 +618,7 @@ func TestBinlogReader_clearB64Sql(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -635,7 +647,7 @@ func TestBinlogReader_appendB64Sql(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -670,7 +682,7 @@ func TestBinlogReader_appendB64Sql(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -699,7 +711,7 @@ func TestBinlogReader_onCommit(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -735,7 +747,7 @@ func TestBinlogReader_onCommit(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -786,27 +798,36 @@ func Test_resolveDDLSQL(t *testing.T) {
 		sql string
 	}
 	tests := []struct {
-		name     string
-		args     args
-		wantSqls []string
-		wantOk   bool
-		wantErr  bool
+		name       string
+		args       args
+		wantSqls   []string
+		wantTables []SchemaTable
+		wantIsDDL  bool
+		wantErr    bool
 	}{
-		// TODO: Add test cases.
-		{"t1", args{"alter TABLE aly_test ADD COLUMN (name5 CHAR(5) ,name6 char(6));"}, []string{}, true, false},
+		{"t1", args{"alter TABLE aly_test ADD COLUMN (name5 CHAR(5) ,name6 char(6));"},
+			[]string{"alter TABLE aly_test ADD COLUMN (name5 CHAR(5) ,name6 char(6));"},
+			[]SchemaTable{{Table: "aly_test"}}, true, false},
+		{"t2", args{"drop table a.t1, t2"},
+			[]string{"drop table  a.`t1`", "drop table  `t2`"},
+			[]SchemaTable{{Schema: "a", Table: "t1"}, {Table: "t2"}}, true, false},
+		{"t3", args{"insert into t1 values (1)"}, []string{"insert into t1 values (1)"}, nil, false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotSqls, gotOk, err := resolveDDLSQL(tt.args.sql)
+			got, err := resolveDDLSQL(tt.args.sql)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("resolveDDLSQL() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(gotSqls, tt.wantSqls) {
-				t.Errorf("resolveDDLSQL() gotSqls = %v, want %v", gotSqls, tt.wantSqls)
+			if !reflect.DeepEqual(got.sqls, tt.wantSqls) {
+				t.Errorf("resolveDDLSQL() sqls = %q, want %q", got.sqls, tt.wantSqls)
 			}
-			if gotOk != tt.wantOk {
-				t.Errorf("resolveDDLSQL() gotOk = %v, want %v", gotOk, tt.wantOk)
+			if !reflect.DeepEqual(got.tables, tt.wantTables) {
+				t.Errorf("resolveDDLSQL() tables = %v, want %v", got.tables, tt.wantTables)
+			}
+			if got.isDDL != tt.wantIsDDL {
+				t.Errorf("resolveDDLSQL() isDDL = %v, want %v", got.isDDL, tt.wantIsDDL)
 			}
 		})
 	}
@@ -822,7 +843,7 @@ func TestBinlogReader_skipQueryDDL(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -837,8 +858,9 @@ func TestBinlogReader_skipQueryDDL(t *testing.T) {
 		shutdownLock             sync.Mutex
 	}
 	type args struct {
-		sql    string
-		schema string
+		sql       string
+		schema    string
+		tableName string
 	}
 	tests := []struct {
 		name   string
@@ -859,7 +881,7 @@ func TestBinlogReader_skipQueryDDL(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -873,7 +895,7 @@ func TestBinlogReader_skipQueryDDL(t *testing.T) {
 				shutdownCh:               tt.fields.shutdownCh,
 				shutdownLock:             tt.fields.shutdownLock,
 			}
-			if got := b.skipQueryDDL(tt.args.sql, tt.args.schema); got != tt.want {
+			if got := b.skipQueryDDL(tt.args.sql, tt.args.schema, tt.args.tableName); got != tt.want {
 				t.Errorf("BinlogReader.skipQueryDDL() = %v, want %v", got, tt.want)
 			}
 		})
@@ -910,7 +932,7 @@ func TestBinlogReader_skipRowEvent(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -947,7 +969,7 @@ func TestBinlogReader_skipRowEvent(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -978,7 +1000,7 @@ func TestBinlogReader_matchString(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -1015,7 +1037,7 @@ func TestBinlogReader_matchString(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -1046,7 +1068,7 @@ func TestBinlogReader_matchDB(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -1083,7 +1105,7 @@ func TestBinlogReader_matchDB(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -1114,7 +1136,7 @@ func TestBinlogReader_Close(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -1146,7 +1168,7 @@ func TestBinlogReader_Close(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -1177,7 +1199,7 @@ func TestBinlogReader_matchTable(t *testing.T) {
 		currentCoordinates       base.BinlogCoordinateTx
 		currentCoordinatesMutex  *sync.Mutex
 		LastAppliedRowsEventHint base.BinlogCoordinateTx
-		MysqlContext             *config.MySQLDriverConfig
+		mysqlContext             *config.MySQLDriverConfig
 		currentTx                *BinlogTx
 		currentBinlogEntry       *BinlogEntry
 		txCount                  int
@@ -1193,7 +1215,8 @@ func TestBinlogReader_matchTable(t *testing.T) {
 	}
 	type args struct {
 		patternTBS []*config.DataSource
-		t          config.DataSource
+		schemaName string
+		tableName  string
 	}
 	tests := []struct {
 		name   string
@@ -1214,7 +1237,7 @@ func TestBinlogReader_matchTable(t *testing.T) {
 				currentCoordinates:       tt.fields.currentCoordinates,
 				currentCoordinatesMutex:  tt.fields.currentCoordinatesMutex,
 				LastAppliedRowsEventHint: tt.fields.LastAppliedRowsEventHint,
-				MysqlContext:             tt.fields.MysqlContext,
+				mysqlContext:             tt.fields.mysqlContext,
 				currentTx:                tt.fields.currentTx,
 				currentBinlogEntry:       tt.fields.currentBinlogEntry,
 				txCount:                  tt.fields.txCount,
@@ -1228,9 +1251,751 @@ func TestBinlogReader_matchTable(t *testing.T) {
 				shutdownCh:               tt.fields.shutdownCh,
 				shutdownLock:             tt.fields.shutdownLock,
 			}
-			if got := b.matchTable(tt.args.patternTBS, config.Table{}); got != tt.want {
+			if got := b.matchTable(tt.args.patternTBS, tt.args.schemaName, tt.args.tableName); got != tt.want {
 				t.Errorf("BinlogReader.matchTable() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDetectRowImage(t *testing.T) {
+	// id int, name varchar, txt text, age int
+	table := &replication.TableMapEvent{
+		ColumnType: []byte{gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_VARCHAR, gomysql.MYSQL_TYPE_BLOB, gomysql.MYSQL_TYPE_LONG},
+	}
+	tests := []struct {
+		name      string
+		rowsEvent *replication.RowsEvent
+		want      string
+	}{
+		{"full-update", &replication.RowsEvent{Table: table, ColumnCount: 4,
+			ColumnBitmap1: []byte{0x0f}, ColumnBitmap2: []byte{0x0f}}, RowImageFull},
+		{"minimal-update", &replication.RowsEvent{Table: table, ColumnCount: 4,
+			ColumnBitmap1: []byte{0x01}, ColumnBitmap2: []byte{0x08}}, RowImageMinimal},
+		{"noblob-update", &replication.RowsEvent{Table: table, ColumnCount: 4,
+			ColumnBitmap1: []byte{0x0b}, ColumnBitmap2: []byte{0x0b}}, RowImageNoBlob},
+		{"full-insert", &replication.RowsEvent{Table: table, ColumnCount: 4,
+			ColumnBitmap1: []byte{0x0f}}, RowImageFull},
+		{"minimal-delete", &replication.RowsEvent{Table: table, ColumnCount: 4,
+			ColumnBitmap1: []byte{0x01}}, RowImageMinimal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectRowImage(tt.rowsEvent); got != tt.want {
+				t.Errorf("DetectRowImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinlogReader_handleFakeRotate(t *testing.T) {
+	calls := 0
+	var gotServerId uint32
+	b := &BinlogReader{OnFakeRotate: func(serverId uint32) error {
+		calls++
+		gotServerId = serverId
+		return fmt.Errorf("another server")
+	}}
+	rotate := &replication.BinlogEvent{Header: &replication.EventHeader{
+		EventType: replication.ROTATE_EVENT, Timestamp: 1546300800}}
+	if err := b.handleFakeRotate(rotate); err != nil || calls != 0 {
+		t.Errorf("handleFakeRotate() of a rotate event = %v with %d calls, want nil without calls", err, calls)
+	}
+	fakeRotate := &replication.BinlogEvent{Header: &replication.EventHeader{
+		EventType: replication.ROTATE_EVENT, Timestamp: 0, ServerID: 7}}
+	if err := b.handleFakeRotate(fakeRotate); err == nil || calls != 1 {
+		t.Errorf("handleFakeRotate() of a fake rotate event = %v with %d calls, want the error of OnFakeRotate", err, calls)
+	}
+	if gotServerId != 7 {
+		t.Errorf("OnFakeRotate() server_id = %v, want 7", gotServerId)
+	}
+}
+
+func TestBinlogReader_ReadGtidSet(t *testing.T) {
+	b := &BinlogReader{}
+	if got := b.ReadGtidSet(); got != "" {
+		t.Errorf("ReadGtidSet() before the streamer = %q, want empty", got)
+	}
+	var err error
+	if b.readGtidSet, err = gtid.Parse("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"); err != nil {
+		t.Fatal(err)
+	}
+	u := uuid.FromStringOrNil("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+	b.readGtidSet.AddGtid(u, 6)
+	if got, want := b.ReadGtidSet(), "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-6"; got != want {
+		t.Errorf("ReadGtidSet() = %q, want %q", got, want)
+	}
+}
+
+func Test_eventFilter_drop(t *testing.T) {
+	f, err := newEventFilter([]*config.EventFilterRule{
+		{Table: "db1.audit_*", Action: "pass"},
+		{Operations: []string{"delete"}, Table: "db1.*", Action: "drop"},
+		{StatementRegex: `(?i)^\s*truncate`, Action: "drop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		op        string
+		schema    string
+		table     string
+		statement string
+		want      bool
+	}{
+		{"passed-first", "delete", "db1", "audit_log", "", false},
+		{"delete", "delete", "db1", "t1", "delete from t1", true},
+		{"insert", "insert", "db1", "t1", "", false},
+		{"other-schema", "delete", "db2", "t1", "", false},
+		{"ddl-statement", "ddl", "db2", "t1", "TRUNCATE TABLE t1", true},
+		{"no-statement", "update", "db2", "t1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.drop(tt.op, tt.schema, tt.table, tt.statement); got != tt.want {
+				t.Errorf("eventFilter.drop() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	wantMatched := []int64{1, 1, 1}
+	for i, stat := range f.stats() {
+		if stat.Matched != wantMatched[i] {
+			t.Errorf("stats()[%d].Matched = %v, want %v", i, stat.Matched, wantMatched[i])
+		}
+	}
+	var none *eventFilter
+	if none.drop("delete", "db1", "t1", "") || none.stats() != nil {
+		t.Errorf("nil eventFilter drops events or has stats")
+	}
+}
+
+func TestBinlogReader_handleEvent_SkipServerIds(t *testing.T) {
+	table := config.NewTable("db1", "t1")
+	table.OriginalTableColumns = mysql.NewColumnList([]mysql.Column{{Name: "id", Type: mysql.IntColumnType}})
+	whereCtx, err := config.NewWhereCtx("true", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &BinlogReader{
+		logger:                  log.NewEntry(log.New(os.Stdout, log.DebugLevel)),
+		currentCoordinatesMutex: &sync.Mutex{},
+		mysqlContext:            &config.MySQLDriverConfig{},
+		tables: map[string]map[string]*config.TableContext{
+			"db1": {"t1": config.NewTableContext(table, whereCtx)},
+		},
+		sqlFilter:     &SqlFilter{},
+		skipServerIds: map[uint32]bool{2: true, 3: true},
+	}
+	// the layout of the binlog matches the metadata, which is not fetched again
+	b.loadTableColumns = func(schemaName, tableName string) (*mysql.ColumnList, error) {
+		t.Errorf("loadTableColumns(%v, %v) called for a matching layout", schemaName, tableName)
+		return nil, fmt.Errorf("unexpected")
+	}
+
+	sid := []byte("0123456789abcdef")
+	gtidEvent := func(gno int64) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+			Event:  &replication.GTIDEvent{SID: sid, GNO: gno},
+		}
+	}
+	rowsEvent := func(serverId uint32, id int32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, ServerID: serverId},
+			Event: &replication.RowsEvent{
+				Table: &replication.TableMapEvent{Schema: []byte("db1"), Table: []byte("t1"),
+					ColumnCount: 1, ColumnType: []byte{gomysql.MYSQL_TYPE_LONG}},
+				ColumnCount:   1,
+				ColumnBitmap1: []byte{0xff},
+				Rows:          [][]interface{}{{id}},
+			},
+		}
+	}
+	xidEvent := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.XID_EVENT},
+		Event:  &replication.XIDEvent{},
+	}
+
+	// a transaction with rows from several origins, and one with rows from skipped origins only
+	stream := []*replication.BinlogEvent{
+		gtidEvent(1), rowsEvent(1, 1), rowsEvent(2, 2), rowsEvent(1, 3), xidEvent,
+		gtidEvent(2), rowsEvent(3, 4), rowsEvent(2, 5), xidEvent,
+	}
+	entries := make(chan *BinlogEntry, 2)
+	b.currentCoordinates.LogFile = "mysql-bin.000001"
+	for i, ev := range stream {
+		// as DataStreamEvents
+		b.currentCoordinates.LogPos = int64(i + 1)
+		if err := b.handleEvent(ev, entries); err != nil {
+			t.Fatalf("handleEvent() error = %v", err)
+		}
+	}
+	close(entries)
+
+	var gnos []int64
+	var ids [][]interface{}
+	for entry := range entries {
+		gnos = append(gnos, entry.Coordinates.GNO)
+		var entryIds []interface{}
+		for _, event := range entry.Events {
+			entryIds = append(entryIds, *event.NewColumnValues.AbstractValues[0])
+		}
+		ids = append(ids, entryIds)
+	}
+	if !reflect.DeepEqual(gnos, []int64{1, 2}) {
+		t.Errorf("gnos = %v, want all the transactions sent", gnos)
+	}
+	if !reflect.DeepEqual(ids, [][]interface{}{{int32(1), int32(3)}, nil}) {
+		t.Errorf("row ids = %v, want the rows of server 1 only", ids)
+	}
+}
+
+func TestToColumnValuesV2_charset(t *testing.T) {
+	table := &config.TableContext{Table: &config.Table{
+		OriginalTableColumns: mysql.NewColumnList([]mysql.Column{
+			{Name: "s", Charset: "latin1"},
+			{Name: "b", Charset: "latin1"},
+			{Name: "bin", Charset: "binary"},
+		}),
+	}}
+	values := ToColumnValuesV2([]interface{}{"caf\xe9", []byte("cr\xe8me"), []byte("\xe9")}, table)
+	got := []interface{}{*values.AbstractValues[0], *values.AbstractValues[1], *values.AbstractValues[2]}
+	want := []interface{}{"café", "crème", []byte("\xe9")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %#v, want %#v", got, want)
+	}
+}
+
+func TestBinlogReader_DataStreamEvents_faults(t *testing.T) {
+	injector := faults.Enable()
+	defer faults.Disable()
+	if err := injector.Set(faults.ExtractorReadEvent, faults.Fault{Error: "connection reset"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed read stops the stream, for the extractor to resume from the last Gtid sent. No
+	// event is read past it.
+	b := &BinlogReader{logger: log.NewEntry(log.New(os.Stderr, log.ErrorLevel))}
+	entries := make(chan *BinlogEntry, 1)
+	if err := b.DataStreamEvents(entries); err == nil {
+		t.Fatalf("DataStreamEvents() succeeded with an injected error")
+	}
+	if len(entries) != 0 {
+		t.Errorf("%v entries sent after a failed read", len(entries))
+	}
+}
+
+func Test_parseXaQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantStmt string
+		wantXid  string
+		wantOk   bool
+	}{
+		{"XA START X'6162',X'',1", "START", "x'6162',x'',1", true},
+		{"XA END X'6162',X'',1", "END", "x'6162',x'',1", true},
+		{"XA COMMIT X'6162',X'6364',1", "COMMIT", "x'6162',x'6364',1", true},
+		{"XA COMMIT X'6162',X'',1 ONE PHASE", "COMMIT ONE PHASE", "x'6162',x'',1", true},
+		{"xa rollback X'6162', X'', 1", "ROLLBACK", "x'6162',x'',1", true},
+		{"XA RECOVER", "", "", false},
+		{"BEGIN", "", "", false},
+		{"insert into xa values (1)", "", "", false},
+	}
+	for _, tt := range tests {
+		stmt, xid, ok := parseXaQuery(tt.query)
+		if stmt != tt.wantStmt || xid != tt.wantXid || ok != tt.wantOk {
+			t.Errorf("parseXaQuery(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.query, stmt, xid, ok, tt.wantStmt, tt.wantXid, tt.wantOk)
+		}
+	}
+}
+
+// xaPrepareEvent returns an XA_PREPARE_LOG_EVENT of the xid gtrid,bqual,1.
+func xaPrepareEvent(onePhase bool, gtrid, bqual string) *replication.BinlogEvent {
+	data := []byte{0, 1, 0, 0, 0, byte(len(gtrid)), 0, 0, 0, byte(len(bqual)), 0, 0, 0}
+	if onePhase {
+		data[0] = 1
+	}
+	data = append(data, gtrid+bqual...)
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: xaPrepareLogEvent},
+		Event:  &replication.GenericEvent{Data: data},
+	}
+}
+
+func Test_parseXaPrepareEvent(t *testing.T) {
+	onePhase, xid, err := parseXaPrepareEvent(xaPrepareEvent(true, "ab", "cd").Event.(*replication.GenericEvent).Data)
+	if err != nil || !onePhase || xid != "x'6162',x'6364',1" {
+		t.Errorf("parseXaPrepareEvent() = %v, %v, %v", onePhase, xid, err)
+	}
+	if _, _, err := parseXaPrepareEvent([]byte{0, 1, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 'a'}); err == nil {
+		t.Errorf("parseXaPrepareEvent() of a truncated xid succeeded")
+	}
+}
+
+func TestBinlogReader_handleEvent_xa(t *testing.T) {
+	sid := []byte("0123456789abcdef")
+	gtidEvent := func(gno int64) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+			Event:  &replication.GTIDEvent{SID: sid, GNO: gno},
+		}
+	}
+	queryEvent := func(query string) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.QUERY_EVENT},
+			Event:  &replication.QueryEvent{Schema: []byte("db1"), Query: []byte(query)},
+		}
+	}
+	rowsEvent := func(id int32) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, ServerID: 1},
+			Event: &replication.RowsEvent{
+				Table: &replication.TableMapEvent{Schema: []byte("db1"), Table: []byte("t1"),
+					ColumnCount: 1, ColumnType: []byte{gomysql.MYSQL_TYPE_LONG}},
+				ColumnCount:   1,
+				ColumnBitmap1: []byte{0xff},
+				Rows:          [][]interface{}{{id}},
+			},
+		}
+	}
+	xidEvent := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.XID_EVENT},
+		Event:  &replication.XIDEvent{},
+	}
+
+	// a: prepared, then committed after another transaction. b: prepared, then rolled back.
+	// c: committed in one phase.
+	stream := []*replication.BinlogEvent{
+		gtidEvent(1), queryEvent("XA START X'61',X'',1"), rowsEvent(1), queryEvent("XA END X'61',X'',1"), xaPrepareEvent(false, "a", ""),
+		gtidEvent(2), queryEvent("BEGIN"), rowsEvent(2), xidEvent,
+		gtidEvent(3), queryEvent("XA COMMIT X'61',X'',1"),
+		gtidEvent(4), queryEvent("XA START X'62',X'',1"), rowsEvent(3), queryEvent("XA END X'62',X'',1"), xaPrepareEvent(false, "b", ""),
+		gtidEvent(5), queryEvent("XA ROLLBACK X'62',X'',1"),
+		gtidEvent(6), queryEvent("XA START X'63',X'',1"), rowsEvent(4), queryEvent("XA END X'63',X'',1"), xaPrepareEvent(true, "c", ""),
+	}
+
+	type result struct {
+		gno         int64
+		ids         []interface{}
+		xaOp        string
+		xid         string
+		preparedGno int64
+	}
+	replicate := func(xaPolicy string) (results []result) {
+		table := config.NewTable("db1", "t1")
+		table.OriginalTableColumns = mysql.NewColumnList([]mysql.Column{{Name: "id", Type: mysql.IntColumnType}})
+		whereCtx, err := config.NewWhereCtx("true", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &BinlogReader{
+			logger:                  log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+			currentCoordinatesMutex: &sync.Mutex{},
+			mysqlContext:            &config.MySQLDriverConfig{XaPolicy: xaPolicy},
+			tables: map[string]map[string]*config.TableContext{
+				"db1": {"t1": config.NewTableContext(table, whereCtx)},
+			},
+			sqlFilter:     &SqlFilter{},
+			skipServerIds: map[uint32]bool{},
+		}
+		b.loadTableColumns = func(schemaName, tableName string) (*mysql.ColumnList, error) {
+			t.Errorf("loadTableColumns(%v, %v) called for a matching layout", schemaName, tableName)
+			return nil, fmt.Errorf("unexpected")
+		}
+		entries := make(chan *BinlogEntry, len(stream))
+		b.currentCoordinates.LogFile = "mysql-bin.000001"
+		for i, ev := range stream {
+			b.currentCoordinates.LogPos = int64(i + 1)
+			if err := b.handleEvent(ev, entries); err != nil {
+				t.Fatalf("handleEvent() error = %v", err)
+			}
+		}
+		close(entries)
+		for entry := range entries {
+			r := result{gno: entry.Coordinates.GNO, xaOp: entry.XaOp, xid: entry.Xid}
+			for _, event := range entry.Events {
+				r.ids = append(r.ids, *event.NewColumnValues.AbstractValues[0])
+			}
+			if entry.XaPrepared != nil {
+				r.preparedGno = entry.XaPrepared.GNO
+			}
+			results = append(results, r)
+		}
+		return results
+	}
+
+	// the rows of a with the commit part, and none of b
+	want := []result{
+		{gno: 2, ids: []interface{}{int32(2)}},
+		{gno: 3, ids: []interface{}{int32(1)}, preparedGno: 1},
+		{gno: 5, preparedGno: 4},
+		{gno: 6, ids: []interface{}{int32(4)}},
+	}
+	if got := replicate(config.XaPolicyLocal); !reflect.DeepEqual(got, want) {
+		t.Errorf("XaPolicyLocal: entries = %+v, want %+v", got, want)
+	}
+
+	// the rows of a and b with the prepare parts
+	want = []result{
+		{gno: 1, ids: []interface{}{int32(1)}, xaOp: XaOpPrepare, xid: "x'61',x'',1"},
+		{gno: 2, ids: []interface{}{int32(2)}},
+		{gno: 3, xaOp: XaOpCommit, xid: "x'61',x'',1", preparedGno: 1},
+		{gno: 4, ids: []interface{}{int32(3)}, xaOp: XaOpPrepare, xid: "x'62',x'',1"},
+		{gno: 5, xaOp: XaOpRollback, xid: "x'62',x'',1", preparedGno: 4},
+		{gno: 6, ids: []interface{}{int32(4)}},
+	}
+	if got := replicate(config.XaPolicyTarget); !reflect.DeepEqual(got, want) {
+		t.Errorf("XaPolicyTarget: entries = %+v, want %+v", got, want)
+	}
+}
+
+func TestBinlogReader_HeartbeatStat(t *testing.T) {
+	b := &BinlogReader{mysqlContext: &config.MySQLDriverConfig{BinlogHeartbeatPeriod: 3}}
+	if got := b.HeartbeatStat(); got.Period != 3 || got.LastHeartbeat != 0 || got.LastEvent != 0 {
+		t.Errorf("HeartbeatStat() before any event = %+v", got)
+	}
+	b.received(&replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.XID_EVENT}})
+	if got := b.HeartbeatStat(); got.LastHeartbeat != 0 || got.LastEvent == 0 {
+		t.Errorf("HeartbeatStat() after an event = %+v", got)
+	}
+	b.received(&replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.HEARTBEAT_EVENT}})
+	if got := b.HeartbeatStat(); got.LastHeartbeat == 0 || got.LastHeartbeat != got.LastEvent {
+		t.Errorf("HeartbeatStat() after a heartbeat = %+v", got)
+	}
+
+	b.mysqlContext.BinlogHeartbeatPeriod = -1
+	if got := b.HeartbeatStat(); got.Period != 0 {
+		t.Errorf("HeartbeatStat().Period disabled = %v, want 0", got.Period)
+	}
+}
+
+func TestToColumnValuesV2_unsigned(t *testing.T) {
+	table := &config.TableContext{Table: &config.Table{
+		OriginalTableColumns: mysql.NewColumnList([]mysql.Column{
+			{Name: "ti", Type: mysql.TinyintColumnType, IsUnsigned: true},
+			{Name: "si", Type: mysql.SmallintColumnType, IsUnsigned: true},
+			{Name: "mi", Type: mysql.MediumIntColumnType, IsUnsigned: true},
+			{Name: "i", Type: mysql.IntColumnType, IsUnsigned: true},
+			{Name: "bi", Type: mysql.BigIntColumnType, IsUnsigned: true},
+			{Name: "signed", Type: mysql.BigIntColumnType},
+		}),
+	}}
+	// the max values, as the binlog has them
+	values := ToColumnValuesV2([]interface{}{int8(-1), int16(-1), int32(-1), int32(-1), int64(-1), int64(-1)}, table)
+	var got []interface{}
+	for _, v := range values.AbstractValues {
+		got = append(got, *v)
+	}
+	want := []interface{}{uint8(255), uint16(65535), uint32(16777215), uint32(4294967295),
+		uint64(18446744073709551615), int64(-1)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %#v, want %#v", got, want)
+	}
+}
+
+func Test_checkSignedness(t *testing.T) {
+	table := &config.TableContext{Table: &config.Table{
+		OriginalTableColumns: mysql.NewColumnList([]mysql.Column{{Name: "id", Type: mysql.IntColumnType}}),
+	}}
+	rowsEvent := func(columnTypes ...byte) *replication.RowsEvent {
+		return &replication.RowsEvent{Table: &replication.TableMapEvent{
+			Schema: []byte("db1"), Table: []byte("tb1"), ColumnType: columnTypes,
+		}}
+	}
+	tests := []struct {
+		name    string
+		event   *replication.RowsEvent
+		table   *config.TableContext
+		policy  string
+		wantErr bool
+	}{
+		{"in metadata", rowsEvent(gomysql.MYSQL_TYPE_LONG), table, config.UnsignedPolicyFail, false},
+		{"hidden integer", rowsEvent(gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_LONGLONG), table, config.UnsignedPolicyFail, true},
+		{"hidden integer, signed", rowsEvent(gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_LONGLONG), table, config.UnsignedPolicySigned, false},
+		{"hidden string", rowsEvent(gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_VARCHAR), table, config.UnsignedPolicyFail, false},
+		{"no table", rowsEvent(gomysql.MYSQL_TYPE_LONGLONG), nil, config.UnsignedPolicyFail, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSignedness(tt.event, tt.table, tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("checkSignedness() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBinlogReader_revalidateTableMeta(t *testing.T) {
+	id := mysql.Column{Name: "id", Type: mysql.IntColumnType}
+	a := mysql.Column{Name: "a", Type: mysql.IntColumnType, IsUnsigned: true}
+	name := mysql.Column{Name: "name", Type: mysql.VarcharColumnType}
+	// db1.tb1 is (id, a, name) in the metadata. a is dropped with sql_log_bin=0, so the binlog has
+	// no DDL and its rows are (id, name).
+	table := config.NewTable("db1", "tb1")
+	table.OriginalTableColumns = mysql.NewColumnList([]mysql.Column{id, a, name})
+	b := &BinlogReader{
+		logger:                  log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		mysqlContext:            &config.MySQLDriverConfig{},
+		tables:                  make(map[string]map[string]*config.TableContext),
+		sqlFilter:               &SqlFilter{},
+		skipServerIds:           make(map[uint32]bool),
+		currentCoordinatesMutex: &sync.Mutex{},
+		currentCoordinates:      base.BinlogCoordinateTx{LogFile: "mysql-bin.000001", LogPos: 200},
+	}
+	if err := b.addTableToTableMap(b.getDbTableMap("db1"), table); err != nil {
+		t.Fatal(err)
+	}
+	fetched := 0
+	source := []mysql.Column{id, a, name}
+	b.loadTableColumns = func(schemaName, tableName string) (*mysql.ColumnList, error) {
+		fetched++
+		return mysql.NewColumnList(source), nil
+	}
+	insert := func(columnTypes []byte, row ...interface{}) error {
+		b.currentBinlogEntry = NewBinlogEntryAt(b.currentCoordinates)
+		return b.handleEvent(&replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2, LogPos: 200, EventSize: 50},
+			Event: &replication.RowsEvent{
+				Table: &replication.TableMapEvent{
+					Schema: []byte("db1"), Table: []byte("tb1"),
+					ColumnCount: uint64(len(columnTypes)), ColumnType: columnTypes,
+				},
+				ColumnCount: uint64(len(columnTypes)),
+				Rows:        [][]interface{}{row},
+			},
+		}, nil)
+	}
+	before := []byte{gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_VARCHAR}
+	after := []byte{gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_VARCHAR}
+
+	if err := insert(before, int32(1), int32(-1), "x"); err != nil {
+		t.Fatalf("matching layout: %v", err)
+	}
+	if fetched != 0 {
+		t.Errorf("fetched %v times for a matching layout", fetched)
+	}
+	b.getDbTableMap("db1")["tb1"].DefChangedSent = true
+
+	// The metadata fetched again is stale too, e.g. a is added back since: an error, not
+	// "x" decoded as a.
+	if err := insert(after, int32(2), "y"); err == nil {
+		t.Fatalf("a stale layout is decoded")
+	}
+	if fetched != 1 || len(b.currentBinlogEntry.Events) != 0 {
+		t.Errorf("stale layout: fetched %v times, events %v, want 1 and none", fetched, b.currentBinlogEntry.Events)
+	}
+
+	// The metadata of the source matches: used, and sent again to the applier.
+	source = []mysql.Column{id, name}
+	if err := insert(after, int32(3), "z"); err != nil {
+		t.Fatalf("fetched layout: %v", err)
+	}
+	events := b.currentBinlogEntry.Events
+	if len(events) != 1 || events[0].Table == nil || len(events[0].Table.OriginalTableColumns.Columns) != 2 {
+		t.Fatalf("events = %+v, want the insert with the new table definition", events)
+	}
+	if err := insert(after, int32(4), "w"); err != nil || fetched != 2 {
+		t.Errorf("same layout again: err %v, fetched %v times, want nil, 2", err, fetched)
+	}
+
+	// Without a connection to the source, a changed layout is an error.
+	b.loadTableColumns = nil
+	if err := insert(before, int32(5), int32(-1), "v"); err == nil {
+		t.Errorf("a changed layout without a connection to the source is decoded")
+	}
+}
+
+func Test_checkTableLayout(t *testing.T) {
+	columns := []mysql.Column{{Name: "id", Type: mysql.BigIntColumnType}, {Name: "s", Type: mysql.TextColumnType}}
+	tableMap := func(columnTypes ...byte) *replication.TableMapEvent {
+		return &replication.TableMapEvent{ColumnType: columnTypes}
+	}
+	tests := []struct {
+		name       string
+		tableMap   *replication.TableMapEvent
+		allowExtra bool
+		wantErr    bool
+	}{
+		{"same", tableMap(gomysql.MYSQL_TYPE_LONGLONG, gomysql.MYSQL_TYPE_BLOB), false, false},
+		{"same family", tableMap(gomysql.MYSQL_TYPE_LONGLONG, gomysql.MYSQL_TYPE_VARCHAR), false, false},
+		{"other type", tableMap(gomysql.MYSQL_TYPE_LONG, gomysql.MYSQL_TYPE_BLOB), false, true},
+		{"fewer", tableMap(gomysql.MYSQL_TYPE_LONGLONG), true, true},
+		{"hidden key", tableMap(gomysql.MYSQL_TYPE_LONGLONG, gomysql.MYSQL_TYPE_BLOB, gomysql.MYSQL_TYPE_LONGLONG), false, true},
+		{"hidden key allowed", tableMap(gomysql.MYSQL_TYPE_LONGLONG, gomysql.MYSQL_TYPE_BLOB, gomysql.MYSQL_TYPE_LONGLONG), true, false},
+	}
+	for _, tt := range tests {
+		if err := checkTableLayout(tt.tableMap, columns, tt.allowExtra); (err != nil) != tt.wantErr {
+			t.Errorf("%v: checkTableLayout() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// rawEvent returns an event as in the binlog, without checksum.
+func rawEvent(eventType replication.EventType, logPos uint32, body []byte) []byte {
+	data := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(body))
+	data[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(data[5:], 1)
+	binary.LittleEndian.PutUint32(data[9:], uint32(replication.EventHeaderSize+len(body)))
+	binary.LittleEndian.PutUint32(data[13:], logPos)
+	return append(data, body...)
+}
+
+func Test_parseTransactionPayloadEvent(t *testing.T) {
+	// size 3, uncompressed size 300, then the fields of later versions
+	data := []byte{payloadSizeField, 1, 3, payloadCompressionTypeField, 1, payloadCompressionNone,
+		payloadUncompressedSizeField, 3, 0xfc, 0x2c, 0x01, 9, 2, 0xff, 0xff, payloadHeaderEndMark, 'a', 'b', 'c'}
+	p, err := parseTransactionPayloadEvent(data)
+	if err != nil || p.compressionType != payloadCompressionNone || p.uncompressedSize != 300 || string(p.payload) != "abc" {
+		t.Errorf("parseTransactionPayloadEvent() = %+v, %v", p, err)
+	}
+	for _, bad := range [][]byte{
+		data[:8],              // truncated header
+		data[:len(data)-1],    // truncated payload
+		{payloadSizeField, 9}, // truncated field
+	} {
+		if _, err := parseTransactionPayloadEvent(bad); err == nil {
+			t.Errorf("parseTransactionPayloadEvent(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestBinlogReader_handleTransactionPayload(t *testing.T) {
+	// MySQL 8.0.20, with the post-header lengths of QUERY_EVENT, TABLE_MAP_EVENT and
+	// WRITE_ROWS_EVENTv2
+	fdeBody := []byte{4, 0}
+	fdeBody = append(fdeBody, make([]byte, 50)...)
+	copy(fdeBody[2:], "8.0.20")
+	fdeBody = append(fdeBody, 0, 0, 0, 0, replication.EventHeaderSize)
+	postHeaderLengths := make([]byte, 40)
+	postHeaderLengths[replication.QUERY_EVENT-1] = 13
+	postHeaderLengths[replication.TABLE_MAP_EVENT-1] = 8
+	postHeaderLengths[replication.WRITE_ROWS_EVENTv2-1] = 10
+	fdeBody = append(fdeBody, postHeaderLengths...)
+	fdeBody = append(fdeBody, replication.BINLOG_CHECKSUM_ALG_CRC32, 0, 0, 0, 0)
+	fde := rawEvent(replication.FORMAT_DESCRIPTION_EVENT, 0, fdeBody)
+	fdeEvent, err := replication.NewBinlogParser().Parse(fde)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sid := []byte("0123456789abcdef")
+	gtidEvent := func(gno int64) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+			Event:  &replication.GTIDEvent{SID: sid, GNO: gno},
+		}
+	}
+	// BEGIN, the table map of db1.t1 (id int), the insert of the id, then XID
+	transaction := func(id uint32) []byte {
+		query := append([]byte{0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0}, "db1\x00BEGIN"...)
+		tableMap := append([]byte{1, 0, 0, 0, 0, 0, 0, 0}, "\x03db1\x00\x02t1\x00\x01"...)
+		tableMap = append(tableMap, gomysql.MYSQL_TYPE_LONG, 0, 0)
+		rows := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0xff, 0}
+		rows = append(rows, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(rows[len(rows)-4:], id)
+		var events []byte
+		events = append(events, rawEvent(replication.QUERY_EVENT, 0, query)...)
+		events = append(events, rawEvent(replication.TABLE_MAP_EVENT, 0, tableMap)...)
+		events = append(events, rawEvent(replication.WRITE_ROWS_EVENTv2, 0, rows)...)
+		events = append(events, rawEvent(replication.XID_EVENT, 0, make([]byte, 8))...)
+		return events
+	}
+	payloadEvent := func(compressionType byte, events []byte) *replication.BinlogEvent {
+		payload := events
+		if compressionType == payloadCompressionZstd {
+			encoder, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload = encoder.EncodeAll(events, nil)
+		}
+		// the sizes are below 251, as one byte
+		data := []byte{payloadSizeField, 1, byte(len(payload)), payloadCompressionTypeField, 1, compressionType,
+			payloadUncompressedSizeField, 1, byte(len(events)), payloadHeaderEndMark}
+		data = append(data, payload...)
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: transactionPayloadEvent, LogPos: 1000, EventSize: uint32(len(data))},
+			Event:  &replication.GenericEvent{Data: data},
+		}
+	}
+
+	table := config.NewTable("db1", "t1")
+	table.OriginalTableColumns = mysql.NewColumnList([]mysql.Column{{Name: "id", Type: mysql.IntColumnType}})
+	whereCtx, err := config.NewWhereCtx("true", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &BinlogReader{
+		logger:                  log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		currentCoordinatesMutex: &sync.Mutex{},
+		mysqlContext:            &config.MySQLDriverConfig{BinlogCompression: true},
+		tables: map[string]map[string]*config.TableContext{
+			"db1": {"t1": config.NewTableContext(table, whereCtx)},
+		},
+		sqlFilter:     &SqlFilter{},
+		skipServerIds: map[uint32]bool{},
+	}
+	if err := b.resetPayloadParser(fdeEvent); err != nil {
+		t.Fatalf("resetPayloadParser() error = %v", err)
+	}
+
+	// compressed, uncompressed, then compressed again
+	entries := make(chan *BinlogEntry, 3)
+	b.currentCoordinates.LogFile = "mysql-bin.000001"
+	handle := func(pos int64, ev *replication.BinlogEvent, payload bool) {
+		b.currentCoordinates.LogPos = pos
+		handle := b.handleEvent
+		if payload {
+			handle = b.handleTransactionPayload
+		}
+		if err := handle(ev, entries); err != nil {
+			t.Fatalf("at %v: error = %v", pos, err)
+		}
+	}
+	handle(1, gtidEvent(1), false)
+	handle(2, payloadEvent(payloadCompressionZstd, transaction(1)), true)
+	handle(3, gtidEvent(2), false)
+	parser := replication.NewBinlogParser()
+	if _, err := parser.Parse(append(append([]byte(nil), fde[:len(fde)-5]...), replication.BINLOG_CHECKSUM_ALG_OFF, 0, 0, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range splitEvents(t, transaction(2)) {
+		ev, err := parser.Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handle(int64(4+i), ev, false)
+	}
+	handle(10, gtidEvent(3), false)
+	handle(11, payloadEvent(payloadCompressionNone, transaction(3)), true)
+	close(entries)
+
+	var gnos []int64
+	var ids []interface{}
+	for entry := range entries {
+		gnos = append(gnos, entry.Coordinates.GNO)
+		for _, event := range entry.Events {
+			ids = append(ids, *event.NewColumnValues.AbstractValues[0])
+		}
+	}
+	if !reflect.DeepEqual(gnos, []int64{1, 2, 3}) || !reflect.DeepEqual(ids, []interface{}{int32(1), int32(2), int32(3)}) {
+		t.Errorf("entries of gno %v, ids %v, want 1, 2, 3", gnos, ids)
+	}
+	if stat := b.BinlogCompressionStat(); !stat.Enabled || stat.Payloads != 2 || stat.UncompressedBytes != 2*int64(len(transaction(1))) {
+		t.Errorf("BinlogCompressionStat() = %+v", stat)
+	}
+
+	if err := b.handleTransactionPayload(payloadEvent(7, transaction(4)), make(chan *BinlogEntry, 1)); err == nil {
+		t.Errorf("handleTransactionPayload() of an unknown compression type succeeded")
+	}
+}
+
+func splitEvents(t *testing.T, events []byte) (split [][]byte) {
+	p := &transactionPayload{compressionType: payloadCompressionNone, uncompressedSize: uint64(len(events)), payload: events}
+	split, err := p.events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return split
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// eventFilterRule is a compiled config.EventFilterRule.
+type eventFilterRule struct {
+	operations map[string]bool // empty for all
+	schema     string          // path.Match pattern. empty for all tables
+	table      string
+	statement  *regexp.Regexp
+	drop       bool
+	// number of events matched. accessed atomically
+	matched int64
+}
+
+// eventFilter is MySQLDriverConfig.EventFilters of the extractor.
+type eventFilter struct {
+	rules []*eventFilterRule
+}
+
+func newEventFilter(rules []*config.EventFilterRule) (*eventFilter, error) {
+	if err := config.ValidateEventFilters(rules); err != nil {
+		return nil, err
+	}
+	f := &eventFilter{}
+	for _, rule := range rules {
+		r := &eventFilterRule{
+			operations: make(map[string]bool),
+			drop:       strings.ToLower(rule.Action) == config.EventFilterActionDrop,
+		}
+		for _, op := range rule.Operations {
+			r.operations[strings.ToLower(op)] = true
+		}
+		if rule.Table != "" {
+			parts := strings.Split(rule.Table, ".")
+			r.schema, r.table = parts[0], parts[1]
+		}
+		if rule.StatementRegex != "" {
+			r.statement = regexp.MustCompile(rule.StatementRegex)
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f, nil
+}
+
+func (r *eventFilterRule) match(op, schema, table, statement string) bool {
+	if len(r.operations) > 0 && !r.operations[op] {
+		return false
+	}
+	if r.schema != "" {
+		if ok, _ := path.Match(r.schema, schema); !ok {
+			return false
+		}
+		if ok, _ := path.Match(r.table, table); !ok {
+			return false
+		}
+	}
+	if r.statement != nil {
+		if statement == "" || !r.statement.MatchString(statement) {
+			return false
+		}
+	}
+	return true
+}
+
+// drop evaluates the rules in order for an event. op is one of the config.EventFilterOp values.
+// The first matching rule is counted and decides. statement is empty if not known.
+func (f *eventFilter) drop(op, schema, table, statement string) bool {
+	if f == nil {
+		return false
+	}
+	for _, r := range f.rules {
+		if r.match(op, schema, table, statement) {
+			atomic.AddInt64(&r.matched, 1)
+			return r.drop
+		}
+	}
+	return false
+}
+
+func (f *eventFilter) stats() []*models.EventFilterStat {
+	if f == nil || len(f.rules) == 0 {
+		return nil
+	}
+	result := make([]*models.EventFilterStat, 0, len(f.rules))
+	for i, r := range f.rules {
+		action := config.EventFilterActionPass
+		if r.drop {
+			action = config.EventFilterActionDrop
+		}
+		result = append(result, &models.EventFilterStat{
+			Rule:    i,
+			Action:  action,
+			Matched: atomic.LoadInt64(&r.matched),
+		})
+	}
+	return result
+}
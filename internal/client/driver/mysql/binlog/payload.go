@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/models"
+	"github.com/klauspost/compress/zstd"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// With binlog_transaction_compression=ON (MySQL 8.0.20+), the source logs each transaction as its
+// GTID event then a TRANSACTION_PAYLOAD_EVENT, which has the other events of the transaction
+// (BEGIN, the table maps, the rows, then XID), compressed. The transactions too large to be
+// compressed, and those logged before the variable is set, are logged as usual: a binlog may have
+// both.
+
+// transactionPayloadEvent is TRANSACTION_PAYLOAD_EVENT of MySQL 8.0.20, which go-mysql decodes as
+// a GenericEvent.
+const transactionPayloadEvent replication.EventType = 40
+
+// the fields of the header of a TRANSACTION_PAYLOAD_EVENT
+const (
+	payloadHeaderEndMark         = 0
+	payloadSizeField             = 1
+	payloadCompressionTypeField  = 2
+	payloadUncompressedSizeField = 3
+)
+
+// the compression types of a TRANSACTION_PAYLOAD_EVENT
+const (
+	payloadCompressionZstd = 0
+	payloadCompressionNone = 255
+)
+
+// transactionPayload is the body of a TRANSACTION_PAYLOAD_EVENT.
+type transactionPayload struct {
+	compressionType  uint64
+	uncompressedSize uint64
+	payload          []byte
+}
+
+// parseTransactionPayloadEvent parses the body of a TRANSACTION_PAYLOAD_EVENT: the fields of its
+// header, each its type, the length of its value and its value, as length-encoded integers, up to
+// the end mark; then the payload.
+func parseTransactionPayloadEvent(data []byte) (*transactionPayload, error) {
+	p := &transactionPayload{}
+	size := uint64(0)
+	pos := 0
+	// reads a length-encoded integer
+	next := func() (uint64, error) {
+		n := 1
+		if pos < len(data) {
+			switch data[pos] {
+			case 0xfc:
+				n = 3
+			case 0xfd:
+				n = 4
+			case 0xfe:
+				n = 9
+			}
+		}
+		if pos+n > len(data) {
+			return 0, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: truncated header of %v bytes", len(data))
+		}
+		v, _, _ := mysql.LengthEncodedInt(data[pos:])
+		pos += n
+		return v, nil
+	}
+	for {
+		fieldType, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if fieldType == payloadHeaderEndMark {
+			break
+		}
+		length, err := next()
+		if err != nil {
+			return nil, err
+		}
+		end := pos + int(length)
+		if length > uint64(len(data)) || end > len(data) {
+			return nil, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: field %v of %v bytes", fieldType, length)
+		}
+		switch fieldType {
+		case payloadSizeField:
+			size, err = next()
+		case payloadCompressionTypeField:
+			p.compressionType, err = next()
+		case payloadUncompressedSizeField:
+			p.uncompressedSize, err = next()
+		}
+		if err != nil {
+			return nil, err
+		}
+		// the fields of later versions are skipped
+		pos = end
+	}
+	p.payload = data[pos:]
+	if size != uint64(len(p.payload)) {
+		return nil, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: payload of %v bytes, %v in its header",
+			len(p.payload), size)
+	}
+	return p, nil
+}
+
+var (
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+	zstdDecoderOnce sync.Once
+)
+
+// events returns the events of the payload, uncompressed. They have no checksum.
+func (p *transactionPayload) events() ([][]byte, error) {
+	var data []byte
+	switch p.compressionType {
+	case payloadCompressionNone:
+		data = p.payload
+	case payloadCompressionZstd:
+		zstdDecoderOnce.Do(func() {
+			// DecodeAll only, which is safe for concurrent use
+			zstdDecoder, zstdDecoderErr = zstd.NewReader(nil)
+		})
+		if zstdDecoderErr != nil {
+			return nil, zstdDecoderErr
+		}
+		var err error
+		data, err = zstdDecoder.DecodeAll(p.payload, make([]byte, 0, p.uncompressedSize))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing a TRANSACTION_PAYLOAD_EVENT: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression type %v of a TRANSACTION_PAYLOAD_EVENT", p.compressionType)
+	}
+	if uint64(len(data)) != p.uncompressedSize {
+		return nil, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: %v bytes uncompressed, %v in its header",
+			len(data), p.uncompressedSize)
+	}
+
+	var events [][]byte
+	for len(data) > 0 {
+		if len(data) < replication.EventHeaderSize {
+			return nil, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: truncated event of %v bytes", len(data))
+		}
+		size := int(binary.LittleEndian.Uint32(data[9:]))
+		if size < replication.EventHeaderSize || size > len(data) {
+			return nil, fmt.Errorf("bad TRANSACTION_PAYLOAD_EVENT: event of %v bytes, %v left", size, len(data))
+		}
+		events = append(events, data[:size])
+		data = data[size:]
+	}
+	return events, nil
+}
+
+// resetPayloadParser sets up the parser of the events of the TRANSACTION_PAYLOAD_EVENTs with the
+// FORMAT_DESCRIPTION_EVENT of a binlog, as the syncer parses the events of the stream, but
+// without the checksum.
+func (b *BinlogReader) resetPayloadParser(ev *replication.BinlogEvent) error {
+	fde, ok := ev.Event.(*replication.FormatDescriptionEvent)
+	if !ok {
+		return fmt.Errorf("unexpected FORMAT_DESCRIPTION_EVENT %T", ev.Event)
+	}
+	raw := append([]byte(nil), ev.RawData...)
+	if fde.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		// the checksum algorithm is just before the checksum
+		raw[len(raw)-replication.BinlogChecksumLength-1] = replication.BINLOG_CHECKSUM_ALG_OFF
+	}
+	parser := replication.NewBinlogParser()
+	// as the syncer. See NewMySQLReader
+	parser.SetUseDecimal(true)
+	if _, err := parser.Parse(raw); err != nil {
+		return fmt.Errorf("parsing the FORMAT_DESCRIPTION_EVENT for the transaction payloads: %v", err)
+	}
+	b.payloadParser = parser
+	return nil
+}
+
+// handleTransactionPayload handles the events of a TRANSACTION_PAYLOAD_EVENT as those of the
+// stream.
+func (b *BinlogReader) handleTransactionPayload(ev *replication.BinlogEvent, entriesChannel chan<- *BinlogEntry) error {
+	generic, ok := ev.Event.(*replication.GenericEvent)
+	if !ok {
+		return fmt.Errorf("unexpected TRANSACTION_PAYLOAD_EVENT %T", ev.Event)
+	}
+	if b.payloadParser == nil {
+		return fmt.Errorf("TRANSACTION_PAYLOAD_EVENT before the FORMAT_DESCRIPTION_EVENT")
+	}
+	payload, err := parseTransactionPayloadEvent(generic.Data)
+	if err != nil {
+		return err
+	}
+	events, err := payload.events()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&b.compressedPayloads, 1)
+	atomic.AddInt64(&b.compressedBytes, int64(len(payload.payload)))
+	atomic.AddInt64(&b.uncompressedBytes, int64(payload.uncompressedSize))
+
+	for _, data := range events {
+		inner, err := b.payloadParser.Parse(data)
+		if err != nil {
+			return fmt.Errorf("parsing an event of the TRANSACTION_PAYLOAD_EVENT at %v:%v: %v",
+				b.currentCoordinates.LogFile, ev.Header.LogPos, err)
+		}
+		// The events of a payload are at the position of the payload, for that of the rows
+		// events (LogPos - EventSize).
+		inner.Header.LogPos = ev.Header.LogPos - ev.Header.EventSize + inner.Header.EventSize
+		if err := b.handleEvent(inner, entriesChannel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BinlogCompressionStat returns the transactions read compressed in the binlog.
+func (b *BinlogReader) BinlogCompressionStat() *models.BinlogCompressionStat {
+	return &models.BinlogCompressionStat{
+		Enabled:           b.mysqlContext.BinlogCompression,
+		Payloads:          atomic.LoadInt64(&b.compressedPayloads),
+		CompressedBytes:   atomic.LoadInt64(&b.compressedBytes),
+		UncompressedBytes: atomic.LoadInt64(&b.uncompressedBytes),
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"fmt"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// Families of column types, which decode to values of the same kind. A change of type within a
+// family (e.g. varchar to text, or a change of the row format) leaves the cached metadata valid.
+// "" matches any type.
+const (
+	familyAny      = ""
+	familyTiny     = "tinyint"
+	familyShort    = "smallint"
+	familyInt24    = "mediumint"
+	familyLong     = "int"
+	familyLonglong = "bigint"
+	familyFloat    = "float"
+	familyDouble   = "double"
+	familyDecimal  = "decimal"
+	familyDate     = "date"
+	familyTime     = "time"
+	familyDatetime = "datetime"
+	familyStamp    = "timestamp"
+	familyYear     = "year"
+	familyBit      = "bit"
+	familyJSON     = "json"
+	familyString   = "string"
+)
+
+// binlogTypeFamily returns the family of a column type of a TABLE_MAP event.
+func binlogTypeFamily(tp byte) string {
+	switch tp {
+	case gomysql.MYSQL_TYPE_TINY:
+		return familyTiny
+	case gomysql.MYSQL_TYPE_SHORT:
+		return familyShort
+	case gomysql.MYSQL_TYPE_INT24:
+		return familyInt24
+	case gomysql.MYSQL_TYPE_LONG:
+		return familyLong
+	case gomysql.MYSQL_TYPE_LONGLONG:
+		return familyLonglong
+	case gomysql.MYSQL_TYPE_FLOAT:
+		return familyFloat
+	case gomysql.MYSQL_TYPE_DOUBLE:
+		return familyDouble
+	case gomysql.MYSQL_TYPE_DECIMAL, gomysql.MYSQL_TYPE_NEWDECIMAL:
+		return familyDecimal
+	case gomysql.MYSQL_TYPE_DATE, gomysql.MYSQL_TYPE_NEWDATE:
+		return familyDate
+	case gomysql.MYSQL_TYPE_TIME, gomysql.MYSQL_TYPE_TIME2:
+		return familyTime
+	case gomysql.MYSQL_TYPE_DATETIME, gomysql.MYSQL_TYPE_DATETIME2:
+		return familyDatetime
+	case gomysql.MYSQL_TYPE_TIMESTAMP, gomysql.MYSQL_TYPE_TIMESTAMP2:
+		return familyStamp
+	case gomysql.MYSQL_TYPE_YEAR:
+		return familyYear
+	case gomysql.MYSQL_TYPE_BIT:
+		return familyBit
+	case gomysql.MYSQL_TYPE_JSON:
+		return familyJSON
+	case gomysql.MYSQL_TYPE_VARCHAR, gomysql.MYSQL_TYPE_VAR_STRING, gomysql.MYSQL_TYPE_STRING,
+		gomysql.MYSQL_TYPE_ENUM, gomysql.MYSQL_TYPE_SET,
+		gomysql.MYSQL_TYPE_TINY_BLOB, gomysql.MYSQL_TYPE_MEDIUM_BLOB, gomysql.MYSQL_TYPE_LONG_BLOB,
+		gomysql.MYSQL_TYPE_BLOB:
+		return familyString
+	default:
+		// e.g. geometry
+		return familyAny
+	}
+}
+
+// columnTypeFamily returns the family of a column of the metadata.
+func columnTypeFamily(tp mysql.ColumnType) string {
+	switch tp {
+	case mysql.TinyintColumnType, mysql.BooleanColumnType:
+		return familyTiny
+	case mysql.SmallintColumnType:
+		return familyShort
+	case mysql.MediumIntColumnType:
+		return familyInt24
+	case mysql.IntColumnType:
+		return familyLong
+	case mysql.BigIntColumnType:
+		return familyLonglong
+	case mysql.FloatColumnType:
+		return familyFloat
+	case mysql.DoubleColumnType:
+		return familyDouble
+	case mysql.DecimalColumnType:
+		return familyDecimal
+	case mysql.DateColumnType:
+		return familyDate
+	case mysql.TimeColumnType:
+		return familyTime
+	case mysql.DateTimeColumnType:
+		return familyDatetime
+	case mysql.TimestampColumnType:
+		return familyStamp
+	case mysql.YearColumnType:
+		return familyYear
+	case mysql.BitColumnType:
+		return familyBit
+	case mysql.JSONColumnType:
+		return familyJSON
+	case mysql.EnumColumnType, mysql.SetColumnType, mysql.CharColumnType, mysql.VarcharColumnType,
+		mysql.TextColumnType, mysql.TinytextColumnType, mysql.BlobColumnType,
+		mysql.BinaryColumnType, mysql.VarbinaryColumnType:
+		return familyString
+	default:
+		return familyAny
+	}
+}
+
+// checkTableLayout returns an error if the columns of a TABLE_MAP event do not match those of
+// the metadata of the table: fewer columns, or a column of another family. More columns are
+// an error unless allowExtra, as on AliRDS, which appends a hidden primary key (see #192).
+func checkTableLayout(tableMap *replication.TableMapEvent, columns []mysql.Column, allowExtra bool) error {
+	n := len(tableMap.ColumnType)
+	if n < len(columns) || (n > len(columns) && !allowExtra) {
+		return fmt.Errorf("%v columns in the binlog, %v in the metadata", n, len(columns))
+	}
+	for i := range columns {
+		binlogFamily := binlogTypeFamily(tableMap.ColumnType[i])
+		metaFamily := columnTypeFamily(columns[i].Type)
+		if binlogFamily != familyAny && metaFamily != familyAny && binlogFamily != metaFamily {
+			return fmt.Errorf("column %v (%v) is %v in the binlog, %v in the metadata",
+				i, columns[i].Name, binlogFamily, metaFamily)
+		}
+	}
+	return nil
+}
+
+// revalidateTableMeta checks the TABLE_MAP of a rows event against the cached metadata of its
+// table, when its layout differs from the last one accepted for the table. The metadata is
+// stale if the structure of the table was changed without a DDL in the binlog the reader
+// handles, e.g. with sql_log_bin=0 or in a skipped statement: it is fetched from the source
+// again, and the table definition is sent again to the applier. If the fetched metadata does not
+// match either, the binlog cannot be decoded safely and an error is returned.
+func (b *BinlogReader) revalidateTableMeta(tableMap *replication.TableMapEvent, table *config.TableContext) error {
+	if table == nil || table.Table == nil || table.Table.OriginalTableColumns == nil {
+		return nil
+	}
+	schemaName := string(tableMap.Schema)
+	tableName := string(tableMap.Table)
+	key := fmt.Sprintf("%v.%v", schemaName, tableName)
+	layout := string(tableMap.ColumnType)
+	if b.tableLayouts == nil {
+		b.tableLayouts = make(map[string]string)
+	}
+	if last, ok := b.tableLayouts[key]; ok && last == layout {
+		return nil
+	}
+
+	mismatch := checkTableLayout(tableMap, table.Table.OriginalTableColumns.Columns, false)
+	if mismatch == nil {
+		b.tableLayouts[key] = layout
+		return nil
+	}
+	b.logger.Warnf("mysql.reader: the binlog layout of %v does not match its cached metadata: %v. fetching the metadata again",
+		key, mismatch)
+
+	if b.loadTableColumns == nil {
+		return fmt.Errorf("cannot fetch the metadata of %v, whose binlog layout does not match the cached one (%v): "+
+			"no connection to the source", key, mismatch)
+	}
+	columns, err := b.loadTableColumns(schemaName, tableName)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the metadata of %v, whose binlog layout does not match the cached one (%v): %v",
+			key, mismatch, err)
+	}
+	if err := checkTableLayout(tableMap, columns.Columns, true); err != nil {
+		return fmt.Errorf("the binlog layout of %v does not match its metadata: %v. "+
+			"its structure was changed without a DDL in the binlog, e.g. with sql_log_bin=0. "+
+			"start the job again from a new snapshot", key, err)
+	}
+
+	table.Table.OriginalTableColumns = columns
+	whereCtx, err := config.NewWhereCtx(table.Table.Where, table.Table)
+	if err != nil {
+		return err
+	}
+	table.WhereCtx = whereCtx
+	table.DefChangedSent = false
+	b.tableLayouts[key] = layout
+	b.logger.Printf("mysql.reader: fetched the metadata of %v again after a change of its binlog layout. columns: %v",
+		key, columns.String())
+	return nil
+}
+
+// forgetTableLayout drops the layout accepted for a table, whose metadata has changed.
+func (b *BinlogReader) forgetTableLayout(schemaName, tableName string) {
+	delete(b.tableLayouts, fmt.Sprintf("%v.%v", schemaName, tableName))
+}
+
+// fetchTableColumns reads the structure of a table from the source into the sqle context, as the
+// extractor does on start, and returns its columns.
+func (b *BinlogReader) fetchTableColumns(schemaName, tableName string) (*mysql.ColumnList, error) {
+	if b.db == nil {
+		return nil, fmt.Errorf("no connection to the source")
+	}
+	stmts, err := base.ShowCreateTable(b.db, schemaName, tableName, false, false)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := sqle.ParseCreateTableStmt("mysql", stmts[0])
+	if err != nil {
+		return nil, err
+	}
+	b.context.AddSchema(schemaName)
+	b.context.LoadTables(schemaName, nil)
+	b.context.DelTable(schemaName, stmt.Table.Name.L)
+	b.context.UseSchema(schemaName)
+	b.context.UpdateContext(stmt, "mysql")
+	return base.GetTableColumnsSqle(b.context, schemaName, tableName)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// MySQL logs an XA transaction in two parts, each with a GTID of its own:
+//   - GTID, XA START xid, the rows, XA END xid, then an XA_PREPARE_LOG_EVENT;
+//   - GTID, then XA COMMIT xid or XA ROLLBACK xid, possibly much later and after other
+//     transactions.
+// XA COMMIT ... ONE PHASE is logged as the first part only, with the one phase flag on the
+// XA_PREPARE_LOG_EVENT, and is replicated as a regular transaction.
+
+// Values of BinlogEntry.XaOp
+const (
+	// the prepare part, with the rows
+	XaOpPrepare = "prepare"
+	// the commit part, committing the prepare part
+	XaOpCommit = "commit"
+	// the commit part, rolling back the prepare part
+	XaOpRollback = "rollback"
+)
+
+// xaPrepareLogEvent is XA_PREPARE_LOG_EVENT of MySQL 5.7, which go-mysql decodes as a
+// GenericEvent.
+const xaPrepareLogEvent replication.EventType = 38
+
+// parseXaPrepareEvent parses the body of an XA_PREPARE_LOG_EVENT: one_phase (1 byte), formatID,
+// gtrid_length and bqual_length (4 bytes each), then gtrid and bqual. The xid is formatted as in
+// the XA statements of the binlog, in lower case.
+func parseXaPrepareEvent(data []byte) (onePhase bool, xid string, err error) {
+	if len(data) < 13 {
+		return false, "", fmt.Errorf("bad XA_PREPARE_LOG_EVENT of %v bytes", len(data))
+	}
+	formatID := int32(binary.LittleEndian.Uint32(data[1:]))
+	gtridLength := int(binary.LittleEndian.Uint32(data[5:]))
+	bqualLength := int(binary.LittleEndian.Uint32(data[9:]))
+	if gtridLength < 0 || bqualLength < 0 || len(data) < 13+gtridLength+bqualLength {
+		return false, "", fmt.Errorf("bad XA_PREPARE_LOG_EVENT: gtrid_length %v, bqual_length %v, %v bytes",
+			gtridLength, bqualLength, len(data))
+	}
+	gtrid := data[13 : 13+gtridLength]
+	bqual := data[13+gtridLength : 13+gtridLength+bqualLength]
+	return data[0] != 0, fmt.Sprintf("x'%x',x'%x',%d", gtrid, bqual, formatID), nil
+}
+
+// parseXaQuery parses the XA statements of the binlog: XA START|END|COMMIT|ROLLBACK xid, and
+// returns the statement in upper case, "COMMIT ONE PHASE" included, and the xid normalized as
+// by parseXaPrepareEvent. ok is false for the other queries.
+func parseXaQuery(query string) (stmt string, xid string, ok bool) {
+	fields := strings.Fields(query)
+	if len(fields) < 3 || !strings.EqualFold(fields[0], "XA") {
+		return "", "", false
+	}
+	stmt = strings.ToUpper(fields[1])
+	switch stmt {
+	case "START", "BEGIN", "END", "COMMIT", "ROLLBACK":
+	default:
+		return "", "", false
+	}
+	fields = fields[2:]
+	if n := len(fields); stmt == "COMMIT" && n > 2 &&
+		strings.EqualFold(fields[n-2], "ONE") && strings.EqualFold(fields[n-1], "PHASE") {
+		stmt, fields = "COMMIT ONE PHASE", fields[:n-2]
+	}
+	// the binlog has the xids as X'gtrid',X'bqual',formatID
+	return stmt, strings.ToLower(strings.Join(fields, "")), true
+}
+
+// handleXaQuery handles the XA statements of the current transaction.
+func (b *BinlogReader) handleXaQuery(stmt string, xid string, entriesChannel chan<- *BinlogEntry) {
+	entry := b.currentBinlogEntry
+	switch stmt {
+	case "START", "BEGIN":
+		// as BEGIN
+		entry.hasBeginQuery = true
+	case "END":
+		// the XA_PREPARE_LOG_EVENT follows
+		return
+	case "COMMIT ONE PHASE":
+		// as COMMIT
+		entriesChannel <- entry
+	case "COMMIT", "ROLLBACK":
+		prepared, ok := b.xaPrepared[xid]
+		delete(b.xaPrepared, xid)
+		if ok {
+			entry.XaPrepared = &prepared.Coordinates
+		} else {
+			// The prepare part is before the start of the replication, e.g. in the snapshot of
+			// the full copy, which does not see the prepared rows.
+			b.logger.Warnf("mysql.reader: XA %v %v prepared before the start of the replication. "+
+				"its rows are not replicated. gtid: %v:%v", stmt, xid, entry.Coordinates.SID, entry.Coordinates.GNO)
+		}
+		switch {
+		case b.mysqlContext.XaPolicy == config.XaPolicyTarget:
+			entry.Xid = xid
+			entry.XaOp = XaOpCommit
+			if stmt == "ROLLBACK" {
+				entry.XaOp = XaOpRollback
+			}
+		case ok && stmt == "COMMIT":
+			entry.Events = prepared.Events
+			entry.OriginalSize += prepared.OriginalSize
+		}
+		entriesChannel <- entry
+	}
+	b.LastAppliedRowsEventHint = b.currentCoordinates
+}
+
+// handleXaPrepare handles the XA_PREPARE_LOG_EVENT ending the prepare part of an XA transaction.
+// With XaPolicyTarget, the prepare part is sent to be prepared on the target. Otherwise it is
+// kept until its XA COMMIT, to be applied as a regular transaction then, or its XA ROLLBACK.
+// Either way, its GTID is applied with the commit part.
+func (b *BinlogReader) handleXaPrepare(ev *replication.BinlogEvent, entriesChannel chan<- *BinlogEntry) error {
+	generic, ok := ev.Event.(*replication.GenericEvent)
+	if !ok {
+		return fmt.Errorf("unexpected XA_PREPARE_LOG_EVENT %T", ev.Event)
+	}
+	onePhase, xid, err := parseXaPrepareEvent(generic.Data)
+	if err != nil {
+		return err
+	}
+	entry := b.currentBinlogEntry
+	if onePhase {
+		// XA COMMIT ... ONE PHASE, as COMMIT
+		entriesChannel <- entry
+		b.LastAppliedRowsEventHint = b.currentCoordinates
+		return nil
+	}
+
+	b.logger.Debugf("mysql.reader: XA PREPARE %v. gtid: %v:%v", xid, entry.Coordinates.SID, entry.Coordinates.GNO)
+	if b.xaPrepared == nil {
+		b.xaPrepared = make(map[string]*BinlogEntry)
+	}
+	if b.mysqlContext.XaPolicy == config.XaPolicyTarget {
+		entry.Xid = xid
+		entry.XaOp = XaOpPrepare
+		entriesChannel <- entry
+		b.xaPrepared[xid] = &BinlogEntry{Coordinates: entry.Coordinates}
+	} else {
+		b.xaPrepared[xid] = entry
+	}
+	b.LastAppliedRowsEventHint = b.currentCoordinates
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// transcodeValues checks the strings of a row, in UTF-8, against the charsets of their target
+// columns, replacing the characters the charsets cannot store or failing by CharsetErrorPolicy.
+// values[i] is the value of the column names[i].
+func transcodeValues(policy string, charsets map[string]string, names []string, values []*interface{}) error {
+	replace := policy == config.CharsetErrorPolicyReplace
+	for i, name := range names {
+		charset, ok := charsets[name]
+		if !ok || i >= len(values) || values[i] == nil {
+			continue
+		}
+		var s string
+		switch v := (*values[i]).(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			continue
+		}
+		transcoded, err := umconf.TranscodeString(s, charset, replace)
+		if err != nil {
+			return fmt.Errorf("column %v: %v. See CharsetErrorPolicy", name, err)
+		}
+		if transcoded == s {
+			continue
+		}
+		if _, ok := (*values[i]).([]byte); ok {
+			*values[i] = []byte(transcoded)
+		} else {
+			*values[i] = transcoded
+		}
+	}
+	return nil
+}
+
+// transcodeDumpEntry checks the rows of the full copy against the target charsets. The structure
+// of a table is read, after the table is created, into the metadata cache.
+func (a *Applier) transcodeDumpEntry(entry *DumpEntry) error {
+	if len(entry.ValuesX) == 0 {
+		return nil
+	}
+	meta, err := a.metaCache.get(a.db, entry.TableSchema, entry.TableName)
+	if err != nil {
+		return err
+	}
+	if len(meta.charsets) == 0 {
+		return nil
+	}
+	names := meta.columns.Names()
+	if entry.Table != nil && entry.Table.OriginalTableColumns != nil {
+		names = entry.Table.OriginalTableColumns.Names()
+	}
+
+	for _, row := range entry.ValuesX {
+		if err := transcodeValues(a.mysqlContext.CharsetErrorPolicy, meta.charsets, names, row); err != nil {
+			return fmt.Errorf("table %v.%v: %v", entry.TableSchema, entry.TableName, err)
+		}
+	}
+	return nil
+}
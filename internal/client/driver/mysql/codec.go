@@ -0,0 +1,563 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	gonats "github.com/nats-io/go-nats"
+	"github.com/satori/go.uuid"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// A transactions message of codec v1 is a gob, as by older versions. A message of codec v2 starts
+// with codecMarker and a byte of the codec. A gob never starts with codecMarker: it starts with
+// its (non-zero) length.
+const (
+	codecMarker = 0
+	codecByteV2 = 2
+)
+
+// codecAckV2 is the reply of an applier to the transactions, telling the extractor it decodes
+// the codec v2. Older appliers reply nothing.
+var codecAckV2 = []byte("codec:v2")
+
+// txCodec encodes the transactions of the incremental replication, before the compression.
+type txCodec interface {
+	// Name is config.CodecV1 or config.CodecV2.
+	Name() string
+	Encode(entries *binlog.BinlogEntries) ([]byte, error)
+	Decode(data []byte, entries *binlog.BinlogEntries) error
+}
+
+// gobCodec is the codec v1: a gob.
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return config.CodecV1
+}
+
+func (gobCodec) Encode(entries *binlog.BinlogEntries) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if err := gob.NewEncoder(b).Encode(entries); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, entries *binlog.BinlogEntries) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(entries)
+}
+
+// compactCodec is the codec v2, a binary format without the type descriptions of gob:
+//   - the lengths and the integers are varints.
+//   - the names (schemas, tables, files, ...) are written once per message, and referred to after.
+//   - a row has a bitmap of its non-NULL columns, followed by their values, each tagged with
+//     its type. A value of another type, and a config.Table, are a gob.
+//   - the values are written once. ValuesPointers are AbstractValues after decoding, as from
+//     the binlog.
+type compactCodec struct{}
+
+func (compactCodec) Name() string {
+	return config.CodecV2
+}
+
+// The type tags of the values of the codec v2.
+const (
+	valInt8 byte = iota + 1
+	valInt16
+	valInt32
+	valInt64
+	valInt
+	valUint8
+	valUint16
+	valUint32
+	valUint64
+	valUint
+	valFloat32
+	valFloat64
+	valString
+	valBytes
+	valBool
+	valGob
+)
+
+// gobValue wraps a value of another type, for gob to write its type.
+type gobValue struct {
+	V interface{}
+}
+
+type compactWriter struct {
+	buf   []byte
+	names map[string]uint64
+	err   error
+}
+
+func (w *compactWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *compactWriter) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *compactWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *compactWriter) str(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// name writes a string repeated among the events: 0 and the string the first time, then the
+// index of its first time plus 1.
+func (w *compactWriter) name(s string) {
+	if i, ok := w.names[s]; ok {
+		w.uvarint(i + 1)
+		return
+	}
+	w.names[s] = uint64(len(w.names))
+	w.uvarint(0)
+	w.str(s)
+}
+
+func (w *compactWriter) gob(v interface{}) {
+	b := new(bytes.Buffer)
+	if err := gob.NewEncoder(b).Encode(v); err != nil && w.err == nil {
+		w.err = err
+	}
+	w.bytes(b.Bytes())
+}
+
+func (w *compactWriter) coordinates(c *base.BinlogCoordinateTx) {
+	w.name(c.LogFile)
+	w.varint(c.LogPos)
+	w.name(c.OSID)
+	w.buf = append(w.buf, c.SID.Bytes()...)
+	w.varint(c.GNO)
+	w.varint(c.LastCommitted)
+	w.varint(c.SeqenceNumber)
+	w.uvarint(uint64(c.Timestamp))
+}
+
+func (w *compactWriter) row(values *umconf.ColumnValues) {
+	if values == nil {
+		w.uvarint(0)
+		return
+	}
+	n := len(values.AbstractValues)
+	w.uvarint(uint64(n) + 1)
+	bitmap := make([]byte, (n+7)/8)
+	for i, v := range values.AbstractValues {
+		if v != nil && *v != nil {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	w.buf = append(w.buf, bitmap...)
+	for _, v := range values.AbstractValues {
+		if v != nil && *v != nil {
+			w.value(*v)
+		}
+	}
+}
+
+func (w *compactWriter) value(v interface{}) {
+	switch v := v.(type) {
+	case int8:
+		w.buf = append(w.buf, valInt8, byte(v))
+	case int16:
+		w.buf = append(w.buf, valInt16)
+		w.varint(int64(v))
+	case int32:
+		w.buf = append(w.buf, valInt32)
+		w.varint(int64(v))
+	case int64:
+		w.buf = append(w.buf, valInt64)
+		w.varint(v)
+	case int:
+		w.buf = append(w.buf, valInt)
+		w.varint(int64(v))
+	case uint8:
+		w.buf = append(w.buf, valUint8, v)
+	case uint16:
+		w.buf = append(w.buf, valUint16)
+		w.uvarint(uint64(v))
+	case uint32:
+		w.buf = append(w.buf, valUint32)
+		w.uvarint(uint64(v))
+	case uint64:
+		w.buf = append(w.buf, valUint64)
+		w.uvarint(v)
+	case uint:
+		w.buf = append(w.buf, valUint)
+		w.uvarint(uint64(v))
+	case float32:
+		w.buf = append(w.buf, valFloat32)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, math.Float32bits(v))
+	case float64:
+		w.buf = append(w.buf, valFloat64)
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+	case string:
+		w.buf = append(w.buf, valString)
+		w.str(v)
+	case []byte:
+		w.buf = append(w.buf, valBytes)
+		w.bytes(v)
+	case bool:
+		if v {
+			w.buf = append(w.buf, valBool, 1)
+		} else {
+			w.buf = append(w.buf, valBool, 0)
+		}
+	default:
+		w.buf = append(w.buf, valGob)
+		w.gob(&gobValue{V: v})
+	}
+}
+
+func (w *compactWriter) event(event *binlog.DataEvent) {
+	w.str(event.Query)
+	w.name(event.CurrentSchema)
+	w.name(event.DatabaseName)
+	w.name(event.TableName)
+	w.name(string(event.DML))
+	w.varint(int64(event.ColumnCount))
+	w.row(event.WhereColumnValues)
+	w.row(event.NewColumnValues)
+	if event.Table == nil {
+		w.uvarint(0)
+	} else {
+		w.uvarint(1)
+		w.gob(event.Table)
+	}
+	w.varint(event.LogPos)
+	w.name(event.RowImage)
+	w.bytes(event.WhereColumnBitmap)
+	w.bytes(event.NewColumnBitmap)
+}
+
+func (compactCodec) Encode(entries *binlog.BinlogEntries) ([]byte, error) {
+	w := &compactWriter{
+		buf:   []byte{codecMarker, codecByteV2},
+		names: make(map[string]uint64),
+	}
+	w.uvarint(uint64(len(entries.Entries)))
+	for _, entry := range entries.Entries {
+		w.coordinates(&entry.Coordinates)
+		w.varint(int64(entry.OriginalSize))
+		w.str(entry.Xid)
+		w.name(entry.XaOp)
+		if entry.XaPrepared == nil {
+			w.uvarint(0)
+		} else {
+			w.uvarint(1)
+			w.coordinates(entry.XaPrepared)
+		}
+		w.uvarint(uint64(len(entry.Events)))
+		for i := range entry.Events {
+			w.event(&entry.Events[i])
+		}
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// compactReader reads the codec v2. The first error is kept, and the reads after it return zeros.
+type compactReader struct {
+	data  []byte
+	names []string
+	err   error
+}
+
+func (r *compactReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("codec v2: "+format, args...)
+	}
+	r.data = nil
+}
+
+func (r *compactReader) next(n uint64) []byte {
+	if uint64(len(r.data)) < n {
+		r.fail("truncated message")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *compactReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail("bad varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *compactReader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail("bad varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// bytes returns a copy, as the message may be reused.
+func (r *compactReader) bytes() []byte {
+	b := r.next(r.uvarint())
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (r *compactReader) str() string {
+	return string(r.next(r.uvarint()))
+}
+
+func (r *compactReader) name() string {
+	i := r.uvarint()
+	if i == 0 {
+		s := r.str()
+		r.names = append(r.names, s)
+		return s
+	}
+	if i > uint64(len(r.names)) {
+		r.fail("bad name %v of %v", i, len(r.names))
+		return ""
+	}
+	return r.names[i-1]
+}
+
+// count reads a number of items of at least min bytes each, bounded by the rest of the message.
+func (r *compactReader) count(min uint64) int {
+	n := r.uvarint()
+	if n*min > uint64(len(r.data)) {
+		r.fail("bad count %v", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *compactReader) gob(v interface{}) {
+	b := r.next(r.uvarint())
+	if r.err != nil {
+		return
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		r.fail("%v", err)
+	}
+}
+
+func (r *compactReader) coordinates(c *base.BinlogCoordinateTx) {
+	c.LogFile = r.name()
+	c.LogPos = r.varint()
+	c.OSID = r.name()
+	if sid := r.next(uuid.Size); sid != nil {
+		copy(c.SID[:], sid)
+	}
+	c.GNO = r.varint()
+	c.LastCommitted = r.varint()
+	c.SeqenceNumber = r.varint()
+	c.Timestamp = uint32(r.uvarint())
+}
+
+func (r *compactReader) row() *umconf.ColumnValues {
+	n := r.uvarint()
+	if n == 0 || r.err != nil {
+		return nil
+	}
+	n--
+	bitmap := r.next((n + 7) / 8)
+	if r.err != nil {
+		return nil
+	}
+	values := make([]interface{}, n)
+	for i := range values {
+		if bitmap[i/8]&(1<<uint(i%8)) != 0 {
+			values[i] = r.value()
+		}
+	}
+	return umconf.ToColumnValues(values)
+}
+
+func (r *compactReader) value() interface{} {
+	switch tag := r.byte(); tag {
+	case valInt8:
+		return int8(r.byte())
+	case valInt16:
+		return int16(r.varint())
+	case valInt32:
+		return int32(r.varint())
+	case valInt64:
+		return r.varint()
+	case valInt:
+		return int(r.varint())
+	case valUint8:
+		return r.byte()
+	case valUint16:
+		return uint16(r.uvarint())
+	case valUint32:
+		return uint32(r.uvarint())
+	case valUint64:
+		return r.uvarint()
+	case valUint:
+		return uint(r.uvarint())
+	case valFloat32:
+		if b := r.next(4); b != nil {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b))
+		}
+	case valFloat64:
+		if b := r.next(8); b != nil {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	case valString:
+		return r.str()
+	case valBytes:
+		if b := r.bytes(); b != nil {
+			return b
+		}
+		return []byte{}
+	case valBool:
+		return r.byte() != 0
+	case valGob:
+		v := &gobValue{}
+		r.gob(v)
+		return v.V
+	default:
+		r.fail("bad value type %v", tag)
+	}
+	return nil
+}
+
+func (r *compactReader) event(event *binlog.DataEvent) {
+	event.Query = r.str()
+	event.CurrentSchema = r.name()
+	event.DatabaseName = r.name()
+	event.TableName = r.name()
+	event.DML = binlog.EventDML(r.name())
+	event.ColumnCount = int(r.varint())
+	event.WhereColumnValues = r.row()
+	event.NewColumnValues = r.row()
+	if r.uvarint() != 0 {
+		event.Table = &config.Table{}
+		r.gob(event.Table)
+	}
+	event.LogPos = r.varint()
+	event.RowImage = r.name()
+	event.WhereColumnBitmap = r.bytes()
+	event.NewColumnBitmap = r.bytes()
+}
+
+func (compactCodec) Decode(data []byte, entries *binlog.BinlogEntries) error {
+	if len(data) < 2 || data[0] != codecMarker || data[1] != codecByteV2 {
+		return fmt.Errorf("codec v2: not a message of the codec")
+	}
+	r := &compactReader{data: data[2:]}
+	// an entry is at least its coordinates
+	n := r.count(uuid.Size)
+	entries.Entries = make([]*binlog.BinlogEntry, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		entry := &binlog.BinlogEntry{}
+		r.coordinates(&entry.Coordinates)
+		entry.OriginalSize = int(r.varint())
+		entry.Xid = r.str()
+		entry.XaOp = r.name()
+		if r.uvarint() != 0 {
+			entry.XaPrepared = &base.BinlogCoordinateTx{}
+			r.coordinates(entry.XaPrepared)
+		}
+		// an event is at least 13 bytes of lengths and references
+		entry.Events = make([]binlog.DataEvent, r.count(13))
+		for j := range entry.Events {
+			r.event(&entry.Events[j])
+		}
+		entries.Entries = append(entries.Entries, entry)
+	}
+	if r.err == nil && len(r.data) != 0 {
+		r.fail("%v bytes after the message", len(r.data))
+	}
+	return r.err
+}
+
+// EncodeEntries encodes the transactions with codec and compresses them. rawSize is the size
+// before the compression.
+func EncodeEntries(entries *binlog.BinlogEntries, codec txCodec, compression string) (msg []byte, rawSize int, err error) {
+	raw, err := codec.Encode(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg, err = compress(raw, compression)
+	return msg, len(raw), err
+}
+
+// DecodeEntries decodes the transactions of a message made by EncodeEntries, or by EncodeWith
+// of older versions, and returns the name of its codec.
+func DecodeEntries(data []byte, entries *binlog.BinlogEntries) (codec string, err error) {
+	raw, err := decompress(data)
+	if err != nil {
+		return "", err
+	}
+	var c txCodec = gobCodec{}
+	if len(raw) > 0 && raw[0] == codecMarker {
+		c = compactCodec{}
+	}
+	return c.Name(), c.Decode(raw, entries)
+}
+
+// txCodec returns the codec of the transactions sent to the applier: the codec v2 once the
+// applier told it decodes it, with the codec v2 supported by the node and not disabled by Codec.
+func (e *Extractor) txCodec() txCodec {
+	if e.mysqlContext.Codec != config.CodecV1 && e.mysqlContext.CodecV2Supported &&
+		atomic.LoadInt32(&e.peerCodecV2) == 1 {
+		return compactCodec{}
+	}
+	return gobCodec{}
+}
+
+// onTxReply takes the codecs the applier advertises in its reply to the transactions.
+func (e *Extractor) onTxReply(reply *gonats.Msg) {
+	if reply == nil || !bytes.Equal(reply.Data, codecAckV2) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&e.peerCodecV2, 0, 1) {
+		e.logger.Infof("mysql.extractor: the applier decodes the codec v2. sending with %v", e.txCodec().Name())
+	}
+}
+
+// txAck returns the reply to the transactions, advertising the codec v2 when supported by the
+// node of the applier.
+func (a *Applier) txAck() []byte {
+	if a.mysqlContext.Codec == config.CodecV1 || !a.mysqlContext.CodecV2Supported {
+		return nil
+	}
+	return codecAckV2
+}
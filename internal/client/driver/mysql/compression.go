@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+// A message compressed by snappy (the default) is sent as it is, as by older versions.
+// Otherwise, it starts with compressionMarker and a byte of the compression. A snappy message
+// never starts with compressionMarker: it starts with the (non-zero) varint length of the gob.
+const (
+	compressionMarker   = 0
+	compressionByteGzip = 'g'
+	compressionByteNone = 'n'
+)
+
+// EncodeWith encodes v with gob and compresses it. rawSize is the size of the gob.
+func EncodeWith(v interface{}, compression string) (msg []byte, rawSize int, err error) {
+	b := new(bytes.Buffer)
+	if err := gob.NewEncoder(b).Encode(v); err != nil {
+		return nil, 0, err
+	}
+	msg, err = compress(b.Bytes(), compression)
+	return msg, b.Len(), err
+}
+
+// compress compresses an encoded message.
+func compress(raw []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "", config.CompressionSnappy:
+		return snappy.Encode(nil, raw), nil
+	case config.CompressionGzip:
+		out := bytes.NewBuffer([]byte{compressionMarker, compressionByteGzip})
+		w := gzip.NewWriter(out)
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case config.CompressionNone:
+		return append([]byte{compressionMarker, compressionByteNone}, raw...), nil
+	default:
+		return nil, fmt.Errorf("unknown compression '%v'", compression)
+	}
+}
+
+// decompress returns the encoded message of a message made by EncodeWith or EncodeEntries.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != compressionMarker {
+		return snappy.Decode(nil, data)
+	}
+	switch data[1] {
+	case compressionByteGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data[2:]))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case compressionByteNone:
+		return data[2:], nil
+	default:
+		return nil, fmt.Errorf("unknown compression of a message: %v", data[1])
+	}
+}
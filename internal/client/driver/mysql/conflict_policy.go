@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+// dumpInsertStatement returns the head and the tail of the statement writing rows of the full copy
+// into schema.table by ConflictPolicy, the rows going in between: "head (...),(...) tail".
+// columns are the escaped names of the columns of the rows, or nil for all the columns of the table.
+// Without the columns, upsert cannot name the columns to update, and replaces the rows instead.
+func dumpInsertStatement(policy, schema, table string, columns []string) (head, tail string) {
+	verb := "insert into"
+	switch policy {
+	case config.ConflictPolicyIgnore:
+		verb = "insert ignore into"
+	case config.ConflictPolicyUpsert:
+		if len(columns) == 0 {
+			verb = "replace into"
+			break
+		}
+		var updates []string
+		for _, name := range columns {
+			updates = append(updates, fmt.Sprintf("%s=values(%s)", name, name))
+		}
+		tail = " on duplicate key update " + strings.Join(updates, ",")
+	case config.ConflictPolicyFail:
+	default:
+		verb = "replace into"
+	}
+	if len(columns) == 0 {
+		return fmt.Sprintf(`%s %s.%s values `, verb, schema, table), tail
+	}
+	return fmt.Sprintf(`%s %s.%s (%s) values `, verb, schema, table, strings.Join(columns, ",")), tail
+}
+
+// conflictsOf returns the number of rows conflicting with the existing rows of the target, of a
+// statement writing n rows by ConflictPolicy, from its affected rows:
+//   - ignore: the ignored rows.
+//   - replace: the deleted rows, more than one for a row conflicting on several unique keys.
+//   - upsert: the updated rows, each counting 2 in the affected rows. A row updated to its current
+//     values counts 0 there, so this is a lower bound.
+func conflictsOf(policy string, n, affected int64) int64 {
+	var count int64
+	switch policy {
+	case config.ConflictPolicyIgnore:
+		count = n - affected
+	case config.ConflictPolicyReplace, config.ConflictPolicyUpsert:
+		count = affected - n
+	}
+	if count < 0 {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// times a transaction is retried after deadlocks among the workers, before the task fails
+	maxDeadlockRetries = 5
+)
+
+var deadlockThreadIdRegexp = regexp.MustCompile(`MySQL thread id (\d+)`)
+
+// tableGate serializes a transaction retried after a deadlock among the workers with the other
+// transactions on its tables. Two workers may deadlock on the rows the writeset does not tell
+// apart, e.g. the gaps and the unique secondary keys.
+type tableGate struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	// "schema.table" -> transactions applying it
+	applying map[string]int
+	// the tables of the transactions applying alone
+	alone map[string]bool
+	// all the transactions applying
+	total int
+}
+
+func newTableGate() *tableGate {
+	g := &tableGate{
+		applying: make(map[string]int),
+		alone:    make(map[string]bool),
+	}
+	g.cond = sync.NewCond(&g.lock)
+	return g
+}
+
+func (g *tableGate) isAlone(tables []string) bool {
+	for _, table := range tables {
+		if g.alone[table] {
+			return true
+		}
+	}
+	return false
+}
+
+// enter waits for the transactions applying alone on any of the tables, and marks the tables applied.
+func (g *tableGate) enter(tables []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for g.isAlone(tables) {
+		g.cond.Wait()
+	}
+	for _, table := range tables {
+		g.applying[table]++
+	}
+	g.total++
+}
+
+// enterAlone is enter, and also waits for the other transactions on the tables, which wait for it.
+func (g *tableGate) enterAlone(tables []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for g.isAlone(tables) {
+		g.cond.Wait()
+	}
+	for _, table := range tables {
+		g.alone[table] = true
+	}
+	for {
+		busy := false
+		for _, table := range tables {
+			if g.applying[table] > 0 {
+				busy = true
+			}
+		}
+		if !busy {
+			break
+		}
+		g.cond.Wait()
+	}
+	for _, table := range tables {
+		g.applying[table]++
+	}
+	g.total++
+}
+
+// leave unmarks the tables of a transaction, and returns the number of the other transactions applying.
+func (g *tableGate) leave(tables []string, alone bool) (others int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, table := range tables {
+		if g.applying[table]--; g.applying[table] <= 0 {
+			delete(g.applying, table)
+		}
+		if alone {
+			delete(g.alone, table)
+		}
+	}
+	g.total--
+	g.cond.Broadcast()
+	return g.total
+}
+
+// applyGated is applyBinlogEvent through the tableGate, alone on the tables or not, and returns the
+// number of the other transactions applying at its end.
+func (a *Applier) applyGated(workerIdx int, binlogEntries []*binlog.BinlogEntry, tables []string,
+	alone bool) (others int, err error) {
+
+	if a.tableGate == nil {
+		return 0, a.applyBinlogEvent(workerIdx, binlogEntries)
+	}
+	if alone {
+		a.tableGate.enterAlone(tables)
+	} else {
+		a.tableGate.enter(tables)
+	}
+	err = a.applyBinlogEvent(workerIdx, binlogEntries)
+	return a.tableGate.leave(tables, alone), err
+}
+
+// tablesOf returns the tables written by the transactions, "schema.table", without duplicates.
+func tablesOf(binlogEntries []*binlog.BinlogEntry) []string {
+	seen := make(map[string]bool)
+	var tables []string
+	for _, binlogEntry := range binlogEntries {
+		for i := range binlogEntry.Events {
+			event := &binlogEntry.Events[i]
+			if event.DML == binlog.NotDML {
+				continue
+			}
+			table := fmt.Sprintf("%s.%s", event.DatabaseName, event.TableName)
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables
+}
+
+// parseDeadlockThreads returns the thread ids of the transactions of the latest detected deadlock
+// of SHOW ENGINE INNODB STATUS.
+func parseDeadlockThreads(status string) []int64 {
+	i := strings.Index(status, "LATEST DETECTED DEADLOCK")
+	if i < 0 {
+		return nil
+	}
+	status = status[i:]
+	if j := strings.Index(status, "\nTRANSACTIONS\n"); j >= 0 {
+		status = status[:j]
+	}
+	var ids []int64
+	for _, match := range deadlockThreadIdRegexp.FindAllStringSubmatch(status, -1) {
+		if id, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// deadlockAmongWorkers tells whether the deadlock of the worker was with another worker, by the
+// thread ids of the latest detected deadlock of SHOW ENGINE INNODB STATUS. Without it, e.g. without
+// the PROCESS privilege, it is told by whether other workers were applying transactions.
+func (a *Applier) deadlockAmongWorkers(workerIdx int, others int) bool {
+	a.workersLock.RLock()
+	workerIds := make(map[int64]bool)
+	for _, conn := range a.dbs {
+		if conn.Id != 0 {
+			workerIds[conn.Id] = true
+		}
+	}
+	self := a.dbs[workerIdx].Id
+	a.workersLock.RUnlock()
+
+	var dummy, dummy2, status string
+	err := a.db.QueryRow("SHOW ENGINE INNODB STATUS").Scan(&dummy, &dummy2, &status)
+	ids := parseDeadlockThreads(status)
+	hasSelf := false
+	for _, id := range ids {
+		if id == self {
+			hasSelf = true
+		}
+	}
+	if err != nil || self == 0 || !hasSelf {
+		a.logger.Debugf("mysql.applier: the deadlock is not in the innodb status (err: %v). other workers applying: %v",
+			err, others)
+		return others > 0
+	}
+	for _, id := range ids {
+		if !workerIds[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// countDeadlock counts a deadlock of a worker, and tells whether it was among the workers, for the
+// transactions to be retried.
+func (a *Applier) countDeadlock(workerIdx int, others int, err error) bool {
+	if a.deadlockAmongWorkers(workerIdx, others) {
+		atomic.AddInt64(&a.workerDeadlocks, 1)
+		return true
+	}
+	atomic.AddInt64(&a.externalDeadlocks, 1)
+	a.logger.Warnf("mysql.applier: deadlock with another session of the target. err: %v", err)
+	return false
+}
+
+func (a *Applier) deadlockStat() *models.DeadlockStat {
+	return &models.DeadlockStat{
+		Workers:  atomic.LoadInt64(&a.workerDeadlocks),
+		External: atomic.LoadInt64(&a.externalDeadlocks),
+		Retries:  atomic.LoadInt64(&a.deadlockRetries),
+	}
+}
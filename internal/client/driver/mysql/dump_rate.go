@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync"
+	"time"
+)
+
+// dumpLimiter caps the bytes per second of the rows read by the dumpers, for DumpMaxMBPerSec.
+// It is a token bucket of a second of bytes. A chunk is read before its bytes are known, so
+// the bucket may go into debt, which the next chunk waits for.
+type dumpLimiter struct {
+	rate float64 // bytes per second
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newDumpLimiter returns nil, no limit, for mbPerSec <= 0.
+func newDumpLimiter(mbPerSec int) *dumpLimiter {
+	if mbPerSec <= 0 {
+		return nil
+	}
+	rate := float64(mbPerSec) * 1024 * 1024
+	return &dumpLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// reserve takes n bytes and returns how long to wait before reading more.
+func (l *dumpLimiter) reserve(n int64, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens += elapsed * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait takes n bytes and waits for the rate, or until stopCh is closed. A nil limiter does not
+// wait.
+func (l *dumpLimiter) wait(n int64, stopCh <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	d := l.reserve(n, time.Now())
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stopCh:
+	}
+}
+
+// rowsBytes returns the bytes of the values of the rows of a chunk.
+func rowsBytes(rows [][]*interface{}) int64 {
+	var n int64
+	for _, row := range rows {
+		for _, v := range row {
+			if b, ok := (*v).([]byte); ok {
+				n += int64(len(b))
+			}
+		}
+	}
+	return n
+}
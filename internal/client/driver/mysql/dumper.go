@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/actiontech/dtle/internal/config"
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
 )
 
 type dumper struct {
@@ -42,6 +44,9 @@ type dumper struct {
 	// 0: don't checksum; 1: checksum once; 2: checksum every time
 	doChecksum int
 	oldWayDump bool
+
+	// for DumpMaxMBPerSec. nil for no limit
+	limiter *dumpLimiter
 }
 
 func NewDumper(db usql.QueryAble, table *config.Table, chunkSize int64,
@@ -75,6 +80,51 @@ func NewDumper(db usql.QueryAble, table *config.Table, chunkSize int64,
 type dumpStatResult struct {
 	Gtid       string
 	TotalCount int64
+	// The extractor stops after the full copy. The applier stops once the rows are applied.
+	SkipIncrementalCopy bool
+}
+
+// copyStat counts the rows copied per table in the full copy.
+type copyStat struct {
+	lock   sync.Mutex
+	tables map[string]*models.TableCopyStat
+}
+
+func newCopyStat() *copyStat {
+	return &copyStat{tables: make(map[string]*models.TableCopyStat)}
+}
+
+func (s *copyStat) add(schema, table string, rows int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := fmt.Sprintf("%v.%v", schema, table)
+	stat, ok := s.tables[key]
+	if !ok {
+		stat = &models.TableCopyStat{TableSchema: schema, TableName: table}
+		s.tables[key] = stat
+	}
+	stat.RowsCopied += rows
+}
+
+// stats returns a copy of the counts sorted by table, or nil if nothing is copied.
+func (s *copyStat) stats() []*models.TableCopyStat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.tables) == 0 {
+		return nil
+	}
+	result := make([]*models.TableCopyStat, 0, len(s.tables))
+	for _, stat := range s.tables {
+		statCopy := *stat
+		result = append(result, &statCopy)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TableSchema != result[j].TableSchema {
+			return result[i].TableSchema < result[j].TableSchema
+		}
+		return result[i].TableName < result[j].TableName
+	})
+	return result
 }
 
 type DumpEntry struct {
@@ -84,6 +134,9 @@ type DumpEntry struct {
 	TableName                string
 	TableSchema              string
 	TbSQL                    []string
+	// the table created by TbSQL, with the names on the target. Empty for an entry of rows.
+	CreateTableSchema string
+	CreateTableName   string
 	// For each `*interface{}` item, it is ensured to be not nil.
 	// If field is sql-NULL, *item is nil. Else, *item is a `[]byte`.
 	// TODO can we just use interface{}? Make sure it is not copied again and again.
@@ -93,6 +146,14 @@ type DumpEntry struct {
 	colBuffer  bytes.Buffer
 	err        error
 	Table      *config.Table
+	// the checkpoint after the rows of the entry are applied. nil for a schema entry
+	Progress *models.DumpProgress
+	// the watermarks of ConcurrentIncrementalCopy, in entries with no rows: HandoffStart with the
+	// tables to be copied, before the binlog is streamed, then HandoffTable once each is copied.
+	// The tables are "schema.table" on the target.
+	HandoffStart  bool
+	HandoffTables []string
+	HandoffTable  string
 }
 
 func (e *DumpEntry) incrementCounter() {
@@ -248,9 +309,9 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 	d.table.Iteration += 1
 	rows, err := d.db.Query(query)
 	if err != nil {
-		d.logger.Debugf("mysql.dumper. error at select chunk. query: ", query)
+		d.logger.Debugf("mysql.dumper. error at select chunk. query: %v", query)
 		newErr := fmt.Errorf("mysql.dumper. error at select chunk. err: %v", err)
-		d.logger.Errorf(newErr.Error())
+		d.logger.Errorf("%v", newErr)
 		return 0, err
 	}
 
@@ -285,6 +346,7 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 	}
 
 	d.logger.Debugf("getChunkData. n_row: %d", entry.RowsCount)
+	d.limiter.wait(rowsBytes(entry.ValuesX), d.shutdownCh)
 
 	if entry.RowsCount > 0 {
 		var lastVals []string
@@ -307,6 +369,14 @@ func (d *dumper) getChunkData() (nRows int64, err error) {
 			d.logger.Debugf("GetLastMaxVal: got %v", d.table.UseUniqueKey.LastMaxVals)
 		}
 	}
+	entry.Progress = &models.DumpProgress{
+		TableSchema: d.TableSchema,
+		TableName:   d.TableName,
+		Iteration:   d.table.Iteration,
+	}
+	if d.table.UseUniqueKey != nil {
+		entry.Progress.LastMaxVals = append([]string{}, d.table.UseUniqueKey.LastMaxVals...)
+	}
 	if d.table.TableRename != "" {
 		entry.TableName = d.table.TableRename
 	}
@@ -27,7 +27,9 @@ func TestDumper2000(t *testing.T) {
 	mysqlCtx.SetDefault()
 
 	i := NewInspector(mysqlCtx, logger)
-	u.PanicIfErr(i.InitDBConnections())
+	if err := i.InitDBConnections(); err != nil {
+		t.Skip(err)
+	}
 	table := config.NewTable("tpcc1", "order_line")
 	i.ValidateOriginalTable("tpcc1", "order_line", table)
 
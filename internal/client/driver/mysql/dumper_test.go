@@ -17,8 +17,8 @@ import (
 func TestNewDumper(t *testing.T) {
 	type args struct {
 		db        *sql.Tx
-		dbName    string
-		tableName string
+		table     *config.Table
+		chunkSize int64
 		logger    *log.Entry
 	}
 	tests := []struct {
@@ -30,7 +30,7 @@ func TestNewDumper(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewDumper(tt.args.db, tt.args.dbName, tt.args.tableName, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
+			if got := NewDumper(tt.args.db, tt.args.table, tt.args.chunkSize, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewDumper() = %v, want %v", got, tt.want)
 			}
 		})
@@ -52,79 +52,22 @@ func Test_dumpEntry_incrementCounter(t *testing.T) {
 }
 
 func Test_dumper_Dump(t *testing.T) {
-	type args struct {
-		w int
-	}
 	tests := []struct {
 		name    string
 		d       *dumper
-		args    args
 		wantErr bool
 	}{
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.d.Dump(tt.args.w); (err != nil) != tt.wantErr {
+			if err := tt.d.Dump(); (err != nil) != tt.wantErr {
 				t.Errorf("dumper.Dump() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func Test_showDatabases(t *testing.T) {
-	type args struct {
-		db *sql.DB
-	}
-	tests := []struct {
-		name    string
-		args    args
-		want    []string
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := showDatabases(tt.args.db)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("showDatabases() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("showDatabases() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_showTables(t *testing.T) {
-	type args struct {
-		db     *sql.DB
-		dbName string
-	}
-	tests := []struct {
-		name       string
-		args       args
-		wantTables []*config.Table
-		wantErr    bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gotTables, err := showTables(tt.args.db, tt.args.dbName)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("showTables() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(gotTables, tt.wantTables) {
-				t.Errorf("showTables() = %v, want %v", gotTables, tt.wantTables)
-			}
-		})
-	}
-}
-
 func Test_dumper_Close(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+)
+
+const (
+	// how long the watchdog may take to kill a statement
+	execKillTimeout = 10 * time.Second
+)
+
+// ExecTimeoutError is the error of a statement of the applier killed after MaxExecTime.
+type ExecTimeoutError struct {
+	Schema string
+	Table  string
+	// row events of the transaction
+	Rows     int
+	Duration time.Duration
+	Err      error
+}
+
+func (e *ExecTimeoutError) Error() string {
+	return fmt.Sprintf("statement on %v.%v (transaction of %v rows) killed after %v, over MaxExecTime: %v",
+		e.Schema, e.Table, e.Rows, e.Duration.Round(time.Millisecond), e.Err)
+}
+
+// execWatchdog kills the statements of the applier running for longer than a timeout, with
+// KILL QUERY on another connection of the pool of the target. MySQL has max_execution_time
+// only for SELECT.
+type execWatchdog struct {
+	timeout time.Duration
+
+	lock    sync.Mutex
+	watches map[*execWatch]struct{}
+	// cancels the kills in progress on stop
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// execWatch is a statement watched by the watchdog.
+type execWatch struct {
+	timer  *time.Timer
+	killed int32 // accessed atomically
+}
+
+func newExecWatchdog(timeout time.Duration) *execWatchdog {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &execWatchdog{
+		timeout: timeout,
+		watches: make(map[*execWatch]struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// watch starts watching a statement running on the connection connId. After the timeout, the
+// statement is killed by kill, which is given the connection id.
+func (w *execWatchdog) watch(connId int64, kill func(ctx context.Context, connId int64) error,
+	onError func(err error)) *execWatch {
+
+	watch := &execWatch{}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	watch.timer = time.AfterFunc(w.timeout, func() {
+		atomic.StoreInt32(&watch.killed, 1)
+		ctx, cancel := context.WithTimeout(w.ctx, execKillTimeout)
+		defer cancel()
+		if err := kill(ctx, connId); err != nil {
+			onError(err)
+		}
+	})
+	w.watches[watch] = struct{}{}
+	return watch
+}
+
+// done stops watching a statement, and tells whether it was killed.
+func (w *execWatchdog) done(watch *execWatch) bool {
+	watch.timer.Stop()
+	w.lock.Lock()
+	delete(w.watches, watch)
+	w.lock.Unlock()
+	return atomic.LoadInt32(&watch.killed) == 1
+}
+
+// stop stops watching all the statements, and cancels the kills in progress.
+func (w *execWatchdog) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for watch := range w.watches {
+		watch.timer.Stop()
+		delete(w.watches, watch)
+	}
+	w.cancel()
+}
+
+// prepareExecTimeout sets max_execution_time on the session of the connection of a worker where
+// supported (MySQL 5.7.8+), which bounds the SELECTs.
+// It must be called with DbMutex held, out of a transaction.
+func (a *Applier) prepareExecTimeout(conn *sql.Conn) error {
+	if a.execWatchdog == nil || conn.ExecTimeSet {
+		return nil
+	}
+	conn.ExecTimeSet = true
+	query := fmt.Sprintf("SET @@session.max_execution_time = %d", a.execWatchdog.timeout/time.Millisecond)
+	if _, err := conn.Db.ExecContext(context.Background(), query); err != nil {
+		a.logger.Debugf("mysql.applier: max_execution_time is not supported on the target: %v", err)
+	}
+	return nil
+}
+
+// execTimed runs a statement of a transaction of rows events on the connection of a worker,
+// killing it after MaxExecTime. The error of a killed statement is an *ExecTimeoutError.
+// It must be called with DbMutex held.
+func (a *Applier) execTimed(conn *sql.Conn, schema, table string, rows int,
+	exec func() (gosql.Result, error)) (gosql.Result, error) {
+
+	if a.execWatchdog == nil || conn.Id == 0 {
+		return exec()
+	}
+	// The pool is replaced by reconnectTarget only with all the DbMutex held.
+	db := a.db
+	start := time.Now()
+	watch := a.execWatchdog.watch(conn.Id, func(ctx context.Context, connId int64) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", connId))
+		return err
+	}, func(err error) {
+		a.logger.Warnf("mysql.applier: failed to kill the statement on %v.%v after MaxExecTime: %v", schema, table, err)
+	})
+	r, err := exec()
+	if !a.execWatchdog.done(watch) || err == nil {
+		return r, err
+	}
+	atomic.AddInt64(&a.execTimeouts, 1)
+	timeoutErr := &ExecTimeoutError{Schema: schema, Table: table, Rows: rows, Duration: time.Since(start), Err: err}
+	a.logger.Warnf("mysql.applier: killed a statement on %v.%v after %v. rows of the transaction: %v. ExecTimeoutPolicy: %v",
+		schema, table, timeoutErr.Duration.Round(time.Millisecond), rows, a.mysqlContext.ExecTimeoutPolicy)
+	return r, timeoutErr
+}
+
+// applyErrorState returns the state of the task failed by an error of the replay.
+func (a *Applier) applyErrorState(err error) int {
+	if _, ok := err.(*ExecTimeoutError); ok && a.mysqlContext.ExecTimeoutPolicy != config.ExecTimeoutPolicyFail {
+		return TaskStateRestart
+	}
+	return TaskStateDead
+}
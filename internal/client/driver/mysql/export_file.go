@@ -0,0 +1,225 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/parquet"
+)
+
+const (
+	// exportTmpSuffix is of the files being written. They are renamed once complete.
+	exportTmpSuffix = ".tmp"
+	// exportRowGroupBytes caps the values of the rows of a Parquet file buffered in memory, before
+	// they are written as a row group.
+	exportRowGroupBytes = 64 * 1024 * 1024
+	// csvNull is NULL in a CSV file. The values are quoted, so it is not a string.
+	csvNull = `\N`
+)
+
+// exportColumn is a column of an exported table.
+type exportColumn struct {
+	name   string
+	binary bool
+}
+
+// exportTableWriter writes the rows of a table of the export to files in dir, completing a file
+// once past maxSize bytes and starting the next one. The files are written under a temporary name,
+// and renamed once complete, so that a file by its final name is never partial.
+type exportTableWriter struct {
+	dir     string
+	format  string
+	schema  string
+	table   string
+	columns []exportColumn
+	maxSize int64
+
+	seq   int
+	cur   *exportFile
+	files []*models.ExportFileStat
+}
+
+func newExportTableWriter(dir, format, schema, table string, columns []exportColumn, maxSize int64) *exportTableWriter {
+	return &exportTableWriter{
+		dir:     dir,
+		format:  format,
+		schema:  schema,
+		table:   table,
+		columns: columns,
+		maxSize: maxSize,
+	}
+}
+
+// exportFile is a file being written. The bytes are hashed as they are written.
+type exportFile struct {
+	name  string
+	file  *os.File
+	buf   *bufio.Writer
+	hash  hash.Hash
+	bytes int64
+	rows  int64
+	pq    *parquet.Writer // with ExportFormatParquet
+}
+
+func (f *exportFile) Write(p []byte) (int, error) {
+	n, err := f.buf.Write(p)
+	f.hash.Write(p[:n])
+	f.bytes += int64(n)
+	return n, err
+}
+
+// size returns the bytes of the file, as they will be written.
+func (f *exportFile) size() int64 {
+	if f.pq != nil {
+		return f.bytes + f.pq.Buffered()
+	}
+	return f.bytes
+}
+
+func (w *exportTableWriter) open() error {
+	w.seq++
+	ext := "csv"
+	if w.format == config.ExportFormatParquet {
+		ext = "parquet"
+	}
+	name := fmt.Sprintf("%s.%s.%06d.%s", w.schema, w.table, w.seq, ext)
+	file, err := os.OpenFile(filepath.Join(w.dir, name+exportTmpSuffix), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f := &exportFile{name: name, file: file, buf: bufio.NewWriter(file), hash: sha256.New()}
+	w.cur = f
+	if w.format == config.ExportFormatParquet {
+		columns := make([]parquet.Column, len(w.columns))
+		for i, c := range w.columns {
+			columns[i] = parquet.Column{Name: c.name, UTF8: !c.binary}
+		}
+		f.pq, err = parquet.NewWriter(f, columns)
+		return err
+	}
+	header := make([][]byte, len(w.columns))
+	for i, c := range w.columns {
+		header[i] = []byte(c.name)
+	}
+	_, err = f.Write(csvRow(header))
+	return err
+}
+
+// write writes a row, a value per column, nil for NULL.
+func (w *exportTableWriter) write(row [][]byte) error {
+	if w.cur == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	f := w.cur
+	if f.pq != nil {
+		if err := f.pq.Write(row); err != nil {
+			return err
+		}
+		if f.pq.Buffered() >= exportRowGroupBytes {
+			if err := f.pq.Flush(); err != nil {
+				return err
+			}
+		}
+	} else if _, err := f.Write(csvRow(row)); err != nil {
+		return err
+	}
+	f.rows++
+	if f.size() >= w.maxSize {
+		return w.complete()
+	}
+	return nil
+}
+
+// complete completes the current file: it is synced, then renamed.
+func (w *exportTableWriter) complete() error {
+	f := w.cur
+	if f.pq != nil {
+		if err := f.pq.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	path := filepath.Join(w.dir, f.name)
+	if err := os.Rename(path+exportTmpSuffix, path); err != nil {
+		return err
+	}
+	w.cur = nil
+	w.files = append(w.files, &models.ExportFileStat{
+		File:        f.name,
+		TableSchema: w.schema,
+		TableName:   w.table,
+		Rows:        f.rows,
+		Bytes:       f.bytes,
+		Sha256:      hex.EncodeToString(f.hash.Sum(nil)),
+	})
+	return nil
+}
+
+// close completes the current file and returns the files of the table. A table of no rows has a
+// file of no rows.
+func (w *exportTableWriter) close() ([]*models.ExportFileStat, error) {
+	if w.cur == nil && len(w.files) == 0 {
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	}
+	if w.cur != nil {
+		if err := w.complete(); err != nil {
+			return nil, err
+		}
+	}
+	return w.files, nil
+}
+
+// abort removes the file being written, e.g. after an error.
+func (w *exportTableWriter) abort() {
+	if w.cur == nil {
+		return
+	}
+	w.cur.file.Close()
+	os.Remove(filepath.Join(w.dir, w.cur.name+exportTmpSuffix))
+	w.cur = nil
+}
+
+// csvRow encodes a row as a line of CSV (RFC 4180): each value quoted, NULL as csvNull unquoted.
+func csvRow(row [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, v := range row {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if v == nil {
+			buf.WriteString(csvNull)
+			continue
+		}
+		buf.WriteByte('"')
+		buf.Write(bytes.Replace(v, []byte(`"`), []byte(`""`), -1))
+		buf.WriteByte('"')
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
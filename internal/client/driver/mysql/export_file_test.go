@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+func TestExportTableWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	columns := []exportColumn{{name: "id"}, {name: "note"}, {name: "data", binary: true}}
+
+	// each file past 40 bytes
+	w := newExportTableWriter(dir, config.ExportFormatCSV, "db1", "t1", columns, 40)
+	for _, row := range [][][]byte{
+		{[]byte("1"), []byte(`say "hi"`), nil},
+		{[]byte("2"), nil, []byte{0, '\n'}},
+		{[]byte("3"), []byte(""), []byte("x")},
+	} {
+		if err := w.write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := w.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Rows != 1 || files[1].Rows != 2 {
+		t.Fatalf("files %+v, want 2 of 1 and 2 rows", files)
+	}
+	first, err := ioutil.ReadFile(filepath.Join(dir, "db1.t1.000001.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\"id\",\"note\",\"data\"\r\n\"1\",\"say \"\"hi\"\"\",\\N\r\n"; string(first) != want {
+		t.Errorf("db1.t1.000001.csv = %q, want %q", first, want)
+	}
+	sum := sha256.Sum256(first)
+	if files[0].Sha256 != hex.EncodeToString(sum[:]) || files[0].Bytes != int64(len(first)) {
+		t.Errorf("file stat %+v, want sha256 %x of %v bytes", files[0], sum, len(first))
+	}
+
+	// a table of no rows, then a failed one
+	if files, err := newExportTableWriter(dir, config.ExportFormatParquet, "db1", "t2", columns, 40).close(); err != nil || len(files) != 1 || files[0].Rows != 0 {
+		t.Errorf("close() of no rows = %+v, %v, want a file of no rows", files, err)
+	}
+	w = newExportTableWriter(dir, config.ExportFormatParquet, "db1", "t3", columns, 1<<20)
+	if err := w.write([][]byte{[]byte("1"), nil, nil}); err != nil {
+		t.Fatal(err)
+	}
+	w.abort()
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	sort.Strings(names)
+	if want := []string{"db1.t1.000001.csv", "db1.t1.000002.csv", "db1.t2.000001.parquet"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files %v, want %v", names, want)
+	}
+}
+
+func TestDumpLimiter(t *testing.T) {
+	if l := newDumpLimiter(0); l != nil {
+		t.Errorf("newDumpLimiter(0) = %v, want no limit", l)
+	}
+	l := newDumpLimiter(1)
+	now := l.last
+	if d := l.reserve(512*1024, now); d != 0 {
+		t.Errorf("reserve() within the burst = %v, want 0", d)
+	}
+	// 1.5MB in all, over the burst of 1MB: the debt of 0.5MB takes half a second
+	if d := l.reserve(1024*1024, now); d != time.Second/2 {
+		t.Errorf("reserve() past the burst = %v, want 500ms", d)
+	}
+	if d := l.reserve(0, now.Add(time.Second)); d != 0 {
+		t.Errorf("reserve() after the debt = %v, want 0", d)
+	}
+}
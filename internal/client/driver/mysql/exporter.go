@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	gosql "database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// ExportManifest is written in the dir of an export once all its files are complete: the
+	// ExportStat of the export, as JSON.
+	ExportManifest = "manifest.json"
+	// exportSnapshotRounds is how many times the GTID set of the snapshot is read before and after
+	// it is started, until they match.
+	exportSnapshotRounds = 10
+)
+
+// Exporter is the Export task. It writes the tables of ReplicateDoDb to files, as of a consistent
+// snapshot, and completes. It is scheduled as any job, e.g. as a periodic one.
+//
+// The files of an export are in a dir of their own, "<job>-<start time>" in the dir of ExportURI.
+// On an error or a shutdown, the dir is removed: an export is complete, or not at all. A file is
+// named "<schema>.<table>.<sequence>.<csv|parquet>", and the values are the text MySQL returns,
+// the binary values as they are.
+type Exporter struct {
+	logger       *log.Entry
+	subject      string
+	mysqlContext *config.MySQLDriverConfig
+	// inspects the tables, and holds the connections and the dumpers
+	extractor *Extractor
+
+	waitCh       chan *models.WaitResult
+	shutdown     bool
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+
+	statLock sync.Mutex
+	stat     *models.ExportStat
+}
+
+func NewExporter(subject string, cfg *config.MySQLDriverConfig, logger *log.Logger,
+	eventEmitter func(message string, args ...interface{})) (*Exporter, error) {
+
+	e, err := NewExtractor(subject, "", 0, cfg, logger, eventEmitter)
+	if err != nil {
+		return nil, err
+	}
+	// the tables need no primary key to be exported
+	e.exporting = true
+	return &Exporter{
+		logger:       e.logger,
+		subject:      subject,
+		mysqlContext: e.mysqlContext,
+		extractor:    e,
+		waitCh:       make(chan *models.WaitResult, 1),
+		shutdownCh:   make(chan struct{}),
+	}, nil
+}
+
+func (x *Exporter) Run() {
+	x.logger.Printf("mysql.exporter: exporting the tables of %s:%d (%v) as %v",
+		x.mysqlContext.ConnectionConfig.Host, x.mysqlContext.ConnectionConfig.Port,
+		x.mysqlContext.ExportFrom, x.mysqlContext.ExportFormat)
+	if err := x.export(); err != nil {
+		x.onError(TaskStateDead, err)
+		return
+	}
+	x.onError(TaskStateComplete, nil)
+}
+
+func (x *Exporter) export() (err error) {
+	cfg := x.mysqlContext
+	if err := cfg.ValidateExport(); err != nil {
+		return err
+	}
+	if cfg.ExportURI == "" && cfg.DataDir == "" {
+		return fmt.Errorf("the Export task needs ExportURI without an alloc dir")
+	}
+	dir, err := cfg.ExportDir(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	if cfg.ExportFrom == config.ExportFromTarget {
+		if cfg.ReplicateDoDb, cfg.ReplicateIgnoreDb, err = cfg.TargetTableFilters(); err != nil {
+			return err
+		}
+		// the names are those of the target already
+		cfg.TargetSchemaMap, cfg.TargetSchemaPrefix, cfg.TargetSchemaSuffix = nil, "", ""
+	}
+
+	e := x.extractor
+	e.startup.enter(startupPhaseSourceInspection)
+	if err := e.connectSource(startupPhaseSourceInspection, e.initiateInspector, e.closeInspectorDB); err != nil {
+		return err
+	}
+	e.startup.enter(startupPhaseSourceConnection)
+	if err := e.connectSource(startupPhaseSourceConnection, e.initDBConnections, e.closeSourceDBs); err != nil {
+		return err
+	}
+	e.startup.enter(startupPhaseMetadataFetch)
+	tx, gtid, err := x.startSnapshot()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := e.getSchemaTablesAndMeta(); err != nil {
+		return err
+	}
+	e.startup.markStarted()
+
+	runDir := filepath.Join(dir, fmt.Sprintf("%s-%s", x.subject, time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			x.logger.Warnf("mysql.exporter: removing the incomplete export %v", runDir)
+			if errRemove := os.RemoveAll(runDir); errRemove != nil {
+				x.logger.Errorf("mysql.exporter: failed to remove %v: %v", runDir, errRemove)
+			}
+		}
+	}()
+	x.statLock.Lock()
+	x.stat = &models.ExportStat{Dir: runDir, Format: cfg.ExportFormat, Gtid: gtid}
+	x.statLock.Unlock()
+
+	for _, db := range e.replicateDoDb {
+		for _, t := range db.Tables {
+			if strings.ToLower(t.TableType) == "view" {
+				x.logger.Printf("mysql.exporter: skipping view %s.%s", t.TableSchema, t.TableName)
+				continue
+			}
+			if err := x.exportTable(tx, t, runDir); err != nil {
+				return fmt.Errorf("exporting %s.%s: %v", t.TableSchema, t.TableName, err)
+			}
+		}
+	}
+
+	x.statLock.Lock()
+	x.stat.Complete = true
+	manifest, err := json.MarshalIndent(x.stat, "", "  ")
+	x.statLock.Unlock()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(runDir, ExportManifest)
+	if err := ioutil.WriteFile(path+exportTmpSuffix, manifest, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+exportTmpSuffix, path); err != nil {
+		return err
+	}
+	x.logger.Printf("mysql.exporter: exported %d tables to %v, as of %v", e.tableCount, runDir, gtid)
+	return nil
+}
+
+// startSnapshot starts a transaction with a consistent snapshot, and returns it with the GTID set
+// of the snapshot. As in mysqlDump, the GTID set is read before and after the snapshot is started,
+// until they match, i.e. no transaction committed in between. It is empty if they never do.
+func (x *Exporter) startSnapshot() (*gosql.Tx, string, error) {
+	db := x.extractor.singletonDB
+	for round := 1; ; round++ {
+		var before, after string
+		if err := db.QueryRow("select @@global.gtid_executed").Scan(&before); err != nil {
+			return nil, "", err
+		}
+		// TODO it seems that two 'start transaction' will be sent, as in mysqlDump.
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := tx.Exec("START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			tx.Rollback()
+			return nil, "", err
+		}
+		if err := tx.QueryRow("select @@global.gtid_executed").Scan(&after); err != nil {
+			tx.Rollback()
+			return nil, "", err
+		}
+		if before == after {
+			x.logger.Printf("mysql.exporter: started the snapshot at %v", after)
+			return tx, after, nil
+		}
+		if round == exportSnapshotRounds {
+			x.logger.Warnf("mysql.exporter: the GTID set changed while starting the snapshot in %d rounds. "+
+				"exporting the snapshot without its GTID set", round)
+			return tx, "", nil
+		}
+		tx.Rollback()
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// exportTable writes the rows of a table to files, by the chunks of a dumper.
+func (x *Exporter) exportTable(tx *gosql.Tx, t *config.Table, dir string) (err error) {
+	e := x.extractor
+	x.logger.Printf("mysql.exporter: exporting table %s.%s", t.TableSchema, t.TableName)
+	var columns []exportColumn
+	for i := range t.OriginalTableColumns.Columns {
+		col := &t.OriginalTableColumns.Columns[i]
+		columns = append(columns, exportColumn{name: col.Name, binary: isBinaryColumn(col)})
+	}
+	w := newExportTableWriter(dir, x.mysqlContext.ExportFormat, t.TableSchema, t.TableName, columns,
+		int64(x.mysqlContext.ExportFileSizeMB)*1024*1024)
+	defer func() {
+		if err != nil {
+			w.abort()
+		}
+	}()
+
+	d := NewDumper(tx, t, x.mysqlContext.ChunkSize, x.logger)
+	d.limiter = e.dumpLimiter
+	if err := d.Dump(); err != nil {
+		return err
+	}
+	e.shutdownLock.Lock()
+	e.dumpers = append(e.dumpers, d)
+	e.shutdownLock.Unlock()
+	// The dumper stops after an error: the rest of the entries are read until it does.
+	for entry := range d.resultsChannel {
+		if err != nil {
+			continue
+		}
+		if entry.err != nil {
+			err = entry.err
+			continue
+		}
+		row := make([][]byte, len(columns))
+		for _, values := range entry.ValuesX {
+			for i, v := range values {
+				row[i], _ = (*v).([]byte)
+			}
+			if err = w.write(row); err != nil {
+				break
+			}
+		}
+		e.copyStat.add(t.TableSchema, t.TableName, entry.RowsCount)
+	}
+	if err != nil {
+		return err
+	}
+
+	files, err := w.close()
+	if err != nil {
+		return err
+	}
+	x.statLock.Lock()
+	x.stat.Files = append(x.stat.Files, files...)
+	x.statLock.Unlock()
+	e.tableCount++
+	return nil
+}
+
+func (x *Exporter) ID() string {
+	id := config.DriverCtx{
+		DriverConfig: &config.MySQLDriverConfig{},
+	}
+	data, err := json.Marshal(id)
+	if err != nil {
+		x.logger.Errorf("mysql.exporter: Failed to marshal ID to JSON: %s", err)
+	}
+	return string(data)
+}
+
+func (x *Exporter) Stats() (*models.TaskStatistics, error) {
+	stats := &models.TaskStatistics{
+		TableCopyStats: x.extractor.copyStat.stats(),
+		Timestamp:      time.Now().UTC().UnixNano(),
+	}
+	x.statLock.Lock()
+	defer x.statLock.Unlock()
+	if x.stat != nil {
+		stat := *x.stat
+		stat.Files = append([]*models.ExportFileStat(nil), x.stat.Files...)
+		stats.Export = &stat
+	}
+	return stats, nil
+}
+
+func (x *Exporter) StartupPhase() (phase string, started bool) {
+	return x.extractor.StartupPhase()
+}
+
+func (x *Exporter) StartDeadline() time.Duration {
+	return x.extractor.StartDeadline()
+}
+
+func (x *Exporter) onError(state int, err error) {
+	if state == TaskStateComplete {
+		x.logger.Printf("mysql.exporter: Done exporting")
+	} else {
+		x.logger.Errorf("mysql.exporter. error: %v", err.Error())
+	}
+	if x.shutdown {
+		return
+	}
+	x.waitCh <- models.NewWaitResult(state, err)
+	x.Shutdown()
+}
+
+func (x *Exporter) WaitCh() chan *models.WaitResult {
+	return x.waitCh
+}
+
+// Shutdown stops the export. The export in progress fails, and its files are removed.
+func (x *Exporter) Shutdown() error {
+	x.shutdownLock.Lock()
+	defer x.shutdownLock.Unlock()
+	if x.shutdown {
+		return nil
+	}
+	x.shutdown = true
+	close(x.shutdownCh)
+
+	x.extractor.closeInspectorDB()
+	if err := x.extractor.Shutdown(); err != nil {
+		return err
+	}
+	x.logger.Printf("mysql.exporter: Shutting down")
+	return nil
+}
@@ -25,7 +25,6 @@ import (
 
 	"github.com/golang/snappy"
 	gonats "github.com/nats-io/go-nats"
-	gomysql "github.com/siddontang/go-mysql/mysql"
 
 	"os"
 
@@ -36,6 +35,8 @@ import (
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	sqle "github.com/actiontech/dtle/internal/client/driver/mysql/sqle/inspector"
 	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/faults"
+	"github.com/actiontech/dtle/internal/gtid"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 	"github.com/actiontech/dtle/utils"
@@ -76,6 +77,8 @@ type Extractor struct {
 
 	sendByTimeoutCounter  int
 	sendBySizeFullCounter int
+	// messages of the binlog sent. accessed atomically
+	sendMessages int64
 
 	natsConn *gonats.Conn
 	waitCh   chan *models.WaitResult
@@ -87,9 +90,70 @@ type Extractor struct {
 	testStub1Delay int64
 
 	context *sqle.Context
+
+	// emits a task event. might be nil.
+	eventEmitter func(message string, args ...interface{})
+	// "schema.table" of source tables to which a surrogate key is added on the target
+	surrogateKeyTables map[string]bool
+
+	// For ReplicationChannel: server uuids of the transactions from the channel, and of those not.
+	channelSids    map[string]bool
+	nonChannelSids map[string]bool
+
+	// "schema.table" -> rows changed since the last row count check
+	rowChurn     map[string]int64
+	rowChurnLock sync.Mutex
+
+	transport *transportCounter
+	copyStat  *copyStat
+
+	// the checkpoint of the last sent rows of the full copy, and whether the copy is resumed
+	dumpProgress     *models.DumpProgress
+	dumpResumed      bool
+	dumpProgressLock sync.Mutex
+	// the binlog is streamed during the full copy, for ConcurrentIncrementalCopy
+	streamingDuringCopy bool
+	// sizes of the messages of the full copy before and after DumpCompression. accessed atomically
+	dumpRawBytes        int64
+	dumpCompressedBytes int64
+	// for DumpMaxMBPerSec, shared by the dumpers. nil for no limit
+	dumpLimiter *dumpLimiter
+
+	// server_uuid and server_id (accessed atomically) of the source, as last observed, and the
+	// times the binlog streamer reconnected to another server. see recheckServerUuid
+	serverUuid      atomic.Value
+	serverId        uint32
+	backendSwitches int64
+
+	// the counters at the last stats reset. see ResetStats
+	statsBaseline statsBaseline
+
+	// With SourceMaxLag or ReadFromReplica: the source addresses in order of preference, and the
+	// GTID set of the transactions acknowledged by the applier, to read from on another source.
+	sourceCandidates []string
+	sentGtidSet      gtid.Set
+	sentGtidLock     sync.Mutex
+	// With ReadFromReplica: the *base.ReplicaStatus of the source, as last read
+	replicaStatus atomic.Value
+	// map[string]string. the replicated schemas renamed on the target, to their names on the target
+	schemaMapping atomic.Value
+	// []*models.ResolvedTable. the tables replicated, as resolved by inspectTables
+	resolvedTables atomic.Value
+
+	// the initialization phase, for StartDeadline
+	startup startupTracker
+	// 1 while the source refuses the connections with too many connections. accessed atomically
+	sourceSaturated int32
+	// the server_id of the extractor as a replica of the source. accessed atomically
+	replicaServerId uint32
+	// 1 once the applier told it decodes the codec v2. accessed atomically
+	peerCodecV2 int32
+	// the tables are inspected for an Exporter, not replicated
+	exporting bool
 }
 
-func NewExtractor(subject, tp string, maxPayload int, cfg *config.MySQLDriverConfig, logger *log.Logger) (*Extractor, error) {
+func NewExtractor(subject, tp string, maxPayload int, cfg *config.MySQLDriverConfig, logger *log.Logger,
+	eventEmitter func(message string, args ...interface{})) (*Extractor, error) {
 
 	cfg = cfg.SetDefault()
 	entry := log.NewEntry(logger).WithFields(log.Fields{
@@ -106,8 +170,15 @@ func NewExtractor(subject, tp string, maxPayload int, cfg *config.MySQLDriverCon
 		rowCopyComplete: make(chan bool),
 		waitCh:          make(chan *models.WaitResult, 1),
 		shutdownCh:      make(chan struct{}),
+		rowChurn:        make(map[string]int64),
+		transport:       newTransportCounter(),
+		copyStat:        newCopyStat(),
+		dumpLimiter:     newDumpLimiter(cfg.DumpMaxMBPerSec),
 		testStub1Delay:  0,
 		context:         sqle.NewContext(nil),
+		eventEmitter:    eventEmitter,
+
+		surrogateKeyTables: make(map[string]bool),
 	}
 	e.context.LoadSchemas(nil)
 
@@ -156,30 +227,95 @@ func (e *Extractor) retryOperation(operation func() error, notFatalHint ...bool)
 
 // Run executes the complete extract logic.
 func (e *Extractor) Run() {
+	e.sourceCandidates = append([]string{e.sourceAddr()}, e.mysqlContext.SourceHosts...)
+	if addr := e.mysqlContext.SourceAddr; addr != "" {
+		// switched by SourceMaxLag
+		connConfig, err := connectionConfigWithAddr(e.mysqlContext.ConnectionConfig, addr)
+		if err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		e.mysqlContext.ConnectionConfig = connConfig
+	}
 	e.logger.Printf("mysql.extractor: Extract binlog events from %s.%d", e.mysqlContext.ConnectionConfig.Host, e.mysqlContext.ConnectionConfig.Port)
 	e.mysqlContext.StartTime = time.Now()
 
 	// Validate job arguments
 	{
-		if e.mysqlContext.SkipCreateDbTable && e.mysqlContext.DropTableIfExists {
-			e.onError(TaskStateDead,
-				fmt.Errorf("conflicting job argument: SkipCreateDbTable=true and DropTableIfExists=true"))
+		if err := e.mysqlContext.ValidateCreateTable(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateSourceHosts(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateCompression(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateCodec(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateReadBatch(e.maxPayload); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateStartPosition(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateMode(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateConcurrentIncrementalCopy(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateInitSQL(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateReplicaServerId(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
+		if err := e.mysqlContext.ValidateDumpMaxMBPerSec(); err != nil {
+			e.onError(TaskStateDead, err)
 			return
 		}
 	}
+	atomic.StoreUint32(&e.replicaServerId, e.mysqlContext.EffectiveServerId(e.subject))
 
-	if err := e.initiateInspector(); err != nil {
+	e.startup.enter(startupPhaseSourceInspection)
+	if err := e.connectSource(startupPhaseSourceInspection, e.initiateInspector, e.closeInspectorDB); err != nil {
 		e.onError(TaskStateDead, err)
 		return
 	}
+	e.startup.enter(startupPhaseNatsConnection)
 	if err := e.initNatsPubClient(); err != nil {
 		e.onError(TaskStateDead, err)
 		return
 	}
-	if err := e.initDBConnections(); err != nil {
+	go e.periodicStatsPublish()
+	e.startup.enter(startupPhaseSourceConnection)
+	if err := e.connectSource(startupPhaseSourceConnection, e.initDBConnections, e.closeSourceDBs); err != nil {
 		e.onError(TaskStateDead, err)
 		return
 	}
+	e.startup.enter(startupPhaseReplicationCheck)
+	if err := e.initReplicationChannel(); err != nil {
+		e.onError(TaskStateDead, err)
+		return
+	}
+	if err := e.initReadFromReplica(); err != nil {
+		e.onError(TaskStateDead, err)
+		return
+	}
+	// started once dumping or streaming, see mysqlDump
+	e.startup.enter(startupPhaseMetadataFetch)
 
 	fullCopy := true
 
@@ -195,6 +331,22 @@ func (e *Extractor) Run() {
 			fullCopy = false
 		}
 
+		if e.mysqlContext.StartPosition == config.StartPositionCurrent {
+			coord, err := base.GetSelfBinlogCoordinates(e.db)
+			if err != nil {
+				e.onError(TaskStateDead, err)
+				return
+			}
+			e.mysqlContext.Gtid = coord.GtidSet
+			e.logger.Printf("mysql.extractor: StartPosition is %v. replicating from gtid %v without the full copy",
+				e.mysqlContext.StartPosition, coord.GtidSet)
+			// Before reading any binlog: a restart must not capture a later position.
+			if e.mysqlContext.SaveStartPosition != nil {
+				e.mysqlContext.SaveStartPosition(coord.GtidSet)
+			}
+			fullCopy = false
+		}
+
 		if e.mysqlContext.GtidStart != "" {
 			coord, err := base.GetSelfBinlogCoordinates(e.db)
 			if err != nil {
@@ -219,13 +371,20 @@ func (e *Extractor) Run() {
 			e.onError(TaskStateDead, err)
 			return
 		}
-		dumpMsg, err := Encode(&dumpStatResult{Gtid: e.initialBinlogCoordinates.GtidSet, TotalCount: e.mysqlContext.RowsEstimate})
+		dumpMsg, err := e.encodeDumpMsg(&dumpStatResult{
+			Gtid:                e.initialBinlogCoordinates.GtidSet,
+			TotalCount:          e.mysqlContext.RowsEstimate,
+			SkipIncrementalCopy: e.mysqlContext.SkipIncrementalCopy,
+		})
 		if err != nil {
 			e.onError(TaskStateDead, err)
 		}
 		if err := e.publish(fmt.Sprintf("%s_full_complete", e.subject), "", dumpMsg); err != nil {
 			e.onError(TaskStateDead, err)
 		}
+		e.dumpProgressLock.Lock()
+		e.dumpProgress = nil
+		e.dumpProgressLock.Unlock()
 	} else {
 		// Will not get consistent table meta-info for an incremental only job.
 		// https://github.com/actiontech/dtle/issues/321#issuecomment-441191534
@@ -237,15 +396,28 @@ func (e *Extractor) Run() {
 			e.onError(TaskStateDead, err)
 			return
 		}
+		e.startup.markStarted()
 	}
 
 	if e.mysqlContext.SkipIncrementalCopy {
 		e.logger.Infof("mysql.extractor. SkipIncrementalCopy")
+		if fullCopy {
+			// the position the copied rows are consistent with
+			e.mysqlContext.Gtid = e.initialBinlogCoordinates.GtidSet
+		}
+		e.onError(TaskStateComplete, nil)
 	} else {
-		if err := e.initBinlogReader(e.initialBinlogCoordinates); err != nil {
-			e.logger.Debugf("mysql.extractor error at initBinlogReader: %v", err.Error())
-			e.onError(TaskStateDead, err)
-			return
+		// already streaming since the snapshot of the copy with ConcurrentIncrementalCopy
+		if !e.streamingDuringCopy {
+			if err := e.skipNonChannelGtids(e.initialBinlogCoordinates); err != nil {
+				e.onError(TaskStateDead, err)
+				return
+			}
+			if err := e.initBinlogReader(e.initialBinlogCoordinates); err != nil {
+				e.logger.Debugf("mysql.extractor error at initBinlogReader: %v", err.Error())
+				e.onError(TaskStateDead, err)
+				return
+			}
 		}
 
 		if err := e.initiateStreaming(); err != nil {
@@ -314,6 +486,11 @@ func (e *Extractor) inspectTables() (err error) {
 
 		}
 		for _, doDb := range doDbs {
+			if doDb.TableSchemaRename == "" {
+				if target := e.mysqlContext.MapSchema(doDb.TableSchema); target != doDb.TableSchema {
+					doDb.TableSchemaRename = target
+				}
+			}
 			db := &config.DataSource{
 				TableSchema:       doDb.TableSchema,
 				TableSchemaRename: doDb.TableSchemaRename,
@@ -387,6 +564,9 @@ func (e *Extractor) inspectTables() (err error) {
 				TableSchema:      dbName,
 				TableSchemaScope: SCHEMA,
 			}
+			if target := e.mysqlContext.MapSchema(dbName); target != dbName {
+				ds.TableSchemaRename = target
+			}
 			if len(e.mysqlContext.ReplicateIgnoreDb) > 0 && e.ignoreDb(dbName) {
 				continue
 			}
@@ -404,6 +584,7 @@ func (e *Extractor) inspectTables() (err error) {
 					e.logger.Warnf("mysql.extractor: %v", err)
 					continue
 				}
+				tb.TableSchemaRename = ds.TableSchemaRename
 
 				ds.Tables = append(ds.Tables, tb)
 			}
@@ -431,8 +612,65 @@ func (e *Extractor) inspectTables() (err error) {
 		e.replicateDoDb = append(e.replicateDoDb, db_mysql)
 	}*/
 
+	schemas := make([]string, 0, len(e.replicateDoDb))
+	for _, db := range e.replicateDoDb {
+		schemas = append(schemas, db.TableSchema)
+	}
+	schemaMapping, err := e.mysqlContext.ValidateTargetSchema(schemas)
+	if err != nil {
+		return err
+	}
+	e.schemaMapping.Store(schemaMapping)
+	e.resolvedTables.Store(resolvedTablesOf(e.mysqlContext, e.replicateDoDb))
+	return e.checkNoPkTables()
+}
+
+// checkNoPkTables applies NoPkTablePolicy to the tables to be replicated.
+func (e *Extractor) checkNoPkTables() error {
+	if e.exporting {
+		return nil
+	}
+	var noPkTables []string
+	for _, db := range e.replicateDoDb {
+		for _, tb := range db.Tables {
+			if strings.ToLower(tb.TableType) == "view" || tb.OriginalTableColumns == nil {
+				continue
+			}
+			if !hasPkColumn(tb.OriginalTableColumns) {
+				noPkTables = append(noPkTables, fmt.Sprintf("%s.%s", tb.TableSchema, tb.TableName))
+			}
+		}
+	}
+	if len(noPkTables) == 0 {
+		return nil
+	}
+
+	policy := e.mysqlContext.NoPkTablePolicy
+	switch policy {
+	case config.NoPkTablePolicyReject:
+		return fmt.Errorf("tables without primary key: %v. Add primary keys or set NoPkTablePolicy to %v or %v",
+			strings.Join(noPkTables, ", "), config.NoPkTablePolicyFullRowMatch, config.NoPkTablePolicySurrogateKey)
+	case config.NoPkTablePolicyFullRowMatch:
+		e.logger.Warnf("mysql.extractor: tables without primary key will be replicated with full-row matching."+
+			" It is slow on large tables, and rows only differing in NULLs are indistinguishable: %v", noPkTables)
+	case config.NoPkTablePolicySurrogateKey:
+		e.logger.Warnf("mysql.extractor: column %v will be added as primary key on the target for tables without primary key: %v",
+			g.SurrogateKeyColumn, noPkTables)
+		for _, name := range noPkTables {
+			e.surrogateKeyTables[name] = true
+		}
+	default:
+		return fmt.Errorf("unknown NoPkTablePolicy %v", policy)
+	}
+	e.emitEvent("NoPkTablePolicy %v. Tables without primary key: %v", policy, strings.Join(noPkTables, ", "))
 	return nil
 }
+
+func (e *Extractor) emitEvent(message string, args ...interface{}) {
+	if e.eventEmitter != nil {
+		e.eventEmitter(message, args...)
+	}
+}
 func (e *Extractor) ignoreDb(dbName string) bool {
 	for _, ignoreDb := range e.mysqlContext.ReplicateIgnoreDb {
 		if ignoreDb.TableSchema == dbName && len(ignoreDb.Tables) == 0 {
@@ -471,7 +709,7 @@ func (e *Extractor) readTableColumns() (err error) {
 
 func (e *Extractor) initNatsPubClient() (err error) {
 	natsAddr := fmt.Sprintf("nats://%s", e.mysqlContext.NatsAddr)
-	sc, err := gonats.Connect(natsAddr)
+	sc, err := e.mysqlContext.NatsAuth.Connect(natsAddr)
 	if err != nil {
 		e.logger.Errorf("mysql.extractor: Can't connect nats server %v. make sure a nats streaming server is running.%v", natsAddr, err)
 		return err
@@ -484,13 +722,16 @@ func (e *Extractor) initNatsPubClient() (err error) {
 
 // initiateStreaming begins treaming of binary log events and registers listeners for such events
 func (e *Extractor) initiateStreaming() error {
-	go func() {
-		e.logger.Printf("mysql.extractor: Beginning streaming")
-		err := e.StreamEvents()
-		if err != nil {
-			e.onError(TaskStateDead, err)
+	if !e.streamingDuringCopy {
+		if err := e.beginStreaming(); err != nil {
+			return err
 		}
-	}()
+	}
+	if e.mysqlContext.ReadFromReplica {
+		go e.periodicReplicaCheck()
+	} else if e.sentGtidSet != nil {
+		go e.periodicSourceLagCheck()
+	}
 
 	go func() {
 		_, err := e.natsConn.Subscribe(fmt.Sprintf("%s_restart", e.subject), func(m *gonats.Msg) {
@@ -509,23 +750,63 @@ func (e *Extractor) initiateStreaming() error {
 			e.onError(TaskStateDead, err)
 		}
 	}()
+
+	if e.mysqlContext.RowCountCheckInterval > 0 {
+		go e.periodicRowCountCheck()
+	}
+	go e.periodicTransportReport()
+	return nil
+}
+
+// beginStreaming streams the binlog from initialBinlogCoordinates, in the background.
+func (e *Extractor) beginStreaming() error {
+	if (e.mysqlContext.SourceMaxLag > 0 || e.mysqlContext.ReadFromReplica) && len(e.mysqlContext.SourceHosts) > 0 {
+		sent, err := gtid.Parse(e.initialBinlogCoordinates.GtidSet)
+		if err != nil {
+			return err
+		}
+		e.sentGtidSet = sent
+	}
+
+	go func() {
+		e.logger.Printf("mysql.extractor: Beginning streaming")
+		err := e.StreamEvents()
+		if err != nil {
+			if sql.IsServerIdCollisionError(err) {
+				e.onServerIdCollision(err)
+				return
+			}
+			e.onError(TaskStateDead, err)
+		}
+	}()
 	return nil
 }
 
 //--EventsStreamer--
 func (e *Extractor) initDBConnections() (err error) {
+	if len(e.mysqlContext.InitSQL) > 0 {
+		e.logger.Printf("mysql.extractor: InitSQL on the connections to the source: %v",
+			strings.Join(e.mysqlContext.InitSQL, "; "))
+	}
 	eventsStreamerUri := e.mysqlContext.ConnectionConfig.GetDBUri()
-	if e.db, err = sql.CreateDB(eventsStreamerUri); err != nil {
+	if e.db, err = sql.CreateDB(eventsStreamerUri, e.mysqlContext.InitSQL...); err != nil {
 		return err
 	}
 	//https://github.com/go-sql-driver/mysql#system-variables
 	dumpUri := fmt.Sprintf("%s&tx_isolation='REPEATABLE-READ'", e.mysqlContext.ConnectionConfig.GetSingletonDBUri())
-	if e.singletonDB, err = sql.CreateDB(dumpUri); err != nil {
+	if e.singletonDB, err = sql.CreateDB(dumpUri, e.mysqlContext.InitSQL...); err != nil {
 		return err
 	}
 	if err := e.validateConnection(); err != nil {
 		return err
 	}
+	backend, err := readSourceBackend(e.db)
+	if err != nil {
+		return err
+	}
+	if err := e.checkServerUuid(backend); err != nil {
+		return err
+	}
 	if err := e.validateAndReadTimeZone(); err != nil {
 		return err
 	}
@@ -567,7 +848,7 @@ func (e *Extractor) getSchemaTablesAndMeta() error {
 			e.context.UpdateContext(ast, "mysql")
 			if !e.context.HasTable(tb.TableSchema, tb.TableName) {
 				err := fmt.Errorf("failed to add table to sqle context. table: %v.%v", db.TableSchema, tb.TableName)
-				e.logger.Errorf(err.Error())
+				e.logger.Errorf("%v", err)
 				return err
 			}
 		}
@@ -581,12 +862,15 @@ func (e *Extractor) getSchemaTablesAndMeta() error {
 
 // initBinlogReader creates and connects the reader: we hook up to a MySQL server as a replica
 func (e *Extractor) initBinlogReader(binlogCoordinates *base.BinlogCoordinatesX) error {
-	binlogReader, err := binlog.NewMySQLReader(e.mysqlContext, e.logger, e.replicateDoDb, e.context)
+	binlogReader, err := binlog.NewMySQLReader(e.mysqlContext, e.logger, e.replicateDoDb, e.context, atomic.LoadUint32(&e.replicaServerId))
 	if err != nil {
 		e.logger.Debugf("mysql.extractor: err at initBinlogReader: NewMySQLReader: %v", err.Error())
 		return err
 	}
 
+	binlogReader.OnFakeRotate = func(serverId uint32) error {
+		return e.recheckServerUuid(serverId, binlogReader.ReadGtidSet())
+	}
 	if err := binlogReader.ConnectBinlogStreamer(*binlogCoordinates); err != nil {
 		e.logger.Debugf("mysql.extractor: err at initBinlogReader: ConnectBinlogStreamer: %v", err.Error())
 		return err
@@ -595,6 +879,19 @@ func (e *Extractor) initBinlogReader(binlogCoordinates *base.BinlogCoordinatesX)
 	return nil
 }
 
+// checkServerUuid records the server_uuid and server_id of the source, and fails if it is not
+// the pinned SourceServerUuid.
+func (e *Extractor) checkServerUuid(backend *sourceBackend) error {
+	e.serverUuid.Store(backend.serverUuid)
+	atomic.StoreUint32(&e.serverId, backend.serverId)
+	if pin := e.mysqlContext.SourceServerUuid; pin != "" && !strings.EqualFold(pin, backend.serverUuid) {
+		return fmt.Errorf("server_uuid of the source %s:%d is %v, not SourceServerUuid %v. "+
+			"the source address might point to another server", e.mysqlContext.ConnectionConfig.Host,
+			e.mysqlContext.ConnectionConfig.Port, backend.serverUuid, pin)
+	}
+	return nil
+}
+
 // validateConnection issues a simple can-connect to MySQL
 func (e *Extractor) validateConnection() error {
 	query := `select @@global.version`
@@ -605,6 +902,79 @@ func (e *Extractor) validateConnection() error {
 	return nil
 }
 
+// initReplicationChannel validates ReplicationChannel exists on the source, and reads the server uuids
+// of the transactions received from it.
+func (e *Extractor) initReplicationChannel() error {
+	if e.mysqlContext.ReplicationChannel == "" {
+		return nil
+	}
+	e.nonChannelSids = make(map[string]bool)
+	return e.refreshChannelSids()
+}
+
+func (e *Extractor) refreshChannelSids() error {
+	status, err := base.GetReplicationChannelStatus(e.db, e.mysqlContext.ReplicationChannel)
+	if err != nil {
+		return err
+	}
+	e.channelSids, err = status.Sids()
+	if err != nil {
+		return err
+	}
+	e.logger.Printf("mysql.extractor: replication channel %v. sids: %v", e.mysqlContext.ReplicationChannel, e.channelSids)
+	return nil
+}
+
+// isChannelTx tells whether a transaction with the server uuid should be replicated, regarding ReplicationChannel.
+func (e *Extractor) isChannelTx(sid string) bool {
+	if e.mysqlContext.ReplicationChannel == "" || e.channelSids[sid] {
+		return true
+	}
+	if e.nonChannelSids[sid] {
+		return false
+	}
+	// The upstream might have a new server uuid, e.g. after its failover.
+	// A transaction is in Retrieved_Gtid_Set before it is applied and written to the binlog.
+	if err := e.refreshChannelSids(); err != nil {
+		e.logger.Warnf("mysql.extractor: error reading status of replication channel %v: %v",
+			e.mysqlContext.ReplicationChannel, err)
+	}
+	if e.channelSids[sid] {
+		return true
+	}
+	e.logger.Debugf("mysql.extractor: skip transactions of sid %v which are not from replication channel %v",
+		sid, e.mysqlContext.ReplicationChannel)
+	e.nonChannelSids[sid] = true
+	return false
+}
+
+// skipNonChannelGtids adds the executed transactions not from ReplicationChannel to the starting gtid set,
+// so that binlog streaming does not request them, which might have been purged.
+func (e *Extractor) skipNonChannelGtids(coordinates *base.BinlogCoordinatesX) error {
+	if e.mysqlContext.ReplicationChannel == "" {
+		return nil
+	}
+	selfCoordinates, err := base.GetSelfBinlogCoordinates(e.db)
+	if err != nil {
+		return err
+	}
+	executed, err := gtid.Parse(selfCoordinates.GtidSet)
+	if err != nil {
+		return err
+	}
+	gtidSet, err := gtid.Parse(coordinates.GtidSet)
+	if err != nil {
+		return err
+	}
+	for sid := range executed {
+		if e.channelSids[sid.String()] {
+			delete(executed, sid)
+		}
+	}
+	coordinates.GtidSet = gtidSet.Union(executed).String()
+	return nil
+}
+
 func (e *Extractor) selectSqlMode() error {
 	query := `select @@global.sql_mode`
 	if err := e.db.QueryRow(query).Scan(&e.mysqlContext.SqlMode); err != nil {
@@ -616,7 +986,7 @@ func (e *Extractor) selectSqlMode() error {
 // readCurrentBinlogCoordinates reads master status from hooked server
 func (e *Extractor) readCurrentBinlogCoordinates() error {
 	if e.mysqlContext.Gtid != "" {
-		gtidSet, err := gomysql.ParseMysqlGTIDSet(e.mysqlContext.Gtid)
+		gtidSet, err := gtid.Parse(e.mysqlContext.Gtid)
 		if err != nil {
 			return err
 		}
@@ -759,7 +1129,7 @@ func (e *Extractor) StreamEvents() error {
 					gno = entries.Entries[0].Coordinates.GNO
 				}
 
-				txMsg, err := Encode(entries)
+				txMsg, _, err := EncodeEntries(&entries, e.txCodec(), e.mysqlContext.IncrementalCompression)
 				if err != nil {
 					return err
 				}
@@ -769,6 +1139,13 @@ func (e *Extractor) StreamEvents() error {
 					return err
 				}
 				e.logger.Debugf("mysql.extractor: send acked gno: %v, n: %v", gno, len(entries.Entries))
+				atomic.AddInt64(&e.sendMessages, 1)
+				for _, entry := range entries.Entries {
+					e.markSent(entry.Coordinates.SID.String(), entry.Coordinates.GNO)
+					if entry.XaPrepared != nil {
+						e.markSent(entry.XaPrepared.SID.String(), entry.XaPrepared.GNO)
+					}
+				}
 
 				entries.Entries = nil
 				entriesSize = 0
@@ -777,6 +1154,7 @@ func (e *Extractor) StreamEvents() error {
 			}
 
 			keepGoing := true
+			readBatch := e.mysqlContext.ReadBatchLimit()
 
 			groupTimeoutDuration := time.Duration(e.mysqlContext.GroupTimeout) * time.Millisecond
 			timer := time.NewTimer(groupTimeoutDuration)
@@ -786,12 +1164,16 @@ func (e *Extractor) StreamEvents() error {
 				var err error
 				select {
 				case binlogEntry := <-e.dataChannel:
+					if !e.isChannelTx(binlogEntry.Coordinates.SID.String()) {
+						continue
+					}
+					e.addRowChurn(binlogEntry)
 					entries.Entries = append(entries.Entries, binlogEntry)
 					entriesSize += binlogEntry.OriginalSize
 
-					if entriesSize >= e.mysqlContext.GroupMaxSize ||
-						int64(len(entries.Entries)) == e.mysqlContext.ReplChanBufferSize {
+					if entriesSize >= e.mysqlContext.GroupMaxSize || len(entries.Entries) >= readBatch {
 						e.logger.Debugf("extractor. incr. send by GroupLimit. entriesSize: %v", entriesSize)
+						e.sendBySizeFullCounter += len(entries.Entries)
 						err = sendEntries()
 						if !timer.Stop() {
 							<-timer.C
@@ -802,6 +1184,7 @@ func (e *Extractor) StreamEvents() error {
 					nEntries := len(entries.Entries)
 					if nEntries > 0 {
 						e.logger.Debugf("extractor. incr. send by timeout. entriesSize: %v", entriesSize)
+						e.sendByTimeoutCounter += nEntries
 						err = sendEntries()
 					}
 					timer.Reset(groupTimeoutDuration)
@@ -903,7 +1286,7 @@ func (e *Extractor) StreamEvents() error {
 						txArray = append(txArray, binlogTx)
 						txBytes += len([]byte(binlogTx.Query))
 						if txBytes > e.mysqlContext.MsgBytesLimit {
-							txMsg, err := Encode(&txArray)
+							txMsg, _, err := EncodeWith(&txArray, e.mysqlContext.IncrementalCompression)
 							if err != nil {
 								e.onError(TaskStateDead, err)
 								break L
@@ -915,6 +1298,9 @@ func (e *Extractor) StreamEvents() error {
 								e.onError(TaskStateDead, err)
 								break L
 							}
+							for _, tx := range txArray {
+								e.markSent(tx.SID, tx.GNO)
+							}
 							//send_by_size_full
 							e.sendBySizeFullCounter += len(txArray)
 							txArray = []*binlog.BinlogTx{}
@@ -924,7 +1310,7 @@ func (e *Extractor) StreamEvents() error {
 				case <-time.After(100 * time.Millisecond):
 					{
 						if len(txArray) != 0 {
-							txMsg, err := Encode(&txArray)
+							txMsg, _, err := EncodeWith(&txArray, e.mysqlContext.IncrementalCompression)
 							if err != nil {
 								e.onError(TaskStateDead, err)
 								break L
@@ -940,6 +1326,9 @@ func (e *Extractor) StreamEvents() error {
 								e.onError(TaskStateDead, err)
 								break L
 							}
+							for _, tx := range txArray {
+								e.markSent(tx.SID, tx.GNO)
+							}
 							//send_by_timeout
 							e.sendByTimeoutCounter += len(txArray)
 							txArray = []*binlog.BinlogTx{}
@@ -969,8 +1358,17 @@ func (e *Extractor) StreamEvents() error {
 func (e *Extractor) publish(subject, gtid string, txMsg []byte) (err error) {
 	for {
 		e.logger.Debugf("mysql.extractor: publish. gtid: %v, msg_len: %v", gtid, len(txMsg))
-		_, err = e.natsConn.Request(subject, txMsg, DefaultConnectWait)
+		if err = faults.Inject(faults.NatsPublish); err == faults.ErrDropped {
+			// lost on the way
+			err = gonats.ErrTimeout
+		} else if err == nil {
+			var reply *gonats.Msg
+			if reply, err = e.natsConn.Request(subject, txMsg, DefaultConnectWait); err == nil {
+				e.onTxReply(reply)
+			}
+		}
 		if err == nil {
+			e.transport.published(subject, len(txMsg))
 			if gtid != "" {
 				e.mysqlContext.Gtid = gtid
 			}
@@ -983,7 +1381,7 @@ func (e *Extractor) publish(subject, gtid string, txMsg []byte) (err error) {
 			break
 		}
 		// there's an error. Let's try again.
-		e.logger.Debugf(fmt.Sprintf("mysql.extractor: there's an error [%v]. Let's try again", err))
+		e.logger.Debugf("mysql.extractor: there's an error [%v]. Let's try again", err)
 		time.Sleep(1 * time.Second)
 	}
 	return err
@@ -1148,6 +1546,24 @@ func (e *Extractor) mysqlDump() error {
 	}
 	step++
 
+	// Resume an interrupted copy. The remaining rows are read from the new snapshot, while the
+	// incremental copy starts from the snapshot of the first attempt.
+	resume := e.mysqlContext.DumpProgress
+	if resume != nil && resume.Gtid != "" {
+		e.logger.Printf("mysql.extractor: Step %d: resuming the full copy of snapshot %v. %d tables completed, %v.%v in progress",
+			step, resume.Gtid, len(resume.CompletedTables), resume.TableSchema, resume.TableName)
+		e.initialBinlogCoordinates = &base.BinlogCoordinatesX{
+			GtidSet: resume.Gtid,
+		}
+		e.setDumpProgress(&models.DumpProgress{
+			Gtid:            resume.Gtid,
+			CompletedTables: resume.CompletedTables,
+		}, true)
+	} else {
+		resume = nil
+		e.setDumpProgress(&models.DumpProgress{Gtid: e.initialBinlogCoordinates.GtidSet}, false)
+	}
+
 	// ------
 	// STEP 4
 	// ------
@@ -1160,6 +1576,12 @@ func (e *Extractor) mysqlDump() error {
 	if err != nil {
 		return err
 	}
+	e.startup.markStarted()
+	if e.mysqlContext.ConcurrentIncrementalCopy {
+		if err := e.streamDuringCopy(resume); err != nil {
+			return err
+		}
+	}
 
 	// Transform the current schema so that it reflects the *current* state of the MySQL server's contents.
 	// First, get the DROP TABLE and CREATE TABLE statement (with keys and constraint definitions) for our tables ...
@@ -1172,6 +1594,10 @@ func (e *Extractor) mysqlDump() error {
 				if tb.TableSchema != db.TableSchema {
 					continue
 				}
+				if resume.Started(tb.TableSchema, tb.TableName) {
+					// created before the resume
+					continue
+				}
 				total, err := e.CountTableRows(tb)
 				if err != nil {
 					return err
@@ -1203,9 +1629,21 @@ func (e *Extractor) mysqlDump() error {
 								tbSQL[num] = strings.Replace(sql, tb.TableName, tb.TableRename, 1)
 							}
 						}
+						for num := range tbSQL {
+							// the other schemas, e.g. of REFERENCES
+							tbSQL[num] = sql.RenameSchemas(tbSQL[num], e.mysqlContext.TargetSchema)
+						}
 						if err != nil {
 							return err
 						}
+						if e.surrogateKeyTables[fmt.Sprintf("%s.%s", tb.TableSchema, tb.TableName)] {
+							tableName := tb.TableName
+							if tb.TableRename != "" {
+								tableName = tb.TableRename
+							}
+							tbSQL = append(tbSQL, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s bigint unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY",
+								sql.EscapeName(tableName), sql.EscapeName(g.SurrogateKeyColumn)))
+						}
 					}
 				}
 				entry := &DumpEntry{
@@ -1216,6 +1654,16 @@ func (e *Extractor) mysqlDump() error {
 					TotalCount:               tb.Counter + 1,
 					RowsCount:                1,
 				}
+				if len(tbSQL) > 0 {
+					entry.CreateTableSchema = tb.TableSchema
+					if db.TableSchemaRename != "" {
+						entry.CreateTableSchema = db.TableSchemaRename
+					}
+					entry.CreateTableName = tb.TableName
+					if tb.TableRename != "" {
+						entry.CreateTableName = tb.TableRename
+					}
+				}
 				atomic.AddInt64(&e.mysqlContext.RowsEstimate, 1)
 				atomic.AddInt64(&e.mysqlContext.TotalRowsCopied, 1)
 				if err := e.encodeDumpEntry(entry); err != nil {
@@ -1263,12 +1711,20 @@ func (e *Extractor) mysqlDump() error {
 		for _, t := range db.Tables {
 			//pool.Add(1)
 			//go func(t *config.Table) {
+			if resume.Completed(t.TableSchema, t.TableName) {
+				e.logger.Printf("mysql.extractor: Step %d: - skipping table '%s.%s' copied before the resume", step, t.TableSchema, t.TableName)
+				continue
+			}
+			if resume.Started(t.TableSchema, t.TableName) {
+				e.resumeTable(t, resume)
+			}
 			counter++
 			// Obtain a record maker for this table, which knows about the schema ...
 			// Choose how we create statements based on the # of rows ...
 			e.logger.Printf("mysql.extractor: Step %d: - scanning table '%s.%s' (%d of %d tables)", step, t.TableSchema, t.TableName, counter, e.tableCount)
 
 			d := NewDumper(tx, t, e.mysqlContext.ChunkSize, e.logger)
+			d.limiter = e.dumpLimiter
 			if err := d.Dump(); err != nil {
 				e.onError(TaskStateDead, err)
 			}
@@ -1284,10 +1740,29 @@ func (e *Extractor) mysqlDump() error {
 					if e.needToSendTabelDef() {
 						entry.Table = d.table
 					}
+					e.dumpProgressLock.Lock()
+					entry.Progress.Gtid = e.dumpProgress.Gtid
+					entry.Progress.CompletedTables = e.dumpProgress.CompletedTables
+					e.dumpProgress = entry.Progress
+					e.dumpProgressLock.Unlock()
 					if err = e.encodeDumpEntry(entry); err != nil {
 						e.onError(TaskStateRestart, err)
 					}
 					atomic.AddInt64(&e.mysqlContext.TotalRowsCopied, entry.RowsCount)
+					e.copyStat.add(t.TableSchema, t.TableName, entry.RowsCount)
+				}
+			}
+			e.dumpProgressLock.Lock()
+			e.dumpProgress = &models.DumpProgress{
+				Gtid: e.dumpProgress.Gtid,
+				CompletedTables: append(append([]string{}, e.dumpProgress.CompletedTables...),
+					fmt.Sprintf("%v.%v", t.TableSchema, t.TableName)),
+			}
+			e.dumpProgressLock.Unlock()
+			if e.streamingDuringCopy {
+				// the watermark of the table: the transactions held for it are applied after its rows
+				if err := e.encodeDumpEntry(&DumpEntry{HandoffTable: handoffTableName(e.mysqlContext, t)}); err != nil {
+					e.onError(TaskStateRestart, err)
 				}
 			}
 
@@ -1307,8 +1782,39 @@ func (e *Extractor) mysqlDump() error {
 
 	return nil
 }
+func (e *Extractor) setDumpProgress(p *models.DumpProgress, resumed bool) {
+	e.dumpProgressLock.Lock()
+	defer e.dumpProgressLock.Unlock()
+	e.dumpProgress = p
+	e.dumpResumed = resumed
+}
+
+// resumeTable sets the position of the table to that of the checkpoint. A table without a
+// unique key is copied again from the start, as its chunks are by offset and might change.
+// Copying a row again is harmless, as the rows are replaced.
+func (e *Extractor) resumeTable(t *config.Table, p *models.DumpProgress) {
+	if t.UseUniqueKey == nil || p.Iteration == 0 || len(p.LastMaxVals) != len(t.UseUniqueKey.LastMaxVals) {
+		e.logger.Printf("mysql.extractor: copying table '%s.%s' again from the start", t.TableSchema, t.TableName)
+		return
+	}
+	t.Iteration = p.Iteration
+	copy(t.UseUniqueKey.LastMaxVals, p.LastMaxVals)
+	e.logger.Printf("mysql.extractor: resuming table '%s.%s' after %v", t.TableSchema, t.TableName, p.LastMaxVals)
+}
+
+// encodeDumpMsg encodes a message of the full copy with DumpCompression, and counts its size.
+func (e *Extractor) encodeDumpMsg(v interface{}) ([]byte, error) {
+	msg, rawSize, err := EncodeWith(v, e.mysqlContext.DumpCompression)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&e.dumpRawBytes, int64(rawSize))
+	atomic.AddInt64(&e.dumpCompressedBytes, int64(len(msg)))
+	return msg, nil
+}
+
 func (e *Extractor) encodeDumpEntry(entry *DumpEntry) error {
-	txMsg, err := Encode(entry)
+	txMsg, err := e.encodeDumpMsg(entry)
 	if err != nil {
 		return err
 	}
@@ -1362,16 +1868,54 @@ func (e *Extractor) Stats() (*models.TaskStatistics, error) {
 		ETA:                eta,
 		Backlog:            fmt.Sprintf("%d/%d", len(e.dataChannel), cap(e.dataChannel)),
 		Stage:              e.mysqlContext.Stage,
+		RowImage:           e.mysqlContext.BinlogRowImage,
+		Mode:               e.mysqlContext.Mode,
+		Status:             e.sourceStatus(),
 		BufferStat: models.BufferStat{
 			ExtractorTxQueueSize: len(e.binlogChannel),
 			SendByTimeout:        e.sendByTimeoutCounter,
 			SendBySizeFull:       e.sendBySizeFullCounter,
+			SendMessages:         atomic.LoadInt64(&e.sendMessages),
+			ReadBatchSize:        e.mysqlContext.ReadBatchLimit(),
+		},
+		TableCopyStats: e.copyStat.stats(),
+		Transport: &models.TransportStat{
+			Subjects: e.transport.stats(),
+		},
+		DumpCompression: &models.CompressionStat{
+			Compression:     e.mysqlContext.DumpCompression,
+			RawBytes:        atomic.LoadInt64(&e.dumpRawBytes),
+			CompressedBytes: atomic.LoadInt64(&e.dumpCompressedBytes),
 		},
 		Timestamp: time.Now().UTC().UnixNano(),
 	}
+	if schemaMapping, ok := e.schemaMapping.Load().(map[string]string); ok && len(schemaMapping) > 0 {
+		taskResUsage.SchemaMapping = schemaMapping
+	}
+	if serverUuid, ok := e.serverUuid.Load().(string); ok {
+		taskResUsage.ServerUuid = serverUuid
+	}
+	taskResUsage.ServerId = atomic.LoadUint32(&e.serverId)
+	taskResUsage.ReplicaServerId = atomic.LoadUint32(&e.replicaServerId)
+	taskResUsage.BackendSwitches = atomic.LoadInt64(&e.backendSwitches)
+	taskResUsage.Throughput = &models.TaskThroughput{
+		Events: totalRowsCopied + deltaEstimate,
+	}
+	total, sinceReset, resetAt := e.statsBaseline.snapshot(e.counters)
+	taskResUsage.EventFilterStats = sinceReset.EventFilterStats
+	taskResUsage.SinceTaskStart = total
+	taskResUsage.StatsResetAt = resetAt
+	e.dumpProgressLock.Lock()
+	taskResUsage.DumpProgress = e.dumpProgress
+	taskResUsage.DumpResumed = e.dumpResumed
+	e.dumpProgressLock.Unlock()
 	if e.natsConn != nil {
 		taskResUsage.MsgStat = e.natsConn.Statistics
+		if e.mysqlContext.ApproveHeterogeneous {
+			taskResUsage.MsgCodec = e.txCodec().Name()
+		}
 		e.mysqlContext.TotalTransferredBytes = int(taskResUsage.MsgStat.OutBytes)
+		taskResUsage.Throughput.Bytes = int64(taskResUsage.MsgStat.OutBytes)
 		if e.mysqlContext.TrafficAgainstLimits > 0 && int(taskResUsage.MsgStat.OutBytes)/1024/1024/1024 >= e.mysqlContext.TrafficAgainstLimits {
 			e.onError(TaskStateDead, fmt.Errorf("traffic limit exceeded : %d/%d", e.mysqlContext.TrafficAgainstLimits, int(taskResUsage.MsgStat.OutBytes)/1024/1024/1024))
 		}
@@ -1380,11 +1924,30 @@ func (e *Extractor) Stats() (*models.TaskStatistics, error) {
 	currentBinlogCoordinates := &base.BinlogCoordinateTx{}
 	if e.binlogReader != nil {
 		currentBinlogCoordinates = e.binlogReader.GetCurrentBinlogCoordinates()
+		if rowImage := e.binlogReader.GetRowImage(); rowImage != "" {
+			taskResUsage.RowImage = rowImage
+		}
+		taskResUsage.BinlogHeartbeat = e.binlogReader.HeartbeatStat()
+		taskResUsage.BinlogCompression = e.binlogReader.BinlogCompressionStat()
 		taskResUsage.CurrentCoordinates = &models.CurrentCoordinates{
 			File:     currentBinlogCoordinates.LogFile,
 			Position: currentBinlogCoordinates.LogPos,
 			GtidSet:  fmt.Sprintf("%s:%d", currentBinlogCoordinates.GetSid(), currentBinlogCoordinates.GNO),
 		}
+		if e.mysqlContext.ReplicationChannel != "" {
+			taskResUsage.CurrentCoordinates.ReplicationChannel = e.mysqlContext.ReplicationChannel
+			if status, err := base.GetReplicationChannelStatus(e.db, e.mysqlContext.ReplicationChannel); err != nil {
+				e.logger.Warnf("mysql.extractor: error reading status of replication channel %v: %v",
+					e.mysqlContext.ReplicationChannel, err)
+			} else {
+				taskResUsage.CurrentCoordinates.RelayMasterLogFile = status.RelayMasterLogFile
+				taskResUsage.CurrentCoordinates.ReadMasterLogPos = status.ExecMasterLogPos
+				taskResUsage.CurrentCoordinates.RetrievedGtidSet = status.RetrievedGtidSet
+				taskResUsage.CurrentCoordinates.ExecutedGtidSet = status.ExecutedGtidSet
+			}
+		} else if e.mysqlContext.ReadFromReplica {
+			e.replicaCoordinates(taskResUsage.CurrentCoordinates)
+		}
 	} else {
 		taskResUsage.CurrentCoordinates = &models.CurrentCoordinates{
 			File:     "",
@@ -1405,6 +1968,8 @@ func (e *Extractor) ID() string {
 			Gtid:                  e.mysqlContext.Gtid,
 			NatsAddr:              e.mysqlContext.NatsAddr,
 			ConnectionConfig:      e.mysqlContext.ConnectionConfig,
+			SourceAddr:            e.mysqlContext.SourceAddr,
+			ServerIdRetry:         e.mysqlContext.ServerIdRetry,
 		},
 	}
 
@@ -1416,7 +1981,11 @@ func (e *Extractor) ID() string {
 }
 
 func (e *Extractor) onError(state int, err error) {
-	e.logger.Errorf("mysql.extractor. error: %v", err.Error())
+	if state == TaskStateComplete {
+		e.logger.Printf("mysql.extractor: Done migrating")
+	} else {
+		e.logger.Errorf("mysql.extractor. error: %v", err.Error())
+	}
 	if e.shutdown {
 		return
 	}
@@ -7,22 +7,23 @@
 package mysql
 
 import (
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
 	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	gomysql "github.com/go-sql-driver/mysql"
+	gonats "github.com/nats-io/go-nats"
+	"github.com/satori/go.uuid"
 )
 
-func TestGtidSetDiff(t *testing.T) {
-	// TODO
-	g, err := GtidSetDiff(
-		"113fa2ce-c8e6-11e7-b894-67ad30e6f107:1-100:200:300-400,f2a4aa16-c8e6-11e7-9ff0-e19f7778f563:100-200:300-400,8888aa16-c8e6-11e7-9ff0-e19f7778f563:1-1000",
-		"113fa2ce-c8e6-11e7-b894-67ad30e6f107:330,f2a4aa16-c8e6-11e7-9ff0-e19f7778f563:301",
-	)
-}
-
 func TestNewExtractor(t *testing.T) {
 	type args struct {
 		subject    string
@@ -40,7 +41,7 @@ func TestNewExtractor(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewExtractor(tt.args.subject, tt.args.tp, tt.args.maxPayload, tt.args.cfg, tt.args.logger); !reflect.DeepEqual(got, tt.want) {
+			if got, _ := NewExtractor(tt.args.subject, tt.args.tp, tt.args.maxPayload, tt.args.cfg, tt.args.logger, nil); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewExtractor() = %v, want %v", got, tt.want)
 			}
 		})
@@ -174,7 +175,7 @@ func TestExtractor_initDBConnections(t *testing.T) {
 
 func TestExtractor_initBinlogReader(t *testing.T) {
 	type args struct {
-		binlogCoordinates *base.BinlogCoordinateTx
+		binlogCoordinates *base.BinlogCoordinatesX
 	}
 	tests := []struct {
 		name    string
@@ -324,29 +325,6 @@ func TestExtractor_StreamEvents(t *testing.T) {
 	}
 }
 
-func TestExtractor_requestMsg(t *testing.T) {
-	type args struct {
-		subject string
-		gtid    string
-		txMsg   []byte
-	}
-	tests := []struct {
-		name    string
-		e       *Extractor
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.e.requestMsg(tt.args.subject, tt.args.gtid, tt.args.txMsg); (err != nil) != tt.wantErr {
-				t.Errorf("Extractor.requestMsg() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
 func TestExtractor_mysqlDump(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -385,22 +363,6 @@ func TestExtractor_encodeDumpEntry(t *testing.T) {
 	}
 }
 
-func Test_currentTimeMillis(t *testing.T) {
-	tests := []struct {
-		name string
-		want int64
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := currentTimeMillis(); got != tt.want {
-				t.Errorf("currentTimeMillis() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestExtractor_Stats(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -443,7 +405,8 @@ func TestExtractor_ID(t *testing.T) {
 
 func TestExtractor_onError(t *testing.T) {
 	type args struct {
-		err error
+		state int
+		err   error
 	}
 	tests := []struct {
 		name string
@@ -454,7 +417,7 @@ func TestExtractor_onError(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.e.onError(tt.args.err)
+			tt.e.onError(tt.args.state, tt.args.err)
 		})
 	}
 }
@@ -563,8 +526,7 @@ func TestExtractor_validateAndReadTimeZone(t *testing.T) {
 
 func TestExtractor_CountTableRows(t *testing.T) {
 	type args struct {
-		tableSchema string
-		tableName   string
+		table *config.Table
 	}
 	tests := []struct {
 		name    string
@@ -577,7 +539,7 @@ func TestExtractor_CountTableRows(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.e.CountTableRows(tt.args.tableSchema, tt.args.tableName)
+			got, err := tt.e.CountTableRows(tt.args.table)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Extractor.CountTableRows() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -588,3 +550,337 @@ func TestExtractor_CountTableRows(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractor_resumeTable(t *testing.T) {
+	e := &Extractor{logger: log.NewEntry(log.New(os.Stdout, log.DebugLevel))}
+	newTable := func(uniqueKey bool) *config.Table {
+		table := &config.Table{TableSchema: "db1", TableName: "t1"}
+		if uniqueKey {
+			table.UseUniqueKey = &umconf.UniqueKey{Name: "PRIMARY", LastMaxVals: make([]string, 2)}
+		}
+		return table
+	}
+	progress := &models.DumpProgress{
+		TableSchema: "db1",
+		TableName:   "t1",
+		Iteration:   3,
+		LastMaxVals: []string{"10", "'a'"},
+	}
+
+	table := newTable(true)
+	e.resumeTable(table, progress)
+	if table.Iteration != 3 || !reflect.DeepEqual(table.UseUniqueKey.LastMaxVals, progress.LastMaxVals) {
+		t.Errorf("resumeTable() = %v %v, want the position of the progress", table.Iteration, table.UseUniqueKey.LastMaxVals)
+	}
+
+	for _, table := range []*config.Table{newTable(false), newTable(true)} {
+		p := *progress
+		if table.UseUniqueKey != nil {
+			// the unique key has changed
+			p.LastMaxVals = []string{"10"}
+		}
+		e.resumeTable(table, &p)
+		if table.Iteration != 0 {
+			t.Errorf("resumeTable() Iteration = %v, want the table copied from the start", table.Iteration)
+		}
+	}
+
+	if !progress.Started("db1", "t1") || progress.Completed("db1", "t1") {
+		t.Errorf("Started()/Completed() of the table in progress are wrong")
+	}
+	progress.CompletedTables = []string{"db1.t0"}
+	if !progress.Completed("db1", "t0") || progress.Started("db1", "t2") {
+		t.Errorf("Started()/Completed() of the other tables are wrong")
+	}
+}
+
+func Test_checkGtidContinuity(t *testing.T) {
+	const sid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	tests := []struct {
+		name     string
+		sent     string
+		executed string
+		purged   string
+		wantErr  bool
+	}{
+		{"continues", sid + ":1-10", sid + ":1-20", sid + ":1-5", false},
+		{"nothing purged", sid + ":1-10", sid + ":1-10", "", false},
+		{"missing sent transactions", sid + ":1-10", sid + ":1-8", "", true},
+		{"other server", sid + ":1-10", "4e11fa47-71ca-11e1-9e33-c80aa9429562:1-20", "", true},
+		{"unsent transactions purged", sid + ":1-10", sid + ":1-20", sid + ":1-12", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkGtidContinuity(tt.sent, tt.executed, tt.purged); (err != nil) != tt.wantErr {
+				t.Errorf("checkGtidContinuity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncodeWith(t *testing.T) {
+	entry := &DumpEntry{TableSchema: "db", TableName: "t", RowsCount: 2}
+	for _, compression := range []string{config.CompressionSnappy, config.CompressionGzip, config.CompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			msg, rawSize, err := EncodeWith(entry, compression)
+			if err != nil {
+				t.Fatalf("EncodeWith() error = %v", err)
+			}
+			if rawSize == 0 {
+				t.Errorf("EncodeWith() rawSize = 0")
+			}
+			got := &DumpEntry{}
+			if err := Decode(msg, got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, entry) {
+				t.Errorf("Decode() = %v, want %v", got, entry)
+			}
+		})
+	}
+	if _, _, err := EncodeWith(entry, "lz4"); err == nil {
+		t.Errorf("EncodeWith() of an unknown compression: want an error")
+	}
+}
+
+func Test_checkBackendSwitch(t *testing.T) {
+	const (
+		uuid1 = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+		uuid2 = "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	)
+	backend := &sourceBackend{serverUuid: uuid2, serverId: 2, executed: uuid1 + ":1-100", purged: uuid1 + ":1-10"}
+	tests := []struct {
+		name         string
+		prevUuid     string
+		read         string
+		executed     string
+		wantSwitched bool
+		wantErr      bool
+	}{
+		{"first", "", uuid1 + ":1-50", backend.executed, false, false},
+		{"same", strings.ToUpper(uuid2), uuid1 + ":1-50", backend.executed, false, false},
+		{"superset", uuid1, uuid1 + ":1-50", backend.executed, true, false},
+		{"missing", uuid1, uuid1 + ":1-50", uuid1 + ":1-40", true, true},
+		{"by position", uuid1, "", backend.executed, true, true},
+	}
+	for _, tt := range tests {
+		b := *backend
+		b.executed = tt.executed
+		switched, err := checkBackendSwitch(tt.prevUuid, &b, tt.read)
+		if switched != tt.wantSwitched || (err != nil) != tt.wantErr {
+			t.Errorf("%v: checkBackendSwitch() = %v, %v, want %v, error %v", tt.name, switched, err, tt.wantSwitched, tt.wantErr)
+		}
+	}
+}
+
+func Test_sourcePosition_lagExceeds(t *testing.T) {
+	tests := []struct {
+		lag    int64
+		maxLag int
+		want   bool
+	}{
+		{5, 10, false},
+		{15, 10, true},
+		{-1, 10, true},
+		{3600, 0, false},
+		{-1, 0, true},
+	}
+	for _, tt := range tests {
+		if got := (&sourcePosition{lag: tt.lag}).lagExceeds(tt.maxLag); got != tt.want {
+			t.Errorf("lagExceeds(%v) of lag %v = %v, want %v", tt.maxLag, tt.lag, got, tt.want)
+		}
+	}
+}
+
+func TestExtractor_replicaCoordinates(t *testing.T) {
+	e := &Extractor{}
+	coordinates := &models.CurrentCoordinates{}
+	e.replicaCoordinates(coordinates)
+	if *coordinates != (models.CurrentCoordinates{}) {
+		t.Errorf("coordinates before the replica status is read = %+v", coordinates)
+	}
+
+	e.replicaStatus.Store(&base.ReplicaStatus{Lag: 7, RelayMasterLogFile: "mysql-bin.000003", ExecMasterLogPos: 1024})
+	e.replicaCoordinates(coordinates)
+	if coordinates.RelayMasterLogFile != "mysql-bin.000003" || coordinates.ReadMasterLogPos != 1024 || coordinates.ReplicaLag != 7 {
+		t.Errorf("replicaCoordinates() = %+v", coordinates)
+	}
+}
+
+func Test_saturatedRetryDelay(t *testing.T) {
+	var delays []time.Duration
+	var delay time.Duration
+	for i := 0; i < 8; i++ {
+		delay = saturatedRetryDelay(delay)
+		delays = append(delays, delay)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("saturatedRetryDelay() = %v, want %v", delays, want)
+	}
+}
+
+func TestExtractor_connectSource(t *testing.T) {
+	e := &Extractor{
+		logger:       log.NewEntry(log.New(os.Stdout, log.DebugLevel)),
+		mysqlContext: &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{Host: "127.0.0.1", Port: 3306}},
+		shutdownCh:   make(chan struct{}),
+	}
+	var events []string
+	e.eventEmitter = func(message string, args ...interface{}) {
+		events = append(events, message)
+	}
+	tooMany := &gomysql.MySQLError{Number: 1040, Message: "Too many connections"}
+
+	attempts, closes := 0, 0
+	e.startup.enter(startupPhaseSourceConnection)
+	err := e.connectSource(startupPhaseSourceConnection, func() error {
+		if attempts++; attempts == 1 {
+			return tooMany
+		}
+		if phase, _ := e.StartupPhase(); phase != startupPhaseSourceConnection {
+			t.Errorf("phase of the retry = %v", phase)
+		}
+		if e.sourceStatus() != models.TaskStatusSourceConnectionSaturated {
+			t.Errorf("sourceStatus() while saturated = %v", e.sourceStatus())
+		}
+		return nil
+	}, func() { closes++ })
+	if err != nil || attempts != 2 || closes != 1 || len(events) != 1 {
+		t.Fatalf("connectSource() = %v, attempts %v, closes %v, events %v", err, attempts, closes, events)
+	}
+	if e.sourceStatus() != "" {
+		t.Errorf("sourceStatus() after the connect = %v", e.sourceStatus())
+	}
+
+	// the other errors, e.g. access denied, are not retried
+	denied := &gomysql.MySQLError{Number: 1045, Message: "Access denied"}
+	attempts = 0
+	if err := e.connectSource(startupPhaseSourceConnection, func() error {
+		attempts++
+		return denied
+	}, func() {}); err != denied || attempts != 1 {
+		t.Errorf("connectSource() = %v after %v attempts, want %v", err, attempts, denied)
+	}
+
+	close(e.shutdownCh)
+	if err := e.connectSource(startupPhaseSourceConnection, func() error {
+		return tooMany
+	}, func() {}); err != tooMany {
+		t.Errorf("connectSource() on shutdown = %v", err)
+	}
+	if phase, _ := e.StartupPhase(); phase != startupPhaseSourceConnectionSaturated {
+		t.Errorf("phase on shutdown = %v", phase)
+	}
+}
+
+// codecTestEntries are transactions of n rows updated on a table of a few columns.
+func codecTestEntries(n int) *binlog.BinlogEntries {
+	sid := uuid.FromStringOrNil("3e11fa47-71ca-11e1-9e33-c80aa9429562")
+	entries := &binlog.BinlogEntries{}
+	for i := 0; i < n; i++ {
+		entry := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{LogFile: "mysql-bin.000003", LogPos: int64(1000 * i),
+			SID: sid, GNO: int64(i + 1), LastCommitted: int64(i), SeqenceNumber: int64(i + 1), Timestamp: 1500000000})
+		event := binlog.NewDataEvent("db1", "tbl1", binlog.UpdateDML, 6)
+		event.WhereColumnValues = umconf.ToColumnValues([]interface{}{int64(i), int32(-i), "name", nil, []byte("blob"), 3.5})
+		event.NewColumnValues = umconf.ToColumnValues([]interface{}{int64(i), int32(i), "new name", uint64(1) << 63, []byte("new blob"), float32(1.5)})
+		event.RowImage = "FULL"
+		entry.Events = append(entry.Events, event)
+		entry.OriginalSize = 100
+		entries.Entries = append(entries.Entries, entry)
+	}
+	entries.Entries[0].Events = append(entries.Entries[0].Events, binlog.DataEvent{Query: "create table t (a int)",
+		CurrentSchema: "db1", DatabaseName: "db1", TableName: "t", DML: binlog.NotDML})
+	entries.Entries[n-1].Xid = "x1"
+	entries.Entries[n-1].XaOp = "commit"
+	entries.Entries[n-1].XaPrepared = &base.BinlogCoordinateTx{SID: sid, GNO: 1}
+	return entries
+}
+
+func TestEncodeEntries(t *testing.T) {
+	entries := codecTestEntries(3)
+	for _, codec := range []txCodec{gobCodec{}, compactCodec{}} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			msg, _, err := EncodeEntries(entries, codec, config.CompressionSnappy)
+			if err != nil {
+				t.Fatalf("EncodeEntries() error = %v", err)
+			}
+			got := &binlog.BinlogEntries{}
+			name, err := DecodeEntries(msg, got)
+			if err != nil {
+				t.Fatalf("DecodeEntries() error = %v", err)
+			}
+			if name != codec.Name() {
+				t.Errorf("DecodeEntries() codec = %v, want %v", name, codec.Name())
+			}
+			for i, entry := range got.Entries {
+				want := entries.Entries[i]
+				if !reflect.DeepEqual(entry.Coordinates, want.Coordinates) || entry.Xid != want.Xid ||
+					entry.XaOp != want.XaOp || !reflect.DeepEqual(entry.XaPrepared, want.XaPrepared) ||
+					len(entry.Events) != len(want.Events) {
+					t.Fatalf("DecodeEntries() entry %v = %+v, want %+v", i, entry, want)
+				}
+				for j := range entry.Events {
+					if !reflect.DeepEqual(entry.Events[j], want.Events[j]) {
+						t.Errorf("DecodeEntries() event %v of entry %v = %+v, want %+v", j, i, entry.Events[j], want.Events[j])
+					}
+				}
+			}
+		})
+	}
+	// a truncated message
+	raw, _ := compactCodec{}.Encode(entries)
+	if err := (compactCodec{}).Decode(raw[:len(raw)/2], &binlog.BinlogEntries{}); err == nil {
+		t.Errorf("Decode() of a truncated message: want an error")
+	}
+}
+
+func TestExtractor_txCodec(t *testing.T) {
+	e := &Extractor{mysqlContext: &config.MySQLDriverConfig{Codec: config.CodecAuto, CodecV2Supported: true},
+		logger: log.NewEntry(log.New(os.Stdout, log.DebugLevel))}
+	if got := e.txCodec().Name(); got != config.CodecV1 {
+		t.Errorf("txCodec() before a reply = %v, want v1", got)
+	}
+	// an older applier
+	e.onTxReply(&gonats.Msg{})
+	if got := e.txCodec().Name(); got != config.CodecV1 {
+		t.Errorf("txCodec() after an empty reply = %v, want v1", got)
+	}
+	e.onTxReply(&gonats.Msg{Data: codecAckV2})
+	if got := e.txCodec().Name(); got != config.CodecV2 {
+		t.Errorf("txCodec() after a reply of v2 = %v, want v2", got)
+	}
+	e.mysqlContext.Codec = config.CodecV1
+	if got := e.txCodec().Name(); got != config.CodecV1 {
+		t.Errorf("txCodec() with Codec v1 = %v, want v1", got)
+	}
+}
+
+func BenchmarkCodec(b *testing.B) {
+	entries := codecTestEntries(100)
+	for _, codec := range []txCodec{gobCodec{}, compactCodec{}} {
+		b.Run(codec.Name()+"/encode", func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				raw, err := codec.Encode(entries)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(raw)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+		b.Run(codec.Name()+"/decode", func(b *testing.B) {
+			raw, err := codec.Encode(entries)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				if err := codec.Decode(raw, &binlog.BinlogEntries{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
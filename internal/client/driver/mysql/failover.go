@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+const (
+	// interval between the checks for a writable target
+	failoverRetryInterval = 2 * time.Second
+)
+
+// TargetReadOnlyError is the task failure when no writable target is found in FailoverTimeout.
+type TargetReadOnlyError struct {
+	Timeout time.Duration
+	// the read-only error which paused the applier
+	Err error
+}
+
+func (e *TargetReadOnlyError) Error() string {
+	return fmt.Sprintf("target is read-only and no writable target is found in %v: %v", e.Timeout, e.Err)
+}
+
+// waitForWritableTarget is called when a transaction failed since the target is read-only.
+// It pauses the worker until the target, or one of FailoverHosts, is writable, and reconnects to it.
+// gen is the failoverGen when the transaction began. If another worker has reconnected since,
+// it returns immediately.
+func (a *Applier) waitForWritableTarget(gen int64, cause error) error {
+	a.failoverLock.Lock()
+	defer a.failoverLock.Unlock()
+
+	if atomic.LoadInt64(&a.failoverGen) != gen {
+		return nil
+	}
+
+	atomic.AddInt64(&a.readOnlyPauses, 1)
+	oldAddr := fmt.Sprintf("%s:%d", a.mysqlContext.ConnectionConfig.Host, a.mysqlContext.ConnectionConfig.Port)
+	a.emitEvent("Target %v is read-only. Applying paused at %v. err: %v",
+		oldAddr, time.Now().Format(time.RFC3339), cause)
+
+	timeout := time.Duration(a.mysqlContext.FailoverTimeout) * time.Second
+	start := time.Now()
+	// The configured host first. It might be resolved to a new instance by DNS.
+	candidates := append([]string{oldAddr}, a.mysqlContext.FailoverHosts...)
+	for {
+		for _, candidate := range candidates {
+			connConfig, err := a.failoverConnectionConfig(candidate)
+			if err != nil {
+				a.logger.Warnf("mysql.applier: bad failover host %v: %v", candidate, err)
+				continue
+			}
+			writable, err := isWritable(connConfig)
+			if err != nil {
+				a.logger.Warnf("mysql.applier: check target %v error: %v", candidate, err)
+				continue
+			} else if !writable {
+				a.logger.Debugf("mysql.applier: target %v is read-only", candidate)
+				continue
+			}
+
+			if err := a.reconnectTarget(connConfig); err != nil {
+				a.logger.Warnf("mysql.applier: reconnect to target %v error: %v", candidate, err)
+				continue
+			}
+			if candidate != oldAddr {
+				atomic.AddInt64(&a.failovers, 1)
+				a.emitEvent("Target failed over from %v to %v", oldAddr, candidate)
+			}
+			a.emitEvent("Target %v is writable. Applying resumed at %v after a pause of %v",
+				candidate, time.Now().Format(time.RFC3339), time.Since(start))
+			return nil
+		}
+
+		if time.Since(start) >= timeout {
+			err := &TargetReadOnlyError{
+				Timeout: timeout,
+				Err:     cause,
+			}
+			a.emitEvent("Failover timed out at %v: %v", time.Now().Format(time.RFC3339), err)
+			return err
+		}
+		select {
+		case <-time.After(failoverRetryInterval):
+		case <-a.shutdownCh:
+			return fmt.Errorf("shutdown while waiting for a writable target")
+		}
+	}
+}
+
+// failoverConnectionConfig returns the target ConnectionConfig with the address replaced by addr ("host:port").
+func (a *Applier) failoverConnectionConfig(addr string) (*umconf.ConnectionConfig, error) {
+	return connectionConfigWithAddr(a.mysqlContext.ConnectionConfig, addr)
+}
+
+// connectionConfigWithAddr returns a copy of connConfig with the address replaced by addr ("host:port").
+func connectionConfigWithAddr(connConfig *umconf.ConnectionConfig, addr string) (*umconf.ConnectionConfig, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	result := *connConfig
+	result.Host = host
+	result.Port = port
+	return &result, nil
+}
+
+func isWritable(connConfig *umconf.ConnectionConfig) (bool, error) {
+	db, err := sql.CreateDB(connConfig.GetDBUri())
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	// super_read_only implies read_only.
+	var readOnly bool
+	if err := db.QueryRow(`select @@global.read_only`).Scan(&readOnly); err != nil {
+		return false, err
+	}
+	return !readOnly, nil
+}
+
+// reconnectTarget replaces the connections of the workers with new ones to connConfig,
+// and re-runs the target validation.
+func (a *Applier) reconnectTarget(connConfig *umconf.ConnectionConfig) error {
+	// Wait for in-flight transactions.
+	for i := range a.dbs {
+		a.dbs[i].DbMutex.Lock()
+		defer a.dbs[i].DbMutex.Unlock()
+	}
+
+	db, err := sql.CreateDB(connConfig.GetDBUri(), a.targetInitSQL()...)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(10 + len(a.dbs))
+	conns, err := sql.CreateConns(db, len(a.dbs))
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	oldDb, oldConnConfig := a.db, a.mysqlContext.ConnectionConfig
+	a.db, a.mysqlContext.ConnectionConfig = db, connConfig
+	err = func() error {
+		if err := a.validateConnection(a.db); err != nil {
+			return err
+		}
+		if err := a.validateServerUUID(); err != nil {
+			return err
+		}
+		if err := a.validateGrants(); err != nil {
+			return err
+		}
+		if err := a.validateAndReadTimeZone(); err != nil {
+			return err
+		}
+		if a.mysqlContext.ApproveHeterogeneous {
+			if err := a.createTableGtidExecutedV3(); err != nil {
+				return err
+			}
+			if err := a.prepareGtidExecutedStmts(conns); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		a.db, a.mysqlContext.ConnectionConfig = oldDb, oldConnConfig
+		db.Close()
+		return err
+	}
+
+	// Statements are prepared on the old connections.
+	for _, schemaItem := range a.tableItems {
+		for _, tableItem := range schemaItem {
+			tableItem.closeStmts()
+		}
+	}
+	for i := range a.dbs {
+		if a.dbs[i].PsDeleteExecutedGtid != nil {
+			a.dbs[i].PsDeleteExecutedGtid.Close()
+		}
+		if a.dbs[i].PsInsertExecutedGtid != nil {
+			a.dbs[i].PsInsertExecutedGtid.Close()
+		}
+		a.dbs[i].Db.Close()
+
+		a.dbs[i].Db = conns[i].Db
+		a.dbs[i].PsDeleteExecutedGtid = conns[i].PsDeleteExecutedGtid
+		a.dbs[i].PsInsertExecutedGtid = conns[i].PsInsertExecutedGtid
+	}
+	oldDb.Close()
+
+	atomic.AddInt64(&a.failoverGen, 1)
+	a.logger.Printf("mysql.applier: reconnected to target %s:%d", connConfig.Host, connConfig.Port)
+	return nil
+}
+
+func (a *Applier) emitEvent(message string, args ...interface{}) {
+	if a.eventEmitter != nil {
+		a.eventEmitter(message, args...)
+	}
+}
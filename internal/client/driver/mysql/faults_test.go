@@ -0,0 +1,232 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	gonats "github.com/nats-io/go-nats"
+	"github.com/satori/go.uuid"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/faults"
+	"github.com/actiontech/dtle/internal/gtid"
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+// The tests below inject failures and check that no GTID is skipped: a transaction is marked
+// replicated only once it is, and it is retried until it is.
+
+const faultsTestSid = "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59"
+
+func runNatsServer(t *testing.T) *gnatsd.Server {
+	s := gnatsd.New(&gnatsd.Options{Host: "127.0.0.1", Port: gnatsd.RANDOM_PORT, NoLog: true, NoSigs: true})
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("nats server is not ready")
+	}
+	return s
+}
+
+func TestExtractorPublish_faults(t *testing.T) {
+	injector := faults.Enable()
+	defer faults.Disable()
+
+	s := runNatsServer(t)
+	defer s.Shutdown()
+	url := fmt.Sprintf("nats://%v", s.Addr())
+	applierConn, err := gonats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer applierConn.Close()
+	var l sync.Mutex
+	var received []string
+	if _, err := applierConn.Subscribe("job1_incr_hete", func(m *gonats.Msg) {
+		l.Lock()
+		received = append(received, string(m.Data))
+		l.Unlock()
+		applierConn.Publish(m.Reply, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	applierConn.Flush()
+
+	extractorConn, err := gonats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extractorConn.Close()
+	e := &Extractor{
+		logger:       log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		natsConn:     extractorConn,
+		transport:    newTransportCounter(),
+		mysqlContext: &config.MySQLDriverConfig{},
+	}
+
+	// A dropped message is sent again.
+	if err := injector.Set(faults.NatsPublish, faults.Fault{DropEvery: 2}); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for gno := 1; gno <= 6; gno++ {
+		gtid := fmt.Sprintf("%v:1-%v", faultsTestSid, gno)
+		want = append(want, gtid)
+		if err := e.publish("job1_incr_hete", gtid, []byte(gtid)); err != nil {
+			t.Fatalf("publish(%v) error = %v", gtid, err)
+		}
+	}
+	l.Lock()
+	got := fmt.Sprint(received)
+	l.Unlock()
+	if got != fmt.Sprint(want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if stat := injector.List()[faults.NatsPublish]; stat.Injected == 0 {
+		t.Errorf("no message was dropped")
+	}
+
+	// A failed publish does not move the checkpoint.
+	if err := injector.Set(faults.NatsPublish, faults.Fault{Error: "connection reset"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.publish("job1_incr_hete", faultsTestSid+":1-7", nil); err == nil {
+		t.Fatalf("publish() succeeded with an injected error")
+	}
+	if e.mysqlContext.Gtid != want[len(want)-1] {
+		t.Errorf("Gtid = %v after a failed publish, want %v", e.mysqlContext.Gtid, want[len(want)-1])
+	}
+}
+
+// faultsDB is a database/sql driver recording the GNOs written to gtid_executed by the committed
+// transactions.
+type faultsDB struct {
+	l         sync.Mutex
+	committed []int64
+}
+
+func (d *faultsDB) Open(name string) (driver.Conn, error) {
+	return &faultsConn{db: d}, nil
+}
+
+type faultsConn struct {
+	db      *faultsDB
+	pending []int64
+}
+
+func (c *faultsConn) Prepare(query string) (driver.Stmt, error) { return &faultsStmt{c}, nil }
+func (c *faultsConn) Close() error                              { return nil }
+func (c *faultsConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *faultsConn) Commit() error {
+	c.db.l.Lock()
+	c.db.committed = append(c.db.committed, c.pending...)
+	c.db.l.Unlock()
+	c.pending = nil
+	return nil
+}
+func (c *faultsConn) Rollback() error {
+	c.pending = nil
+	return nil
+}
+
+type faultsStmt struct {
+	c *faultsConn
+}
+
+func (s *faultsStmt) Close() error  { return nil }
+func (s *faultsStmt) NumInput() int { return -1 }
+func (s *faultsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) == 2 {
+		// insert into gtid_executed
+		s.c.pending = append(s.c.pending, args[1].(int64))
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *faultsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestApplyBinlogEvent_faults(t *testing.T) {
+	injector := faults.Enable()
+	defer faults.Disable()
+
+	db := &faultsDB{}
+	gosql.Register("dtle-faults-test", db)
+	sqlDB, err := gosql.Open("dtle-faults-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := conn.PrepareContext(context.Background(), "replace into gtid_executed values (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdownCh := make(chan struct{})
+	defer close(shutdownCh)
+	a := &Applier{
+		logger:       log.NewEntry(log.New(os.Stderr, log.ErrorLevel)),
+		mysqlContext: &config.MySQLDriverConfig{},
+		dbs:          []*sql.Conn{{DbMutex: &sync.Mutex{}, Db: conn, PsInsertExecutedGtid: ps}},
+		mtsManager:   NewMtsManager(shutdownCh),
+		gtidApplied:  make(gtid.Set),
+	}
+	go a.mtsManager.LcUpdater()
+
+	// Every third commit fails. The failed transaction is retried, as it is resent from the
+	// checkpoint after the task restarts.
+	if err := injector.Set(faults.ApplierCommit, faults.Fault{DropEvery: 3}); err != nil {
+		t.Fatal(err)
+	}
+	sid := uuid.FromStringOrNil(faultsTestSid)
+	received := make(gtid.Set)
+	failures := 0
+	for gno := int64(1); gno <= 6; gno++ {
+		entry := &binlog.BinlogEntry{Coordinates: base.BinlogCoordinateTx{SID: sid, GNO: gno, SeqenceNumber: gno}}
+		received.AddGtid(sid, gno)
+		for {
+			err := a.ApplyBinlogEvent(0, entry)
+			if err == nil {
+				break
+			}
+			failures++
+			if failures > 10 {
+				t.Fatalf("gno %v: ApplyBinlogEvent() error = %v", gno, err)
+			}
+			if a.gtidApplied.ContainsGtid(sid, gno) {
+				t.Fatalf("gno %v is marked applied after a failed commit", gno)
+			}
+		}
+	}
+	if failures == 0 {
+		t.Errorf("no commit failed")
+	}
+	if gap := received.Subtract(a.gtidApplied).Count(); gap != 0 {
+		t.Errorf("%v transactions received but not applied", gap)
+	}
+	if got := fmt.Sprint(db.committed); got != "[1 2 3 4 5 6]" {
+		t.Errorf("committed gtid_executed = %v, want 1 to 6 once each", got)
+	}
+	if !a.mtsManager.WaitForSequence(6) {
+		t.Errorf("the transactions are not all marked executed")
+	}
+}
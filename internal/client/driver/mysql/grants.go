@@ -0,0 +1,241 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/g"
+)
+
+// grantSet is the privileges parsed from SHOW GRANTS, by level: "*" for *.*,
+// "db" for `db`.* and "db.tb" for `db`.`tb`. Column privileges are ignored.
+type grantSet map[string]map[string]bool
+
+// privilegeRequirement is a privilege needed on *.* (empty Schema), on `Schema`.* (empty Table)
+// or on `Schema`.`Table`.
+type privilegeRequirement struct {
+	Privilege string
+	Schema    string
+	Table     string
+}
+
+func (r privilegeRequirement) String() string {
+	if r.Schema == "" {
+		return fmt.Sprintf("%v ON *.*", r.Privilege)
+	}
+	if r.Table == "" {
+		return fmt.Sprintf("%v ON `%v`.*", r.Privilege, r.Schema)
+	}
+	return fmt.Sprintf("%v ON `%v`.`%v`", r.Privilege, r.Schema, r.Table)
+}
+
+func showGrants(db sql.QueryAble) (grants []string, err error) {
+	err = sql.QueryRowsMap(db, `show grants for current_user()`, func(rowMap sql.RowMap) error {
+		for _, grantData := range rowMap {
+			grants = append(grants, grantData.String)
+		}
+		return nil
+	})
+	return grants, err
+}
+
+// parseGrants parses statements like "GRANT SELECT, INSERT ON `db`.* TO 'u'@'%'".
+// Other statements (e.g. granting roles or proxy) are skipped.
+func parseGrants(grants []string) grantSet {
+	gs := make(grantSet)
+	for _, grant := range grants {
+		if !strings.HasPrefix(grant, "GRANT ") {
+			continue
+		}
+		on := strings.Index(grant, " ON ")
+		to := strings.LastIndex(grant, " TO ")
+		if on < 0 || to < on {
+			continue
+		}
+
+		level := strings.Replace(strings.TrimSpace(grant[on+len(" ON "):to]), "`", "", -1)
+		level = strings.Replace(level, `\_`, "_", -1)
+		if strings.HasPrefix(level, "PROCEDURE ") || strings.HasPrefix(level, "FUNCTION ") {
+			continue
+		}
+		level = strings.TrimPrefix(level, "TABLE ")
+		switch {
+		case level == "*.*" || level == "*":
+			level = "*"
+		case strings.HasSuffix(level, ".*"):
+			level = strings.TrimSuffix(level, ".*")
+		}
+
+		privs, ok := gs[level]
+		if !ok {
+			privs = make(map[string]bool)
+			gs[level] = privs
+		}
+		for _, priv := range strings.Split(grant[len("GRANT "):on], ",") {
+			priv = strings.ToUpper(strings.TrimSpace(priv))
+			if strings.Contains(priv, "(") {
+				continue
+			}
+			if priv == "ALL PRIVILEGES" {
+				priv = "ALL"
+			}
+			privs[priv] = true
+		}
+	}
+	return gs
+}
+
+// has tells whether the privilege is granted on the level of r or on a level above it.
+func (gs grantSet) has(r privilegeRequirement) bool {
+	levels := []string{"*"}
+	if r.Schema != "" {
+		levels = append(levels, r.Schema)
+		if r.Table != "" {
+			levels = append(levels, fmt.Sprintf("%v.%v", r.Schema, r.Table))
+		}
+	}
+	for _, level := range levels {
+		privs := gs[level]
+		if privs["ALL"] || privs[r.Privilege] {
+			return true
+		}
+		// SUPER allows what REPLICATION CLIENT does.
+		if r.Privilege == "REPLICATION CLIENT" && privs["SUPER"] {
+			return true
+		}
+	}
+	return false
+}
+
+// missingPrivileges returns the requirements not granted, without duplicates.
+func (gs grantSet) missingPrivileges(required []privilegeRequirement) (missing []string) {
+	seen := make(map[privilegeRequirement]bool)
+	for _, r := range required {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if !gs.has(r) {
+			missing = append(missing, r.String())
+		}
+	}
+	return missing
+}
+
+// sourcePrivileges are the privileges needed by the extractor on the source.
+func sourcePrivileges(dataSources []*config.DataSource) []privilegeRequirement {
+	required := []privilegeRequirement{
+		{Privilege: "REPLICATION SLAVE"},
+		{Privilege: "REPLICATION CLIENT"},
+	}
+	if len(dataSources) == 0 {
+		return append(required, privilegeRequirement{Privilege: "SELECT"})
+	}
+	for _, db := range dataSources {
+		if db.TableSchema == "" {
+			// by TableSchemaRegex. The schemas are not known yet.
+			required = append(required, privilegeRequirement{Privilege: "SELECT"})
+			continue
+		}
+		if len(db.Tables) == 0 {
+			required = append(required, privilegeRequirement{Privilege: "SELECT", Schema: db.TableSchema})
+		}
+		for _, tb := range db.Tables {
+			required = append(required, privilegeRequirement{
+				Privilege: "SELECT", Schema: db.TableSchema, Table: tb.TableName})
+		}
+	}
+	return required
+}
+
+// targetPrivileges are the privileges needed by the applier on the target.
+// DML types excluded by DmlFilter are not needed.
+func targetPrivileges(cfg *config.MySQLDriverConfig, dmlFilterIndex map[string]map[binlog.EventDML]bool) []privilegeRequirement {
+	var required []privilegeRequirement
+	// for the gtid_executed table of dtle
+	for _, priv := range []string{"CREATE", "SELECT", "INSERT", "UPDATE", "DELETE"} {
+		required = append(required, privilegeRequirement{Privilege: priv, Schema: g.DtleSchemaName})
+	}
+
+	add := func(schema, table string, filter map[binlog.EventDML]bool) {
+		dmls := []struct {
+			dml  binlog.EventDML
+			priv string
+		}{{binlog.InsertDML, "INSERT"}, {binlog.UpdateDML, "UPDATE"}, {binlog.DeleteDML, "DELETE"}}
+		for _, dml := range dmls {
+			if !filter[dml.dml] {
+				required = append(required, privilegeRequirement{Privilege: dml.priv, Schema: schema, Table: table})
+			}
+		}
+		if !cfg.SkipCreateDbTable {
+			required = append(required, privilegeRequirement{Privilege: "CREATE", Schema: schema, Table: table})
+		}
+		if cfg.DropTableIfExists {
+			required = append(required, privilegeRequirement{Privilege: "DROP", Schema: schema, Table: table})
+		}
+	}
+
+	if len(cfg.ReplicateDoDb) == 0 {
+		add("", "", nil)
+		return required
+	}
+	for _, db := range cfg.ReplicateDoDb {
+		schema := db.TableSchema
+		if db.TableSchemaRename != "" {
+			schema = db.TableSchemaRename
+		}
+		if schema == "" {
+			add("", "", nil)
+			continue
+		}
+		if len(db.Tables) == 0 {
+			add(schema, "", nil)
+		}
+		for _, tb := range db.Tables {
+			tbSchema := schema
+			if tb.TableSchemaRename != "" {
+				tbSchema = tb.TableSchemaRename
+			}
+			table := tb.TableName
+			if tb.TableRename != "" {
+				table = tb.TableRename
+			}
+			if table == "" {
+				// by TableRegex
+				add(tbSchema, "", nil)
+				continue
+			}
+			add(tbSchema, table, dmlFilterIndex[fmt.Sprintf("%v.%v", db.TableSchema, tb.TableName)])
+		}
+	}
+	return required
+}
+
+// MissingCreateTablePrivileges returns the CREATE and DROP privileges needed on the target
+// to create the tables (see SkipCreateDbTable and DropTableIfExists) and not granted.
+func MissingCreateTablePrivileges(db sql.QueryAble, cfg *config.MySQLDriverConfig) ([]string, error) {
+	grants, err := showGrants(db)
+	if err != nil {
+		return nil, err
+	}
+	var required []privilegeRequirement
+	for _, r := range targetPrivileges(cfg, nil) {
+		if r.Schema != g.DtleSchemaName && (r.Privilege == "CREATE" || r.Privilege == "DROP") {
+			required = append(required, r)
+		}
+	}
+	return parseGrants(grants).missingPrivileges(required), nil
+}
+
+func missingPrivilegesError(side string, missing []string) error {
+	return fmt.Errorf("user has insufficient privileges for %v. Missing: %v", side, strings.Join(missing, ", "))
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// handoffKeys are what a transaction changes: the tables, as "schema.table" on the target, the
+// schemas changed as a whole (e.g. by CREATE DATABASE), and whether it may change any table.
+type handoffKeys struct {
+	tables  []string
+	schemas []string
+	all     bool
+}
+
+func handoffKeysOf(entry *binlog.BinlogEntry) (keys handoffKeys) {
+	for i := range entry.Events {
+		event := &entry.Events[i]
+		schema := event.DatabaseName
+		if schema == "" {
+			schema = event.CurrentSchema
+		}
+		switch {
+		case schema != "" && event.TableName != "":
+			keys.tables = append(keys.tables, fmt.Sprintf("%s.%s", schema, event.TableName))
+		case schema != "":
+			keys.schemas = append(keys.schemas, schema)
+		default:
+			keys.all = true
+		}
+	}
+	return keys
+}
+
+// schemaOf returns the schema of a "schema.table".
+func schemaOf(table string) string {
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		return table[:i]
+	}
+	return table
+}
+
+// tableSet counts the keys of transactions, to tell whether another one overlaps them.
+type tableSet struct {
+	tables       map[string]int
+	tableSchemas map[string]int // the schemas of tables
+	schemas      map[string]int
+	all          int
+}
+
+func newTableSet() *tableSet {
+	return &tableSet{
+		tables:       make(map[string]int),
+		tableSchemas: make(map[string]int),
+		schemas:      make(map[string]int),
+	}
+}
+
+func (s *tableSet) add(keys handoffKeys) {
+	for _, table := range keys.tables {
+		s.tables[table]++
+		s.tableSchemas[schemaOf(table)]++
+	}
+	for _, schema := range keys.schemas {
+		s.schemas[schema]++
+	}
+	if keys.all {
+		s.all++
+	}
+}
+
+func (s *tableSet) removeTable(table string) {
+	if s.tables[table] == 0 {
+		return
+	}
+	s.tables[table]--
+	if s.tables[table] == 0 {
+		delete(s.tables, table)
+	}
+	schema := schemaOf(table)
+	s.tableSchemas[schema]--
+	if s.tableSchemas[schema] == 0 {
+		delete(s.tableSchemas, schema)
+	}
+}
+
+func (s *tableSet) empty() bool {
+	return len(s.tables) == 0 && len(s.schemas) == 0 && s.all == 0
+}
+
+func (s *tableSet) overlaps(keys handoffKeys) bool {
+	if keys.all {
+		return !s.empty()
+	}
+	if s.all > 0 {
+		return true
+	}
+	for _, schema := range keys.schemas {
+		if s.schemas[schema] > 0 || s.tableSchemas[schema] > 0 {
+			return true
+		}
+	}
+	for _, table := range keys.tables {
+		if s.tables[table] > 0 || s.schemas[schemaOf(table)] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type heldTx struct {
+	entry *binlog.BinlogEntry
+	keys  handoffKeys
+}
+
+// tableHandoff is the watermark handoff of ConcurrentIncrementalCopy. The transactions streamed
+// during the full copy are held until the tables they change are copied, as the rows copied are
+// those of the snapshot the binlog is streamed from. A transaction is also held after an earlier
+// held one changing a table of it, so that the transactions on a table are applied in the order
+// of the binlog. The others are released at once.
+//
+// The released transactions are applied one at a time (without the MTS of the binlog), as they
+// may be out of the order of the binlog.
+type tableHandoff struct {
+	lock    sync.Mutex
+	pending *tableSet // the tables not copied yet
+	held    []*heldTx // in the order of the binlog
+	heldSet *tableSet // the keys of held
+	// the sizes in the binlog of the held transactions, and its limit. 0 for no limit
+	heldBytes int64
+	maxBytes  int64
+	released  int64
+	throttled int64
+	finished  bool
+	enqueue   func(*binlog.BinlogEntry)
+}
+
+// newTableHandoff returns the handoff of the tables to be copied ("schema.table" on the target).
+// enqueue applies a released transaction, in order.
+func newTableHandoff(tables []string, maxBytes int64, enqueue func(*binlog.BinlogEntry)) *tableHandoff {
+	h := &tableHandoff{
+		pending:  newTableSet(),
+		heldSet:  newTableSet(),
+		maxBytes: maxBytes,
+		enqueue:  enqueue,
+	}
+	h.pending.add(handoffKeys{tables: tables})
+	return h
+}
+
+// full tells whether the held transactions reach the limit. The streaming waits for them to be
+// released then.
+func (h *tableHandoff) full() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.finished || h.maxBytes <= 0 || h.heldBytes < h.maxBytes {
+		return false
+	}
+	h.throttled++
+	return true
+}
+
+// admit holds or releases the transactions streamed, in the order of the binlog.
+func (h *tableHandoff) admit(entries []*binlog.BinlogEntry) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, entry := range entries {
+		if h.finished {
+			h.enqueue(entry)
+			continue
+		}
+		serialize(entry)
+		keys := handoffKeysOf(entry)
+		if !h.pending.overlaps(keys) && !h.heldSet.overlaps(keys) {
+			h.enqueue(entry)
+			continue
+		}
+		h.held = append(h.held, &heldTx{entry: entry, keys: keys})
+		h.heldSet.add(keys)
+		h.heldBytes += int64(entry.OriginalSize)
+	}
+}
+
+// tableCopied releases the transactions held for a table ("schema.table" on the target) once
+// it is copied.
+func (h *tableHandoff) tableCopied(table string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.pending.removeTable(table)
+	h.release()
+}
+
+// finish releases all the held transactions, once the full copy is complete. The transactions
+// streamed after it are not held.
+func (h *tableHandoff) finish() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.pending = newTableSet()
+	h.release()
+	h.finished = true
+}
+
+// release releases the held transactions which changed no table not copied, nor a table of an
+// earlier held one. with lock held
+func (h *tableHandoff) release() {
+	blocked := newTableSet()
+	var held []*heldTx
+	h.heldBytes = 0
+	for _, tx := range h.held {
+		if h.pending.overlaps(tx.keys) || blocked.overlaps(tx.keys) {
+			blocked.add(tx.keys)
+			held = append(held, tx)
+			h.heldBytes += int64(tx.entry.OriginalSize)
+			continue
+		}
+		h.enqueue(tx.entry)
+		h.released++
+	}
+	h.held = held
+	h.heldSet = blocked
+}
+
+func (h *tableHandoff) stat() *models.ConcurrentCopyStat {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return &models.ConcurrentCopyStat{
+		PendingTables: len(h.pending.tables),
+		HeldTxs:       len(h.held),
+		HeldBytes:     h.heldBytes,
+		ReleasedTxs:   h.released,
+		Throttled:     h.throttled,
+	}
+}
+
+// serialize makes the transaction applied without the MTS of the binlog, as on MySQL 5.6.
+func serialize(entry *binlog.BinlogEntry) {
+	entry.Coordinates.SeqenceNumber = 0
+	entry.Coordinates.LastCommitted = 0
+}
+
+// handoffTableName returns the name of a table copied on the target, "schema.table", as in the
+// transactions streamed.
+func handoffTableName(cfg *config.MySQLDriverConfig, t *config.Table) string {
+	table := t.TableName
+	if t.TableRename != "" {
+		table = t.TableRename
+	}
+	return fmt.Sprintf("%s.%s", cfg.TargetSchema(t.TableSchema), table)
+}
+
+// streamDuringCopy streams the binlog from the snapshot of the full copy, for
+// ConcurrentIncrementalCopy, after telling the applier the tables to be copied. The tables copied
+// before a resume are not held.
+func (e *Extractor) streamDuringCopy(resume *models.DumpProgress) error {
+	var tables []string
+	for _, db := range e.replicateDoDb {
+		for _, t := range db.Tables {
+			if !resume.Completed(t.TableSchema, t.TableName) {
+				tables = append(tables, handoffTableName(e.mysqlContext, t))
+			}
+		}
+	}
+	if err := e.encodeDumpEntry(&DumpEntry{HandoffStart: true, HandoffTables: tables}); err != nil {
+		return err
+	}
+
+	if err := e.skipNonChannelGtids(e.initialBinlogCoordinates); err != nil {
+		return err
+	}
+	if err := e.initBinlogReader(e.initialBinlogCoordinates); err != nil {
+		return err
+	}
+	if err := e.beginStreaming(); err != nil {
+		return err
+	}
+	e.streamingDuringCopy = true
+	e.logger.Printf("mysql.extractor: streaming the binlog from %v during the full copy of %d tables",
+		e.initialBinlogCoordinates.GtidSet, len(tables))
+	return nil
+}
+
+// startHandoff starts holding the transactions streamed during the full copy, until the tables
+// they change are copied.
+func (a *Applier) startHandoff(tables []string) {
+	maxBytes := int64(a.mysqlContext.ConcurrentCopyMaxBufferMB) * 1024 * 1024
+	a.handoff.Store(newTableHandoff(tables, maxBytes, func(entry *binlog.BinlogEntry) {
+		a.applyDataEntryQueue <- entry
+	}))
+	a.logger.Printf("mysql.applier: applying the binlog streamed during the full copy of %d tables, as they are copied",
+		len(tables))
+}
+
+// currentHandoff returns the *tableHandoff of the full copy of ConcurrentIncrementalCopy, or nil.
+func (a *Applier) currentHandoff() *tableHandoff {
+	h, _ := a.handoff.Load().(*tableHandoff)
+	return h
+}
+
+// handoffTableCopied releases the transactions held for a table, after its rows are applied.
+func (a *Applier) handoffTableCopied(table string) {
+	if h := a.currentHandoff(); h != nil {
+		a.logger.Debugf("mysql.applier: table %v copied. releasing its transactions", table)
+		h.tableCopied(table)
+	}
+}
+
+// finishHandoff releases the transactions still held once the full copy is complete.
+func (a *Applier) finishHandoff(h *tableHandoff) {
+	stat := h.stat()
+	h.finish()
+	a.handoff.Store((*tableHandoff)(nil))
+	a.logger.Printf("mysql.applier: full copy complete. released %d held transactions, %d at its end",
+		stat.ReleasedTxs+int64(stat.HeldTxs), stat.HeldTxs)
+}
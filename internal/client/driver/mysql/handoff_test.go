@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+)
+
+func TestTableHandoff(t *testing.T) {
+	// a transaction changing the tables, "schema.table" or "schema" for the whole schema
+	tx := func(gno int64, tables ...string) *binlog.BinlogEntry {
+		entry := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{GNO: gno, LastCommitted: gno - 1, SeqenceNumber: gno})
+		for _, table := range tables {
+			schema := schemaOf(table)
+			name := ""
+			if schema != table {
+				name = table[len(schema)+1:]
+			}
+			entry.Events = append(entry.Events, binlog.NewDataEvent(schema, name, binlog.InsertDML, 1))
+		}
+		entry.OriginalSize = 100
+		return entry
+	}
+	var applied []int64
+	h := newTableHandoff([]string{"db1.t1", "db1.t2"}, 300, func(entry *binlog.BinlogEntry) {
+		applied = append(applied, entry.Coordinates.GNO)
+	})
+	expect := func(what string, want ...int64) {
+		t.Helper()
+		if !reflect.DeepEqual(applied, want) {
+			t.Errorf("%v: applied %v, want %v", what, applied, want)
+		}
+	}
+
+	h.admit([]*binlog.BinlogEntry{
+		tx(1, "db1.t1"),
+		tx(2, "db1.t3"), // not copied
+		tx(3, "db1.t2"),
+		tx(4, "db1.t3", "db1.t1"),
+		tx(5, "db1.t3"), // after 4 on t3
+		tx(6, "db2"),
+	})
+	expect("admit", 2, 6)
+	if stat := h.stat(); stat.PendingTables != 2 || stat.HeldTxs != 4 || stat.HeldBytes != 400 {
+		t.Errorf("stat() = %+v, want 2 tables pending, 4 transactions held", stat)
+	}
+	if !h.full() {
+		t.Errorf("full() = false with 400 bytes held, want true")
+	}
+
+	h.tableCopied("db1.t2")
+	expect("db1.t2 copied", 2, 6, 3)
+	h.admit([]*binlog.BinlogEntry{tx(7, "db1")}) // the schema of a held transaction
+	expect("db1 admitted", 2, 6, 3)
+	h.tableCopied("db1.t1")
+	expect("db1.t1 copied", 2, 6, 3, 1, 4, 5, 7)
+	if stat := h.stat(); stat.PendingTables != 0 || stat.HeldTxs != 0 || stat.ReleasedTxs != 5 {
+		t.Errorf("stat() = %+v, want none pending or held, 5 released", stat)
+	}
+
+	h.admit([]*binlog.BinlogEntry{tx(8, "db1.t1")})
+	h.finish()
+	h.admit([]*binlog.BinlogEntry{tx(9, "db1.t1")})
+	expect("finish", 2, 6, 3, 1, 4, 5, 7, 8, 9)
+	if h.full() {
+		t.Errorf("full() = true after finish(), want false")
+	}
+}
+
+func TestTableHandoff_serialize(t *testing.T) {
+	var applied []*binlog.BinlogEntry
+	h := newTableHandoff([]string{"db1.t1"}, 0, func(entry *binlog.BinlogEntry) {
+		applied = append(applied, entry)
+	})
+	entry := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{GNO: 1, LastCommitted: 4, SeqenceNumber: 5})
+	entry.Events = append(entry.Events, binlog.NewDataEvent("", "", binlog.NotDML, 0))
+	h.admit([]*binlog.BinlogEntry{entry})
+	if len(applied) != 0 {
+		t.Fatalf("a transaction of no known table applied with a table pending")
+	}
+	h.finish()
+	if len(applied) != 1 || entry.Coordinates.SeqenceNumber != 0 || entry.Coordinates.LastCommitted != 0 {
+		t.Errorf("finish() applied %v, %+v, want it without MTS", len(applied), entry.Coordinates)
+	}
+
+	after := binlog.NewBinlogEntryAt(base.BinlogCoordinateTx{GNO: 2, LastCommitted: 5, SeqenceNumber: 6})
+	h.admit([]*binlog.BinlogEntry{after})
+	if after.Coordinates.SeqenceNumber != 6 {
+		t.Errorf("a transaction after the copy: SeqenceNumber = %v, want kept", after.Coordinates.SeqenceNumber)
+	}
+}
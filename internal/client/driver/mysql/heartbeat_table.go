@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	gosql "database/sql"
+	"fmt"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+// heartbeatTsLayout is the layout of the ts column of pt-heartbeat, in UTC (pt-heartbeat --utc).
+const heartbeatTsLayout = "2006-01-02T15:04:05.000000"
+
+// heartbeatTableWriter writes the row of the target in HeartbeatTable periodically. The writes go
+// through the pool of the applier, not the apply, so they are not in the TableStats.
+type heartbeatTableWriter struct {
+	// the pool of the applier, replaced on a failover of the target
+	db       func() *gosql.DB
+	replace  string
+	serverId uint32 // the key of the row: the server_id of the target
+	interval time.Duration
+	// the time and the source binlog position of the target at now
+	position   func(now time.Time) (ts time.Time, file string, pos int64)
+	logger     *log.Entry
+	shutdownCh chan struct{}
+	failing    bool
+}
+
+// initHeartbeatTable creates HeartbeatTable if set, for the writes after the start.
+func (a *Applier) initHeartbeatTable() error {
+	schema, table, err := a.mysqlContext.ValidateHeartbeatTable()
+	if err != nil || schema == "" {
+		return err
+	}
+	name := fmt.Sprintf("%v.%v", sql.EscapeName(schema), sql.EscapeName(table))
+	// the table of pt-heartbeat
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+			ts varchar(26) NOT NULL,
+			server_id int unsigned NOT NULL PRIMARY KEY,
+			file varchar(255) DEFAULT NULL,
+			position bigint unsigned DEFAULT NULL,
+			relay_master_log_file varchar(255) DEFAULT NULL,
+			exec_master_log_pos bigint unsigned DEFAULT NULL
+		)`, name)
+	if _, err := a.db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %v", sql.EscapeName(schema))); err != nil {
+		return fmt.Errorf("failed to create the schema of HeartbeatTable: %v", err)
+	}
+	if _, err := a.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create HeartbeatTable: %v", err)
+	}
+
+	replace := fmt.Sprintf("REPLACE INTO %v (ts, server_id, file, position, relay_master_log_file, exec_master_log_pos) "+
+		"VALUES (?, ?, ?, ?, NULL, NULL)", name)
+	w := &heartbeatTableWriter{
+		db:         func() *gosql.DB { return a.db },
+		replace:    replace,
+		interval:   time.Duration(a.mysqlContext.HeartbeatTableInterval) * time.Second,
+		position:   a.heartbeatPosition,
+		logger:     a.logger,
+		shutdownCh: a.shutdownCh,
+	}
+	if err := a.db.QueryRow(`select @@global.server_id`).Scan(&w.serverId); err != nil {
+		return err
+	}
+	a.heartbeatTable = w
+	a.logger.Printf("mysql.applier: Writing the heartbeat to %v every %v, with server_id %v",
+		a.mysqlContext.HeartbeatTable, w.interval, w.serverId)
+	return nil
+}
+
+// heartbeatPosition returns the time and the source binlog position of the target at now. The
+// time is the one of the last applied transaction on the source, or now if all the transactions
+// received are applied, so the lag of pt-heartbeat is 0 while the source is idle.
+func (a *Applier) heartbeatPosition(now time.Time) (ts time.Time, file string, pos int64) {
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	ts = now
+	if a.gtidReceived.Subtract(a.gtidApplied).Count() > 0 && a.lastAppliedTs > 0 {
+		ts = time.Unix(int64(a.lastAppliedTs), 0)
+	}
+	return ts, a.lastAppliedFile, a.lastAppliedPos
+}
+
+func (w *heartbeatTableWriter) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.write(time.Now()); err != nil {
+			if w.failing {
+				w.logger.Debugf("mysql.applier: heartbeat table write error: %v", err)
+			} else {
+				w.logger.Warnf("mysql.applier: heartbeat table write error: %v", err)
+			}
+			w.failing = true
+		} else {
+			w.failing = false
+		}
+	}
+}
+
+func (w *heartbeatTableWriter) write(now time.Time) error {
+	ts, file, pos := w.position(now)
+	var fileArg, posArg interface{}
+	if file != "" {
+		fileArg, posArg = file, pos
+	}
+	_, err := w.db().Exec(w.replace, ts.UTC().Format(heartbeatTsLayout), w.serverId, fileArg, posArg)
+	return err
+}
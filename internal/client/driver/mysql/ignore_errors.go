@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"strconv"
+	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// warnRiskyIgnoreErrorCodes warns of the codes of IgnoreErrorCodes which may hide a diverging
+// target, in the log and in the task events.
+func (a *Applier) warnRiskyIgnoreErrorCodes() {
+	if len(a.mysqlContext.IgnoreErrorCodes) > 0 {
+		a.logger.Printf("mysql.applier: IgnoreErrorCodes: %v", a.mysqlContext.IgnoreErrorCodes)
+	}
+	risky := a.mysqlContext.RiskyIgnoreErrorCodes()
+	if len(risky) == 0 {
+		return
+	}
+	a.logger.Warnf("mysql.applier: WARNING: IgnoreErrorCodes has %v. Ignoring them may lose rows or"+
+		" leave the target diverging from the source. Set IgnoreRiskyErrorCodes if intended",
+		strings.Join(risky, ", "))
+	a.emitEvent("WARNING: IgnoreErrorCodes has %v. Ignoring them may lose rows or"+
+		" leave the target diverging from the source. Set IgnoreRiskyErrorCodes if intended",
+		strings.Join(risky, ", "))
+}
+
+// ignoresError tells whether err is a MySQL error of IgnoreErrorCodes, and if so, logs and
+// counts it. what describes the statement.
+func (a *Applier) ignoresError(err error, what string) bool {
+	mysqlErr, ok := err.(*mysqldriver.MySQLError)
+	if !ok {
+		return false
+	}
+	ignored := false
+	for _, code := range a.mysqlContext.IgnoreErrorCodes {
+		if code == mysqlErr.Number {
+			ignored = true
+			break
+		}
+	}
+	if !ignored {
+		return false
+	}
+	a.logger.Warnf("mysql.applier: ignore error of IgnoreErrorCodes on %v: %v", what, err)
+
+	a.ignoredErrorsLock.Lock()
+	defer a.ignoredErrorsLock.Unlock()
+	if a.ignoredErrors == nil {
+		a.ignoredErrors = make(map[string]int64)
+	}
+	a.ignoredErrors[strconv.Itoa(int(mysqlErr.Number))]++
+	return true
+}
+
+// ignoredErrorCounts returns the errors ignored for IgnoreErrorCodes, by code.
+func (a *Applier) ignoredErrorCounts() map[string]int64 {
+	a.ignoredErrorsLock.Lock()
+	defer a.ignoredErrorsLock.Unlock()
+	if len(a.ignoredErrors) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, len(a.ignoredErrors))
+	for code, n := range a.ignoredErrors {
+		counts[code] = n
+	}
+	return counts
+}
@@ -38,7 +38,7 @@ func NewInspector(ctx *uconf.MySQLDriverConfig, logger *log.Entry) *Inspector {
 
 func (i *Inspector) InitDBConnections() (err error) {
 	inspectorUri := i.mysqlContext.ConnectionConfig.GetDBUri()
-	if i.db, err = usql.CreateDB(inspectorUri); err != nil {
+	if i.db, err = usql.CreateDB(inspectorUri, i.mysqlContext.InitSQL...); err != nil {
 		return err
 	}
 	if err := i.validateConnection(); err != nil {
@@ -131,6 +131,17 @@ func (i *Inspector) ValidateOriginalTable(databaseName, tableName string, table
 			}
 		}
 	}
+	hasPrimaryKey := false
+	for _, uk := range uniqueKeys {
+		if uk.IsPrimary() {
+			hasPrimaryKey = true
+		}
+	}
+	if "MINIMAL" == i.mysqlContext.BinlogRowImage && !hasPrimaryKey {
+		// The before image of a MINIMAL update/delete contains only the PK. Rows could not be matched otherwise.
+		return fmt.Errorf("table %s.%s has no primary key. It cannot be replicated with binlog_row_image=MINIMAL. Set binlog_row_image to FULL or NOBLOB, or add a primary key",
+			databaseName, tableName)
+	}
 	if table.UseUniqueKey == nil {
 		i.logger.Warnf("No valid unique key found for table %s.%s. It will be slow on large table.", table.TableSchema, table.TableName)
 	} else {
@@ -190,6 +201,19 @@ func (i *Inspector) validateGrants() error {
 		i.logger.Debugf("mysql.inspector: skipping priv check")
 		return nil
 	}
+	if i.mysqlContext.StrictPrivilegeCheck {
+		grants, err := showGrants(i.db)
+		if err != nil {
+			return err
+		}
+		gs := parseGrants(grants)
+		i.mysqlContext.HasSuperPrivilege = gs.has(privilegeRequirement{Privilege: "SUPER"})
+		if missing := gs.missingPrivileges(sourcePrivileges(i.mysqlContext.ReplicateDoDb)); len(missing) > 0 {
+			return missingPrivilegesError("extractor", missing)
+		}
+		i.logger.Printf("mysql.inspector: User has the privileges on the replicated tables")
+		return nil
+	}
 
 	query := `show grants for current_user()`
 	foundAll := false
@@ -271,11 +295,26 @@ func (i *Inspector) validateBinlogs() error {
 		i.mysqlContext.BinlogRowImage = "FULL"
 	}
 	i.mysqlContext.BinlogRowImage = strings.ToUpper(i.mysqlContext.BinlogRowImage)
+	i.mysqlContext.BinlogCompression = binlogTransactionCompression(i.db)
+	if i.mysqlContext.BinlogCompression {
+		i.logger.Printf("mysql.inspector: binlog_transaction_compression is ON on %s:%d. the compressed transactions are decompressed",
+			i.mysqlContext.ConnectionConfig.Host, i.mysqlContext.ConnectionConfig.Port)
+	}
 
 	i.logger.Printf("mysql.inspector: Binary logs validated on %s:%d", i.mysqlContext.ConnectionConfig.Host, i.mysqlContext.ConnectionConfig.Port)
 	return nil
 }
 
+// binlogTransactionCompression tells whether the server compresses the transactions in its
+// binlog. false before MySQL 8.0.20, which has no binlog_transaction_compression.
+func binlogTransactionCompression(db *gosql.DB) bool {
+	var compression bool
+	if err := db.QueryRow(`select @@global.binlog_transaction_compression`).Scan(&compression); err != nil {
+		return false
+	}
+	return compression
+}
+
 // validateTable makes sure the table we need to operate on actually exists
 func (i *Inspector) validateTable(databaseName, tableName string) error {
 	query := fmt.Sprintf(`show table status from %s like '%s'`, usql.EscapeName(databaseName), tableName)
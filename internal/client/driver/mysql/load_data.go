@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"bytes"
+	gosql "database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+
+	"github.com/actiontech/dtle/internal/auxdisk"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// isBinaryColumn tells whether the values of the column are written in hex to a LOAD DATA file.
+// They are loaded without a charset conversion.
+func isBinaryColumn(column *umconf.Column) bool {
+	switch column.Type {
+	case umconf.BinaryColumnType, umconf.VarbinaryColumnType, umconf.BlobColumnType, umconf.BitColumnType:
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeLoadData writes the rows in the default format of LOAD DATA: fields terminated by tab,
+// lines by newline, escaped by backslash, and NULL as \N. Binary values are in hex.
+func encodeLoadData(columns []umconf.Column, rows [][]*interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("a row has %v values for %v columns", len(row), len(columns))
+		}
+		for j, value := range row {
+			if j > 0 {
+				buf.WriteByte('\t')
+			}
+			if *value == nil {
+				buf.WriteString(`\N`)
+				continue
+			}
+			bs := (*value).([]byte)
+			if isBinaryColumn(&columns[j]) {
+				buf.WriteString(hex.EncodeToString(bs))
+				continue
+			}
+			for _, b := range bs {
+				switch b {
+				case 0:
+					buf.WriteString(`\0`)
+				case '\t':
+					buf.WriteString(`\t`)
+				case '\n':
+					buf.WriteString(`\n`)
+				case '\r':
+					buf.WriteString(`\r`)
+				case '\\':
+					buf.WriteString(`\\`)
+				default:
+					buf.WriteByte(b)
+				}
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// loadDataStatement loads the file into the table, replacing or ignoring the conflicting rows by
+// ConflictPolicy. Binary columns are read into user variables, and unhexed in the SET clause.
+func loadDataStatement(path, schema, table, charset, policy string, columns []umconf.Column) string {
+	var targets, sets []string
+	for i := range columns {
+		name := sql.EscapeName(columns[i].Name)
+		if isBinaryColumn(&columns[i]) {
+			variable := fmt.Sprintf("@dtle_col%d", i)
+			targets = append(targets, variable)
+			sets = append(sets, fmt.Sprintf("%s = UNHEX(%s)", name, variable))
+		} else {
+			targets = append(targets, name)
+		}
+	}
+	modifier := "REPLACE"
+	if policy == config.ConflictPolicyIgnore {
+		modifier = "IGNORE"
+	}
+	query := fmt.Sprintf(`LOAD DATA LOCAL INFILE '%s' %s INTO TABLE %s.%s CHARACTER SET %s (%s)`,
+		sql.EscapeValue(path), modifier, sql.EscapeName(schema), sql.EscapeName(table), charset, strings.Join(targets, ","))
+	if len(sets) > 0 {
+		query += " SET " + strings.Join(sets, ",")
+	}
+	return query
+}
+
+// loadRows applies the rows of a chunk of the full copy with LOAD DATA LOCAL INFILE, via a file in
+// the task tmp dir, which is removed right after. loaded is false if the rows are to be inserted:
+// on an error, or if LOAD DATA is not usable, e.g. the target disallows it. LOAD DATA can only replace
+// or ignore the conflicting rows, so the rows are inserted with the other ConflictPolicy.
+func (a *Applier) loadRows(tx *gosql.Tx, entry *DumpEntry) (loaded bool, err error) {
+	policy := a.mysqlContext.ConflictPolicy
+	if atomic.LoadInt32(&a.loadDataDisabled) != 0 || a.mysqlContext.TmpDir == "" ||
+		entry.Table == nil || entry.Table.OriginalTableColumns == nil ||
+		(policy != config.ConflictPolicyReplace && policy != config.ConflictPolicyIgnore) {
+		return false, nil
+	}
+	columns := entry.Table.OriginalTableColumns.ColumnList()
+	data, err := encodeLoadData(columns, entry.ValuesX)
+	if err != nil {
+		return false, err
+	}
+	if a.dataCipher != nil {
+		if data, err = a.dataCipher.Seal(data); err != nil {
+			return false, err
+		}
+	}
+
+	f, err := ioutil.TempFile(a.mysqlContext.TmpDir, "load_data_")
+	if err != nil {
+		return false, err
+	}
+	path := f.Name()
+	defer func() {
+		f.Close()
+		if err := os.Remove(path); err != nil {
+			a.logger.Warnf("mysql.applier: remove LOAD DATA file %v error: %v", path, err)
+		}
+		a.mysqlContext.AuxDisk.Release(path)
+	}()
+	if err := a.mysqlContext.AuxDisk.Reserve(auxdisk.KindLoadData, path, int64(len(data))); err != nil {
+		return false, err
+	}
+	if _, err := f.Write(data); err != nil {
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+
+	infile := path
+	if a.dataCipher != nil {
+		// the file is decrypted while it is sent to the target
+		infile = "Reader::" + path
+		mysqldriver.RegisterReaderHandler(path, func() io.Reader { return a.openLoadDataFile(path) })
+		defer mysqldriver.DeregisterReaderHandler(path)
+	} else {
+		mysqldriver.RegisterLocalFile(path)
+		defer mysqldriver.DeregisterLocalFile(path)
+	}
+	query := loadDataStatement(infile, entry.TableSchema, entry.TableName,
+		a.mysqlContext.ConnectionConfig.Charset, policy, columns)
+	a.logger.Debugf("mysql.applier: Exec [%s]", query)
+	start := time.Now()
+	r, err := tx.Exec(query)
+	if err != nil {
+		if sql.IsLocalInfileDisabledError(err) {
+			if atomic.CompareAndSwapInt32(&a.loadDataDisabled, 0, 1) {
+				a.logger.Warnf("mysql.applier: LOAD DATA LOCAL INFILE is disallowed by the target. inserting the rows: %v", err)
+				a.emitEvent("LOAD DATA LOCAL INFILE is disallowed by the target (local_infile). The full copy continues with INSERT: %v", err)
+			}
+			return false, nil
+		}
+		return false, err
+	}
+	if affected, err := r.RowsAffected(); err == nil {
+		atomic.AddInt64(&a.conflictCount, conflictsOf(policy, int64(len(entry.ValuesX)), affected))
+	}
+	atomic.AddInt64(&a.loadDataRows, int64(len(entry.ValuesX)))
+	atomic.AddInt64(&a.loadDataNanos, int64(time.Since(start)))
+	return true, nil
+}
+
+// openLoadDataFile returns the decrypted content of a LOAD DATA file written with EncryptDataAtRest.
+func (a *Applier) openLoadDataFile(path string) io.Reader {
+	sealed, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errReader{err}
+	}
+	data, err := a.dataCipher.Open(sealed)
+	if err != nil {
+		return errReader{fmt.Errorf("failed to decrypt LOAD DATA file %v: %v", path, err)}
+	}
+	return bytes.NewReader(data)
+}
+
+// errReader fails the LOAD DATA reading it with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// tableMeta is the structure of a target table. It is not modified once cached.
+type tableMeta struct {
+	// without the surrogate key column
+	columns *umconf.ColumnList
+	// charsets of the string columns, by name
+	charsets map[string]string
+	// kinds of the DATE, DATETIME and TIMESTAMP columns, by name
+	temporals map[string]string
+	loadedAt  time.Time
+}
+
+// sameStructure tells if the table has the same columns, charsets and temporal columns in m and
+// o, e.g. loaded again after an eviction or an expiry, so the prepared statements stay valid.
+func (m *tableMeta) sameStructure(o *tableMeta) bool {
+	return reflect.DeepEqual(m.columns, o.columns) &&
+		reflect.DeepEqual(m.charsets, o.charsets) && reflect.DeepEqual(m.temporals, o.temporals)
+}
+
+// tableMetaCache keeps the structure of the most recently used target tables, so they are not
+// read from information_schema on each first touch of a table.
+type tableMetaCache struct {
+	lock sync.Mutex
+	lru  *simplelru.LRU
+	// 0 for no expiry
+	ttl time.Duration
+	// reads the structure of a table. loadTableMeta but in tests
+	load func(db sql.QueryAble, schema, table string) (*tableMeta, error)
+
+	hits, misses int64
+	// by the size bound only, not by an expiry or a DDL
+	evictions int64
+}
+
+func newTableMetaCache(size int, ttl time.Duration) (*tableMetaCache, error) {
+	c := &tableMetaCache{ttl: ttl, load: loadTableMeta}
+	lru, err := simplelru.NewLRU(size, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = lru
+	return c, nil
+}
+
+func tableMetaKey(schema, table string) string {
+	return fmt.Sprintf("%v.%v", schema, table)
+}
+
+// get returns the structure of schema.table, reading it with db if it is not cached or expired.
+func (c *tableMetaCache) get(db sql.QueryAble, schema, table string) (*tableMeta, error) {
+	key := tableMetaKey(schema, table)
+	now := time.Now()
+	c.lock.Lock()
+	if v, ok := c.lru.Get(key); ok {
+		meta := v.(*tableMeta)
+		if c.ttl == 0 || now.Sub(meta.loadedAt) < c.ttl {
+			c.hits++
+			c.lock.Unlock()
+			return meta, nil
+		}
+		c.lru.Remove(key)
+	}
+	c.misses++
+	c.lock.Unlock()
+
+	meta, err := c.load(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	meta.loadedAt = now
+
+	c.lock.Lock()
+	if c.lru.Add(key, meta) {
+		c.evictions++
+	}
+	c.lock.Unlock()
+	return meta, nil
+}
+
+func loadTableMeta(db sql.QueryAble, schema, table string) (*tableMeta, error) {
+	columns, err := base.GetTableColumns(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	charsets, err := base.GetColumnCharsets(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	columns = stripSurrogateKeyColumn(columns)
+	return &tableMeta{
+		columns:   columns,
+		charsets:  charsets,
+		temporals: temporalColumns(columns),
+	}, nil
+}
+
+// invalidate drops the structure of schema.table, or of all the tables of schema if table is "".
+func (c *tableMetaCache) invalidate(schema, table string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if table != "" {
+		c.lru.Remove(tableMetaKey(schema, table))
+		return
+	}
+	prefix := tableMetaKey(schema, "")
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.lru.Remove(key)
+		}
+	}
+}
+
+func (c *tableMetaCache) stat() *models.MetadataCacheStat {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &models.MetadataCacheStat{
+		Size:      c.lru.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
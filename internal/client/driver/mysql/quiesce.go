@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	gosql "database/sql"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/gtid"
+)
+
+// SourceQuiescer is a connection to the source of a job for a cutover, to stop the writes on
+// it and to read its final position.
+type SourceQuiescer struct {
+	db *gosql.DB
+	// whether SetReadOnly turned a writable source read-only
+	readOnlySet bool
+}
+
+// OpenSourceQuiescer connects to the source with connConfig, whose secrets must be resolved.
+func OpenSourceQuiescer(connConfig *umconf.ConnectionConfig) (*SourceQuiescer, error) {
+	db, err := sql.CreateDB(connConfig.GetDBUri())
+	if err != nil {
+		return nil, err
+	}
+	return &SourceQuiescer{db: db}, nil
+}
+
+// SetReadOnly sets the global read_only of the source, which waits for the transactions being
+// committed. Only the sessions with SUPER may write afterwards.
+func (q *SourceQuiescer) SetReadOnly() error {
+	var readOnly bool
+	if err := q.db.QueryRow(`select @@global.read_only`).Scan(&readOnly); err != nil {
+		return err
+	}
+	if readOnly {
+		return nil
+	}
+	if _, err := q.db.Exec(`set global read_only = ON`); err != nil {
+		return err
+	}
+	q.readOnlySet = true
+	return nil
+}
+
+// RestoreWritable makes the source writable again, if SetReadOnly turned it read-only.
+func (q *SourceQuiescer) RestoreWritable() error {
+	if !q.readOnlySet {
+		return nil
+	}
+	if _, err := q.db.Exec(`set global read_only = OFF`); err != nil {
+		return err
+	}
+	q.readOnlySet = false
+	return nil
+}
+
+// ExecutedGtid returns the GTID set executed on the source.
+func (q *SourceQuiescer) ExecutedGtid() (string, error) {
+	var executed string
+	if err := q.db.QueryRow(`select @@global.gtid_executed`).Scan(&executed); err != nil {
+		return "", err
+	}
+	return executed, nil
+}
+
+func (q *SourceQuiescer) Close() error {
+	return q.db.Close()
+}
+
+// setGtidBase records the GTID set the incremental apply starts from, i.e. the Gtid of the job or
+// the one the full copy is consistent with.
+func (a *Applier) setGtidBase(s string) {
+	set, err := gtid.Parse(s)
+	if err != nil {
+		a.logger.Warnf("mysql.applier: bad gtid %v: %v", s, err)
+		return
+	}
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	a.gtidBase = set
+}
+
+// executedGtid returns the GTID set the target has, for the ExecutedGtidSet of the applier: the
+// one the incremental apply started from, and the transactions applied since. Empty before the
+// incremental apply.
+func (a *Applier) executedGtid() string {
+	a.gtidGapLock.Lock()
+	defer a.gtidGapLock.Unlock()
+	if a.gtidBase == nil {
+		return ""
+	}
+	return a.gtidBase.Union(a.gtidApplied).String()
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// initReadFromReplica validates the source is a replica with ReadFromReplica. Without
+// log_slave_updates, the binlog of the replica has none of the transactions of the primary,
+// which is warned of.
+func (e *Extractor) initReadFromReplica() error {
+	if !e.mysqlContext.ReadFromReplica {
+		return nil
+	}
+	status, err := base.GetReplicaStatus(e.db)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		return fmt.Errorf("ReadFromReplica: the source %v is not a replica", e.sourceAddr())
+	}
+	e.replicaStatus.Store(status)
+
+	var logSlaveUpdates bool
+	if err := e.db.QueryRow(`select @@global.log_slave_updates`).Scan(&logSlaveUpdates); err != nil {
+		return err
+	}
+	if !logSlaveUpdates {
+		e.logger.Warnf("mysql.extractor: log_slave_updates is disabled on the replica %v. "+
+			"the transactions replicated from the primary are not in its binlog", e.sourceAddr())
+		e.emitEvent("log_slave_updates is disabled on the replica %v with ReadFromReplica. "+
+			"The transactions replicated from the primary are not in its binlog", e.sourceAddr())
+	}
+	e.logger.Printf("mysql.extractor: reading from the replica %v. lag: %vs, relay master log: %v:%v",
+		e.sourceAddr(), status.Lag, status.RelayMasterLogFile, status.ExecMasterLogPos)
+	return nil
+}
+
+// periodicReplicaCheck reads the replica status of the source with ReadFromReplica, and reports
+// its lag to the applier on "<subject>_replica_lag", for DelayCount. It switches to another source
+// instance, as periodicSourceLagCheck, when the replica is unreachable, stops replicating or
+// exceeds SourceMaxLag.
+func (e *Extractor) periodicReplicaCheck() {
+	ticker := time.NewTicker(sourceLagCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		status, err := base.GetReplicaStatus(e.db)
+		if err == nil && status == nil {
+			err = fmt.Errorf("not a replica")
+		}
+		var current *sourcePosition
+		if err == nil {
+			e.replicaStatus.Store(status)
+			e.reportReplicaLag(status.Lag)
+			current, err = readSourcePosition(e.db)
+		}
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: check replica %v error: %v", e.sourceAddr(), err)
+			current = nil
+		} else if !current.lagExceeds(e.mysqlContext.SourceMaxLag) {
+			continue
+		} else {
+			e.logger.Warnf("mysql.extractor: lag of the replica %v is %vs (SourceMaxLag %vs)",
+				e.sourceAddr(), current.lag, e.mysqlContext.SourceMaxLag)
+		}
+		if len(e.sourceCandidates) > 1 && e.failoverSource(current) {
+			return
+		}
+	}
+}
+
+// reportReplicaLag sends the lag of the replica to the applier. The reports are not counted.
+func (e *Extractor) reportReplicaLag(lag int64) {
+	msg, err := Encode(lag)
+	if err != nil {
+		e.logger.Warnf("mysql.extractor: replica lag report error: %v", err)
+		return
+	}
+	if err := e.natsConn.Publish(fmt.Sprintf("%s_replica_lag", e.subject), msg); err != nil {
+		e.logger.Warnf("mysql.extractor: replica lag report error: %v", err)
+	}
+}
+
+// replicaCoordinates fills the replica status of the source with ReadFromReplica in the
+// coordinates of the statistics, as last read.
+func (e *Extractor) replicaCoordinates(coordinates *models.CurrentCoordinates) {
+	status, ok := e.replicaStatus.Load().(*base.ReplicaStatus)
+	if !ok {
+		return
+	}
+	coordinates.RelayMasterLogFile = status.RelayMasterLogFile
+	coordinates.ReadMasterLogPos = status.ExecMasterLogPos
+	coordinates.ReplicaLag = status.Lag
+}
+
+// handleReplicaLagReport keeps the lag of the replica read by the extractor with ReadFromReplica.
+func (a *Applier) handleReplicaLagReport(m *gonats.Msg) {
+	var lag int64
+	if err := Decode(m.Data, &lag); err != nil {
+		a.logger.Warnf("mysql.applier: replica lag report error: %v", err)
+		return
+	}
+	atomic.StoreInt64(&a.sourceReplicaLag, lag)
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// resolvedTablesOf returns the tables of dbs, with their names on the target.
+func resolvedTablesOf(cfg *config.MySQLDriverConfig, dbs []*config.DataSource) []*models.ResolvedTable {
+	var tables []*models.ResolvedTable
+	for _, db := range dbs {
+		for _, tb := range db.Tables {
+			target := tb.TableName
+			if tb.TableRename != "" {
+				target = tb.TableRename
+			}
+			tables = append(tables, &models.ResolvedTable{
+				TableSchema:  tb.TableSchema,
+				TableName:    tb.TableName,
+				TargetSchema: cfg.TargetSchema(tb.TableSchema),
+				TargetTable:  target,
+				Where:        tb.Where,
+			})
+		}
+	}
+	return tables
+}
+
+// ResolvedConfig returns the tables matched by the filters of the job, the schemas renamed on the
+// target, and the server_id of the extractor as a replica. See driver.ConfigResolver.
+func (e *Extractor) ResolvedConfig() map[string]interface{} {
+	values := make(map[string]interface{})
+	if tables, ok := e.resolvedTables.Load().([]*models.ResolvedTable); ok {
+		values["ResolvedTables"] = tables
+	}
+	if schemaMapping, ok := e.schemaMapping.Load().(map[string]string); ok && len(schemaMapping) > 0 {
+		values["SchemaMapping"] = schemaMapping
+	}
+	if serverId := atomic.LoadUint32(&e.replicaServerId); serverId != 0 {
+		values["ReplicaServerId"] = serverId
+	}
+	return values
+}
+
+// ResolvedConfig returns the number of the workers applying the binlog, as resized by SetWorkers
+// or limited on MySQL 5.6. See driver.ConfigResolver.
+func (a *Applier) ResolvedConfig() map[string]interface{} {
+	values := make(map[string]interface{})
+	if n := atomic.LoadInt32(&a.activeWorkers); n > 0 {
+		values["ParallelWorkers"] = n
+	}
+	return values
+}
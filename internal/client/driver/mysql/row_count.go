@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// rowCountEntry is the source side of a row count check of a table.
+type rowCountEntry struct {
+	TableSchema  string
+	TableName    string
+	TargetSchema string
+	TargetTable  string
+	SourceCount  int64
+	// the table is under heavy writes and not counted
+	Skipped bool
+}
+
+// rowCountCheck is sent from the extractor to the applier on "<subject>_row_count".
+type rowCountCheck struct {
+	Tables    []*rowCountEntry
+	Tolerance int64
+}
+
+func countRows(db sql.QueryAble, schema, table string) (count int64, err error) {
+	query := fmt.Sprintf("select count(*) from %s.%s", sql.EscapeName(schema), sql.EscapeName(table))
+	err = db.QueryRow(query).Scan(&count)
+	return count, err
+}
+
+// addRowChurn records the rows changed by a transaction, for RowCountCheckMaxChurn.
+func (e *Extractor) addRowChurn(binlogEntry *binlog.BinlogEntry) {
+	if e.mysqlContext.RowCountCheckInterval <= 0 {
+		return
+	}
+	e.rowChurnLock.Lock()
+	defer e.rowChurnLock.Unlock()
+	for i := range binlogEntry.Events {
+		event := &binlogEntry.Events[i]
+		if event.DML == binlog.NotDML {
+			continue
+		}
+		e.rowChurn[fmt.Sprintf("%s.%s", event.DatabaseName, event.TableName)]++
+	}
+}
+
+// takeRowChurn returns the rows changed per "schema.table" since the last call.
+func (e *Extractor) takeRowChurn() map[string]int64 {
+	e.rowChurnLock.Lock()
+	defer e.rowChurnLock.Unlock()
+	churn := e.rowChurn
+	e.rowChurn = make(map[string]int64)
+	return churn
+}
+
+// periodicRowCountCheck counts rows of the replicated source tables every RowCountCheckInterval,
+// and sends the counts to the applier to be compared with the target tables.
+func (e *Extractor) periodicRowCountCheck() {
+	interval := time.Duration(e.mysqlContext.RowCountCheckInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	e.takeRowChurn()
+	for {
+		select {
+		case <-e.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		check, err := e.buildRowCountCheck(e.takeRowChurn())
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: row count check error: %v", err)
+			continue
+		}
+		msg, err := Encode(check)
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: row count check error: %v", err)
+			continue
+		}
+		if err := e.publish(fmt.Sprintf("%s_row_count", e.subject), "", msg); err != nil {
+			e.logger.Warnf("mysql.extractor: row count check error: %v", err)
+		}
+	}
+}
+
+func (e *Extractor) buildRowCountCheck(churn map[string]int64) (*rowCountCheck, error) {
+	check := &rowCountCheck{
+		Tolerance: e.mysqlContext.RowCountTolerance,
+	}
+	for _, db := range e.replicateDoDb {
+		for _, tb := range db.Tables {
+			entry := &rowCountEntry{
+				TableSchema:  db.TableSchema,
+				TableName:    tb.TableName,
+				TargetSchema: db.TableSchema,
+				TargetTable:  tb.TableName,
+			}
+			if tb.TableSchemaRename != "" {
+				entry.TargetSchema = tb.TableSchemaRename
+			} else if db.TableSchemaRename != "" {
+				entry.TargetSchema = db.TableSchemaRename
+			}
+			if tb.TableRename != "" {
+				entry.TargetTable = tb.TableRename
+			}
+
+			if churn[fmt.Sprintf("%s.%s", db.TableSchema, tb.TableName)] > e.mysqlContext.RowCountCheckMaxChurn {
+				entry.Skipped = true
+			} else {
+				count, err := countRows(e.db, db.TableSchema, tb.TableName)
+				if err != nil {
+					return nil, err
+				}
+				entry.SourceCount = count
+			}
+			check.Tables = append(check.Tables, entry)
+		}
+	}
+	return check, nil
+}
+
+// handleRowCountCheck counts rows of the target tables and compares them with the source counts.
+func (a *Applier) handleRowCountCheck(m *gonats.Msg) {
+	check := &rowCountCheck{}
+	if err := Decode(m.Data, check); err != nil {
+		a.logger.Warnf("mysql.applier: row count check error: %v", err)
+		return
+	}
+	// The check is not worth blocking the extractor.
+	if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+		a.onError(TaskStateDead, err)
+		return
+	}
+
+	var results []*models.TableRowCount
+	for _, entry := range check.Tables {
+		var targetCount int64
+		if !entry.Skipped {
+			count, err := countRows(a.db, entry.TargetSchema, entry.TargetTable)
+			if err != nil {
+				a.logger.Warnf("mysql.applier: row count check of %v.%v error: %v",
+					entry.TargetSchema, entry.TargetTable, err)
+				continue
+			}
+			targetCount = count
+		}
+		result := compareRowCount(entry, targetCount, check.Tolerance)
+		if result.Diverged {
+			a.logger.Warnf("mysql.applier: row count of %v.%v diverged. source: %v, target: %v",
+				entry.TableSchema, entry.TableName, result.SourceCount, result.TargetCount)
+			a.emitEvent("Row count of table %v.%v diverged. source: %v, target: %v",
+				entry.TableSchema, entry.TableName, result.SourceCount, result.TargetCount)
+		}
+		results = append(results, result)
+	}
+
+	a.rowCountsLock.Lock()
+	a.rowCounts = results
+	a.rowCountsLock.Unlock()
+}
+
+func compareRowCount(entry *rowCountEntry, targetCount int64, tolerance int64) *models.TableRowCount {
+	result := &models.TableRowCount{
+		TableSchema: entry.TableSchema,
+		TableName:   entry.TableName,
+		Skipped:     entry.Skipped,
+		Timestamp:   time.Now().UTC().UnixNano(),
+	}
+	if entry.Skipped {
+		return result
+	}
+	result.SourceCount = entry.SourceCount
+	result.TargetCount = targetCount
+	result.Delta = targetCount - entry.SourceCount
+	result.Diverged = result.Delta > tolerance || -result.Delta > tolerance
+	return result
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+// onServerIdCollision handles the binlog dump stopped by the source because another replica (e.g.
+// another extractor, or a real replica) connected with the same server_id. The two would
+// disconnect each other repeatedly. The task is restarted once with a re-randomized server_id,
+// and fails on another collision.
+func (e *Extractor) onServerIdCollision(err error) {
+	old := atomic.LoadUint32(&e.replicaServerId)
+	if e.mysqlContext.ServerIdRetry != 0 {
+		e.emitEvent("server_id %v of the extractor collided again with another replica of the source %v. "+
+			"Set ReplicaServerId to a server_id unused among the replicas of the source", old, e.sourceAddr())
+		e.onError(TaskStateDead, fmt.Errorf("server_id %v collided again with another replica of the source: %v", old, err))
+		return
+	}
+	e.mysqlContext.ServerIdRetry = config.RandomServerId(old)
+	e.logger.Warnf("mysql.extractor: another replica of the source connected with server_id %v. retrying with server_id %v: %v",
+		old, e.mysqlContext.ServerIdRetry, err)
+	e.emitEvent("Another replica of the source %v connected with server_id %v of the extractor, which was disconnected. "+
+		"Retrying with server_id %v. Set ReplicaServerId to a server_id unused among the replicas of the source",
+		e.sourceAddr(), old, e.mysqlContext.ServerIdRetry)
+	e.onError(TaskStateRestart, fmt.Errorf("server_id %v collided, retrying with %v", old, e.mysqlContext.ServerIdRetry))
+}
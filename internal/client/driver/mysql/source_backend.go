@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+)
+
+const (
+	// connections tried to reach the server the binlog streamer is on, when the source address
+	// leads to several servers
+	backendCheckAttempts = 5
+)
+
+// sourceBackend is the server behind the source address. A load balancer or a VIP might lead
+// each connection to another server.
+type sourceBackend struct {
+	serverUuid string
+	serverId   uint32
+	executed   string
+	purged     string
+}
+
+// readSourceBackend reads the identity and the GTID sets of a server, on one connection.
+func readSourceBackend(db sql.QueryAble) (*sourceBackend, error) {
+	b := &sourceBackend{}
+	if err := db.QueryRow(`select @@global.server_uuid, @@global.server_id, @@global.gtid_executed, @@global.gtid_purged`).
+		Scan(&b.serverUuid, &b.serverId, &b.executed, &b.purged); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readStreamerBackend reads the server the binlog streamer is on, known by its server_id. A
+// new connection to the source address might lead to another server, and is retried.
+func (e *Extractor) readStreamerBackend(serverId uint32) (*sourceBackend, error) {
+	var backend *sourceBackend
+	for i := 0; i < backendCheckAttempts; i++ {
+		var err error
+		backend, err = func() (*sourceBackend, error) {
+			db, err := sql.CreateDB(e.mysqlContext.ConnectionConfig.GetDBUri(), e.mysqlContext.InitSQL...)
+			if err != nil {
+				return nil, err
+			}
+			defer db.Close()
+			return readSourceBackend(db)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		if serverId == 0 || backend.serverId == serverId {
+			return backend, nil
+		}
+		e.logger.Debugf("mysql.extractor: a connection to the source %v led to server_id %v, not %v of the binlog streamer",
+			e.sourceAddr(), backend.serverId, serverId)
+	}
+	return nil, fmt.Errorf("the binlog streamer of the source %v is on server_id %v, but %v connections led to "+
+		"other servers, e.g. server_id %v. the source address might be a load balancer",
+		e.sourceAddr(), serverId, backendCheckAttempts, backend.serverId)
+}
+
+// recheckServerUuid is called when the binlog streamer connects or reconnects, and when it opens
+// the next binlog file, with the server_id of the server it is on and the GTID set it resumes
+// from. Behind a load balancer, a reconnect might land on another server, whose binlog positions
+// are not those read. The streaming continues there only if it resumes by GTID, and the server
+// has executed all the transactions read and purged none of the others.
+func (e *Extractor) recheckServerUuid(serverId uint32, read string) error {
+	prevUuid, _ := e.serverUuid.Load().(string)
+	prevId := atomic.LoadUint32(&e.serverId)
+
+	backend, err := e.readStreamerBackend(serverId)
+	if err != nil {
+		return err
+	}
+	if err := e.checkServerUuid(backend); err != nil {
+		return err
+	}
+	switched, err := checkBackendSwitch(prevUuid, backend, read)
+	if err != nil {
+		return fmt.Errorf("the source %v led to another server (server_uuid %v, server_id %v, was %v, %v): %v",
+			e.sourceAddr(), backend.serverUuid, backend.serverId, prevUuid, prevId, err)
+	} else if !switched {
+		return nil
+	}
+	atomic.AddInt64(&e.backendSwitches, 1)
+	e.logger.Warnf("mysql.extractor: the source %v led to another server (server_uuid %v, server_id %v, was %v, %v). "+
+		"it has executed the read transactions %v", e.sourceAddr(), backend.serverUuid, backend.serverId, prevUuid, prevId, read)
+	e.emitEvent("Source %v led to another server (server_uuid %v, server_id %v, was %v, %v). "+
+		"Reading from gtid %v", e.sourceAddr(), backend.serverUuid, backend.serverId, prevUuid, prevId, read)
+	return nil
+}
+
+// checkBackendSwitch tells whether the binlog streamer is on another server than prevUuid, and if
+// so, whether the read can continue there from the GTID set read.
+func checkBackendSwitch(prevUuid string, backend *sourceBackend, read string) (switched bool, err error) {
+	if prevUuid == "" || strings.EqualFold(prevUuid, backend.serverUuid) {
+		return false, nil
+	}
+	if read == "" {
+		return true, fmt.Errorf("the binlog is read by position, which is not that of the other server")
+	}
+	if err := checkGtidContinuity(read, backend.executed, backend.purged); err != nil {
+		return true, fmt.Errorf("it cannot continue the binlog read: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/gtid"
+)
+
+const (
+	// interval between the checks of the replica lag of the source
+	sourceLagCheckInterval = 10 * time.Second
+)
+
+// sourcePosition is the replica lag and the GTID sets of a source instance.
+type sourcePosition struct {
+	lag      int64 // -1 if the replication is stopped
+	executed string
+	purged   string
+}
+
+func readSourcePosition(db sql.QueryAble) (*sourcePosition, error) {
+	lag, err := base.GetReplicaLag(db)
+	if err != nil {
+		return nil, err
+	}
+	p := &sourcePosition{lag: lag}
+	if err := db.QueryRow(`select @@global.gtid_executed, @@global.gtid_purged`).Scan(&p.executed, &p.purged); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// lagExceeds tells whether the lag is out of maxLag (seconds). A stopped replication always is,
+// and maxLag 0 (with ReadFromReplica) is no limit otherwise.
+func (p *sourcePosition) lagExceeds(maxLag int) bool {
+	return p.lag < 0 || (maxLag > 0 && p.lag > int64(maxLag))
+}
+
+// String describes the position for the logs and the events of a switch.
+func (p *sourcePosition) String() string {
+	if p == nil {
+		return "unreachable"
+	}
+	return fmt.Sprintf("lag: %vs, gtid_executed: %v", p.lag, p.executed)
+}
+
+// checkGtidContinuity checks that the binlog of a source with the GTID sets executed and purged
+// continues right after the sent GTID set: it has all the sent transactions, and none of the
+// others is purged.
+func checkGtidContinuity(sent, executed, purged string) error {
+	sentSet, err := gtid.Parse(sent)
+	if err != nil {
+		return err
+	}
+	executedSet, err := gtid.Parse(executed)
+	if err != nil {
+		return err
+	}
+	purgedSet, err := gtid.Parse(purged)
+	if err != nil {
+		return err
+	}
+	if !executedSet.Contains(sentSet) {
+		return fmt.Errorf("gtid_executed %v does not contain the sent transactions %v", executed, sent)
+	}
+	if !sentSet.Contains(purgedSet) {
+		return fmt.Errorf("gtid_purged %v contains transactions not sent yet. sent: %v", purged, sent)
+	}
+	return nil
+}
+
+// markSent adds a transaction acknowledged by the applier to the sent GTID set.
+func (e *Extractor) markSent(sid string, gno int64) {
+	e.sentGtidLock.Lock()
+	defer e.sentGtidLock.Unlock()
+	if e.sentGtidSet == nil {
+		return
+	}
+	u, err := uuid.FromString(sid)
+	if err != nil {
+		e.logger.Warnf("mysql.extractor: bad gtid %v:%v: %v", sid, gno, err)
+		return
+	}
+	e.sentGtidSet.AddGtid(u, gno)
+}
+
+func (e *Extractor) sentGtid() string {
+	e.sentGtidLock.Lock()
+	defer e.sentGtidLock.Unlock()
+	return e.sentGtidSet.String()
+}
+
+// periodicSourceLagCheck switches to another source instance, when the replica lag of the
+// current one exceeds SourceMaxLag.
+func (e *Extractor) periodicSourceLagCheck() {
+	ticker := time.NewTicker(sourceLagCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := readSourcePosition(e.db)
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: check source lag error: %v", err)
+			continue
+		}
+		if !current.lagExceeds(e.mysqlContext.SourceMaxLag) {
+			continue
+		}
+		e.logger.Warnf("mysql.extractor: lag of source %v is %vs, more than SourceMaxLag %vs",
+			e.sourceAddr(), current.lag, e.mysqlContext.SourceMaxLag)
+		if e.failoverSource(current) {
+			return
+		}
+	}
+}
+
+// failoverSource restarts the extractor on the first of the other source instances, which is within
+// SourceMaxLag, and from which the binlog can be read right after the sent transactions.
+// current is nil if the current source is unreachable. It returns false if there is none.
+func (e *Extractor) failoverSource(current *sourcePosition) bool {
+	oldAddr := e.sourceAddr()
+	sent := e.sentGtid()
+	for _, candidate := range e.sourceCandidates {
+		if candidate == oldAddr {
+			continue
+		}
+		connConfig, err := connectionConfigWithAddr(e.mysqlContext.ConnectionConfig, candidate)
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: bad source host %v: %v", candidate, err)
+			continue
+		}
+		position, err := func() (*sourcePosition, error) {
+			db, err := sql.CreateDB(connConfig.GetDBUri(), e.mysqlContext.InitSQL...)
+			if err != nil {
+				return nil, err
+			}
+			defer db.Close()
+			return readSourcePosition(db)
+		}()
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: check source %v error: %v", candidate, err)
+			continue
+		}
+		if position.lagExceeds(e.mysqlContext.SourceMaxLag) {
+			e.logger.Debugf("mysql.extractor: lag of source %v is %vs", candidate, position.lag)
+			continue
+		}
+		if err := checkGtidContinuity(sent, position.executed, position.purged); err != nil {
+			e.logger.Warnf("mysql.extractor: cannot switch to source %v: %v", candidate, err)
+			continue
+		}
+
+		e.logger.Printf("mysql.extractor: switching source from %v (%v) to %v (%v), from gtid %v",
+			oldAddr, current, candidate, position, sent)
+		e.emitEvent("Source failed over from %v (%v) to %v (%v). Reading from gtid %v",
+			oldAddr, current, candidate, position, sent)
+		e.mysqlContext.Gtid = sent
+		e.mysqlContext.SourceAddr = candidate
+		e.onError(TaskStateRestart, fmt.Errorf("switching source to %v", candidate))
+		return true
+	}
+	e.logger.Warnf("mysql.extractor: no other source is within SourceMaxLag with the sent transactions. staying on %v", oldAddr)
+	return false
+}
+
+func (e *Extractor) sourceAddr() string {
+	return fmt.Sprintf("%s:%d", e.mysqlContext.ConnectionConfig.Host, e.mysqlContext.ConnectionConfig.Port)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// the delays between the connections to a source refused with too many connections
+	saturatedRetryMinDelay = time.Second
+	saturatedRetryMaxDelay = time.Minute
+)
+
+// saturatedRetryDelay returns the delay after the previous one, doubled up to saturatedRetryMaxDelay.
+func saturatedRetryDelay(prev time.Duration) time.Duration {
+	if prev <= 0 {
+		return saturatedRetryMinDelay
+	}
+	if prev *= 2; prev > saturatedRetryMaxDelay {
+		return saturatedRetryMaxDelay
+	}
+	return prev
+}
+
+// closeInspectorDB closes the connections of a failed initiateInspector.
+func (e *Extractor) closeInspectorDB() {
+	if e.inspector != nil {
+		sql.CloseDB(e.inspector.db)
+		e.inspector.db = nil
+	}
+}
+
+// closeSourceDBs closes the connections of a failed initDBConnections.
+func (e *Extractor) closeSourceDBs() {
+	sql.CloseDB(e.db)
+	e.db = nil
+	sql.CloseDB(e.singletonDB)
+	e.singletonDB = nil
+}
+
+// connectSource runs connect, which connects to the source in the startup phase, until it does not
+// fail with too many connections (1040). The source is at its max_connections, e.g. on a mass
+// restart of the tasks: the connect is retried with an increasing delay, in the startup phase
+// source_connection_saturated, bounded by StartDeadline, after closing the connections opened with
+// closeDBs. The other errors, e.g. of the grants or of the address, are returned.
+func (e *Extractor) connectSource(phase string, connect func() error, closeDBs func()) error {
+	var delay time.Duration
+	for {
+		err := connect()
+		if err == nil || !sql.IsTooManyConnectionsError(err) {
+			atomic.StoreInt32(&e.sourceSaturated, 0)
+			return err
+		}
+		closeDBs()
+
+		delay = saturatedRetryDelay(delay)
+		if atomic.SwapInt32(&e.sourceSaturated, 1) == 0 {
+			e.emitEvent("The source %v:%v refuses the connections: too many connections. Consider raising its max_connections",
+				e.mysqlContext.ConnectionConfig.Host, e.mysqlContext.ConnectionConfig.Port)
+		}
+		e.logger.Warnf("mysql.extractor: the source refuses the connections in %v, retrying in %v: %v", phase, delay, err)
+		e.startup.enter(startupPhaseSourceConnectionSaturated)
+		select {
+		case <-time.After(delay):
+		case <-e.shutdownCh:
+			return err
+		}
+		e.startup.enter(phase)
+	}
+}
+
+// sourceStatus returns TaskStatistics.Status of the extractor.
+func (e *Extractor) sourceStatus() string {
+	if atomic.LoadInt32(&e.sourceSaturated) == 1 {
+		return models.TaskStatusSourceConnectionSaturated
+	}
+	return ""
+}
@@ -22,6 +22,7 @@ const (
 	LessThanOrEqualsComparisonSign                        = "<="
 	EqualsComparisonSign                                  = "="
 	IsEqualsComparisonSign                                = "is"
+	NullSafeEqualsComparisonSign                          = "<=>"
 	GreaterThanOrEqualsComparisonSign                     = ">="
 	GreaterThanComparisonSign                             = ">"
 	NotEqualsComparisonSign                               = "!="
@@ -124,20 +125,41 @@ func BuildSetPreparedClause(columns *umconf.ColumnList) (result string, err erro
 	return strings.Join(setTokens, ", "), nil
 }
 
-func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.ColumnList, args []*interface{}) (result string, columnArgs []interface{}, err error) {
-	if len(args) < tableColumns.Len() {
-		return result, columnArgs, fmt.Errorf("args count differs from table column count in BuildDMLDeleteQuery %v, %v",
-			len(args), tableColumns.Len())
+// buildWhereComparisons builds the comparisons to match a row by whereColumns.
+// If there is a primary key in whereColumns, only the key is used. Otherwise all whereColumns
+// are compared with NULL-safe equality (<=>) and placeholders, so that the statement is the same
+// for any row and can be prepared once.
+func buildWhereComparisons(whereColumns, tableColumns *umconf.ColumnList, args []*interface{}) (comparisons []string, columnArgs []interface{}, err error) {
+	if !hasPkColumn(whereColumns) {
+		for _, column := range whereColumns.ColumnList() {
+			tableOrdinal := tableColumns.Ordinals[column.Name]
+			value := "?"
+			if strings.HasPrefix(column.ColumnType, "binary") {
+				value = fmt.Sprintf("cast(? as %s)", column.ColumnType)
+			}
+			comparison, err := BuildValueComparison(column.Name, value, NullSafeEqualsComparisonSign)
+			if err != nil {
+				return comparisons, columnArgs, err
+			}
+			comparisons = append(comparisons, comparison)
+			if *args[tableOrdinal] == nil {
+				columnArgs = append(columnArgs, nil)
+			} else {
+				columnArgs = append(columnArgs, column.ConvertArg(*args[tableOrdinal]))
+			}
+		}
+		return comparisons, columnArgs, nil
 	}
-	comparisons := []string{}
+
+	comparisons = []string{}
 	uniqueKeyComparisons := []string{}
 	uniqueKeyArgs := make([]interface{}, 0)
-	for _, column := range tableColumns.ColumnList() {
+	for _, column := range whereColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.Name]
 		if *args[tableOrdinal] == nil {
 			comparison, err := BuildValueComparison(column.Name, "NULL", IsEqualsComparisonSign)
 			if err != nil {
-				return result, columnArgs, err
+				return comparisons, columnArgs, err
 			}
 			comparisons = append(comparisons, comparison)
 		} else {
@@ -145,7 +167,7 @@ func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.Co
 				arg := column.ConvertArg(*args[tableOrdinal])
 				comparison, err := BuildValueComparison(column.Name, fmt.Sprintf("cast('%v' as %s)", arg, column.ColumnType), EqualsComparisonSign)
 				if err != nil {
-					return result, columnArgs, err
+					return comparisons, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
 					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
@@ -156,7 +178,7 @@ func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.Co
 				arg := column.ConvertArg(*args[tableOrdinal])
 				comparison, err := BuildValueComparison(column.Name, "?", EqualsComparisonSign)
 				if err != nil {
-					return result, columnArgs, err
+					return comparisons, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
 					uniqueKeyArgs = append(uniqueKeyArgs, arg)
@@ -174,19 +196,49 @@ func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.Co
 	if len(uniqueKeyArgs) > 0 {
 		columnArgs = uniqueKeyArgs
 	}
-	databaseName = EscapeName(databaseName)
-	tableName = EscapeName(tableName)
+	return comparisons, columnArgs, nil
+}
+
+func hasPkColumn(columns *umconf.ColumnList) bool {
+	for _, column := range columns.ColumnList() {
+		if column.IsPk() {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildDMLDeleteQuery builds the delete by whereColumns, a subset of tableColumns.
+// Args are indexed by the ordinals of tableColumns.
+func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns, whereColumns *umconf.ColumnList, args []*interface{}) (result string, columnArgs []interface{}, err error) {
+	if len(args) < tableColumns.Len() {
+		return result, columnArgs, fmt.Errorf("args count differs from table column count in BuildDMLDeleteQuery %v, %v",
+			len(args), tableColumns.Len())
+	}
+	if !whereColumns.IsSubsetOf(tableColumns) {
+		return result, columnArgs, fmt.Errorf("where columns is not a subset of table columns in BuildDMLDeleteQuery")
+	}
+	comparisons, columnArgs, err := buildWhereComparisons(whereColumns, tableColumns, args)
 	if err != nil {
 		return result, columnArgs, err
 	}
+	databaseName = EscapeName(databaseName)
+	tableName = EscapeName(tableName)
+	limit := ""
+	if !hasPkColumn(whereColumns) {
+		// Rows matched by all columns might be duplicated. Only one of them is deleted on the source.
+		limit = "limit 1"
+	}
 	result = fmt.Sprintf(`
 			delete
 				from
 					%s.%s
 				where
 					%s
+				%s
 		`, databaseName, tableName,
 		fmt.Sprintf("(%s)", strings.Join(comparisons, " and ")),
+		limit,
 	)
 	return result, columnArgs, nil
 }
@@ -206,7 +258,7 @@ func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedCol
 	databaseName = EscapeName(databaseName)
 	tableName = EscapeName(tableName)
 
-	for _, column := range tableColumns.ColumnList() {
+	for _, column := range sharedColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.Name]
 		if *args[tableOrdinal] == nil {
 			sharedArgs = append(sharedArgs, *args[tableOrdinal])
@@ -216,11 +268,11 @@ func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedCol
 		}
 	}
 
-	mappedSharedColumnNames := duplicateNames(tableColumns.Names())
+	mappedSharedColumnNames := duplicateNames(mappedSharedColumns.Names())
 	for i := range mappedSharedColumnNames {
 		mappedSharedColumnNames[i] = EscapeName(mappedSharedColumnNames[i])
 	}
-	preparedValues := buildColumnsPreparedValues(tableColumns)
+	preparedValues := buildColumnsPreparedValues(mappedSharedColumns)
 
 	result = fmt.Sprintf(`
 			replace into
@@ -235,7 +287,10 @@ func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedCol
 	return result, sharedArgs, nil
 }
 
-func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns, uniqueKeyColumns *umconf.ColumnList, valueArgs, whereArgs []*interface{}) (result string, sharedArgs, columnArgs []interface{}, err error) {
+// BuildDMLUpdateQuery sets sharedColumns and matches rows by whereColumns. Both are subsets of tableColumns.
+// For a FULL row image all of them are the table columns. For a MINIMAL image, they are the columns
+// present in the after and before images respectively.
+func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns, whereColumns *umconf.ColumnList, valueArgs, whereArgs []*interface{}) (result string, sharedArgs, columnArgs []interface{}, err error) {
 	if len(valueArgs) < tableColumns.Len() {
 		return result, sharedArgs, columnArgs, fmt.Errorf("value args count differs from table column count in BuildDMLUpdateQuery %v, %v",
 			len(valueArgs), tableColumns.Len())
@@ -250,10 +305,13 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 	if sharedColumns.Len() == 0 {
 		return result, sharedArgs, columnArgs, fmt.Errorf("No shared columns found in BuildDMLUpdateQuery")
 	}
+	if !whereColumns.IsSubsetOf(tableColumns) {
+		return result, sharedArgs, columnArgs, fmt.Errorf("where columns is not a subset of table columns in BuildDMLUpdateQuery")
+	}
 	databaseName = EscapeName(databaseName)
 	tableName = EscapeName(tableName)
 
-	for _, column := range tableColumns.ColumnList() {
+	for _, column := range sharedColumns.ColumnList() {
 		tableOrdinal := tableColumns.Ordinals[column.Name]
 		if *valueArgs[tableOrdinal] == nil || *valueArgs[tableOrdinal] == "NULL" ||
 			fmt.Sprintf("%v", *valueArgs[tableOrdinal]) == "" {
@@ -264,50 +322,9 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 		}
 	}
 
-	comparisons := []string{}
-	uniqueKeyComparisons := []string{}
-	uniqueKeyArgs := make([]interface{}, 0)
-	for _, column := range tableColumns.ColumnList() {
-		tableOrdinal := tableColumns.Ordinals[column.Name]
-		if *whereArgs[tableOrdinal] == nil {
-			comparison, err := BuildValueComparison(column.Name, "NULL", IsEqualsComparisonSign)
-			if err != nil {
-				return result, sharedArgs, columnArgs, err
-			}
-			comparisons = append(comparisons, comparison)
-		} else {
-			if strings.HasPrefix(column.ColumnType, "binary") {
-				arg := column.ConvertArg(*whereArgs[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, fmt.Sprintf("cast('%v' as %s)", arg, column.ColumnType), EqualsComparisonSign)
-				if err != nil {
-					return result, sharedArgs, columnArgs, err
-				}
-				if strings.ToUpper(column.Key) == "PRI" {
-					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
-				} else {
-					comparisons = append(comparisons, comparison)
-				}
-			} else {
-				arg := column.ConvertArg(*whereArgs[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, "?", EqualsComparisonSign)
-				if err != nil {
-					return result, sharedArgs, columnArgs, err
-				}
-				if strings.ToUpper(column.Key) == "PRI" {
-					uniqueKeyArgs = append(uniqueKeyArgs, arg)
-					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
-				} else {
-					columnArgs = append(columnArgs, arg)
-					comparisons = append(comparisons, comparison)
-				}
-			}
-		}
-	}
-	if len(uniqueKeyComparisons) > 0 {
-		comparisons = uniqueKeyComparisons
-	}
-	if len(uniqueKeyArgs) > 0 {
-		columnArgs = uniqueKeyArgs
+	comparisons, columnArgs, err := buildWhereComparisons(whereColumns, tableColumns, whereArgs)
+	if err != nil {
+		return result, sharedArgs, columnArgs, err
 	}
 	setClause, err := BuildSetPreparedClause(mappedSharedColumns)
 
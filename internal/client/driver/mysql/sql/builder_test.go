@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"strings"
 
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	test "github.com/outbrain/golib/tests"
 )
 
@@ -37,258 +38,78 @@ func TestEscapeName(t *testing.T) {
 
 func TestBuildSetPreparedClause(t *testing.T) {
 	{
-		columns := NewColumnList([]string{"c1"})
+		columns := umconf.NewColumnList(umconf.NewColumns([]string{"c1"}))
 		clause, err := BuildSetPreparedClause(columns)
 		test.S(t).ExpectNil(err)
 		test.S(t).ExpectEquals(clause, "`c1`=?")
 	}
 	{
-		columns := NewColumnList([]string{"c1", "c2"})
+		columns := umconf.NewColumnList(umconf.NewColumns([]string{"c1", "c2"}))
 		clause, err := BuildSetPreparedClause(columns)
 		test.S(t).ExpectNil(err)
 		test.S(t).ExpectEquals(clause, "`c1`=?, `c2`=?")
 	}
 	{
-		columns := NewColumnList([]string{})
+		columns := umconf.NewColumnList(umconf.NewColumns([]string{}))
 		_, err := BuildSetPreparedClause(columns)
 		test.S(t).ExpectNotNil(err)
 	}
 }
 
-func TestBuildRangeInsertQuery(t *testing.T) {
-	databaseName := "mydb"
-	originalTableName := "tbl"
-	ghostTableName := "ghost"
-	sharedColumns := []string{"id", "name", "position"}
-	{
-		uniqueKey := "PRIMARY"
-		uniqueKeyColumns := NewColumnList([]string{"id"})
-		rangeStartValues := []string{"@v1s"}
-		rangeEndValues := []string{"@v1e"}
-		rangeStartArgs := []interface{}{3}
-		rangeEndArgs := []interface{}{103}
-
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
-		test.S(t).ExpectNil(err)
-		expected := `
-				insert /* udup mydb.tbl */ ignore into mydb.ghost (id, name, position)
-				(select id, name, position from mydb.tbl force index (PRIMARY)
-					where (((id > @v1s) or ((id = @v1s))) and ((id < @v1e) or ((id = @v1e))))
-				)
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 103, 103}))
-	}
-	{
-		uniqueKey := "name_position_uidx"
-		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-		rangeStartValues := []string{"@v1s", "@v2s"}
-		rangeEndValues := []string{"@v1e", "@v2e"}
-		rangeStartArgs := []interface{}{3, 17}
-		rangeEndArgs := []interface{}{103, 117}
-
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
-		test.S(t).ExpectNil(err)
-		expected := `
-				insert /* udup mydb.tbl */ ignore into mydb.ghost (id, name, position)
-				(select id, name, position from mydb.tbl force index (name_position_uidx)
-				  where (((name > @v1s) or (((name = @v1s)) AND (position > @v2s)) or ((name = @v1s) and (position = @v2s))) and ((name < @v1e) or (((name = @v1e)) AND (position < @v2e)) or ((name = @v1e) and (position = @v2e))))
-				)
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 17, 3, 17, 103, 103, 117, 103, 117}))
-	}
+// newTestColumns returns the columns id (the primary key), name, rank, position and age.
+func newTestColumns() *umconf.ColumnList {
+	columns := umconf.NewColumns([]string{"id", "name", "rank", "position", "age"})
+	columns[0].Key = "PRI"
+	return umconf.NewColumnList(columns)
 }
 
-func TestBuildRangeInsertQueryRenameMap(t *testing.T) {
-	databaseName := "mydb"
-	originalTableName := "tbl"
-	ghostTableName := "ghost"
-	sharedColumns := []string{"id", "name", "position"}
-	mappedSharedColumns := []string{"id", "name", "location"}
-	{
-		uniqueKey := "PRIMARY"
-		uniqueKeyColumns := NewColumnList([]string{"id"})
-		rangeStartValues := []string{"@v1s"}
-		rangeEndValues := []string{"@v1e"}
-		rangeStartArgs := []interface{}{3}
-		rangeEndArgs := []interface{}{103}
-
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
-		test.S(t).ExpectNil(err)
-		expected := `
-				insert /* udup mydb.tbl */ ignore into mydb.ghost (id, name, location)
-				(select id, name, position from mydb.tbl force index (PRIMARY)
-					where (((id > @v1s) or ((id = @v1s))) and ((id < @v1e) or ((id = @v1e))))
-				)
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 103, 103}))
-	}
-	{
-		uniqueKey := "name_position_uidx"
-		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-		rangeStartValues := []string{"@v1s", "@v2s"}
-		rangeEndValues := []string{"@v1e", "@v2e"}
-		rangeStartArgs := []interface{}{3, 17}
-		rangeEndArgs := []interface{}{103, 117}
-
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
-		test.S(t).ExpectNil(err)
-		expected := `
-				insert /* udup mydb.tbl */ ignore into mydb.ghost (id, name, location)
-				(select id, name, position from mydb.tbl force index (name_position_uidx)
-				  where (((name > @v1s) or (((name = @v1s)) AND (position > @v2s)) or ((name = @v1s) and (position = @v2s))) and ((name < @v1e) or (((name = @v1e)) AND (position < @v2e)) or ((name = @v1e) and (position = @v2e))))
-				)
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 17, 3, 17, 103, 103, 117, 103, 117}))
-	}
-}
-
-func TestBuildRangeInsertPreparedQuery(t *testing.T) {
-	databaseName := "mydb"
-	originalTableName := "tbl"
-	ghostTableName := "ghost"
-	sharedColumns := []string{"id", "name", "position"}
-	{
-		uniqueKey := "name_position_uidx"
-		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-		rangeStartArgs := []interface{}{3, 17}
-		rangeEndArgs := []interface{}{103, 117}
-
-		query, explodedArgs, err := BuildRangeInsertPreparedQuery(databaseName, originalTableName, ghostTableName, sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, true, true)
-		test.S(t).ExpectNil(err)
-		expected := `
-				insert /* udup mydb.tbl */ ignore into mydb.ghost (id, name, position)
-				(select id, name, position from mydb.tbl force index (name_position_uidx)
-				  where (((name > ?) or (((name = ?)) AND (position > ?)) or ((name = ?) and (position = ?))) and ((name < ?) or (((name = ?)) AND (position < ?)) or ((name = ?) and (position = ?))))
-				lock in share mode )
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 17, 3, 17, 103, 103, 117, 103, 117}))
-	}
-}
-
-func TestBuildUniqueKeyRangeEndPreparedQuery(t *testing.T) {
-	databaseName := "mydb"
-	originalTableName := "tbl"
-	var chunkSize int64 = 500
-	{
-		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-		rangeStartArgs := []interface{}{3, 17}
-		rangeEndArgs := []interface{}{103, 117}
-
-		query, explodedArgs, err := BuildUniqueKeyRangeEndPreparedQuery(databaseName, originalTableName, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, chunkSize, false, "test")
-		test.S(t).ExpectNil(err)
-		expected := `
-				select /* udup mydb.tbl test */ name, position
-				  from (
-				    select
-				        name, position
-				      from
-				        mydb.tbl
-				      where ((name > ?) or (((name = ?)) AND (position > ?))) and ((name < ?) or (((name = ?)) AND (position < ?)) or ((name = ?) and (position = ?)))
-				      order by
-				        name asc, position asc
-				      limit 500
-				  ) select_osc_chunk
-				order by
-				  name desc, position desc
-				limit 1
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 17, 103, 103, 117, 103, 117}))
-	}
-}
-
-func TestBuildUniqueKeyMinValuesPreparedQuery(t *testing.T) {
-	databaseName := "mydb"
-	originalTableName := "tbl"
-	uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-	{
-		query, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
-		test.S(t).ExpectNil(err)
-		expected := `
-			select /* udup mydb.tbl */ name, position
-			  from
-			    mydb.tbl
-			  order by
-			    name asc, position asc
-			  limit 1
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-	}
-	{
-		query, err := BuildUniqueKeyMaxValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
-		test.S(t).ExpectNil(err)
-		expected := `
-			select /* udup mydb.tbl */ name, position
-			  from
-			    mydb.tbl
-			  order by
-			    name desc, position desc
-			  limit 1
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+func toArgs(values ...interface{}) []*interface{} {
+	args := make([]*interface{}, len(values))
+	for i := range values {
+		args[i] = &values[i]
 	}
+	return args
 }
 
 func TestBuildDMLDeleteQuery(t *testing.T) {
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	args := []interface{}{3, "testname", "first", 17, 23}
-	{
-		uniqueKeyColumns := NewColumnList([]string{"position"})
-
-		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
-		test.S(t).ExpectNil(err)
-		expected := `
-			delete /* udup mydb.tbl */
-				from
-					mydb.tbl
-				where
-					((position = ?))
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{17}))
-	}
 	{
-		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
-
-		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
+		tableColumns := newTestColumns()
+		args := toArgs(3, "testname", "first", 17, 23)
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
 		expected := `
-			delete /* udup mydb.tbl */
+			delete
 				from
 					mydb.tbl
 				where
-					((name = ?) and (position = ?))
+					((id = ?))
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{"testname", 17}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
 	}
 	{
-		uniqueKeyColumns := NewColumnList([]string{"position", "name"})
-
-		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
+		// without primary key, all the columns are compared null-safe and one of the duplicates is deleted
+		tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "rank", "position", "age"}))
+		args := toArgs(3, "testname", nil, 17, 23)
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
 		expected := `
-			delete /* udup mydb.tbl */
+			delete
 				from
 					mydb.tbl
 				where
-					((position = ?) and (name = ?))
+					((id <=> ?) and (name <=> ?) and (rank <=> ?) and (position <=> ?) and (age <=> ?))
+				limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{17, "testname"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3, "testname", nil, 17, 23}))
 	}
 	{
-		uniqueKeyColumns := NewColumnList([]string{"position", "name"})
-		args := []interface{}{"first", 17}
-
-		_, _, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
+		tableColumns := newTestColumns()
+		args := toArgs("first", 17)
+		_, _, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNotNil(err)
 	}
 }
@@ -296,36 +117,30 @@ func TestBuildDMLDeleteQuery(t *testing.T) {
 func TestBuildDMLDeleteQuerySignedUnsigned(t *testing.T) {
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	uniqueKeyColumns := NewColumnList([]string{"position"})
+	columns := umconf.NewColumns([]string{"id", "name", "rank", "position", "age"})
+	columns[3].Key = "PRI"
+	tableColumns := umconf.NewColumnList(columns)
+	expected := `
+		delete
+			from
+				mydb.tbl
+			where
+				((position = ?))
+	`
 	{
 		// test signed (expect no change)
-		args := []interface{}{3, "testname", "first", -1, 23}
-		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
+		args := toArgs(3, "testname", "first", int8(-1), 23)
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
-		expected := `
-			delete /* udup mydb.tbl */
-				from
-					mydb.tbl
-				where
-					((position = ?))
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{-1}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{int8(-1)}))
 	}
 	{
 		// test unsigned
-		args := []interface{}{3, "testname", "first", int8(-1), 23}
-		uniqueKeyColumns.SetUnsigned("position")
-		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, args)
+		args := toArgs(3, "testname", "first", int8(-1), 23)
+		tableColumns.SetUnsigned("position")
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
-		expected := `
-			delete /* udup mydb.tbl */
-				from
-					mydb.tbl
-				where
-					((position = ?))
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
 		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{uint8(255)}))
 	}
@@ -334,44 +149,33 @@ func TestBuildDMLDeleteQuerySignedUnsigned(t *testing.T) {
 func TestBuildDMLInsertQuery(t *testing.T) {
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	args := []interface{}{3, "testname", "first", 17, 23}
+	tableColumns := newTestColumns()
+	args := toArgs(3, "testname", "first", 17, 23)
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
 		expected := `
-			replace /* udup mydb.tbl */
-				into mydb.tbl
-					(id, name, position, age)
+			replace into
+				mydb.tbl
+					(id, name, rank, position, age)
 				values
-					(?, ?, ?, ?)
+					(?, ?, ?, ?, ?)
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "first", 17, 23}))
 	}
 	{
-		sharedColumns := NewColumnList([]string{"position", "name", "age", "id"})
-		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
-		test.S(t).ExpectNil(err)
-		expected := `
-			replace /* udup mydb.tbl */
-				into mydb.tbl
-					(position, name, age, id)
-				values
-					(?, ?, ?, ?)
-		`
-		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{17, "testname", 23, 3}))
+		sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"position", "name", "surprise", "id"}))
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		test.S(t).ExpectNotNil(err)
 	}
 	{
-		sharedColumns := NewColumnList([]string{"position", "name", "surprise", "id"})
+		sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{}))
 		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
 		test.S(t).ExpectNotNil(err)
 	}
 	{
-		sharedColumns := NewColumnList([]string{})
-		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, toArgs(3, "testname"))
 		test.S(t).ExpectNotNil(err)
 	}
 }
@@ -379,197 +183,301 @@ func TestBuildDMLInsertQuery(t *testing.T) {
 func TestBuildDMLInsertQuerySignedUnsigned(t *testing.T) {
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
+	tableColumns := newTestColumns()
+	expected := `
+		replace into
+			mydb.tbl
+				(id, name, rank, position, age)
+			values
+				(?, ?, ?, ?, ?)
+	`
 	{
 		// testing signed
-		args := []interface{}{3, "testname", "first", int8(-1), 23}
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		args := toArgs(3, "testname", "first", int8(-1), 23)
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
-		expected := `
-			replace /* udup mydb.tbl */
-				into mydb.tbl
-					(id, name, position, age)
-				values
-					(?, ?, ?, ?)
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", int8(-1), 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "first", int8(-1), 23}))
 	}
 	{
 		// testing unsigned
-		args := []interface{}{3, "testname", "first", int8(-1), 23}
-		sharedColumns.SetUnsigned("position")
-		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		args := toArgs(3, "testname", "first", int8(-1), 23)
+		tableColumns.SetUnsigned("position")
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
-		expected := `
-			replace /* udup mydb.tbl */
-				into mydb.tbl
-					(id, name, position, age)
-				values
-					(?, ?, ?, ?)
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", uint8(255), 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "first", uint8(255), 23}))
 	}
 	{
 		// testing unsigned
-		args := []interface{}{3, "testname", "first", int32(-1), 23}
-		sharedColumns.SetUnsigned("position")
-		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+		args := toArgs(3, "testname", "first", int32(-1), 23)
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
-		expected := `
-			replace /* udup mydb.tbl */
-				into mydb.tbl
-					(id, name, position, age)
-				values
-					(?, ?, ?, ?)
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", uint32(4294967295), 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "first", uint32(4294967295), 23}))
 	}
 }
 
 func TestBuildDMLUpdateQuery(t *testing.T) {
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	valueArgs := []interface{}{3, "testname", "newval", 17, 23}
-	whereArgs := []interface{}{3, "testname", "findme", 17, 56}
+	tableColumns := newTestColumns()
+	valueArgs := toArgs(3, "testname", "newval", 17, 23)
+	whereArgs := toArgs(3, "testname", "findme", 17, 56)
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"position"})
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((position = ?))
+			update
+				mydb.tbl
+			set
+				id=?, name=?, rank=?, position=?, age=?
+			where
+				((id = ?))
+			limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{17}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "newval", 17, 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
 	}
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"position", "name"})
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		mappedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "rank", "role", "age"}))
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, mappedColumns, tableColumns, valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((position = ?) and (name = ?))
+			update
+				mydb.tbl
+			set
+				id=?, name=?, rank=?, role=?, age=?
+			where
+				((id = ?))
+			limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{17, "testname"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "newval", 17, 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
+	}
+	{
+		sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "surprise", "age"}))
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, tableColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNotNil(err)
 	}
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"age"})
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{}))
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, tableColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, toArgs(3))
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLUpdateQuerySignedUnsigned(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	columns := umconf.NewColumns([]string{"id", "name", "rank", "position", "age"})
+	columns[3].Key = "PRI"
+	tableColumns := umconf.NewColumnList(columns)
+	valueArgs := toArgs(3, "testname", "newval", int8(-17), int8(-2))
+	whereArgs := toArgs(3, "testname", "findme", int8(-3), 56)
+	expected := `
+		update
+			mydb.tbl
+		set
+			id=?, name=?, rank=?, position=?, age=?
+		where
+			((position = ?))
+		limit 1
+	`
+	{
+		// test signed
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
-		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((age = ?))
-		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{56}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "newval", int8(-17), int8(-2)}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{int8(-3)}))
 	}
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"age", "position", "id", "name"})
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		// test unsigned
+		tableColumns.SetUnsigned("age")
+		tableColumns.SetUnsigned("position")
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "newval", uint8(239), uint8(254)}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{uint8(253)}))
+	}
+}
+
+func TestBuildDMLUpdateQueryRowImage(t *testing.T) {
+	// update tbl set age = 24 where id = 3, logged with different binlog_row_image
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList([]umconf.Column{
+		{Name: "id", Key: "PRI"},
+		{Name: "name"},
+		{Name: "txt", ColumnType: "text"},
+		{Name: "age"},
+	})
+	subset := func(names ...string) *umconf.ColumnList {
+		columns := []umconf.Column{}
+		for _, name := range names {
+			columns = append(columns, *tableColumns.GetColumn(name))
+		}
+		return umconf.NewColumnList(columns)
+	}
+	toArgs := func(values ...interface{}) []*interface{} {
+		args := make([]*interface{}, len(values))
+		for i := range values {
+			args[i] = &values[i]
+		}
+		return args
+	}
+	{
+		// FULL
+		valueArgs := toArgs(3, "testname", "long text", 24)
+		whereArgs := toArgs(3, "testname", "long text", 23)
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((age = ?) and (position = ?) and (id = ?) and (name = ?))
+			update mydb.tbl
+				set id=?, name=?, txt=?, age=?
+				where ((id = ?))
+				limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{56, 17, 3, "testname"}))
-	}
-	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"age", "surprise"})
-		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
-		test.S(t).ExpectNotNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", "long text", 24}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
 	}
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		uniqueKeyColumns := NewColumnList([]string{})
-		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
-		test.S(t).ExpectNotNil(err)
+		// MINIMAL: before image has only the PK, after image has only the changed column
+		valueArgs := toArgs(nil, nil, nil, 24)
+		whereArgs := toArgs(3, nil, nil, nil)
+		newColumns := subset("age")
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, newColumns, newColumns, subset("id"), valueArgs, whereArgs)
+		test.S(t).ExpectNil(err)
+		expected := `
+			update mydb.tbl
+				set age=?
+				where ((id = ?))
+				limit 1
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{24}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
 	}
 	{
-		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-		mappedColumns := NewColumnList([]string{"id", "name", "role", "age"})
-		uniqueKeyColumns := NewColumnList([]string{"id"})
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, mappedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		// NOBLOB: the unchanged text column is absent from both images
+		valueArgs := toArgs(3, "testname", nil, 24)
+		whereArgs := toArgs(3, "testname", nil, 23)
+		newColumns := subset("id", "name", "age")
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, newColumns, newColumns, subset("id", "name", "age"), valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, role=?, age=?
-				where
-					((id = ?))
+			update mydb.tbl
+				set id=?, name=?, age=?
+				where ((id = ?))
+				limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 24}))
 		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
 	}
+	{
+		// where columns must be a subset of table columns
+		valueArgs := toArgs(nil, nil, nil, 24)
+		whereArgs := toArgs(3, nil, nil, nil)
+		whereColumns := umconf.NewColumnList(umconf.NewColumns([]string{"surprise"}))
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, subset("age"), subset("age"), whereColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNotNil(err)
+	}
 }
 
-func TestBuildDMLUpdateQuerySignedUnsigned(t *testing.T) {
+func TestBuildDMLFullRowMatch(t *testing.T) {
+	// a table without primary key, with NULLable columns
 	databaseName := "mydb"
 	tableName := "tbl"
-	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
-	valueArgs := []interface{}{3, "testname", "newval", int8(-17), int8(-2)}
-	whereArgs := []interface{}{3, "testname", "findme", int8(-3), 56}
-	sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
-	uniqueKeyColumns := NewColumnList([]string{"position"})
+	tableColumns := umconf.NewColumnList([]umconf.Column{
+		{Name: "name", Nullable: true},
+		{Name: "bin", ColumnType: "binary(4)", Nullable: true},
+		{Name: "age", Nullable: true},
+	})
+	toArgs := func(values ...interface{}) []*interface{} {
+		args := make([]*interface{}, len(values))
+		for i := range values {
+			args[i] = &values[i]
+		}
+		return args
+	}
+	{
+		args := toArgs("testname", nil, nil)
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		expected := `
+			delete from mydb.tbl
+				where ((name <=> ?) and (bin <=> cast(? as binary(4))) and (age <=> ?))
+				limit 1
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{"testname", nil, nil}))
+	}
 	{
-		// test signed
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		// the statement does not change with the NULL pattern
+		args := toArgs(nil, "abcd", 23)
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, tableColumns, args)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((position = ?))
+			delete from mydb.tbl
+				where ((name <=> ?) and (bin <=> cast(? as binary(4))) and (age <=> ?))
+				limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", int8(-17), int8(-2)}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{int8(-3)}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{nil, "abcd", 23}))
 	}
 	{
-		// test unsigned
-		sharedColumns.SetUnsigned("age")
-		uniqueKeyColumns.SetUnsigned("position")
-		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+		valueArgs := toArgs("testname", nil, 24)
+		whereArgs := toArgs("testname", nil, nil)
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
 		test.S(t).ExpectNil(err)
 		expected := `
-			update /* udup mydb.tbl */
-			  mydb.tbl
-					set id=?, name=?, position=?, age=?
-				where
-					((position = ?))
+			update mydb.tbl
+				set name=?, bin=?, age=?
+				where ((name <=> ?) and (bin <=> cast(? as binary(4))) and (age <=> ?))
+				limit 1
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
-		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", int8(-17), uint8(254)}))
-		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{uint8(253)}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{"testname", nil, 24}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{"testname", nil, nil}))
+	}
+}
+
+func TestRenameSchemas(t *testing.T) {
+	target := func(schema string) string {
+		switch schema {
+		case "app", "crm":
+			return "tenant1_" + schema
+		case "my db":
+			return "tenant1-db"
+		}
+		return schema
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"create database if not exists app", "create database if not exists tenant1_app"},
+		{"DROP SCHEMA `app`", "DROP SCHEMA `tenant1_app`"},
+		{"alter database character set utf8mb4", "alter database character set utf8mb4"},
+		{"create table app.t like `crm`.t0", "create table tenant1_app.t like `tenant1_crm`.t0"},
+		{"alter table t add constraint fk foreign key (c) references crm.p (id)",
+			"alter table t add constraint fk foreign key (c) references tenant1_crm.p (id)"},
+		{"create table `my db`.t (c decimal(4,2) default 1.5 comment 'app.t')",
+			"create table `tenant1-db`.t (c decimal(4,2) default 1.5 comment 'app.t')"},
+		{"drop table other.t /* app.t */", "drop table other.t /* app.t */"},
+		{"grant select on app.* to 'u'@'%'", "grant select on tenant1_app.* to 'u'@'%'"},
+	}
+	for _, tt := range tests {
+		test.S(t).ExpectEquals(RenameSchemas(tt.query, target), tt.want)
 	}
 }
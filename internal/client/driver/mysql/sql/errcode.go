@@ -7,6 +7,8 @@
 package sql
 
 import (
+	"strings"
+
 	"github.com/go-sql-driver/mysql"
 )
 
@@ -925,3 +927,76 @@ func IgnoreExistsError(err error) bool {
 		return false
 	}
 }
+
+// IsReadOnlyError tells if the statement failed because the server is read_only or super_read_only.
+func IsReadOnlyError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+
+	switch mysqlErr.Number {
+	case ErrOptionPreventsStatement:
+		// 1290 is also used for other options, e.g. --secure-file-priv.
+		return strings.Contains(mysqlErr.Message, "read-only") || strings.Contains(mysqlErr.Message, "read_only")
+	case ErrReadOnlyMode, ErrCantExecuteInReadOnlyTransaction:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsDeadlockError tells if the transaction was rolled back as a deadlock victim.
+func IsDeadlockError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrLockDeadlock
+}
+
+// IsLocalInfileDisabledError tells if LOAD DATA LOCAL INFILE failed because the server disallows it.
+func IsLocalInfileDisabledError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	// 3948: ER_CLIENT_LOCAL_FILES_DISABLED of MySQL 8.0
+	return mysqlErr.Number == ErrNotAllowedCommand || mysqlErr.Number == 3948
+}
+
+// IsDupEntryError tells if the statement failed on a duplicate value of a unique key.
+func IsDupEntryError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrDupEntry
+}
+
+// IsXaDupIdError tells if XA START failed because the xid already exists, e.g. is prepared.
+func IsXaDupIdError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrXaerDupid
+}
+
+// IsXaNotaError tells if the XA statement failed because the xid is unknown.
+func IsXaNotaError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrXaerNota
+}
+
+// IsNoSuchTableError tells if the statement failed because the table does not exist.
+func IsNoSuchTableError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrNoSuchTable
+}
+
+// IsTooManyConnectionsError tells if the connection was refused because the server is at its
+// max_connections.
+func IsTooManyConnectionsError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrConCount
+}
+
+// IsServerIdCollisionError tells if the binlog dump was stopped by the source because another
+// replica connected with the same server_id. The error might be wrapped.
+func IsServerIdCollisionError(err error) bool {
+	// ErrMasterFatalErrorReadingBinlog: "A slave (or replica) with the same server_uuid/server_id
+	// as this slave has connected to the master"
+	return err != nil && strings.Contains(err.Error(), "same server_uuid/server_id")
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsReadOnlyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"read-only", &mysql.MySQLError{Number: ErrOptionPreventsStatement,
+			Message: "The MySQL server is running with the --read-only option so it cannot execute this statement"}, true},
+		{"super-read-only", &mysql.MySQLError{Number: ErrOptionPreventsStatement,
+			Message: "The MySQL server is running with the --super-read-only option so it cannot execute this statement"}, true},
+		{"secure-file-priv", &mysql.MySQLError{Number: ErrOptionPreventsStatement,
+			Message: "The MySQL server is running with the --secure-file-priv option so it cannot execute this statement"}, false},
+		{"read-only-transaction", &mysql.MySQLError{Number: ErrCantExecuteInReadOnlyTransaction,
+			Message: "Cannot execute statement in a READ ONLY transaction."}, true},
+		{"dup-entry", &mysql.MySQLError{Number: ErrDupEntry, Message: "Duplicate entry '1' for key 'PRIMARY'"}, false},
+		{"not-mysql", fmt.Errorf("read-only"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsReadOnlyError(tt.err); got != tt.want {
+				t.Errorf("IsReadOnlyError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
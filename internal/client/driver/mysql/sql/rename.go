@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"bytes"
+	"strings"
+)
+
+// sqlToken is a token of a statement: an identifier, bare or quoted with backticks, or a
+// character. Strings, comments and spaces are not tokens.
+type sqlToken struct {
+	start, end int
+	// the unquoted name of an identifier. empty for a character
+	name   string
+	quoted bool
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// tokenizeSQL splits a statement into tokens, skipping the strings and the comments.
+func tokenizeSQL(query string) (tokens []sqlToken) {
+	n := len(query)
+	for i := 0; i < n; {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && strings.HasPrefix(query[i:], "-- ")):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = n
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = n
+			}
+		case c == '\'' || c == '"':
+			i++
+			for i < n {
+				if query[i] == '\\' {
+					i += 2
+				} else if query[i] == c {
+					i++
+					if i < n && query[i] == c {
+						i++
+						continue
+					}
+					break
+				} else {
+					i++
+				}
+			}
+		case c == '`':
+			start := i
+			var name bytes.Buffer
+			for i++; i < n; i++ {
+				if query[i] == '`' {
+					if i+1 < n && query[i+1] == '`' {
+						name.WriteByte('`')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				name.WriteByte(query[i])
+			}
+			tokens = append(tokens, sqlToken{start: start, end: i, name: name.String(), quoted: true})
+		case isWordByte(c):
+			start := i
+			for i < n && isWordByte(query[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{start: start, end: i, name: query[start:i]})
+		default:
+			tokens = append(tokens, sqlToken{start: i, end: i + 1})
+			i++
+		}
+	}
+	return tokens
+}
+
+// isDot tells whether the token at i is a ".".
+func isDot(query string, tokens []sqlToken, i int) bool {
+	return i >= 0 && i < len(tokens) && tokens[i].name == "" && query[tokens[i].start] == '.'
+}
+
+// isKeyword tells whether the token at i is a bare identifier of one of the keywords.
+func isKeyword(tokens []sqlToken, i int, keywords ...string) bool {
+	if i < 0 || i >= len(tokens) || tokens[i].quoted || tokens[i].name == "" {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(tokens[i].name, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumber tells whether a bare identifier is a number, e.g. the "1" of "1.5".
+func isNumber(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '0' || name[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isBareName tells whether a name can be written without quotes.
+func isBareName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isWordByte(name[i]) {
+			return false
+		}
+	}
+	return name != "" && !isNumber(name)
+}
+
+// RenameSchemas rewrites the schemas of a statement to their names given by target, e.g. for a DDL
+// on several schemas: the qualifiers of schema.table and schema.table.column, and the schema of
+// CREATE/ALTER/DROP DATABASE. The strings and the comments are kept, and a schema is quoted with
+// backticks if it was, or if its new name needs them.
+func RenameSchemas(query string, target func(schema string) string) string {
+	tokens := tokenizeSQL(query)
+
+	schemaAt := make(map[int]bool)
+	for i, token := range tokens {
+		if token.name != "" && isDot(query, tokens, i+1) && !isDot(query, tokens, i-1) &&
+			(token.quoted || !isNumber(token.name)) {
+			schemaAt[i] = true
+		}
+	}
+	if isKeyword(tokens, 0, "create", "alter", "drop") && isKeyword(tokens, 1, "database", "schema") {
+		i := 2
+		for isKeyword(tokens, i, "if", "not", "exists") {
+			i++
+		}
+		if i < len(tokens) && tokens[i].name != "" && !isDot(query, tokens, i+1) &&
+			!isKeyword(tokens, i, "character", "charset", "collate", "default", "encryption", "read") {
+			schemaAt[i] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	last := 0
+	for i, token := range tokens {
+		if !schemaAt[i] {
+			continue
+		}
+		renamed := target(token.name)
+		if renamed == token.name {
+			continue
+		}
+		buf.WriteString(query[last:token.start])
+		if token.quoted || !isBareName(renamed) {
+			buf.WriteString("`" + strings.Replace(renamed, "`", "``", -1) + "`")
+		} else {
+			buf.WriteString(renamed)
+		}
+		last = token.end
+	}
+	if last == 0 {
+		return query
+	}
+	buf.WriteString(query[last:])
+	return buf.String()
+}
@@ -9,6 +9,7 @@ package sql
 import (
 	"context"
 	gosql "database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,7 +20,7 @@ import (
 	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 const (
@@ -147,6 +148,10 @@ type Conn struct {
 	DbMutex *sync.Mutex
 	Db      *gosql.Conn
 	Fde     string
+	// connection_id() on the server
+	Id int64
+	// max_execution_time is set on the session, with MaxExecTime
+	ExecTimeSet bool
 
 	PsDeleteExecutedGtid *gosql.Stmt
 	PsInsertExecutedGtid *gosql.Stmt
@@ -158,32 +163,85 @@ type DB struct {
 	Fde     string
 }
 
-func CreateDB(mysql_uri string) (*gosql.DB, error) {
-	db, err := gosql.Open("mysql", mysql_uri)
-	if err != nil {
-		return nil, err
+// CreateDB opens a pool of connections to mysql_uri. initSQL, e.g. the InitSQL of a task, runs in
+// order on every connection the pool opens, including those replacing the broken ones.
+func CreateDB(mysql_uri string, initSQL ...string) (*gosql.DB, error) {
+	var db *gosql.DB
+	if len(initSQL) == 0 {
+		var err error
+		db, err = gosql.Open("mysql", mysql_uri)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		db = gosql.OpenDB(&initConnector{dsn: mysql_uri, initSQL: initSQL})
 	}
 	db.SetConnMaxLifetime(ConnMaxLifetime)
 
 	return db, nil
 }
 
+// initConnector opens the connections of the mysql driver, running initSQL on each.
+type initConnector struct {
+	dsn     string
+	initSQL []string
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.Execer)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("the connection cannot run InitSQL")
+	}
+	for _, query := range c.initSQL {
+		if _, err := execer.Exec(query, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("InitSQL '%v': %v", query, err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *initConnector) Driver() driver.Driver {
+	return mysql.MySQLDriver{}
+}
+
 func CreateConns(db *gosql.DB, count int) ([]*Conn, error) {
+	return CreateConnsContext(context.Background(), db, count)
+}
+
+// CreateConnsContext is CreateConns, bounded by ctx.
+func CreateConnsContext(ctx context.Context, db *gosql.DB, count int) ([]*Conn, error) {
 	conns := make([]*Conn, count)
 	for i := 0; i < count; i++ {
-		conn, err := db.Conn(context.Background())
+		conn, err := db.Conn(ctx)
 		if err != nil {
+			CloseConns(conns[:i]...)
 			return nil, err
 		}
 
-		_, err = conn.ExecContext(context.Background(), "SET @@session.foreign_key_checks = 0")
+		_, err = conn.ExecContext(ctx, "SET @@session.foreign_key_checks = 0")
 		if err != nil {
+			conn.Close()
+			CloseConns(conns[:i]...)
+			return nil, err
+		}
+
+		var id int64
+		if err := conn.QueryRowContext(ctx, "select connection_id()").Scan(&id); err != nil {
+			conn.Close()
+			CloseConns(conns[:i]...)
 			return nil, err
 		}
 
 		conns[i] = &Conn{
 			DbMutex: &sync.Mutex{},
 			Db:      conn,
+			Id:      id,
 		}
 	}
 	return conns, nil
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync"
+	"time"
+)
+
+// The initialization phases of the extractor, the applier and the exporter, see driver.StartupReporter.
+const (
+	startupPhaseInit = "init"
+
+	// the extractor connects to the source and checks its grants, GTID mode and binlog settings
+	startupPhaseSourceInspection = "source_inspection"
+	startupPhaseNatsConnection   = "nats_connection"
+	// the extractor opens its connections to the source, and reads its server settings
+	startupPhaseSourceConnection = "source_connection"
+	// the source refuses the connections with too many connections, which are retried
+	startupPhaseSourceConnectionSaturated = "source_connection_saturated"
+	startupPhaseReplicationCheck          = "replication_channel_check"
+	// the extractor reads the binlog position, with the snapshot of the full copy, and the tables
+	startupPhaseMetadataFetch = "metadata_fetch"
+
+	// the applier opens its connections to the target, and reads its server settings
+	startupPhaseTargetConnection = "target_connection"
+	startupPhaseNatsSubscription = "nats_subscription"
+)
+
+// startupTracker is the initialization phase of a task, until it has started.
+type startupTracker struct {
+	lock    sync.Mutex
+	phase   string
+	started bool
+}
+
+// enter records that the task is in phase.
+func (s *startupTracker) enter(phase string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phase = phase
+}
+
+// markStarted records that the task has started.
+func (s *startupTracker) markStarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.started = true
+}
+
+func (s *startupTracker) get() (phase string, started bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.phase == "" {
+		return startupPhaseInit, s.started
+	}
+	return s.phase, s.started
+}
+
+// startDeadline converts StartDeadline (in seconds), with 0 if disabled.
+func startDeadline(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (e *Extractor) StartupPhase() (phase string, started bool) {
+	return e.startup.get()
+}
+
+func (e *Extractor) StartDeadline() time.Duration {
+	return startDeadline(e.mysqlContext.StartDeadline)
+}
+
+func (a *Applier) StartupPhase() (phase string, started bool) {
+	return a.startup.get()
+}
+
+func (a *Applier) StartDeadline() time.Duration {
+	return startDeadline(a.mysqlContext.StartDeadline)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"encoding/json"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/config"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// statsPublisher publishes the statistics of a task on models.StatsSubject, for an aggregator
+// to assemble a job-wide view of the tasks on different nodes.
+// It is best effort: a failure is logged and the next interval is tried.
+type statsPublisher struct {
+	natsConn   *gonats.Conn
+	cfg        *config.MySQLDriverConfig
+	subject    string // the job
+	taskType   string
+	stats      func() (*models.TaskStatistics, error)
+	logger     *log.Entry
+	logPrefix  string
+	shutdownCh chan struct{}
+	// a failure is logged as a warning only after a success, not to flood the log
+	failing bool
+}
+
+func (p *statsPublisher) run() {
+	if p.cfg.StatsPublishInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(p.cfg.StatsPublishInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.publish(); err != nil {
+			if p.failing {
+				p.logger.Debugf("%v: stats publish error: %v", p.logPrefix, err)
+			} else {
+				p.logger.Warnf("%v: stats publish error: %v", p.logPrefix, err)
+			}
+			p.failing = true
+		} else {
+			p.failing = false
+		}
+	}
+}
+
+// publish does not wait for the nats server. The data path is not affected if nobody subscribes
+// or the connection is slow.
+func (p *statsPublisher) publish() error {
+	stats, err := p.stats()
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&models.StatsReport{
+		JobID:    p.subject,
+		AllocID:  p.cfg.AllocID,
+		TaskType: p.taskType,
+		Stats:    stats,
+	})
+	if err != nil {
+		return err
+	}
+	return p.natsConn.Publish(models.StatsSubject(p.subject), msg)
+}
+
+func (e *Extractor) periodicStatsPublish() {
+	p := &statsPublisher{
+		natsConn:   e.natsConn,
+		cfg:        e.mysqlContext,
+		subject:    e.subject,
+		taskType:   models.TaskTypeSrc,
+		stats:      e.Stats,
+		logger:     e.logger,
+		logPrefix:  "mysql.extractor",
+		shutdownCh: e.shutdownCh,
+	}
+	p.run()
+}
+
+func (a *Applier) periodicStatsPublish() {
+	p := &statsPublisher{
+		natsConn:   a.natsConn,
+		cfg:        a.mysqlContext,
+		subject:    a.subject,
+		taskType:   models.TaskTypeDest,
+		stats:      a.Stats,
+		logger:     a.logger,
+		logPrefix:  "mysql.applier",
+		shutdownCh: a.shutdownCh,
+	}
+	p.run()
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// statsBaseline is the counters of a task at its last stats reset. The counters themselves are
+// never reset: Stats reports them as they are in SinceTaskStart, and minus the baseline since the
+// reset. Stats and reset both read the counters under the lock, so the counters of a Stats
+// concurrent with a reset are all from before it, or all from after it.
+type statsBaseline struct {
+	lock    sync.Mutex
+	base    *models.TaskCounters
+	resetAt int64
+}
+
+// snapshot reads the counters with read, and returns them since the task start and since the
+// last reset, with the time of the reset.
+func (b *statsBaseline) snapshot(read func() *models.TaskCounters) (total, sinceReset *models.TaskCounters, resetAt int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	total = read()
+	return total, subCounters(total, b.base), b.resetAt
+}
+
+// reset reads the counters with read, makes them the baseline, and returns them since the
+// previous reset.
+func (b *statsBaseline) reset(read func() *models.TaskCounters, now time.Time) *models.TaskCounters {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	total := read()
+	sinceReset := subCounters(total, b.base)
+	b.base = total
+	b.resetAt = now.UnixNano()
+	return sinceReset
+}
+
+// subCounters returns the counters c minus base, in a copy. A nil base is zero.
+func subCounters(c, base *models.TaskCounters) *models.TaskCounters {
+	if base == nil {
+		base = &models.TaskCounters{}
+	}
+	d := &models.TaskCounters{
+		TxSucceeded: c.TxSucceeded - base.TxSucceeded,
+		TxFailed:    c.TxFailed - base.TxFailed,
+	}
+	if c.TableStats != nil {
+		t := *c.TableStats
+		if base.TableStats != nil {
+			t.InsertCount -= base.TableStats.InsertCount
+			t.UpdateCount -= base.TableStats.UpdateCount
+			t.DelCount -= base.TableStats.DelCount
+			t.ConflictCount -= base.TableStats.ConflictCount
+		}
+		d.TableStats = &t
+	}
+	for i, stat := range c.EventFilterStats {
+		s := *stat
+		// the rules are those of the task, the same in c and base
+		if i < len(base.EventFilterStats) {
+			s.Matched -= base.EventFilterStats[i].Matched
+		}
+		d.EventFilterStats = append(d.EventFilterStats, &s)
+	}
+	return d
+}
+
+// counters returns the resettable counters of the applier, since its start.
+func (a *Applier) counters() *models.TaskCounters {
+	c := &models.TaskCounters{
+		TableStats: &models.TableStats{
+			InsertCount:   atomic.LoadInt64(&a.insertCount),
+			UpdateCount:   atomic.LoadInt64(&a.updateCount),
+			DelCount:      atomic.LoadInt64(&a.deleteCount),
+			ConflictCount: atomic.LoadInt64(&a.conflictCount),
+		},
+	}
+	if a.txCounter != nil {
+		c.TxSucceeded, c.TxFailed = a.txCounter.totals()
+	}
+	return c
+}
+
+// ResetStats resets the counters of the applier, see models.TaskCounters, and returns them as
+// they were.
+func (a *Applier) ResetStats() (*models.TaskCounters, error) {
+	return a.statsBaseline.reset(a.counters, time.Now()), nil
+}
+
+// counters returns the resettable counters of the extractor, since its start.
+func (e *Extractor) counters() *models.TaskCounters {
+	c := &models.TaskCounters{}
+	if e.binlogReader != nil {
+		c.EventFilterStats = e.binlogReader.EventFilterStats()
+	}
+	return c
+}
+
+// ResetStats resets the counters of the extractor, see models.TaskCounters, and returns them as
+// they were.
+func (e *Extractor) ResetStats() (*models.TaskCounters, error) {
+	return e.statsBaseline.reset(e.counters, time.Now()), nil
+}
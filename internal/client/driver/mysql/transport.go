@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// interval of the extractor reporting its publish counters to the applier on "<subject>_transport"
+	transportReportInterval = 10 * time.Second
+)
+
+// transportCounter counts the messages of a task per nats subject. A new one is made
+// each time the task is started, so the counters are reset on restart.
+type transportCounter struct {
+	lock     sync.Mutex
+	subjects map[string]*models.SubjectStat
+	started  time.Time
+}
+
+func newTransportCounter() *transportCounter {
+	return &transportCounter{
+		subjects: make(map[string]*models.SubjectStat),
+		started:  time.Now(),
+	}
+}
+
+// get must be called with lock held.
+func (c *transportCounter) get(subject string) *models.SubjectStat {
+	stat, ok := c.subjects[subject]
+	if !ok {
+		stat = &models.SubjectStat{Subject: subject}
+		c.subjects[subject] = stat
+	}
+	return stat
+}
+
+// subscribed lists the subject in the stats before any message is received.
+func (c *transportCounter) subscribed(subject string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.get(subject)
+}
+
+func (c *transportCounter) published(subject string, nBytes int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stat := c.get(subject)
+	stat.PublishMsgs++
+	stat.PublishBytes += int64(nBytes)
+	stat.LastPublish = time.Now().UTC().UnixNano()
+}
+
+func (c *transportCounter) received(subject string, nBytes int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stat := c.get(subject)
+	stat.ReceiveMsgs++
+	stat.ReceiveBytes += int64(nBytes)
+	stat.LastReceive = time.Now().UTC().UnixNano()
+}
+
+// lastReceive returns the time of the last received message, or the start time if there is none.
+func (c *transportCounter) lastReceive() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	last := c.started
+	for _, stat := range c.subjects {
+		if stat.LastReceive > last.UnixNano() {
+			last = time.Unix(0, stat.LastReceive)
+		}
+	}
+	return last
+}
+
+// stats returns a copy of the counters, sorted by subject.
+func (c *transportCounter) stats() []*models.SubjectStat {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result := make([]*models.SubjectStat, 0, len(c.subjects))
+	for _, stat := range c.subjects {
+		statCopy := *stat
+		result = append(result, &statCopy)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Subject < result[j].Subject
+	})
+	return result
+}
+
+// periodicTransportReport sends the publish counters to the applier, for the applier to tell
+// whether it is missing messages. The reports are not counted.
+func (e *Extractor) periodicTransportReport() {
+	ticker := time.NewTicker(transportReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		msg, err := Encode(e.transport.stats())
+		if err != nil {
+			e.logger.Warnf("mysql.extractor: transport report error: %v", err)
+			continue
+		}
+		if err := e.natsConn.Publish(fmt.Sprintf("%s_transport", e.subject), msg); err != nil {
+			e.logger.Warnf("mysql.extractor: transport report error: %v", err)
+		}
+	}
+}
+
+// subscribe subscribes to the subject, counting the received messages.
+func (a *Applier) subscribe(subject string, cb gonats.MsgHandler) (*gonats.Subscription, error) {
+	a.transport.subscribed(subject)
+	return a.natsConn.Subscribe(subject, func(m *gonats.Msg) {
+		a.transport.received(m.Subject, len(m.Data))
+		cb(m)
+	})
+}
+
+// handleTransportReport keeps the counters of the extractor, and emits an event if the
+// extractor is publishing while the applier has received nothing for TransportIdleTimeout.
+// It is usually caused by the subjects of the two sides not matching.
+func (a *Applier) handleTransportReport(m *gonats.Msg) {
+	var peer []*models.SubjectStat
+	if err := Decode(m.Data, &peer); err != nil {
+		a.logger.Warnf("mysql.applier: transport report error: %v", err)
+		return
+	}
+
+	a.peerTransportLock.Lock()
+	defer a.peerTransportLock.Unlock()
+	prev := a.peerTransport
+	a.peerTransport = peer
+
+	if a.mysqlContext.TransportIdleTimeout < 0 {
+		return
+	}
+	timeout := time.Duration(a.mysqlContext.TransportIdleTimeout) * time.Second
+	idle := time.Since(a.transport.lastReceive())
+	publishing := peerPublishing(prev, peer)
+	if idle <= timeout || len(publishing) == 0 {
+		a.transportIdleReported = false
+		return
+	}
+	if a.transportIdleReported {
+		return
+	}
+	a.transportIdleReported = true
+
+	var subscribed []string
+	for _, stat := range a.transport.stats() {
+		subscribed = append(subscribed, stat.Subject)
+	}
+	a.logger.Warnf("mysql.applier: received nothing for %v while the extractor is publishing to %v. subscribed: %v",
+		idle, publishing, subscribed)
+	a.emitEvent("Applier has received nothing for %v while the extractor is publishing to %v. Applier subscribes to %v",
+		idle.Truncate(time.Second), strings.Join(publishing, ", "), strings.Join(subscribed, ", "))
+}
+
+// peerPublishing returns the subjects whose publish count increased from prev to cur.
+func peerPublishing(prev, cur []*models.SubjectStat) []string {
+	prevMsgs := make(map[string]int64)
+	for _, stat := range prev {
+		prevMsgs[stat.Subject] = stat.PublishMsgs
+	}
+	var subjects []string
+	for _, stat := range cur {
+		if stat.PublishMsgs > prevMsgs[stat.Subject] {
+			subjects = append(subjects, stat.Subject)
+		}
+	}
+	return subjects
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// txCounter counts the transactions applied and failed, in total and in a window reset every
+// interval. A rising error rate warns of trouble on the target before the retries give up.
+type txCounter struct {
+	lock            sync.Mutex
+	interval        time.Duration
+	succeeded       int64
+	failed          int64
+	windowStart     time.Time
+	windowSucceeded int64
+	windowFailed    int64
+}
+
+func newTxCounter(interval time.Duration, now time.Time) *txCounter {
+	return &txCounter{
+		interval:    interval,
+		windowStart: now,
+	}
+}
+
+// rollWindow starts a new window if the current one is over at now.
+func (c *txCounter) rollWindow(now time.Time) {
+	if now.Sub(c.windowStart) < c.interval {
+		return
+	}
+	c.windowStart = now
+	c.windowSucceeded = 0
+	c.windowFailed = 0
+}
+
+// add counts n transactions applied at now, or failed if err is not nil.
+func (c *txCounter) add(n int, err error, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rollWindow(now)
+	if err == nil {
+		c.succeeded += int64(n)
+		c.windowSucceeded += int64(n)
+	} else {
+		c.failed += int64(n)
+		c.windowFailed += int64(n)
+	}
+}
+
+// totals returns the transactions applied and failed since the start.
+func (c *txCounter) totals() (succeeded, failed int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.succeeded, c.failed
+}
+
+func (c *txCounter) stat(now time.Time) *models.TxStat {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rollWindow(now)
+	stat := &models.TxStat{
+		Succeeded:       c.succeeded,
+		Failed:          c.failed,
+		WindowSucceeded: c.windowSucceeded,
+		WindowFailed:    c.windowFailed,
+		WindowStart:     c.windowStart.Unix(),
+	}
+	if total := c.windowSucceeded + c.windowFailed; total > 0 {
+		stat.ErrorRatePct = float64(c.windowFailed) * 100 / float64(total)
+	}
+	return stat
+}
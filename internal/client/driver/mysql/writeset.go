@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+const (
+	// size of the hash table of written keys. Different keys in a bucket are seen as conflicting.
+	writeSetBuckets = 1 << 16
+)
+
+// writeSetTracker detects conflicting transactions for parallel apply. A transaction writing
+// a (schema, table, primary key) written by an earlier transaction waits for it to be applied,
+// even if the source logged them as parallel (e.g. with WRITESET on another key set, or after a
+// table mapping). Keys are hashed into a fixed number of buckets, so there are false positives,
+// which only cost parallelism.
+type writeSetTracker struct {
+	// bucket -> seqNum of the last enqueued tx writing it. 0 for none. accessed atomically
+	buckets []int64
+	strict  bool
+	// number of txs which waited for a conflicting tx. accessed atomically
+	waits int64
+}
+
+func newWriteSetTracker(strict bool) *writeSetTracker {
+	return &writeSetTracker{
+		buckets: make([]int64, writeSetBuckets),
+		strict:  strict,
+	}
+}
+
+// reset forgets all the keys, as the seqNums restart in a new binlog file.
+func (w *writeSetTracker) reset() {
+	for i := range w.buckets {
+		atomic.StoreInt64(&w.buckets[i], 0)
+	}
+}
+
+// tableOnly tells whether the rows of the table cannot be told apart by the primary key.
+// It is the case without a primary key, and in strict mode, with a unique secondary key:
+// two txs might conflict on the unique key with different primary keys.
+func (w *writeSetTracker) tableOnly(columns *umconf.ColumnList) bool {
+	if columns == nil {
+		return true
+	}
+	hasPk := false
+	for _, column := range columns.ColumnList() {
+		switch column.Key {
+		case "PRI":
+			hasPk = true
+		case "UNI":
+			if w.strict {
+				return true
+			}
+		}
+	}
+	return !hasPk
+}
+
+// bucketsOf returns the buckets written by the tx, without duplicates.
+// The table items of the events must have been set.
+func (w *writeSetTracker) bucketsOf(binlogEntry *binlog.BinlogEntry) []int {
+	seen := make(map[int]bool)
+	var result []int
+	add := func(keys ...interface{}) {
+		h := fnv.New64a()
+		for _, key := range keys {
+			fmt.Fprintf(h, "%v\x00", key)
+		}
+		bucket := int(h.Sum64() % writeSetBuckets)
+		if !seen[bucket] {
+			seen[bucket] = true
+			result = append(result, bucket)
+		}
+	}
+	for i := range binlogEntry.Events {
+		event := &binlogEntry.Events[i]
+		if event.DML == binlog.NotDML {
+			continue
+		}
+		var columns *umconf.ColumnList
+		if tableItem, ok := event.TableItem.(*applierTableItem); ok && tableItem != nil {
+			columns = tableItem.columns
+		}
+		if w.tableOnly(columns) {
+			add(event.DatabaseName, event.TableName)
+			continue
+		}
+		// both the before and after images: an update might change the primary key
+		for _, values := range []*umconf.ColumnValues{event.WhereColumnValues, event.NewColumnValues} {
+			if values == nil {
+				continue
+			}
+			key, ok := pkValues(columns, values)
+			if !ok {
+				// not in a non-FULL image
+				continue
+			}
+			add(append([]interface{}{event.DatabaseName, event.TableName}, key...)...)
+		}
+	}
+	return result
+}
+
+// pkValues returns the values of the primary key columns. ok is false if any of them is absent.
+func pkValues(columns *umconf.ColumnList, values *umconf.ColumnValues) (key []interface{}, ok bool) {
+	abstractValues := values.GetAbstractValues()
+	for i, column := range columns.ColumnList() {
+		if !column.IsPk() {
+			continue
+		}
+		if i >= len(abstractValues) || abstractValues[i] == nil || *abstractValues[i] == nil {
+			return nil, false
+		}
+		key = append(key, *abstractValues[i])
+	}
+	return key, true
+}
+
+// dependsOn returns the seqNum of the last tx writing any of the buckets, or 0.
+func (w *writeSetTracker) dependsOn(buckets []int) (seq int64) {
+	for _, bucket := range buckets {
+		if s := atomic.LoadInt64(&w.buckets[bucket]); s > seq {
+			seq = s
+		}
+	}
+	return seq
+}
+
+func (w *writeSetTracker) add(buckets []int, seq int64) {
+	for _, bucket := range buckets {
+		atomic.StoreInt64(&w.buckets[bucket], seq)
+	}
+}
+
+// inFlight returns the number of buckets written by txs with seqNum > lastCommitted.
+func (w *writeSetTracker) inFlight(lastCommitted int64) (n int) {
+	for i := range w.buckets {
+		if atomic.LoadInt64(&w.buckets[i]) > lastCommitted {
+			n++
+		}
+	}
+	return n
+}
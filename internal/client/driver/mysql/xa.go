@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	"sync/atomic"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	gomysql "github.com/siddontang/go-mysql/mysql"
+)
+
+// splitXaEntries splits a group of entries around the XA entries, which are applied on their own.
+func splitXaEntries(binlogEntries []*binlog.BinlogEntry) (groups [][]*binlog.BinlogEntry) {
+	start := 0
+	for i, binlogEntry := range binlogEntries {
+		if binlogEntry.XaOp == "" {
+			continue
+		}
+		if i > start {
+			groups = append(groups, binlogEntries[start:i])
+		}
+		groups = append(groups, binlogEntries[i:i+1])
+		start = i + 1
+	}
+	if start < len(binlogEntries) {
+		groups = append(groups, binlogEntries[start:])
+	}
+	return groups
+}
+
+// addXaPreparedExecuted adds the GTID of the prepare part of an XA transaction to gtidExecuted,
+// as the entry committing it is.
+func (a *Applier) addXaPreparedExecuted(coordinates *base.BinlogCoordinateTx) {
+	gtidSetItem, ok := a.gtidExecuted[coordinates.SID]
+	if !ok {
+		gtidSetItem = &base.GtidExecutedItem{}
+		a.gtidExecuted[coordinates.SID] = gtidSetItem
+	}
+	gtidSetItem.NRow += 1
+	gtidSetItem.Intervals = append(gtidSetItem.Intervals,
+		gomysql.Interval{Start: coordinates.GNO, Stop: coordinates.GNO + 1}).Normalize()
+}
+
+// applyXaEntry applies a part of an XA transaction with XaPolicyTarget. The prepare part is
+// applied in an XA transaction of the same xid, which is prepared. The commit part commits or
+// rolls it back, then records the GTIDs of both parts as executed, so that a prepare part is
+// applied again after a restart until it is committed. Either part applied again is ignored: XA
+// START fails with XAER_DUPID on a prepared xid, and XA COMMIT and XA ROLLBACK with XAER_NOTA on a
+// finished one.
+func (a *Applier) applyXaEntry(workerIdx int, binlogEntry *binlog.BinlogEntry) error {
+	dbApplier := a.dbs[workerIdx]
+	dbApplier.DbMutex.Lock()
+	defer dbApplier.DbMutex.Unlock()
+	ctx := context.Background()
+	exec := func(query string) error {
+		a.logger.Debugf("mysql.applier: Exec [%s]", query)
+		_, err := dbApplier.Db.ExecContext(ctx, query)
+		return err
+	}
+	xid := binlogEntry.Xid
+
+	switch binlogEntry.XaOp {
+	case binlog.XaOpPrepare:
+		if err := exec("XA START " + xid); err != nil {
+			if !sql.IsXaDupIdError(err) {
+				return err
+			}
+			a.logger.Infof("mysql.applier: XA %v is already prepared. gtid: %v:%v",
+				xid, binlogEntry.Coordinates.SID, binlogEntry.Coordinates.GNO)
+			break
+		}
+		nInsert, nUpdate, nDelete, err := a.applyXaRows(workerIdx, binlogEntry)
+		if err != nil {
+			// for the prepare part to be applied again
+			if errEnd := exec("XA END " + xid); errEnd != nil {
+				a.logger.Warnf("mysql.applier: XA END %v error: %v", xid, errEnd)
+			} else if errRollback := exec("XA ROLLBACK " + xid); errRollback != nil {
+				a.logger.Warnf("mysql.applier: XA ROLLBACK %v error: %v", xid, errRollback)
+			}
+			return err
+		}
+		if err := exec("XA END " + xid); err != nil {
+			return err
+		}
+		if err := exec("XA PREPARE " + xid); err != nil {
+			return err
+		}
+		atomic.AddInt64(&a.insertCount, nInsert)
+		atomic.AddInt64(&a.updateCount, nUpdate)
+		atomic.AddInt64(&a.deleteCount, nDelete)
+	case binlog.XaOpCommit, binlog.XaOpRollback:
+		query := "XA COMMIT " + xid
+		if binlogEntry.XaOp == binlog.XaOpRollback {
+			query = "XA ROLLBACK " + xid
+		}
+		if err := exec(query); err != nil {
+			if !sql.IsXaNotaError(err) {
+				return err
+			}
+			a.logger.Warnf("mysql.applier: XA %v is not prepared on the target, finished before a restart "+
+				"or prepared before the start of the replication. gtid: %v:%v",
+				xid, binlogEntry.Coordinates.SID, binlogEntry.Coordinates.GNO)
+		}
+		// both GTIDs or none, for the prepare part not to be applied again without its commit part
+		tx, err := dbApplier.Db.BeginTx(ctx, &gosql.TxOptions{})
+		if err != nil {
+			return err
+		}
+		gtids := []*base.BinlogCoordinateTx{&binlogEntry.Coordinates}
+		if binlogEntry.XaPrepared != nil {
+			gtids = append(gtids, binlogEntry.XaPrepared)
+		}
+		for _, coordinates := range gtids {
+			if _, err := dbApplier.PsInsertExecutedGtid.Exec(coordinates.SID.Bytes(), coordinates.GNO); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		for _, coordinates := range gtids {
+			a.markGtidApplied(coordinates)
+		}
+	}
+	a.mtsManager.Executed(binlogEntry)
+	atomic.AddInt64(&a.mysqlContext.TotalDeltaCopied, 1)
+	return nil
+}
+
+// applyXaRows applies the rows of the prepare part of an XA transaction, in the XA transaction
+// started on the connection of the worker, and returns the number of rows events applied.
+func (a *Applier) applyXaRows(workerIdx int, binlogEntry *binlog.BinlogEntry) (nInsert, nUpdate, nDelete int64, err error) {
+	for _, event := range binlogEntry.Events {
+		if event.DML == binlog.NotDML || a.isDmlFiltered(&event) {
+			// no DDL in XA transactions
+			continue
+		}
+		stmt, args, _, err := a.buildDMLEventQuery(event, workerIdx)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			a.logger.Errorf("mysql.applier: gtid: %v:%v, error: %v", binlogEntry.Coordinates.SID, binlogEntry.Coordinates.GNO, err)
+			return 0, 0, 0, err
+		}
+		switch event.DML {
+		case binlog.InsertDML:
+			nInsert++
+		case binlog.UpdateDML:
+			nUpdate++
+		case binlog.DeleteDML:
+			nDelete++
+		}
+	}
+	return nInsert, nUpdate, nDelete, nil
+}
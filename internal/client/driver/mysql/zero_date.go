@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// relaxZeroDatesSQL allows the zero and the invalid dates on a session of the applier, for
+// ZeroDatePolicyPreserve, keeping the other modes of its sql_mode.
+const relaxZeroDatesSQL = "SET SESSION sql_mode = TRIM(LEADING ',' FROM CONCAT(" +
+	"REPLACE(REPLACE(CONCAT(',', @@session.sql_mode, ','), ',NO_ZERO_IN_DATE,', ','), ',NO_ZERO_DATE,', ','), " +
+	"'ALLOW_INVALID_DATES'))"
+
+// The temporal columns with zero dates, by their type in SHOW COLUMNS, and their epochs of
+// ZeroDatePolicyEpoch.
+var temporalEpochs = map[string]string{
+	"date":      "1970-01-01",
+	"datetime":  "1970-01-01 00:00:00",
+	"timestamp": "1970-01-02 00:00:00",
+}
+
+// temporalColumns returns the kinds of the DATE, DATETIME and TIMESTAMP columns of a table, by
+// name: "date", "datetime" or "timestamp".
+func temporalColumns(columns *umconf.ColumnList) map[string]string {
+	temporals := make(map[string]string)
+	for _, column := range columns.Columns {
+		columnType := strings.ToLower(column.ColumnType)
+		if i := strings.IndexByte(columnType, '('); i >= 0 {
+			columnType = columnType[:i]
+		}
+		if _, ok := temporalEpochs[columnType]; ok {
+			temporals[column.Name] = columnType
+		}
+	}
+	return temporals
+}
+
+// IsZeroOrInvalidDate tells whether a DATE, DATETIME or TIMESTAMP value, e.g.
+// "2023-02-30 10:00:00", has a zero month or day, as '0000-00-00', or is not a date.
+func IsZeroOrInvalidDate(s string) bool {
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	year, err1 := strconv.Atoi(s[0:4])
+	month, err2 := strconv.Atoi(s[5:7])
+	day, err3 := strconv.Atoi(s[8:10])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	if month == 0 || day == 0 || month > 12 {
+		return true
+	}
+	// the day 0 of the next month is the last day of the month
+	return day > time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// convertZeroDates converts the zero and the invalid dates of a row to NULL or to the epoch, by
+// policy. values[i] is the value of the column names[i]. It returns the number of values converted.
+func convertZeroDates(policy string, temporals map[string]string, names []string, values []*interface{}) int {
+	converted := 0
+	for i, name := range names {
+		kind, ok := temporals[name]
+		if !ok || i >= len(values) || values[i] == nil {
+			continue
+		}
+		var s string
+		switch v := (*values[i]).(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			continue
+		}
+		if !IsZeroOrInvalidDate(s) {
+			continue
+		}
+		if policy == config.ZeroDatePolicyNull {
+			*values[i] = nil
+		} else {
+			*values[i] = temporalEpochs[kind]
+		}
+		converted++
+	}
+	return converted
+}
+
+// convertsZeroDates tells whether ZeroDatePolicy converts the zero dates of the rows.
+func (a *Applier) convertsZeroDates() bool {
+	policy := a.mysqlContext.ZeroDatePolicy
+	return policy == config.ZeroDatePolicyNull || policy == config.ZeroDatePolicyEpoch
+}
+
+// convertRowZeroDates converts the zero dates of the rows of a table for ZeroDatePolicy, and
+// counts them for the table.
+func (a *Applier) convertRowZeroDates(schema, table string, temporals map[string]string, names []string,
+	rows ...[]*interface{}) {
+
+	converted := 0
+	for _, row := range rows {
+		converted += convertZeroDates(a.mysqlContext.ZeroDatePolicy, temporals, names, row)
+	}
+	if converted == 0 {
+		return
+	}
+	a.zeroDatesLock.Lock()
+	defer a.zeroDatesLock.Unlock()
+	if a.zeroDates == nil {
+		a.zeroDates = make(map[string]int64)
+	}
+	a.zeroDates[fmt.Sprintf("%s.%s", schema, table)] += int64(converted)
+}
+
+// convertDumpEntryZeroDates converts the zero dates of the rows of the full copy for
+// ZeroDatePolicy.
+func (a *Applier) convertDumpEntryZeroDates(entry *DumpEntry) error {
+	if !a.convertsZeroDates() || len(entry.ValuesX) == 0 {
+		return nil
+	}
+	meta, err := a.metaCache.get(a.db, entry.TableSchema, entry.TableName)
+	if err != nil {
+		return err
+	}
+	if len(meta.temporals) == 0 {
+		return nil
+	}
+	names := meta.columns.Names()
+	if entry.Table != nil && entry.Table.OriginalTableColumns != nil {
+		names = entry.Table.OriginalTableColumns.Names()
+	}
+	a.convertRowZeroDates(entry.TableSchema, entry.TableName, meta.temporals, names, entry.ValuesX...)
+	return nil
+}
+
+// zeroDateConversions returns the zero dates converted for ZeroDatePolicy, by "schema.table".
+func (a *Applier) zeroDateConversions() map[string]int64 {
+	a.zeroDatesLock.Lock()
+	defer a.zeroDatesLock.Unlock()
+	if len(a.zeroDates) == 0 {
+		return nil
+	}
+	conversions := make(map[string]int64, len(a.zeroDates))
+	for table, n := range a.zeroDates {
+		conversions[table] = n
+	}
+	return conversions
+}
+
+// targetInitSQL returns the statements run on every connection to the target: InitSQL, then
+// the relaxing of the sql_mode for ZeroDatePolicyPreserve.
+func (a *Applier) targetInitSQL() []string {
+	if a.mysqlContext.ZeroDatePolicy != config.ZeroDatePolicyPreserve {
+		return a.mysqlContext.InitSQL
+	}
+	return append(append([]string(nil), a.mysqlContext.InitSQL...), relaxZeroDatesSQL)
+}
+
+// RejectsZeroDates tells whether a target of sql_mode rejects the zero dates: in a strict mode,
+// with NO_ZERO_DATE or NO_ZERO_IN_DATE.
+func RejectsZeroDates(sqlMode string) bool {
+	modes := make(map[string]bool)
+	for _, mode := range strings.Split(strings.ToUpper(sqlMode), ",") {
+		modes[strings.TrimSpace(mode)] = true
+	}
+	strict := modes["STRICT_TRANS_TABLES"] || modes["STRICT_ALL_TABLES"] || modes["TRADITIONAL"]
+	return strict && (modes["NO_ZERO_DATE"] || modes["NO_ZERO_IN_DATE"] || modes["TRADITIONAL"])
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// fdCheckIntv is how often the open files of the client are counted.
+	fdCheckIntv = 30 * time.Second
+
+	// fdWarnRatio is the part of the limit of open files past which the
+	// client warns.
+	fdWarnRatio = 0.9
+
+	// fdBaseline is the files the client opens without tasks: the state,
+	// the RPC and nats connections and the listeners.
+	fdBaseline = 256
+
+	// fdPerTask is the files a task is expected to open: its MySQL
+	// connections, one per worker, its nats connection, and its spill,
+	// dead-letter and audit files.
+	fdPerTask = 64
+
+	// defaultExpectedTasks is ClientConfig.ExpectedTasks if unset.
+	defaultExpectedTasks = 16
+
+	// maxNodeEvents is how many node events are kept.
+	maxNodeEvents = 20
+)
+
+// fdThreshold returns the least limit of open files for expectedTasks tasks.
+func fdThreshold(expectedTasks int) uint64 {
+	if expectedTasks <= 0 {
+		expectedTasks = defaultExpectedTasks
+	}
+	return fdBaseline + uint64(expectedTasks)*fdPerTask
+}
+
+// fingerprintOpenFiles records the limit of open files of the process in the
+// node attributes, and returns it. It is 0 where the limit is unknown.
+func (c *Client) fingerprintOpenFiles() uint64 {
+	limit, ok := maxOpenFiles()
+	if !ok {
+		return 0
+	}
+	atomic.StoreUint64(&c.maxOpenFiles, limit)
+	c.configLock.Lock()
+	c.config.Node.Attributes[models.NodeAttrMaxOpenFiles] = strconv.FormatUint(limit, 10)
+	c.configLock.Unlock()
+	return limit
+}
+
+// checkOpenFilesLimit is the preflight of the limit of open files at start.
+// A limit below that for ExpectedTasks is logged, or fails the start with
+// OpenFilesPolicyFail. Tasks running out of files fail in confusing ways, on
+// any of their connections or files.
+func (c *Client) checkOpenFilesLimit(limit uint64) error {
+	policy := c.config.OpenFilesPolicy
+	switch policy {
+	case "":
+		policy = config.OpenFilesPolicyWarn
+	case config.OpenFilesPolicyWarn, config.OpenFilesPolicyFail:
+	default:
+		return fmt.Errorf("unknown open files policy %q. expect %v or %v",
+			policy, config.OpenFilesPolicyWarn, config.OpenFilesPolicyFail)
+	}
+	if limit == 0 {
+		// unknown on this platform
+		return nil
+	}
+
+	threshold := fdThreshold(c.config.ExpectedTasks)
+	if limit >= threshold {
+		return nil
+	}
+	expectedTasks := c.config.ExpectedTasks
+	if expectedTasks <= 0 {
+		expectedTasks = defaultExpectedTasks
+	}
+	if policy == config.OpenFilesPolicyFail {
+		return fmt.Errorf("the limit of open files %v is below %v for %v tasks. raise it with ulimit -n",
+			limit, threshold, expectedTasks)
+	}
+	c.logger.Warnf("agent: The limit of open files %v is below %v for %v tasks. Raise it with ulimit -n",
+		limit, threshold, expectedTasks)
+	return nil
+}
+
+// watchOpenFiles is a long lived goroutine counting the open files of the
+// client, for the client.open_files metric and Stats. The limit is
+// fingerprinted again, as it can be raised at runtime with prlimit.
+func (c *Client) watchOpenFiles() {
+	clk := c.clk()
+	warned := false
+	for {
+		select {
+		case <-clk.After(fdCheckIntv):
+		case <-c.shutdownCh:
+			return
+		}
+
+		n, ok := openFiles()
+		if !ok {
+			// unknown on this platform
+			return
+		}
+		atomic.StoreInt64(&c.openFiles, int64(n))
+		warned = c.checkOpenFiles(n, c.fingerprintOpenFiles(), warned)
+	}
+}
+
+// checkOpenFiles warns and emits a node event when n open files are past
+// fdWarnRatio of limit, unless it has warned since they last were not, and
+// returns whether they are.
+func (c *Client) checkOpenFiles(n int, limit uint64, warned bool) bool {
+	if limit == 0 || float64(n) <= fdWarnRatio*float64(limit) {
+		return false
+	}
+	if !warned {
+		c.logger.Warnf("agent: %v files open out of the limit of %v. Raise it with ulimit -n", n, limit)
+		c.emitNodeEvent(models.NodeEventOpenFiles,
+			fmt.Sprintf("%v files open out of the limit of %v", n, limit),
+			map[string]string{
+				"open_files":     strconv.Itoa(n),
+				"max_open_files": strconv.FormatUint(limit, 10),
+			})
+	}
+	return true
+}
+
+// emitNodeEvent records a condition of the node, keeping the last
+// maxNodeEvents, and queues it for the servers.
+func (c *Client) emitNodeEvent(typ, message string, details map[string]string) {
+	c.nodeEventsLock.Lock()
+	defer c.nodeEventsLock.Unlock()
+	event := models.NewNodeEvent(typ, message, details, c.clk().Now())
+	c.nodeEvents = append(c.nodeEvents, event)
+	if len(c.nodeEvents) > maxNodeEvents {
+		c.nodeEvents = c.nodeEvents[len(c.nodeEvents)-maxNodeEvents:]
+	}
+	c.pendingNodeEvents = append(c.pendingNodeEvents, event)
+	if len(c.pendingNodeEvents) > maxNodeEvents {
+		c.pendingNodeEvents = c.pendingNodeEvents[len(c.pendingNodeEvents)-maxNodeEvents:]
+	}
+}
+
+// takeNodeEvents returns the events not yet sent to the servers, which are
+// then taken as sent.
+func (c *Client) takeNodeEvents() []*models.NodeEvent {
+	c.nodeEventsLock.Lock()
+	defer c.nodeEventsLock.Unlock()
+	events := c.pendingNodeEvents
+	c.pendingNodeEvents = nil
+	return events
+}
+
+// requeueNodeEvents queues the events taken by takeNodeEvents again, before
+// those emitted since, after they failed to be sent.
+func (c *Client) requeueNodeEvents(events []*models.NodeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	c.nodeEventsLock.Lock()
+	defer c.nodeEventsLock.Unlock()
+	c.pendingNodeEvents = append(events, c.pendingNodeEvents...)
+	if len(c.pendingNodeEvents) > maxNodeEvents {
+		c.pendingNodeEvents = c.pendingNodeEvents[len(c.pendingNodeEvents)-maxNodeEvents:]
+	}
+}
+
+// NodeEvents returns the last events of the node, oldest first.
+func (c *Client) NodeEvents() []*models.NodeEvent {
+	c.nodeEventsLock.Lock()
+	defer c.nodeEventsLock.Unlock()
+	return append([]*models.NodeEvent(nil), c.nodeEvents...)
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+// maxOpenFiles is unknown on this platform.
+func maxOpenFiles() (uint64, bool) {
+	return 0, false
+}
+
+// openFiles is unknown on this platform.
+func openFiles() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/config"
+	ulog "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func Test_fdThreshold(t *testing.T) {
+	if got, want := fdThreshold(0), fdThreshold(defaultExpectedTasks); got != want {
+		t.Errorf("fdThreshold(0) = %v, want %v", got, want)
+	}
+	// The default limit of 1024 is too low for the default tasks.
+	if got := fdThreshold(0); got <= 1024 {
+		t.Errorf("fdThreshold(0) = %v, want above 1024", got)
+	}
+	if got, want := fdThreshold(100), uint64(fdBaseline+100*fdPerTask); got != want {
+		t.Errorf("fdThreshold(100) = %v, want %v", got, want)
+	}
+}
+
+func TestClient_checkOpenFilesLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		expectedTasks int
+		limit         uint64
+		wantErr       bool
+	}{
+		{"enough", config.OpenFilesPolicyFail, 0, 65536, false},
+		{"unknown", config.OpenFilesPolicyFail, 0, 0, false},
+		{"few tasks", config.OpenFilesPolicyFail, 4, 1024, false},
+		{"warn", config.OpenFilesPolicyWarn, 0, 1024, false},
+		{"default", "", 0, 1024, false},
+		{"fail", config.OpenFilesPolicyFail, 0, 1024, true},
+		{"bad policy", "ignore", 0, 65536, true},
+	}
+	for _, tt := range tests {
+		c := &Client{
+			config: &config.ClientConfig{ExpectedTasks: tt.expectedTasks, OpenFilesPolicy: tt.policy},
+			logger: ulog.New(os.Stderr, ulog.ErrorLevel),
+		}
+		if err := c.checkOpenFilesLimit(tt.limit); (err != nil) != tt.wantErr {
+			t.Errorf("%v: checkOpenFilesLimit() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClient_checkOpenFiles(t *testing.T) {
+	c := &Client{
+		logger: ulog.New(os.Stderr, ulog.ErrorLevel),
+		clock:  newFakeClock(),
+	}
+
+	if c.checkOpenFiles(900, 1000, false) {
+		t.Errorf("90%% is not past the ratio")
+	}
+	if c.checkOpenFiles(950, 0, false) {
+		t.Errorf("past the ratio of an unknown limit")
+	}
+	if len(c.NodeEvents()) != 0 {
+		t.Fatalf("events = %v, want none", c.NodeEvents())
+	}
+
+	// Once past the ratio, then again after it was not.
+	warned := false
+	for _, n := range []int{950, 990, 800, 960} {
+		warned = c.checkOpenFiles(n, 1000, warned)
+	}
+	events := c.NodeEvents()
+	if len(events) != 2 {
+		t.Fatalf("events = %v, want 2", len(events))
+	}
+	if e := events[1]; e.Type != models.NodeEventOpenFiles || e.Details["open_files"] != "960" ||
+		e.Details["max_open_files"] != "1000" {
+		t.Errorf("event = %+v", e)
+	}
+
+	for i := 0; i < maxNodeEvents; i++ {
+		c.checkOpenFiles(950, 1000, false)
+	}
+	if got := len(c.NodeEvents()); got != maxNodeEvents {
+		t.Errorf("events = %v, want %v", got, maxNodeEvents)
+	}
+}
+
+func Test_openFiles(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	limit, ok := maxOpenFiles()
+	if !ok || limit == 0 {
+		t.Fatalf("maxOpenFiles() = %v, %v", limit, ok)
+	}
+	before, ok := openFiles()
+	if !ok {
+		t.Fatalf("openFiles() is unknown")
+	}
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if after, _ := openFiles(); after != before+1 {
+		t.Errorf("openFiles() = %v after opening a file, want %v", after, before+1)
+	}
+}
+
+func TestClient_takeNodeEvents(t *testing.T) {
+	c := &Client{clock: newFakeClock()}
+	c.emitNodeEvent(models.NodeEventOpenFiles, "first", nil)
+	c.emitNodeEvent(models.NodeEventClockJump, "second", nil)
+
+	sent := c.takeNodeEvents()
+	if len(sent) != 2 || len(c.takeNodeEvents()) != 0 {
+		t.Fatalf("taken = %v, want the 2 events once", len(sent))
+	}
+
+	// failed to be sent, queued again before the newer events
+	c.emitNodeEvent(models.NodeEventOpenFiles, "third", nil)
+	c.requeueNodeEvents(sent)
+	var got []string
+	for _, e := range c.takeNodeEvents() {
+		got = append(got, e.Message)
+	}
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("taken = %v, want %v", got, want)
+	}
+	if len(c.NodeEvents()) != 3 {
+		t.Errorf("events = %v, want all 3 kept locally", len(c.NodeEvents()))
+	}
+}
@@ -0,0 +1,43 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxOpenFiles returns the soft limit of open files of the process.
+func maxOpenFiles() (uint64, bool) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, false
+	}
+	return uint64(rlimit.Cur), true
+}
+
+// openFiles returns the number of open files of the process, listed in
+// /proc/self/fd on Linux and /dev/fd on the BSDs.
+func openFiles() (int, bool) {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		f, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		// without the fd of the listing itself
+		return len(names) - 1, true
+	}
+	return 0, false
+}
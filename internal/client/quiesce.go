@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/gtid"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// quiescePollInterval is the interval of the checks of the position applied by the
+	// target during a Quiesce.
+	quiescePollInterval = time.Second
+)
+
+// quiescedSource is the source of a job during a Quiesce, see mysqlDriver.SourceQuiescer.
+type quiescedSource interface {
+	SetReadOnly() error
+	RestoreWritable() error
+	ExecutedGtid() (string, error)
+	Close() error
+}
+
+// openQuiescedSource connects to the source of a Quiesce. Replaced by the tests.
+var openQuiescedSource = func(connConfig *umconf.ConnectionConfig) (quiescedSource, error) {
+	return mysqlDriver.OpenSourceQuiescer(connConfig)
+}
+
+// Quiesce sets the source of the job read-only if sourceReadOnly, waits within ctx for
+// the Dest task to apply the GTID set executed on the source, and saves the checkpoint
+// of the task. It returns the GTID set. On error, the source is made writable again.
+func (r *Allocator) Quiesce(ctx context.Context, sourceReadOnly bool) (final string, err error) {
+	var dest *Worker
+	for _, tr := range r.getWorkers() {
+		if tr.task.Type == models.TaskTypeDest {
+			dest = tr
+		}
+	}
+	if dest == nil {
+		return "", fmt.Errorf("allocation %q has no %v task", r.alloc.ID, models.TaskTypeDest)
+	}
+	connConfig, err := sourceConnectionConfig(r.Alloc().Job)
+	if err != nil {
+		return "", err
+	}
+
+	source, err := openQuiescedSource(connConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to the source: %v", err)
+	}
+	defer source.Close()
+	if sourceReadOnly {
+		defer func() {
+			if err == nil {
+				return
+			}
+			if restoreErr := source.RestoreWritable(); restoreErr != nil {
+				r.logger.Errorf("agent: Failed to make the source of alloc %q writable again: %v", r.alloc.ID, restoreErr)
+			}
+		}()
+		if err := source.SetReadOnly(); err != nil {
+			return "", fmt.Errorf("failed to set the source read-only: %v", err)
+		}
+	}
+
+	if final, err = source.ExecutedGtid(); err != nil {
+		return "", fmt.Errorf("failed to read the position of the source: %v", err)
+	}
+	if err := waitApplied(ctx, dest, final); err != nil {
+		return "", err
+	}
+	if err := dest.SaveState(); err != nil {
+		return "", err
+	}
+	if err := r.SaveState(); err != nil {
+		return "", err
+	}
+	dest.updater(dest.task.Type, "", models.NewTaskEvent(models.TaskQuiesced).
+		SetDriverMessage(fmt.Sprintf("applied the source up to gtid %v, source read-only: %v", final, sourceReadOnly)))
+	return final, nil
+}
+
+// sourceConnectionConfig returns the connection to the source of the MySQL Src task of job,
+// with its secrets resolved.
+func sourceConnectionConfig(job *models.Job) (*umconf.ConnectionConfig, error) {
+	for _, task := range job.Tasks {
+		if task.Type != models.TaskTypeSrc {
+			continue
+		}
+		if task.Driver != models.TaskDriverMySQL {
+			return nil, fmt.Errorf("the %v task of job %q has the driver %v, not %v",
+				task.Type, job.ID, task.Driver, models.TaskDriverMySQL)
+		}
+		var driverConfig config.MySQLDriverConfig
+		if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+			return nil, err
+		}
+		if driverConfig.ConnectionConfig == nil {
+			return nil, fmt.Errorf("the %v task of job %q has no ConnectionConfig", task.Type, job.ID)
+		}
+		if err := driverConfig.ConnectionConfig.ResolveSecrets(); err != nil {
+			return nil, err
+		}
+		return driverConfig.ConnectionConfig, nil
+	}
+	return nil, fmt.Errorf("job %q has no %v task", job.ID, models.TaskTypeSrc)
+}
+
+// waitApplied waits for the ExecutedGtidSet of the task to cover the GTID set final.
+func waitApplied(ctx context.Context, tr *Worker, final string) error {
+	want, err := gtid.Parse(final)
+	if err != nil {
+		return err
+	}
+	missing := want
+	ticker := time.NewTicker(quiescePollInterval)
+	defer ticker.Stop()
+	for {
+		if stats := tr.LatestTaskStats(); stats != nil && stats.CurrentCoordinates != nil {
+			if applied, err := gtid.Parse(stats.CurrentCoordinates.ExecutedGtidSet); err == nil {
+				missing = want.Subtract(applied)
+				if len(missing) == 0 {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the target has not applied %v of the source: %v", missing, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
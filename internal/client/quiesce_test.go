@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+type fakeQuiescedSource struct {
+	executed string
+	readOnly bool
+	closed   bool
+}
+
+func (s *fakeQuiescedSource) SetReadOnly() error {
+	s.readOnly = true
+	return nil
+}
+func (s *fakeQuiescedSource) RestoreWritable() error {
+	s.readOnly = false
+	return nil
+}
+func (s *fakeQuiescedSource) ExecutedGtid() (string, error) { return s.executed, nil }
+func (s *fakeQuiescedSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAllocator_Quiesce(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "dtle-quiesce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+
+	source := &fakeQuiescedSource{executed: "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-10"}
+	var connConfig *umconf.ConnectionConfig
+	defer func(open func(*umconf.ConnectionConfig) (quiescedSource, error)) { openQuiescedSource = open }(openQuiescedSource)
+	openQuiescedSource = func(c *umconf.ConnectionConfig) (quiescedSource, error) {
+		connConfig = c
+		return source, nil
+	}
+
+	logger := log.New(os.Stderr, log.ErrorLevel)
+	alloc := &models.Allocation{ID: "alloc1", JobID: "job1", Job: &models.Job{
+		ID: "job1",
+		Tasks: []*models.Task{{
+			Type:   models.TaskTypeSrc,
+			Driver: models.TaskDriverMySQL,
+			Config: map[string]interface{}{
+				"ConnectionConfig": map[string]interface{}{"Host": "10.0.0.1", "Port": 3306},
+			},
+		}},
+	}}
+	r := NewAllocator(logger, &config.ClientConfig{StateDir: stateDir}, func(*models.Allocation) {}, alloc,
+		make(chan *models.TaskUpdate, 1))
+	var events []*models.TaskEvent
+	dest := &Worker{
+		logger: logger,
+		config: r.config,
+		alloc:  alloc,
+		updater: func(taskName, state string, event *models.TaskEvent) {
+			events = append(events, event)
+		},
+		task:      &models.Task{Type: models.TaskTypeDest, Config: map[string]interface{}{}, ConfigLock: &sync.RWMutex{}},
+		running:   true,
+		taskStats: &models.TaskStatistics{CurrentCoordinates: &models.CurrentCoordinates{}},
+	}
+	r.tasks = map[string]*Worker{models.TaskTypeDest: dest}
+	apply := func(executed string) {
+		dest.taskStatsLock.Lock()
+		dest.taskStats = &models.TaskStatistics{CurrentCoordinates: &models.CurrentCoordinates{ExecutedGtidSet: executed}}
+		dest.taskStatsLock.Unlock()
+	}
+
+	// the target falls behind: the source is made writable again
+	apply("3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-8")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := r.Quiesce(ctx, true); err == nil {
+		t.Errorf("Quiesce() of a target behind succeeded")
+	}
+	if source.readOnly || !source.closed {
+		t.Errorf("the source is left read-only %v, closed %v", source.readOnly, source.closed)
+	}
+	if connConfig == nil || connConfig.Host != "10.0.0.1" {
+		t.Errorf("connected to the source %+v", connConfig)
+	}
+
+	// the target catches up: the source stays read-only
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		apply("3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-10,4e11fa47-71ca-11e1-9e33-c80aa9429562:1")
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	final, err := r.Quiesce(ctx, true)
+	if err != nil || final != source.executed {
+		t.Fatalf("Quiesce() = %v, %v, want %v", final, err, source.executed)
+	}
+	if !source.readOnly {
+		t.Errorf("the source is writable after the cutover")
+	}
+	if len(events) != 1 || events[0].Type != models.TaskQuiesced {
+		t.Errorf("events = %v, want a %v event", events, models.TaskQuiesced)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/armon/go-metrics"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// reconcileIntv is how often the status of the allocations is reconciled.
+	reconcileIntv = 30 * time.Second
+
+	// defaultAllocReconcileThreshold is ClientConfig.AllocReconcileThreshold
+	// if unset. It is above defaultAllocShutdownTimeout, so that the tasks
+	// stopping in time are not taken as a mismatch.
+	defaultAllocReconcileThreshold = 2 * time.Minute
+)
+
+// Actions of the reconciler on a stale mismatch, see AllocReconcile.Action.
+const (
+	// ReconcileUpdate sends the allocation pulled from the servers to its
+	// runner again, as updateAlloc.
+	ReconcileUpdate = "update"
+	// ReconcileRemove destroys the runner of the allocation, as removeAlloc.
+	// The allocation is added again if the servers still send it.
+	ReconcileRemove = "remove"
+)
+
+// AllocReconcile compares the status the servers want for a local allocation
+// with the status of the client.
+type AllocReconcile struct {
+	AllocID string
+	JobID   string
+	// DesiredStatus is that of the allocation last pulled from the servers,
+	// and RunnerDesiredStatus that applied by the runner of the allocation.
+	DesiredStatus       string
+	RunnerDesiredStatus string
+	ClientStatus        string
+	// TaskStates is the state of each task
+	TaskStates map[string]string
+	// RunnerExited is whether the runner of the allocation has returned
+	RunnerExited bool
+
+	Consistent bool
+	// Reason is why the statuses are not consistent
+	Reason string
+	// Action is what the reconciler does on a stale mismatch, "" for none
+	Action string
+	// MismatchSince is when the mismatch was first found, zero if Consistent
+	MismatchSince time.Time
+	// Stale marks a mismatch older than the threshold
+	Stale bool
+}
+
+// ReconcileReport is the status of the local allocations, see
+// Client.ReconcileReport.
+type ReconcileReport struct {
+	Time      time.Time
+	Threshold string
+	// Actions counts the actions taken by the reconciler since the start
+	Actions map[string]int64
+	// Allocs are sorted by AllocID
+	Allocs []*AllocReconcile
+}
+
+// checkAlloc returns why the runner of an allocation does not match the
+// allocation pulled from the servers, which may be nil if not known, and the
+// action re-driving it, or "" if they match. runner is as Allocator.Alloc.
+func checkAlloc(pulled, runner *models.Allocation, exited bool) (reason, action string) {
+	if pulled != nil && pulled.AllocModifyIndex > runner.AllocModifyIndex &&
+		pulled.DesiredStatus != runner.DesiredStatus && pulled.ClientTerminalStatus() {
+		// The update was dropped, or the runner failed to take it.
+		return fmt.Sprintf("the runner has not applied the desired status %v", pulled.DesiredStatus),
+			ReconcileUpdate
+	}
+
+	active := runner.ClientStatus == models.AllocClientStatusPending ||
+		runner.ClientStatus == models.AllocClientStatusRunning
+	switch runner.DesiredStatus {
+	case models.AllocDesiredStatusStop, models.AllocDesiredStatusEvict, models.AllocDesiredStatusPause:
+		if active {
+			return fmt.Sprintf("the desired status is %v but the allocation is %v",
+				runner.DesiredStatus, runner.ClientStatus), ReconcileRemove
+		}
+	default:
+		if active && exited {
+			return fmt.Sprintf("the runner has exited but the allocation is %v", runner.ClientStatus),
+				ReconcileRemove
+		}
+	}
+	return "", ""
+}
+
+// recordPulledAllocs keeps the allocations pulled from the servers for the
+// reconciler, and forgets those no longer on the node.
+func (c *Client) recordPulledAllocs(update *allocUpdates) {
+	c.reconcileLock.Lock()
+	defer c.reconcileLock.Unlock()
+	if c.pulledAllocs == nil {
+		c.pulledAllocs = make(map[string]*models.Allocation)
+	}
+	for id, alloc := range update.pulled {
+		c.pulledAllocs[id] = alloc
+	}
+	for id := range c.pulledAllocs {
+		_, pulled := update.pulled[id]
+		_, filtered := update.filtered[id]
+		if !pulled && !filtered {
+			delete(c.pulledAllocs, id)
+		}
+	}
+}
+
+// ReconcileReport compares the status the servers want for each local
+// allocation with the status of the client.
+func (c *Client) ReconcileReport() *ReconcileReport {
+	report, _ := c.reconcileReport(c.clk().Now())
+	return report
+}
+
+// reconcileReport returns the report at now, with the allocations pulled from
+// the servers for those with a stale mismatch, by ID. The start of the
+// mismatches is kept for the next reports.
+func (c *Client) reconcileReport(now time.Time) (*ReconcileReport, map[string]*models.Allocation) {
+	threshold := c.config.AllocReconcileThreshold
+	if threshold <= 0 {
+		threshold = defaultAllocReconcileThreshold
+	}
+	runners := c.getAllocRunners()
+
+	c.reconcileLock.Lock()
+	defer c.reconcileLock.Unlock()
+	if c.allocMismatches == nil {
+		c.allocMismatches = make(map[string]time.Time)
+	}
+	report := &ReconcileReport{
+		Time:      now,
+		Threshold: threshold.String(),
+		Actions:   make(map[string]int64, len(c.reconcileActions)),
+	}
+	for action, n := range c.reconcileActions {
+		report.Actions[action] = n
+	}
+	stale := make(map[string]*models.Allocation)
+	for id, ar := range runners {
+		alloc := ar.Alloc()
+		exited := false
+		select {
+		case <-ar.WaitCh():
+			exited = true
+		default:
+		}
+		pulled := c.pulledAllocs[id]
+		r := &AllocReconcile{
+			AllocID:             id,
+			JobID:               alloc.JobID,
+			DesiredStatus:       alloc.DesiredStatus,
+			RunnerDesiredStatus: alloc.DesiredStatus,
+			ClientStatus:        alloc.ClientStatus,
+			TaskStates:          make(map[string]string, len(alloc.TaskStates)),
+			RunnerExited:        exited,
+		}
+		if pulled != nil {
+			r.DesiredStatus = pulled.DesiredStatus
+		}
+		for name, state := range alloc.TaskStates {
+			r.TaskStates[name] = state.State
+		}
+		r.Reason, r.Action = checkAlloc(pulled, alloc, exited)
+		r.Consistent = r.Reason == ""
+		if r.Consistent {
+			delete(c.allocMismatches, id)
+		} else {
+			since, ok := c.allocMismatches[id]
+			if !ok {
+				since = now
+				c.allocMismatches[id] = since
+			}
+			r.MismatchSince = since
+			r.Stale = now.Sub(since) >= threshold
+			if r.Stale {
+				if pulled == nil {
+					pulled = alloc
+				}
+				stale[id] = pulled
+			}
+		}
+		report.Allocs = append(report.Allocs, r)
+	}
+	for id := range c.allocMismatches {
+		if _, ok := runners[id]; !ok {
+			delete(c.allocMismatches, id)
+		}
+	}
+	sort.Slice(report.Allocs, func(i, j int) bool {
+		return report.Allocs[i].AllocID < report.Allocs[j].AllocID
+	})
+	return report, stale
+}
+
+// reconcileAllocs is a long lived goroutine re-driving the allocations whose
+// status has not matched that wanted by the servers for the threshold, e.g.
+// after a dropped update or a destroy which failed silently, rather than
+// waiting for the next change pushed by the servers.
+func (c *Client) reconcileAllocs() {
+	clk := c.clk()
+	for {
+		select {
+		case <-clk.After(reconcileIntv):
+		case <-c.shutdownCh:
+			return
+		}
+		c.reconcile(clk.Now())
+	}
+}
+
+// reconcile takes the actions on the stale mismatches at now. Each action is
+// logged and counted, in ReconcileReport and the client.reconcile_actions
+// metric, and the mismatch is given the threshold again before the next one.
+func (c *Client) reconcile(now time.Time) {
+	report, stale := c.reconcileReport(now)
+	for _, r := range report.Allocs {
+		pulled, ok := stale[r.AllocID]
+		if !ok || r.Action == "" {
+			continue
+		}
+		c.logger.Warnf("agent: Reconciling alloc %q, mismatched since %v: %v. Action: %v",
+			r.AllocID, r.MismatchSince, r.Reason, r.Action)
+		var err error
+		switch r.Action {
+		case ReconcileUpdate:
+			err = c.updateAlloc(pulled, pulled)
+		case ReconcileRemove:
+			err = c.removeAlloc(pulled)
+		}
+		if err != nil {
+			c.logger.Errorf("agent: Failed to reconcile alloc %q: %v", r.AllocID, err)
+		}
+		metrics.IncrCounterWithLabels([]string{"client", "reconcile_actions"}, 1,
+			[]metrics.Label{{Name: "action", Value: r.Action}})
+
+		c.reconcileLock.Lock()
+		if c.reconcileActions == nil {
+			c.reconcileActions = make(map[string]int64)
+		}
+		c.reconcileActions[r.Action]++
+		if _, ok := c.allocMismatches[r.AllocID]; ok {
+			c.allocMismatches[r.AllocID] = now
+		}
+		c.reconcileLock.Unlock()
+	}
+}
+
+// reconcileActionsTotal returns how many actions the reconciler has taken.
+func (c *Client) reconcileActionsTotal() int64 {
+	c.reconcileLock.Lock()
+	defer c.reconcileLock.Unlock()
+	var total int64
+	for _, n := range c.reconcileActions {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func Test_checkAlloc(t *testing.T) {
+	alloc := func(index uint64, desired, client string) *models.Allocation {
+		return &models.Allocation{AllocModifyIndex: index, DesiredStatus: desired, ClientStatus: client}
+	}
+	run, stop := models.AllocDesiredStatusRun, models.AllocDesiredStatusStop
+	running, complete := models.AllocClientStatusRunning, models.AllocClientStatusComplete
+	tests := []struct {
+		name       string
+		pulled     *models.Allocation
+		runner     *models.Allocation
+		exited     bool
+		wantAction string
+	}{
+		{"running", alloc(2, run, ""), alloc(2, run, running), false, ""},
+		{"not pulled", nil, alloc(2, run, running), false, ""},
+		{"stopped", alloc(3, stop, ""), alloc(3, stop, complete), true, ""},
+		{"completed by itself", alloc(2, run, ""), alloc(2, run, complete), true, ""},
+		{"stop not applied", alloc(3, stop, ""), alloc(2, run, running), false, ReconcileUpdate},
+		{"tasks running after stop", alloc(3, stop, ""), alloc(3, stop, running), false, ReconcileRemove},
+		{"runner exited", alloc(2, run, ""), alloc(2, run, running), true, ReconcileRemove},
+	}
+	for _, tt := range tests {
+		reason, action := checkAlloc(tt.pulled, tt.runner, tt.exited)
+		if action != tt.wantAction || (reason == "") != (tt.wantAction == "") {
+			t.Errorf("%v: checkAlloc() = %q, %q, want action %q", tt.name, reason, action, tt.wantAction)
+		}
+	}
+}
+
+func TestClient_reconcile(t *testing.T) {
+	fc := newFakeClock()
+	c := newLoopTestClient(t, newFakeServers(), fc)
+	defer stopLoopTestClient(c)
+	c.config.AllocReconcileThreshold = time.Minute
+
+	newRunner := func(id string, index uint64) *Allocator {
+		ar := NewAllocator(c.logger, c.config, nil,
+			&models.Allocation{ID: id, AllocModifyIndex: index, DesiredStatus: models.AllocDesiredStatusRun}, nil)
+		ar.taskStates["Src"] = &models.TaskState{State: models.TaskStateRunning}
+		c.allocs[id] = ar
+		return ar
+	}
+	ok := newRunner("a1", 2)
+	dropped := newRunner("a2", 2)
+	// the update stopping a2 was dropped
+	for i := 0; i < cap(dropped.updateCh); i++ {
+		dropped.updateCh <- dropped.alloc
+	}
+	c.recordPulledAllocs(&allocUpdates{
+		pulled: map[string]*models.Allocation{
+			"a2": {ID: "a2", AllocModifyIndex: 3, DesiredStatus: models.AllocDesiredStatusStop},
+		},
+		filtered: map[string]struct{}{"a1": {}},
+	})
+
+	start := fc.Now()
+	report := c.ReconcileReport()
+	if len(report.Allocs) != 2 {
+		t.Fatalf("ReconcileReport() = %+v, want 2 allocs", report.Allocs)
+	}
+	if r := report.Allocs[0]; r.AllocID != "a1" || !r.Consistent || r.TaskStates["Src"] != models.TaskStateRunning {
+		t.Errorf("a1 = %+v, want consistent", r)
+	}
+	r := report.Allocs[1]
+	if r.Consistent || r.DesiredStatus != models.AllocDesiredStatusStop ||
+		r.RunnerDesiredStatus != models.AllocDesiredStatusRun || r.Action != ReconcileUpdate || r.Stale {
+		t.Errorf("a2 = %+v, want a fresh mismatch", r)
+	}
+
+	// not acted on before the threshold
+	c.reconcile(start.Add(30 * time.Second))
+	if n := c.reconcileActionsTotal(); n != 0 {
+		t.Errorf("actions before the threshold = %v, want 0", n)
+	}
+	<-dropped.updateCh
+	c.reconcile(start.Add(time.Minute))
+	report = c.ReconcileReport()
+	if report.Actions[ReconcileUpdate] != 1 {
+		t.Errorf("Actions = %v, want an update", report.Actions)
+	}
+	if !report.Allocs[1].MismatchSince.Equal(start.Add(time.Minute)) {
+		t.Errorf("MismatchSince after the action = %v, want the action time", report.Allocs[1].MismatchSince)
+	}
+	var sent *models.Allocation
+	for len(dropped.updateCh) > 0 {
+		sent = <-dropped.updateCh
+	}
+	if sent == nil || sent.DesiredStatus != models.AllocDesiredStatusStop {
+		t.Errorf("update sent = %+v, want the stop", sent)
+	}
+
+	// the runner of a1 exited with its task running
+	close(ok.waitCh)
+	c.reconcile(start.Add(2 * time.Minute))
+	c.reconcile(start.Add(3 * time.Minute))
+	if _, found := c.allocs["a1"]; found {
+		t.Errorf("a1 is not removed")
+	}
+	if !ok.destroy {
+		t.Errorf("a1 is not destroyed")
+	}
+}
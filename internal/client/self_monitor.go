@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// selfMonitorIntv is how often the runtime of the client is sampled.
+	selfMonitorIntv = time.Minute
+
+	// defaultSelfMonitorWindow is ClientConfig.SelfMonitorWindow if unset.
+	defaultSelfMonitorWindow = 10 * time.Minute
+
+	// heapProfileIntv is the least time between two heap profiles.
+	heapProfileIntv = time.Hour
+
+	// goroutineSummaryTop is how many groups of goroutines the warning of a
+	// growth of the goroutines lists.
+	goroutineSummaryTop = 5
+
+	// debugDir is where the heap profiles are written, in the state dir.
+	debugDir = "debug"
+)
+
+// runtimeSample is the runtime of the client at a time.
+type runtimeSample struct {
+	Goroutines int
+	HeapInuse  uint64
+	NumGC      uint32
+	// total and last GC stop-the-world pauses
+	PauseTotal time.Duration
+	LastPause  time.Duration
+}
+
+func readRuntimeSample() runtimeSample {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := runtimeSample{
+		Goroutines: runtime.NumGoroutine(),
+		HeapInuse:  m.HeapInuse,
+		NumGC:      m.NumGC,
+		PauseTotal: time.Duration(m.PauseTotalNs),
+	}
+	if m.NumGC > 0 {
+		s.LastPause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+	}
+	return s
+}
+
+// selfMonitor keeps the samples of the runtime of the client over the window.
+type selfMonitor struct {
+	lock sync.Mutex
+	// the samples of the window, oldest first
+	samples []runtimeSample
+	// growths warned of, and heap profiles written
+	warnings    int64
+	profiles    int64
+	lastProfile time.Time
+}
+
+// grows tells whether the values grow at every sample and end above threshold.
+func grows(values []uint64, threshold uint64) bool {
+	if len(values) < 2 || values[len(values)-1] <= threshold {
+		return false
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// watchRuntime is a long lived goroutine sampling the goroutines, the heap and
+// the GC pauses of the client, for Stats and the metrics, and warning of their
+// growth.
+func (c *Client) watchRuntime() {
+	clk := c.clk()
+	for {
+		select {
+		case <-clk.After(selfMonitorIntv):
+		case <-c.shutdownCh:
+			return
+		}
+		c.checkRuntime(readRuntimeSample(), clk.Now())
+	}
+}
+
+// checkRuntime records a sample at now. It warns, with a node event, when the
+// goroutines or the heap in use has grown at every sample of a full window to
+// above SelfMonitorGoroutines or SelfMonitorHeapMB, and starts a new window. It
+// writes a heap profile when the heap in use is above SelfMonitorHeapProfileMB.
+func (c *Client) checkRuntime(s runtimeSample, now time.Time) {
+	nodeID := c.Node().ID
+	metrics.SetGauge([]string{"client", "runtime", "goroutines", nodeID}, float32(s.Goroutines))
+	metrics.SetGauge([]string{"client", "runtime", "heap_inuse", nodeID}, float32(s.HeapInuse))
+	metrics.SetGauge([]string{"client", "runtime", "gc_last_pause_ns", nodeID}, float32(s.LastPause.Nanoseconds()))
+
+	window := c.config.SelfMonitorWindow
+	if window <= 0 {
+		window = defaultSelfMonitorWindow
+	}
+	size := int(window/selfMonitorIntv) + 1
+	if size < 2 {
+		size = 2
+	}
+
+	m := &c.selfMonitor
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.samples = append(m.samples, s)
+	if len(m.samples) > size {
+		m.samples = m.samples[len(m.samples)-size:]
+	}
+
+	if len(m.samples) == size {
+		goroutines := make([]uint64, size)
+		heap := make([]uint64, size)
+		for i, sample := range m.samples {
+			goroutines[i] = uint64(sample.Goroutines)
+			heap[i] = sample.HeapInuse
+		}
+		first := m.samples[0]
+		var growth []string
+		if limit := c.config.SelfMonitorGoroutines; limit > 0 && grows(goroutines, uint64(limit)) {
+			growth = append(growth, fmt.Sprintf("goroutines grew from %v to %v", first.Goroutines, s.Goroutines))
+		}
+		if limit := c.config.SelfMonitorHeapMB; limit > 0 && grows(heap, uint64(limit)<<20) {
+			growth = append(growth, fmt.Sprintf("heap in use grew from %vMB to %vMB", first.HeapInuse>>20, s.HeapInuse>>20))
+		}
+		if len(growth) > 0 {
+			m.warnings++
+			message := fmt.Sprintf("%v in %v", strings.Join(growth, ", "), window)
+			c.logger.Warnf("agent: The client may leak: %v. Goroutines: %v", message, goroutineSummary(goroutineSummaryTop))
+			c.emitNodeEvent(models.NodeEventGrowth, message, map[string]string{
+				"goroutines": strconv.Itoa(s.Goroutines),
+				"heap_inuse": strconv.FormatUint(s.HeapInuse, 10),
+			})
+			m.samples = m.samples[len(m.samples)-1:]
+		}
+	}
+
+	if limit := c.config.SelfMonitorHeapProfileMB; limit > 0 && s.HeapInuse > uint64(limit)<<20 &&
+		(m.lastProfile.IsZero() || now.Sub(m.lastProfile) >= heapProfileIntv) {
+		m.lastProfile = now
+		path, err := c.writeHeapProfile(now)
+		if err != nil {
+			c.logger.Errorf("agent: Failed to write the heap profile: %v", err)
+		} else {
+			m.profiles++
+			c.logger.Warnf("agent: Heap in use is %vMB, above %vMB. Wrote the heap profile %v",
+				s.HeapInuse>>20, limit, path)
+		}
+	}
+}
+
+// writeHeapProfile writes a heap profile to the debug dir of the state dir.
+func (c *Client) writeHeapProfile(now time.Time) (string, error) {
+	if c.config.StateDir == "" {
+		return "", fmt.Errorf("no state dir")
+	}
+	dir := filepath.Join(c.config.StateDir, debugDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("heap-%v.pprof", now.UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// goroutineSummary lists the top groups of goroutines with the same stack,
+// by count, e.g. "120 in nats.(*Conn).readLoop, 8 in ...".
+func goroutineSummary(top int) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return err.Error()
+	}
+	return summarizeGoroutines(buf.String(), top)
+}
+
+// summarizeGoroutines summarizes a goroutine profile of debug level 1. A group
+// is named by its first frame out of the runtime.
+func summarizeGoroutines(profile string, top int) string {
+	type group struct {
+		count int
+		fn    string
+	}
+	var groups []group
+	scanner := bufio.NewScanner(strings.NewReader(profile))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, " @ "); i > 0 {
+			if n, err := strconv.Atoi(line[:i]); err == nil {
+				groups = append(groups, group{count: n})
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(groups) == 0 || len(fields) < 3 || fields[0] != "#" {
+			continue
+		}
+		g := &groups[len(groups)-1]
+		fn := fields[2]
+		if i := strings.LastIndex(fn, "+0x"); i > 0 {
+			fn = fn[:i]
+		}
+		if g.fn == "" || strings.HasPrefix(g.fn, "runtime.") {
+			g.fn = fn
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+	if len(groups) > top {
+		groups = groups[:top]
+	}
+	parts := make([]string, 0, len(groups))
+	for _, g := range groups {
+		parts = append(parts, fmt.Sprintf("%v in %v", g.count, g.fn))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// selfMonitorStats is the last sample of the runtime, for Stats.
+func (c *Client) selfMonitorStats() map[string]string {
+	m := &c.selfMonitor
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := map[string]string{
+		"warnings":      strconv.FormatInt(m.warnings, 10),
+		"heap_profiles": strconv.FormatInt(m.profiles, 10),
+	}
+	if len(m.samples) == 0 {
+		return stats
+	}
+	s := m.samples[len(m.samples)-1]
+	stats["goroutines"] = strconv.Itoa(s.Goroutines)
+	stats["heap_inuse"] = strconv.FormatUint(s.HeapInuse, 10)
+	stats["num_gc"] = strconv.FormatUint(uint64(s.NumGC), 10)
+	stats["gc_pause_total"] = s.PauseTotal.String()
+	stats["gc_last_pause"] = s.LastPause.String()
+	return stats
+}
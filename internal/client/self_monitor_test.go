@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func Test_summarizeGoroutines(t *testing.T) {
+	profile := `goroutine profile: total 130
+
+120 @ 0x43a1c5 0x44a3e1 0x6d2c1f
+#	0x6d2c1e	github.com/nats-io/go-nats.(*Conn).readLoop+0x1ae	/go/src/nats.go:1420
+
+8 @ 0x43a1c5 0x4098ad 0x7a01b2
+#	0x43a1c4	runtime.gopark+0x104	/usr/local/go/src/runtime/proc.go:336
+#	0x7a01b1	github.com/actiontech/dtle/internal/client.(*Client).run+0x91	/go/src/client.go:900
+
+2 @ 0x43a1c5
+#	0x43a1c4	runtime.gopark+0x104	/usr/local/go/src/runtime/proc.go:336
+`
+	want := "120 in github.com/nats-io/go-nats.(*Conn).readLoop, 8 in github.com/actiontech/dtle/internal/client.(*Client).run"
+	if got := summarizeGoroutines(profile, 2); got != want {
+		t.Errorf("summarizeGoroutines() = %q, want %q", got, want)
+	}
+	if got := goroutineSummary(goroutineSummaryTop); got == "" {
+		t.Errorf("goroutineSummary() is empty")
+	}
+}
+
+func TestClient_checkRuntime(t *testing.T) {
+	fc := newFakeClock()
+	c := newLoopTestClient(t, newFakeServers(), fc)
+	defer stopLoopTestClient(c)
+	c.config.SelfMonitorWindow = 3 * time.Minute
+	c.config.SelfMonitorGoroutines = 100
+
+	now := fc.Now()
+	sample := func(goroutines int) {
+		c.checkRuntime(runtimeSample{Goroutines: goroutines, HeapInuse: 1 << 20}, now)
+		now = now.Add(selfMonitorIntv)
+	}
+	// grows, but not at every sample of the window
+	for _, n := range []int{90, 110, 105, 120, 130} {
+		sample(n)
+	}
+	if len(c.NodeEvents()) != 0 {
+		t.Fatalf("warned of a growth with a drop in the window: %+v", c.NodeEvents())
+	}
+	sample(140)
+	events := c.NodeEvents()
+	if len(events) != 1 || events[0].Type != models.NodeEventGrowth || events[0].Details["goroutines"] != "140" {
+		t.Fatalf("NodeEvents() = %+v, want a growth", events)
+	}
+	// a new window
+	sample(150)
+	if len(c.NodeEvents()) != 1 {
+		t.Errorf("warned again before a new window")
+	}
+	stats := c.selfMonitorStats()
+	if stats["goroutines"] != "150" || stats["warnings"] != "1" {
+		t.Errorf("selfMonitorStats() = %v", stats)
+	}
+
+	c.config.SelfMonitorHeapProfileMB = 1
+	c.checkRuntime(runtimeSample{Goroutines: 10, HeapInuse: 2 << 20}, now)
+	c.checkRuntime(runtimeSample{Goroutines: 10, HeapInuse: 2 << 20}, now.Add(time.Minute))
+	files, err := ioutil.ReadDir(filepath.Join(c.config.StateDir, debugDir))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("heap profiles = %v, %v, want 1 within an hour", files, err)
+	}
+	c.checkRuntime(runtimeSample{Goroutines: 10, HeapInuse: 2 << 20}, now.Add(heapProfileIntv))
+	if files, _ := ioutil.ReadDir(filepath.Join(c.config.StateDir, debugDir)); len(files) != 2 {
+		t.Errorf("heap profiles = %v, want 2 after an hour", len(files))
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+const (
+	// maxTaskOutputLine is the longest line of the output of a task forwarded to the
+	// client log. The rest of a longer line is dropped, so that a task writing without
+	// newlines cannot make the client buffer without bound.
+	maxTaskOutputLine = 16 * 1024
+
+	// taskOutputCut marks a line cut at maxTaskOutputLine in the client log.
+	taskOutputCut = " [cut]"
+)
+
+// parseTaskOutputLevel returns the level at which the output of the tasks is forwarded
+// to the client log, and whether it is, for ClientConfig.TaskOutputLogLevel.
+func parseTaskOutputLevel(level string) (log.Level, bool, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return 0, false, nil
+	case "debug":
+		return log.DebugLevel, true, nil
+	case "info":
+		return log.InfoLevel, true, nil
+	case "warn":
+		return log.WarnLevel, true, nil
+	case "error":
+		return log.ErrorLevel, true, nil
+	default:
+		return 0, false, fmt.Errorf("unknown task output log level %q. expect debug, info, warn or error", level)
+	}
+}
+
+// taskOutput is the stdout or the stderr of a task. It is appended to a file in the logs
+// dir of the task, if any, and forwarded line by line to the client log if forward, each
+// line prefixed with the allocation. It is safe for concurrent use.
+type taskOutput struct {
+	lock    sync.Mutex
+	file    *os.File
+	logger  *log.Logger
+	level   log.Level
+	forward bool
+	prefix  string
+
+	// the partial line, at most maxTaskOutputLine, and whether it was cut
+	line []byte
+	cut  bool
+}
+
+// newTaskOutput opens the output name ("stdout" or "stderr") of a task of an allocation.
+// The file is in logsDir, or not written if logsDir is "".
+func newTaskOutput(logsDir, allocID, task, name string, logger *log.Logger, level log.Level, forward bool) (*taskOutput, error) {
+	o := &taskOutput{
+		logger:  logger,
+		level:   level,
+		forward: forward,
+		prefix:  fmt.Sprintf("[alloc=%v] %v %v: ", allocID, task, name),
+	}
+	if logsDir != "" {
+		path := filepath.Join(logsDir, fmt.Sprintf("%v.%v", task, name))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		o.file = f
+	}
+	return o, nil
+}
+
+// Write appends p to the file, and forwards the complete lines.
+func (o *taskOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.file != nil {
+		if _, err := o.file.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	if !o.forward {
+		return len(p), nil
+	}
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if room := maxTaskOutputLine - len(o.line); len(chunk) > room {
+			o.line = append(o.line, chunk[:room]...)
+			o.cut = true
+		} else {
+			o.line = append(o.line, chunk...)
+		}
+		if i < 0 {
+			break
+		}
+		o.flush()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush forwards the partial line. It must be called with lock held.
+func (o *taskOutput) flush() {
+	if len(o.line) == 0 && !o.cut {
+		return
+	}
+	msg := o.prefix + strings.TrimSuffix(string(o.line), "\r")
+	if o.cut {
+		msg += taskOutputCut
+	}
+	switch o.level {
+	case log.DebugLevel:
+		o.logger.Debugf("%s", msg)
+	case log.WarnLevel:
+		o.logger.Warnf("%s", msg)
+	case log.ErrorLevel:
+		o.logger.Errorf("%s", msg)
+	default:
+		o.logger.Printf("%s", msg)
+	}
+	o.line = o.line[:0]
+	o.cut = false
+}
+
+// Close forwards the last partial line and closes the file.
+func (o *taskOutput) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.forward {
+		o.flush()
+	}
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// openOutput opens the stdout and the stderr of the task, once for all of its starts.
+func (r *Worker) openOutput() error {
+	if r.stdout != nil {
+		return nil
+	}
+	level, forward, err := parseTaskOutputLevel(r.config.TaskOutputLogLevel)
+	if err != nil {
+		return err
+	}
+	logsDir := ""
+	if r.taskDir != nil {
+		logsDir = r.taskDir.LogsDir
+	}
+	stdout, err := newTaskOutput(logsDir, r.alloc.ID, r.task.Type, "stdout", r.logger, level, forward)
+	if err != nil {
+		return err
+	}
+	stderr, err := newTaskOutput(logsDir, r.alloc.ID, r.task.Type, "stderr", r.logger, level, forward)
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+	r.stdout, r.stderr = stdout, stderr
+	return nil
+}
+
+// closeOutput closes the stdout and the stderr of the task, if opened.
+func (r *Worker) closeOutput() {
+	for _, o := range []*taskOutput{r.stdout, r.stderr} {
+		if o == nil {
+			continue
+		}
+		if err := o.Close(); err != nil {
+			r.logger.Warnf("agent: Failed to close the output of task %q for alloc %q: %v",
+				r.task.Type, r.alloc.ID, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+func TestTaskOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-task-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs bytes.Buffer
+	level, forward, err := parseTaskOutputLevel("WARN")
+	if err != nil || !forward || level != log.WarnLevel {
+		t.Fatalf("parseTaskOutputLevel() = %v, %v, %v", level, forward, err)
+	}
+	o, err := newTaskOutput(dir, "a1", "Src", "stderr", log.New(&logs, log.DebugLevel), level, forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", maxTaskOutputLine+100)
+	for _, s := range []string{"first li", "ne\r\nsecond\n", long + "\n", "partial"} {
+		if n, err := o.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write() = %v, %v", n, err)
+		}
+	}
+	if strings.Contains(logs.String(), "partial") {
+		t.Errorf("a partial line is forwarded before Close: %q", logs.String())
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := logs.String()
+	for _, want := range []string{
+		"[alloc=a1] Src stderr: first line \n",
+		"[alloc=a1] Src stderr: second \n",
+		"[alloc=a1] Src stderr: " + long[:maxTaskOutputLine] + taskOutputCut + " \n",
+		"[alloc=a1] Src stderr: partial \n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("client log does not contain %.60q", want)
+		}
+	}
+	if strings.Contains(got, long[:maxTaskOutputLine+1]) {
+		t.Errorf("a long line is forwarded uncut")
+	}
+
+	file, err := ioutil.ReadFile(filepath.Join(dir, "Src.stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first line\r\nsecond\n" + long + "\npartial"; string(file) != want {
+		t.Errorf("file has %v bytes, want %v uncut", len(file), len(want))
+	}
+
+	if _, _, err := parseTaskOutputLevel("verbose"); err == nil {
+		t.Errorf("parseTaskOutputLevel() of an unknown level succeeded")
+	}
+}
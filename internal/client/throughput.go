@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// throughputWindows are the windows of the rates of models.TaskThroughput, by name.
+var throughputWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+const (
+	// throughputSampleIntv is the least time between two samples of the throughput of a
+	// task. It bounds the samples kept for the longest window.
+	throughputSampleIntv = 5 * time.Second
+)
+
+// throughputSample is the cumulative events and bytes of a task at a time.
+type throughputSample struct {
+	at     time.Time
+	events int64
+	bytes  int64
+}
+
+// throughputRing keeps samples of the throughput of a task, taken on the stats collection, to
+// compute its rates over the throughputWindows.
+type throughputRing struct {
+	lock    sync.Mutex
+	samples []throughputSample // oldest first
+}
+
+// observe samples the throughput of ru at now, and sets its rates.
+func (r *throughputRing) observe(ru *models.TaskStatistics, now time.Time) {
+	t := ru.Throughput
+	if t == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cur := throughputSample{at: now, events: t.Events, bytes: t.Bytes}
+	if n := len(r.samples); n > 0 {
+		last := r.samples[n-1]
+		if cur.events < last.events || cur.bytes < last.bytes || now.Before(last.at) {
+			// the task was restarted, with its counters
+			r.samples = nil
+		}
+	}
+	if n := len(r.samples); n == 0 || now.Sub(r.samples[n-1].at) >= throughputSampleIntv {
+		r.samples = append(r.samples, cur)
+	}
+	// keep one sample at or before the start of the longest window
+	longest := throughputWindows[len(throughputWindows)-1].d
+	drop := 0
+	for drop+1 < len(r.samples) && !r.samples[drop+1].at.After(now.Add(-longest)) {
+		drop++
+	}
+	r.samples = r.samples[drop:]
+
+	t.Windows = make([]*models.ThroughputWindow, 0, len(throughputWindows))
+	for _, w := range throughputWindows {
+		window := &models.ThroughputWindow{Window: w.name}
+		base := r.windowBase(now.Add(-w.d))
+		if secs := now.Sub(base.at).Seconds(); secs > 0 {
+			window.EventsPerSec = float64(cur.events-base.events) / secs
+			window.BytesPerSec = float64(cur.bytes-base.bytes) / secs
+		}
+		t.Windows = append(t.Windows, window)
+	}
+}
+
+// windowBase returns the last sample at or before start, or the oldest one if the samples start
+// after it.
+func (r *throughputRing) windowBase(start time.Time) throughputSample {
+	base := r.samples[0]
+	for _, s := range r.samples[1:] {
+		if s.at.After(start) {
+			break
+		}
+		base = s
+	}
+	return base
+}
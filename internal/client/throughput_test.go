@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestThroughputRing_observe(t *testing.T) {
+	var r throughputRing
+	start := time.Unix(1525910400, 0)
+	observe := func(at time.Duration, events, bytes int64) map[string]*models.ThroughputWindow {
+		ru := &models.TaskStatistics{Throughput: &models.TaskThroughput{Events: events, Bytes: bytes}}
+		r.observe(ru, start.Add(at))
+		windows := make(map[string]*models.ThroughputWindow)
+		for _, w := range ru.Throughput.Windows {
+			windows[w.Window] = w
+		}
+		return windows
+	}
+
+	// a burst of 600k events in the first minute, then 10 events/sec, every second
+	var events int64
+	for s := 1; s <= 20*60; s++ {
+		if s <= 60 {
+			events += 10000
+		} else {
+			events += 10
+		}
+		observe(time.Duration(s)*time.Second, events, events*100)
+	}
+	if len(r.samples) > int(15*time.Minute/throughputSampleIntv)+2 {
+		t.Errorf("%v samples kept", len(r.samples))
+	}
+	windows := observe(20*time.Minute+time.Second, events+10, (events+10)*100)
+	for _, name := range []string{"1m", "5m", "15m"} {
+		w := windows[name]
+		if w == nil || w.EventsPerSec < 9.5 || w.EventsPerSec > 10.5 || w.BytesPerSec < 950 || w.BytesPerSec > 1050 {
+			t.Errorf("window %v = %+v, want the steady 10 events/sec", name, w)
+		}
+	}
+
+	// within the first minute of the task, the rates are since its start
+	r = throughputRing{}
+	observe(0, 0, 0)
+	windows = observe(30*time.Second, 300, 0)
+	if w := windows["15m"]; w.EventsPerSec != 10 {
+		t.Errorf("15m events/sec of a new task = %v, want 10", w.EventsPerSec)
+	}
+
+	// a restarted task starts over
+	windows = observe(40*time.Second, 50, 0)
+	if w := windows["1m"]; w.EventsPerSec != 0 || len(r.samples) != 1 {
+		t.Errorf("1m events/sec after a restart = %v with %v samples", w.EventsPerSec, len(r.samples))
+	}
+}
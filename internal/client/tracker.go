@@ -105,14 +105,16 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 		return models.TaskRestarting, 0
 	}
 
-	if r.waitRes != nil && !r.waitRes.ShouldRestart() {
-		return models.TaskNotRestarting, 0
-	}
-
+	// A finite task, e.g. a one-shot copy, exits successfully once done
 	if r.waitRes != nil && r.waitRes.Successful() {
+		r.reason = ""
 		return models.TaskTerminated, 0
 	}
 
+	if r.waitRes != nil && !r.waitRes.ShouldRestart() {
+		return models.TaskNotRestarting, 0
+	}
+
 	r.count++
 
 	// Check if we have entered a new interval.
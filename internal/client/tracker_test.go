@@ -7,6 +7,7 @@
 package client
 
 import (
+	"fmt"
 	"math/rand"
 	"reflect"
 	"sync"
@@ -213,7 +214,21 @@ func TestRestartTracker_GetState(t *testing.T) {
 		want   string
 		want1  time.Duration
 	}{
-		// TODO: Add test cases.
+		{
+			name:   "completed",
+			fields: fields{waitRes: models.NewWaitResult(0, nil)},
+			want:   models.TaskTerminated,
+		},
+		{
+			name:   "unrecoverable failure",
+			fields: fields{waitRes: models.NewWaitResult(2, fmt.Errorf("bad config"))},
+			want:   models.TaskNotRestarting,
+		},
+		{
+			name:   "restart triggered",
+			fields: fields{waitRes: models.NewWaitResult(0, nil), restartTriggered: true},
+			want:   models.TaskRestarting,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -12,7 +12,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/actiontech/dtle/internal/gtid"
 	"github.com/actiontech/dtle/internal/models"
 )
 
@@ -77,6 +80,12 @@ func diffAllocs(existing []*models.Allocation, allocs *allocUpdates) *diffResult
 
 // persistState is used to help with saving state
 func persistState(path string, data interface{}) error {
+	return persistStateRotated(path, data, 1)
+}
+
+// persistStateRotated is persistState keeping the last keep snapshots: the
+// previous ones are path.1, path.2 and so on, the most recent first.
+func persistStateRotated(path string, data interface{}, keep int) error {
 	buf, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to encode state: %v", err)
@@ -88,6 +97,9 @@ func persistState(path string, data interface{}) error {
 	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
 		return fmt.Errorf("failed to save state to tmp: %v", err)
 	}
+	if err := rotateState(path, keep); err != nil {
+		return fmt.Errorf("failed to rotate the snapshots of %s: %v", path, err)
+	}
 	if err := os.Rename(tmpPath, path); err != nil {
 		return fmt.Errorf("failed to rename tmp to path: %v", err)
 	}
@@ -100,3 +112,74 @@ func persistState(path string, data interface{}) error {
 	}
 	return nil
 }
+
+// rotateState shifts the snapshots at path by one, for a new one to be saved
+// at path, and removes those past the last keep.
+func rotateState(path string, keep int) error {
+	for _, p := range statePaths(path)[1:] {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(p, path+"."), "%d", &n)
+		if n >= keep-1 {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	for n := keep - 2; n >= 0; n-- {
+		from := path
+		if n > 0 {
+			from = fmt.Sprintf("%s.%d", path, n)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// statePaths returns path and its previous snapshots, see persistStateRotated,
+// the most recent first. path is returned even if it does not exist.
+func statePaths(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	seqs := make(map[int]string)
+	var ns []int
+	for _, m := range matches {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(m, path+"."), "%d", &n); err != nil || n <= 0 ||
+			m != fmt.Sprintf("%s.%d", path, n) {
+			continue
+		}
+		seqs[n] = m
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	paths := []string{path}
+	for _, n := range ns {
+		paths = append(paths, seqs[n])
+	}
+	return paths
+}
+
+// restoreState is used to read back in the persisted state
+func restoreState(path string, data interface{}) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state: %v", err)
+	}
+	if err := json.Unmarshal(buf, data); err != nil {
+		return fmt.Errorf("failed to decode state: %v", err)
+	}
+	return nil
+}
+
+// gtidTxCount returns the number of transactions in the gtid set.
+func gtidTxCount(gtidSet string) (int64, error) {
+	set, err := gtid.Parse(gtidSet)
+	if err != nil {
+		return 0, err
+	}
+	return set.Count(), nil
+}
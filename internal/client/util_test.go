@@ -46,12 +46,44 @@ func Test_diffAllocs(t *testing.T) {
 		existing []*models.Allocation
 		allocs   *allocUpdates
 	}
+	complete := &models.Allocation{
+		ID:               "alloc1",
+		AllocModifyIndex: 10,
+		DesiredStatus:    models.AllocDesiredStatusRun,
+		ClientStatus:     models.AllocClientStatusComplete,
+		TaskStates:       map[string]*models.TaskState{models.TaskTypeSrc: {State: models.TaskStateComplete}},
+	}
+	updated := complete.Copy()
+	updated.AllocModifyIndex = 11
 	tests := []struct {
 		name string
 		args args
 		want *diffResult
 	}{
-		// TODO: Add test cases.
+		{
+			name: "complete alloc unchanged",
+			args: args{
+				existing: []*models.Allocation{complete},
+				allocs:   &allocUpdates{filtered: map[string]struct{}{"alloc1": {}}},
+			},
+			want: &diffResult{ignore: []*models.Allocation{complete}},
+		},
+		{
+			name: "complete alloc updated",
+			args: args{
+				existing: []*models.Allocation{complete},
+				allocs:   &allocUpdates{pulled: map[string]*models.Allocation{"alloc1": updated}},
+			},
+			want: &diffResult{updated: []allocTuple{{complete, updated}}},
+		},
+		{
+			name: "complete alloc removed",
+			args: args{
+				existing: []*models.Allocation{complete},
+				allocs:   &allocUpdates{},
+			},
+			want: &diffResult{removed: []*models.Allocation{complete}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -82,3 +114,29 @@ func Test_persistState(t *testing.T) {
 		})
 	}
 }
+
+func Test_gtidTxCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		gtid    string
+		want    int64
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"one-interval", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5", 5, false},
+		{"two-sids", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7,3e11fa47-71ca-11e1-9e33-c80aa9429563:1", 7, false},
+		{"bad", "not-a-gtid", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gtidTxCount(tt.gtid)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gtidTxCount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("gtidTxCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
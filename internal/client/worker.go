@@ -7,9 +7,11 @@
 package client
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -19,6 +21,7 @@ import (
 
 	"github.com/armon/go-metrics"
 
+	"github.com/actiontech/dtle/internal/client/allocdir"
 	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
@@ -54,11 +57,29 @@ type Worker struct {
 	taskStats     *models.TaskStatistics
 	taskStatsLock sync.RWMutex
 
+	// samples of the throughput of the task, for its rates
+	throughput throughputRing
+	// samples of the progress of the task, for its completion estimate
+	completion completionRing
+
 	task *models.Task
 
+	// the Meta of the job, updated in place with the allocation
+	jobMeta     map[string]string
+	jobMetaLock sync.Mutex
+
 	handle     driver.DriverHandle
 	handleLock sync.Mutex
 
+	// the config of the running task. nil if it cannot be resolved. guarded by handleLock
+	resolvedConfig *models.ResolvedTaskConfig
+
+	// nil without ClientConfig.AllocDir
+	taskDir *allocdir.TaskDir
+
+	// the stdout and stderr of the task, opened on its first start
+	stdout, stderr *taskOutput
+
 	// payloadRendered tracks whether the payload has been rendered to disk
 	payloadRendered bool
 
@@ -79,6 +100,10 @@ type Worker struct {
 	destroyEvent *models.TaskEvent
 	workUpdates  chan *models.TaskUpdate
 
+	// forceKillCh is closed to stop waiting for the task handle on destroy
+	forceKillCh   chan struct{}
+	forceKillOnce sync.Once
+
 	// waitCh closing marks the run loop as having exited
 	waitCh chan struct{}
 
@@ -121,12 +146,14 @@ func NewWorker(logger *log.Logger, config *config.ClientConfig,
 		restartTracker: restartTracker,
 		alloc:          alloc,
 		task:           task,
+		jobMeta:        alloc.Job.Meta,
 		destroyCh:      make(chan struct{}),
 		waitCh:         make(chan struct{}),
 		startCh:        make(chan struct{}, 1),
 		unblockCh:      make(chan struct{}),
 		restartCh:      make(chan *models.TaskEvent),
 		workUpdates:    workUpdates,
+		forceKillCh:    make(chan struct{}),
 	}
 
 	return tc
@@ -178,16 +205,36 @@ func (r *Worker) SaveState() error {
 				}
 			}
 		} else {
-			r.workUpdates <- &models.TaskUpdate{
+			update := &models.TaskUpdate{
 				JobID:    r.alloc.JobID,
 				NatsAddr: id.DriverConfig.NatsAddr,
 			}
+			if r.task.Type == models.TaskTypeDest {
+				// the applied part of the full copy, for the tasks to resume it
+				update.DumpProgress = id.DriverConfig.DumpProgress
+			}
+			r.workUpdates <- update
 		}
 		r.logger.Debugf("Worker.SaveState: lock: %p, %p", r.task, r.task.ConfigLock)
 		r.task.ConfigLock.Lock()
 		r.logger.Debugf("Worker.SaveState: after lock: %p", r.task)
 		r.task.Config["Gtid"] = id.DriverConfig.Gtid
 		r.task.Config["NatsAddr"] = id.DriverConfig.NatsAddr
+		if r.task.Type == models.TaskTypeDest {
+			if id.DriverConfig.DumpProgress != nil && id.DriverConfig.Gtid == "" {
+				r.task.Config["DumpProgress"] = id.DriverConfig.DumpProgress
+			} else {
+				delete(r.task.Config, "DumpProgress")
+			}
+		}
+		if r.task.Type == models.TaskTypeSrc && id.DriverConfig.SourceAddr != "" {
+			// switched by SourceMaxLag
+			r.task.Config["SourceAddr"] = id.DriverConfig.SourceAddr
+		}
+		if r.task.Type == models.TaskTypeSrc && id.DriverConfig.ServerIdRetry != 0 {
+			// re-randomized after a server_id collision
+			r.task.Config["ServerIdRetry"] = id.DriverConfig.ServerIdRetry
+		}
 		r.task.ConfigLock.Unlock()
 		r.logger.Debugf("Worker.SaveState: after unlock: %p", r.task)
 	}
@@ -195,6 +242,38 @@ func (r *Worker) SaveState() error {
 	return nil
 }
 
+// saveStartPosition sets the Gtid captured by the task at its first start in the task config,
+// and has it saved by the allocator and the servers, without waiting for the next snapshot.
+func (r *Worker) saveStartPosition(gtid string) {
+	r.persistLock.Lock()
+	r.task.ConfigLock.Lock()
+	r.task.Config["Gtid"] = gtid
+	r.task.ConfigLock.Unlock()
+	r.persistLock.Unlock()
+
+	r.workUpdates <- &models.TaskUpdate{
+		JobID: r.alloc.JobID,
+		Gtid:  gtid,
+	}
+	r.updater(r.task.Type, "", models.NewTaskEvent(models.TaskStartPositionSaved).
+		SetDriverMessage(fmt.Sprintf("start position: gtid %v", gtid)))
+}
+
+// currentGtid returns the Gtid replicated by the task, from the handle.
+// ok is false if the task is not running.
+func (r *Worker) currentGtid() (gtid string, ok bool) {
+	r.handleLock.Lock()
+	defer r.handleLock.Unlock()
+	if r.handle == nil {
+		return "", false
+	}
+	id := &config.DriverCtx{}
+	if err := json.Unmarshal([]byte(r.handle.ID()), id); err != nil || id.DriverConfig == nil {
+		return "", false
+	}
+	return id.DriverConfig.Gtid, true
+}
+
 // DestroyState is used to cleanup after ourselves
 func (r *Worker) DestroyState() error {
 	r.persistLock.Lock()
@@ -218,7 +297,13 @@ func (r *Worker) setState(state string, event *models.TaskEvent) {
 
 // createDriver makes a driver for the task
 func (r *Worker) createDriver() (driver.Driver, error) {
-	driverCtx := driver.NewDriverContext(r.task.Type, r.alloc.ID, r.config, r.config.Node, r.logger)
+	eventEmitter := func(m string, args ...interface{}) {
+		msg := fmt.Sprintf(m, args...)
+		r.logger.Debugf("agent: driver event for task %q in allocation %q: %q", r.task.Type, r.alloc.ID, msg)
+		r.setState("", models.NewTaskEvent(models.TaskDriverMessage).SetDriverMessage(msg))
+	}
+
+	driverCtx := driver.NewDriverContext(r.task.Type, r.alloc.ID, r.config, r.config.Node, r.logger, eventEmitter)
 	driver, err := driver.NewDriver(r.task.Driver, driverCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create driver '%s' for alloc %s: %v",
@@ -230,6 +315,7 @@ func (r *Worker) createDriver() (driver.Driver, error) {
 // Run is a long running routine used to manage the task
 func (r *Worker) Run() {
 	defer close(r.waitCh)
+	defer r.closeOutput()
 	r.logger.Debugf("agent: Starting task context for '%s' (alloc '%s')",
 		r.task.Type, r.alloc.ID)
 
@@ -267,6 +353,7 @@ func (r *Worker) run() {
 	// Predeclare things so we can jump to the RESTART
 	var stopCollection chan struct{}
 	var handleWaitCh chan *models.WaitResult
+	var startupDeadline <-chan time.Time
 
 	// If we already have a handle, populate the stopCollection and handleWaitCh
 	// to fix the invariant that it exists.
@@ -324,8 +411,26 @@ func (r *Worker) run() {
 					}
 
 					handleWaitCh = r.handle.WaitCh()
+					startupDeadline = r.startupDeadline()
 				}
 
+			case <-startupDeadline:
+				startupDeadline = nil
+				timeoutErr := r.startupTimeout()
+				if timeoutErr == nil {
+					continue
+				}
+
+				r.logger.Errorf("agent: Task %q for alloc %q failed: %v", r.task.Type, r.alloc.ID, timeoutErr)
+				r.setState("", models.NewTaskEvent(models.TaskStartupTimeout).SetDriverError(timeoutErr))
+				r.killTask(nil)
+				close(stopCollection)
+
+				// The hung handle might never exit, so it is not waited. The failure
+				// counts against the restart policy.
+				r.restartTracker.SetWaitResult(models.NewWaitResult(1, timeoutErr))
+				break WAIT
+
 			case waitRes := <-handleWaitCh:
 				if waitRes == nil {
 					panic("nil wait")
@@ -337,6 +442,7 @@ func (r *Worker) run() {
 
 				// Stop collection of the task's resource usage
 				close(stopCollection)
+				r.collectFinalTaskStats()
 
 				// Log whether the task was successful or not.
 				r.restartTracker.SetWaitResult(waitRes)
@@ -402,7 +508,15 @@ func (r *Worker) run() {
 				r.killTask(killEvent)
 				close(stopCollection)
 				// Wait for handler to exit before calling cleanup
-				<-handleWaitCh
+				select {
+				case <-handleWaitCh:
+				case <-r.forceKillCh:
+					r.logger.Errorf("agent: Forced teardown of task %v for alloc %q. Resources may have been leaked",
+						r.task.Type, r.alloc.ID)
+					r.setState(models.TaskStateDead,
+						models.NewTaskEvent(models.TaskKilled).SetKillError(fmt.Errorf("forced teardown")))
+					return
+				}
 
 				r.logger.Debugf("setState 8")
 				r.setState(models.TaskStateDead, nil)
@@ -411,10 +525,14 @@ func (r *Worker) run() {
 		}
 
 	RESTART:
-		restart := r.shouldRestart()
+		restart, completed := r.shouldRestart()
 		if !restart {
+			state := models.TaskStateDead
+			if completed {
+				state = models.TaskStateComplete
+			}
 			r.logger.Debugf("setState 9")
-			r.setState(models.TaskStateDead, nil)
+			r.setState(state, nil)
 			return
 		}
 
@@ -423,26 +541,29 @@ func (r *Worker) run() {
 		r.handle = nil
 		handleWaitCh = nil
 		stopCollection = nil
+		startupDeadline = nil
 		r.handleLock.Unlock()
 	}
 }
 
 // shouldRestart returns if the task should restart. If the return value is
 // true, the task's restart policy has already been considered and any wait time
-// between restarts has been applied.
-func (r *Worker) shouldRestart() bool {
+// between restarts has been applied. completed is true if the task is not
+// restarted because it finished successfully.
+func (r *Worker) shouldRestart() (restart, completed bool) {
 	state, when := r.restartTracker.GetState()
 	reason := r.restartTracker.GetReason()
 	switch state {
-	case models.TaskNotRestarting, models.TaskTerminated:
+	case models.TaskTerminated:
+		r.logger.Printf("agent: Not restarting task: %v for alloc: %v, it completed", r.task.Type, r.alloc.ID)
+		return false, true
+	case models.TaskNotRestarting:
 		r.logger.Printf("agent: Not restarting task: %v for alloc: %v ", r.task.Type, r.alloc.ID)
-		if state == models.TaskNotRestarting {
-			r.logger.Debugf("setState restart 1")
-			r.setState(models.TaskStateFailed,
-				models.NewTaskEvent(models.TaskNotRestarting).
-					SetRestartReason(reason).SetFailsTask())
-		}
-		return false
+		r.logger.Debugf("setState restart 1")
+		r.setState(models.TaskStateFailed,
+			models.NewTaskEvent(models.TaskNotRestarting).
+				SetRestartReason(reason).SetFailsTask())
+		return false, false
 	case models.TaskRestarting:
 		r.logger.Printf("agent: Restarting task %q for alloc %q in %v", r.task.Type, r.alloc.ID, when)
 		r.logger.Debugf("setState restart 2")
@@ -452,7 +573,7 @@ func (r *Worker) shouldRestart() bool {
 				SetRestartReason(reason))
 	default:
 		r.logger.Errorf("agent: Restart tracker returned unknown store: %q", state)
-		return false
+		return false, false
 	}
 
 	// Sleep but watch for destroy events.
@@ -469,10 +590,10 @@ func (r *Worker) shouldRestart() bool {
 		r.logger.Debugf("agent: Not restarting task: %v because it has been destroyed", r.task.Type)
 		r.logger.Debugf("setState restart 3")
 		r.setState(models.TaskStateDead, r.destroyEvent)
-		return false
+		return false, false
 	}
 
-	return true
+	return true, false
 }
 
 // killTask kills the running task. A killing event can optionally be passed and
@@ -527,6 +648,23 @@ func (r *Worker) startTask() error {
 
 	// Run prestart
 	ctx := driver.NewExecContext(r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload)
+	ctx.NatsAuth = r.config.NatsAuth
+	ctx.AllocID = r.alloc.ID
+	ctx.AuxDisk = r.config.AuxDisk
+	ctx.SaveStartPosition = r.saveStartPosition
+	ctx.ApplyLatencyExemplars = r.config.ApplyLatencyExemplars
+	if r.taskDir != nil {
+		if err := r.taskDir.Build(); err != nil {
+			return fmt.Errorf("failed to build task dir of task %q for alloc %q: %v",
+				r.task.Type, r.alloc.ID, err)
+		}
+		ctx.TaskDir = r.taskDir
+	}
+	if err := r.openOutput(); err != nil {
+		return fmt.Errorf("failed to open the output of task %q for alloc %q: %v",
+			r.task.Type, r.alloc.ID, err)
+	}
+	ctx.Stdout, ctx.Stderr = r.stdout, r.stderr
 
 	// Start the job
 	handle, err := drv.Start(ctx, r.task)
@@ -538,12 +676,102 @@ func (r *Worker) startTask() error {
 
 	}
 
+	r.task.ConfigLock.RLock()
+	resolved, err := driver.ResolvedTaskConfig(r.task)
+	r.task.ConfigLock.RUnlock()
+	var resolvedConfig *models.ResolvedTaskConfig
+	if err != nil {
+		r.logger.Warnf("agent: Failed to resolve config of task %q for alloc %q: %v", r.task.Type, r.alloc.ID, err)
+	} else {
+		resolvedConfig = &models.ResolvedTaskConfig{
+			Config:      resolved,
+			ModifyIndex: r.alloc.Job.ModifyIndex,
+		}
+	}
+
 	r.handleLock.Lock()
 	r.handle = handle
+	r.resolvedConfig = resolvedConfig
 	r.handleLock.Unlock()
 	return nil
 }
 
+// startupDeadline returns a channel fired at the start deadline of the handle,
+// or nil if it does not report its startup or has no deadline.
+func (r *Worker) startupDeadline() <-chan time.Time {
+	r.handleLock.Lock()
+	reporter, ok := r.handle.(driver.StartupReporter)
+	r.handleLock.Unlock()
+	if !ok || reporter.StartDeadline() <= 0 {
+		return nil
+	}
+	return time.After(reporter.StartDeadline())
+}
+
+// startupTimeout returns a models.StartupTimeoutError with the phase the handle
+// is stuck in, or nil if it has started.
+func (r *Worker) startupTimeout() error {
+	r.handleLock.Lock()
+	reporter, ok := r.handle.(driver.StartupReporter)
+	r.handleLock.Unlock()
+	if !ok {
+		return nil
+	}
+	phase, started := reporter.StartupPhase()
+	if started {
+		return nil
+	}
+	return &models.StartupTimeoutError{Phase: phase, Deadline: reporter.StartDeadline()}
+}
+
+// resolvedTaskConfig returns the config of the running task, or nil.
+func (r *Worker) resolvedTaskConfig() *models.ResolvedTaskConfig {
+	r.handleLock.Lock()
+	defer r.handleLock.Unlock()
+	return r.resolvedConfig
+}
+
+// applyLatency returns the apply latency of the tables of the task if its handle is a
+// driver.LatencyReporter, or nil.
+func (r *Worker) applyLatency() []*models.LatencyHistogram {
+	r.handleLock.Lock()
+	reporter, ok := r.handle.(driver.LatencyReporter)
+	r.handleLock.Unlock()
+	if !ok {
+		return nil
+	}
+	return reporter.ApplyLatency()
+}
+
+// EffectiveConfig returns the config the task is running with: its resolved config, with the
+// values the handle resolved while running merged in if it is a driver.ConfigResolver.
+func (r *Worker) EffectiveConfig() (*models.ResolvedTaskConfig, error) {
+	r.handleLock.Lock()
+	handle := r.handle
+	resolved := r.resolvedConfig
+	r.handleLock.Unlock()
+
+	if handle == nil {
+		return nil, fmt.Errorf("task %v is not running", r.task.Type)
+	}
+	if resolved == nil {
+		return nil, fmt.Errorf("the config of task %v could not be resolved", r.task.Type)
+	}
+	effective := &models.ResolvedTaskConfig{
+		Config:      make(map[string]interface{}, len(resolved.Config)),
+		ModifyIndex: resolved.ModifyIndex,
+	}
+	for k, v := range resolved.Config {
+		effective.Config[k] = v
+	}
+	if resolver, ok := handle.(driver.ConfigResolver); ok {
+		for k, v := range resolver.ResolvedConfig() {
+			effective.Config[k] = v
+		}
+	}
+	return effective, nil
+}
+
 // collectResourceUsageStats starts collecting resource usage stats of a Task.
 // Collection ends when the passed channel is closed
 func (r *Worker) collectResourceUsageStats(stopCollection <-chan struct{}) {
@@ -576,6 +804,11 @@ func (r *Worker) collectResourceUsageStats(stopCollection <-chan struct{}) {
 				continue
 			}
 
+			if ru != nil {
+				r.throughput.observe(ru, time.Now())
+				r.completion.observe(ru, time.Now())
+				ru.JobMeta = r.getJobMeta()
+			}
 			r.taskStatsLock.Lock()
 			r.taskStats = ru
 			r.taskStatsLock.Unlock()
@@ -603,6 +836,31 @@ func (r *Worker) LatestTaskStats() *models.TaskStatistics {
 	return r.taskStats
 }
 
+// collectFinalTaskStats gets the stats of the exited task once more, for the report of
+// the allocation.
+func (r *Worker) collectFinalTaskStats() {
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+	if handle == nil {
+		return
+	}
+	ru, err := handle.Stats()
+	if err != nil || ru == nil {
+		return
+	}
+	r.taskStatsLock.Lock()
+	r.taskStats = ru
+	r.taskStatsLock.Unlock()
+}
+
+// finalTaskStats returns the last stats collected, also when the task is not running.
+func (r *Worker) finalTaskStats() *models.TaskStatistics {
+	r.taskStatsLock.RLock()
+	defer r.taskStatsLock.RUnlock()
+	return r.taskStats
+}
+
 // handleDestroy kills the task handle. In the case that killing fails,
 // handleDestroy will retry with an exponential backoff and will give up at a
 // given limit. It returns whether the task was destroyed and the error
@@ -652,6 +910,26 @@ func (r *Worker) Kill(source, reason string, fail bool) {
 	r.Destroy(event)
 }
 
+// ForceKill stops waiting for the task to exit on destroy. It is used when the
+// task does not stop in time.
+func (r *Worker) ForceKill() {
+	r.forceKillOnce.Do(func() {
+		close(r.forceKillCh)
+	})
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+	if handle != nil {
+		// The handle might hang again. Do not block the caller.
+		go func() {
+			if err := handle.Shutdown(); err != nil {
+				r.logger.Warnf("agent: Failed to shut down task %v for alloc %q on forced teardown: %v",
+					r.task.Type, r.alloc.ID, err)
+			}
+		}()
+	}
+}
+
 // UnblockStart unblocks the starting of the task. It currently assumes only
 // consul-template will unblock
 func (r *Worker) UnblockStart(source string) {
@@ -673,6 +951,95 @@ func (r *Worker) waitErrorToEvent(res *models.WaitResult) *models.TaskEvent {
 		SetExitMessage(res.Err)
 }
 
+// SetWorkers resizes the pool of workers of the task, whose handle must be a
+// driver.WorkerResizer.
+func (r *Worker) SetWorkers(ctx context.Context, n int) error {
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+
+	if handle == nil {
+		return fmt.Errorf("task %v is not running", r.task.Type)
+	}
+	resizer, ok := handle.(driver.WorkerResizer)
+	if !ok {
+		return fmt.Errorf("the workers of task %v cannot be resized", r.task.Type)
+	}
+	return resizer.SetWorkers(ctx, n)
+}
+
+// errStatsNotResettable is returned by ResetStats for a task whose handle is not a
+// driver.StatsResetter.
+var errStatsNotResettable = errors.New("the stats of the task cannot be reset")
+
+// ResetStats resets the counters of the task, whose handle must be a driver.StatsResetter, and
+// returns them as they were. The reset is recorded as a task event, with who reset them.
+func (r *Worker) ResetStats(by string) (*models.TaskCounters, error) {
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+
+	if handle == nil {
+		return nil, fmt.Errorf("task %v is not running", r.task.Type)
+	}
+	resetter, ok := handle.(driver.StatsResetter)
+	if !ok {
+		return nil, errStatsNotResettable
+	}
+	counters, err := resetter.ResetStats()
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Printf("agent: Stats of task %v for alloc %q reset by %v", r.task.Type, r.alloc.ID, by)
+	r.updater(r.task.Type, "", models.NewTaskEvent(models.TaskStatsReset).
+		SetDriverMessage(fmt.Sprintf("counters reset by %v", by)))
+
+	// not to report the counters before the reset until the next collection
+	if ru, err := handle.Stats(); err == nil && ru != nil {
+		r.throughput.observe(ru, time.Now())
+		r.completion.observe(ru, time.Now())
+		r.taskStatsLock.Lock()
+		r.taskStats = ru
+		r.taskStatsLock.Unlock()
+	}
+	return counters, nil
+}
+
+// Stop stops the task gracefully and without restarting it. A handle which is a
+// driver.GracefulStopper finishes its work in progress first, so the state saved
+// is a clean checkpoint. The task is then killed as by Destroy, and torn down
+// by force if it has not exited when ctx is done.
+func (r *Worker) Stop(ctx context.Context) error {
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+
+	var err error
+	if stopper, ok := handle.(driver.GracefulStopper); ok {
+		r.setState(models.TaskStateRunning, models.NewTaskEvent(models.TaskStopping))
+		if err = stopper.Stop(ctx); err != nil {
+			r.logger.Warnf("agent: Task %v for alloc %q did not stop gracefully: %v",
+				r.task.Type, r.alloc.ID, err)
+		}
+		if saveErr := r.SaveState(); saveErr != nil {
+			r.logger.Errorf("agent: Failed to save store of Task Runner for task %q: %v", r.task.Type, saveErr)
+		}
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-ctx.Done():
+		r.logger.Errorf("agent: Task %v for alloc %q did not stop in time. Forcing teardown",
+			r.task.Type, r.alloc.ID)
+		r.ForceKill()
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
 // Destroy is used to indicate that the task context should be destroyed. The
 // event parameter provides a context for the destroy.
 func (r *Worker) Destroy(event *models.TaskEvent) {
@@ -687,10 +1054,36 @@ func (r *Worker) Destroy(event *models.TaskEvent) {
 	close(r.destroyCh)
 }
 
+// setJobMeta updates the Meta of the job of the task, without restarting it.
+func (r *Worker) setJobMeta(meta map[string]string) {
+	r.jobMetaLock.Lock()
+	defer r.jobMetaLock.Unlock()
+	r.jobMeta = meta
+}
+
+func (r *Worker) getJobMeta() map[string]string {
+	r.jobMetaLock.Lock()
+	defer r.jobMetaLock.Unlock()
+	return r.jobMeta
+}
+
+// metricLabels returns the labels of the metrics of the task: its name, and the entries of the
+// Meta of the job whose keys are in AllocationMetricsMetaLabels.
+func (r *Worker) metricLabels() []metrics.Label {
+	labels := []metrics.Label{{"task_name", fmt.Sprintf("%s_%s", r.alloc.Job.Name, r.alloc.Task)}}
+	meta := r.getJobMeta()
+	for _, key := range r.config.AllocationMetricsMetaLabels {
+		if value, ok := meta[key]; ok {
+			labels = append(labels, metrics.Label{Name: key, Value: value})
+		}
+	}
+	return labels
+}
+
 // emitStats emits resource usage stats of tasks to remote metrics collector
 // sinks
 func (r *Worker) emitStats(ru *models.TaskStatistics) {
-	labels := []metrics.Label{{"task_name", fmt.Sprintf("%s_%s", r.alloc.Job.Name, r.alloc.Task)}}
+	labels := r.metricLabels()
 	if r.config.PublishAllocationMetrics {
 		metrics.SetGaugeWithLabels([]string{"network", "in_msgs"}, float32(ru.MsgStat.InMsgs), labels)
 		metrics.SetGaugeWithLabels([]string{"network", "out_msgs"}, float32(ru.MsgStat.OutMsgs), labels)
@@ -706,6 +1099,7 @@ func (r *Worker) emitStats(ru *models.TaskStatistics) {
 		metrics.SetGaugeWithLabels([]string{"table", "insert"}, float32(ru.TableStats.InsertCount), labels)
 		metrics.SetGaugeWithLabels([]string{"table", "update"}, float32(ru.TableStats.UpdateCount), labels)
 		metrics.SetGaugeWithLabels([]string{"table", "delete"}, float32(ru.TableStats.DelCount), labels)
+		metrics.SetGaugeWithLabels([]string{"table", "conflict"}, float32(ru.TableStats.ConflictCount), labels)
 	}
 
 	if ru.DelayCount != nil && r.config.PublishAllocationMetrics {
@@ -713,8 +1107,29 @@ func (r *Worker) emitStats(ru *models.TaskStatistics) {
 		metrics.SetGaugeWithLabels([]string{"delay", "time"}, float32(ru.DelayCount.Time), labels)
 	}
 
+	if ru.GtidGap != nil && r.config.PublishAllocationMetrics {
+		metrics.SetGaugeWithLabels([]string{"gtid_gap", "num"}, float32(ru.GtidGap.Transactions), labels)
+		metrics.SetGaugeWithLabels([]string{"gtid_gap", "time"}, float32(ru.GtidGap.Seconds), labels)
+	}
+
 	if ru.ThroughputStat != nil && r.config.PublishAllocationMetrics {
 		metrics.SetGaugeWithLabels([]string{"throughput", "num"}, float32(ru.ThroughputStat.Num), labels)
 		metrics.SetGaugeWithLabels([]string{"throughput", "time"}, float32(ru.ThroughputStat.Time), labels)
 	}
+
+	if ru.Throughput != nil && r.config.PublishAllocationMetrics {
+		metrics.SetGaugeWithLabels([]string{"throughput", "events"}, float32(ru.Throughput.Events), labels)
+		metrics.SetGaugeWithLabels([]string{"throughput", "bytes"}, float32(ru.Throughput.Bytes), labels)
+		for _, w := range ru.Throughput.Windows {
+			windowLabels := append([]metrics.Label{{"window", w.Window}}, labels...)
+			metrics.SetGaugeWithLabels([]string{"throughput", "events_per_sec"}, float32(w.EventsPerSec), windowLabels)
+			metrics.SetGaugeWithLabels([]string{"throughput", "bytes_per_sec"}, float32(w.BytesPerSec), windowLabels)
+		}
+	}
+
+	if ru.TxStat != nil && r.config.PublishAllocationMetrics {
+		metrics.SetGaugeWithLabels([]string{"tx", "succeeded"}, float32(ru.TxStat.Succeeded), labels)
+		metrics.SetGaugeWithLabels([]string{"tx", "failed"}, float32(ru.TxStat.Failed), labels)
+		metrics.SetGaugeWithLabels([]string{"tx", "error_rate_pct"}, float32(ru.TxStat.ErrorRatePct), labels)
+	}
 }
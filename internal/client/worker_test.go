@@ -7,9 +7,17 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+
 	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
@@ -815,7 +823,7 @@ func TestWorker_shouldRestart(t *testing.T) {
 				waitCh:          tt.fields.waitCh,
 				persistLock:     tt.fields.persistLock,
 			}
-			if got := r.shouldRestart(); got != tt.want {
+			if got, _ := r.shouldRestart(); got != tt.want {
 				t.Errorf("Worker.shouldRestart() = %v, want %v", got, tt.want)
 			}
 		})
@@ -1622,3 +1630,204 @@ func TestWorker_emitStats(t *testing.T) {
 		})
 	}
 }
+
+func TestWorker_saveStartPosition(t *testing.T) {
+	var events []*models.TaskEvent
+	r := &Worker{
+		updater: func(taskName, state string, event *models.TaskEvent) {
+			events = append(events, event)
+		},
+		alloc: &models.Allocation{JobID: "job1"},
+		task: &models.Task{
+			Type:       models.TaskTypeSrc,
+			Config:     map[string]interface{}{"StartPosition": "current"},
+			ConfigLock: &sync.RWMutex{},
+		},
+		workUpdates: make(chan *models.TaskUpdate, 1),
+	}
+	gtid := "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-10"
+	r.saveStartPosition(gtid)
+
+	if got := r.task.Config["Gtid"]; got != gtid {
+		t.Errorf("task config Gtid = %v, want %v", got, gtid)
+	}
+	select {
+	case update := <-r.workUpdates:
+		if update.JobID != "job1" || update.Gtid != gtid {
+			t.Errorf("TaskUpdate = %+v, want the Gtid of job1", update)
+		}
+	default:
+		t.Errorf("no TaskUpdate")
+	}
+	if len(events) != 1 || events[0].Type != models.TaskStartPositionSaved {
+		t.Errorf("events = %v, want a %v event", events, models.TaskStartPositionSaved)
+	}
+}
+
+// stoppingHandle commits its work in progress, advancing its Gtid, on Stop.
+type stoppingHandle struct {
+	gtid    string
+	stopped bool
+}
+
+func (h *stoppingHandle) ID() string {
+	bs, _ := json.Marshal(config.DriverCtx{DriverConfig: &config.MySQLDriverConfig{Gtid: h.gtid}})
+	return string(bs)
+}
+func (h *stoppingHandle) WaitCh() chan *models.WaitResult { return nil }
+func (h *stoppingHandle) Shutdown() error                 { return nil }
+func (h *stoppingHandle) Stats() (*models.TaskStatistics, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (h *stoppingHandle) Stop(ctx context.Context) error {
+	h.stopped = true
+	h.gtid = "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-10"
+	return nil
+}
+
+func TestWorker_Stop(t *testing.T) {
+	var events []*models.TaskEvent
+	handle := &stoppingHandle{gtid: "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:1-8"}
+	r := &Worker{
+		logger: log.New(os.Stderr, log.ErrorLevel),
+		updater: func(taskName, state string, event *models.TaskEvent) {
+			events = append(events, event)
+		},
+		alloc: &models.Allocation{ID: "alloc1", JobID: "job1"},
+		task: &models.Task{
+			Type:       models.TaskTypeDest,
+			Config:     map[string]interface{}{},
+			ConfigLock: &sync.RWMutex{},
+		},
+		handle:      handle,
+		destroyCh:   make(chan struct{}),
+		waitCh:      make(chan struct{}),
+		forceKillCh: make(chan struct{}),
+		workUpdates: make(chan *models.TaskUpdate, 4),
+	}
+	// the run loop
+	go func() {
+		<-r.destroyCh
+		close(r.waitCh)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !handle.stopped {
+		t.Errorf("Stop() did not stop the handle gracefully")
+	}
+	if r.destroyEvent == nil || r.destroyEvent.Type != models.TaskKilled {
+		t.Errorf("destroy event = %v, want %v", r.destroyEvent, models.TaskKilled)
+	}
+	if len(events) == 0 || events[0].Type != models.TaskStopping {
+		t.Errorf("events = %v, want a %v event", events, models.TaskStopping)
+	}
+	// The checkpoint is the Gtid after the work in progress.
+	if got := r.task.Config["Gtid"]; got != handle.gtid {
+		t.Errorf("task config Gtid = %v, want %v", got, handle.gtid)
+	}
+	var last *models.TaskUpdate
+	for len(r.workUpdates) > 0 {
+		last = <-r.workUpdates
+	}
+	if last == nil || last.Gtid != handle.gtid {
+		t.Errorf("last TaskUpdate = %+v, want the Gtid %v", last, handle.gtid)
+	}
+}
+
+type startingHandle struct {
+	stoppingHandle
+	phase    string
+	started  bool
+	deadline time.Duration
+}
+
+func (h *startingHandle) StartupPhase() (string, bool) { return h.phase, h.started }
+func (h *startingHandle) StartDeadline() time.Duration { return h.deadline }
+
+func TestWorker_startupTimeout(t *testing.T) {
+	r := &Worker{handle: &stoppingHandle{}}
+	if r.startupDeadline() != nil || r.startupTimeout() != nil {
+		t.Errorf("a handle not reporting its startup has a start deadline")
+	}
+
+	handle := &startingHandle{phase: "source_inspection", deadline: 10 * time.Millisecond}
+	r.handle = handle
+	select {
+	case <-r.startupDeadline():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the start deadline did not fire")
+	}
+	err, ok := r.startupTimeout().(*models.StartupTimeoutError)
+	if !ok || err.Phase != "source_inspection" || err.Deadline != handle.deadline {
+		t.Errorf("startupTimeout() = %v, want the phase source_inspection", r.startupTimeout())
+	}
+
+	handle.started = true
+	if err := r.startupTimeout(); err != nil {
+		t.Errorf("startupTimeout() of a started handle = %v", err)
+	}
+	handle.deadline = 0
+	if r.startupDeadline() != nil {
+		t.Errorf("a disabled start deadline fires")
+	}
+}
+
+func TestWorker_metricLabels(t *testing.T) {
+	alloc := &models.Allocation{
+		Task: models.TaskTypeSrc,
+		Job:  &models.Job{Name: "job1", Meta: map[string]string{"team": "payments", "ticket": "DB-1234"}},
+	}
+	r := &Worker{
+		config:  &config.ClientConfig{AllocationMetricsMetaLabels: []string{"team", "env"}},
+		alloc:   alloc,
+		jobMeta: alloc.Job.Meta,
+	}
+	want := []metrics.Label{{Name: "task_name", Value: "job1_Src"}, {Name: "team", Value: "payments"}}
+	if got := r.metricLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("metricLabels() = %v, want %v", got, want)
+	}
+
+	// updated in place with the allocation
+	r.setJobMeta(map[string]string{"team": "billing", "env": "prod"})
+	want = []metrics.Label{{Name: "task_name", Value: "job1_Src"}, {Name: "team", Value: "billing"}, {Name: "env", Value: "prod"}}
+	if got := r.metricLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("metricLabels() = %v after an update, want %v", got, want)
+	}
+}
+
+type resolvingHandle struct {
+	stoppingHandle
+	values map[string]interface{}
+}
+
+func (h *resolvingHandle) ResolvedConfig() map[string]interface{} { return h.values }
+
+func TestWorker_EffectiveConfig(t *testing.T) {
+	r := &Worker{task: &models.Task{Type: models.TaskTypeDest}}
+	if _, err := r.EffectiveConfig(); err == nil {
+		t.Errorf("EffectiveConfig() of a task not running, want an error")
+	}
+
+	resolved := &models.ResolvedTaskConfig{
+		Config:      map[string]interface{}{"ParallelWorkers": 4, "ReplChanBufferSize": 600},
+		ModifyIndex: 7,
+	}
+	r.handle = &resolvingHandle{values: map[string]interface{}{"ParallelWorkers": 8}}
+	r.resolvedConfig = resolved
+	got, err := r.EffectiveConfig()
+	if err != nil {
+		t.Fatalf("EffectiveConfig() error = %v", err)
+	}
+	want := map[string]interface{}{"ParallelWorkers": 8, "ReplChanBufferSize": 600}
+	if !reflect.DeepEqual(got.Config, want) || got.ModifyIndex != 7 {
+		t.Errorf("EffectiveConfig() = %+v, want %v at 7", got, want)
+	}
+	// the config the task was started with is kept
+	if resolved.Config["ParallelWorkers"] != 4 {
+		t.Errorf("EffectiveConfig() modified the resolved config: %v", resolved.Config)
+	}
+}
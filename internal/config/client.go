@@ -8,15 +8,23 @@ package config
 
 import (
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/actiontech/dtle/internal"
+	"github.com/actiontech/dtle/internal/auxdisk"
+	"github.com/actiontech/dtle/internal/client/allocdir"
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/g"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/socks5"
 
 	"strings"
 
@@ -35,6 +43,175 @@ const (
 	defaultChunkSize  = 2000
 	defaultNumWorkers = 1
 	defaultMsgBytes   = 20 * 1024
+
+	defaultFailoverTimeout = 300
+
+	defaultAdaptiveGroupMaxSize       = 50
+	defaultAdaptiveGroupTargetLatency = 100
+
+	defaultRowCountCheckMaxChurn = 1000
+
+	defaultTransportIdleTimeout = 60
+	defaultStatsPublishInterval = 10
+	defaultParkIdleAfter        = 600
+	defaultErrorRateWindow      = 300
+
+	defaultBinlogHeartbeatPeriod = 3
+
+	defaultMetadataCacheSize = 1024
+	defaultMetadataCacheTTL  = 300
+
+	defaultStartDeadline = 600
+
+	defaultHeartbeatTableInterval = 1
+
+	defaultConcurrentCopyMaxBufferMB = 256
+
+	defaultExportFileSizeMB = 256
+)
+
+// Values of MySQLDriverConfig.NoPkTablePolicy
+const (
+	// Refuse to replicate tables without a primary key.
+	NoPkTablePolicyReject = "reject"
+	// Replicate with all columns in the WHERE clause of UPDATE/DELETE.
+	NoPkTablePolicyFullRowMatch = "full_row_match"
+	// Add a generated auto-increment primary key column on the target.
+	NoPkTablePolicySurrogateKey = "surrogate_key"
+)
+
+// StartPositionCurrent is the value of MySQLDriverConfig.StartPosition to replicate from the
+// current position of the source, without the full copy.
+const StartPositionCurrent = "current"
+
+// Values of MySQLDriverConfig.DumpCompression and IncrementalCompression
+const (
+	CompressionSnappy = "snappy"
+	CompressionGzip   = "gzip"
+	CompressionNone   = "none"
+)
+
+// Values of MySQLDriverConfig.Codec
+const (
+	CodecAuto = "auto"
+	CodecV1   = "v1"
+	CodecV2   = "v2"
+)
+
+// Values of MySQLDriverConfig.CharsetErrorPolicy
+const (
+	// Fail the task on a string the target column cannot store.
+	CharsetErrorPolicyFail = "fail"
+	// Replace the characters the target column cannot store by '?'.
+	CharsetErrorPolicyReplace = "replace"
+)
+
+// Values of MySQLDriverConfig.ZeroDatePolicy
+const (
+	// Apply the zero and the invalid dates as they are, failing on a target rejecting them.
+	ZeroDatePolicyFail = "fail"
+	// Apply them as they are, allowing them on the sessions of the applier: NO_ZERO_DATE and
+	// NO_ZERO_IN_DATE are removed from their sql_mode, and ALLOW_INVALID_DATES is added.
+	ZeroDatePolicyPreserve = "preserve"
+	// Apply them as NULL.
+	ZeroDatePolicyNull = "null"
+	// Apply them as the epoch: '1970-01-01' for DATE and DATETIME, and '1970-01-02' for
+	// TIMESTAMP, within its range in any time zone.
+	ZeroDatePolicyEpoch = "epoch"
+)
+
+// riskyErrorCodes are the MySQL error codes of IgnoreErrorCodes which may hide a diverging
+// target, e.g. a lost row, by their names.
+var riskyErrorCodes = map[uint16]string{
+	1022: "ER_DUP_KEY",
+	1032: "ER_KEY_NOT_FOUND",
+	1048: "ER_BAD_NULL_ERROR",
+	1054: "ER_BAD_FIELD_ERROR",
+	1062: "ER_DUP_ENTRY",
+	1136: "ER_WRONG_VALUE_COUNT_ON_ROW",
+	1146: "ER_NO_SUCH_TABLE",
+	1264: "ER_WARN_DATA_OUT_OF_RANGE",
+	1292: "ER_TRUNCATED_WRONG_VALUE",
+	1366: "ER_TRUNCATED_WRONG_VALUE_FOR_FIELD",
+	1406: "ER_DATA_TOO_LONG",
+	1451: "ER_ROW_IS_REFERENCED_2",
+	1452: "ER_NO_REFERENCED_ROW_2",
+}
+
+// unignorableErrorCodes are the MySQL error codes the applier handles itself, which
+// IgnoreErrorCodes may not have: the transaction is rolled back, or the statement is killed.
+var unignorableErrorCodes = map[uint16]string{
+	1205: "ER_LOCK_WAIT_TIMEOUT",
+	1213: "ER_LOCK_DEADLOCK",
+	1317: "ER_QUERY_INTERRUPTED",
+}
+
+// Values of MySQLDriverConfig.ConflictPolicy
+const (
+	// INSERT. Fail the task on a row conflicting with an existing one.
+	ConflictPolicyFail = "fail"
+	// INSERT IGNORE. Keep the existing row.
+	ConflictPolicyIgnore = "ignore"
+	// REPLACE. Delete the existing row and insert the new one.
+	ConflictPolicyReplace = "replace"
+	// INSERT ... ON DUPLICATE KEY UPDATE. Update the existing row with the new values.
+	ConflictPolicyUpsert = "upsert"
+)
+
+// Values of MySQLDriverConfig.XaPolicy
+const (
+	// Apply an XA transaction as a regular transaction at its XA COMMIT.
+	XaPolicyLocal = "local"
+	// Prepare an XA transaction on the target at its XA PREPARE, and commit or roll it back on
+	// the target at its XA COMMIT or XA ROLLBACK.
+	XaPolicyTarget = "xa"
+)
+
+// Values of MySQLDriverConfig.UnsignedPolicy
+const (
+	// Fail the task on a rows event with an integer value of a column missing from the table
+	// metadata, whose signedness is unknown.
+	UnsignedPolicyFail = "fail"
+	// Send such values as decoded, i.e. signed, e.g. the hidden primary key of AliRDS. The applier
+	// converts them with the target columns.
+	UnsignedPolicySigned = "signed"
+)
+
+// Values of MySQLDriverConfig.ExecTimeoutPolicy
+const (
+	// Restart the task on a statement killed after MaxExecTime. The transaction is applied
+	// again from the checkpoint, under the restart policy of the job.
+	ExecTimeoutPolicyRetry = "retry"
+	// Fail the task on a statement killed after MaxExecTime.
+	ExecTimeoutPolicyFail = "fail"
+)
+
+// Values of MySQLDriverConfig.ExportFrom
+const (
+	// Export the tables of ReplicateDoDb by their names on the source.
+	ExportFromSource = "source"
+	// Export them by their names on the target, renamed by TableSchemaRename, TableRename and
+	// TargetSchemaMap, TargetSchemaPrefix and TargetSchemaSuffix.
+	ExportFromTarget = "target"
+)
+
+// Values of MySQLDriverConfig.ExportFormat
+const (
+	// CSV of RFC 4180, with a header of the column names. NULL is \N, unquoted.
+	ExportFormatCSV = "csv"
+	// Parquet, uncompressed, a column of byte arrays per column of the table.
+	ExportFormatParquet = "parquet"
+)
+
+// The ranges of the server_id of the extractor as a replica of the source. 0 is not a valid
+// server_id of a replica.
+const (
+	// ReplicaServerId may be set to 1 - ReplicaServerIdMax, e.g. to a server_id reserved for dtle
+	// among the servers of the replication topology.
+	ReplicaServerIdMax = 1<<31 - 1
+	// The server_ids derived from the node ID and the job ID, and the re-randomized ones after a
+	// collision, are in derivedServerIdMin - 4294967295, unused by the servers configured by hand.
+	derivedServerIdMin = 1 << 31
 )
 
 // RPCHandler can be provided to the Client if there is a local server
@@ -84,6 +261,10 @@ type ClientConfig struct {
 
 	MaxPayload int
 
+	// NatsAuth is the authentication of the embedded nats server and of the
+	// connections of the tasks. Might be nil.
+	NatsAuth *NatsAuthConfig
+
 	// StatsCollectionInterval is the interval at which the Udup client
 	// collects resource usage stats
 	StatsCollectionInterval time.Duration
@@ -96,14 +277,139 @@ type ClientConfig struct {
 	// allocation metrics to remote Metric sinks
 	PublishAllocationMetrics bool
 
+	// AllocationMetricsMetaLabels are the keys of the Meta of the jobs which label the
+	// allocation metrics, e.g. "team". The other keys are not, to bound the cardinality.
+	AllocationMetricsMetaLabels []string
+
 	// LogLevel is the level of the logs to putout
 	LogLevel string
 
 	// NoHostUUID disables using the host's UUID and will force generation of a
 	// random UUID.
 	NoHostUUID bool
+
+	// AllocUpdatesBufferSize is the capacity of the queue of allocation
+	// status updates waiting to be synced to the servers.
+	AllocUpdatesBufferSize int
+
+	// AllocShutdownTimeout is how long the tasks of an allocation are waited
+	// to stop on destroy, before they are torn down forcibly.
+	AllocShutdownTimeout time.Duration
+
+	// StateSnapshotInterval is how often the changed allocations are
+	// snapshotted to the state dir.
+	StateSnapshotInterval time.Duration
+
+	// StateSnapshotTxDelta is how many transactions a task replicates
+	// before its allocation is considered changed and snapshotted. If set,
+	// it is snapshotted then without waiting for StateSnapshotInterval.
+	StateSnapshotTxDelta int64
+
+	// StateSnapshotsRetained is how many snapshots of an allocation are
+	// kept, the restore falling back to the previous one if the latest fails
+	// to be read.
+	StateSnapshotsRetained int
+
+	// AuxDiskBudget is the max bytes of the auxiliary files (spill,
+	// dead-letter and audit files) of all the tasks. 0 is unlimited.
+	AuxDiskBudget int64
+
+	// AuxDiskPolicy is what to do when AuxDiskBudget is hit: "pause"
+	// (default), "drop_dead_letters" or "stop_audit".
+	AuxDiskPolicy string
+
+	// AuxDisk accounts the auxiliary files of the tasks. It is created by
+	// the client from AuxDiskBudget and AuxDiskPolicy.
+	AuxDisk *auxdisk.Budget
+
+	// DriverSetupParallelism is how many drivers are set up at once at
+	// start. 0 or 1 to set them up one by one.
+	DriverSetupParallelism int
+
+	// DriverSetupTimeout is how long a driver is waited to be set up at
+	// start, before it is skipped. 0 is unlimited.
+	DriverSetupTimeout time.Duration
+
+	// ApplyLatencyExemplars keeps the apply latency of the tables of the Dest
+	// tasks, with the GTID of the slowest transaction of each bucket, for the
+	// OpenMetrics format of /metrics. It costs memory by table.
+	ApplyLatencyExemplars bool
+
+	// RefuseUnsupportedAllocs fails the allocations whose task needs a driver
+	// or a feature the node does not advertise. The managers already avoid
+	// placing them; this is the backstop, e.g. for a node being upgraded.
+	RefuseUnsupportedAllocs bool
+
+	// DisableConsulFallback disables looking for the servers in Consul
+	// (the service ConsulConfig.ServerServiceName) when they are unreachable
+	// or report no leader. ConsulConfig.ClientAutoJoin = false disables it too.
+	DisableConsulFallback bool
+
+	// ConsulFallbackAfter is how long the servers report no leader before
+	// they are looked for in Consul. 0 for the default.
+	ConsulFallbackAfter time.Duration
+
+	// HeartbeatGraceFactor stretches the heartbeat TTL of the node within
+	// which the last heartbeat is taken as healthy. 0 for the default.
+	HeartbeatGraceFactor float64
+
+	// ExpectedTasks is how many tasks the node is expected to run at once,
+	// from which the least limit of open files is derived. 0 for the default.
+	ExpectedTasks int
+
+	// OpenFilesPolicy is what to do at start when the limit of open files is
+	// below that for ExpectedTasks: "warn" (default) or "fail".
+	OpenFilesPolicy string
+
+	// StateDirMode is the octal mode of the state dir, e.g. "0700" (default).
+	StateDirMode string
+
+	// AllocDirMode is the octal mode of the alloc dir and the dirs of the
+	// allocations and the tasks in it, but the secrets dirs. Empty for 0700
+	// for the alloc dir and 0755 for the others. AllocDirOwner is their owner,
+	// "<uid>:<gid>", either of which may be empty. Empty to keep the agent's.
+	AllocDirMode  string
+	AllocDirOwner string
+
+	// AllocDirPerms are parsed by the client from AllocDirMode and
+	// AllocDirOwner.
+	AllocDirPerms allocdir.Perms
+
+	// DNSPrefer is the address family tried first when a server or a MySQL
+	// endpoint has both: "ipv4" or "ipv6". Empty for the order of the DNS.
+	DNSPrefer string
+
+	// AllocReconcileThreshold is how long the status of an allocation may
+	// not match that wanted by the servers before it is re-driven.
+	AllocReconcileThreshold time.Duration
+
+	// TaskOutputLogLevel is the level at which the stdout and stderr of the
+	// tasks are forwarded to the client log: debug, info, warn or error.
+	// Empty to only write them to the logs dirs of the tasks.
+	TaskOutputLogLevel string
+
+	// SelfMonitorWindow is the window over which the growth of the
+	// goroutines and the heap in use of the client is judged.
+	SelfMonitorWindow time.Duration
+
+	// SelfMonitorGoroutines and SelfMonitorHeapMB are the goroutines and the
+	// heap in use (MB) above which a growth over every sample of the window
+	// is warned of. 0 for off.
+	SelfMonitorGoroutines int
+	SelfMonitorHeapMB     int
+
+	// SelfMonitorHeapProfileMB is the heap in use (MB) above which a heap
+	// profile is written to the debug dir of the state dir, at most once an
+	// hour. 0 for off.
+	SelfMonitorHeapProfileMB int
 }
 
+// Values of ClientConfig.OpenFilesPolicy
+const (
+	OpenFilesPolicyWarn = "warn"
+	OpenFilesPolicyFail = "fail"
+)
+
 func (c *ClientConfig) Copy() *ClientConfig {
 	nc := new(ClientConfig)
 	*nc = *c
@@ -118,16 +424,19 @@ type DriverCtx struct {
 }
 
 func (d *DataSource) String() string {
-	return fmt.Sprintf(d.TableSchema)
+	return d.TableSchema
 }
 
 type MySQLDriverConfig struct {
-	DataDir     string
+	// the data and tmp dirs of the task in the alloc dir. Empty without an alloc dir
+	DataDir     string `json:"-"` // set by the client
+	TmpDir      string `json:"-"` // set by the client
 	MaxFileSize int64
 	//Ref:http://dev.mysql.com/doc/refman/5.7/en/replication-options-slave.html#option_mysqld_replicate-do-table
 	ReplicateDoDb                       []*DataSource
 	ReplicateIgnoreDb                   []*DataSource
 	DropTableIfExists                   bool
+	ConfirmDropTable                    bool // required with DropTableIfExists, which drops the existing tables on the target
 	ExpandSyntaxSupport                 bool
 	ReplChanBufferSize                  int64
 	MsgBytesLimit                       int
@@ -147,17 +456,27 @@ type MySQLDriverConfig struct {
 	GroupCount                          int
 	GroupMaxSize                        int
 	GroupTimeout                        int // millisecond
+	// ReadBatchSize is the max transactions of the binlog the extractor sends in one message,
+	// if GroupMaxSize (bytes) is not reached first. 0 (default) for ReplChanBufferSize.
+	ReadBatchSize int
 
 	Gtid                     string
 	GtidStart                string
-	AutoGtid                 bool // For internal use. Might be changed without notification.
+	AutoGtid                 bool                 // For internal use. Might be changed without notification.
+	DumpProgress             *models.DumpProgress // set by the applier while copying. For internal use.
 	NatsAddr                 string
+	NatsAuth                 *NatsAuthConfig `json:"-"` // set by the client
+	AllocID                  string          `json:"-"` // set by the client
+	NodeId                   string          `json:"-"` // set by the client
+	AuxDisk                  *auxdisk.Budget `json:"-"` // set by the client
+	ApplyLatencyExemplars    bool            `json:"-"` // set by the client, see ClientConfig.ApplyLatencyExemplars
 	ParallelWorkers          int
 	ConnectionConfig         *umconf.ConnectionConfig
 	SystemVariables          map[string]string
 	HasSuperPrivilege        bool
 	BinlogFormat             string
 	BinlogRowImage           string
+	BinlogCompression        bool // binlog_transaction_compression of the source, MySQL 8.0.20+
 	SqlMode                  string
 	MySQLVersion             string
 	MySQLServerUuid          string
@@ -183,6 +502,220 @@ type MySQLDriverConfig struct {
 
 	SkipPrivilegeCheck  bool
 	SkipIncrementalCopy bool
+	// Mode is the mode of the job, on the Src task: JobModeFull (default) to copy the tables and
+	// replicate the binlog from the copy, JobModeCopyOnly to complete after the copy (as
+	// SkipIncrementalCopy), or JobModeIncrementalOnly to replicate the binlog from Gtid, GtidStart
+	// or StartPosition, without the copy. It cannot be changed by updating the job.
+	Mode string
+	// ConcurrentIncrementalCopy streams the binlog from the snapshot of the full copy while the
+	// tables are copied, instead of after the copy. The applier holds the transactions on a table
+	// until the table is copied (the watermark handoff), in memory, up to ConcurrentCopyMaxBufferMB.
+	// The transactions are applied one at a time until the copy completes.
+	ConcurrentIncrementalCopy bool
+	ConcurrentCopyMaxBufferMB int
+	// StrictPrivilegeCheck checks at start the privileges needed on the replicated tables
+	// (e.g. SELECT on the source, INSERT/UPDATE/DELETE on the target), and fails with the missing ones.
+	StrictPrivilegeCheck bool
+	// UseLoadData applies the rows of the full copy with LOAD DATA LOCAL INFILE, via files in TmpDir.
+	// A chunk is inserted instead if it cannot be loaded, and all the rows if the target disallows it.
+	UseLoadData bool
+	// DumpCompression and IncrementalCompression compress the messages from the extractor
+	// to the applier in the full copy and in the incremental replication respectively.
+	// See CompressionSnappy (default), CompressionGzip and CompressionNone.
+	DumpCompression        string
+	IncrementalCompression string
+	// Codec is the codec of the transactions of the incremental replication, before the
+	// compression. CodecAuto (default) uses the compact binary CodecV2 once the applier
+	// tells it supports it, else the gob of older versions, CodecV1. CodecV1 forces it.
+	Codec string
+	// CodecV2Supported tells whether the node of the task supports CodecV2, by its
+	// capability attributes.
+	CodecV2Supported bool `json:"-"` // set by the client
+	// CharsetErrorPolicy decides what the applier does with a string which the charset of its
+	// target column cannot store, e.g. an emoji into a utf8 or latin1 column. The strings from
+	// the binlog are first decoded from the charset of their source column.
+	// See CharsetErrorPolicyFail (default) and CharsetErrorPolicyReplace.
+	CharsetErrorPolicy string
+	// ZeroDatePolicy decides what the applier does with the zero dates, e.g. '0000-00-00', and
+	// the invalid dates, e.g. '2023-02-30', of the DATE, DATETIME and TIMESTAMP columns, which
+	// a source may allow by its sql_mode and a strict target rejects.
+	// See ZeroDatePolicyFail (default), ZeroDatePolicyPreserve, ZeroDatePolicyNull and
+	// ZeroDatePolicyEpoch.
+	ZeroDatePolicy string
+	// ConflictPolicy decides how the applier writes the rows of the full copy which conflict on a
+	// unique key with the rows already on the target, e.g. of a partially copied table.
+	// See ConflictPolicyReplace (default), ConflictPolicyFail, ConflictPolicyIgnore and
+	// ConflictPolicyUpsert. The incremental replication is not affected, whose inserts replace
+	// the existing rows so that the transactions can be applied again after a restart.
+	ConflictPolicy string
+	// XaPolicy decides how the applier applies the XA transactions, whose GTIDs are applied at
+	// their XA COMMIT or XA ROLLBACK. See XaPolicyLocal (default) and XaPolicyTarget.
+	XaPolicy string
+	// UnsignedPolicy decides what the extractor does with the integer values of a rows event
+	// beyond the columns of the table metadata, which the binlog has as signed. The values of
+	// UNSIGNED columns are otherwise converted with the metadata.
+	// See UnsignedPolicyFail (default) and UnsignedPolicySigned.
+	UnsignedPolicy string
+	// MaxExecTime is how long (in seconds) a statement of the applier may run on the target before
+	// it is killed, e.g. an UPDATE without a useful index blocking the replication. 0 (default)
+	// for no limit. ExecTimeoutPolicy decides what follows the kill: see ExecTimeoutPolicyRetry
+	// (default) and ExecTimeoutPolicyFail.
+	MaxExecTime       int
+	ExecTimeoutPolicy string
+	// IgnoreErrorCodes are the MySQL error codes of the target which the applier treats as
+	// success, logging them, e.g. 1644 of a SIGNAL in a trigger. The statement is skipped, and
+	// the rest of its transaction applied. The codes of riskyErrorCodes, e.g. 1062 of a duplicate
+	// entry, are warned of loudly unless IgnoreRiskyErrorCodes.
+	IgnoreErrorCodes      []uint16
+	IgnoreRiskyErrorCodes bool
+	// InitSQL are the statements run in order on every connection of the task to its MySQL server,
+	// including the connections opened on the reconnects, e.g. "SET SESSION sql_mode = ''" or
+	// "SET time_zone = '+00:00'". Each is a single SET statement.
+	InitSQL []string
+	// TargetSchemaMap, TargetSchemaPrefix and TargetSchemaSuffix rename the source schemas on the
+	// target, e.g. "app" to "tenant1_app" with the prefix "tenant1_", in the full copy, the DMLs and
+	// the DDLs. A schema of TargetSchemaMap is renamed to its value, and other schemas get the prefix
+	// and the suffix, except the system schemas. The TableSchemaRename of ReplicateDoDb comes first.
+	TargetSchemaMap    map[string]string
+	TargetSchemaPrefix string
+	TargetSchemaSuffix string
+	// EventFilters drop or pass source events by operation, table and statement text.
+	// Evaluated after ReplicateDoDb and SqlFilter. See EventFilterRule.
+	EventFilters []*EventFilterRule
+	// SkipServerIds drops the row events logged with these server_ids, i.e. originated on these
+	// servers. The transactions are still replicated, without the rows, so the GTIDs advance.
+	SkipServerIds []uint32
+	// ReplicaServerId is the server_id of the extractor as a replica of the source, which must be
+	// unique among the replicas of the source: the source disconnects the older of two replicas with
+	// the same server_id. 0 (default) derives it from the node ID and the job ID. See
+	// ReplicaServerIdMax.
+	ReplicaServerId uint32
+	// ServerIdRetry is the re-randomized server_id of the extractor after a server_id collision.
+	// For internal use.
+	ServerIdRetry uint32
+
+	// DependencyGroups declares tables related by foreign keys. Each group is a list of
+	// "schema.table" (names on the target), parent first. With ParallelWorkers > 1, a transaction
+	// touching a table of a group waits for all earlier transactions touching the group to be
+	// applied. This trades parallelism of these tables for a consistent view on the target.
+	DependencyGroups [][]string
+	// WriteSetStrict applies the transactions on a table with a unique secondary key in source
+	// order with ParallelWorkers > 1. Otherwise, only transactions writing the same primary key
+	// are ordered, and two transactions may conflict on the unique key.
+	WriteSetStrict bool
+
+	// AdaptiveGroup makes the applier commit consecutive transactions together, in groups of
+	// AdaptiveGroupMinSize to AdaptiveGroupMaxSize transactions. The size grows while a group is
+	// applied within AdaptiveGroupTargetLatency (milliseconds), and is halved on a slower group, an
+	// error or a deadlock (AIMD). Disabled with WriteSetStrict.
+	AdaptiveGroup              bool
+	AdaptiveGroupMinSize       int
+	AdaptiveGroupMaxSize       int
+	AdaptiveGroupTargetLatency int
+
+	// NoPkTablePolicy decides how tables without a primary key are handled.
+	// See NoPkTablePolicyReject (default), NoPkTablePolicyFullRowMatch and NoPkTablePolicySurrogateKey.
+	NoPkTablePolicy string
+
+	// MetadataCacheSize bounds the tables whose structure (columns and charsets) the applier
+	// keeps, least recently used first out. The structure of a table is dropped on its DDLs.
+	// MetadataCacheTTL is how long (in seconds) a structure is used if DDLs may not reach the
+	// applier, i.e. an EventFilters rule may drop them.
+	MetadataCacheSize int
+	MetadataCacheTTL  int
+
+	// FailoverTimeout is how long (in seconds) the applier waits for a writable target,
+	// after the target turned read-only (e.g. during a failover). Negative to fail immediately.
+	FailoverTimeout int
+	// FailoverHosts are other target instances ("host:port") to try when the target is read-only.
+	FailoverHosts []string
+	// ReplicationChannel is the replication channel of a multi-source replica source.
+	// If set, only the transactions received from the channel are replicated.
+	ReplicationChannel string
+	// StartPosition is StartPositionCurrent to capture the current Gtid of the source at the
+	// first start, and to replicate from it without the full copy. The captured Gtid is
+	// persisted at once, and is resumed from like a checkpoint afterwards.
+	StartPosition string
+	// SaveStartPosition persists at once the Gtid captured with StartPosition. set by the client
+	SaveStartPosition func(gtid string) `json:"-"`
+	// SourceServerUuid pins the server_uuid of the source. The extractor refuses to run on
+	// another server, e.g. after a DNS or config change repoints the source address.
+	SourceServerUuid string
+	// SourceHosts are other readable source instances ("host:port"), in order of preference after
+	// the address in ConnectionConfig. See SourceMaxLag.
+	SourceHosts []string
+	// SourceMaxLag is the max replica lag (in seconds) of the source. If it is exceeded, the extractor
+	// switches to the first of SourceHosts within it, which has all the sent transactions. 0 (default) to disable.
+	SourceMaxLag int
+	// SourceAddr is the address ("host:port") the extractor reads from after a switch. For internal use.
+	SourceAddr string
+	// ReadFromReplica reads the binlog from a replica of the primary, for when the binlog dump
+	// is not granted on the primary. The replica must have log_slave_updates. Its replica lag is
+	// added to DelayCount, and the extractor switches to the first of SourceHosts with the sent
+	// transactions when the replica stops replicating, is unreachable or exceeds SourceMaxLag.
+	ReadFromReplica bool
+
+	// RowCountCheckInterval is the interval (in seconds) of comparing row counts of the source
+	// and target tables. 0 (default) to disable.
+	RowCountCheckInterval int
+	// RowCountTolerance is the max difference of row counts for a table not to be flagged as diverged.
+	RowCountTolerance int64
+	// RowCountCheckMaxChurn skips a table in a row count check, if more rows than it of the table
+	// are changed in the last interval. Their counts are expected to differ transiently.
+	RowCountCheckMaxChurn int64
+
+	// TransportIdleTimeout is how long (in seconds) the applier may receive nothing from nats,
+	// while the extractor reports publishing, before a task event is emitted. Negative to disable.
+	TransportIdleTimeout int
+	// StatsPublishInterval is the interval (in seconds) of publishing the statistics of the task
+	// on the nats subject "dtle.stats.<job>". Negative to disable.
+	StatsPublishInterval int
+	// ParkIdleAfter is how long (in seconds) the applier may have nothing to apply before it
+	// closes its connections to the target, reopening them on the next transaction.
+	// DisableIdleParking keeps them open, for the jobs which cannot afford the reconnection.
+	ParkIdleAfter      int
+	DisableIdleParking bool
+	// ErrorRateWindow is the interval (in seconds) the error rate of the transactions applied is
+	// over. The counts of the window are reset at its end.
+	ErrorRateWindow int
+	// BinlogHeartbeatPeriod is the interval (in seconds) of the heartbeats the source sends on the
+	// binlog connection when idle. The connection is reconnected after missing two of them.
+	// Negative to disable the heartbeats, and the detection of a dead connection.
+	BinlogHeartbeatPeriod int
+
+	// StartDeadline is how long (in seconds) the task may take to start, i.e. to connect to the
+	// source (Src) or the target (Dest) and to nats. A task which has not started within it, e.g.
+	// hung on a DNS lookup or on a query blocked by a metadata lock, fails with the phase it is
+	// stuck in, and is restarted by the restart policy. Negative to disable.
+	StartDeadline int
+
+	// HeartbeatTable is a table ("schema.table") on the target, which the applier creates and
+	// writes the time and the source binlog position of the applied transactions into every
+	// HeartbeatTableInterval (seconds), in the layout of pt-heartbeat, for the tools measuring
+	// the replication lag with it. Empty (default) to disable.
+	HeartbeatTable         string
+	HeartbeatTableInterval int
+
+	// EncryptDataAtRest encrypts the data files the task writes in the alloc dir, i.e. the LOAD
+	// DATA files of UseLoadData, with the data key of the allocation (AES-GCM). The key is
+	// shredded when the allocation is destroyed. Needs the encrypt_at_rest feature on the client.
+	EncryptDataAtRest bool
+	DataKey           []byte `json:"-"` // set by the client with EncryptDataAtRest
+
+	// DumpMaxMBPerSec caps the rate (in MB per second) of the rows read from the tables by the
+	// full copy and by the Export task. 0 (default) for no limit.
+	DumpMaxMBPerSec int
+
+	// The Export task writes the tables of ReplicateDoDb to files, as of a consistent snapshot,
+	// and completes. ExportFrom tells whether the server of ConnectionConfig is the source or the
+	// target of the job, for the names of the tables: see ExportFromSource (default) and
+	// ExportFromTarget. ExportFormat is ExportFormatCSV (default) or ExportFormatParquet.
+	// ExportURI is the dir of the files, a path or a file:// URI, the data dir of the task if
+	// empty. A file is completed once past ExportFileSizeMB, and the next one started.
+	ExportFrom       string
+	ExportFormat     string
+	ExportURI        string
+	ExportFileSizeMB int
 }
 
 func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
@@ -191,6 +724,16 @@ func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
 	if result.MaxRetries <= 0 {
 		result.MaxRetries = defaultNumRetries
 	}
+	if result.Mode == "" {
+		if result.SkipIncrementalCopy {
+			result.Mode = models.JobModeCopyOnly
+		} else {
+			result.Mode = models.JobModeFull
+		}
+	}
+	if result.Mode == models.JobModeCopyOnly {
+		result.SkipIncrementalCopy = true
+	}
 	if result.ChunkSize <= 0 {
 		result.ChunkSize = defaultChunkSize
 	}
@@ -216,12 +759,571 @@ func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
 	// TODO temporarily (or permanently) disable homogeneous replication, hetero only.
 	result.ApproveHeterogeneous = true
 
+	if result.AdaptiveGroupMinSize <= 0 {
+		result.AdaptiveGroupMinSize = 1
+	}
+	if result.AdaptiveGroupMaxSize <= 0 {
+		result.AdaptiveGroupMaxSize = defaultAdaptiveGroupMaxSize
+	}
+	if result.AdaptiveGroupMaxSize < result.AdaptiveGroupMinSize {
+		result.AdaptiveGroupMaxSize = result.AdaptiveGroupMinSize
+	}
+	if result.AdaptiveGroupTargetLatency <= 0 {
+		result.AdaptiveGroupTargetLatency = defaultAdaptiveGroupTargetLatency
+	}
+
+	if result.FailoverTimeout == 0 {
+		result.FailoverTimeout = defaultFailoverTimeout
+	}
+
+	if result.RowCountCheckMaxChurn <= 0 {
+		result.RowCountCheckMaxChurn = defaultRowCountCheckMaxChurn
+	}
+	if result.TransportIdleTimeout == 0 {
+		result.TransportIdleTimeout = defaultTransportIdleTimeout
+	}
+	if result.StatsPublishInterval == 0 {
+		result.StatsPublishInterval = defaultStatsPublishInterval
+	}
+	if result.ParkIdleAfter <= 0 {
+		result.ParkIdleAfter = defaultParkIdleAfter
+	}
+	if result.ErrorRateWindow <= 0 {
+		result.ErrorRateWindow = defaultErrorRateWindow
+	}
+	if result.BinlogHeartbeatPeriod == 0 {
+		result.BinlogHeartbeatPeriod = defaultBinlogHeartbeatPeriod
+	}
+	if result.StartDeadline == 0 {
+		result.StartDeadline = defaultStartDeadline
+	}
+	if result.ConcurrentCopyMaxBufferMB <= 0 {
+		result.ConcurrentCopyMaxBufferMB = defaultConcurrentCopyMaxBufferMB
+	}
+	if result.HeartbeatTableInterval <= 0 {
+		result.HeartbeatTableInterval = defaultHeartbeatTableInterval
+	}
+	if result.ExportFileSizeMB <= 0 {
+		result.ExportFileSizeMB = defaultExportFileSizeMB
+	}
+	if result.MetadataCacheSize <= 0 {
+		result.MetadataCacheSize = defaultMetadataCacheSize
+	}
+	if result.MetadataCacheTTL <= 0 {
+		result.MetadataCacheTTL = defaultMetadataCacheTTL
+	}
+
+	if "" == result.DumpCompression {
+		result.DumpCompression = CompressionSnappy
+	}
+	if "" == result.IncrementalCompression {
+		result.IncrementalCompression = CompressionSnappy
+	}
+	if "" == result.Codec {
+		result.Codec = CodecAuto
+	}
+
+	if "" == result.CharsetErrorPolicy {
+		result.CharsetErrorPolicy = CharsetErrorPolicyFail
+	}
+	if "" == result.ZeroDatePolicy {
+		result.ZeroDatePolicy = ZeroDatePolicyFail
+	}
+	if "" == result.ExportFrom {
+		result.ExportFrom = ExportFromSource
+	}
+	if "" == result.ExportFormat {
+		result.ExportFormat = ExportFormatCSV
+	}
+	if "" == result.ConflictPolicy {
+		result.ConflictPolicy = ConflictPolicyReplace
+	}
+	if "" == result.XaPolicy {
+		result.XaPolicy = XaPolicyLocal
+	}
+	if "" == result.UnsignedPolicy {
+		result.UnsignedPolicy = UnsignedPolicyFail
+	}
+	if "" == result.ExecTimeoutPolicy {
+		result.ExecTimeoutPolicy = ExecTimeoutPolicyRetry
+	}
+
+	if "" == result.NoPkTablePolicy {
+		result.NoPkTablePolicy = NoPkTablePolicyReject
+	}
+
 	if "" == result.ConnectionConfig.Charset {
 		result.ConnectionConfig.Charset = "utf8mb4"
 	}
 	return &result
 }
 
+// ValidateCreateTable checks the arguments about creating the tables on the target.
+func (m *MySQLDriverConfig) ValidateCreateTable() error {
+	if m.SkipCreateDbTable && m.DropTableIfExists {
+		return fmt.Errorf("conflicting job argument: SkipCreateDbTable=true and DropTableIfExists=true")
+	}
+	if m.DropTableIfExists && !m.ConfirmDropTable {
+		return fmt.Errorf("DropTableIfExists=true drops the existing tables on the target. ConfirmDropTable=true is required")
+	}
+	return nil
+}
+
+// ValidateSourceHosts checks the arguments about switching to another source instance.
+func (m *MySQLDriverConfig) ValidateSourceHosts() error {
+	if (m.SourceMaxLag > 0 || m.ReadFromReplica) && len(m.SourceHosts) > 0 && m.SourceServerUuid != "" {
+		return fmt.Errorf("conflicting job argument: SourceServerUuid pins a single source, and SourceHosts with SourceMaxLag or ReadFromReplica switch to another")
+	}
+	for _, host := range m.SourceHosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			return fmt.Errorf("bad SourceHosts %v: %v", host, err)
+		}
+	}
+	return nil
+}
+
+// ReadBatchLimit returns the max transactions of the binlog in one message of the extractor.
+func (m *MySQLDriverConfig) ReadBatchLimit() int {
+	if m.ReadBatchSize > 0 {
+		return m.ReadBatchSize
+	}
+	return int(m.ReplChanBufferSize)
+}
+
+// ValidateReadBatch checks ReadBatchSize. A batch is sent once it reaches GroupMaxSize bytes, so
+// batching needs GroupMaxSize below maxPayload, the max message size of nats (0 if unknown).
+func (m *MySQLDriverConfig) ValidateReadBatch(maxPayload int) error {
+	if m.ReadBatchSize < 0 {
+		return fmt.Errorf("bad ReadBatchSize %v: negative", m.ReadBatchSize)
+	}
+	if m.ReadBatchSize > 1 && maxPayload > 0 && m.GroupMaxSize >= maxPayload {
+		return fmt.Errorf("bad ReadBatchSize %v: GroupMaxSize %v is not below the max payload of nats %v. "+
+			"a message of the batched transactions would exceed it", m.ReadBatchSize, m.GroupMaxSize, maxPayload)
+	}
+	return nil
+}
+
+// ValidateAdaptiveGroup checks the bounds of the group size of AdaptiveGroup.
+func (m *MySQLDriverConfig) ValidateAdaptiveGroup() error {
+	if m.AdaptiveGroupMinSize < 0 || m.AdaptiveGroupMaxSize < 0 || m.AdaptiveGroupTargetLatency < 0 {
+		return fmt.Errorf("bad AdaptiveGroup arguments: negative AdaptiveGroupMinSize, AdaptiveGroupMaxSize or AdaptiveGroupTargetLatency")
+	}
+	if m.AdaptiveGroupMinSize > 0 && m.AdaptiveGroupMaxSize > 0 && m.AdaptiveGroupMinSize > m.AdaptiveGroupMaxSize {
+		return fmt.Errorf("bad AdaptiveGroup arguments: AdaptiveGroupMinSize %v > AdaptiveGroupMaxSize %v",
+			m.AdaptiveGroupMinSize, m.AdaptiveGroupMaxSize)
+	}
+	return nil
+}
+
+// ValidateStartPosition checks StartPosition.
+func (m *MySQLDriverConfig) ValidateStartPosition() error {
+	switch m.StartPosition {
+	case "":
+		return nil
+	case StartPositionCurrent:
+		if m.GtidStart != "" {
+			return fmt.Errorf("conflicting job argument: StartPosition=%v and GtidStart", m.StartPosition)
+		}
+		return nil
+	default:
+		return fmt.Errorf("bad StartPosition '%v'. Expect '%v'", m.StartPosition, StartPositionCurrent)
+	}
+}
+
+// ValidateMode checks Mode with the arguments it requires or forbids.
+func (m *MySQLDriverConfig) ValidateMode() error {
+	switch m.Mode {
+	case "":
+		return nil
+	case models.JobModeFull:
+		if m.SkipIncrementalCopy {
+			return fmt.Errorf("conflicting job argument: Mode=%v and SkipIncrementalCopy", m.Mode)
+		}
+		return nil
+	case models.JobModeCopyOnly:
+		if m.StartPosition != "" || m.GtidStart != "" || m.AutoGtid {
+			return fmt.Errorf("conflicting job argument: Mode=%v and a start position of the binlog", m.Mode)
+		}
+		if m.SourceMaxLag > 0 || m.MaxLagMillisecondsThrottleThreshold > 0 || m.HeartbeatTable != "" {
+			return fmt.Errorf("conflicting job argument: Mode=%v has no replication lag, for SourceMaxLag, MaxLagMillisecondsThrottleThreshold or HeartbeatTable", m.Mode)
+		}
+		return nil
+	case models.JobModeIncrementalOnly:
+		if m.SkipIncrementalCopy {
+			return fmt.Errorf("conflicting job argument: Mode=%v and SkipIncrementalCopy", m.Mode)
+		}
+		if m.Gtid == "" && m.GtidStart == "" && m.StartPosition == "" && !m.AutoGtid {
+			return fmt.Errorf("Mode=%v needs Gtid, GtidStart or StartPosition=%v", m.Mode, StartPositionCurrent)
+		}
+		return nil
+	default:
+		return fmt.Errorf("bad Mode '%v'. Expect %v, %v or %v",
+			m.Mode, models.JobModeFull, models.JobModeCopyOnly, models.JobModeIncrementalOnly)
+	}
+}
+
+// ValidateConcurrentIncrementalCopy checks ConcurrentIncrementalCopy, which needs both the full
+// copy and the incremental replication.
+func (m *MySQLDriverConfig) ValidateConcurrentIncrementalCopy() error {
+	if m.ConcurrentCopyMaxBufferMB < 0 {
+		return fmt.Errorf("bad ConcurrentCopyMaxBufferMB %v: negative", m.ConcurrentCopyMaxBufferMB)
+	}
+	if !m.ConcurrentIncrementalCopy {
+		return nil
+	}
+	if m.Mode == models.JobModeCopyOnly || m.Mode == models.JobModeIncrementalOnly {
+		return fmt.Errorf("conflicting job argument: ConcurrentIncrementalCopy and Mode=%v", m.Mode)
+	}
+	if m.SkipIncrementalCopy {
+		return fmt.Errorf("conflicting job argument: ConcurrentIncrementalCopy and SkipIncrementalCopy")
+	}
+	return nil
+}
+
+// ValidateDumpMaxMBPerSec checks DumpMaxMBPerSec.
+func (m *MySQLDriverConfig) ValidateDumpMaxMBPerSec() error {
+	if m.DumpMaxMBPerSec < 0 {
+		return fmt.Errorf("bad DumpMaxMBPerSec %v: negative", m.DumpMaxMBPerSec)
+	}
+	return nil
+}
+
+// ValidateExport checks the options of the Export task.
+func (m *MySQLDriverConfig) ValidateExport() error {
+	switch m.ExportFrom {
+	case "", ExportFromSource, ExportFromTarget:
+	default:
+		return fmt.Errorf("bad ExportFrom '%v'. Expect %v or %v", m.ExportFrom, ExportFromSource, ExportFromTarget)
+	}
+	switch m.ExportFormat {
+	case "", ExportFormatCSV, ExportFormatParquet:
+	default:
+		return fmt.Errorf("bad ExportFormat '%v'. Expect %v or %v", m.ExportFormat, ExportFormatCSV, ExportFormatParquet)
+	}
+	if m.ExportFileSizeMB < 0 {
+		return fmt.Errorf("bad ExportFileSizeMB %v: negative", m.ExportFileSizeMB)
+	}
+	if _, err := m.ExportDir(""); err != nil {
+		return err
+	}
+	if m.EncryptDataAtRest {
+		return fmt.Errorf("conflicting job argument: EncryptDataAtRest is not supported by the Export task")
+	}
+	return m.ValidateDumpMaxMBPerSec()
+}
+
+// ExportDir returns the dir of the files of the Export task by ExportURI, in dataDir if empty.
+// Only the local dirs are supported.
+func (m *MySQLDriverConfig) ExportDir(dataDir string) (string, error) {
+	if m.ExportURI == "" {
+		return filepath.Join(dataDir, "export"), nil
+	}
+	i := strings.Index(m.ExportURI, "://")
+	if i < 0 {
+		return m.ExportURI, nil
+	}
+	if scheme := m.ExportURI[:i]; scheme != "file" {
+		return "", fmt.Errorf("bad ExportURI '%v': scheme %v is not supported. Expect a path or a file:// URI",
+			m.ExportURI, scheme)
+	}
+	path := m.ExportURI[i+len("://"):]
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("bad ExportURI '%v': expect an absolute path", m.ExportURI)
+	}
+	return path, nil
+}
+
+// TargetTableFilters returns ReplicateDoDb and ReplicateIgnoreDb by the names on the target of
+// their tables, for ExportFromTarget, without the renames. The tables renamed by a regex are not
+// supported.
+func (m *MySQLDriverConfig) TargetTableFilters() (doDbs, ignoreDbs []*DataSource, err error) {
+	for _, db := range m.ReplicateDoDb {
+		if db.TableSchemaRegex != "" {
+			return nil, nil, fmt.Errorf("ExportFrom=%v: TableSchemaRegex '%v' is not supported", ExportFromTarget, db.TableSchemaRegex)
+		}
+		target := &DataSource{TableSchema: m.TargetSchema(db.TableSchema)}
+		for _, tb := range db.Tables {
+			if tb.TableRegex != "" {
+				return nil, nil, fmt.Errorf("ExportFrom=%v: TableRegex '%v' is not supported", ExportFromTarget, tb.TableRegex)
+			}
+			targetTb := &Table{TableName: tb.TableName, Where: tb.Where}
+			if tb.TableRename != "" {
+				targetTb.TableName = tb.TableRename
+			}
+			target.Tables = append(target.Tables, targetTb)
+		}
+		doDbs = append(doDbs, target)
+	}
+	for _, db := range m.ReplicateIgnoreDb {
+		target := &DataSource{TableSchema: m.MapSchema(db.TableSchema)}
+		for _, tb := range db.Tables {
+			target.Tables = append(target.Tables, &Table{TableName: tb.TableName})
+		}
+		ignoreDbs = append(ignoreDbs, target)
+	}
+	return doDbs, ignoreDbs, nil
+}
+
+// ValidateCompression checks DumpCompression and IncrementalCompression.
+func (m *MySQLDriverConfig) ValidateCompression() error {
+	for _, c := range []string{m.DumpCompression, m.IncrementalCompression} {
+		switch c {
+		case "", CompressionSnappy, CompressionGzip, CompressionNone:
+		default:
+			return fmt.Errorf("bad compression '%v'. Expect %v, %v or %v", c, CompressionSnappy, CompressionGzip, CompressionNone)
+		}
+	}
+	return nil
+}
+
+// ValidateCodec checks Codec.
+func (m *MySQLDriverConfig) ValidateCodec() error {
+	switch m.Codec {
+	case "", CodecAuto, CodecV1:
+		return nil
+	default:
+		return fmt.Errorf("bad codec '%v'. Expect %v or %v", m.Codec, CodecAuto, CodecV1)
+	}
+}
+
+// ValidateCharsetErrorPolicy checks CharsetErrorPolicy.
+func (m *MySQLDriverConfig) ValidateCharsetErrorPolicy() error {
+	switch m.CharsetErrorPolicy {
+	case "", CharsetErrorPolicyFail, CharsetErrorPolicyReplace:
+		return nil
+	default:
+		return fmt.Errorf("bad CharsetErrorPolicy '%v'. Expect %v or %v",
+			m.CharsetErrorPolicy, CharsetErrorPolicyFail, CharsetErrorPolicyReplace)
+	}
+}
+
+// ValidateZeroDatePolicy checks ZeroDatePolicy.
+func (m *MySQLDriverConfig) ValidateZeroDatePolicy() error {
+	switch m.ZeroDatePolicy {
+	case "", ZeroDatePolicyFail, ZeroDatePolicyPreserve, ZeroDatePolicyNull, ZeroDatePolicyEpoch:
+		return nil
+	default:
+		return fmt.Errorf("bad ZeroDatePolicy '%v'. Expect %v, %v, %v or %v", m.ZeroDatePolicy,
+			ZeroDatePolicyFail, ZeroDatePolicyPreserve, ZeroDatePolicyNull, ZeroDatePolicyEpoch)
+	}
+}
+
+// ValidateSocks5Proxy checks the Socks5Proxy of ConnectionConfig.
+func (m *MySQLDriverConfig) ValidateSocks5Proxy() error {
+	if m.ConnectionConfig == nil {
+		return nil
+	}
+	return m.ConnectionConfig.ValidateSocks5Proxy()
+}
+
+// ValidateConflictPolicy checks ConflictPolicy.
+func (m *MySQLDriverConfig) ValidateConflictPolicy() error {
+	switch m.ConflictPolicy {
+	case "", ConflictPolicyFail, ConflictPolicyIgnore, ConflictPolicyReplace, ConflictPolicyUpsert:
+		return nil
+	default:
+		return fmt.Errorf("bad ConflictPolicy '%v'. Expect %v, %v, %v or %v", m.ConflictPolicy,
+			ConflictPolicyFail, ConflictPolicyIgnore, ConflictPolicyReplace, ConflictPolicyUpsert)
+	}
+}
+
+// ValidateXaPolicy checks XaPolicy.
+func (m *MySQLDriverConfig) ValidateXaPolicy() error {
+	switch m.XaPolicy {
+	case "", XaPolicyLocal, XaPolicyTarget:
+		return nil
+	default:
+		return fmt.Errorf("bad XaPolicy '%v'. Expect %v or %v", m.XaPolicy, XaPolicyLocal, XaPolicyTarget)
+	}
+}
+
+// ValidateExecTimeout checks MaxExecTime and ExecTimeoutPolicy.
+func (m *MySQLDriverConfig) ValidateExecTimeout() error {
+	if m.MaxExecTime < 0 {
+		return fmt.Errorf("bad MaxExecTime %v: negative", m.MaxExecTime)
+	}
+	switch m.ExecTimeoutPolicy {
+	case "", ExecTimeoutPolicyRetry, ExecTimeoutPolicyFail:
+		return nil
+	default:
+		return fmt.Errorf("bad ExecTimeoutPolicy '%v'. Expect %v or %v",
+			m.ExecTimeoutPolicy, ExecTimeoutPolicyRetry, ExecTimeoutPolicyFail)
+	}
+}
+
+// ValidateIgnoreErrorCodes checks that IgnoreErrorCodes are MySQL server error codes, other than
+// the ones the applier handles itself.
+func (m *MySQLDriverConfig) ValidateIgnoreErrorCodes() error {
+	for _, code := range m.IgnoreErrorCodes {
+		if code < 1000 || (code >= 2000 && code < 3000) {
+			return fmt.Errorf("bad IgnoreErrorCodes %v. Expect a server error code: 1000 - 1999, or 3000 and above", code)
+		}
+		if name, ok := unignorableErrorCodes[code]; ok {
+			return fmt.Errorf("bad IgnoreErrorCodes %v (%v). The applier handles it itself", code, name)
+		}
+	}
+	return nil
+}
+
+// RiskyIgnoreErrorCodes returns the codes of IgnoreErrorCodes which may hide a diverging target,
+// with their names, e.g. "1062 (ER_DUP_ENTRY)". None with IgnoreRiskyErrorCodes.
+func (m *MySQLDriverConfig) RiskyIgnoreErrorCodes() []string {
+	if m.IgnoreRiskyErrorCodes {
+		return nil
+	}
+	var risky []string
+	for _, code := range m.IgnoreErrorCodes {
+		if name, ok := riskyErrorCodes[code]; ok {
+			risky = append(risky, fmt.Sprintf("%v (%v)", code, name))
+		}
+	}
+	return risky
+}
+
+// ValidateInitSQL checks that each of InitSQL is a single SET statement: a connection of the task
+// runs several statements separated by ';' in one query.
+func (m *MySQLDriverConfig) ValidateInitSQL() error {
+	for _, query := range m.InitSQL {
+		stmt := strings.TrimSuffix(strings.TrimSpace(query), ";")
+		fields := strings.Fields(stmt)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "set") {
+			return fmt.Errorf("bad InitSQL '%v'. Expect a SET statement", query)
+		}
+		if strings.Contains(stmt, ";") {
+			return fmt.Errorf("bad InitSQL '%v'. Expect a single statement, without ';'", query)
+		}
+	}
+	return nil
+}
+
+// ValidateReplicaServerId checks that ReplicaServerId is out of the range of the derived server_ids.
+func (m *MySQLDriverConfig) ValidateReplicaServerId() error {
+	if m.ReplicaServerId > ReplicaServerIdMax {
+		return fmt.Errorf("bad ReplicaServerId %v. Expect 1 - %v, or 0 to derive it. %v - 4294967295 are reserved",
+			m.ReplicaServerId, ReplicaServerIdMax, derivedServerIdMin)
+	}
+	return nil
+}
+
+// EffectiveServerId returns the server_id of the extractor: ServerIdRetry after a collision,
+// ReplicaServerId, or the one derived from the node ID and the job ID.
+func (m *MySQLDriverConfig) EffectiveServerId(jobId string) uint32 {
+	if m.ServerIdRetry != 0 {
+		return m.ServerIdRetry
+	}
+	if m.ReplicaServerId != 0 {
+		return m.ReplicaServerId
+	}
+	return DeriveServerId(m.NodeId, jobId)
+}
+
+// DeriveServerId derives the server_id of the extractor of a job on a node, which stays the same
+// over the restarts of the task.
+func DeriveServerId(nodeId, jobId string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(nodeId))
+	h.Write([]byte{0})
+	h.Write([]byte(jobId))
+	return derivedServerIdMin | h.Sum32()
+}
+
+// RandomServerId returns a random server_id of the derived range, other than prev.
+func RandomServerId(prev uint32) uint32 {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		if id := derivedServerIdMin | r.Uint32(); id != prev {
+			return id
+		}
+	}
+}
+
+// MapSchema returns the name on the target of the source schema by TargetSchemaMap,
+// TargetSchemaPrefix and TargetSchemaSuffix.
+func (m *MySQLDriverConfig) MapSchema(schema string) string {
+	if target, ok := m.TargetSchemaMap[schema]; ok {
+		return target
+	}
+	switch strings.ToLower(schema) {
+	case "", "mysql", "sys", "information_schema", "performance_schema", strings.ToLower(g.DtleSchemaName):
+		return schema
+	}
+	return m.TargetSchemaPrefix + schema + m.TargetSchemaSuffix
+}
+
+// TargetSchema returns the name on the target of the source schema: its TableSchemaRename in
+// ReplicateDoDb, else by MapSchema.
+func (m *MySQLDriverConfig) TargetSchema(schema string) string {
+	for _, db := range m.ReplicateDoDb {
+		if db.TableSchema == schema && db.TableSchemaRename != "" {
+			return db.TableSchemaRename
+		}
+	}
+	return m.MapSchema(schema)
+}
+
+// ValidateTargetSchema checks that no two of the source schemas are renamed to the same schema
+// on the target, and returns the renamed ones, to their names on the target.
+func (m *MySQLDriverConfig) ValidateTargetSchema(schemas []string) (map[string]string, error) {
+	for schema, target := range m.TargetSchemaMap {
+		if schema == "" || target == "" {
+			return nil, fmt.Errorf("bad TargetSchemaMap '%v: %v': empty schema", schema, target)
+		}
+		schemas = append(schemas, schema)
+	}
+	renamed := make(map[string]string)
+	sources := make(map[string]string)
+	for _, schema := range schemas {
+		target := m.TargetSchema(schema)
+		if source, ok := sources[target]; ok && source != schema {
+			return nil, fmt.Errorf("conflicting schema mapping: both %v and %v are applied into %v on the target",
+				source, schema, target)
+		}
+		sources[target] = schema
+		if target != schema {
+			renamed[schema] = target
+		}
+	}
+	return renamed, nil
+}
+
+// ValidateHeartbeatTable checks HeartbeatTable, and returns its schema and table.
+func (m *MySQLDriverConfig) ValidateHeartbeatTable() (schema, table string, err error) {
+	if m.HeartbeatTable == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(m.HeartbeatTable, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("bad HeartbeatTable '%v'. Expect schema.table", m.HeartbeatTable)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ValidateUnsignedPolicy checks UnsignedPolicy.
+func (m *MySQLDriverConfig) ValidateUnsignedPolicy() error {
+	switch m.UnsignedPolicy {
+	case "", UnsignedPolicyFail, UnsignedPolicySigned:
+		return nil
+	default:
+		return fmt.Errorf("bad UnsignedPolicy '%v'. Expect %v or %v", m.UnsignedPolicy, UnsignedPolicyFail, UnsignedPolicySigned)
+	}
+}
+
+// MaskedPassword replaces the passwords in the task configs shown to the users.
+const MaskedPassword = "*"
+
+// RedactTaskConfig masks the secrets in a task config (as a map) shown to the users.
+// A secret reference is not a secret, and is kept.
+func RedactTaskConfig(taskConfig map[string]interface{}) {
+	if connCfgMap, ok := taskConfig["ConnectionConfig"].(map[string]interface{}); ok {
+		if pwd, ok := connCfgMap["Password"].(string); !ok || !umconf.IsSecretRef(pwd) {
+			connCfgMap["Password"] = MaskedPassword
+		}
+		if proxy, ok := connCfgMap["Socks5Proxy"].(string); ok {
+			connCfgMap["Socks5Proxy"] = socks5.Redact(proxy)
+		}
+	}
+}
+
 // RequiresBinlogFormatChange is `true` when the original binlog format isn't `ROW`
 func (m *MySQLDriverConfig) RequiresBinlogFormatChange() bool {
 	return m.BinlogFormat != "ROW"
@@ -324,6 +1426,10 @@ type Table struct {
 	RowsEstimate int64
 
 	Where string // TODO load from job description
+
+	// DmlFilter lists the DML types ("insert", "update", "delete") not to be applied to the table.
+	// It is set on the Dest task.
+	DmlFilter []string
 }
 
 type TableContext struct {
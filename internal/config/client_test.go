@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestMySQLDriverConfig_ValidateTargetSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *MySQLDriverConfig
+		schemas []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"none", &MySQLDriverConfig{}, []string{"app", "crm"}, map[string]string{}, false},
+		{"prefix", &MySQLDriverConfig{TargetSchemaPrefix: "tenant1_"}, []string{"app", "mysql"},
+			map[string]string{"app": "tenant1_app"}, false},
+		{"map-and-suffix", &MySQLDriverConfig{TargetSchemaSuffix: "_bak", TargetSchemaMap: map[string]string{"crm": "sales"}},
+			[]string{"app"}, map[string]string{"app": "app_bak", "crm": "sales"}, false},
+		{"rename-first", &MySQLDriverConfig{TargetSchemaPrefix: "tenant1_",
+			ReplicateDoDb: []*DataSource{{TableSchema: "app", TableSchemaRename: "app2"}}},
+			[]string{"app"}, map[string]string{"app": "app2"}, false},
+		{"collision", &MySQLDriverConfig{TargetSchemaMap: map[string]string{"crm": "app"}}, []string{"app"}, nil, true},
+		{"collision-by-prefix", &MySQLDriverConfig{TargetSchemaPrefix: "a_", TargetSchemaMap: map[string]string{"crm": "a_app"}},
+			[]string{"app"}, nil, true},
+		{"empty", &MySQLDriverConfig{TargetSchemaMap: map[string]string{"crm": ""}}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.ValidateTargetSchema(tt.schemas)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTargetSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateTargetSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLDriverConfig_ValidateMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *MySQLDriverConfig
+		wantErr bool
+	}{
+		{"default", &MySQLDriverConfig{}, false},
+		{"full", &MySQLDriverConfig{Mode: models.JobModeFull}, false},
+		{"full-skip", &MySQLDriverConfig{Mode: models.JobModeFull, SkipIncrementalCopy: true}, true},
+		{"copy-only", &MySQLDriverConfig{Mode: models.JobModeCopyOnly, SkipIncrementalCopy: true}, false},
+		{"copy-only-lag", &MySQLDriverConfig{Mode: models.JobModeCopyOnly, SourceMaxLag: 10}, true},
+		{"copy-only-heartbeat", &MySQLDriverConfig{Mode: models.JobModeCopyOnly, HeartbeatTable: "dtle.heartbeat"}, true},
+		{"copy-only-current", &MySQLDriverConfig{Mode: models.JobModeCopyOnly, StartPosition: StartPositionCurrent}, true},
+		{"incremental-only", &MySQLDriverConfig{Mode: models.JobModeIncrementalOnly}, true},
+		{"incremental-only-gtid", &MySQLDriverConfig{Mode: models.JobModeIncrementalOnly, Gtid: "a:1-10"}, false},
+		{"incremental-only-current", &MySQLDriverConfig{Mode: models.JobModeIncrementalOnly, StartPosition: StartPositionCurrent}, false},
+		{"incremental-only-skip", &MySQLDriverConfig{Mode: models.JobModeIncrementalOnly, GtidStart: "a:1",
+			SkipIncrementalCopy: true}, true},
+		{"bad", &MySQLDriverConfig{Mode: "copy"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.ValidateMode(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := (&MySQLDriverConfig{SkipIncrementalCopy: true, ConnectionConfig: &umconf.ConnectionConfig{}}).SetDefault(); got.Mode != models.JobModeCopyOnly {
+		t.Errorf("SetDefault().Mode = %v with SkipIncrementalCopy, want %v", got.Mode, models.JobModeCopyOnly)
+	}
+	if got := (&MySQLDriverConfig{Mode: models.JobModeCopyOnly, ConnectionConfig: &umconf.ConnectionConfig{}}).SetDefault(); !got.SkipIncrementalCopy {
+		t.Errorf("SetDefault().SkipIncrementalCopy = false with Mode=%v", models.JobModeCopyOnly)
+	}
+}
+
+func TestMySQLDriverConfig_ValidateConcurrentIncrementalCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *MySQLDriverConfig
+		wantErr bool
+	}{
+		{"default", &MySQLDriverConfig{}, false},
+		{"full", &MySQLDriverConfig{ConcurrentIncrementalCopy: true, Mode: models.JobModeFull}, false},
+		{"copy-only", &MySQLDriverConfig{ConcurrentIncrementalCopy: true, Mode: models.JobModeCopyOnly}, true},
+		{"skip", &MySQLDriverConfig{ConcurrentIncrementalCopy: true, SkipIncrementalCopy: true}, true},
+		{"incremental-only", &MySQLDriverConfig{ConcurrentIncrementalCopy: true, Mode: models.JobModeIncrementalOnly,
+			Gtid: "a:1-10"}, true},
+		{"negative-buffer", &MySQLDriverConfig{ConcurrentCopyMaxBufferMB: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.ValidateConcurrentIncrementalCopy(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConcurrentIncrementalCopy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMySQLDriverConfig_ValidateInitSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		initSQL []string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"set", []string{"SET SESSION sql_mode = ''", "set time_zone = '+00:00';"}, false},
+		{"not-set", []string{"DROP TABLE t"}, true},
+		{"empty", []string{" "}, true},
+		{"multi", []string{"SET time_zone = '+00:00'; DROP TABLE t"}, true},
+		{"multi-in-comment", []string{"SET @a = 1 /*!; DROP TABLE t */"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MySQLDriverConfig{InitSQL: tt.initSQL}
+			if err := m.ValidateInitSQL(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateInitSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMySQLDriverConfig_EffectiveServerId(t *testing.T) {
+	for _, id := range []uint32{0, 1, ReplicaServerIdMax} {
+		if err := (&MySQLDriverConfig{ReplicaServerId: id}).ValidateReplicaServerId(); err != nil {
+			t.Errorf("ValidateReplicaServerId() of %v: %v", id, err)
+		}
+	}
+	if err := (&MySQLDriverConfig{ReplicaServerId: derivedServerIdMin}).ValidateReplicaServerId(); err == nil {
+		t.Errorf("ValidateReplicaServerId() of %v in the reserved range: no error", derivedServerIdMin)
+	}
+
+	m := &MySQLDriverConfig{NodeId: "node1"}
+	derived := m.EffectiveServerId("job1")
+	if derived < derivedServerIdMin || derived != DeriveServerId("node1", "job1") {
+		t.Errorf("EffectiveServerId() = %v, want a stable derived server_id", derived)
+	}
+	if DeriveServerId("node1", "job2") == derived || DeriveServerId("node2", "job1") == derived {
+		t.Errorf("DeriveServerId() is the same for another job or node")
+	}
+	m.ReplicaServerId = 100
+	if got := m.EffectiveServerId("job1"); got != 100 {
+		t.Errorf("EffectiveServerId() = %v, want ReplicaServerId", got)
+	}
+	m.ServerIdRetry = RandomServerId(100)
+	if got := m.EffectiveServerId("job1"); got != m.ServerIdRetry || got < derivedServerIdMin {
+		t.Errorf("EffectiveServerId() = %v after a collision, want %v", got, m.ServerIdRetry)
+	}
+}
+
+func TestMySQLDriverConfig_ValidateIgnoreErrorCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		codes   []uint16
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"server", []uint16{1644, 1062, 3819}, false},
+		{"client", []uint16{2013}, true},
+		{"below", []uint16{999}, true},
+		{"deadlock", []uint16{1213}, true},
+		{"interrupted", []uint16{1644, 1317}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MySQLDriverConfig{IgnoreErrorCodes: tt.codes}
+			if err := m.ValidateIgnoreErrorCodes(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateIgnoreErrorCodes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	m := &MySQLDriverConfig{IgnoreErrorCodes: []uint16{1644, 1062, 1452}}
+	if got := m.RiskyIgnoreErrorCodes(); !reflect.DeepEqual(got, []string{"1062 (ER_DUP_ENTRY)", "1452 (ER_NO_REFERENCED_ROW_2)"}) {
+		t.Errorf("RiskyIgnoreErrorCodes() = %v", got)
+	}
+	m.IgnoreRiskyErrorCodes = true
+	if got := m.RiskyIgnoreErrorCodes(); got != nil {
+		t.Errorf("RiskyIgnoreErrorCodes() = %v with IgnoreRiskyErrorCodes, want none", got)
+	}
+}
+
+func TestMySQLDriverConfig_ValidateExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       *MySQLDriverConfig
+		wantDir string
+		wantErr bool
+	}{
+		{"default", &MySQLDriverConfig{}, "/data/export", false},
+		{"parquet", &MySQLDriverConfig{ExportFrom: ExportFromTarget, ExportFormat: ExportFormatParquet}, "/data/export", false},
+		{"bad-from", &MySQLDriverConfig{ExportFrom: "replica"}, "", true},
+		{"bad-format", &MySQLDriverConfig{ExportFormat: "json"}, "", true},
+		{"negative-size", &MySQLDriverConfig{ExportFileSizeMB: -1}, "", true},
+		{"negative-rate", &MySQLDriverConfig{DumpMaxMBPerSec: -1}, "", true},
+		{"path", &MySQLDriverConfig{ExportURI: "/backup/db1"}, "/backup/db1", false},
+		{"file", &MySQLDriverConfig{ExportURI: "file:///backup/db1"}, "/backup/db1", false},
+		{"relative-file", &MySQLDriverConfig{ExportURI: "file://backup"}, "", true},
+		{"s3", &MySQLDriverConfig{ExportURI: "s3://bucket/db1"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.ValidateExport(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateExport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if dir, _ := tt.m.ExportDir("/data"); !tt.wantErr && dir != tt.wantDir {
+				t.Errorf("ExportDir() = %v, want %v", dir, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestMySQLDriverConfig_TargetTableFilters(t *testing.T) {
+	m := &MySQLDriverConfig{
+		ReplicateDoDb: []*DataSource{
+			{TableSchema: "db1", TableSchemaRename: "db1_new", Tables: []*Table{
+				{TableName: "t1", TableRename: "t1_new"},
+				{TableName: "t2", Where: "id > 1"},
+			}},
+			{TableSchema: "db2"},
+		},
+		ReplicateIgnoreDb:  []*DataSource{{TableSchema: "db3", Tables: []*Table{{TableName: "t3"}}}},
+		TargetSchemaPrefix: "tenant1_",
+	}
+	doDbs, ignoreDbs, err := m.TargetTableFilters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*DataSource{
+		{TableSchema: "db1_new", Tables: []*Table{{TableName: "t1_new"}, {TableName: "t2", Where: "id > 1"}}},
+		{TableSchema: "tenant1_db2"},
+	}
+	if !reflect.DeepEqual(doDbs, want) {
+		t.Errorf("TargetTableFilters() ReplicateDoDb = %+v, want %+v", doDbs, want)
+	}
+	if len(ignoreDbs) != 1 || ignoreDbs[0].TableSchema != "tenant1_db3" || ignoreDbs[0].Tables[0].TableName != "t3" {
+		t.Errorf("TargetTableFilters() ReplicateIgnoreDb = %+v", ignoreDbs)
+	}
+
+	m.ReplicateDoDb = []*DataSource{{TableSchemaRegex: "db(.*)", TableSchemaRename: "new$1"}}
+	if _, _, err := m.TargetTableFilters(); err == nil {
+		t.Errorf("TargetTableFilters() of a TableSchemaRegex: no error")
+	}
+}
@@ -154,6 +154,10 @@ func (a *ConsulConfig) Merge(b *ConsulConfig) *ConsulConfig {
 // hashicorp/consul/api.  NOTE: datacenter is not set
 func (c *ConsulConfig) ApiConfig() (*consul.Config, error) {
 	config := consul.DefaultConfig()
+	if config.HttpClient == nil {
+		// the vendored api only builds it in NewClient
+		config.HttpClient = &http.Client{Transport: config.Transport}
+	}
 	if c.Addr != "" {
 		config.Address = c.Addr
 	}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Values of EventFilterRule.Action
+const (
+	EventFilterActionDrop = "drop"
+	EventFilterActionPass = "pass"
+)
+
+// Values of EventFilterRule.Operations
+const (
+	EventFilterOpInsert = "insert"
+	EventFilterOpUpdate = "update"
+	EventFilterOpDelete = "delete"
+	EventFilterOpDDL    = "ddl"
+)
+
+// EventFilterRule matches binlog events of the source. The rules of a job are evaluated in
+// order, and the first matching one decides whether the event is dropped or passed.
+// An event matching no rule is passed.
+type EventFilterRule struct {
+	// Operations are some of "insert", "update", "delete" and "ddl". Empty for all.
+	Operations []string
+	// Table is "schema.table" on the source. Both parts may have wildcards ("*", "?", "[a-z]").
+	// Empty for all tables.
+	Table string
+	// StatementRegex matches the statement text: the query of a DDL, or the ROWS_QUERY event
+	// of a DML (only logged with binlog_rows_query_log_events=ON). A DML without the statement
+	// never matches a rule with StatementRegex.
+	StatementRegex string
+	// Action is "drop" or "pass".
+	Action string
+}
+
+// ValidateEventFilters checks the rules before the job is registered.
+func ValidateEventFilters(rules []*EventFilterRule) error {
+	for i, rule := range rules {
+		if rule == nil {
+			return fmt.Errorf("EventFilters[%v]: empty rule", i)
+		}
+		for _, op := range rule.Operations {
+			switch strings.ToLower(op) {
+			case EventFilterOpInsert, EventFilterOpUpdate, EventFilterOpDelete, EventFilterOpDDL:
+			default:
+				return fmt.Errorf("EventFilters[%v]: unknown operation %q. use insert, update, delete or ddl", i, op)
+			}
+		}
+		if rule.Table != "" {
+			parts := strings.Split(rule.Table, ".")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("EventFilters[%v]: table %q is not schema.table", i, rule.Table)
+			}
+			for _, part := range parts {
+				if _, err := path.Match(part, ""); err != nil {
+					return fmt.Errorf("EventFilters[%v]: bad table pattern %q: %v", i, rule.Table, err)
+				}
+			}
+		}
+		if rule.StatementRegex != "" {
+			if _, err := regexp.Compile(rule.StatementRegex); err != nil {
+				return fmt.Errorf("EventFilters[%v]: bad StatementRegex: %v", i, err)
+			}
+		}
+		switch strings.ToLower(rule.Action) {
+		case EventFilterActionDrop, EventFilterActionPass:
+		default:
+			return fmt.Errorf("EventFilters[%v]: unknown action %q. use drop or pass", i, rule.Action)
+		}
+	}
+	return nil
+}
+
+// EventFiltersMayDropDDL returns whether some rule may drop DDLs, which then do not reach the
+// applier.
+func EventFiltersMayDropDDL(rules []*EventFilterRule) bool {
+	for _, rule := range rules {
+		if rule == nil || strings.ToLower(rule.Action) != EventFilterActionDrop {
+			continue
+		}
+		if len(rule.Operations) == 0 {
+			return true
+		}
+		for _, op := range rule.Operations {
+			if strings.ToLower(op) == EventFilterOpDDL {
+				return true
+			}
+		}
+	}
+	return false
+}
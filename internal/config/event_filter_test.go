@@ -0,0 +1,39 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import "testing"
+
+func TestValidateEventFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []*EventFilterRule
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"all", []*EventFilterRule{{Action: "drop"}}, false},
+		{"full", []*EventFilterRule{
+			{Operations: []string{"Insert", "ddl"}, Table: "db?.t_*", StatementRegex: `^(?i)truncate`, Action: "DROP"},
+			{Table: "db1.[a-c]*", Action: "pass"},
+		}, false},
+		{"nil-rule", []*EventFilterRule{nil}, true},
+		{"bad-op", []*EventFilterRule{{Operations: []string{"replace"}, Action: "drop"}}, true},
+		{"no-schema", []*EventFilterRule{{Table: "t1", Action: "drop"}}, true},
+		{"empty-table", []*EventFilterRule{{Table: "db1.", Action: "drop"}}, true},
+		{"dotted", []*EventFilterRule{{Table: "db1.t1.c1", Action: "drop"}}, true},
+		{"bad-pattern", []*EventFilterRule{{Table: "db1.[t", Action: "drop"}}, true},
+		{"bad-regex", []*EventFilterRule{{StatementRegex: "(", Action: "drop"}}, true},
+		{"no-action", []*EventFilterRule{{Table: "db1.t1"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateEventFilters(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEventFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -8,6 +8,29 @@ package mysql
 
 import (
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+
+	"github.com/actiontech/dtle/internal/resolver"
+	"github.com/actiontech/dtle/internal/socks5"
+)
+
+const (
+	// SecretEnvPrefix refers to an environment variable of the client, e.g. "env://MYSQL_PWD".
+	SecretEnvPrefix = "env://"
+	// SecretFilePrefix refers to a file on the client, e.g. "file:///etc/dtle/mysql_pwd".
+	SecretFilePrefix = "file://"
+
+	// dialTimeout is the timeout of each address of a server dialed with UseResolver, as the
+	// timeout of the DSNs.
+	dialTimeout = 5 * time.Second
 )
 
 // ConnectionConfig is the minimal configuration required to connect to a MySQL server
@@ -17,19 +40,134 @@ type ConnectionConfig struct {
 	User     string
 	Password string
 	Charset  string
+	// Socks5Proxy is the SOCKS5 proxy to connect through, "[socks5://][user[:password]@]host:port".
+	// Empty for a direct connection.
+	Socks5Proxy string
 }
 
 func (c *ConnectionConfig) GetDBUriByDbName(databaseName string) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%v&maxAllowedPacket=0", c.User, c.Password, c.Host, c.Port, databaseName, c.Charset)
+	return fmt.Sprintf("%s:%s@%s(%s:%d)/%s?charset=%v&maxAllowedPacket=0", c.User, c.Password, c.network(), c.Host, c.Port, databaseName, c.Charset)
 }
 
 func (c *ConnectionConfig) GetDBUri() string {
 	if "" == c.Charset {
 		c.Charset = "utf8mb4"
 	}
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=5s&tls=false&autocommit=true&charset=%v&multiStatements=true&maxAllowedPacket=0", c.User, c.Password, c.Host, c.Port, c.Charset)
+	return fmt.Sprintf("%s:%s@%s(%s:%d)/?timeout=5s&tls=false&autocommit=true&charset=%v&multiStatements=true&maxAllowedPacket=0", c.User, c.Password, c.network(), c.Host, c.Port, c.Charset)
 }
 
 func (c *ConnectionConfig) GetSingletonDBUri() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=5s&tls=false&autocommit=false&charset=%v&multiStatements=true&maxAllowedPacket=0", c.User, c.Password, c.Host, c.Port, c.Charset)
+	return fmt.Sprintf("%s:%s@%s(%s:%d)/?timeout=5s&tls=false&autocommit=false&charset=%v&multiStatements=true&maxAllowedPacket=0", c.User, c.Password, c.network(), c.Host, c.Port, c.Charset)
+}
+
+// Addr returns "host:port" of the server.
+func (c *ConnectionConfig) Addr() string {
+	return net.JoinHostPort(c.Host, fmt.Sprint(c.Port))
+}
+
+// ValidateSocks5Proxy checks the form of Socks5Proxy.
+func (c *ConnectionConfig) ValidateSocks5Proxy() error {
+	if c.Socks5Proxy == "" {
+		return nil
+	}
+	_, err := socks5.Parse(c.Socks5Proxy)
+	return err
+}
+
+var (
+	socks5NetsLock sync.Mutex
+	// registered networks of the driver, by proxy
+	socks5Nets = make(map[string]string)
+)
+
+// network returns the network of the DSN: "tcp", or for a proxied connection, a
+// network registered to the driver to dial through the proxy. The driver dials
+// with it for every new connection of the pool, so the reconnections go through
+// the proxy too, each bounded by the timeout of the proxy.
+func (c *ConnectionConfig) network() string {
+	if c.Socks5Proxy == "" {
+		return "tcp"
+	}
+	socks5NetsLock.Lock()
+	defer socks5NetsLock.Unlock()
+	if name, ok := socks5Nets[c.Socks5Proxy]; ok {
+		return name
+	}
+	h := fnv.New64a()
+	h.Write([]byte(c.Socks5Proxy))
+	name := fmt.Sprintf("socks5-%x", h.Sum64())
+	d, err := socks5.Parse(c.Socks5Proxy)
+	if err != nil {
+		// not validated. fail the connections
+		gomysql.RegisterDial(name, func(addr string) (net.Conn, error) {
+			return nil, err
+		})
+	} else {
+		gomysql.RegisterDial(name, d.Dial)
+	}
+	socks5Nets[c.Socks5Proxy] = name
+	return name
+}
+
+// UseResolver makes the driver dial the direct connections ("tcp") with r, which resolves the
+// host again for every new connection of a pool once its TTL expires. The proxied connections
+// are resolved by the proxy.
+func UseResolver(r *resolver.Resolver) {
+	gomysql.RegisterDial("tcp", func(addr string) (net.Conn, error) {
+		return r.Dial(addr, dialTimeout)
+	})
+}
+
+// IsSecretRef tells whether a credential is a reference to be resolved on the client.
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, SecretEnvPrefix) || strings.HasPrefix(s, SecretFilePrefix)
+}
+
+// SecretResolveError is returned when a secret reference in the task config cannot be resolved.
+type SecretResolveError struct {
+	Field string
+	Ref   string
+	Err   error
+}
+
+func (e *SecretResolveError) Error() string {
+	return fmt.Sprintf("cannot resolve %v from %v: %v", e.Field, e.Ref, e.Err)
+}
+
+// ResolveSecret returns the value referred by s, or s itself if it is not a reference.
+// A file is read as a whole, with the trailing line break trimmed.
+func ResolveSecret(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, SecretEnvPrefix):
+		name := strings.TrimPrefix(s, SecretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(s, SecretFilePrefix):
+		bs, err := ioutil.ReadFile(strings.TrimPrefix(s, SecretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(bs), "\r\n"), nil
+	default:
+		return s, nil
+	}
+}
+
+// ResolveSecrets replaces the credential references with the values.
+// It is called on the client, at task start. The server keeps the references.
+func (c *ConnectionConfig) ResolveSecrets() error {
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{{"User", &c.User}, {"Password", &c.Password}} {
+		value, err := ResolveSecret(*field.value)
+		if err != nil {
+			return &SecretResolveError{Field: field.name, Ref: *field.value, Err: err}
+		}
+		*field.value = value
+	}
+	return nil
 }
@@ -7,9 +7,14 @@
 package mysql
 
 import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
 )
 
 type charsetEncoding map[string]encoding.Encoding
@@ -23,3 +28,84 @@ func init() {
 	charsetEncodingMap["gbk"] = simplifiedchinese.GBK
 	charsetEncodingMap["gb2312"] = simplifiedchinese.GB18030
 }
+
+// DecodeString decodes s, in charset, to UTF-8. s is returned as is for a charset
+// without a known encoding, e.g. utf8mb4 or binary.
+func DecodeString(s, charset string) (string, error) {
+	enc, ok := charsetEncodingMap[charset]
+	if !ok {
+		return s, nil
+	}
+	decoded, _, err := transform.String(enc.NewDecoder(), s)
+	if err != nil {
+		return s, fmt.Errorf("decode %v string: %v", charset, err)
+	}
+	return decoded, nil
+}
+
+// UntranscodableError is returned by TranscodeString for a character a charset cannot store.
+type UntranscodableError struct {
+	Charset string
+	// Char is utf8.RuneError for an invalid UTF-8 sequence.
+	Char rune
+}
+
+func (e *UntranscodableError) Error() string {
+	if e.Char == utf8.RuneError {
+		return fmt.Sprintf("invalid UTF-8 sequence cannot be stored in charset %v", e.Charset)
+	}
+	return fmt.Sprintf("character %q (%U) cannot be stored in charset %v", e.Char, e.Char, e.Charset)
+}
+
+// TranscodeString checks that the UTF-8 string s can be stored in a column of charset.
+// With replace, the characters which cannot be stored, and the invalid UTF-8 sequences,
+// are replaced by '?' as MySQL does. Otherwise an UntranscodableError is returned.
+// The result is still UTF-8, converted to charset by the server.
+func TranscodeString(s, charset string, replace bool) (string, error) {
+	var fits func(r rune) bool
+	switch charset {
+	case "", "utf8mb4", "binary":
+		fits = func(r rune) bool { return true }
+	case "utf8", "utf8mb3":
+		fits = func(r rune) bool { return r <= 0xFFFF }
+	default:
+		enc, ok := charsetEncodingMap[charset]
+		if !ok {
+			// unknown to us. Left to the server.
+			return s, nil
+		}
+		if _, err := enc.NewEncoder().String(s); err == nil {
+			return s, nil
+		}
+		fits = func(r rune) bool {
+			_, err := enc.NewEncoder().String(string(r))
+			return err == nil
+		}
+	}
+
+	var buf *strings.Builder
+	for i, r := range s {
+		width := utf8.RuneLen(r)
+		if r == utf8.RuneError {
+			_, width = utf8.DecodeRuneInString(s[i:])
+		}
+		if (r != utf8.RuneError || width != 1) && fits(r) {
+			if buf != nil {
+				buf.WriteString(s[i : i+width])
+			}
+			continue
+		}
+		if !replace {
+			return s, &UntranscodableError{Charset: charset, Char: r}
+		}
+		if buf == nil {
+			buf = &strings.Builder{}
+			buf.WriteString(s[:i])
+		}
+		buf.WriteByte('?')
+	}
+	if buf == nil {
+		return s, nil
+	}
+	return buf.String(), nil
+}
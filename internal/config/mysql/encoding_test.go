@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestDecodeString(t *testing.T) {
+	tests := []struct {
+		s       string
+		charset string
+		want    string
+	}{
+		{"caf\xe9 \xc0 la cr\xe8me", "latin1", "café À la crème"},
+		{"\x80 \xff", "latin1", "€ ÿ"},
+		{"café", "utf8mb4", "café"},
+		{"\xe9", "binary", "\xe9"},
+	}
+	for _, tt := range tests {
+		got, err := DecodeString(tt.s, tt.charset)
+		if err != nil || got != tt.want {
+			t.Errorf("DecodeString(%q, %v) = %q, %v, want %q", tt.s, tt.charset, got, err, tt.want)
+		}
+	}
+}
+
+func TestTranscodeString(t *testing.T) {
+	tests := []struct {
+		s        string
+		charset  string
+		want     string
+		wantChar rune // of the error without replace. 0 for none
+	}{
+		{"café €", "utf8mb4", "café €", 0},
+		{"café 😀", "utf8mb4", "café 😀", 0},
+		{"café 😀", "utf8", "café ?", '😀'},
+		{"café €", "latin1", "café €", 0},
+		{"café 中文", "latin1", "café ??", '中'},
+		{"中文", "gbk", "中文", 0},
+		{"caf\xe9", "utf8mb4", "caf?", '�'},
+		{"caf\xe9", "some_charset", "caf\xe9", 0},
+	}
+	for _, tt := range tests {
+		got, err := TranscodeString(tt.s, tt.charset, true)
+		if err != nil || got != tt.want {
+			t.Errorf("TranscodeString(%q, %v, replace) = %q, %v, want %q", tt.s, tt.charset, got, err, tt.want)
+		}
+		got, err = TranscodeString(tt.s, tt.charset, false)
+		if tt.wantChar == 0 {
+			if err != nil || got != tt.s {
+				t.Errorf("TranscodeString(%q, %v) = %q, %v, want it unchanged", tt.s, tt.charset, got, err)
+			}
+			continue
+		}
+		if uErr, ok := err.(*UntranscodableError); !ok || uErr.Char != tt.wantChar || uErr.Charset != tt.charset {
+			t.Errorf("TranscodeString(%q, %v) error = %v, want %q untranscodable", tt.s, tt.charset, err, tt.wantChar)
+		}
+	}
+}
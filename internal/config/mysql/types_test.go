@@ -38,3 +38,22 @@ func TestGetColumn(t *testing.T) {
 		test.S(t).ExpectTrue(column == nil)
 	}
 }
+
+func TestColumnConvertArg_unsigned(t *testing.T) {
+	// the max values of the UNSIGNED columns, as the binlog has them
+	tests := []struct {
+		column Column
+		arg    interface{}
+		want   interface{}
+	}{
+		{Column{Type: TinyintColumnType, IsUnsigned: true}, int8(-1), uint8(255)},
+		{Column{Type: SmallintColumnType, IsUnsigned: true}, int16(-1), uint16(65535)},
+		{Column{Type: MediumIntColumnType, IsUnsigned: true}, int32(-1), uint32(16777215)},
+		{Column{Type: IntColumnType, IsUnsigned: true}, int32(-1), uint32(4294967295)},
+		{Column{Type: BigIntColumnType, IsUnsigned: true}, int64(-1), "18446744073709551615"},
+		{Column{Type: BigIntColumnType}, int64(-1), int64(-1)},
+	}
+	for _, tt := range tests {
+		test.S(t).ExpectEquals(tt.column.ConvertArg(tt.arg), tt.want)
+	}
+}
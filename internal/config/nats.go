@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	gnatsd "github.com/nats-io/gnatsd/server"
+	gonats "github.com/nats-io/go-nats"
+)
+
+// NatsAuthConfig is the authentication of the nats server embedded in the agent, and of
+// the connections of the tasks. A task connects to the nats server of another agent,
+// so all the agents must have the same one. At most one of token and user is set.
+type NatsAuthConfig struct {
+	Token    string
+	User     string
+	Password string
+	Nkey     string
+}
+
+// Enabled is nil-safe.
+func (c *NatsAuthConfig) Enabled() bool {
+	return c != nil && (c.Token != "" || c.User != "" || c.Nkey != "")
+}
+
+func (c *NatsAuthConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Nkey != "" {
+		return fmt.Errorf("nats nkey authentication is not supported by the embedded nats server. use a token or a user")
+	}
+	if c.Token != "" && c.User != "" {
+		return fmt.Errorf("only one of nats token and nats user can be set")
+	}
+	if c.User != "" && c.Password == "" {
+		return fmt.Errorf("nats password is required with nats user")
+	}
+	if c.User == "" && c.Password != "" {
+		return fmt.Errorf("nats user is required with nats password")
+	}
+	return nil
+}
+
+// ApplyServerOptions sets the authentication of the embedded nats server.
+func (c *NatsAuthConfig) ApplyServerOptions(opts *gnatsd.Options) {
+	if !c.Enabled() {
+		return
+	}
+	opts.Authorization = c.Token
+	opts.Username = c.User
+	opts.Password = c.Password
+}
+
+// Connect connects to the nats server with the authentication. c might be nil.
+func (c *NatsAuthConfig) Connect(natsAddr string) (*gonats.Conn, error) {
+	var options []gonats.Option
+	if c.Enabled() {
+		if c.Token != "" {
+			options = append(options, gonats.Token(c.Token))
+		} else {
+			options = append(options, gonats.UserInfo(c.User, c.Password))
+		}
+	}
+	nc, err := gonats.Connect(natsAddr, options...)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "authorization") {
+		return nil, fmt.Errorf("nats authentication to %v failed (authentication enabled: %v). "+
+			"the nats auth settings must be the same on all the agents: %v", natsAddr, c.Enabled(), err)
+	}
+	return nc, err
+}
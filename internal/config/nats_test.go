@@ -0,0 +1,32 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package config
+
+import "testing"
+
+func TestNatsAuthConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *NatsAuthConfig
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"token", &NatsAuthConfig{Token: "t"}, false},
+		{"user", &NatsAuthConfig{User: "u", Password: "p"}, false},
+		{"token-and-user", &NatsAuthConfig{Token: "t", User: "u", Password: "p"}, true},
+		{"no-password", &NatsAuthConfig{User: "u"}, true},
+		{"no-user", &NatsAuthConfig{Password: "p"}, true},
+		{"nkey", &NatsAuthConfig{Nkey: "UABC"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("NatsAuthConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -79,6 +79,7 @@ func TestWhereTrueText(t *testing.T) {
 	var tbCtx *TableContext
 
 	tbCtx = newTableContextWithWhere(t, "db1", "tb1", "a = 'hello'", "id", "a")
+	tbCtx.Table.OriginalTableColumns.SetColumnType("a", mysql.TextColumnType)
 	r, err := tbCtx.WhereTrue(buildColumnValues(1, []byte("hello")))
 	if err != nil {
 		t.Fatal(err)
@@ -87,8 +88,7 @@ func TestWhereTrueText(t *testing.T) {
 		t.Fatalf("it is hello")
 	}
 
-	tbCtx = newTableContextWithWhere(t, "db1", "tb1", "a = 'hello'", "id", "a")
-	r, err = tbCtx.WhereTrue(buildColumnValues(2, "hello2"))
+	r, err = tbCtx.WhereTrue(buildColumnValues(2, []byte("hello2")))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) error = nil, want an error", spec)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2018, 5, 10, 1, 59, 30, 0, time.UTC), time.Date(2018, 5, 10, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2018, 5, 10, 2, 0, 0, 0, time.UTC), time.Date(2018, 5, 11, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, 5, 10, 1, 16, 0, 0, time.UTC), time.Date(2018, 5, 10, 1, 30, 0, 0, time.UTC)},
+		{"30 23 31 * *", time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 5, 31, 23, 30, 0, 0, time.UTC)},
+		{"0 0 * * sat,sun", time.Date(2018, 5, 10, 0, 0, 0, 0, time.UTC), time.Date(2018, 5, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, 5, 10, 0, 0, 0, 0, time.UTC), time.Date(2018, 5, 13, 0, 0, 0, 0, time.UTC)},
+		// the day of month or the day of week
+		{"0 0 1 * mon", time.Date(2018, 5, 10, 0, 0, 0, 0, time.UTC), time.Date(2018, 5, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2018, 12, 15, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2018, 5, 10, 17, 30, 0, 0, time.UTC).In(shanghai), time.Date(2018, 5, 11, 2, 0, 0, 0, shanghai)},
+		{"0 0 30 2 *", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.spec, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next(%v) = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package faults injects failures at named points of the client, to test its
+// resilience: errors, latency, or dropping every Nth call. The injection is
+// enabled by the fault_injection option of the agent and controlled at runtime
+// by /v1/agent/faults. When it is not enabled, a point costs a nil check.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The injection points.
+const (
+	// before an RPC of the client is sent to the servers
+	RPCBeforeSend = "rpc.before_send"
+	// before the extractor publishes a message to the applier
+	NatsPublish = "nats.publish"
+	// before the applier commits a transaction
+	ApplierCommit = "applier.commit"
+	// before the extractor reads a binlog event
+	ExtractorReadEvent = "extractor.read_event"
+)
+
+// Points lists the injection points.
+var Points = []string{RPCBeforeSend, NatsPublish, ApplierCommit, ExtractorReadEvent}
+
+// ErrDropped is returned for a dropped call. A dropped publish is lost, as if
+// its ack timed out. The other calls fail with it.
+var ErrDropped = errors.New("faults: call dropped")
+
+// Fault is what is injected at a point. The zero Fault injects nothing.
+type Fault struct {
+	// Error is the message of the error each call fails with
+	Error string
+	// Latency is added to each call, before it fails if it does
+	Latency time.Duration
+	// DropEvery drops every DropEvery-th call. 0 for none
+	DropEvery int64
+}
+
+// PointStat is the fault set at a point and the calls since it was set.
+type PointStat struct {
+	Fault
+	Calls int64
+	// calls with a fault injected
+	Injected int64
+}
+
+// Injector holds the faults of the points.
+type Injector struct {
+	l      sync.Mutex
+	points map[string]*PointStat
+}
+
+// NewInjector returns an injector without faults.
+func NewInjector() *Injector {
+	return &Injector{points: make(map[string]*PointStat)}
+}
+
+// active is nil unless the injection is enabled.
+var active *Injector
+
+// Enable enables the injection and returns the injector. It is called when the
+// agent starts, before any point is reached.
+func Enable() *Injector {
+	if active == nil {
+		active = NewInjector()
+	}
+	return active
+}
+
+// Disable disables the injection. For tests.
+func Disable() {
+	active = nil
+}
+
+// Active returns the injector, or nil if the injection is not enabled.
+func Active() *Injector {
+	return active
+}
+
+// Inject injects the fault set at point, if the injection is enabled.
+func Inject(point string) error {
+	if active == nil {
+		return nil
+	}
+	return active.Inject(point)
+}
+
+// Inject waits for the latency of the fault set at point, then returns ErrDropped
+// for a dropped call, or the error of the fault.
+func (i *Injector) Inject(point string) error {
+	i.l.Lock()
+	p, ok := i.points[point]
+	if !ok {
+		i.l.Unlock()
+		return nil
+	}
+	p.Calls++
+	f := p.Fault
+	drop := f.DropEvery > 0 && p.Calls%f.DropEvery == 0
+	if drop || f.Error != "" || f.Latency > 0 {
+		p.Injected++
+	}
+	i.l.Unlock()
+
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	if drop {
+		return ErrDropped
+	}
+	if f.Error != "" {
+		return fmt.Errorf("faults: %v: %v", point, f.Error)
+	}
+	return nil
+}
+
+// Set sets the fault of point, resetting its counts.
+func (i *Injector) Set(point string, f Fault) error {
+	if !isPoint(point) {
+		return fmt.Errorf("unknown injection point %q. valid: %v", point, Points)
+	}
+	if f.Latency < 0 || f.DropEvery < 0 {
+		return fmt.Errorf("Latency and DropEvery must not be negative")
+	}
+	i.l.Lock()
+	defer i.l.Unlock()
+	i.points[point] = &PointStat{Fault: f}
+	return nil
+}
+
+// Clear clears the fault of point, or of all the points if point is "".
+func (i *Injector) Clear(point string) error {
+	i.l.Lock()
+	defer i.l.Unlock()
+	if point == "" {
+		i.points = make(map[string]*PointStat)
+		return nil
+	}
+	if !isPoint(point) {
+		return fmt.Errorf("unknown injection point %q. valid: %v", point, Points)
+	}
+	delete(i.points, point)
+	return nil
+}
+
+// List returns the faults set, by point.
+func (i *Injector) List() map[string]PointStat {
+	i.l.Lock()
+	defer i.l.Unlock()
+	list := make(map[string]PointStat, len(i.points))
+	for point, p := range i.points {
+		list[point] = *p
+	}
+	return list
+}
+
+func isPoint(point string) bool {
+	for _, p := range Points {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package faults
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInject_disabled(t *testing.T) {
+	Disable()
+	if err := Inject(ApplierCommit); err != nil {
+		t.Errorf("Inject() = %v with the injection disabled", err)
+	}
+	if Active() != nil {
+		t.Errorf("Active() != nil with the injection disabled")
+	}
+}
+
+func TestInjector_Inject(t *testing.T) {
+	i := Enable()
+	defer Disable()
+
+	if err := i.Set("nats.subscribe", Fault{Error: "x"}); err == nil {
+		t.Errorf("Set() of an unknown point succeeded")
+	}
+	if err := i.Set(NatsPublish, Fault{DropEvery: -1}); err == nil {
+		t.Errorf("Set() of a negative DropEvery succeeded")
+	}
+
+	if err := i.Set(NatsPublish, Fault{DropEvery: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 6; n++ {
+		err := Inject(NatsPublish)
+		if wantDrop := n%3 == 0; wantDrop != (err == ErrDropped) {
+			t.Errorf("call %v: Inject() = %v, want dropped: %v", n, err, wantDrop)
+		}
+	}
+	// The other points are not affected.
+	if err := Inject(ApplierCommit); err != nil {
+		t.Errorf("Inject() = %v at a point without a fault", err)
+	}
+
+	if err := i.Set(ApplierCommit, Fault{Error: "lock wait timeout", Latency: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := Inject(ApplierCommit); err == nil {
+		t.Errorf("Inject() = nil, want the error of the fault")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Inject() took %v, want the latency of the fault", elapsed)
+	}
+
+	list := i.List()
+	if got := list[NatsPublish]; got.Calls != 6 || got.Injected != 2 {
+		t.Errorf("stat of %v = %+v, want 6 calls and 2 injected", NatsPublish, got)
+	}
+	if got := list[ApplierCommit]; got.Calls != 1 || got.Injected != 1 {
+		t.Errorf("stat of %v = %+v, want 1 call injected", ApplierCommit, got)
+	}
+
+	if err := i.Clear(NatsPublish); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 3; n++ {
+		if err := Inject(NatsPublish); err != nil {
+			t.Errorf("Inject() = %v after Clear", err)
+		}
+	}
+	if err := i.Clear(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(i.List()); got != 0 {
+		t.Errorf("%v faults after clearing all", got)
+	}
+}
@@ -12,6 +12,9 @@ const (
 	GtidExecutedTableV2         string = "gtid_executed_v2"
 	GtidExecutedTableV3         string = "gtid_executed_v3"
 
+	// The column added on the target by NoPkTablePolicy "surrogate_key".
+	SurrogateKeyColumn string = "dtle_row_id"
+
 	ENV_PRINT_TPS         = "UDUP_PRINT_TPS"
 	ENV_DUMP_CHECKSUM     = "DTLE_DUMP_CHECKSUM"
 	ENV_DUMP_OLDWAY       = "DTLE_DUMP_OLDWAY"
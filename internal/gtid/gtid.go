@@ -0,0 +1,411 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package gtid parses and manipulates MySQL GTID sets, e.g.
+// "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7,4e11fa47-71ca-11e1-9e33-c80aa9429562:1".
+//
+// A Set is normalized when the intervals of each server uuid are sorted, non-empty
+// and neither overlap nor touch, and no server uuid has no interval. Parse and the
+// operations return normalized sets. The operations expect normalized sets, and
+// run in time linear to the number of intervals.
+package gtid
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxGNO is the largest GNO MySQL generates.
+const MaxGNO = math.MaxInt64 - 1
+
+// Interval is the GNOs from Start to Stop, excluding Stop, as in go-mysql.
+type Interval struct {
+	Start int64
+	Stop  int64
+}
+
+// Set is a GTID set: the intervals of GNOs by server uuid.
+type Set map[uuid.UUID][]Interval
+
+// Parse parses a GTID set as MySQL does: the server uuids are separated by ",",
+// possibly surrounded by white spaces, and empty between the commas is allowed.
+// The intervals may be unordered or overlap, and a server uuid may be repeated.
+// The empty string is the empty set.
+func Parse(s string) (Set, error) {
+	set := make(Set)
+	for len(s) > 0 {
+		var part string
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			part, s = s[:i], s[i+1:]
+		} else {
+			part, s = s, ""
+		}
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sid, intervals, err := parseUUIDSet(part)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := set[sid]; ok {
+			intervals = append(prev, intervals...)
+		}
+		set[sid] = intervals
+	}
+	set.Normalize()
+	return set, nil
+}
+
+// parseUUIDSet parses "uuid:interval[:interval]...".
+func parseUUIDSet(s string) (sid uuid.UUID, intervals []Interval, err error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return sid, nil, fmt.Errorf("bad gtid %q: expect uuid:interval[:interval]", s)
+	}
+	sid, err = uuid.FromString(strings.TrimSpace(s[:i]))
+	if err != nil {
+		return sid, nil, fmt.Errorf("bad gtid %q: %v", s, err)
+	}
+	intervals, err = parseIntervals(s[i+1:])
+	if err != nil {
+		return sid, nil, fmt.Errorf("bad gtid %q: %v", s, err)
+	}
+	return sid, intervals, nil
+}
+
+// parseIntervals parses "interval[:interval]...", an interval being "gno" or "start-end",
+// end included. It scans s once, which matters for the sets of many intervals.
+func parseIntervals(s string) ([]Interval, error) {
+	intervals := make([]Interval, 0, strings.Count(s, ":")+1)
+	pos := 0
+	for {
+		start, ok := scanGNO(s, &pos)
+		end := start
+		if ok && pos < len(s) && s[pos] == '-' {
+			pos++
+			end, ok = scanGNO(s, &pos)
+		}
+		if !ok || end < start {
+			return nil, fmt.Errorf("bad interval at %v: expect 1 <= start <= end <= %v", pos, int64(MaxGNO))
+		}
+		intervals = append(intervals, Interval{Start: start, Stop: end + 1})
+		if pos == len(s) {
+			return intervals, nil
+		}
+		if s[pos] != ':' {
+			return nil, fmt.Errorf("unexpected %q at %v", s[pos], pos)
+		}
+		pos++
+	}
+}
+
+// scanGNO scans a GNO in 1 to MaxGNO at *pos, with the white spaces around.
+func scanGNO(s string, pos *int) (gno int64, ok bool) {
+	i := skipSpaces(s, *pos)
+	digits := 0
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		d := int64(s[i] - '0')
+		if gno > (MaxGNO-d)/10 {
+			return 0, false
+		}
+		gno = gno*10 + d
+		digits++
+	}
+	*pos = skipSpaces(s, i)
+	return gno, digits > 0 && gno >= 1
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// String formats the set as MySQL does: the server uuids in order, in lower case,
+// separated by ",\n". The set must be normalized.
+func (s Set) String() string {
+	sids := s.sortedSids()
+	var buf bytes.Buffer
+	for i, sid := range sids {
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(sid.String())
+		writeIntervals(&buf, s[sid])
+	}
+	return buf.String()
+}
+
+// UUIDSetString formats the GNOs of sid as "uuid:interval[:interval]...", or ""
+// if there are none.
+func (s Set) UUIDSetString(sid uuid.UUID) string {
+	intervals, ok := s[sid]
+	if !ok {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString(sid.String())
+	writeIntervals(&buf, intervals)
+	return buf.String()
+}
+
+func writeIntervals(buf *bytes.Buffer, intervals []Interval) {
+	var num [20]byte
+	for _, interval := range intervals {
+		buf.WriteByte(':')
+		buf.Write(strconv.AppendInt(num[:0], interval.Start, 10))
+		if interval.Stop-1 != interval.Start {
+			buf.WriteByte('-')
+			buf.Write(strconv.AppendInt(num[:0], interval.Stop-1, 10))
+		}
+	}
+}
+
+func (s Set) sortedSids() []uuid.UUID {
+	sids := make([]uuid.UUID, 0, len(s))
+	for sid := range s {
+		sids = append(sids, sid)
+	}
+	sort.Slice(sids, func(i, j int) bool {
+		return bytes.Compare(sids[i][:], sids[j][:]) < 0
+	})
+	return sids
+}
+
+// Normalize sorts and merges the intervals of each server uuid in place, and
+// removes the empty intervals and server uuids.
+func (s Set) Normalize() {
+	for sid, intervals := range s {
+		intervals = normalizeIntervals(intervals)
+		if len(intervals) == 0 {
+			delete(s, sid)
+		} else {
+			s[sid] = intervals
+		}
+	}
+}
+
+func normalizeIntervals(intervals []Interval) []Interval {
+	sorted := true
+	for i := 1; i < len(intervals); i++ {
+		if intervals[i].Start < intervals[i-1].Start {
+			sorted = false
+			break
+		}
+	}
+	if !sorted {
+		sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+	}
+	n := 0
+	for _, interval := range intervals {
+		if interval.Start >= interval.Stop {
+			continue
+		}
+		if n > 0 && interval.Start <= intervals[n-1].Stop {
+			if interval.Stop > intervals[n-1].Stop {
+				intervals[n-1].Stop = interval.Stop
+			}
+			continue
+		}
+		intervals[n] = interval
+		n++
+	}
+	return intervals[:n]
+}
+
+// Clone returns a copy of the set.
+func (s Set) Clone() Set {
+	c := make(Set, len(s))
+	for sid, intervals := range s {
+		c[sid] = append([]Interval(nil), intervals...)
+	}
+	return c
+}
+
+// Count returns the number of transactions in the set.
+func (s Set) Count() (count int64) {
+	for _, intervals := range s {
+		for _, interval := range intervals {
+			count += interval.Stop - interval.Start
+		}
+	}
+	return count
+}
+
+// Equal tells whether the sets have the same transactions.
+func (s Set) Equal(o Set) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for sid, a := range s {
+		b, ok := o[sid]
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Union returns the transactions in s or o.
+func (s Set) Union(o Set) Set {
+	u := make(Set, len(s))
+	for sid, a := range s {
+		if b, ok := o[sid]; ok {
+			u[sid] = unionIntervals(a, b)
+		} else {
+			u[sid] = append([]Interval(nil), a...)
+		}
+	}
+	for sid, b := range o {
+		if _, ok := s[sid]; !ok {
+			u[sid] = append([]Interval(nil), b...)
+		}
+	}
+	return u
+}
+
+func unionIntervals(a, b []Interval) []Interval {
+	u := make([]Interval, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var next Interval
+		if j >= len(b) || (i < len(a) && a[i].Start <= b[j].Start) {
+			next = a[i]
+			i++
+		} else {
+			next = b[j]
+			j++
+		}
+		if n := len(u); n > 0 && next.Start <= u[n-1].Stop {
+			if next.Stop > u[n-1].Stop {
+				u[n-1].Stop = next.Stop
+			}
+			continue
+		}
+		u = append(u, next)
+	}
+	return u
+}
+
+// Subtract returns the transactions in s but not in o.
+func (s Set) Subtract(o Set) Set {
+	d := make(Set, len(s))
+	for sid, a := range s {
+		b, ok := o[sid]
+		if !ok {
+			d[sid] = append([]Interval(nil), a...)
+			continue
+		}
+		if intervals := subtractIntervals(a, b); len(intervals) > 0 {
+			d[sid] = intervals
+		}
+	}
+	return d
+}
+
+func subtractIntervals(a, b []Interval) []Interval {
+	d := make([]Interval, 0, len(a))
+	j := 0
+	for _, interval := range a {
+		for j < len(b) && b[j].Stop <= interval.Start {
+			j++
+		}
+		start := interval.Start
+		// b[k] for k >= j might overlap the interval. The last one might overlap the next as well.
+		for k := j; k < len(b) && b[k].Start < interval.Stop; k++ {
+			if b[k].Start > start {
+				d = append(d, Interval{Start: start, Stop: b[k].Start})
+			}
+			if b[k].Stop > start {
+				start = b[k].Stop
+			}
+		}
+		if start < interval.Stop {
+			d = append(d, Interval{Start: start, Stop: interval.Stop})
+		}
+	}
+	return d
+}
+
+// Contains tells whether s has all the transactions of o.
+func (s Set) Contains(o Set) bool {
+	for sid, b := range o {
+		a, ok := s[sid]
+		if !ok || !containsIntervals(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsIntervals(a, b []Interval) bool {
+	i := 0
+	for _, interval := range b {
+		// as b is normalized, an interval of b is within a single interval of a
+		for i < len(a) && a[i].Stop < interval.Stop {
+			i++
+		}
+		if i == len(a) || a[i].Start > interval.Start {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsGtid tells whether the set has sid:gno.
+func (s Set) ContainsGtid(sid uuid.UUID, gno int64) bool {
+	intervals := s[sid]
+	i := sort.Search(len(intervals), func(i int) bool { return intervals[i].Stop > gno })
+	return i < len(intervals) && intervals[i].Start <= gno
+}
+
+// AddGtid adds sid:gno to the set. GNOs usually come in order, so appending to the
+// last interval is the fast path.
+func (s Set) AddGtid(sid uuid.UUID, gno int64) {
+	intervals := s[sid]
+	n := len(intervals)
+	switch {
+	case n == 0 || gno > intervals[n-1].Stop:
+		s[sid] = append(intervals, Interval{Start: gno, Stop: gno + 1})
+		return
+	case gno == intervals[n-1].Stop:
+		intervals[n-1].Stop++
+		return
+	}
+
+	// the first interval with Stop >= gno
+	i := sort.Search(n, func(i int) bool { return intervals[i].Stop >= gno })
+	switch {
+	case gno >= intervals[i].Start && gno < intervals[i].Stop:
+		// already there
+	case gno == intervals[i].Stop:
+		intervals[i].Stop++
+		if i+1 < n && intervals[i+1].Start == intervals[i].Stop {
+			intervals[i].Stop = intervals[i+1].Stop
+			intervals = append(intervals[:i+1], intervals[i+2:]...)
+		}
+	case gno+1 == intervals[i].Start:
+		intervals[i].Start--
+	default:
+		intervals = append(intervals, Interval{})
+		copy(intervals[i+1:], intervals[i:])
+		intervals[i] = Interval{Start: gno, Stop: gno + 1}
+	}
+	s[sid] = intervals
+}
@@ -0,0 +1,320 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package gtid
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var testSids = []uuid.UUID{
+	uuid.FromStringOrNil("3e11fa47-71ca-11e1-9e33-c80aa9429562"),
+	uuid.FromStringOrNil("4e11fa47-71ca-11e1-9e33-c80aa9429562"),
+	uuid.FromStringOrNil("f2a4aa16-c8e6-11e7-9ff0-e19f7778f563"),
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"empty", "", ""},
+		{"blank", " \n", ""},
+		{"one", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"},
+		{"single gnos", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1:3:5-5", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1:3:5"},
+		{"unordered and touching", "3e11fa47-71ca-11e1-9e33-c80aa9429562:7-9:1-3:4-5:2", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7-9"},
+		{"sorted uuids", "4e11fa47-71ca-11e1-9e33-c80aa9429562:1,3E11FA47-71CA-11E1-9E33-C80AA9429562:2",
+			"3e11fa47-71ca-11e1-9e33-c80aa9429562:2,\n4e11fa47-71ca-11e1-9e33-c80aa9429562:1"},
+		{"repeated uuid", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-2,\n 3e11fa47-71ca-11e1-9e33-c80aa9429562:3",
+			"3e11fa47-71ca-11e1-9e33-c80aa9429562:1-3"},
+		{"empty parts", ",3e11fa47-71ca-11e1-9e33-c80aa9429562:1,,\n", "3e11fa47-71ca-11e1-9e33-c80aa9429562:1"},
+		{"max", "3e11fa47-71ca-11e1-9e33-c80aa9429562:9223372036854775806", "3e11fa47-71ca-11e1-9e33-c80aa9429562:9223372036854775806"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := Parse(tt.s)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := set.String(); got != tt.want {
+				t.Errorf("Parse().String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:1:",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:0",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:5-3",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:1-x",
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:9223372036854775807",
+		"3e11fa47-71ca-11e1-9e33:1",
+		"not-a-gtid",
+	} {
+		if set, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", bad, set)
+		}
+	}
+}
+
+func TestSet_AddGtid(t *testing.T) {
+	sid := testSids[0]
+	set := make(Set)
+	for _, gno := range []int64{1, 2, 3, 7, 5, 4, 10, 9, 3, 6} {
+		set.AddGtid(sid, gno)
+	}
+	if got, want := set.String(), sid.String()+":1-7:9-10"; got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+	if !set.ContainsGtid(sid, 6) || set.ContainsGtid(sid, 8) || set.ContainsGtid(testSids[1], 1) {
+		t.Errorf("ContainsGtid() is wrong for %v", set)
+	}
+	if got := set.UUIDSetString(sid); got != sid.String()+":1-7:9-10" {
+		t.Errorf("UUIDSetString() = %v", got)
+	}
+}
+
+// The properties below are checked against a model of a set: a bitmap of the GNOs 1 to 63
+// for each server uuid.
+
+type model map[uuid.UUID]uint64
+
+func (m model) Generate(r *rand.Rand, size int) reflect.Value {
+	m = make(model)
+	for _, sid := range testSids {
+		if r.Intn(4) == 0 {
+			continue
+		}
+		// runs of GNOs, so that the sets have intervals longer than 1
+		var bitmap uint64
+		for gno := 1; gno < 64; {
+			run := 1 + r.Intn(8)
+			if r.Intn(2) == 0 {
+				for k := 0; k < run && gno+k < 64; k++ {
+					bitmap |= 1 << uint(gno+k)
+				}
+			}
+			gno += run
+		}
+		if bitmap != 0 {
+			m[sid] = bitmap
+		}
+	}
+	return reflect.ValueOf(m)
+}
+
+// set returns the set of the model.
+func (m model) set() Set {
+	s := make(Set)
+	for sid, bitmap := range m {
+		for gno := int64(1); gno < 64; gno++ {
+			if bitmap&(1<<uint(gno)) != 0 {
+				s.AddGtid(sid, gno)
+			}
+		}
+	}
+	return s
+}
+
+// unnormalizedString formats the model with the intervals shuffled, overlapping and repeated,
+// and the uuids in upper case and repeated.
+func (m model) unnormalizedString(r *rand.Rand) string {
+	var parts []string
+	for sid, bitmap := range m {
+		var intervals []string
+		for gno := 1; gno < 64; gno++ {
+			if bitmap&(1<<uint(gno)) == 0 {
+				continue
+			}
+			end := gno
+			for end+1 < 64 && bitmap&(1<<uint(end+1)) != 0 && r.Intn(3) > 0 {
+				end++
+			}
+			intervals = append(intervals, fmt.Sprintf("%v-%v", gno, end))
+			if r.Intn(3) == 0 {
+				intervals = append(intervals, fmt.Sprint(gno))
+			}
+		}
+		r.Shuffle(len(intervals), func(i, j int) { intervals[i], intervals[j] = intervals[j], intervals[i] })
+		split := r.Intn(len(intervals))
+		parts = append(parts, strings.ToUpper(sid.String())+":"+strings.Join(intervals[:split+1], ":"))
+		if split+1 < len(intervals) {
+			parts = append(parts, " "+sid.String()+":"+strings.Join(intervals[split+1:], ":"))
+		}
+	}
+	r.Shuffle(len(parts), func(i, j int) { parts[i], parts[j] = parts[j], parts[i] })
+	return strings.Join(parts, ",\n")
+}
+
+// modelOf returns the model of a set, which must have the GNOs in 1 to 63.
+func modelOf(s Set) model {
+	m := make(model)
+	for sid, intervals := range s {
+		for _, interval := range intervals {
+			for gno := interval.Start; gno < interval.Stop; gno++ {
+				m[sid] |= 1 << uint(gno)
+			}
+		}
+	}
+	return m
+}
+
+func isNormalized(s Set) bool {
+	for _, intervals := range s {
+		if len(intervals) == 0 {
+			return false
+		}
+		for i, interval := range intervals {
+			if interval.Start >= interval.Stop || (i > 0 && interval.Start <= intervals[i-1].Stop) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func checkProperty(t *testing.T, f interface{}) {
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSet_properties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	t.Run("round trip", func(t *testing.T) {
+		checkProperty(t, func(m model) bool {
+			s := m.set()
+			parsed, err := Parse(s.String())
+			return err == nil && parsed.Equal(s) && parsed.String() == s.String() && reflect.DeepEqual(modelOf(s), m)
+		})
+	})
+	t.Run("parse unnormalized", func(t *testing.T) {
+		checkProperty(t, func(m model) bool {
+			parsed, err := Parse(m.unnormalizedString(r))
+			return err == nil && isNormalized(parsed) && parsed.Equal(m.set())
+		})
+	})
+	t.Run("normalize", func(t *testing.T) {
+		checkProperty(t, func(m model) bool {
+			s := make(Set)
+			for sid, intervals := range m.set() {
+				// split the intervals, shuffle and add empty ones
+				for _, interval := range intervals {
+					for gno := interval.Start; gno < interval.Stop; gno++ {
+						s[sid] = append(s[sid], Interval{Start: gno, Stop: gno + 1}, Interval{Start: gno, Stop: gno})
+					}
+				}
+				r.Shuffle(len(s[sid]), func(i, j int) { s[sid][i], s[sid][j] = s[sid][j], s[sid][i] })
+			}
+			s[testSids[0]] = append(s[testSids[0]], Interval{Start: 5, Stop: 5})
+			s.Normalize()
+			return isNormalized(s) && s.Equal(m.set())
+		})
+	})
+	t.Run("union", func(t *testing.T) {
+		checkProperty(t, func(a, b model) bool {
+			u := a.set().Union(b.set())
+			want := make(model)
+			for sid := range a {
+				want[sid] = a[sid] | b[sid]
+			}
+			for sid := range b {
+				want[sid] = a[sid] | b[sid]
+			}
+			return isNormalized(u) && reflect.DeepEqual(modelOf(u), want) && u.Equal(b.set().Union(a.set()))
+		})
+	})
+	t.Run("subtract", func(t *testing.T) {
+		checkProperty(t, func(a, b model) bool {
+			d := a.set().Subtract(b.set())
+			want := make(model)
+			for sid := range a {
+				if bitmap := a[sid] &^ b[sid]; bitmap != 0 {
+					want[sid] = bitmap
+				}
+			}
+			return isNormalized(d) && reflect.DeepEqual(modelOf(d), want) && d.Union(b.set()).Equal(a.set().Union(b.set()))
+		})
+	})
+	t.Run("contains", func(t *testing.T) {
+		checkProperty(t, func(a, b model) bool {
+			want := true
+			for sid := range b {
+				if b[sid]&^a[sid] != 0 {
+					want = false
+				}
+			}
+			return a.set().Contains(b.set()) == want &&
+				a.set().Contains(a.set().Subtract(b.set())) &&
+				a.set().Union(b.set()).Contains(b.set())
+		})
+	})
+	t.Run("equal and count", func(t *testing.T) {
+		checkProperty(t, func(a, b model) bool {
+			var count int
+			for _, bitmap := range a {
+				count += bits.OnesCount64(bitmap)
+			}
+			return a.set().Equal(b.set()) == reflect.DeepEqual(a, b) &&
+				a.set().Count() == int64(count) &&
+				a.set().Equal(a.set().Clone())
+		})
+	})
+}
+
+// bigSet returns a set of n intervals for each of the test server uuids, the GNOs of
+// each interval shifted by shift.
+func bigSet(n int, shift int64) Set {
+	s := make(Set)
+	for _, sid := range testSids {
+		intervals := make([]Interval, n)
+		for i := range intervals {
+			start := int64(i)*10 + 1 + shift
+			intervals[i] = Interval{Start: start, Stop: start + 5}
+		}
+		s[sid] = intervals
+	}
+	return s
+}
+
+func BenchmarkParse(b *testing.B) {
+	str := bigSet(1000, 0).String()
+	b.SetBytes(int64(len(str)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(str); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSubtract(b *testing.B) {
+	x, y := bigSet(1000, 0), bigSet(1000, 3)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Subtract(y)
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	x, y := bigSet(1000, 0), bigSet(1000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Contains(y)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client"
+	"github.com/actiontech/dtle/internal/config"
+	ulog "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// TestClient is a real Client whose servers are a MockServer.
+type TestClient struct {
+	*client.Client
+	Server *MockServer
+	// address of the embedded nats server, to be set in the task configs
+	NatsAddr string
+	dir      string
+	// makes the config of the client, for Restart
+	newConfig func() *config.ClientConfig
+}
+
+// NewTestClient starts a client with state and alloc dirs in a temp dir, and an embedded
+// nats server on a free port. The config can be changed by cb. Call Shutdown when done.
+func NewTestClient(t *testing.T, server *MockServer, cb ...func(*config.ClientConfig)) *TestClient {
+	dir, err := ioutil.TempDir("", "dtle-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsPort, err := FreePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	natsAddr := fmt.Sprintf("127.0.0.1:%d", natsPort)
+
+	c := &TestClient{Server: server, NatsAddr: natsAddr, dir: dir}
+	c.newConfig = func() *config.ClientConfig {
+		cfg := NewTestClientConfig(server, dir, natsAddr)
+		for _, f := range cb {
+			f(cfg)
+		}
+		return cfg
+	}
+	if c.Client, err = client.NewClient(c.newConfig(), testLogger()); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return c
+}
+
+// NewTestClientConfig returns the config of a client with state and alloc dirs in dir.
+func NewTestClientConfig(server *MockServer, dir, natsAddr string) *config.ClientConfig {
+	return &config.ClientConfig{
+		StateDir:   filepath.Join(dir, "state"),
+		AllocDir:   filepath.Join(dir, "alloc"),
+		LogOutput:  os.Stderr,
+		Region:     "global",
+		RPCHandler: server,
+		Node: &models.Node{
+			Name:       "integration",
+			Datacenter: "dc1",
+		},
+		NatsAddr:   natsAddr,
+		MaxPayload: 100 * 1024 * 1024,
+		// fresh node ID in each test
+		NoHostUUID:           true,
+		AllocShutdownTimeout: 5 * time.Second,
+	}
+}
+
+func testLogger() *ulog.Logger {
+	logLevel := ulog.ErrorLevel
+	if testing.Verbose() {
+		logLevel = ulog.DebugLevel
+	}
+	return ulog.New(os.Stderr, logLevel)
+}
+
+// Shutdown stops the client and removes its dirs.
+func (c *TestClient) Shutdown() {
+	c.Client.Shutdown()
+	os.RemoveAll(c.dir)
+}
+
+// Restart shuts down the client and starts a new one with the same config and dirs,
+// which restores the allocations from the state dir.
+func (c *TestClient) Restart(t *testing.T) {
+	c.Client.Shutdown()
+	cl, err := client.NewClient(c.newConfig(), testLogger())
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.Client = cl
+}
+
+// WaitForRegistered waits for the node to be registered to the server.
+func (c *TestClient) WaitForRegistered(t *testing.T, timeout time.Duration) {
+	WaitForResult(t, timeout, func() (bool, error) {
+		node := c.Server.Node(c.Client.Node().ID)
+		if node == nil {
+			return false, fmt.Errorf("node %v is not registered", c.Client.Node().ID)
+		}
+		return true, nil
+	})
+}
+
+// WaitForAllocStatus waits for the client to report the allocation in the status.
+// It fails at once if the allocation reaches another terminal status.
+func (c *TestClient) WaitForAllocStatus(t *testing.T, allocID, status string, timeout time.Duration) {
+	WaitForResult(t, timeout, func() (bool, error) {
+		alloc := c.Server.ClientAlloc(allocID)
+		if alloc == nil {
+			return false, fmt.Errorf("no update of alloc %v from the client", allocID)
+		}
+		if alloc.ClientStatus == status {
+			return true, nil
+		}
+		if alloc.ClientTerminalStatus() {
+			t.Fatalf("alloc %v is %v (%v), want %v", allocID, alloc.ClientStatus, alloc.ClientDescription, status)
+		}
+		return false, fmt.Errorf("alloc %v is %v, want %v", allocID, alloc.ClientStatus, status)
+	})
+}
+
+// WaitForResult retries test every 100ms until it returns true, and fails t with the
+// last error on timeout.
+func WaitForResult(t *testing.T, timeout time.Duration, test func() (bool, error)) {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := test()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %v: %v", timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// FreePort returns a TCP port which is free for now.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
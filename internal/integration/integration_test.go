@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gonats "github.com/nats-io/go-nats"
+
+	"github.com/actiontech/dtle/internal/client"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestClient_RegisterNode(t *testing.T) {
+	c := NewTestClient(t, NewMockServer())
+	defer c.Shutdown()
+
+	c.WaitForRegistered(t, 10*time.Second)
+}
+
+func TestClient_RunAllocs(t *testing.T) {
+	server := NewMockServer()
+	c := NewTestClient(t, server)
+	defer c.Shutdown()
+	c.WaitForRegistered(t, 10*time.Second)
+
+	// Nothing listens on the port. The tasks are run and fail to connect.
+	port, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &MySQLInstance{Host: "127.0.0.1", Port: port, User: "root"}
+	job := NewMySQLJob("run-allocs", c.NatsAddr, down, down, "db1")
+	allocs := server.RegisterJob(job, c.Node().ID)
+	for _, alloc := range allocs {
+		c.WaitForAllocStatus(t, alloc.ID, models.AllocClientStatusFailed, 30*time.Second)
+	}
+}
+
+func TestReplication_InsertRows(t *testing.T) {
+	src := StartMySQL(t, "src", 1)
+	defer src.Close()
+	dest := StartMySQL(t, "dest", 2)
+	defer dest.Close()
+
+	src.Exec(t,
+		"drop database if exists integration",
+		"create database integration",
+		"create table integration.t1 (id int primary key, val varchar(20))",
+		"insert into integration.t1 values (1, 'a'), (2, 'b')")
+	dest.Exec(t, "drop database if exists integration")
+
+	server := NewMockServer()
+	c := NewTestClient(t, server)
+	defer c.Shutdown()
+	c.WaitForRegistered(t, 10*time.Second)
+
+	job := NewMySQLJob("insert-rows", c.NatsAddr, src, dest, "integration")
+	allocs := server.RegisterJob(job, c.Node().ID)
+	defer server.StopJob(job.ID)
+	for _, alloc := range allocs {
+		c.WaitForAllocStatus(t, alloc.ID, models.AllocClientStatusRunning, time.Minute)
+	}
+
+	// full copy
+	dest.WaitForRowCount(t, "integration.t1", 2, time.Minute)
+
+	// incremental
+	for i := 3; i <= 10; i++ {
+		src.Exec(t, fmt.Sprintf("insert into integration.t1 values (%d, 'x')", i))
+	}
+	src.Exec(t, "delete from integration.t1 where id = 1")
+	dest.WaitForRowCount(t, "integration.t1", 9, time.Minute)
+}
+
+func TestClient_NatsAuth(t *testing.T) {
+	auth := &config.NatsAuthConfig{Token: "s3cr3t"}
+	c := NewTestClient(t, NewMockServer(), func(cfg *config.ClientConfig) {
+		cfg.NatsAuth = auth
+	})
+	defer c.Shutdown()
+
+	natsURL := fmt.Sprintf("nats://%s", c.NatsAddr)
+	if nc, err := auth.Connect(natsURL); err != nil {
+		t.Errorf("Connect() with the token error = %v", err)
+	} else {
+		nc.Close()
+	}
+	var noAuth *config.NatsAuthConfig
+	if nc, err := noAuth.Connect(natsURL); err == nil {
+		nc.Close()
+		t.Errorf("Connect() without the token succeeded, want an authentication error")
+	}
+}
+
+func TestClient_NatsAddrInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dir, err := ioutil.TempDir("", "dtle-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := client.NewClient(NewTestClientConfig(NewMockServer(), dir, l.Addr().String()), testLogger())
+	if err == nil {
+		c.Shutdown()
+		t.Fatalf("NewClient() succeeded, want an error with the nats address in use")
+	}
+	if !strings.Contains(err.Error(), l.Addr().String()) {
+		t.Errorf("NewClient() error = %v, want the nats address in it", err)
+	}
+}
+
+func TestClient_RestoreAllocNatsReady(t *testing.T) {
+	// The extractor connects to nats, then to MySQL, where it hangs.
+	// A new connection to MySQL tells the extractor has connected to nats.
+	mysql := HangingMySQL(t)
+	defer mysql.Close()
+
+	server := NewMockServer()
+	c := NewTestClient(t, server)
+	defer c.Shutdown()
+	c.WaitForRegistered(t, 10*time.Second)
+
+	job := NewMySQLJob("restore-alloc", c.NatsAddr, mysql, mysql, "db1")
+	job.Tasks = job.Tasks[:1]
+	allocs := server.RegisterJob(job, c.Node().ID)
+	for _, alloc := range allocs {
+		c.WaitForAllocStatus(t, alloc.ID, models.AllocClientStatusRunning, 30*time.Second)
+	}
+	WaitForResult(t, 10*time.Second, func() (bool, error) {
+		return mysql.Accepted() > 0, fmt.Errorf("the extractor has not connected to MySQL")
+	})
+
+	// The restored allocations start their tasks at once.
+	accepted := mysql.Accepted()
+	c.Restart(t)
+	WaitForResult(t, 10*time.Second, func() (bool, error) {
+		return mysql.Accepted() > accepted, fmt.Errorf("the restored extractor has not connected to MySQL")
+	})
+	for _, alloc := range allocs {
+		restored, err := c.GetClientAlloc(alloc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.ClientStatus != models.AllocClientStatusRunning {
+			t.Errorf("restored alloc %v is %v, want running", alloc.ID, restored.ClientStatus)
+		}
+		for taskType, state := range restored.TaskStates {
+			for _, event := range state.Events {
+				for _, msg := range []string{event.Message, event.DriverError, event.SetupError} {
+					if strings.Contains(strings.ToLower(msg), "nats") {
+						t.Errorf("task %v of alloc %v failed to connect nats: %v", taskType, alloc.ID, msg)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReplication_StatsPublish(t *testing.T) {
+	src := StartMySQL(t, "src", 1)
+	defer src.Close()
+	dest := StartMySQL(t, "dest", 2)
+	defer dest.Close()
+
+	src.Exec(t,
+		"drop database if exists integration",
+		"create database integration",
+		"create table integration.t1 (id int primary key)")
+	dest.Exec(t, "drop database if exists integration")
+
+	server := NewMockServer()
+	c := NewTestClient(t, server)
+	defer c.Shutdown()
+	c.WaitForRegistered(t, 10*time.Second)
+
+	nc, err := gonats.Connect(fmt.Sprintf("nats://%s", c.NatsAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	sub, err := nc.SubscribeSync(models.StatsSubjectPrefix + ">")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := NewMySQLJob("stats-publish", c.NatsAddr, src, dest, "integration")
+	for _, task := range job.Tasks {
+		task.Config["StatsPublishInterval"] = 1
+	}
+	allocs := server.RegisterJob(job, c.Node().ID)
+	defer server.StopJob(job.ID)
+	wantAllocs := make(map[string]bool)
+	for _, alloc := range allocs {
+		wantAllocs[alloc.ID] = true
+	}
+
+	// each task reports on the subject of the job
+	reported := make(map[string]bool)
+	deadline := time.Now().Add(time.Minute)
+	for len(reported) < len(allocs) {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if err != nil {
+			t.Fatalf("stats of %v of %v allocs published: %v", len(reported), len(allocs), err)
+		}
+		if msg.Subject != models.StatsSubject(job.ID) {
+			t.Fatalf("stats published on %v, want %v", msg.Subject, models.StatsSubject(job.ID))
+		}
+		var report models.StatsReport
+		if err := json.Unmarshal(msg.Data, &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.JobID != job.ID || !wantAllocs[report.AllocID] || report.Stats == nil {
+			t.Fatalf("stats report = %+v, want job %v with stats of an alloc of it", report, job.ID)
+		}
+		reported[report.AllocID] = true
+	}
+}
+
+func TestReplication_BinlogHeartbeat(t *testing.T) {
+	src := StartMySQL(t, "src", 1)
+	defer src.Close()
+	dest := StartMySQL(t, "dest", 2)
+	defer dest.Close()
+	proxy := ProxyMySQL(t, src)
+	defer proxy.Close()
+
+	src.Exec(t,
+		"drop database if exists integration",
+		"create database integration",
+		"create table integration.t1 (id int primary key)")
+	dest.Exec(t, "drop database if exists integration")
+
+	server := NewMockServer()
+	c := NewTestClient(t, server)
+	defer c.Shutdown()
+	c.WaitForRegistered(t, 10*time.Second)
+
+	const heartbeatPeriod = 2
+	job := NewMySQLJob("binlog-heartbeat", c.NatsAddr, proxy, dest, "integration")
+	for _, task := range job.Tasks {
+		task.Config["BinlogHeartbeatPeriod"] = heartbeatPeriod
+	}
+	allocs := server.RegisterJob(job, c.Node().ID)
+	defer server.StopJob(job.ID)
+	for _, alloc := range allocs {
+		c.WaitForAllocStatus(t, alloc.ID, models.AllocClientStatusRunning, time.Minute)
+	}
+	src.Exec(t, "insert into integration.t1 values (1)")
+	dest.WaitForRowCount(t, "integration.t1", 1, time.Minute)
+
+	// The binlog connection is dropped silently. Without the heartbeats, the extractor would
+	// wait for the next event on it forever.
+	proxy.Blackhole()
+	src.Exec(t, "insert into integration.t1 values (2)")
+	// detected after two missed heartbeats, then reconnected by the syncer after a second
+	dest.WaitForRowCount(t, "integration.t1", 2, 2*heartbeatPeriod*time.Second+10*time.Second)
+}
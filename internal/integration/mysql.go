@@ -0,0 +1,378 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package integration
+
+import (
+	gosql "database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+var skipMySQL = flag.Bool("skip-mysql", false, "skip the tests which need MySQL instances")
+
+const (
+	// The image of the MySQL containers. It is started with gtid and row-based binlog.
+	mysqlImage        = "mysql:5.7"
+	mysqlRootPassword = "password"
+	mysqlStartTimeout = 2 * time.Minute
+)
+
+// MySQLInstance is a MySQL server for the tests, either given by the environment
+// or run in a docker container.
+type MySQLInstance struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DB       *gosql.DB
+
+	// empty if the instance is not started by the harness
+	containerID string
+	// for HangingMySQL and ProxyMySQL
+	listener net.Listener
+	accepted int64
+	proxy    *mysqlProxy
+}
+
+// StartMySQL returns the MySQL instance for the role ("src" or "dest").
+//
+// If DTLE_TEST_MYSQL_SRC (or _DEST) is set to "user:password@host:port", the instance is used.
+// Otherwise a container is started with the docker command, if there is one.
+// The test is skipped with -skip-mysql, or if there is no instance to use.
+func StartMySQL(t *testing.T, role string, serverID int) *MySQLInstance {
+	if *skipMySQL {
+		t.Skip("skipped with -skip-mysql")
+	}
+
+	var m *MySQLInstance
+	if addr := os.Getenv(fmt.Sprintf("DTLE_TEST_MYSQL_%s", strings.ToUpper(role))); addr != "" {
+		var err error
+		if m, err = parseMySQLAddr(addr); err != nil {
+			t.Fatalf("bad DTLE_TEST_MYSQL_%s: %v", strings.ToUpper(role), err)
+		}
+	} else {
+		if _, err := exec.LookPath("docker"); err != nil {
+			t.Skip("no MySQL instance: neither DTLE_TEST_MYSQL_* nor docker is available")
+		}
+		var err error
+		if m, err = runMySQLContainer(serverID); err != nil {
+			t.Fatalf("run MySQL container error: %v", err)
+		}
+	}
+
+	if err := m.connect(); err != nil {
+		m.Close()
+		t.Fatalf("connect to MySQL %s:%d error: %v", m.Host, m.Port, err)
+	}
+	return m
+}
+
+func parseMySQLAddr(addr string) (*MySQLInstance, error) {
+	i := strings.LastIndex(addr, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("expect user:password@host:port")
+	}
+	m := &MySQLInstance{}
+	userPass := strings.SplitN(addr[:i], ":", 2)
+	m.User = userPass[0]
+	if len(userPass) == 2 {
+		m.Password = userPass[1]
+	}
+	host, port, err := net.SplitHostPort(addr[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	m.Host = host
+	if m.Port, err = strconv.Atoi(port); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func runMySQLContainer(serverID int) (*MySQLInstance, error) {
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "MYSQL_ROOT_PASSWORD="+mysqlRootPassword,
+		"-p", "127.0.0.1::3306",
+		mysqlImage,
+		fmt.Sprintf("--server-id=%d", serverID),
+		"--log-bin=mysql-bin", "--binlog-format=ROW",
+		"--gtid-mode=ON", "--enforce-gtid-consistency=ON").Output()
+	if err != nil {
+		return nil, err
+	}
+	m := &MySQLInstance{
+		User:        "root",
+		Password:    mysqlRootPassword,
+		containerID: strings.TrimSpace(string(out)),
+	}
+
+	out, err = exec.Command("docker", "port", m.containerID, "3306").Output()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	// "127.0.0.1:32768"
+	host, port, err := net.SplitHostPort(strings.TrimSpace(strings.Split(string(out), "\n")[0]))
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.Host = host
+	if m.Port, err = strconv.Atoi(port); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// connect waits for the instance to be up.
+func (m *MySQLInstance) connect() (err error) {
+	if m.DB, err = sql.CreateDB(m.ConnectionConfig().GetDBUri()); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(mysqlStartTimeout)
+	for {
+		if err = m.DB.Ping(); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// HangingMySQL returns an instance which accepts connections but never answers.
+// The tasks using it hang on connecting to MySQL.
+func HangingMySQL(t *testing.T) *MySQLInstance {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MySQLInstance{
+		Host:     "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		User:     "root",
+		listener: l,
+	}
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&m.accepted, 1)
+			conns = append(conns, conn)
+		}
+	}()
+	return m
+}
+
+// ProxyMySQL returns an instance forwarding its connections to m, until Blackhole.
+func ProxyMySQL(t *testing.T, m *MySQLInstance) *MySQLInstance {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &MySQLInstance{
+		Host:     "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		User:     m.User,
+		Password: m.Password,
+		listener: l,
+		proxy:    &mysqlProxy{},
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&p.accepted, 1)
+			p.proxy.forward(conn, addr)
+		}
+	}()
+	return p
+}
+
+// Blackhole silently drops the traffic of the connections a ProxyMySQL has accepted, as a
+// firewall dropping an idle connection. The connections stay open. The new ones are forwarded.
+func (m *MySQLInstance) Blackhole() {
+	m.proxy.lock.Lock()
+	defer m.proxy.lock.Unlock()
+	for _, c := range m.proxy.conns {
+		atomic.StoreInt32(&c.dropped, 1)
+	}
+}
+
+// mysqlProxy is the connections forwarded by a ProxyMySQL.
+type mysqlProxy struct {
+	lock  sync.Mutex
+	conns []*proxiedConn
+}
+
+type proxiedConn struct {
+	client  net.Conn
+	server  net.Conn
+	dropped int32
+}
+
+func (p *mysqlProxy) forward(client net.Conn, addr string) {
+	server, err := net.Dial("tcp", addr)
+	if err != nil {
+		client.Close()
+		return
+	}
+	c := &proxiedConn{client: client, server: server}
+	p.lock.Lock()
+	p.conns = append(p.conns, c)
+	p.lock.Unlock()
+	go c.copy(server, client)
+	go c.copy(client, server)
+}
+
+// copy forwards src to dst, or discards it once the connection is blackholed.
+func (c *proxiedConn) copy(dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if atomic.LoadInt32(&c.dropped) == 1 {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				src.Close()
+				return
+			}
+		}
+		if err != nil {
+			dst.Close()
+			return
+		}
+	}
+}
+
+func (p *mysqlProxy) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, c := range p.conns {
+		c.client.Close()
+		c.server.Close()
+	}
+}
+
+// Accepted returns how many connections a HangingMySQL or a ProxyMySQL has accepted.
+func (m *MySQLInstance) Accepted() int64 {
+	return atomic.LoadInt64(&m.accepted)
+}
+
+// Close closes the connection, and removes the container if started by the harness.
+func (m *MySQLInstance) Close() {
+	if m.DB != nil {
+		m.DB.Close()
+	}
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	if m.proxy != nil {
+		m.proxy.close()
+	}
+	if m.containerID != "" {
+		exec.Command("docker", "rm", "-f", m.containerID).Run()
+	}
+}
+
+func (m *MySQLInstance) ConnectionConfig() *umconf.ConnectionConfig {
+	return &umconf.ConnectionConfig{
+		Host:     m.Host,
+		Port:     m.Port,
+		User:     m.User,
+		Password: m.Password,
+	}
+}
+
+// Exec runs the statements and fails t on error.
+func (m *MySQLInstance) Exec(t *testing.T, queries ...string) {
+	for _, query := range queries {
+		if _, err := m.DB.Exec(query); err != nil {
+			t.Fatalf("exec %q on %s:%d error: %v", query, m.Host, m.Port, err)
+		}
+	}
+}
+
+// WaitForRowCount waits for the table ("schema.table") to have n rows.
+func (m *MySQLInstance) WaitForRowCount(t *testing.T, table string, n int64, timeout time.Duration) {
+	WaitForResult(t, timeout, func() (bool, error) {
+		var count int64
+		if err := m.DB.QueryRow(fmt.Sprintf("select count(*) from %s", table)).Scan(&count); err != nil {
+			return false, err
+		}
+		if count != n {
+			return false, fmt.Errorf("table %v has %v rows, want %v", table, count, n)
+		}
+		return true, nil
+	})
+}
+
+// NewMySQLJob returns a job replicating the schema from src to dest, through the nats server at natsAddr.
+func NewMySQLJob(name, natsAddr string, src, dest *MySQLInstance, schema string) *models.Job {
+	newTask := func(taskType string, m *MySQLInstance) *models.Task {
+		task := models.NewTask()
+		task.Type = taskType
+		task.Driver = models.TaskDriverMySQL
+		task.Config = map[string]interface{}{
+			"Gtid":     "",
+			"NatsAddr": natsAddr,
+			"ReplicateDoDb": []map[string]interface{}{
+				{"TableSchema": schema},
+			},
+			"ConnectionConfig": map[string]interface{}{
+				"Host":     m.Host,
+				"Port":     m.Port,
+				"User":     m.User,
+				"Password": m.Password,
+			},
+		}
+		return task
+	}
+	return &models.Job{
+		Region: "global",
+		// the applier takes the job ID as an UUID
+		ID:          models.GenerateUUID(),
+		Name:        name,
+		Type:        models.JobTypeSync,
+		Datacenters: []string{"dc1"},
+		Tasks: []*models.Task{
+			newTask(models.TaskTypeSrc, src),
+			newTask(models.TaskTypeDest, dest),
+		},
+		Status: models.JobStatusRunning,
+	}
+}
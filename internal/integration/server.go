@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package integration is a harness of end-to-end tests of the client, the tasks and MySQL.
+// A real Client is run against MockServer, an in-process RPCHandler which implements
+// the Node and Alloc endpoints the client calls.
+package integration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// max time of a blocking query if MaxQueryTime is not set
+	defaultMaxQueryTime = 2 * time.Second
+	heartbeatTTL        = 10 * time.Second
+)
+
+// MockServer plays the servers for a Client. Allocations are placed by RegisterJob
+// instead of the scheduler, and the updates from the client are kept as they are.
+type MockServer struct {
+	lock sync.Mutex
+	// closed and replaced on each change of index
+	changeCh chan struct{}
+	index    uint64
+
+	nodes  map[string]*models.Node
+	allocs map[string]*models.Allocation
+	// the last allocation updates from the client
+	clientAllocs map[string]*models.Allocation
+	jobUpdates   map[string]*models.TaskUpdate
+}
+
+func NewMockServer() *MockServer {
+	return &MockServer{
+		changeCh:     make(chan struct{}),
+		index:        1,
+		nodes:        make(map[string]*models.Node),
+		allocs:       make(map[string]*models.Allocation),
+		clientAllocs: make(map[string]*models.Allocation),
+		jobUpdates:   make(map[string]*models.TaskUpdate),
+	}
+}
+
+// bumpIndex must be called with the lock held.
+func (s *MockServer) bumpIndex() uint64 {
+	s.index++
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+	return s.index
+}
+
+// RPC implements config.RPCHandler.
+func (s *MockServer) RPC(method string, args interface{}, reply interface{}) error {
+	switch method {
+	case "Node.Register":
+		req := args.(*models.NodeRegisterRequest)
+		resp := reply.(*models.NodeUpdateResponse)
+		s.lock.Lock()
+		node := req.Node.Copy()
+		node.Status = models.NodeStatusReady
+		s.nodes[node.ID] = node
+		resp.NodeModifyIndex = s.bumpIndex()
+		s.lock.Unlock()
+		resp.HeartbeatTTL = heartbeatTTL
+		return nil
+
+	case "Node.UpdateStatus":
+		req := args.(*models.NodeUpdateStatusRequest)
+		resp := reply.(*models.NodeUpdateResponse)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		node, ok := s.nodes[req.NodeID]
+		if !ok {
+			return fmt.Errorf("node not found")
+		}
+		node.Status = req.Status
+		resp.HeartbeatTTL = heartbeatTTL
+		return nil
+
+	case "Node.GetNode":
+		req := args.(*models.NodeSpecificRequest)
+		resp := reply.(*models.SingleNodeResponse)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		resp.Node = s.nodes[req.NodeID].Copy()
+		resp.Index = s.index
+		return nil
+
+	case "Node.GetClientAllocs":
+		req := args.(*models.NodeSpecificRequest)
+		resp := reply.(*models.NodeClientAllocsResponse)
+		s.blockingQuery(&req.QueryOptions)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		resp.Allocs = make(map[string]uint64)
+		for _, alloc := range s.allocs {
+			if alloc.NodeID == req.NodeID {
+				resp.Allocs[alloc.ID] = alloc.AllocModifyIndex
+			}
+		}
+		resp.Index = s.index
+		return nil
+
+	case "Alloc.GetAllocs":
+		req := args.(*models.AllocsGetRequest)
+		resp := reply.(*models.AllocsGetResponse)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for _, id := range req.AllocIDs {
+			if alloc, ok := s.allocs[id]; ok {
+				resp.Allocs = append(resp.Allocs, alloc.Copy())
+			}
+		}
+		resp.Index = s.index
+		return nil
+
+	case "Alloc.GetAlloc":
+		req := args.(*models.AllocSpecificRequest)
+		resp := reply.(*models.SingleAllocResponse)
+		s.blockingQuery(&req.QueryOptions)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if alloc, ok := s.allocs[req.AllocID]; ok {
+			resp.Alloc = alloc.Copy()
+		}
+		resp.Index = s.index
+		return nil
+
+	case "Node.UpdateAlloc":
+		req := args.(*models.AllocUpdateRequest)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for _, update := range req.Alloc {
+			s.clientAllocs[update.ID] = update.Copy()
+			// Like the servers, AllocModifyIndex is not changed by client updates.
+			if alloc, ok := s.allocs[update.ID]; ok {
+				alloc.ClientStatus = update.ClientStatus
+				alloc.ClientDescription = update.ClientDescription
+				alloc.TaskStates = update.TaskStates
+				alloc.ModifyIndex = s.bumpIndex()
+			}
+		}
+		return nil
+
+	case "Node.UpdateJob":
+		req := args.(*models.JobUpdateRequest)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for _, update := range req.JobUpdates {
+			s.jobUpdates[update.JobID] = update
+		}
+		return nil
+	}
+	return fmt.Errorf("mock server: unsupported RPC method %v", method)
+}
+
+// blockingQuery waits until the index is beyond MinQueryIndex, or MaxQueryTime.
+func (s *MockServer) blockingQuery(opts *models.QueryOptions) {
+	if opts.MinQueryIndex == 0 {
+		return
+	}
+	maxQueryTime := opts.MaxQueryTime
+	if maxQueryTime == 0 {
+		maxQueryTime = defaultMaxQueryTime
+	}
+	timeout := time.After(maxQueryTime)
+	for {
+		s.lock.Lock()
+		index, changeCh := s.index, s.changeCh
+		s.lock.Unlock()
+		if index > opts.MinQueryIndex {
+			return
+		}
+		select {
+		case <-changeCh:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Node returns the registered node, or nil.
+func (s *MockServer) Node(nodeID string) *models.Node {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nodes[nodeID].Copy()
+}
+
+// RegisterJob places an allocation of each task of the job on the node.
+func (s *MockServer) RegisterJob(job *models.Job, nodeID string) []*models.Allocation {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var allocs []*models.Allocation
+	for _, task := range job.Tasks {
+		index := s.bumpIndex()
+		alloc := &models.Allocation{
+			ID:               models.GenerateUUID(),
+			Name:             fmt.Sprintf("%s.%s", job.Name, task.Type),
+			NodeID:           nodeID,
+			JobID:            job.ID,
+			Job:              job,
+			Task:             task.Type,
+			DesiredStatus:    models.AllocDesiredStatusRun,
+			ClientStatus:     models.AllocClientStatusPending,
+			CreateIndex:      index,
+			ModifyIndex:      index,
+			AllocModifyIndex: index,
+		}
+		s.allocs[alloc.ID] = alloc
+		allocs = append(allocs, alloc.Copy())
+	}
+	return allocs
+}
+
+// StopJob sets the desired status of the allocations of the job to stop.
+func (s *MockServer) StopJob(jobID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, alloc := range s.allocs {
+		if alloc.JobID == jobID {
+			index := s.bumpIndex()
+			alloc.DesiredStatus = models.AllocDesiredStatusStop
+			alloc.ModifyIndex = index
+			alloc.AllocModifyIndex = index
+		}
+	}
+}
+
+// ClientAlloc returns the last update of the allocation from the client, or nil.
+func (s *MockServer) ClientAlloc(allocID string) *models.Allocation {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.clientAllocs[allocID].Copy()
+}
+
+// JobUpdate returns the last task update of the job from the client, or nil.
+func (s *MockServer) JobUpdate(jobID string) *models.TaskUpdate {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.jobUpdates[jobID]
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A client advertises its capabilities as node attributes set to "1": "driver.<name>" for
+// each available driver, and "feature.<name>" for each supported feature.
+const (
+	NodeAttrDriverPrefix  = "driver."
+	NodeAttrFeaturePrefix = "feature."
+)
+
+// Features of the task configs, which older clients do not understand.
+const (
+	FeatureCompression   = "compression"
+	FeatureLoadData      = "load_data"
+	FeatureAdaptiveGroup = "adaptive_group"
+	FeatureStartPosition = "start_position"
+	FeatureEncryptAtRest = "encrypt_at_rest"
+	FeatureCodecV2       = "codec_v2"
+)
+
+// featureConfigKeys are the task config keys using each feature.
+var featureConfigKeys = map[string][]string{
+	FeatureCompression:   {"DumpCompression", "IncrementalCompression"},
+	FeatureLoadData:      {"UseLoadData"},
+	FeatureAdaptiveGroup: {"AdaptiveGroup"},
+	FeatureStartPosition: {"StartPosition"},
+	FeatureEncryptAtRest: {"EncryptDataAtRest"},
+	// negotiated by the ends of a job, which fall back to v1
+	FeatureCodecV2: nil,
+}
+
+// SupportedFeatures returns the features supported by this version, sorted.
+func SupportedFeatures() []string {
+	features := make([]string, 0, len(featureConfigKeys))
+	for feature := range featureConfigKeys {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// RequiredFeatures returns the features used by the config of the task, sorted.
+// A key set to a zero value (e.g. false or "") does not use its feature.
+func (t *Task) RequiredFeatures() []string {
+	var features []string
+	for feature, keys := range featureConfigKeys {
+		for _, key := range keys {
+			if v, ok := t.Config[key]; ok && !isZeroConfigValue(v) {
+				features = append(features, feature)
+				break
+			}
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+func isZeroConfigValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	default:
+		return false
+	}
+}
+
+// CapabilityConstraint is satisfied by the nodes with the capability attr, e.g. "driver.MySQL".
+func CapabilityConstraint(attr string) *Constraint {
+	return &Constraint{
+		LTarget: fmt.Sprintf("${attr.%s}", attr),
+		RTarget: "1",
+		Operand: "=",
+	}
+}
+
+// CapabilityAttr returns the capability required by a constraint made by CapabilityConstraint,
+// or "" for another constraint.
+func (c *Constraint) CapabilityAttr() string {
+	if c.RTarget != "1" || c.Operand != "=" ||
+		!strings.HasPrefix(c.LTarget, "${attr.") || !strings.HasSuffix(c.LTarget, "}") {
+		return ""
+	}
+	attr := strings.TrimSuffix(strings.TrimPrefix(c.LTarget, "${attr."), "}")
+	if !strings.HasPrefix(attr, NodeAttrDriverPrefix) && !strings.HasPrefix(attr, NodeAttrFeaturePrefix) {
+		return ""
+	}
+	return attr
+}
+
+// addCapabilityConstraints adds the constraints on the driver and the features required by the task.
+func (t *Task) addCapabilityConstraints() {
+	var attrs []string
+	if t.Driver != "" {
+		attrs = append(attrs, NodeAttrDriverPrefix+t.Driver)
+	}
+	for _, feature := range t.RequiredFeatures() {
+		attrs = append(attrs, NodeAttrFeaturePrefix+feature)
+	}
+	for _, attr := range attrs {
+		c := CapabilityConstraint(attr)
+		found := false
+		for _, existing := range t.Constraints {
+			if existing.Equal(c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Constraints = append(t.Constraints, c)
+		}
+	}
+}
+
+// MissingCapability returns the first capability required by the constraints which the node
+// does not advertise, or "" if it has them all.
+func (n *Node) MissingCapability(constraints []*Constraint) string {
+	for _, c := range constraints {
+		if attr := c.CapabilityAttr(); attr != "" && n.Attributes[attr] != "1" {
+			return attr
+		}
+	}
+	return ""
+}
@@ -9,6 +9,8 @@ package models
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
@@ -28,6 +30,41 @@ const (
 	JobStatusComplete = "complete" // Complete means all evaluation's and allocations are terminal
 )
 
+// Values of Job.Colocate. "" is JobColocateAny.
+const (
+	// JobColocateAny places the tasks on any nodes.
+	JobColocateAny = "any"
+	// JobColocateTrue places the tasks on the same node, e.g. for the
+	// extractor to publish to the nats server of the applier on localhost.
+	JobColocateTrue = "true"
+	// JobColocateFalse places the tasks on different nodes, e.g. for the
+	// isolation of their resources.
+	JobColocateFalse = "false"
+)
+
+// Values of the Mode of the config of the Src task of a job. "" is JobModeFull, or
+// JobModeCopyOnly with SkipIncrementalCopy.
+const (
+	// JobModeFull copies the tables, then replicates the binlog from the copy.
+	JobModeFull = "full"
+	// JobModeCopyOnly copies the tables, and completes.
+	JobModeCopyOnly = "copy_only"
+	// JobModeIncrementalOnly replicates the binlog from Gtid, GtidStart or StartPosition,
+	// without the copy.
+	JobModeIncrementalOnly = "incremental_only"
+)
+
+// Bounds of Job.Meta, as it is stored with the job and its allocations, and its keys may be
+// metric labels.
+const (
+	JobMetaMaxEntries  = 32
+	JobMetaMaxKeyLen   = 64
+	JobMetaMaxValueLen = 256
+)
+
+// jobMetaKeyRegexp is of the keys of Job.Meta, which are valid metric label names.
+var jobMetaKeyRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 func ValidJobStatus(status string) bool {
 	switch status {
 	case JobStatusPending, JobStatusRunning, JobStatusPause, JobStatusDead, JobStatusComplete:
@@ -66,6 +103,15 @@ type Job struct {
 	// Datacenters contains all the datacenters this job is allowed to span
 	Datacenters []string
 
+	// Colocate is whether the tasks are placed on the same node, on
+	// different nodes, or on any. See JobColocateAny.
+	Colocate string
+
+	// Meta are the labels of the job, e.g. team=payments or ticket=DB-1234. They are carried
+	// on its allocations and task statistics, and are updated in place, without restarting
+	// the tasks. See JobMetaMaxEntries.
+	Meta map[string]string
+
 	// Constraints can be specified at a job level and apply to
 	// all the tasks.
 	Constraints []*Constraint
@@ -74,6 +120,17 @@ type Job struct {
 	// to run. Each task is an atomic unit of scheduling and placement.
 	Tasks []*Task
 
+	// Periodic makes the job launch a child job at the times of a cron
+	// expression, instead of running itself.
+	Periodic *PeriodicConfig
+
+	// ParentID is the ID of the periodic job which launched this job.
+	ParentID string
+
+	// LaunchTime is the time a child of a periodic job was launched for,
+	// in UnixNano.
+	LaunchTime int64
+
 	// Job status
 	Status string
 
@@ -105,7 +162,9 @@ func (j *Job) Copy() *Job {
 	nj := new(Job)
 	*nj = *j
 	nj.Datacenters = internal.CopySliceString(nj.Datacenters)
+	nj.Meta = internal.CopyMapStringString(nj.Meta)
 	nj.Constraints = CopySliceConstraints(nj.Constraints)
+	nj.Periodic = nj.Periodic.Copy()
 
 	if j.Tasks != nil {
 		ts := make([]*Task, len(nj.Tasks))
@@ -169,9 +228,93 @@ func (j *Job) Validate() error {
 		}
 	}
 
+	if err := j.validateColocate(); err != nil {
+		mErr.Errors = append(mErr.Errors, err)
+	}
+
+	if err := ValidateJobMeta(j.Meta); err != nil {
+		mErr.Errors = append(mErr.Errors, err)
+	}
+
+	if j.Periodic != nil {
+		if err := j.Periodic.Validate(); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("Periodic validation failed: %v", err))
+		}
+	}
+
 	return mErr.ErrorOrNil()
 }
 
+// validateColocate rejects a Colocate which the nodes the tasks are pinned to
+// make impossible. The datacenters are those of the job, shared by the tasks.
+func (j *Job) validateColocate() error {
+	switch j.Colocate {
+	case "", JobColocateAny:
+		return nil
+	case JobColocateTrue, JobColocateFalse:
+	default:
+		return fmt.Errorf("Unknown colocate %q. expect %v, %v or %v",
+			j.Colocate, JobColocateTrue, JobColocateFalse, JobColocateAny)
+	}
+
+	for i, a := range j.Tasks {
+		for _, b := range j.Tasks[i+1:] {
+			same, known := a.sameNode(b)
+			if !known {
+				continue
+			}
+			if j.Colocate == JobColocateTrue && !same {
+				return fmt.Errorf("Tasks %v and %v are pinned to different nodes, against colocate %v",
+					a.Type, b.Type, j.Colocate)
+			}
+			if j.Colocate == JobColocateFalse && same {
+				return fmt.Errorf("Tasks %v and %v are pinned to the same node, against colocate %v",
+					a.Type, b.Type, j.Colocate)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateJobMeta checks the count and the sizes of the entries of Job.Meta, and their keys.
+func ValidateJobMeta(meta map[string]string) error {
+	if len(meta) > JobMetaMaxEntries {
+		return fmt.Errorf("Too many meta entries: %v. Expect at most %v", len(meta), JobMetaMaxEntries)
+	}
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(key) > JobMetaMaxKeyLen {
+			return fmt.Errorf("Meta key %q is too long. Expect at most %v bytes", key, JobMetaMaxKeyLen)
+		}
+		if !IsJobMetaKey(key) {
+			return fmt.Errorf("Bad meta key %q. Expect letters, digits and '_', not starting with a digit", key)
+		}
+		if len(meta[key]) > JobMetaMaxValueLen {
+			return fmt.Errorf("Meta value of %q is too long. Expect at most %v bytes", key, JobMetaMaxValueLen)
+		}
+	}
+	return nil
+}
+
+// IsJobMetaKey tells whether key is a valid key of Job.Meta.
+func IsJobMetaKey(key string) bool {
+	return jobMetaKeyRegexp.MatchString(key)
+}
+
+// MatchesMeta tells whether the job has all the entries of filter in its Meta.
+func (j *Job) MatchesMeta(filter map[string]string) bool {
+	for key, value := range filter {
+		if v, ok := j.Meta[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
 // LookupTask finds a task by name
 func (j *Job) LookupTask(tp string) *Task {
 	for _, t := range j.Tasks {
@@ -190,6 +333,8 @@ func (j *Job) Stub(job *Job) *JobListStub {
 		Type:              j.Type,
 		Status:            j.Status,
 		StatusDescription: j.StatusDescription,
+		Mode:              j.Mode(),
+		Meta:              j.Meta,
 		CreateIndex:       j.CreateIndex,
 		ModifyIndex:       j.ModifyIndex,
 		JobModifyIndex:    j.JobModifyIndex,
@@ -197,6 +342,29 @@ func (j *Job) Stub(job *Job) *JobListStub {
 	}
 }
 
+// Mode returns the mode of the job, by the config of its Src task. See JobModeFull.
+func (j *Job) Mode() string {
+	for _, t := range j.Tasks {
+		if t.Type != TaskTypeSrc {
+			continue
+		}
+		if mode, ok := t.Config["Mode"].(string); ok && mode != "" {
+			return mode
+		}
+		switch skip := t.Config["SkipIncrementalCopy"].(type) {
+		case bool:
+			if skip {
+				return JobModeCopyOnly
+			}
+		case string:
+			if skip == "true" || skip == "1" {
+				return JobModeCopyOnly
+			}
+		}
+	}
+	return JobModeFull
+}
+
 // JobListStub is used to return a subset of job information
 // for the job list
 type JobListStub struct {
@@ -205,6 +373,8 @@ type JobListStub struct {
 	Type              string
 	Status            string
 	StatusDescription string
+	Mode              string // see JobModeFull
+	Meta              map[string]string
 	JobSummary        *Job
 	CreateIndex       uint64
 	ModifyIndex       uint64
@@ -304,6 +474,8 @@ type JobSpecificRequest struct {
 
 // JobListRequest is used to parameterize a list request
 type JobListRequest struct {
+	// Meta lists only the jobs with all these entries in their Meta.
+	Meta map[string]string
 	QueryOptions
 }
 
@@ -355,12 +527,67 @@ type TaskValidateResponse struct {
 	ServerID ServerIDValidate
 
 	Binlog BinlogValidate
+
+	NoPkTables NoPkTablesValidate
+
+	ZeroDates ZeroDatesValidate
+}
+
+type ZeroDatesValidate struct {
+	Success bool
+	// Error is a string version of any error that may have occured
+	Error string
+	// The ZeroDatePolicy in effect
+	Policy string
+	// Replicated DATE, DATETIME and TIMESTAMP columns with a zero default, as
+	// "schema.table.column". Src only
+	Columns []string
+	// Whether the sql_mode of the target rejects the zero dates, which Policy applies as they
+	// are. Dest only
+	StrictTarget bool
+	// Warning tells of the zero dates a strict target would reject, with the Columns of the Src
+	// task. See WarnZeroDates
+	Warning string
+}
+
+// WarnZeroDates warns on the Dest tasks of a StrictTarget of the columns with a zero default of
+// the Src tasks. Their rows likely have zero dates, which
+// would fail the task.
+func (r *JobValidateResponse) WarnZeroDates() {
+	var columns []string
+	for _, task := range r.ValidationTasks {
+		if task.Type == TaskTypeSrc {
+			columns = append(columns, task.ZeroDates.Columns...)
+		}
+	}
+	if len(columns) == 0 {
+		return
+	}
+	for _, task := range r.ValidationTasks {
+		if task.Type == TaskTypeDest && task.ZeroDates.StrictTarget {
+			task.ZeroDates.Warning = fmt.Sprintf("the sql_mode of the target rejects the zero dates, which the"+
+				" columns with a zero default likely have: %v. See ZeroDatePolicy", strings.Join(columns, ", "))
+		}
+	}
+}
+
+type NoPkTablesValidate struct {
+	Success bool
+	// Error is a string version of any error that may have occured
+	Error string
+	// The NoPkTablePolicy in effect
+	Policy string
+	// Replicated tables without primary key, as "schema.table"
+	Tables []string
 }
 
 type BinlogValidate struct {
 	Success bool
 	// Error is a string version of any error that may have occured
 	Error string
+	// binlog_transaction_compression of the source, MySQL 8.0.20+. The extractor decompresses
+	// the transactions compressed
+	TransactionCompression bool
 }
 
 type GtidModeValidate struct {
@@ -373,6 +600,9 @@ type ServerIDValidate struct {
 	Success bool
 	// Error is a string version of any error that may have occured
 	Error string
+	// The server_id of the extractor as a replica of the source. 0 if derived at the start of
+	// the task, on a node not known yet
+	ReplicaServerId uint32
 }
 
 type PrivilegesValidate struct {
@@ -44,6 +44,11 @@ type NodeListRequest struct {
 // to register a node as being a schedulable entity.
 type NodeRegisterRequest struct {
 	Node *Node
+
+	// Events are the events of the node since it last sent them, appended
+	// to its events with those of the registration.
+	Events []*NodeEvent
+
 	WriteRequest
 }
 
@@ -78,6 +83,11 @@ type NodeServerInfo struct {
 type NodeUpdateStatusRequest struct {
 	NodeID string
 	Status string
+
+	// Events are appended to the events of the node, see
+	// NodeRegisterRequest.Events.
+	Events []*NodeEvent
+
 	WriteRequest
 }
 
@@ -135,6 +145,12 @@ type SingleNodeResponse struct {
 	QueryMeta
 }
 
+// NodeEventsResponse is used to return the events of a node
+type NodeEventsResponse struct {
+	Events []*NodeEvent
+	QueryMeta
+}
+
 // JobListResponse is used for a list request
 type NodeListResponse struct {
 	Nodes []*NodeListStub
@@ -147,6 +163,12 @@ const (
 	NodeStatusDown  = "down"
 )
 
+// Values of Node.SchedulingEligibility
+const (
+	NodeSchedulingEligible   = "eligible"
+	NodeSchedulingIneligible = "ineligible"
+)
+
 // ValidNodeStatus is used to check if a node status is valid
 func ValidNodeStatus(status string) bool {
 	switch status {
@@ -176,6 +198,13 @@ type Node struct {
 
 	NatsAddr string
 
+	// NodeClass is an opaque identifier of the role of the node, e.g. "monitor".
+	NodeClass string
+
+	// SchedulingEligibility tells whether allocations can be placed on the node.
+	// An ineligible node still heartbeats and relays nats messages.
+	SchedulingEligibility string
+
 	// Attributes is an arbitrary set of key/value
 	// data that can be used for constraints. Examples
 	// include "kernel.name=linux", "arch=386", "driver.docker=1",
@@ -196,6 +225,10 @@ type Node struct {
 	// updated
 	StatusUpdatedAt int64
 
+	// Events is the timeline of the node, oldest first, kept by the servers
+	// up to MaxNodeEvents.
+	Events []*NodeEvent
+
 	// Raft Indexes
 	CreateIndex uint64
 	ModifyIndex uint64
@@ -206,6 +239,12 @@ func (n *Node) Ready() bool {
 	return n.Status == NodeStatusReady
 }
 
+// Eligible returns if allocations can be placed on the node. Nodes registered
+// without an eligibility are eligible.
+func (n *Node) Eligible() bool {
+	return n.SchedulingEligibility != NodeSchedulingIneligible
+}
+
 func (n *Node) Copy() *Node {
 	if n == nil {
 		return nil
@@ -213,9 +252,61 @@ func (n *Node) Copy() *Node {
 	nn := new(Node)
 	*nn = *n
 	nn.Attributes = internal.CopyMapStringString(nn.Attributes)
+	nn.Events = append([]*NodeEvent(nil), nn.Events...)
 	return nn
 }
 
+// NodeAttrMaxOpenFiles is the node attribute of the limit of open files of the
+// client process, RLIMIT_NOFILE. It is missing where the limit is unknown.
+const NodeAttrMaxOpenFiles = "os.max_open_files"
+
+// Values of NodeEvent.Type
+const (
+	NodeEventOpenFiles = "open_files"
+	NodeEventClockJump = "clock_jump"
+	NodeEventGrowth    = "growth"
+
+	// Events of the servers
+	NodeEventRegistered      = "registered"
+	NodeEventHeartbeatMissed = "heartbeat_missed"
+	NodeEventStatus          = "status"
+	NodeEventEligibility     = "eligibility"
+	NodeEventAttributes      = "attributes"
+)
+
+// MaxNodeEvents is how many events of a node the servers keep.
+const MaxNodeEvents = 50
+
+// NodeEvent is a condition of a node worth a place in its timeline, e.g. the
+// client running out of open files.
+type NodeEvent struct {
+	Type      string
+	Message   string
+	Timestamp time.Time
+	Details   map[string]string
+}
+
+// NewNodeEvent returns an event of the node at now.
+func NewNodeEvent(typ, message string, details map[string]string, now time.Time) *NodeEvent {
+	return &NodeEvent{
+		Type:      typ,
+		Message:   message,
+		Timestamp: now,
+		Details:   details,
+	}
+}
+
+// AppendNodeEvents returns events followed by more, in a new slice, keeping
+// the last MaxNodeEvents.
+func AppendNodeEvents(events []*NodeEvent, more ...*NodeEvent) []*NodeEvent {
+	all := make([]*NodeEvent, 0, len(events)+len(more))
+	all = append(append(all, events...), more...)
+	if len(all) > MaxNodeEvents {
+		all = all[len(all)-MaxNodeEvents:]
+	}
+	return all
+}
+
 // TerminalStatus returns if the current status is terminal and
 // will no longer transition.
 func (n *Node) TerminalStatus() bool {
@@ -233,9 +324,11 @@ func (n *Node) Stub() *NodeListStub {
 		ID:                n.ID,
 		Datacenter:        n.Datacenter,
 		Name:              n.Name,
+		NodeClass:         n.NodeClass,
 		Status:            n.Status,
 		HTTPAddr:          n.HTTPAddr,
 		StatusDescription: n.StatusDescription,
+		Eligibility:       n.SchedulingEligibility,
 		CreateIndex:       n.CreateIndex,
 		ModifyIndex:       n.ModifyIndex,
 	}
@@ -248,8 +341,10 @@ type NodeListStub struct {
 	Datacenter        string
 	Name              string
 	HTTPAddr          string
+	NodeClass         string
 	Status            string
 	StatusDescription string
+	Eligibility       string
 	CreateIndex       uint64
 	ModifyIndex       uint64
 }
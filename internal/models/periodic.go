@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/actiontech/dtle/internal/cron"
+)
+
+// PeriodicChildInfix separates the ID of a periodic job and the launch time in the IDs of its children.
+const PeriodicChildInfix = "-periodic-"
+
+// PeriodicConfig is the periodic stanza of a job. At each time of Spec, the job launches a
+// child job making a one-shot copy (full copy, no incremental).
+type PeriodicConfig struct {
+	// Enabled launches the children. A disabled periodic job launches nothing.
+	Enabled bool
+
+	// Spec is a cron expression, e.g. "0 2 * * *" or "@daily".
+	Spec string
+
+	// ProhibitOverlap skips a launch while a child is still running.
+	ProhibitOverlap bool
+
+	// TimeZone is the location Spec is evaluated in, e.g. "Asia/Shanghai". Defaults to UTC.
+	TimeZone string
+}
+
+func (p *PeriodicConfig) Copy() *PeriodicConfig {
+	if p == nil {
+		return nil
+	}
+	np := new(PeriodicConfig)
+	*np = *p
+	return np
+}
+
+func (p *PeriodicConfig) Validate() error {
+	if p.Spec == "" {
+		return errors.New("Missing periodic spec")
+	}
+	if _, err := cron.Parse(p.Spec); err != nil {
+		return fmt.Errorf("Invalid periodic spec %q: %v", p.Spec, err)
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		return fmt.Errorf("Invalid periodic time zone %q: %v", p.TimeZone, err)
+	}
+	return nil
+}
+
+// Next returns the first launch time after t, or the zero time if there is none.
+func (p *PeriodicConfig) Next(t time.Time) (time.Time, error) {
+	schedule, loc, err := p.schedule()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(t.In(loc)), nil
+}
+
+// LastDue returns the latest launch time after last and not after now, or the zero time if
+// there is none. The launches missed in between, e.g. while there was no leader, are
+// coalesced into it.
+func (p *PeriodicConfig) LastDue(last, now time.Time) (time.Time, error) {
+	schedule, loc, err := p.schedule()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var due time.Time
+	for next := schedule.Next(last.In(loc)); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		due = next
+	}
+	return due, nil
+}
+
+func (p *PeriodicConfig) schedule() (*cron.Schedule, *time.Location, error) {
+	schedule, err := cron.Parse(p.Spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schedule, loc, nil
+}
+
+// IsPeriodic returns whether the job launches children instead of running itself.
+func (j *Job) IsPeriodic() bool {
+	return j.Periodic != nil
+}
+
+// PeriodicChild derives the child launched at launch from a periodic job. Its tasks make a
+// one-shot copy, from the current data of the source.
+func (j *Job) PeriodicChild(launch time.Time) *Job {
+	child := j.Copy()
+	child.ID = fmt.Sprintf("%s%s%d", j.ID, PeriodicChildInfix, launch.Unix())
+	child.Name = fmt.Sprintf("%s%s%d", j.Name, PeriodicChildInfix, launch.Unix())
+	child.Periodic = nil
+	child.ParentID = j.ID
+	child.LaunchTime = launch.UnixNano()
+	child.Status = ""
+	child.StatusDescription = ""
+	child.CreateIndex = 0
+	child.ModifyIndex = 0
+	child.JobModifyIndex = 0
+	for _, t := range child.Tasks {
+		// Task.Copy might share the map with the parent.
+		config := make(map[string]interface{}, len(t.Config)+1)
+		for k, v := range t.Config {
+			config[k] = v
+		}
+		delete(config, "Gtid")
+		delete(config, "NatsAddr")
+		config["SkipIncrementalCopy"] = true
+		config["Mode"] = JobModeCopyOnly
+		t.Config = config
+	}
+	return child
+}
+
+// PeriodicChildStub is the outcome of a launch of a periodic job.
+type PeriodicChildStub struct {
+	JobID string
+	// LaunchTime in UnixNano
+	LaunchTime int64
+	// Status of the child job
+	Status string
+	// Outcome is "running", "complete" if all its allocations ran successfully, or "failed".
+	Outcome string
+	// Duration from the launch to the end of the last task. 0 while running.
+	Duration time.Duration
+}
+
+const (
+	PeriodicOutcomeRunning  = "running"
+	PeriodicOutcomeComplete = "complete"
+	PeriodicOutcomeFailed   = "failed"
+)
+
+// PeriodicChildOutcome summarizes a child job and its allocations.
+func PeriodicChildOutcome(child *Job, allocs []*Allocation) *PeriodicChildStub {
+	stub := &PeriodicChildStub{
+		JobID:      child.ID,
+		LaunchTime: child.LaunchTime,
+		Status:     child.Status,
+		Outcome:    PeriodicOutcomeRunning,
+	}
+	if len(allocs) == 0 {
+		return stub
+	}
+
+	var finished time.Time
+	succeeded := true
+	for _, alloc := range allocs {
+		if !alloc.TerminalStatus() {
+			return stub
+		}
+		if !alloc.RanSuccessfully() {
+			succeeded = false
+		}
+		for _, state := range alloc.TaskStates {
+			if state.FinishedAt.After(finished) {
+				finished = state.FinishedAt
+			}
+		}
+	}
+	if succeeded {
+		stub.Outcome = PeriodicOutcomeComplete
+	} else {
+		stub.Outcome = PeriodicOutcomeFailed
+	}
+	if !finished.IsZero() {
+		stub.Duration = finished.Sub(time.Unix(0, child.LaunchTime))
+	}
+	return stub
+}
+
+// JobChildrenResponse is used to return the recent children of a periodic job, oldest first.
+type JobChildrenResponse struct {
+	Children []*PeriodicChildStub
+	QueryMeta
+}
@@ -20,6 +20,8 @@ import (
 var (
 	ErrNoLeader     = fmt.Errorf("No cluster leader")
 	ErrNoRegionPath = fmt.Errorf("No path to region")
+	// ErrServerBusy tells the clients to back off and try later.
+	ErrServerBusy = fmt.Errorf("Server busy, try later")
 )
 
 type MessageType uint8
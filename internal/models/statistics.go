@@ -7,6 +7,8 @@
 package models
 
 import (
+	"time"
+
 	gonats "github.com/nats-io/go-nats"
 )
 
@@ -28,6 +30,8 @@ type TableStats struct {
 	InsertCount int64
 	UpdateCount int64
 	DelCount    int64
+	// rows of the full copy conflicting with the existing rows of the target, resolved by ConflictPolicy
+	ConflictCount int64
 }
 
 type DelayCount struct {
@@ -35,9 +39,133 @@ type DelayCount struct {
 	Time uint64
 }
 
+// GtidGap is how far the applier is behind what it has received from the extractor.
+type GtidGap struct {
+	// number of received but not yet executed transactions
+	Transactions int64
+	// source commit time of the last received transaction minus that of the last executed one
+	Seconds int64
+}
+
+// Values of CompletionEstimate.CatchUpState
+const (
+	CatchUpCaughtUp   = "caught_up"
+	CatchUpConverging = "converging"
+	CatchUpSteady     = "steady"
+	CatchUpDiverging  = "diverging"
+)
+
+// CompletionEstimate estimates when the phases of a task complete, by their progress over the
+// last minute. An ETA is a duration, "0s" once done, or "N/A" without a progress yet. An EndAt
+// is the estimated end in unix seconds, 0 without an ETA.
+type CompletionEstimate struct {
+	// of the full copy: the rows left, ReadMasterRowCount - ExecMasterRowCount, at the rate
+	// they are copied. Empty without a full copy.
+	DumpETA   string
+	DumpEndAt int64
+	// of the incremental catch-up: the lag, DelayCount.Time, at the rate it decreases. Without
+	// an ETA while the lag is steady or increases. applier only
+	CatchUpState string
+	CatchUpETA   string
+	CatchUpEndAt int64
+}
+
+// ThroughputStat is of the rows of the full copy applied with UseLoadData.
 type ThroughputStat struct {
-	Num  uint64
-	Time uint64
+	Num  uint64 // rows
+	Time uint64 // milliseconds spent on LOAD DATA
+}
+
+// TaskThroughput is the throughput of a task: its events, the rows of the full copy and the
+// transactions of the incremental replication, and the bytes of the messages sent by the
+// extractor or received by the applier. Events and Bytes count since the task started.
+type TaskThroughput struct {
+	Events  int64
+	Bytes   int64
+	Windows []*ThroughputWindow // 1m, 5m and 15m
+}
+
+// ThroughputWindow is the rates of a TaskThroughput over the last Window, e.g. "5m". A task
+// started within the window has the rates since its start.
+type ThroughputWindow struct {
+	Window       string
+	EventsPerSec float64
+	BytesPerSec  float64
+}
+
+// TxStat is of the transactions the applier applied and failed to apply. A failed attempt of a
+// retried transaction counts as failed, e.g. on a deadlock or a read-only target.
+type TxStat struct {
+	Succeeded int64
+	Failed    int64
+	// ErrorRatePct is the percentage of the failed ones since WindowStart (unix seconds). The
+	// window is reset every ErrorRateWindow of the job.
+	ErrorRatePct    float64
+	WindowSucceeded int64
+	WindowFailed    int64
+	WindowStart     int64
+}
+
+// BinlogHeartbeatStat is of the binlog connection of the extractor to the source. The source
+// sends heartbeats when it has no event to send for Period. The times are unix seconds, 0 if none.
+type BinlogHeartbeatStat struct {
+	Period        int64 // seconds. 0 if the heartbeats are disabled
+	LastHeartbeat int64
+	LastEvent     int64 // of the events and heartbeats
+}
+
+// BinlogCompressionStat is of the transactions compressed in the binlog of the source, with
+// binlog_transaction_compression of MySQL 8.0.20+.
+type BinlogCompressionStat struct {
+	Enabled           bool  // binlog_transaction_compression of the source, as the task started
+	Payloads          int64 // the compressed transactions read
+	CompressedBytes   int64
+	UncompressedBytes int64
+}
+
+// TaskCounters are the counters of a task which a stats reset sets back to zero.
+type TaskCounters struct {
+	TableStats       *TableStats        // applier only
+	EventFilterStats []*EventFilterStat // the source events matched by the rules. extractor only
+	TxSucceeded      int64              // applier only
+	TxFailed         int64              // failed attempts, the retries included. applier only
+}
+
+// FileSinkStat is of the files a FileSink task writes the messages of the extractor to.
+type FileSinkStat struct {
+	Dir           string
+	CurrentFile   string // the name of the file being written, in Dir
+	CurrentOffset int64
+	Files         int   // in Dir, the files of the previous runs included
+	BytesWritten  int64 // since the task started
+}
+
+// ExportStat is of the files an Export task writes the tables to.
+type ExportStat struct {
+	Dir      string // of the files of the run
+	Format   string // csv or parquet
+	Gtid     string // the GTID set of the snapshot
+	Complete bool   // all the tables are written
+	Files    []*ExportFileStat
+}
+
+// ExportFileStat is of a file an Export task has completed.
+type ExportFileStat struct {
+	File        string // the name of the file, in the Dir of ExportStat
+	TableSchema string
+	TableName   string
+	Rows        int64
+	Bytes       int64
+	Sha256      string // hex, of the content of the file
+}
+
+// EncryptionStat is of the data files written with EncryptDataAtRest. The bytes are of the
+// plaintexts, and the times in milliseconds.
+type EncryptionStat struct {
+	BytesEncrypted int64
+	BytesDecrypted int64
+	EncryptTime    int64
+	DecryptTime    int64
 }
 
 type MsgStat struct {
@@ -53,6 +181,176 @@ type BufferStat struct {
 	ApplierGroupTxQueueSize int
 	SendByTimeout           int
 	SendBySizeFull          int
+	// messages of the binlog sent by the extractor, each of up to ReadBatchSize transactions.
+	// (SendByTimeout + SendBySizeFull) / SendMessages is the average batch
+	SendMessages  int64
+	ReadBatchSize int
+
+	// conflict detection of the applier with ParallelWorkers > 1. Written keys are hashed
+	// into WriteSetBuckets buckets.
+	WriteSetBuckets int
+	// buckets written by transactions not yet applied
+	WriteSetInFlight int
+	// estimated chance of an unrelated key falling into an in-flight bucket (a false conflict)
+	WriteSetFalsePositiveRate float64
+	// transactions which waited for a conflicting transaction
+	WriteSetWaits int64
+
+	// the workers of the applier applying the binlog, ParallelWorkers unless resized
+	ApplierWorkers int
+
+	// transactions committed together by the applier, with AdaptiveGroup
+	ApplierGroupSize int
+	// the last changes of ApplierGroupSize, oldest first
+	ApplierGroupSizeChanges []*GroupSizeChange
+}
+
+// GroupSizeChange is a change of the group size of the applier with AdaptiveGroup.
+type GroupSizeChange struct {
+	From   int
+	To     int
+	Reason string
+	// unix nano
+	Timestamp int64
+}
+
+// TableRowCount is the result of comparing row counts of a source table and its target table.
+type TableRowCount struct {
+	TableSchema string
+	TableName   string
+	SourceCount int64
+	TargetCount int64
+	// TargetCount - SourceCount
+	Delta int64
+	// Delta is beyond RowCountTolerance
+	Diverged bool
+	// not compared since the table is under heavy writes
+	Skipped   bool
+	Timestamp int64
+}
+
+// TableCopyStat is the number of rows of a table copied in the full copy.
+type TableCopyStat struct {
+	TableSchema string
+	TableName   string
+	RowsCopied  int64
+}
+
+// DumpProgress is the checkpoint of an interrupted full copy, from which it is resumed.
+// It is saved with the task config in the alloc state, as the Gtid is.
+type DumpProgress struct {
+	// Gtid of the consistent snapshot of the first attempt. The incremental copy starts
+	// from it even if the rest is copied from a later snapshot: the replayed events
+	// overwrite the rows copied in between.
+	Gtid string
+	// "schema.table" of the completely copied tables, with the names on the source
+	CompletedTables []string
+	// the table being copied, with the names on the source, and the position after its
+	// last applied chunk
+	TableSchema string
+	TableName   string
+	Iteration   int64
+	LastMaxVals []string
+}
+
+// Started tells if the table has been completely or partially copied.
+func (p *DumpProgress) Started(schema, table string) bool {
+	if p == nil {
+		return false
+	}
+	return (p.TableSchema == schema && p.TableName == table) || p.Completed(schema, table)
+}
+
+// Completed tells if the table has been completely copied.
+func (p *DumpProgress) Completed(schema, table string) bool {
+	if p == nil {
+		return false
+	}
+	name := schema + "." + table
+	for _, t := range p.CompletedTables {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// EventFilterStat is the number of source events matched by an EventFilters rule.
+type EventFilterStat struct {
+	Rule    int // index of the rule in EventFilters
+	Action  string
+	Matched int64
+}
+
+// SubjectStat is the traffic of a task on a nats subject. Times are unix nanoseconds, 0 for never.
+type SubjectStat struct {
+	Subject      string
+	PublishMsgs  int64
+	PublishBytes int64
+	ReceiveMsgs  int64
+	ReceiveBytes int64
+	LastPublish  int64
+	LastReceive  int64
+}
+
+// CompressionStat is the size of messages before and after the compression.
+type CompressionStat struct {
+	Compression     string
+	RawBytes        int64
+	CompressedBytes int64
+}
+
+// MetadataCacheStat is the use of the cache of the table structures of a task.
+type MetadataCacheStat struct {
+	Size      int
+	Hits      int64
+	Misses    int64
+	Evictions int64 // by the size bound
+}
+
+// ConcurrentCopyStat is the watermark handoff of ConcurrentIncrementalCopy: the transactions
+// streamed during the full copy, held until the tables they change are copied.
+type ConcurrentCopyStat struct {
+	PendingTables int   // not copied yet
+	HeldTxs       int   // held in memory
+	HeldBytes     int64 // of the held transactions, in the binlog
+	ReleasedTxs   int64 // released after being held
+	Throttled     int64 // times the streaming waited for the held transactions to be released
+}
+
+// LatencyHistogram is the time the applier took to apply the transactions changing a table on
+// the target. The transactions applied together (e.g. in a group of AdaptiveGroup) are observed
+// once, as the last of them.
+type LatencyHistogram struct {
+	Table   string    // "schema.table"
+	Buckets []float64 // the upper bounds, in seconds. +Inf is implied
+	Counts  []uint64  // cumulative, by bucket, then +Inf
+	Count   uint64
+	Sum     float64 // seconds
+	// by bucket as Counts: the slowest transaction observed in the bucket since the last report.
+	// nil for none
+	Exemplars []*LatencyExemplar
+}
+
+// LatencyExemplar is a transaction observed in a LatencyHistogram.
+type LatencyExemplar struct {
+	Gtid      string  // "uuid:txid"
+	Value     float64 // seconds
+	Timestamp int64   // unix nanoseconds
+}
+
+// DeadlockStat is the deadlocks of the transactions of the applier on the target.
+type DeadlockStat struct {
+	Workers  int64 // among the workers of the applier, retried
+	External int64 // with the other sessions of the target, e.g. the locks of the applications
+	Retries  int64 // of the transactions of the workers, after the deadlocks among them
+}
+
+// TransportStat is the nats traffic of a task since it was (re)started.
+type TransportStat struct {
+	Subjects []*SubjectStat
+	// For the applier: the subjects of the paired extractor, as last reported by it.
+	PeerSubjects []*SubjectStat
 }
 
 type CurrentCoordinates struct {
@@ -64,26 +362,151 @@ type CurrentCoordinates struct {
 	ReadMasterLogPos   int64
 	RetrievedGtidSet   string
 	ExecutedGtidSet    string
+	ReplicationChannel string
+	// ReplicaLag is the Seconds_Behind_Master of the source with ReadFromReplica, -1 if its
+	// replication is stopped.
+	ReplicaLag int64
 }
 
+// Values of TaskStatistics.Status
+const (
+	// applying, or ready to apply with its connections to the target open
+	TaskStatusActive = "active"
+	// idle, with its connections to the target closed until the next transaction
+	TaskStatusIdle = "idle"
+	// the source refuses the connections of the extractor with too many connections, which are
+	// retried. Its max_connections should be raised
+	TaskStatusSourceConnectionSaturated = "source_connection_saturated"
+)
+
 type TaskStatistics struct {
 	CurrentCoordinates *CurrentCoordinates
 	TableStats         *TableStats
 	DelayCount         *DelayCount
+	GtidGap            *GtidGap
+	RowCounts          []*TableRowCount
+	TableCopyStats     []*TableCopyStat
+	EventFilterStats   []*EventFilterStat
+	DumpProgress       *DumpProgress // of the full copy. nil when it is not copying
+	DumpResumed        bool          // the full copy was resumed from a DumpProgress
+	Transport          *TransportStat
+	DumpCompression    *CompressionStat   // of the messages of the full copy sent by the extractor
+	MetadataCache      *MetadataCacheStat // of the target tables. applier only
 	ProgressPct        string
 	ExecMasterRowCount int64
 	ExecMasterTxCount  int64
 	ReadMasterRowCount int64
 	ReadMasterTxCount  int64
 	ETA                string
+	Completion         *CompletionEstimate // set by the client on each stats collection
 	Backlog            string
 	ThroughputStat     *ThroughputStat
+	TxStat             *TxStat // applier only
 	MsgStat            gonats.Statistics
+	MsgCodec           string // codec of the transactions sent or last received: v1 or v2. heterogeneous only
 	BufferStat         BufferStat
 	Stage              string
+	RowImage           string // binlog_row_image. FULL, MINIMAL or NOBLOB.
+	ServerUuid         string // server_uuid of the MySQL server, as last observed
+	ServerId           uint32 // server_id of the MySQL server, as last observed. extractor only
+	ReplicaServerId    uint32 // server_id of the extractor as a replica of the source. extractor only
+	BackendSwitches    int64  // times the source address led the extractor to another server on a reconnect
+	ReadOnlyPauses     int64  // times the applier paused because the target was read-only
+	Failovers          int64  // times the applier switched to another target instance
+	Status             string // TaskStatusActive or TaskStatusIdle of the applier, TaskStatusSourceConnectionSaturated of the extractor
+	IdleParks          int64  // times the applier closed its connections to the target when idle
+	ExecTimeouts       int64  // statements of the applier killed after MaxExecTime
 	Timestamp          int64
+
+	// of the binlog connection to the source. extractor only
+	BinlogHeartbeat *BinlogHeartbeatStat
+	// of the transactions compressed in the binlog of the source. extractor only
+	BinlogCompression *BinlogCompressionStat
+
+	// the replicated schemas renamed on the target, to their names on the target. extractor only
+	SchemaMapping map[string]string
+
+	// the deadlocks of the applied transactions. applier only
+	Deadlocks *DeadlockStat
+
+	// of ConcurrentIncrementalCopy, while the full copy is in progress. applier only
+	ConcurrentCopy *ConcurrentCopyStat
+
+	// the zero and the invalid dates converted for ZeroDatePolicy, by "schema.table". applier only
+	ZeroDateConversions map[string]int64
+
+	// the errors of the target ignored for IgnoreErrorCodes, by code. applier only
+	IgnoredErrors map[string]int64
+
+	// the Mode of the job, JobModeFull, JobModeCopyOnly or JobModeIncrementalOnly. extractor only
+	Mode string
+
+	// the Meta of the job, as of the last update of the allocation
+	JobMeta map[string]string
+
+	// TableStats, EventFilterStats and the totals of TxStat count since the last stats reset, at
+	// StatsResetAt, unix nanoseconds, 0 if none. SinceTaskStart counts them since the task
+	// started, and is never reset.
+	StatsResetAt   int64
+	SinceTaskStart *TaskCounters
+
+	// of the files written. FileSink only
+	FileSink *FileSinkStat
+
+	// of the files written. Export only
+	Export *ExportStat
+
+	// of the data files written with EncryptDataAtRest, since the task started. nil without
+	Encryption *EncryptionStat
+
+	// the events and bytes of the task, with their rates over the windows of the stats
+	// collection. ThroughputStat is of LOAD DATA only
+	Throughput *TaskThroughput
 }
 
 type AllocStatistics struct {
 	Tasks map[string]*TaskStatistics
 }
+
+// StatsSubjectPrefix is the prefix of the nats subjects the tasks publish their statistics to.
+// An aggregator subscribes to "dtle.stats.>" for all the jobs on a nats server.
+const StatsSubjectPrefix = "dtle.stats."
+
+// StatsSubject is the nats subject of the statistics of the tasks of a job.
+func StatsSubject(jobID string) string {
+	return StatsSubjectPrefix + jobID
+}
+
+// StatsReport is published as JSON by a task on StatsSubject every StatsPublishInterval.
+type StatsReport struct {
+	JobID    string
+	AllocID  string
+	TaskType string
+	Stats    *TaskStatistics
+}
+
+// AllocReport is the summary of an allocation whose task has completed, e.g. a one-shot
+// migration with SkipIncrementalCopy. It is kept in the alloc dir as an audit artifact.
+type AllocReport struct {
+	AllocID    string
+	JobID      string
+	JobName    string
+	Task       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   string
+	// the Gtid set replicated by the task
+	Gtid            string
+	TotalRowsCopied int64
+	Tables          []*TableCopyStat
+	TableStats      *TableStats
+	// the last row count comparison, if RowCountCheckInterval is set
+	RowCounts []*TableRowCount
+	Events    []*TaskEvent
+}
+
+// QuiesceResponse is the result of the cutover of an allocation to the target: the GTID set
+// of the source applied by its Dest task.
+type QuiesceResponse struct {
+	Gtid string
+}
@@ -21,10 +21,15 @@ import (
 const (
 	TaskTypeSrc  = "Src"
 	TaskTypeDest = "Dest"
+	// TaskTypeExport writes the tables of the job to files and completes, with the MySQL driver.
+	TaskTypeExport = "Export"
 
 	TaskDriverMySQL  = "MySQL"
 	TaskDriverKafka  = "Kafka"
 	TaskDriverOracle = "Oracle"
+
+	// TaskDriverFileSink writes the binlog read by the Src task to files, on the Dest.
+	TaskDriverFileSink = "FileSink"
 )
 
 // Task is a single process typically that is executed as part of a task.
@@ -84,12 +89,31 @@ func (t *Task) Canonicalize(job *Job) {
 	if len(t.Config) == 0 {
 		t.Config = nil
 	}
+	t.addCapabilityConstraints()
 }
 
 func (t *Task) GoString() string {
 	return fmt.Sprintf("*%#v", *t)
 }
 
+// Pinned returns whether the task is pinned to a node, by NodeID or NodeName.
+func (t *Task) Pinned() bool {
+	return t.NodeID != "" || t.NodeName != ""
+}
+
+// sameNode returns whether the tasks are pinned to the same node, if known
+// from their NodeID or their NodeName.
+func (t *Task) sameNode(other *Task) (same bool, known bool) {
+	switch {
+	case t.NodeID != "" && other.NodeID != "":
+		return t.NodeID == other.NodeID, true
+	case t.NodeName != "" && other.NodeName != "":
+		return t.NodeName == other.NodeName, true
+	default:
+		return false, false
+	}
+}
+
 // Validate is used to sanity check a task
 func (t *Task) Validate() error {
 	var mErr multierror.Error
@@ -115,7 +139,7 @@ const (
 	TaskStateDead     = "dead"    // Terminal state of task.
 	TaskStateStop     = "stop"
 	TaskStateQueued   = "queued"
-	TaskStateComplete = "complete"
+	TaskStateComplete = "complete" // Terminal state of a task which finished successfully.
 	TaskStateFailed   = "failed"
 	TaskStateStarting = "starting"
 	TaskStateLost     = "lost"
@@ -140,6 +164,27 @@ type TaskState struct {
 
 	// Series of task events that transition the state of the task.
 	Events []*TaskEvent
+
+	// ResolvedConfig is the config the task was last started with. It is not modified once set.
+	ResolvedConfig *ResolvedTaskConfig `json:"resolved_config,omitempty"`
+}
+
+// ResolvedTaskConfig is the config of a task as run by the driver: with the defaults and the
+// values resolved on the client filled in, and the secrets redacted.
+type ResolvedTaskConfig struct {
+	Config map[string]interface{}
+	// ModifyIndex of the job the config is derived from
+	ModifyIndex uint64
+}
+
+// ResolvedTable is a table replicated by a task, as resolved on the source from the
+// ReplicateDoDb and ReplicateIgnoreDb of the job, with its name on the target.
+type ResolvedTable struct {
+	TableSchema  string
+	TableName    string
+	TargetSchema string
+	TargetTable  string
+	Where        string `json:",omitempty"`
 }
 
 func (ts *TaskState) Copy() *TaskState {
@@ -151,6 +196,7 @@ func (ts *TaskState) Copy() *TaskState {
 	copy.Failed = ts.Failed
 	copy.StartedAt = ts.StartedAt
 	copy.FinishedAt = ts.FinishedAt
+	copy.ResolvedConfig = ts.ResolvedConfig
 
 	if ts.Events != nil {
 		copy.Events = make([]*TaskEvent, len(ts.Events))
@@ -163,6 +209,10 @@ func (ts *TaskState) Copy() *TaskState {
 
 // Successful returns whether a task finished successfully.
 func (ts *TaskState) Successful() bool {
+	if ts.State == TaskStateComplete {
+		return true
+	}
+
 	l := len(ts.Events)
 	if ts.State != TaskStateDead || l == 0 {
 		return false
@@ -202,6 +252,10 @@ const (
 	// TaskKilled indicates a user has killed the task.
 	TaskKilled = "Killed"
 
+	// TaskStopping indicates that the task is being stopped gracefully: it
+	// finishes the work in progress before it is killed.
+	TaskStopping = "Stopping"
+
 	// TaskRestarting indicates that task terminated and is being restarted.
 	TaskRestarting = "Restarting"
 
@@ -219,6 +273,28 @@ const (
 
 	// TaskLeaderDead indicates that the leader task within the has finished.
 	TaskLeaderDead = "Leader Task Dead"
+
+	// TaskDriverMessage is an informational event message emitted by
+	// drivers such as when they're performing a long running action like
+	// dumping a large table.
+	TaskDriverMessage = "Driver"
+
+	// TaskStartPositionSaved indicates that the start position captured by the
+	// task (see StartPosition of the MySQL driver) is saved.
+	TaskStartPositionSaved = "Start Position Saved"
+
+	// TaskStatsReset indicates that the counters of the task were reset, see
+	// TaskCounters. The counters of the graphs drop to zero at it.
+	TaskStatsReset = "Stats Reset"
+
+	// TaskStartupTimeout indicates that the task has not started within its
+	// start deadline, see StartupTimeoutError. It is then restarted by the
+	// restart policy.
+	TaskStartupTimeout = "Startup Timeout"
+
+	// TaskQuiesced indicates that the target task has applied the final
+	// position of the source for a cutover, see Client.Quiesce.
+	TaskQuiesced = "Quiesced"
 )
 
 // TaskEvent is an event that effects the state of a task and contains meta-data
@@ -384,9 +460,10 @@ func (e *TaskEvent) SetDriverMessage(m string) *TaskEvent {
 }
 
 type TaskUpdate struct {
-	JobID    string
-	Gtid     string
-	NatsAddr string
+	JobID        string
+	Gtid         string
+	NatsAddr     string
+	DumpProgress *DumpProgress
 }
 
 const (
@@ -420,3 +497,15 @@ func (r *WaitResult) String() string {
 	return fmt.Sprintf("Wait returned exit code %v, and error %v",
 		r.ExitCode, r.Err)
 }
+
+// StartupTimeoutError is the error of a task which has not started within its
+// start deadline. Phase is the initialization phase it was stuck in.
+type StartupTimeoutError struct {
+	Phase    string
+	Deadline time.Duration
+}
+
+func (e *StartupTimeoutError) Error() string {
+	return fmt.Sprintf("startup timeout: the task has not started within %v, stuck in phase %v",
+		e.Deadline, e.Phase)
+}
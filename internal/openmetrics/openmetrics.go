@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package openmetrics writes metrics in the OpenMetrics text format, which the
+// vendored Prometheus client predates, with the exemplars of the histograms.
+package openmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ContentType is the content type of the OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Accepts tells whether the Accept header of a request accepts the OpenMetrics text format.
+func Accepts(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.Split(part, ";")[0])
+		if mediaType == "application/openmetrics-text" {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelPair is a label of a metric or of an exemplar.
+type LabelPair struct {
+	Name  string
+	Value string
+}
+
+// Exemplar is a sample of a bucket of a histogram, e.g. the transaction observed.
+type Exemplar struct {
+	Labels    []LabelPair
+	Value     float64
+	Timestamp time.Time
+}
+
+// Histogram is a metric of a HistogramFamily. Counts are cumulative, by bucket, then +Inf, and
+// Exemplars are by bucket as Counts, nil for none.
+type Histogram struct {
+	Labels    []LabelPair
+	Buckets   []float64 // the upper bounds. +Inf is implied
+	Counts    []uint64
+	Count     uint64
+	Sum       float64
+	Exemplars []*Exemplar
+}
+
+// HistogramFamily is a histogram with exemplars, which the dto.MetricFamily of the vendored
+// client cannot hold.
+type HistogramFamily struct {
+	Name    string
+	Help    string
+	Metrics []*Histogram
+}
+
+// Write writes the families, then the histograms, in the OpenMetrics text format. The names of
+// the histograms must not be those of the families.
+func Write(w io.Writer, families []*dto.MetricFamily, histograms []*HistogramFamily) error {
+	bw := bufio.NewWriter(w)
+	sorted := append([]*dto.MetricFamily(nil), families...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+	for _, mf := range sorted {
+		if err := writeFamily(bw, mf); err != nil {
+			return err
+		}
+	}
+	for _, hf := range histograms {
+		writeHistogramFamily(bw, hf)
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+func writeFamily(w *bufio.Writer, mf *dto.MetricFamily) error {
+	name := mf.GetName()
+	var typ string
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		typ = "counter"
+		// the samples of a counter are name_total
+		name = strings.TrimSuffix(name, "_total")
+	case dto.MetricType_GAUGE:
+		typ = "gauge"
+	case dto.MetricType_SUMMARY:
+		typ = "summary"
+	case dto.MetricType_HISTOGRAM:
+		typ = "histogram"
+	case dto.MetricType_UNTYPED:
+		typ = "unknown"
+	default:
+		return fmt.Errorf("unknown type %v of metric %v", mf.GetType(), name)
+	}
+	writeHeader(w, name, typ, mf.GetHelp())
+
+	for _, m := range mf.GetMetric() {
+		labels := labelsOf(m)
+		ts := ""
+		if m.TimestampMs != nil {
+			ts = " " + formatFloat(float64(m.GetTimestampMs())/1000)
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			writeSample(w, name+"_total", labels, formatFloat(m.GetCounter().GetValue()), ts)
+		case dto.MetricType_GAUGE:
+			writeSample(w, name, labels, formatFloat(m.GetGauge().GetValue()), ts)
+		case dto.MetricType_UNTYPED:
+			writeSample(w, name, labels, formatFloat(m.GetUntyped().GetValue()), ts)
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.GetQuantile() {
+				writeSample(w, name, withLabel(labels, "quantile", formatFloat(q.GetQuantile())),
+					formatFloat(q.GetValue()), ts)
+			}
+			writeSample(w, name+"_sum", labels, formatFloat(s.GetSampleSum()), ts)
+			writeSample(w, name+"_count", labels, strconv.FormatUint(s.GetSampleCount(), 10), ts)
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			infSeen := false
+			for _, b := range h.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), 1) {
+					infSeen = true
+				}
+				writeSample(w, name+"_bucket", withLabel(labels, "le", formatFloat(b.GetUpperBound())),
+					strconv.FormatUint(b.GetCumulativeCount(), 10), ts)
+			}
+			if !infSeen {
+				writeSample(w, name+"_bucket", withLabel(labels, "le", "+Inf"),
+					strconv.FormatUint(h.GetSampleCount(), 10), ts)
+			}
+			writeSample(w, name+"_sum", labels, formatFloat(h.GetSampleSum()), ts)
+			writeSample(w, name+"_count", labels, strconv.FormatUint(h.GetSampleCount(), 10), ts)
+		}
+	}
+	return nil
+}
+
+func writeHistogramFamily(w *bufio.Writer, hf *HistogramFamily) {
+	writeHeader(w, hf.Name, "histogram", hf.Help)
+	for _, h := range hf.Metrics {
+		for i, count := range h.Counts {
+			le := "+Inf"
+			if i < len(h.Buckets) {
+				le = formatFloat(h.Buckets[i])
+			}
+			value := strconv.FormatUint(count, 10)
+			if i < len(h.Exemplars) && h.Exemplars[i] != nil {
+				ex := h.Exemplars[i]
+				value += fmt.Sprintf(" # %s %s %s", formatLabels(ex.Labels), formatFloat(ex.Value),
+					formatFloat(float64(ex.Timestamp.UnixNano())/1e9))
+			}
+			writeSample(w, hf.Name+"_bucket", withLabel(h.Labels, "le", le), value, "")
+		}
+		writeSample(w, hf.Name+"_sum", h.Labels, formatFloat(h.Sum), "")
+		writeSample(w, hf.Name+"_count", h.Labels, strconv.FormatUint(h.Count, 10), "")
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escape(help))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels []LabelPair, value, ts string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteString(formatLabels(labels))
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteString(ts)
+	w.WriteByte('\n')
+}
+
+func labelsOf(m *dto.Metric) []LabelPair {
+	labels := make([]LabelPair, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		labels = append(labels, LabelPair{Name: l.GetName(), Value: l.GetValue()})
+	}
+	return labels
+}
+
+func withLabel(labels []LabelPair, name, value string) []LabelPair {
+	return append(append([]LabelPair(nil), labels...), LabelPair{Name: name, Value: value})
+}
+
+func formatLabels(labels []LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, l.Name, escape(l.Value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// formatFloat formats a float as OpenMetrics does, with a decimal point for the integers.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
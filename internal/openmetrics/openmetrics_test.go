@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package openmetrics
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestWrite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rows_total", Help: "Rows \"applied\"."}, []string{"task_name"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_size", Help: "Queue size."})
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "batch_seconds", Help: "Batch time.", Buckets: []float64{0.5, 1}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "rpc_seconds", Help: "RPC time."})
+	reg.MustRegister(counter, gauge, hist, summary)
+	counter.WithLabelValues("job1_Dest").Add(3)
+	gauge.Set(7)
+	hist.Observe(0.2)
+	hist.Observe(0.7)
+	summary.Observe(1.5)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Unix(1520879607, 789000000)
+	latency := &HistogramFamily{
+		Name: "apply_latency_seconds",
+		Help: "Apply latency.",
+		Metrics: []*Histogram{{
+			Labels:  []LabelPair{{Name: "task_name", Value: "job1_Dest"}, {Name: "table", Value: "db1.t1"}},
+			Buckets: []float64{0.01, 1},
+			Counts:  []uint64{2, 5, 6},
+			Count:   6,
+			Sum:     3.25,
+			Exemplars: []*Exemplar{
+				{Labels: []LabelPair{{Name: "gtid", Value: "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:8"}}, Value: 0.008, Timestamp: at},
+				nil,
+				{Labels: []LabelPair{{Name: "gtid", Value: "3c1a8b5e-7e8c-11e9-8f9e-2a86e4085a59:12"}}, Value: 2.5, Timestamp: at},
+			},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, families, []*HistogramFamily{latency}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	out := buf.String()
+	if !strings.HasSuffix(out, "\n# EOF\n") {
+		t.Errorf("no # EOF at the end:\n%v", out)
+	}
+	for _, want := range []string{
+		"# TYPE rows counter\n",
+		`rows_total{task_name="job1_Dest"} 3.0` + "\n",
+		`# HELP rows Rows \"applied\".` + "\n",
+		`batch_seconds_bucket{le="+Inf"} 2` + "\n",
+		`rpc_seconds{quantile="0.5"} 1.5` + "\n",
+		`apply_latency_seconds_bucket{task_name="job1_Dest",table="db1.t1",le="1.0"} 5` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in:\n%v", want, out)
+		}
+	}
+
+	// the exemplars, as in the ABNF of OpenMetrics, within their buckets
+	exemplarRe := regexp.MustCompile(`^apply_latency_seconds_bucket\{[^}]*le="([^"]+)"\} [0-9]+ # \{gtid="([0-9a-f-]+:[0-9]+)"\} (\S+) (\S+)$`)
+	exemplars := 0
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, " # ") {
+			continue
+		}
+		m := exemplarRe.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("bad exemplar line %q", line)
+			continue
+		}
+		exemplars++
+		le, _ := strconv.ParseFloat(m[1], 64)
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil || value > le {
+			t.Errorf("exemplar %v of the bucket %v: %v", m[3], m[1], err)
+		}
+		if ts, err := strconv.ParseFloat(m[4], 64); err != nil || ts != 1520879607.789 {
+			t.Errorf("exemplar timestamp %v, want 1520879607.789", m[4])
+		}
+	}
+	if exemplars != 2 {
+		t.Errorf("%v exemplars, want 2", exemplars)
+	}
+
+	// The text parser of the Prometheus client parses the rest, once the exemplars are removed.
+	// It reads the samples of a counter, name_total, as an untyped family of their own, and does
+	// not escape the quotes of the help.
+	var stripped bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if i := strings.Index(line, " # "); i > 0 {
+			line = line[:i]
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			line = strings.Replace(line, `\"`, `"`, -1)
+		}
+		stripped.WriteString(line + "\n")
+	}
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(&stripped)
+	if err != nil {
+		t.Fatalf("TextToMetricFamilies() error = %v", err)
+	}
+	if v := parsed["rows_total"].GetMetric()[0].GetUntyped().GetValue(); v != 3 {
+		t.Errorf("rows_total = %v, want 3", v)
+	}
+	if v := parsed["queue_size"].GetMetric()[0].GetGauge().GetValue(); v != 7 {
+		t.Errorf("queue_size = %v, want 7", v)
+	}
+	if h := parsed["batch_seconds"].GetMetric()[0].GetHistogram(); h.GetSampleCount() != 2 || h.GetBucket()[0].GetCumulativeCount() != 1 {
+		t.Errorf("batch_seconds = %v, want 2 observed, 1 within 0.5", h)
+	}
+	if s := parsed["rpc_seconds"].GetMetric()[0].GetSummary(); s.GetSampleCount() != 1 || s.GetSampleSum() != 1.5 {
+		t.Errorf("rpc_seconds = %v, want 1 observed", s)
+	}
+	h := parsed["apply_latency_seconds"].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 6 || h.GetSampleSum() != 3.25 || len(h.GetBucket()) != 3 || h.GetBucket()[1].GetCumulativeCount() != 5 {
+		t.Errorf("apply_latency_seconds = %v", h)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5": true,
+		"text/plain;version=0.0.4;q=0.5,*/*;q=0.1":                                   false,
+		"": false,
+	} {
+		if got := Accepts(accept); got != want {
+			t.Errorf("Accepts(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// the types of the thrift compact protocol, of the metadata of Parquet
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter encodes a struct with the thrift compact protocol. The ids of the fields are
+// deltas from the previous field of their struct, hence the stack of the enclosing structs.
+type compactWriter struct {
+	buf   bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (c *compactWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - c.last; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.varint(int64(id))
+	}
+	c.last = id
+}
+
+func (c *compactWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	// zigzag, as binary.PutVarint
+	n := binary.PutVarint(b[:], v)
+	c.buf.Write(b[:n])
+}
+
+func (c *compactWriter) uvarint(v uint64) {
+	c.buf.Write(appendUvarint(nil, v))
+}
+
+func (c *compactWriter) i32(id int16, v int32) {
+	c.fieldHeader(id, compactI32)
+	c.varint(int64(v))
+}
+
+func (c *compactWriter) i64(id int16, v int64) {
+	c.fieldHeader(id, compactI64)
+	c.varint(v)
+}
+
+func (c *compactWriter) binary(id int16, b []byte) {
+	c.fieldHeader(id, compactBinary)
+	c.elemBinary(b)
+}
+
+func (c *compactWriter) beginStruct(id int16) {
+	c.fieldHeader(id, compactStruct)
+	c.elemStructBegin()
+}
+
+func (c *compactWriter) endStruct() {
+	c.elemStructEnd()
+}
+
+// stop ends the top level struct.
+func (c *compactWriter) stop() {
+	c.buf.WriteByte(0)
+}
+
+func (c *compactWriter) listBegin(id int16, elemType byte, n int) {
+	c.fieldHeader(id, compactList)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		c.buf.WriteByte(0xf0 | elemType)
+		c.uvarint(uint64(n))
+	}
+}
+
+func (c *compactWriter) elemI32(v int32) {
+	c.varint(int64(v))
+}
+
+func (c *compactWriter) elemBinary(b []byte) {
+	c.uvarint(uint64(len(b)))
+	c.buf.Write(b)
+}
+
+func (c *compactWriter) elemStructBegin() {
+	c.stack = append(c.stack, c.last)
+	c.last = 0
+}
+
+func (c *compactWriter) elemStructEnd() {
+	c.buf.WriteByte(0)
+	c.last = c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
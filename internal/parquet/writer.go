@@ -0,0 +1,281 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package parquet writes Parquet files of optional byte array columns, uncompressed and PLAIN
+// encoded, one data page per column chunk. It is enough for the exports of the tables, whose
+// values are the text of MySQL, and has none of the dependencies of a full implementation.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// magic is at the start and at the end of a Parquet file.
+const magic = "PAR1"
+
+// the enums of parquet.thrift
+const (
+	typeByteArray          = 6
+	repetitionOptional     = 1
+	convertedTypeUTF8      = 0
+	encodingPlain          = 0
+	encodingRLE            = 3
+	codecUncompressed      = 0
+	pageTypeDataPage       = 0
+	createdBy              = "dtle"
+	fileMetaDataVersion    = 1
+	definitionLevelNull    = 0
+	definitionLevelNotNull = 1
+)
+
+// Column is a column of a file. Its values are byte arrays, nil for NULL.
+type Column struct {
+	Name string
+	// UTF8 annotates the values as strings, else they are binary
+	UTF8 bool
+}
+
+// Writer writes rows to a Parquet file. The rows are buffered in memory until Flush, which
+// writes them as a row group, and Close writes the footer.
+type Writer struct {
+	w       io.Writer
+	columns []Column
+	offset  int64
+
+	// the buffered rows, by column
+	values   [][][]byte
+	rows     int64
+	buffered int64
+
+	rowGroups []*rowGroup
+	totalRows int64
+	closed    bool
+}
+
+type rowGroup struct {
+	columns   []*columnChunk
+	rows      int64
+	totalSize int64
+}
+
+type columnChunk struct {
+	offset int64 // of the page header
+	values int64
+	size   int64 // of the page, header included
+}
+
+// NewWriter starts a file of columns on w.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet: no columns")
+	}
+	pw := &Writer{
+		w:       w,
+		columns: columns,
+		values:  make([][][]byte, len(columns)),
+	}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// Write buffers a row, a value per column.
+func (w *Writer) Write(row [][]byte) error {
+	if w.closed {
+		return fmt.Errorf("parquet: write after close")
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: row of %v values, %v columns", len(row), len(w.columns))
+	}
+	for i, v := range row {
+		if v != nil {
+			// nil is NULL
+			v = append([]byte{}, v...)
+		}
+		w.values[i] = append(w.values[i], v)
+		w.buffered += int64(len(v))
+	}
+	w.rows++
+	return nil
+}
+
+// Buffered returns the bytes of the values buffered since the last Flush.
+func (w *Writer) Buffered() int64 {
+	return w.buffered
+}
+
+// Size returns the bytes written to the file, the buffered rows and the footer excluded.
+func (w *Writer) Size() int64 {
+	return w.offset
+}
+
+// Flush writes the buffered rows as a row group.
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rg := &rowGroup{rows: w.rows}
+	for i := range w.columns {
+		chunk, err := w.writeColumnChunk(w.values[i])
+		if err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, chunk)
+		rg.totalSize += chunk.size
+		w.values[i] = nil
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.totalRows += w.rows
+	w.rows = 0
+	w.buffered = 0
+	return nil
+}
+
+// writeColumnChunk writes the values of a column of a row group as a data page: the definition
+// levels, then the values which are not NULL.
+func (w *Writer) writeColumnChunk(values [][]byte) (*columnChunk, error) {
+	var page bytes.Buffer
+	levels := definitionLevels(values)
+	binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+	page.Write(levels)
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+		page.Write(v)
+	}
+
+	var header compactWriter
+	header.i32(1, pageTypeDataPage)
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(page.Len()))
+	header.beginStruct(5)
+	header.i32(1, int32(len(values)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.endStruct()
+	header.stop()
+
+	chunk := &columnChunk{
+		offset: w.offset,
+		values: int64(len(values)),
+		size:   int64(header.buf.Len() + page.Len()),
+	}
+	if err := w.write(header.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.write(page.Bytes()); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// definitionLevels encodes the definition levels of the values, 1 bit wide, as runs of the
+// RLE/bit-packing hybrid encoding.
+func definitionLevels(values [][]byte) []byte {
+	var buf []byte
+	level := func(v []byte) byte {
+		if v == nil {
+			return definitionLevelNull
+		}
+		return definitionLevelNotNull
+	}
+	for i := 0; i < len(values); {
+		l := level(values[i])
+		run := 1
+		for i+run < len(values) && level(values[i+run]) == l {
+			run++
+		}
+		buf = appendUvarint(buf, uint64(run)<<1)
+		buf = append(buf, l)
+		i += run
+	}
+	return buf
+}
+
+// Rows returns the rows written, the buffered ones included.
+func (w *Writer) Rows() int64 {
+	return w.totalRows + w.rows
+}
+
+// Close flushes the buffered rows and writes the footer. It does not close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+
+	var meta compactWriter
+	meta.i32(1, fileMetaDataVersion)
+	meta.listBegin(2, compactStruct, len(w.columns)+1)
+	meta.elemStructBegin()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(w.columns)))
+	meta.elemStructEnd()
+	for _, c := range w.columns {
+		meta.elemStructBegin()
+		meta.i32(1, typeByteArray)
+		meta.i32(3, repetitionOptional)
+		meta.binary(4, []byte(c.Name))
+		if c.UTF8 {
+			meta.i32(6, convertedTypeUTF8)
+		}
+		meta.elemStructEnd()
+	}
+	meta.i64(3, w.totalRows)
+	meta.listBegin(4, compactStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		meta.elemStructBegin()
+		meta.listBegin(1, compactStruct, len(rg.columns))
+		for i, chunk := range rg.columns {
+			meta.elemStructBegin()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, typeByteArray)
+			meta.listBegin(2, compactI32, 2)
+			meta.elemI32(encodingPlain)
+			meta.elemI32(encodingRLE)
+			meta.listBegin(3, compactBinary, 1)
+			meta.elemBinary([]byte(w.columns[i].Name))
+			meta.i32(4, codecUncompressed)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.elemStructEnd()
+		}
+		meta.i64(2, rg.totalSize)
+		meta.i64(3, rg.rows)
+		meta.elemStructEnd()
+	}
+	meta.binary(6, []byte(createdBy))
+	meta.stop()
+
+	if err := w.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	var footer [8]byte
+	binary.LittleEndian.PutUint32(footer[:4], uint32(meta.buf.Len()))
+	copy(footer[4:], magic)
+	return w.write(footer[:])
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// compactReader decodes the structs of the thrift compact protocol into maps of their fields,
+// by id: int64, []byte, []interface{} or map[int16]interface{}.
+type compactReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case compactI32, compactI64:
+		v, n := binary.Varint(r.data[r.pos:])
+		r.pos += n
+		return v
+	case compactBinary:
+		l := int(r.uvarint())
+		b := r.data[r.pos : r.pos+l]
+		r.pos += l
+		return b
+	case compactList:
+		header := r.data[r.pos]
+		r.pos++
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case compactStruct:
+		fields := make(map[int16]interface{})
+		var last int16
+		for {
+			header := r.data[r.pos]
+			r.pos++
+			if header == 0 {
+				return fields
+			}
+			id := last + int16(header>>4)
+			if header>>4 == 0 {
+				v, n := binary.Varint(r.data[r.pos:])
+				r.pos += n
+				id = int16(v)
+			}
+			fields[id] = r.value(header & 0x0f)
+			last = id
+		}
+	default:
+		panic(fmt.Sprintf("unexpected type %v", typ))
+	}
+}
+
+func field(s interface{}, ids ...int16) interface{} {
+	for _, id := range ids {
+		s = s.(map[int16]interface{})[id]
+	}
+	return s
+}
+
+// readFile reads the columns of a file written by Writer, by the footer.
+func readFile(t *testing.T, data []byte) (names []string, columns [][][]byte) {
+	t.Helper()
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatalf("no magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &compactReader{data: data[len(data)-8-footerLen : len(data)-8]}
+	meta := footer.value(compactStruct)
+
+	schema := field(meta, 2).([]interface{})
+	if n := field(schema[0], 5).(int64); int(n) != len(schema)-1 {
+		t.Fatalf("root of %v children, %v columns", n, len(schema)-1)
+	}
+	for _, s := range schema[1:] {
+		names = append(names, string(field(s, 4).([]byte)))
+	}
+	columns = make([][][]byte, len(names))
+	var rows int64
+	for _, rg := range field(meta, 4).([]interface{}) {
+		rows += field(rg, 3).(int64)
+		for i, chunk := range field(rg, 1).([]interface{}) {
+			offset := int(field(chunk, 3, 9).(int64))
+			page := &compactReader{data: data, pos: offset}
+			header := page.value(compactStruct)
+			size := int(field(header, 3).(int64))
+			if int(field(chunk, 3, 7).(int64)) != page.pos-offset+size {
+				t.Errorf("column chunk of %v bytes, %v with the page header", field(chunk, 3, 7), page.pos-offset+size)
+			}
+			body := data[page.pos : page.pos+size]
+			levelsLen := int(binary.LittleEndian.Uint32(body))
+			levels := &compactReader{data: body[4 : 4+levelsLen]}
+			values := body[4+levelsLen:]
+			for levels.pos < len(levels.data) {
+				run := int(levels.uvarint() >> 1)
+				level := levels.data[levels.pos]
+				levels.pos++
+				for j := 0; j < run; j++ {
+					if level == definitionLevelNull {
+						columns[i] = append(columns[i], nil)
+						continue
+					}
+					l := int(binary.LittleEndian.Uint32(values))
+					columns[i] = append(columns[i], values[4:4+l])
+					values = values[4+l:]
+				}
+			}
+			if len(values) != 0 {
+				t.Errorf("%v bytes of values left in column %v", len(values), names[i])
+			}
+		}
+	}
+	if n := field(meta, 3).(int64); n != rows {
+		t.Errorf("num_rows %v, %v in the row groups", n, rows)
+	}
+	return names, columns
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{{Name: "id", UTF8: true}, {Name: "data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantIds, wantData [][]byte
+	for i := 0; i < 40; i++ {
+		id := []byte(fmt.Sprint(i))
+		var data []byte
+		if i%3 != 0 {
+			data = []byte{byte(i), 0}
+		}
+		if err := w.Write([][]byte{id, data}); err != nil {
+			t.Fatal(err)
+		}
+		wantIds = append(wantIds, id)
+		wantData = append(wantData, data)
+		if i == 16 {
+			// another row group
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Write([][]byte{nil}); err == nil {
+		t.Errorf("Write() of a value for 2 columns: no error")
+	}
+	if w.Rows() != 40 {
+		t.Errorf("Rows() = %v, want 40", w.Rows())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if int64(buf.Len()) != w.Size() {
+		t.Errorf("Size() = %v, %v bytes written", w.Size(), buf.Len())
+	}
+
+	names, columns := readFile(t, buf.Bytes())
+	if !reflect.DeepEqual(names, []string{"id", "data"}) {
+		t.Errorf("columns %v", names)
+	}
+	if !reflect.DeepEqual(columns[0], wantIds) || !reflect.DeepEqual(columns[1], wantData) {
+		t.Errorf("read %q, want %q and %q", columns, wantIds, wantData)
+	}
+}
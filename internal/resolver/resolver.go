@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package resolver resolves the hostnames of the servers and the MySQL endpoints at every
+// connection attempt, so that a DNS change (e.g. a failover by swapping a CNAME) is followed
+// without restarting the agent. The addresses are cached for a short TTL.
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+// Values of the address family preference, see Resolver.Configure.
+const (
+	PreferNone = ""
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+)
+
+const (
+	// DefaultTTL is how long the addresses of a host are used before it is resolved again.
+	DefaultTTL = 5 * time.Second
+
+	lookupTimeout = 5 * time.Second
+)
+
+// LookupFunc looks up the addresses of a host, as net.Resolver.LookupIPAddr.
+type LookupFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// Resolver resolves hostnames with a TTL cache. It is safe for concurrent use.
+type Resolver struct {
+	lock   sync.Mutex
+	lookup LookupFunc
+	ttl    time.Duration
+	prefer string
+	logger *log.Logger
+	now    func() time.Time
+	cache  map[string]*entry
+}
+
+type entry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// Default is the resolver of the agent, configured by the client.
+var Default = New(net.DefaultResolver.LookupIPAddr, DefaultTTL)
+
+// New returns a resolver looking up the hosts with lookup, caching their addresses for ttl.
+func New(lookup LookupFunc, ttl time.Duration) *Resolver {
+	return &Resolver{
+		lookup: lookup,
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  make(map[string]*entry),
+	}
+}
+
+// ValidatePrefer checks a preference of address family.
+func ValidatePrefer(prefer string) error {
+	switch prefer {
+	case PreferNone, PreferIPv4, PreferIPv6:
+		return nil
+	default:
+		return fmt.Errorf("unknown address family %q. expect %v or %v", prefer, PreferIPv4, PreferIPv6)
+	}
+}
+
+// Configure sets the address family tried first, PreferNone for the order of the DNS, and the
+// logger of the changes of the addresses, which may be nil.
+func (r *Resolver) Configure(prefer string, logger *log.Logger) error {
+	if err := ValidatePrefer(prefer); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prefer = prefer
+	r.logger = logger
+	r.cache = make(map[string]*entry)
+	return nil
+}
+
+// LookupIP returns the addresses of host, those of the preferred family first. An IP is
+// returned as is. If the lookup fails, the last addresses of host are returned, if any, so
+// that a DNS outage does not break the connections.
+func (r *Resolver) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.lock.Lock()
+	last := r.cache[host]
+	if last != nil && r.now().Before(last.expires) {
+		r.lock.Unlock()
+		return last.ips, nil
+	}
+	lookup := r.lookup
+	r.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	addrs, err := lookup(ctx, host)
+	cancel()
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no address of host %v", host)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err != nil {
+		if last != nil {
+			if r.logger != nil {
+				r.logger.Debugf("agent: Failed to resolve %v, using its last addresses %v: %v", host, last.ips, err)
+			}
+			return last.ips, nil
+		}
+		return nil, err
+	}
+	ips := sortIPs(addrs, r.prefer)
+	if last != nil && !sameIPs(last.ips, ips) && r.logger != nil {
+		r.logger.Printf("agent: The addresses of %v changed from %v to %v", host, last.ips, ips)
+	}
+	r.cache[host] = &entry{ips: ips, expires: r.now().Add(r.ttl)}
+	return ips, nil
+}
+
+// ResolveTCPAddr resolves "host:port" to the first address of the host.
+func (r *Resolver) ResolveTCPAddr(hostport string) (*net.TCPAddr, error) {
+	host, port, err := splitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ips[0], Port: port}, nil
+}
+
+// Dial connects to "host:port" over TCP, trying the addresses of the host in order, each
+// within timeout.
+func (r *Resolver) Dial(hostport string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := splitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func splitHostPort(hostport string) (host string, port int, err error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, err
+	}
+	if port, err = net.LookupPort("tcp", portStr); err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
+
+// sortIPs returns the IPs of addrs, those of the preferred family first, in the DNS order.
+func sortIPs(addrs []net.IPAddr, prefer string) []net.IP {
+	var first, second []net.IP
+	for _, addr := range addrs {
+		isIPv4 := addr.IP.To4() != nil
+		if prefer == PreferNone || (prefer == PreferIPv4) == isIPv4 {
+			first = append(first, addr.IP)
+		} else {
+			second = append(second, addr.IP)
+		}
+	}
+	return append(first, second...)
+}
+
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
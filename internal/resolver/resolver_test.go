@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+// fakeDNS answers the lookups with the addresses set, and counts them.
+type fakeDNS struct {
+	lock    sync.Mutex
+	addrs   map[string][]string
+	err     error
+	lookups int
+}
+
+func (d *fakeDNS) set(host string, ips ...string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.addrs[host] = ips
+}
+
+func (d *fakeDNS) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.lookups++
+	if d.err != nil {
+		return nil, d.err
+	}
+	var addrs []net.IPAddr
+	for _, ip := range d.addrs[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestResolver_LookupIP(t *testing.T) {
+	dns := &fakeDNS{addrs: make(map[string][]string)}
+	dns.set("mysql.example.com", "10.0.0.1")
+	r := New(dns.lookup, time.Minute)
+	now := time.Unix(1525910400, 0)
+	r.now = func() time.Time { return now }
+	var logs bytes.Buffer
+	if err := r.Configure(PreferNone, log.New(&logs, log.DebugLevel)); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(want string) {
+		t.Helper()
+		ips, err := r.LookupIP("mysql.example.com")
+		if err != nil || len(ips) == 0 || ips[0].String() != want {
+			t.Fatalf("LookupIP() = %v, %v, want %v", ips, err, want)
+		}
+	}
+	lookup("10.0.0.1")
+
+	// the CNAME is swapped: the cached address until the TTL expires
+	dns.set("mysql.example.com", "10.0.0.2")
+	lookup("10.0.0.1")
+	if dns.lookups != 1 {
+		t.Errorf("lookups = %v, want 1 within the TTL", dns.lookups)
+	}
+	now = now.Add(time.Minute)
+	lookup("10.0.0.2")
+	if !strings.Contains(logs.String(), "changed from [10.0.0.1] to [10.0.0.2]") {
+		t.Errorf("the change is not logged: %q", logs.String())
+	}
+
+	// the DNS is unreachable: the last address
+	now = now.Add(time.Minute)
+	dns.err = errors.New("i/o timeout")
+	lookup("10.0.0.2")
+	if _, err := r.LookupIP("unknown.example.com"); err == nil {
+		t.Errorf("LookupIP() of an unresolvable host succeeded")
+	}
+
+	if ips, err := r.LookupIP("192.168.1.1"); err != nil || ips[0].String() != "192.168.1.1" {
+		t.Errorf("LookupIP() of an IP = %v, %v", ips, err)
+	}
+}
+
+func TestResolver_prefer(t *testing.T) {
+	dns := &fakeDNS{addrs: make(map[string][]string)}
+	dns.set("server.example.com", "fd00::1", "10.0.0.1", "fd00::2")
+	tests := []struct {
+		prefer string
+		want   string
+	}{
+		{PreferNone, "[fd00::1 10.0.0.1 fd00::2]"},
+		{PreferIPv4, "[10.0.0.1 fd00::1 fd00::2]"},
+		{PreferIPv6, "[fd00::1 fd00::2 10.0.0.1]"},
+	}
+	for _, tt := range tests {
+		r := New(dns.lookup, time.Minute)
+		if err := r.Configure(tt.prefer, nil); err != nil {
+			t.Fatal(err)
+		}
+		ips, err := r.LookupIP("server.example.com")
+		if got := fmtIPs(ips); err != nil || got != tt.want {
+			t.Errorf("LookupIP() preferring %q = %v, %v, want %v", tt.prefer, got, err, tt.want)
+		}
+	}
+	if err := New(dns.lookup, time.Minute).Configure("ipv5", nil); err == nil {
+		t.Errorf("Configure() of an unknown family succeeded")
+	}
+}
+
+func fmtIPs(ips []net.IP) string {
+	var s []string
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+func TestResolver_Dial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	dns := &fakeDNS{addrs: make(map[string][]string)}
+	// the first address refuses the connection
+	dns.set("mysql.example.com", "127.0.0.2", "127.0.0.1")
+	r := New(dns.lookup, time.Minute)
+	conn, err := r.Dial(net.JoinHostPort("mysql.example.com", port), time.Second)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close()
+
+	addr, err := r.ResolveTCPAddr(net.JoinHostPort("mysql.example.com", port))
+	if err != nil || addr.IP.String() != "127.0.0.2" {
+		t.Errorf("ResolveTCPAddr() = %v, %v", addr, err)
+	}
+}
@@ -172,14 +172,14 @@ func (n *udupFSM) applyUpsertNode(buf []byte, index uint64) interface{} {
 		panic(fmt.Errorf("failed to decode request: %v", err))
 	}
 
-	if err := n.state.UpsertNode(index, req.Node); err != nil {
+	if err := n.state.UpsertNode(index, req.Node, req.Events...); err != nil {
 		n.logger.Errorf("server.fsm: UpsertNode failed: %v", err)
 		return err
 	}
 
 	// Unblock evals for the nodes computed node class if it is in a ready
 	// store.
-	if req.Node.Status == models.NodeStatusReady {
+	if req.Node.Status == models.NodeStatusReady && req.Node.Eligible() {
 		n.blockedEvals.Unblock(req.Node.ComputedClass, index)
 	}
 
@@ -207,7 +207,7 @@ func (n *udupFSM) applyStatusUpdate(buf []byte, index uint64) interface{} {
 		panic(fmt.Errorf("failed to decode request: %v", err))
 	}
 
-	if err := n.state.UpdateNodeStatus(index, req.NodeID, req.Status); err != nil {
+	if err := n.state.UpdateNodeStatus(index, req.NodeID, req.Status, req.Events...); err != nil {
 		n.logger.Errorf("server.fsm: UpdateNodeStatus failed: %v", err)
 		return err
 	}
@@ -523,6 +523,8 @@ func (n *udupFSM) applyJobClientUpdate(buf []byte, index uint64) interface{} {
 				existing.JobModifyIndex = index
 				for _, t := range existing.Tasks {
 					t.Config["Gtid"] = ju.Gtid
+					// the full copy is complete
+					delete(t.Config, "DumpProgress")
 					//t.Config["NatsAddr"] = ju.NatsAddr
 				}
 				// Update all the client allocations
@@ -533,6 +535,11 @@ func (n *udupFSM) applyJobClientUpdate(buf []byte, index uint64) interface{} {
 			} else {
 				existing.ModifyIndex = index
 				existing.JobModifyIndex = index
+				if ju.DumpProgress != nil {
+					for _, t := range existing.Tasks {
+						t.Config["DumpProgress"] = ju.DumpProgress
+					}
+				}
 				/*for _, t := range existing.Tasks {
 					t.Config["NatsAddr"] = ju.NatsAddr
 				}*/
@@ -102,6 +102,8 @@ func (s *Server) invalidateHeartbeat(id string) {
 	req := models.NodeUpdateStatusRequest{
 		NodeID: id,
 		Status: models.NodeStatusDown,
+		Events: []*models.NodeEvent{models.NewNodeEvent(models.NodeEventHeartbeatMissed,
+			"Missed the heartbeats for the TTL", nil, time.Now())},
 		WriteRequest: models.WriteRequest{
 			Region: s.config.Region,
 		},
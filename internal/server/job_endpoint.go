@@ -14,6 +14,7 @@ import (
 	"github.com/hashicorp/go-memdb"
 
 	"github.com/actiontech/dtle/internal/client/driver"
+	uconf "github.com/actiontech/dtle/internal/config"
 	"github.com/actiontech/dtle/internal/models"
 	"github.com/actiontech/dtle/internal/server/scheduler"
 	"github.com/actiontech/dtle/internal/server/store"
@@ -25,7 +26,6 @@ const (
 	// RegisterEnforceIndexErrPrefix is the prefix to use in errors caused by
 	// enforcing the job modify index during registers.
 	RegisterEnforceIndexErrPrefix = "Enforcing job modify index"
-	MaskedPassword = "*"
 )
 
 // Job endpoint is used for job interactions
@@ -49,6 +49,23 @@ func (j *Job) Register(args *models.JobRegisterRequest, reply *models.JobRespons
 	// Initialize the job fields (sets defaults and any necessary init work).
 	args.Job.Canonicalize()
 
+	for _, task := range args.Job.Tasks {
+		if err := driver.ValidateTaskConfig(task); err != nil {
+			reply.Success = false
+			return fmt.Errorf("task %q -> config: %v", task.Type, err)
+		}
+	}
+	if args.Job.Periodic != nil {
+		if err := args.Job.Periodic.Validate(); err != nil {
+			reply.Success = false
+			return err
+		}
+	}
+	if err := j.validateMode(args.Job); err != nil {
+		reply.Success = false
+		return err
+	}
+
 	// Validate the job.
 	/*if err := validateJob(args.Job); err != nil {
 		reply.Success = false
@@ -270,8 +287,13 @@ func (j *Job) Validate(args *models.JobValidateRequest,
 			return fmt.Errorf("task %q -> config: %v", task.Type, err)
 		}
 		rep.Type = task.Type
+		if task.Type == models.TaskTypeSrc && rep.ServerID.ReplicaServerId == 0 && task.NodeID != "" {
+			// derived as on the node of the task
+			rep.ServerID.ReplicaServerId = uconf.DeriveServerId(task.NodeID, args.Job.ID)
+		}
 		reply.ValidationTasks = append(reply.ValidationTasks, rep)
 	}
+	reply.WarnZeroDates()
 	reply.DriverConfigValidated = true
 	return nil
 }
@@ -334,6 +356,34 @@ func (j *Job) Evaluate(args *models.JobEvaluateRequest, reply *models.JobRespons
 	return nil
 }
 
+// validateMode checks the Mode of a job registered: a periodic job copies the tables at every
+// launch, and the mode of an existing job cannot be changed, as the targets were filled for it.
+func (j *Job) validateMode(job *models.Job) error {
+	mode := job.Mode()
+	if job.Periodic != nil && mode == models.JobModeIncrementalOnly {
+		return fmt.Errorf("a periodic job copies the tables, and cannot be Mode=%v", mode)
+	}
+	for _, task := range job.Tasks {
+		if table, _ := task.Config["HeartbeatTable"].(string); table != "" && mode == models.JobModeCopyOnly {
+			return fmt.Errorf("task %q -> config: conflicting job argument: Mode=%v has no replication lag, for HeartbeatTable",
+				task.Type, mode)
+		}
+	}
+	snap, err := j.srv.fsm.State().Snapshot()
+	if err != nil {
+		return err
+	}
+	existing, err := snap.JobByID(memdb.NewWatchSet(), job.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Mode() != mode {
+		return fmt.Errorf("job %q is Mode=%v, and cannot be updated to Mode=%v. Register a new job instead",
+			job.ID, existing.Mode(), mode)
+	}
+	return nil
+}
+
 // Deregister is used to remove a job the cluster.
 func (j *Job) Deregister(args *models.JobDeregisterRequest, reply *models.JobResponse) error {
 	if done, err := j.srv.forward("Job.Deregister", args, args, reply); done {
@@ -457,6 +507,9 @@ func (j *Job) List(args *models.JobListRequest,
 					break
 				}
 				job := raw.(*models.Job)
+				if !job.MatchesMeta(args.Meta) {
+					continue
+				}
 				jobCopy0, err := copystructure.Copy(job)
 				if err != nil {
 					return err
@@ -465,14 +518,8 @@ func (j *Job) List(args *models.JobListRequest,
 				if !ok {
 					return fmt.Errorf("failed to deep copy job")
 				}
-				//fmt.Printf("**** mask password\n")
 				for _, t := range jobCopy.Tasks {
-					if connCfg, ok := t.Config["ConnectionConfig"]; ok {
-						if connCfgMap, ok := connCfg.(map[string]interface{}); ok {
-							//getStar := func() string { return "*" }
-							connCfgMap["Password"] = MaskedPassword
-						}
-					}
+					uconf.RedactTaskConfig(t.Config)
 				}
 				jobs = append(jobs, job.Stub(jobCopy))
 			}
@@ -534,6 +581,46 @@ func (j *Job) Allocations(args *models.JobSpecificRequest,
 	return j.srv.blockingRPC(&opts)
 }
 
+// Children is used to list the recent children of a periodic job
+func (j *Job) Children(args *models.JobSpecificRequest,
+	reply *models.JobChildrenResponse) error {
+	if done, err := j.srv.forward("Job.Children", args, args, reply); done {
+		return err
+	}
+	defer metrics.MeasureSince([]string{"server", "job", "children"}, time.Now())
+
+	// Setup the blocking query
+	opts := blockingOptions{
+		queryOpts: &args.QueryOptions,
+		queryMeta: &reply.QueryMeta,
+		run: func(ws memdb.WatchSet, state *store.StateStore) error {
+			children, err := periodicChildren(ws, state, args.JobID)
+			if err != nil {
+				return err
+			}
+			reply.Children = children
+
+			// Use the last index that affected the jobs or allocs table
+			jindex, err := state.Index("jobs")
+			if err != nil {
+				return err
+			}
+			aindex, err := state.Index("allocs")
+			if err != nil {
+				return err
+			}
+			reply.Index = jindex
+			if aindex > reply.Index {
+				reply.Index = aindex
+			}
+
+			// Set the query response
+			j.srv.setQueryMeta(&reply.QueryMeta)
+			return nil
+		}}
+	return j.srv.blockingRPC(&opts)
+}
+
 // Evaluations is used to list the evaluations for a job
 func (j *Job) Evaluations(args *models.JobSpecificRequest,
 	reply *models.JobEvaluationsResponse) error {
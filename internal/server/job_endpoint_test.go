@@ -290,23 +290,3 @@ func TestJob_Plan(t *testing.T) {
 		})
 	}
 }
-
-func Test_validateJob(t *testing.T) {
-	type args struct {
-		job *models.Job
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := validateJob(tt.args.job); (err != nil) != tt.wantErr {
-				t.Errorf("validateJob() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
@@ -23,6 +23,10 @@ const (
 	// batchUpdateInterval is how long we wait to batch updates
 	batchUpdateInterval = 50 * time.Millisecond
 
+	// maxBatchedAllocUpdates bounds the allocation updates pending in a
+	// batch. The clients are told to try later beyond it.
+	maxBatchedAllocUpdates = 8192
+
 	// maxParallelRequestsPerDerive  is the maximum number of parallel Vault
 	// create token requests that may be outstanding per derive request
 	maxParallelRequestsPerDerive = 16
@@ -92,7 +96,8 @@ func (n *Node) Register(args *models.NodeRegisterRequest, reply *models.NodeUpda
 		return err
 	}
 
-	// Commit this update via Raft
+	// Commit this update via Raft, with the events of the registration
+	args.Events = append(args.Events, registerNodeEvents(originalNode, args.Node, time.Now())...)
 	_, index, err := n.srv.raftApply(models.NodeRegisterRequestType, args)
 	if err != nil {
 		n.srv.logger.Errorf("server.agent: Register failed: %v", err)
@@ -102,11 +107,15 @@ func (n *Node) Register(args *models.NodeRegisterRequest, reply *models.NodeUpda
 
 	// Check if we should trigger evaluations
 	originalStatus := models.NodeStatusInit
+	originalEligible := true
 	if originalNode != nil {
 		originalStatus = originalNode.Status
+		originalEligible = originalNode.Eligible()
 	}
 	transitionToReady := transitionedToReady(args.Node.Status, originalStatus)
-	if transitionToReady {
+	// The allocations blocked for no eligible node can be placed on it now.
+	transitionToEligible := args.Node.Ready() && !originalEligible && args.Node.Eligible()
+	if transitionToReady || transitionToEligible {
 		evalIDs, evalIndex, err := n.createNodeEvals(args.Node.ID, index)
 		if err != nil {
 			n.srv.logger.Errorf("server.agent: eval creation failed: %v", err)
@@ -143,6 +152,51 @@ func (n *Node) Register(args *models.NodeRegisterRequest, reply *models.NodeUpda
 	return nil
 }
 
+// registerNodeEvents returns the events of the registration of node, which was
+// original before (nil if not registered).
+func registerNodeEvents(original, node *models.Node, now time.Time) []*models.NodeEvent {
+	if original == nil {
+		return []*models.NodeEvent{models.NewNodeEvent(models.NodeEventRegistered, "Node registered", nil, now)}
+	}
+	var events []*models.NodeEvent
+	if original.Status == models.NodeStatusDown {
+		events = append(events, models.NewNodeEvent(models.NodeEventRegistered, "Node registered after being down", nil, now))
+	}
+	if original.Eligible() != node.Eligible() {
+		message := "Node marked eligible for scheduling"
+		if !node.Eligible() {
+			message = "Node marked ineligible for scheduling"
+		}
+		events = append(events, models.NewNodeEvent(models.NodeEventEligibility, message, nil, now))
+	}
+	if changed := changedNodeAttrs(original, node); len(changed) > 0 {
+		events = append(events, models.NewNodeEvent(models.NodeEventAttributes,
+			fmt.Sprintf("%v attributes changed", len(changed)), changed, now))
+	}
+	return events
+}
+
+// changedNodeAttrs returns the attributes and the class changed from old to
+// new, as "<old> -> <new>" by key. A missing attribute is "".
+func changedNodeAttrs(old, new *models.Node) map[string]string {
+	changed := make(map[string]string)
+	diff := func(key, o, n string) {
+		if o != n {
+			changed[key] = fmt.Sprintf("%q -> %q", o, n)
+		}
+	}
+	for k, v := range new.Attributes {
+		diff(k, old.Attributes[k], v)
+	}
+	for k, v := range old.Attributes {
+		if _, ok := new.Attributes[k]; !ok {
+			diff(k, v, "")
+		}
+	}
+	diff("node.class", old.NodeClass, new.NodeClass)
+	return changed
+}
+
 // updateNodeUpdateResponse assumes the n.srv.peerLock is held for reading.
 func (n *Node) constructNodeServerInfoResponse(snap *store.StateSnapshot, reply *models.NodeUpdateResponse) error {
 	reply.LeaderRPCAddr = string(n.srv.raft.Leader())
@@ -248,9 +302,14 @@ func (n *Node) UpdateStatus(args *models.NodeUpdateStatusRequest, reply *models.
 	// Update the timestamp of when the node status was updated
 	node.StatusUpdatedAt = time.Now().Unix()
 
-	// Commit this update via Raft
+	// Commit this update via Raft, with the events of the node and of a
+	// status change
 	var index uint64
 	if node.Status != args.Status {
+		args.Events = append(args.Events, models.NewNodeEvent(models.NodeEventStatus,
+			fmt.Sprintf("Node status changed from %v to %v", node.Status, args.Status), nil, time.Now()))
+	}
+	if len(args.Events) > 0 {
 		_, index, err = n.srv.raftApply(models.NodeUpdateStatusRequestType, args)
 		if err != nil {
 			n.srv.logger.Errorf("server.agent: status update failed: %v", err)
@@ -396,6 +455,44 @@ func (n *Node) GetNode(args *models.NodeSpecificRequest,
 	return n.srv.blockingRPC(&opts)
 }
 
+// Events is used to request the events of a specific node, oldest first
+func (n *Node) Events(args *models.NodeSpecificRequest,
+	reply *models.NodeEventsResponse) error {
+	if done, err := n.srv.forward("Node.Events", args, args, reply); done {
+		return err
+	}
+	defer metrics.MeasureSince([]string{"server", "client", "events"}, time.Now())
+
+	// Setup the blocking query
+	opts := blockingOptions{
+		queryOpts: &args.QueryOptions,
+		queryMeta: &reply.QueryMeta,
+		run: func(ws memdb.WatchSet, state *store.StateStore) error {
+			// Verify the arguments
+			if args.NodeID == "" {
+				return fmt.Errorf("missing node ID")
+			}
+
+			// Look for the node
+			out, err := state.NodeByID(ws, args.NodeID)
+			if err != nil {
+				return err
+			}
+			if out == nil {
+				return fmt.Errorf("node not found")
+			}
+
+			// Setup the output
+			reply.Events = append([]*models.NodeEvent(nil), out.Events...)
+			reply.Index = out.ModifyIndex
+
+			// Set the query response
+			n.srv.setQueryMeta(&reply.QueryMeta)
+			return nil
+		}}
+	return n.srv.blockingRPC(&opts)
+}
+
 // GetAllocs is used to request allocations for a specific node
 func (n *Node) GetAllocs(args *models.NodeSpecificRequest,
 	reply *models.NodeAllocsResponse) error {
@@ -545,6 +642,10 @@ func (n *Node) UpdateAlloc(args *models.AllocUpdateRequest, reply *models.Generi
 
 	// Add this to the batch
 	n.updatesLock.Lock()
+	if len(n.updates) >= maxBatchedAllocUpdates {
+		n.updatesLock.Unlock()
+		return models.ErrServerBusy
+	}
 	n.updates = append(n.updates, args.Alloc...)
 
 	// Start a new batch if none
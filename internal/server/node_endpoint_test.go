@@ -7,6 +7,7 @@
 package server
 
 import (
+	"os"
 	"reflect"
 	"sync"
 	"testing"
@@ -609,3 +610,93 @@ func Test_batchFuture_Respond(t *testing.T) {
 		})
 	}
 }
+
+func Test_registerNodeEvents(t *testing.T) {
+	now := time.Now()
+	node := &models.Node{
+		ID:         "node1",
+		Status:     models.NodeStatusReady,
+		Attributes: map[string]string{"os.name": "centos", "driver.MySQL": "1"},
+	}
+	types := func(events []*models.NodeEvent) (types []string) {
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return types
+	}
+
+	if got := types(registerNodeEvents(nil, node, now)); !reflect.DeepEqual(got, []string{models.NodeEventRegistered}) {
+		t.Errorf("first registration = %v", got)
+	}
+	if got := registerNodeEvents(node, node.Copy(), now); len(got) != 0 {
+		t.Errorf("unchanged registration = %v, want none", types(got))
+	}
+
+	changed := node.Copy()
+	changed.Attributes = map[string]string{"os.name": "ubuntu", "os.version": "18.04"}
+	changed.SchedulingEligibility = models.NodeSchedulingIneligible
+	events := registerNodeEvents(node, changed, now)
+	if got := types(events); !reflect.DeepEqual(got, []string{models.NodeEventEligibility, models.NodeEventAttributes}) {
+		t.Fatalf("changed registration = %v", got)
+	}
+	wantDetails := map[string]string{
+		"os.name":      `"centos" -> "ubuntu"`,
+		"os.version":   `"" -> "18.04"`,
+		"driver.MySQL": `"1" -> ""`,
+	}
+	if !reflect.DeepEqual(events[1].Details, wantDetails) {
+		t.Errorf("attribute details = %v, want %v", events[1].Details, wantDetails)
+	}
+
+	down := node.Copy()
+	down.Status = models.NodeStatusDown
+	if got := types(registerNodeEvents(down, node, now)); !reflect.DeepEqual(got, []string{models.NodeEventRegistered}) {
+		t.Errorf("registration after down = %v", got)
+	}
+}
+
+func TestStateStore_nodeEvents(t *testing.T) {
+	state, err := store.NewStateStore(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "12345678-abcd-efab-cdef-123456789abc"
+	event := func(typ string) *models.NodeEvent {
+		return models.NewNodeEvent(typ, typ, nil, time.Now())
+	}
+
+	node := &models.Node{ID: id, Status: models.NodeStatusReady}
+	if err := state.UpsertNode(1, node, event(models.NodeEventRegistered)); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.UpdateNodeStatus(2, id, models.NodeStatusDown, event(models.NodeEventHeartbeatMissed)); err != nil {
+		t.Fatal(err)
+	}
+	// a registration keeps the events
+	if err := state.UpsertNode(3, &models.Node{ID: id, Status: models.NodeStatusReady}, event(models.NodeEventOpenFiles)); err != nil {
+		t.Fatal(err)
+	}
+	out, err := state.NodeByID(nil, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range out.Events {
+		got = append(got, e.Type)
+	}
+	want := []string{models.NodeEventRegistered, models.NodeEventHeartbeatMissed, models.NodeEventOpenFiles}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// capped, keeping the last
+	for i := 0; i < models.MaxNodeEvents; i++ {
+		if err := state.UpdateNodeStatus(uint64(4+i), id, models.NodeStatusReady, event(models.NodeEventStatus)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, _ = state.NodeByID(nil, id)
+	if len(out.Events) != models.MaxNodeEvents || out.Events[0].Type != models.NodeEventStatus {
+		t.Errorf("events = %v, want the last %v", len(out.Events), models.MaxNodeEvents)
+	}
+}
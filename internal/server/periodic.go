@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package server
+
+import (
+	"sort"
+	"time"
+
+	memdb "github.com/hashicorp/go-memdb"
+
+	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/server/store"
+)
+
+const (
+	// periodicDispatchInterval is how often the leader looks for the periodic
+	// jobs due to launch a child.
+	periodicDispatchInterval = time.Second
+
+	// periodicChildrenKept is how many children of a periodic job are kept.
+	// The older terminal children are deregistered.
+	periodicChildrenKept = 10
+)
+
+// periodicDispatch launches the children of the periodic jobs, as long as we
+// are the leader.
+func (s *Server) periodicDispatch(stopCh chan struct{}) {
+	// The time after which each periodic job launches next: when it was first
+	// seen by this leader, or its last launch.
+	last := make(map[string]time.Time)
+	ticker := time.NewTicker(periodicDispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			if err := s.dispatchPeriodicJobs(last, now); err != nil {
+				s.logger.Errorf("manager: periodic dispatch failed: %v", err)
+			}
+		}
+	}
+}
+
+// dispatchPeriodicJobs launches the children of the periodic jobs due at now.
+func (s *Server) dispatchPeriodicJobs(last map[string]time.Time, now time.Time) error {
+	state := s.fsm.State()
+	ws := memdb.NewWatchSet()
+	iter, err := state.Jobs(ws)
+	if err != nil {
+		return err
+	}
+	var jobs []*models.Job
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		if job := raw.(*models.Job); job.IsPeriodic() {
+			jobs = append(jobs, job)
+		}
+	}
+
+	seen := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		seen[job.ID] = true
+		if !job.Periodic.Enabled || job.Status == models.JobStatusPause {
+			// The launches missed while disabled are not made up for.
+			last[job.ID] = now
+			continue
+		}
+
+		children, err := periodicChildren(ws, state, job.ID)
+		if err != nil {
+			return err
+		}
+		base, ok := last[job.ID]
+		if n := len(children); n > 0 {
+			// e.g. a launch by the previous leader
+			if launched := time.Unix(0, children[n-1].LaunchTime); !ok || launched.After(base) {
+				base = launched
+			}
+		} else if !ok {
+			base = now
+		}
+		last[job.ID] = base
+
+		launch, err := job.Periodic.LastDue(base, now)
+		if err != nil {
+			s.logger.Errorf("manager: periodic job %v: %v", job.ID, err)
+			continue
+		}
+		if launch.IsZero() {
+			continue
+		}
+		if job.Periodic.ProhibitOverlap {
+			if running := runningPeriodicChild(children); running != "" {
+				s.logger.Warnf("manager: periodic job %v: launch at %v skipped, child %v is still running",
+					job.ID, launch, running)
+				last[job.ID] = launch
+				continue
+			}
+		}
+
+		if err := s.launchPeriodicChild(job, launch); err != nil {
+			// retried at the next tick
+			s.logger.Errorf("manager: periodic job %v: launch at %v failed: %v", job.ID, launch, err)
+			continue
+		}
+		last[job.ID] = launch
+		s.gcPeriodicChildren(job, children)
+	}
+
+	for id := range last {
+		if !seen[id] {
+			delete(last, id)
+		}
+	}
+	return nil
+}
+
+// launchPeriodicChild registers the child of the job launched at launch.
+func (s *Server) launchPeriodicChild(job *models.Job, launch time.Time) error {
+	child := job.PeriodicChild(launch)
+	s.logger.Printf("manager: periodic job %v: launching %v", job.ID, child.ID)
+	req := models.JobRegisterRequest{
+		Job:          child,
+		WriteRequest: models.WriteRequest{Region: job.Region},
+	}
+	var resp models.JobResponse
+	return s.RPC("Job.Register", &req, &resp)
+}
+
+// gcPeriodicChildren deregisters the oldest terminal children of the job, but
+// the last periodicChildrenKept ones. children is as before the latest launch.
+func (s *Server) gcPeriodicChildren(job *models.Job, children []*models.PeriodicChildStub) {
+	excess := len(children) + 1 - periodicChildrenKept
+	for _, child := range children {
+		if excess <= 0 {
+			return
+		}
+		if child.Outcome == models.PeriodicOutcomeRunning {
+			continue
+		}
+		req := models.JobDeregisterRequest{
+			JobID:        child.JobID,
+			WriteRequest: models.WriteRequest{Region: job.Region},
+		}
+		var resp models.JobResponse
+		if err := s.RPC("Job.Deregister", &req, &resp); err != nil {
+			s.logger.Errorf("manager: periodic job %v: deregister child %v failed: %v", job.ID, child.JobID, err)
+			return
+		}
+		excess--
+	}
+}
+
+// periodicChildren returns the children of a periodic job with their outcomes,
+// oldest first.
+func periodicChildren(ws memdb.WatchSet, state *store.StateStore, parentID string) ([]*models.PeriodicChildStub, error) {
+	iter, err := state.JobsByIDPrefix(ws, parentID+models.PeriodicChildInfix)
+	if err != nil {
+		return nil, err
+	}
+	var children []*models.PeriodicChildStub
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		job := raw.(*models.Job)
+		if job.ParentID != parentID {
+			continue
+		}
+		allocs, err := state.AllocsByJob(ws, job.ID, true)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, models.PeriodicChildOutcome(job, allocs))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].LaunchTime < children[j].LaunchTime
+	})
+	return children, nil
+}
+
+// runningPeriodicChild returns the ID of a child still running, or "".
+func runningPeriodicChild(children []*models.PeriodicChildStub) string {
+	for _, child := range children {
+		if child.Outcome == models.PeriodicOutcomeRunning {
+			return child.JobID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestPeriodicConfig_LastDue(t *testing.T) {
+	p := &models.PeriodicConfig{Enabled: true, Spec: "0 * * * *"}
+	base := time.Date(2018, 5, 10, 1, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"not due", base.Add(20 * time.Minute), time.Time{}},
+		{"due", base.Add(30 * time.Minute), time.Date(2018, 5, 10, 2, 0, 0, 0, time.UTC)},
+		{"missed launches coalesced", base.Add(3 * time.Hour), time.Date(2018, 5, 10, 4, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := p.LastDue(base, tt.now)
+		if err != nil {
+			t.Fatalf("%v: LastDue() error = %v", tt.name, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%v: LastDue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJob_PeriodicChild(t *testing.T) {
+	parent := &models.Job{
+		ID:       "nightly",
+		Name:     "nightly",
+		Periodic: &models.PeriodicConfig{Enabled: true, Spec: "@daily", ProhibitOverlap: true},
+		Tasks: []*models.Task{{
+			Type:   models.TaskTypeSrc,
+			Driver: models.TaskDriverMySQL,
+			Config: map[string]interface{}{"Gtid": "uuid:1-10", "ReplChanBufferSize": 600},
+		}},
+	}
+	launch := time.Date(2018, 5, 10, 0, 0, 0, 0, time.UTC)
+	child := parent.PeriodicChild(launch)
+	if child.ID != "nightly-periodic-1525910400" || child.ParentID != "nightly" || child.IsPeriodic() {
+		t.Fatalf("child = %v parent %v periodic %v", child.ID, child.ParentID, child.Periodic)
+	}
+	config := child.Tasks[0].Config
+	if _, ok := config["Gtid"]; ok || config["SkipIncrementalCopy"] != true || config["ReplChanBufferSize"] != 600 {
+		t.Errorf("child config = %v, want a one-shot full copy", config)
+	}
+	if _, ok := parent.Tasks[0].Config["SkipIncrementalCopy"]; ok {
+		t.Errorf("parent config modified: %v", parent.Tasks[0].Config)
+	}
+}
+
+func TestPeriodicChildOutcome(t *testing.T) {
+	launch := time.Date(2018, 5, 10, 0, 0, 0, 0, time.UTC)
+	child := &models.Job{ID: "nightly-periodic-1525910400", LaunchTime: launch.UnixNano()}
+	finished := func(clientStatus string, failed bool, after time.Duration) *models.Allocation {
+		exitCode := 0
+		if failed {
+			exitCode = 1
+		}
+		return &models.Allocation{
+			ClientStatus: clientStatus,
+			TaskStates: map[string]*models.TaskState{models.TaskTypeSrc: {
+				State:      models.TaskStateDead,
+				Failed:     failed,
+				FinishedAt: launch.Add(after),
+				Events:     []*models.TaskEvent{models.NewTaskEvent(models.TaskTerminated).SetExitCode(exitCode)},
+			}},
+		}
+	}
+	tests := []struct {
+		name         string
+		allocs       []*models.Allocation
+		wantOutcome  string
+		wantDuration time.Duration
+	}{
+		{"not placed", nil, models.PeriodicOutcomeRunning, 0},
+		{"running", []*models.Allocation{
+			finished(models.AllocClientStatusComplete, false, time.Minute),
+			{ClientStatus: models.AllocClientStatusRunning},
+		}, models.PeriodicOutcomeRunning, 0},
+		{"complete", []*models.Allocation{
+			finished(models.AllocClientStatusComplete, false, time.Minute),
+			finished(models.AllocClientStatusComplete, false, 2*time.Minute),
+		}, models.PeriodicOutcomeComplete, 2 * time.Minute},
+		{"failed", []*models.Allocation{
+			finished(models.AllocClientStatusComplete, false, time.Minute),
+			finished(models.AllocClientStatusFailed, true, 3*time.Minute),
+		}, models.PeriodicOutcomeFailed, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		got := models.PeriodicChildOutcome(child, tt.allocs)
+		if got.Outcome != tt.wantOutcome || got.Duration != tt.wantDuration {
+			t.Errorf("%v: outcome = %v %v, want %v %v", tt.name, got.Outcome, got.Duration, tt.wantOutcome, tt.wantDuration)
+		}
+		running := runningPeriodicChild([]*models.PeriodicChildStub{got})
+		if (running != "") != (tt.wantOutcome == models.PeriodicOutcomeRunning) {
+			t.Errorf("%v: runningPeriodicChild() = %q", tt.name, running)
+		}
+	}
+}
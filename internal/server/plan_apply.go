@@ -305,7 +305,7 @@ func evaluateNodePlan(snap *store.StateSnapshot, plan *models.Plan, nodeID strin
 	// If the node does not exist or is not ready for schduling it is not fit
 	// XXX: There is a potential race between when we do this check and when
 	// the Raft commit happens.
-	if node == nil || node.Status != models.NodeStatusReady {
+	if node == nil || node.Status != models.NodeStatusReady || !node.Eligible() {
 		return false, nil
 	}
 
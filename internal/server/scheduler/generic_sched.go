@@ -9,6 +9,7 @@ package scheduler
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 
 	//"math/rand"
 
@@ -72,6 +73,10 @@ type GenericScheduler struct {
 	blocked        *models.Evaluation
 	failedTGAllocs map[string]*models.AllocMetric
 	queuedAllocs   map[string]int
+
+	// taskNodes are the nodes of the tasks of the job, by task type: those
+	// of the allocations kept, then of those placed. See colocatedNodes.
+	taskNodes map[string]string
 }
 
 // NewGenericScheduler is a factory function to instantiate a new synchronous scheduler
@@ -251,6 +256,11 @@ func (s *GenericScheduler) process() (bool, error) {
 // re-placed.
 func (s *GenericScheduler) filterCompleteAllocs(allocs []*models.Allocation) ([]*models.Allocation, map[string]*models.Allocation) {
 	filter := func(a *models.Allocation) bool {
+		// An allocation whose task has completed, e.g. a one-shot copy with
+		// SkipIncrementalCopy, should not be replaced.
+		if a.DesiredStatus == models.AllocDesiredStatusRun && a.RanSuccessfully() {
+			return false
+		}
 		// Filter terminal, non batch allocations
 		return a.TerminalStatus()
 	}
@@ -285,9 +295,10 @@ func (s *GenericScheduler) filterCompleteAllocs(allocs []*models.Allocation) ([]
 // computeJobAllocs is used to reconcile differences between the job,
 // existing allocations and node status to update the allocations.
 func (s *GenericScheduler) computeJobAllocs() error {
-	// Materialize all the tasks, job could be missing if deregistered
+	// Materialize all the tasks, job could be missing if deregistered. A
+	// periodic job runs no task itself, only its children do.
 	var tasks map[string]*models.Task
-	if s.job != nil {
+	if s.job != nil && !s.job.IsPeriodic() {
 		tasks = materializeTasks(s.job)
 	}
 
@@ -334,6 +345,13 @@ func (s *GenericScheduler) computeJobAllocs() error {
 	destructiveUpdates, inplaceUpdates := inplaceUpdate(s.ctx, s.eval, s.job, diff.update)
 	diff.update = destructiveUpdates
 
+	s.taskNodes = make(map[string]string)
+	for _, tuples := range [][]allocTuple{diff.update, inplaceUpdates, diff.migrate, diff.resume, diff.pause, diff.ignore} {
+		for _, tuple := range tuples {
+			s.taskNodes[tuple.Alloc.Task] = tuple.Alloc.NodeID
+		}
+	}
+
 	if s.eval.AnnotatePlan {
 		s.plan.Annotations = &models.PlanAnnotations{
 			DesiredTGUpdates: desiredUpdates(diff, inplaceUpdates, destructiveUpdates),
@@ -373,6 +391,10 @@ func (s *GenericScheduler) computePlacements(place []allocTuple) error {
 
 	s.ctx.Metrics().EvaluateNode()
 
+	if s.taskNodes == nil {
+		s.taskNodes = make(map[string]string)
+	}
+	sortPlacements(place)
 	for _, missing := range place {
 		// Check if this task has already failed
 		if metric, ok := s.failedTGAllocs[missing.Task.Type]; ok {
@@ -385,11 +407,19 @@ func (s *GenericScheduler) computePlacements(place []allocTuple) error {
 		if err != nil {
 			return err
 		}
+		if preferredNode != nil && len(s.colocatedNodes(missing.Task, []*models.Node{preferredNode}, place)) == 0 {
+			if missing.Task.Pinned() {
+				return fmt.Errorf("sched: task %v is pinned to node %v, which the nodes of the other tasks %v rule out. "+
+					"colocate of the job: %q", missing.Name, preferredNode.Name, s.taskNodes, s.job.Colocate)
+			}
+			// the node of the previous allocation
+			preferredNode = nil
+		}
 
 		if preferredNode != nil {
 			// do nothing
-		} else {
-			nodeId := nodes[rand.Intn(len(nodes))].ID
+		} else if feasible := s.colocatedNodes(missing.Task, feasibleNodes(nodes, missing.Task, s.ctx.Metrics()), place); len(feasible) > 0 {
+			nodeId := feasible[rand.Intn(len(feasible))].ID
 			s.logger.Debugf("sched: no preferred node. Auto selected node %v for task %v", nodeId, missing.Name)
 
 			ws := memdb.NewWatchSet() // TODO what is ws used for?
@@ -397,6 +427,11 @@ func (s *GenericScheduler) computePlacements(place []allocTuple) error {
 			if err != nil {
 				return err
 			}
+		} else if colocate := s.job.Colocate; colocate == models.JobColocateTrue || colocate == models.JobColocateFalse {
+			s.logger.Warnf("sched: no node has the capabilities required by task %v with colocate %v of the job. "+
+				"the other tasks are on %v", missing.Name, colocate, s.taskNodes)
+		} else {
+			s.logger.Warnf("sched: no node has the capabilities required by task %v", missing.Name)
 		}
 
 		// Store the available nodes by datacenter
@@ -429,6 +464,7 @@ func (s *GenericScheduler) computePlacements(place []allocTuple) error {
 				}
 			}
 			s.plan.AppendAlloc(alloc)
+			s.taskNodes[missing.Task.Type] = preferredNode.ID
 		} else {
 			// Lazy initialize the failed map
 			if s.failedTGAllocs == nil {
@@ -490,3 +526,96 @@ func (s *GenericScheduler) findPreferredNode(allocTuple *allocTuple) (node *mode
 	}
 	return
 }
+
+// sortPlacements orders the placements for the colocation of the tasks: the
+// tasks pinned to a node first, then the Dest task, whose node is that of the
+// nats server of the job.
+func sortPlacements(place []allocTuple) {
+	rank := func(t *models.Task) int {
+		switch {
+		case t.Pinned():
+			return 0
+		case t.Type == models.TaskTypeDest:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(place, func(i, j int) bool {
+		return rank(place[i].Task) < rank(place[j].Task)
+	})
+}
+
+// colocatedNodes filters the nodes for the task by the Colocate of the job:
+// the node of the other tasks with JobColocateTrue, or another with
+// JobColocateFalse. With JobColocateTrue, the nodes are also filtered by the
+// capabilities of the other tasks still to be placed, so that they fit on the
+// node too. Whatever the Colocate, the tasks are placed on the node of the
+// Dest task if its nats address is a loopback one. The others are recorded as
+// filtered in the metrics.
+func (s *GenericScheduler) colocatedNodes(task *models.Task, nodes []*models.Node, place []allocTuple) []*models.Node {
+	colocate := s.job.Colocate
+	var feasible []*models.Node
+NODES:
+	for _, node := range nodes {
+		if addr := s.unreachableNatsAddr(task, node); addr != "" {
+			s.ctx.Metrics().FilterNode(node, "nats address "+addr+" of Dest is local")
+			continue
+		}
+		if colocate != models.JobColocateTrue && colocate != models.JobColocateFalse {
+			feasible = append(feasible, node)
+			continue
+		}
+		for taskType, nodeID := range s.taskNodes {
+			if taskType == task.Type {
+				continue
+			}
+			if (nodeID == node.ID) != (colocate == models.JobColocateTrue) {
+				s.ctx.Metrics().FilterNode(node, "colocate "+colocate)
+				continue NODES
+			}
+		}
+		if colocate == models.JobColocateTrue {
+			for _, other := range place {
+				if _, placed := s.taskNodes[other.Task.Type]; placed || other.Task.Type == task.Type {
+					continue
+				}
+				if attr := node.MissingCapability(taskConstraints(other.Task).constraints); attr != "" {
+					s.ctx.Metrics().FilterNode(node, attr)
+					continue NODES
+				}
+			}
+		}
+		feasible = append(feasible, node)
+	}
+	return feasible
+}
+
+// unreachableNatsAddr returns the nats address of the Dest task if the task
+// on the node can not connect to it: a loopback address of another node.
+func (s *GenericScheduler) unreachableNatsAddr(task *models.Task, node *models.Node) string {
+	if task.Type == models.TaskTypeDest {
+		if !localNatsAddr(node.NatsAddr) {
+			return ""
+		}
+		if s.job.Colocate == models.JobColocateFalse && len(s.job.Tasks) > 1 {
+			return node.NatsAddr
+		}
+		for taskType, nodeID := range s.taskNodes {
+			if taskType != task.Type && nodeID != node.ID {
+				return node.NatsAddr
+			}
+		}
+		return ""
+	}
+
+	destNodeID, ok := s.taskNodes[models.TaskTypeDest]
+	if !ok || destNodeID == node.ID {
+		return ""
+	}
+	destNode, err := s.state.NodeByID(memdb.NewWatchSet(), destNodeID)
+	if err != nil || destNode == nil || !localNatsAddr(destNode.NatsAddr) {
+		return ""
+	}
+	return destNode.NatsAddr
+}
@@ -7,10 +7,14 @@
 package scheduler
 
 import (
+	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/server/store"
 )
 
 func TestSetStatusError_Error(t *testing.T) {
@@ -210,6 +214,22 @@ func TestGenericScheduler_process(t *testing.T) {
 }
 
 func TestGenericScheduler_filterCompleteAllocs(t *testing.T) {
+	completedTask := map[string]*models.TaskState{models.TaskTypeSrc: {
+		State:  models.TaskStateDead,
+		Events: []*models.TaskEvent{models.NewTaskEvent(models.TaskTerminated).SetExitCode(0)},
+	}}
+	running := &models.Allocation{Name: "running", DesiredStatus: models.AllocDesiredStatusRun,
+		ClientStatus: models.AllocClientStatusRunning}
+	failed := &models.Allocation{Name: "failed", DesiredStatus: models.AllocDesiredStatusRun,
+		ClientStatus: models.AllocClientStatusFailed}
+	completed := &models.Allocation{Name: "completed", DesiredStatus: models.AllocDesiredStatusRun,
+		ClientStatus: models.AllocClientStatusComplete, TaskStates: completedTask}
+	stopped := &models.Allocation{Name: "stopped", DesiredStatus: models.AllocDesiredStatusStop,
+		ClientStatus: models.AllocClientStatusComplete, TaskStates: completedTask}
+	completedState := &models.Allocation{Name: "completedState", DesiredStatus: models.AllocDesiredStatusRun,
+		ClientStatus: models.AllocClientStatusComplete,
+		TaskStates:   map[string]*models.TaskState{models.TaskTypeSrc: {State: models.TaskStateComplete}}}
+
 	type fields struct {
 		logger         *log.Logger
 		state          State
@@ -234,7 +254,30 @@ func TestGenericScheduler_filterCompleteAllocs(t *testing.T) {
 		want   []*models.Allocation
 		want1  map[string]*models.Allocation
 	}{
-		// TODO: Add test cases.
+		{
+			name:  "failed",
+			args:  args{allocs: []*models.Allocation{running, failed}},
+			want:  []*models.Allocation{running},
+			want1: map[string]*models.Allocation{failed.Name: failed},
+		},
+		{
+			name:  "completed",
+			args:  args{allocs: []*models.Allocation{running, completed}},
+			want:  []*models.Allocation{running, completed},
+			want1: map[string]*models.Allocation{},
+		},
+		{
+			name:  "completed task state",
+			args:  args{allocs: []*models.Allocation{running, completedState}},
+			want:  []*models.Allocation{running, completedState},
+			want1: map[string]*models.Allocation{},
+		},
+		{
+			name:  "completed and stopped",
+			args:  args{allocs: []*models.Allocation{stopped}},
+			want:  []*models.Allocation{},
+			want1: map[string]*models.Allocation{stopped.Name: stopped},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -252,7 +295,8 @@ func TestGenericScheduler_filterCompleteAllocs(t *testing.T) {
 				failedTGAllocs: tt.fields.failedTGAllocs,
 				queuedAllocs:   tt.fields.queuedAllocs,
 			}
-			got, got1 := s.filterCompleteAllocs(tt.args.allocs)
+			allocs := append([]*models.Allocation(nil), tt.args.allocs...)
+			got, got1 := s.filterCompleteAllocs(allocs)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GenericScheduler.filterCompleteAllocs() got = %v, want %v", got, tt.want)
 			}
@@ -411,3 +455,144 @@ func TestGenericScheduler_findPreferredNode(t *testing.T) {
 		})
 	}
 }
+
+func TestGenericScheduler_computePlacements_colocate(t *testing.T) {
+	state, err := store.NewStateStore(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the IDs of the nodes by name, and back
+	ids, names := make(map[string]string), make(map[string]string)
+	for i, natsAddr := range []string{"10.0.0.1:8193", "10.0.0.2:8193", "10.0.0.3:8193", "127.0.0.1:8193"} {
+		node := &models.Node{
+			ID:         models.GenerateUUID(),
+			Name:       []string{"n1", "n2", "n3", "local"}[i],
+			Datacenter: "dc1",
+			NatsAddr:   natsAddr,
+			Status:     models.NodeStatusReady,
+		}
+		ids[node.Name], names[node.ID] = node.ID, node.Name
+		if err := state.UpsertNode(uint64(i+1), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// place places the tasks of a job of colocate, with the Dest task on
+	// destNode already if set, and returns the names of the nodes of the
+	// tasks.
+	place := func(colocate string, destNode string, srcPin string, destPin string) (map[string]string, error) {
+		job := &models.Job{
+			ID:          "job1",
+			Name:        "job1",
+			Datacenters: []string{"dc1"},
+			Colocate:    colocate,
+			Tasks: []*models.Task{
+				{Type: models.TaskTypeSrc, NodeID: ids[srcPin], Config: map[string]interface{}{}},
+				{Type: models.TaskTypeDest, NodeID: ids[destPin], Config: map[string]interface{}{}},
+			},
+		}
+		eval := &models.Evaluation{ID: models.GenerateUUID(), JobID: job.ID}
+		logger := log.New(os.Stderr, log.ErrorLevel)
+		plan := eval.MakePlan(job)
+		s := &GenericScheduler{
+			logger:    logger,
+			state:     state,
+			eval:      eval,
+			job:       job,
+			plan:      plan,
+			ctx:       NewEvalContext(state, plan, logger),
+			taskNodes: make(map[string]string),
+		}
+		var tuples []allocTuple
+		for name, task := range materializeTasks(job) {
+			if task.Type == models.TaskTypeDest && destNode != "" {
+				s.taskNodes[task.Type] = ids[destNode]
+				continue
+			}
+			tuples = append(tuples, allocTuple{Name: name, Task: task})
+		}
+		if err := s.computePlacements(tuples); err != nil {
+			return nil, err
+		}
+		nodes := make(map[string]string)
+		for nodeID, allocs := range plan.NodeAllocation {
+			for _, alloc := range allocs {
+				nodes[alloc.Task] = names[nodeID]
+			}
+		}
+		for task, metric := range s.failedTGAllocs {
+			nodes[task] = "failed: " + strings.Join(sortedKeys(metric.ConstraintFiltered), ",")
+		}
+		return nodes, nil
+	}
+
+	for i := 0; i < 20; i++ {
+		nodes, err := place(models.JobColocateTrue, "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[models.TaskTypeSrc] != nodes[models.TaskTypeDest] {
+			t.Fatalf("colocate true: tasks on %v", nodes)
+		}
+
+		nodes, err = place(models.JobColocateFalse, "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[models.TaskTypeSrc] == nodes[models.TaskTypeDest] {
+			t.Fatalf("colocate false: tasks on %v", nodes)
+		}
+		if nodes[models.TaskTypeDest] == "local" {
+			t.Fatalf("colocate false: Dest on the node of a loopback nats address")
+		}
+
+		// The Dest task running on n1.
+		nodes, err = place(models.JobColocateFalse, "n1", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src := nodes[models.TaskTypeSrc]; src == "n1" || src == "" {
+			t.Fatalf("colocate false with Dest on n1: Src on %q", src)
+		}
+
+		// The Src task follows the pinned Dest task.
+		nodes, err = place(models.JobColocateTrue, "", "", "n2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src := nodes[models.TaskTypeSrc]; src != "n2" {
+			t.Fatalf("colocate true with Dest pinned to n2: Src on %q", src)
+		}
+
+		// Whatever the colocate, the Src task can only reach a loopback
+		// nats address on its node.
+		nodes, err = place(models.JobColocateAny, "local", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src := nodes[models.TaskTypeSrc]; src != "local" {
+			t.Fatalf("Dest on a loopback nats address: Src on %q", src)
+		}
+	}
+
+	nodes, err := place(models.JobColocateFalse, "local", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nodes[models.TaskTypeSrc], "failed: colocate false,nats address 127.0.0.1:8193 of Dest is local"; got != want {
+		t.Errorf("colocate false with Dest on a loopback nats address: Src %q, want %q", got, want)
+	}
+
+	if _, err := place(models.JobColocateFalse, "", "n3", "n3"); err == nil {
+		t.Errorf("colocate false with the tasks pinned to the same node: no error")
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -9,6 +9,7 @@ package scheduler
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"reflect"
 
 	memdb "github.com/hashicorp/go-memdb"
@@ -169,7 +170,7 @@ func diffAllocs(job *models.Job, taintedNodes map[string]*models.Node,
 	return result
 }
 
-// readyNodesInDCs returns all the ready nodes in the given datacenters and a
+// readyNodesInDCs returns all the ready and eligible nodes in the given datacenters and a
 // mapping of each data center to the count of ready nodes.
 func readyNodesInDCs(state State, dcs []string) ([]*models.Node, map[string]int, error) {
 	// Index the DCs
@@ -196,6 +197,9 @@ func readyNodesInDCs(state State, dcs []string) ([]*models.Node, map[string]int,
 		if node.Status != models.NodeStatusReady {
 			continue
 		}
+		if !node.Eligible() {
+			continue
+		}
 
 		if _, ok := dcMap[node.Datacenter]; !ok {
 			continue
@@ -418,6 +422,35 @@ func taskConstraints(t *models.Task) taskConstrainTuple {
 	return c
 }
 
+// feasibleNodes returns the nodes with the drivers and the features required by the task.
+// The others are recorded as filtered in the metrics.
+func feasibleNodes(nodes []*models.Node, t *models.Task, metrics *models.AllocMetric) []*models.Node {
+	tc := taskConstraints(t)
+	var feasible []*models.Node
+	for _, node := range nodes {
+		if attr := node.MissingCapability(tc.constraints); attr != "" {
+			metrics.FilterNode(node, attr)
+			continue
+		}
+		feasible = append(feasible, node)
+	}
+	return feasible
+}
+
+// localNatsAddr returns whether the nats address is a loopback address, which
+// the tasks on the other nodes can not connect to.
+func localNatsAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // desiredUpdates takes the diffResult as well as the set of inplace and
 // destructive updates and returns a map of tasks to their set of desired
 // updates.
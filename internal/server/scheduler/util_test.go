@@ -7,10 +7,12 @@
 package scheduler
 
 import (
+	"os"
 	"reflect"
 	"testing"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
+	"github.com/actiontech/dtle/internal/server/store"
 )
 
 func Test_materializeTasks(t *testing.T) {
@@ -133,6 +135,23 @@ func Test_diffAllocs(t *testing.T) {
 }
 
 func Test_readyNodesInDCs(t *testing.T) {
+	state, err := store.NewStateStore(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := &models.Node{ID: "00000000-0000-0000-0000-000000000001", Datacenter: "dc1", Status: models.NodeStatusReady,
+		SchedulingEligibility: models.NodeSchedulingEligible}
+	// registered by an older client
+	readyNoEligibility := &models.Node{ID: "00000000-0000-0000-0000-000000000002", Datacenter: "dc1", Status: models.NodeStatusReady}
+	ineligible := &models.Node{ID: "00000000-0000-0000-0000-000000000003", Datacenter: "dc1", Status: models.NodeStatusReady,
+		SchedulingEligibility: models.NodeSchedulingIneligible}
+	down := &models.Node{ID: "00000000-0000-0000-0000-000000000004", Datacenter: "dc1", Status: models.NodeStatusDown}
+	for i, node := range []*models.Node{ready, readyNoEligibility, ineligible, down} {
+		if err := state.UpsertNode(uint64(100+i), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	type args struct {
 		state State
 		dcs   []string
@@ -144,7 +163,9 @@ func Test_readyNodesInDCs(t *testing.T) {
 		want1   map[string]int
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"ready-and-eligible", args{state, []string{"dc1"}},
+			[]*models.Node{ready, readyNoEligibility}, map[string]int{"dc1": 2}, false},
+		{"other-dc", args{state, []string{"dc2"}}, nil, map[string]int{"dc2": 0}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -260,7 +281,18 @@ func Test_tasksUpdated(t *testing.T) {
 		args args
 		want bool
 	}{
-		// TODO: Add test cases.
+		{"meta", args{
+			jobA: &models.Job{Meta: map[string]string{"team": "payments"},
+				Tasks: []*models.Task{{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL, Config: map[string]interface{}{"Gtid": ""}}}},
+			jobB: &models.Job{Meta: map[string]string{"team": "billing", "env": "prod"},
+				Tasks: []*models.Task{{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL, Config: map[string]interface{}{"Gtid": ""}}}},
+			task: models.TaskTypeSrc,
+		}, false},
+		{"config", args{
+			jobA: &models.Job{Tasks: []*models.Task{{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL, Config: map[string]interface{}{"Gtid": ""}}}},
+			jobB: &models.Job{Tasks: []*models.Task{{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL, Config: map[string]interface{}{"Gtid": "a:1"}}}},
+			task: models.TaskTypeSrc,
+		}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
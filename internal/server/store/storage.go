@@ -95,8 +95,9 @@ func (s *StateStore) Abandon() {
 
 // UpsertNode is used to register a node or update a node definition
 // This is assumed to be triggered by the client, so we retain the value
-// of drain which is set by the scheduler.
-func (s *StateStore) UpsertNode(index uint64, node *models.Node) error {
+// of drain which is set by the scheduler. The events of the node are
+// retained, followed by events.
+func (s *StateStore) UpsertNode(index uint64, node *models.Node, events ...*models.NodeEvent) error {
 	txn := s.db.Txn(true)
 	defer txn.Abort()
 
@@ -111,9 +112,11 @@ func (s *StateStore) UpsertNode(index uint64, node *models.Node) error {
 		exist := existing.(*models.Node)
 		node.CreateIndex = exist.CreateIndex
 		node.ModifyIndex = index
+		node.Events = models.AppendNodeEvents(exist.Events, events...)
 	} else {
 		node.CreateIndex = index
 		node.ModifyIndex = index
+		node.Events = models.AppendNodeEvents(nil, events...)
 	}
 
 	// Insert the node
@@ -190,8 +193,9 @@ func (s *StateStore) UpdateJobStatus(index uint64, jobID, status string) error {
 	return nil
 }
 
-// UpdateNodeStatus is used to update the status of a node
-func (s *StateStore) UpdateNodeStatus(index uint64, nodeID, status string) error {
+// UpdateNodeStatus is used to update the status of a node, appending events
+// to those of the node
+func (s *StateStore) UpdateNodeStatus(index uint64, nodeID, status string, events ...*models.NodeEvent) error {
 	txn := s.db.Txn(true)
 	defer txn.Abort()
 
@@ -212,6 +216,7 @@ func (s *StateStore) UpdateNodeStatus(index uint64, nodeID, status string) error
 	// Update the status in the copy
 	copyNode.Status = status
 	copyNode.ModifyIndex = index
+	copyNode.Events = models.AppendNodeEvents(existingNode.Events, events...)
 
 	// Insert the node
 	if err := txn.Insert("nodes", copyNode); err != nil {
@@ -1471,6 +1476,12 @@ func (s *StateStore) setJobStatus(index uint64, txn *memdb.Txn,
 }
 
 func (s *StateStore) getJobStatus(txn *memdb.Txn, job *models.Job, evalDelete bool) (string, error) {
+	// A periodic job runs no allocation itself, and is running as long as it
+	// is registered, launching its children.
+	if job.IsPeriodic() {
+		return models.JobStatusRunning, nil
+	}
+
 	allocs, err := txn.Get("allocs", "job", job.ID)
 	if err != nil {
 		return "", err
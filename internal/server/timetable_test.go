@@ -56,13 +56,13 @@ func TestTimeTable_Serialize(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t := &TimeTable{
+			tr := &TimeTable{
 				granularity: tt.fields.granularity,
 				limit:       tt.fields.limit,
 				table:       tt.fields.table,
 				l:           tt.fields.l,
 			}
-			if err := t.Serialize(tt.args.enc); (err != nil) != tt.wantErr {
+			if err := tr.Serialize(tt.args.enc); (err != nil) != tt.wantErr {
 				t.Errorf("TimeTable.Serialize() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -89,13 +89,13 @@ func TestTimeTable_Deserialize(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t := &TimeTable{
+			tr := &TimeTable{
 				granularity: tt.fields.granularity,
 				limit:       tt.fields.limit,
 				table:       tt.fields.table,
 				l:           tt.fields.l,
 			}
-			if err := t.Deserialize(tt.args.dec); (err != nil) != tt.wantErr {
+			if err := tr.Deserialize(tt.args.dec); (err != nil) != tt.wantErr {
 				t.Errorf("TimeTable.Deserialize() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -122,13 +122,13 @@ func TestTimeTable_Witness(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t := &TimeTable{
+			tr := &TimeTable{
 				granularity: tt.fields.granularity,
 				limit:       tt.fields.limit,
 				table:       tt.fields.table,
 				l:           tt.fields.l,
 			}
-			t.Witness(tt.args.index, tt.args.when)
+			tr.Witness(tt.args.index, tt.args.when)
 		})
 	}
 }
@@ -153,13 +153,13 @@ func TestTimeTable_NearestIndex(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t := &TimeTable{
+			tr := &TimeTable{
 				granularity: tt.fields.granularity,
 				limit:       tt.fields.limit,
 				table:       tt.fields.table,
 				l:           tt.fields.l,
 			}
-			if got := t.NearestIndex(tt.args.when); got != tt.want {
+			if got := tr.NearestIndex(tt.args.when); got != tt.want {
 				t.Errorf("TimeTable.NearestIndex() = %v, want %v", got, tt.want)
 			}
 		})
@@ -186,13 +186,13 @@ func TestTimeTable_NearestTime(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t := &TimeTable{
+			tr := &TimeTable{
 				granularity: tt.fields.granularity,
 				limit:       tt.fields.limit,
 				table:       tt.fields.table,
 				l:           tt.fields.l,
 			}
-			if got := t.NearestTime(tt.args.index); !reflect.DeepEqual(got, tt.want) {
+			if got := tr.NearestTime(tt.args.index); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("TimeTable.NearestTime() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+// Package socks5 connects through a SOCKS5 proxy (RFC 1928), with no
+// authentication or with a user and password (RFC 1929).
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds the connection to the proxy and the handshake.
+const DefaultTimeout = 5 * time.Second
+
+const (
+	version = 5
+
+	authNone         = 0
+	authUserPassword = 2
+	authNoAcceptable = 0xff
+
+	cmdConnect = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+var replyErrors = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// Dialer connects to the addresses through the proxy at Addr.
+type Dialer struct {
+	// "host:port" of the proxy
+	Addr     string
+	User     string
+	Password string
+	Timeout  time.Duration
+}
+
+// Parse parses a proxy of the form "[socks5://][user[:password]@]host:port".
+func Parse(proxy string) (*Dialer, error) {
+	s := strings.TrimPrefix(proxy, "socks5://")
+	d := &Dialer{Timeout: DefaultTimeout}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		userInfo := s[:i]
+		s = s[i+1:]
+		if j := strings.Index(userInfo, ":"); j >= 0 {
+			d.User, d.Password = userInfo[:j], userInfo[j+1:]
+		} else {
+			d.User = userInfo
+		}
+		if len(d.User) == 0 || len(d.User) > 255 || len(d.Password) > 255 {
+			return nil, fmt.Errorf("bad SOCKS5 proxy %q: the user must be of 1 to 255 bytes, and the password at most 255", Redact(proxy))
+		}
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return nil, fmt.Errorf("bad SOCKS5 proxy %q: %v", Redact(proxy), err)
+	}
+	d.Addr = s
+	return d, nil
+}
+
+// Redact masks the password of a proxy, for the errors and the configs shown to the users.
+func Redact(proxy string) string {
+	i := strings.LastIndex(proxy, "@")
+	j := strings.Index(proxy, "://")
+	start := 0
+	if j >= 0 {
+		start = j + 3
+	}
+	if i < 0 || !strings.Contains(proxy[start:i], ":") {
+		return proxy
+	}
+	k := start + strings.Index(proxy[start:i], ":")
+	return proxy[:k+1] + "*" + proxy[i:]
+}
+
+// Dial connects to addr, "host:port", through the proxy.
+func (d *Dialer) Dial(addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port of %v: %v", addr, err)
+	}
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	conn, err := net.DialTimeout("tcp", d.Addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SOCKS5 proxy %v: %v", d.Addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := d.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to %v through SOCKS5 proxy %v: %v", addr, d.Addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *Dialer) handshake(conn net.Conn, host string, port uint16) error {
+	method := byte(authNone)
+	if d.User != "" {
+		method = authUserPassword
+	}
+	if _, err := conn.Write([]byte{version, 1, method}); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != version {
+		return fmt.Errorf("unexpected SOCKS version %v", buf[0])
+	}
+	switch buf[1] {
+	case authNone:
+	case authUserPassword:
+		if d.User == "" {
+			return fmt.Errorf("the proxy requires a user and password")
+		}
+		req := []byte{1, byte(len(d.User))}
+		req = append(req, d.User...)
+		req = append(req, byte(len(d.Password)))
+		req = append(req, d.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return fmt.Errorf("authentication failed")
+		}
+	case authNoAcceptable:
+		return fmt.Errorf("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %v", buf[1])
+	}
+
+	req := []byte{version, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, atypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, atypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %v", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, then the bound address, which is not used
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		if msg, ok := replyErrors[reply[1]]; ok {
+			return fmt.Errorf("%v", msg)
+		}
+		return fmt.Errorf("unknown reply %v", reply[1])
+	}
+	var n int
+	switch reply[3] {
+	case atypIPv4:
+		n = net.IPv4len
+	case atypIPv6:
+		n = net.IPv6len
+	case atypDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return fmt.Errorf("unknown address type %v", reply[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// Forwarder listens on the loopback and forwards the connections to an
+// address through a proxy, for the clients which cannot be given a dialer.
+type Forwarder struct {
+	listener net.Listener
+	dialer   *Dialer
+	target   string
+
+	l      sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Forward forwards the connections to target, "host:port", through d.
+func Forward(d *Dialer, target string) (*Forwarder, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarder{
+		listener: listener,
+		dialer:   d,
+		target:   target,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go f.serve()
+	return f, nil
+}
+
+// Addr returns the local address to connect to instead of the target.
+func (f *Forwarder) Addr() (host string, port int) {
+	addr := f.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func (f *Forwarder) serve() {
+	for {
+		local, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.forward(local)
+	}
+}
+
+func (f *Forwarder) forward(local net.Conn) {
+	remote, err := f.dialer.Dial(f.target)
+	if err != nil {
+		local.Close()
+		return
+	}
+	if !f.track(local, remote) {
+		return
+	}
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(remote, local)
+	go pipe(local, remote)
+	// either side is done. close both to end the other
+	<-done
+	local.Close()
+	remote.Close()
+	<-done
+	f.l.Lock()
+	delete(f.conns, local)
+	delete(f.conns, remote)
+	f.l.Unlock()
+}
+
+// track keeps the connections to be closed with the forwarder. It closes them
+// if the forwarder is closed.
+func (f *Forwarder) track(conns ...net.Conn) bool {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if f.closed {
+		for _, c := range conns {
+			c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		f.conns[c] = struct{}{}
+	}
+	return true
+}
+
+// Close stops listening and closes the forwarded connections.
+func (f *Forwarder) Close() error {
+	f.l.Lock()
+	f.closed = true
+	for c := range f.conns {
+		c.Close()
+	}
+	f.l.Unlock()
+	return f.listener.Close()
+}